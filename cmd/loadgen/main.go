@@ -0,0 +1,89 @@
+// Package main runs a load test against a running Sentinel instance and
+// prints latency percentiles for each operation.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/loadgen"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:8080", "base URL of the instance under test")
+	rps := flag.Float64("rps", 50, "requests per second")
+	duration := flag.Duration("duration", 30*time.Second, "how long to send requests")
+	mixFlag := flag.String("mix", loadgen.DefaultMix, "comma-separated op=weight pairs; ops are register, login, refresh, and me")
+	sessions := flag.Int("sessions", loadgen.DefaultSessions, "accounts to register and log in before the test")
+	maxInFlight := flag.Int("max-in-flight", loadgen.DefaultMaxInFlight, "outstanding requests beyond which due requests are dropped")
+	jsonOut := flag.Bool("json", false, "print the report as JSON")
+	maxP99 := flag.Duration("max-p99", 0, "exit with status 1 if any op's p99 latency exceeds this")
+	maxErrorRate := flag.Float64("max-error-rate", 0.01, "exit with status 1 if any op's error rate exceeds this fraction")
+	flag.Parse()
+
+	mix, err := loadgen.ParseMix(*mixFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := loadgen.Run(ctx, loadgen.Options{
+		BaseURL:     *url,
+		RPS:         *rps,
+		Duration:    *duration,
+		Mix:         mix,
+		Sessions:    *sessions,
+		MaxInFlight: *maxInFlight,
+	})
+	if err != nil {
+		log.Fatalf("Load test failed: %v", err)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		fmt.Printf("%s for %s at %.0f rps (achieved %.1f rps, %d dropped)\n\n",
+			report.Mix, report.Duration.Round(time.Millisecond), report.TargetRPS, report.AchievedRPS, report.Dropped)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "op\trequests\terrors\tp50\tp90\tp99\tmax\t")
+		for _, s := range report.Ops {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t\n", s.Op, s.Requests, s.Errors,
+				round(s.P50), round(s.P90), round(s.P99), round(s.Max))
+		}
+		tw.Flush()
+	}
+
+	failed := false
+	for _, s := range report.Ops {
+		if *maxP99 > 0 && s.P99 > *maxP99 {
+			log.Printf("%s: p99 %s exceeds %s", s.Op, round(s.P99), *maxP99)
+			failed = true
+		}
+		if s.ErrorRate() > *maxErrorRate {
+			log.Printf("%s: error rate %.2f%% exceeds %.2f%%", s.Op, 100*s.ErrorRate(), 100**maxErrorRate)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// round shortens d for display.
+func round(d time.Duration) time.Duration {
+	if d >= time.Millisecond {
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
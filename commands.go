@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/config"
+	"github.com/mayvqt/Sentinel/internal/storage"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+// BackupCommandTimeout bounds CLI backup and restore operations.
+const BackupCommandTimeout = 10 * time.Minute
+
+// runCommand dispatches CLI subcommands (e.g. "sentinel backup create").
+// It reports whether args named a subcommand; when it returns false the
+// caller should start the server as usual.
+func runCommand(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "backup":
+		return runBackupCommand(args[1:]), true
+	case "doctor":
+		return runDoctorCommand(), true
+	case "templates":
+		return runTemplatesCommand(args[1:]), true
+	case "token":
+		return runTokenCommand(args[1:]), true
+	case "help", "-h", "--help":
+		printUsage()
+		return ExitCodeSuccess, true
+	}
+	return 0, false
+}
+
+// printUsage lists the available subcommands.
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command]\n\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "With no command, the HTTP server is started. With --dev it uses the in-memory")
+	fmt.Fprintln(os.Stderr, "store with seeded demo accounts, relaxed rate limits, and readable logs.")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  backup create <path>   Snapshot the live SQLite database to <path>, and upload it to BACKUP_UPLOAD_URL if set")
+	fmt.Fprintln(os.Stderr, "  backup restore <path>  Replace the database with the snapshot at <path> (stop the server first)")
+	fmt.Fprintln(os.Stderr, "  doctor                 Check configuration, database, TLS, SMTP, and clock; exits non-zero on failure")
+	fmt.Fprintln(os.Stderr, "  templates [dir]        List email template variables and validate overrides in [dir] (default MAIL_TEMPLATES_DIR)")
+	fmt.Fprintln(os.Stderr, "  token create --user ID [--role ROLE] [--ttl 15m]")
+	fmt.Fprintln(os.Stderr, "                         Sign an access token with the configured key and print it (at most 24h)")
+	fmt.Fprintln(os.Stderr, "  token inspect <jwt|->  Print a token's claims and whether it is accepted; exits non-zero if not")
+	fmt.Fprintln(os.Stderr, "  help                   Show this message")
+}
+
+// runBackupCommand implements "backup create" and "backup restore".
+func runBackupCommand(args []string) int {
+	if len(args) != 2 || (args[0] != "create" && args[0] != "restore") {
+		printUsage()
+		return ExitCodeConfigError
+	}
+	action, path := args[0], args[1]
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration load failed: %v\n", err)
+		return ExitCodeConfigError
+	}
+	if cfg.DatabaseURL == "" {
+		fmt.Fprintln(os.Stderr, "DATABASE_URL is required: the in-memory store cannot be backed up")
+		return ExitCodeConfigError
+	}
+
+	piiCipher, err := newPIICipher(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration load failed: %v\n", err)
+		return ExitCodeConfigError
+	}
+	bucket, err := buildBackupBucket(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration load failed: %v\n", err)
+		return ExitCodeConfigError
+	}
+	s, err := store.NewSQLiteWithOptions(cfg.DatabaseURL, store.SQLiteOptions{PII: piiCipher})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Store initialization failed: %v\n", err)
+		return ExitCodeStoreError
+	}
+	defer s.Close()
+
+	backupper, ok := s.(store.Backupper)
+	if !ok {
+		fmt.Fprintln(os.Stderr, store.ErrBackupUnsupported)
+		return ExitCodeStoreError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), BackupCommandTimeout)
+	defer cancel()
+
+	start := time.Now()
+	switch action {
+	case "create":
+		err = backupper.Backup(ctx, path)
+	case "restore":
+		err = backupper.Restore(ctx, path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Backup %s failed: %v\n", action, err)
+		return ExitCodeStoreError
+	}
+
+	switch action {
+	case "create":
+		fmt.Printf("Backup written to %s (%s)\n", path, time.Since(start).Round(time.Millisecond))
+		if bucket != nil {
+			key, err := bucket.PutFile(ctx, storage.KindBackups, start, path, "application/vnd.sqlite3")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Backup upload failed: %v\n", err)
+				return ExitCodeStoreError
+			}
+			fmt.Printf("Backup uploaded to %s as %s\n", bucket, key)
+		}
+	case "restore":
+		fmt.Printf("Database %s restored from %s (%s)\n", cfg.DatabaseURL, path, time.Since(start).Round(time.Millisecond))
+	}
+	return ExitCodeSuccess
+}
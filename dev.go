@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/config"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+// devPassword is the password of every account seeded by --dev.
+const devPassword = "Sentinel-dev-1"
+
+// devRateLimitFactor is how many times more requests --dev allows than
+// the normal rate limits.
+const devRateLimitFactor = 20
+
+// devAccounts are the accounts seeded by --dev, in ID order.
+var devAccounts = []models.User{
+	{Username: "admin", Email: "admin@example.com", Role: "admin"},
+	{Username: "alice", Email: "alice@example.com", Role: "user"},
+	{Username: "bob", Email: "bob@example.com", Role: "user"},
+	{Username: "carol", Email: "carol@example.com", Role: "moderator"},
+}
+
+// applyDevMode adjusts cfg for "sentinel --dev": the in-memory store on a
+// single instance, and a random JWT secret when none is set. It refuses to
+// run with ENVIRONMENT=production.
+func applyDevMode(cfg *config.Config) error {
+	if cfg.Environment == "production" {
+		return errors.New("--dev cannot be used with ENVIRONMENT=production")
+	}
+	if cfg.DatabaseURL != "" {
+		logger.Warn("DATABASE_URL is ignored with --dev; using the in-memory store")
+		cfg.DatabaseURL = ""
+		cfg.DatabaseReplicaURLs = nil
+	}
+	cfg.DeploymentProfile = "single"
+	cfg.RateLimitBackend = "memory"
+	if cfg.JWTSecret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		cfg.JWTSecret = base64.RawURLEncoding.EncodeToString(b)
+		logger.Info("Generated a random JWT secret; tokens will not survive a restart")
+	}
+	return nil
+}
+
+// seedDevData creates devAccounts in s, all with devPassword, and returns
+// them with their IDs.
+func seedDevData(ctx context.Context, s store.Store) ([]models.User, error) {
+	hash, err := auth.HashPassword(devPassword)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]models.User, len(devAccounts))
+	for i, u := range devAccounts {
+		u.Password = hash
+		id, err := s.CreateUser(ctx, &u)
+		if err != nil {
+			return nil, fmt.Errorf("seed user %s: %w", u.Username, err)
+		}
+		u.ID = id
+		users[i] = u
+	}
+	return users, nil
+}
+
+// printDevExamples lists the seeded accounts and curl commands to try
+// against the server on port.
+func printDevExamples(port string, users []models.User) {
+	base := "http://localhost:" + port
+	fmt.Println()
+	fmt.Println("Development mode: in-memory store, relaxed rate limits. Data is lost on exit.")
+	fmt.Println()
+	fmt.Printf("Seeded accounts (password %q):\n", devPassword)
+	for _, u := range users {
+		fmt.Printf("  %-6s %-18s role %-9s id %d\n", u.Username, u.Email, u.Role, u.ID)
+	}
+	fmt.Println()
+	fmt.Println("Try:")
+	fmt.Printf("  TOKEN=$(curl -s -X POST %s/api/auth/login -H 'Content-Type: application/json' \\\n", base)
+	fmt.Printf("    -d '{\"username\":\"admin\",\"password\":\"%s\"}' | jq -r .access_token)\n", devPassword)
+	fmt.Printf("  curl -s -H \"Authorization: Bearer $TOKEN\" %s/api/auth/profile\n", base)
+	fmt.Printf("  curl -s -H \"Authorization: Bearer $TOKEN\" '%s/api/admin/users/search?q=ali'\n", base)
+	fmt.Printf("  curl -s -X POST %s/api/auth/register -H 'Content-Type: application/json' \\\n", base)
+	fmt.Printf("    -d '{\"username\":\"dave\",\"email\":\"dave@example.com\",\"password\":\"%s\"}'\n", devPassword)
+	fmt.Println()
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/backchannel"
+	"github.com/mayvqt/Sentinel/internal/config"
+	"github.com/mayvqt/Sentinel/internal/doctor"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/mail"
+	"github.com/mayvqt/Sentinel/internal/metadata"
+	"github.com/mayvqt/Sentinel/internal/sms"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
+)
+
+// runDoctorCommand implements "sentinel doctor": it checks the deployment
+// without starting the server or modifying the database, prints a report,
+// and exits with ExitCodeCheckFailed when any check fails.
+func runDoctorCommand() int {
+	// Findings belong in the report, not interleaved as log lines.
+	logger.SetLevel(logger.LevelError)
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration load failed: %v\n", err)
+		return ExitCodeConfigError
+	}
+
+	results := doctor.Run(context.Background(), doctorChecks(cfg), doctor.DefaultCheckTimeout)
+	fmt.Printf("%s %s doctor\n\n", AppName, AppVersion)
+	if err := doctor.WriteReport(os.Stdout, results); err != nil {
+		return ExitCodeServerError
+	}
+	if !doctor.Passed(results) {
+		return ExitCodeCheckFailed
+	}
+	return ExitCodeSuccess
+}
+
+// doctorChecks returns the checks for cfg, in report order.
+func doctorChecks(cfg *config.Config) []doctor.Check {
+	return []doctor.Check{
+		{Name: "configuration", Run: func(context.Context) (doctor.Status, string) {
+			if err := checkConfiguration(cfg); err != nil {
+				return doctor.StatusFail, err.Error()
+			}
+			return doctor.StatusPass, "all settings are valid"
+		}},
+		doctor.SecretStrength(cfg.JWTSecret, cfg.JWTSecretMinBits, cfg.Environment == "production"),
+		{Name: "database", Run: func(ctx context.Context) (doctor.Status, string) {
+			if cfg.DatabaseURL == "" {
+				return doctor.StatusWarn, "DATABASE_URL not set; the in-memory store loses all data on restart"
+			}
+			version, err := store.InspectSQLite(ctx, cfg.DatabaseURL)
+			if err != nil {
+				return doctor.StatusFail, err.Error()
+			}
+			latest := store.SchemaVersion()
+			switch {
+			case version > latest:
+				return doctor.StatusFail, fmt.Sprintf("schema version %d is newer than this build supports (%d)", version, latest)
+			case version < latest:
+				return doctor.StatusWarn, fmt.Sprintf("schema version %d of %d; %d migrations run at next start", version, latest, latest-version)
+			}
+			return doctor.StatusPass, fmt.Sprintf("schema version %d (up to date)", version)
+		}},
+		tlsCheck(cfg),
+		{Name: "smtp", Run: func(ctx context.Context) (doctor.Status, string) {
+			if cfg.SMTPHost == "" {
+				return doctor.StatusSkip, "SMTP_HOST not set; email notifications are disabled"
+			}
+			mailer, err := mail.NewSMTP(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+			if err != nil {
+				return doctor.StatusFail, err.Error()
+			}
+			if err := mailer.Ping(ctx); err != nil {
+				return doctor.StatusFail, err.Error()
+			}
+			return doctor.StatusPass, fmt.Sprintf("%s:%d reachable", cfg.SMTPHost, cfg.SMTPPort)
+		}},
+		clockCheck(cfg),
+	}
+}
+
+// checkConfiguration applies the validation the server performs at startup
+// and reports every problem at once.
+func checkConfiguration(cfg *config.Config) error {
+	var errs []error
+	if err := validateConfiguration(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	spec := cfg.MetadataPolicies
+	if spec == "" {
+		spec = metadata.DefaultPolicySpec
+	}
+	if _, err := metadata.ParsePolicies(spec, metadata.Access(cfg.MetadataDefaultPolicy), cfg.MetadataMaxBytes); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := buildAdminRoles(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newPIICipher(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := buildAnalyticsSink(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := buildBackupBucket(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := buildAuditRetention(cfg, nil); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := buildUsernamePolicy(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := loadMailTemplates(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkSSOCookieDomain(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkDeployment(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := loadFeatureFlags(cfg, nil); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := logger.ParseFormat(cfg.LogFormat); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT: %w", err))
+	}
+	if _, err := backchannel.ParseClients(cfg.BackchannelLogoutClients); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := webhooks.ParseSecrets(cfg.WebhookSigningSecrets); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.MagicLinkEnabled && cfg.SMTPHost == "" {
+		errs = append(errs, errors.New("MAGIC_LINK_ENABLED requires SMTP_HOST"))
+	}
+	if cfg.SMSProvider != "" {
+		if _, err := sms.New(smsConfig(cfg)); err != nil {
+			errs = append(errs, err)
+		}
+	} else if cfg.PhoneLoginEnabled {
+		errs = append(errs, errors.New("PHONE_LOGIN_ENABLED requires SMS_PROVIDER"))
+	}
+	if cfg.TokenEncryptionKey != "" {
+		if _, err := auth.ParseEncryptionKey(cfg.TokenEncryptionKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cfg.TLSClientCAFile != "" && !cfg.TLSEnabled {
+		errs = append(errs, errors.New("TLS_CLIENT_CA_FILE requires TLS to be enabled"))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// tlsCheck validates the server certificate when TLS is enabled.
+func tlsCheck(cfg *config.Config) doctor.Check {
+	if !cfg.TLSEnabled {
+		return doctor.Check{Name: "tls certificate", Run: func(context.Context) (doctor.Status, string) {
+			return doctor.StatusSkip, "TLS disabled; terminate TLS at a reverse proxy"
+		}}
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return doctor.Check{Name: "tls certificate", Run: func(context.Context) (doctor.Status, string) {
+			return doctor.StatusFail, "TLS_ENABLED is set but TLS_CERT_FILE or TLS_KEY_FILE is missing"
+		}}
+	}
+	return doctor.Certificate(cfg.TLSCertFile, cfg.TLSKeyFile, time.Now)
+}
+
+// clockCheck compares the clock with NTP_SERVER, allowing the configured
+// token clock skew.
+func clockCheck(cfg *config.Config) doctor.Check {
+	if cfg.NTPServer == "" || strings.EqualFold(cfg.NTPServer, "off") {
+		return doctor.Check{Name: "clock sync", Run: func(context.Context) (doctor.Status, string) {
+			return doctor.StatusSkip, "NTP_SERVER is off"
+		}}
+	}
+	tolerance := cfg.TokenClockSkew
+	if tolerance <= 0 {
+		tolerance = time.Second
+	}
+	return doctor.Clock(cfg.NTPServer, tolerance)
+}
@@ -3,6 +3,7 @@ module github.com/mayvqt/Sentinel
 go 1.25.3
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/joho/godotenv v1.5.1
 	golang.org/x/crypto v0.43.0
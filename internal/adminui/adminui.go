@@ -0,0 +1,35 @@
+// Package adminui embeds a small single-page admin console (overview
+// stats, users and their sessions, and the audit log) so deployments get a
+// management frontend without building one. The page itself holds no
+// data: it signs in through /api/auth/login and every view is loaded from
+// the admin API, which requires an admin token.
+package adminui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// Prefix is the path the console is served under.
+const Prefix = "/admin/"
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the console's assets under Prefix.
+func Handler() http.Handler {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err) // the embedded tree is fixed at build time
+	}
+	files := http.StripPrefix(strings.TrimSuffix(Prefix, "/"), http.FileServerFS(assets))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Embedded files carry no modification time, so nothing can be
+		// revalidated; make browsers refetch the (small) assets after an
+		// upgrade instead of running a stale console.
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,42 @@
+package adminui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h := Handler()
+	for path, contentType := range map[string]string{
+		"/admin/":        "text/html",
+		"/admin/app.js":  "text/javascript",
+		"/admin/app.css": "text/css",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d", path, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, contentType) {
+			t.Errorf("%s: Content-Type %q, want %s", path, got, contentType)
+		}
+		if w.Header().Get("Cache-Control") != "no-cache" {
+			t.Errorf("%s: expected no-cache", path)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/", nil))
+	if !strings.Contains(w.Body.String(), `<script src="app.js"`) {
+		t.Error("expected the index page to load the console script")
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/missing.js", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown assets, got %d", w.Code)
+	}
+}
@@ -0,0 +1,280 @@
+// Sentinel admin console. Signs in through the public login endpoint and
+// drives the /api/admin routes with the resulting bearer token, which is
+// kept in sessionStorage so it does not outlive the browser tab.
+"use strict";
+
+const PAGE_SIZE = 25;
+const TOKEN_KEY = "sentinel.admin.token";
+
+const $ = (sel) => document.querySelector(sel);
+const state = { userQuery: "", userOffset: 0, auditFilter: {}, auditOffset: 0 };
+
+class APIError extends Error {
+  constructor(status, message) {
+    super(message);
+    this.status = status;
+  }
+}
+
+async function api(method, path, body) {
+  const headers = { Accept: "application/json" };
+  const token = sessionStorage.getItem(TOKEN_KEY);
+  if (token) headers.Authorization = "Bearer " + token;
+  if (body !== undefined) headers["Content-Type"] = "application/json";
+
+  const res = await fetch(path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const data = res.status === 204 ? null : await res.json().catch(() => null);
+  if (!res.ok) {
+    throw new APIError(res.status, (data && data.message) || res.statusText);
+  }
+  return data;
+}
+
+function showError(err) {
+  const el = $("#error");
+  el.textContent = err ? err.message : "";
+  el.hidden = !err;
+}
+
+function show(id) {
+  for (const section of document.querySelectorAll("main > section")) {
+    section.hidden = section.id !== id;
+  }
+  $("#nav").hidden = id === "login";
+}
+
+function cell(row, text) {
+  const td = document.createElement("td");
+  td.textContent = text == null ? "" : String(text);
+  row.appendChild(td);
+  return td;
+}
+
+function fmtTime(v) {
+  return v ? new Date(v).toLocaleString() : "";
+}
+
+function pager(el, offset, count, total) {
+  el.textContent = total === 0 ? "No results" : `${offset + 1}–${offset + count} of ${total}`;
+  const section = el.closest("section");
+  section.querySelector('[data-page="-1"]').disabled = offset === 0;
+  section.querySelector('[data-page="1"]').disabled = offset + count >= total;
+}
+
+// Views
+
+async function renderStats() {
+  show("stats");
+  const stats = await api("GET", "/api/admin/stats");
+  for (const dd of document.querySelectorAll("[data-stat]")) {
+    dd.textContent = stats[dd.dataset.stat];
+  }
+}
+
+async function renderUsers() {
+  show("users");
+  const rows = $("#user-rows");
+  rows.replaceChildren();
+  if (!state.userQuery) {
+    $("#user-page").textContent = "Search to list users";
+    return;
+  }
+  const params = new URLSearchParams({ q: state.userQuery, limit: PAGE_SIZE, offset: state.userOffset });
+  const data = await api("GET", "/api/admin/users/search?" + params);
+  for (const { user } of data.results) {
+    const tr = document.createElement("tr");
+    tr.dataset.href = "#/users/" + user.id;
+    cell(tr, user.id);
+    cell(tr, user.username);
+    cell(tr, user.email);
+    cell(tr, user.role);
+    cell(tr, user.disabled ? "disabled" : "active");
+    rows.appendChild(tr);
+  }
+  pager($("#user-page"), state.userOffset, data.results.length, data.total);
+}
+
+async function renderUser(id) {
+  show("user");
+  const [user, sessions] = await Promise.all([
+    api("GET", "/api/admin/users/" + id),
+    api("GET", `/api/admin/users/${id}/refresh-tokens`),
+  ]);
+
+  $("#user-title").textContent = user.username;
+  const fields = $("#user-fields");
+  fields.replaceChildren();
+  for (const [label, value] of [
+    ["ID", user.id],
+    ["Email", user.email],
+    ["Role", user.role],
+    ["Status", user.disabled ? "disabled" : "active"],
+    ["Created", fmtTime(user.created_at)],
+    ["Updated", fmtTime(user.updated_at)],
+    ["Metadata", JSON.stringify(user.metadata || {})],
+  ]) {
+    const dt = document.createElement("dt");
+    dt.textContent = label;
+    const dd = document.createElement("dd");
+    dd.textContent = value;
+    fields.append(dt, dd);
+  }
+
+  const disable = $("#user-disable");
+  disable.disabled = user.disabled;
+  disable.onclick = () => batch("users:batchDisable", { ids: [user.id] }, `Disable ${user.username}?`);
+  const role = user.role === "admin" ? "user" : "admin";
+  const roleButton = $("#user-role");
+  roleButton.textContent = role === "admin" ? "Make admin" : "Remove admin";
+  roleButton.onclick = () => batch("users:batchAssignRole", { ids: [user.id], role }, `Change ${user.username} to ${role}?`);
+  $("#user-delete").onclick = () =>
+    batch("users:batchDelete", { ids: [user.id] }, `Permanently delete ${user.username}?`, "#/users");
+
+  const rows = $("#session-rows");
+  rows.replaceChildren();
+  for (const t of sessions.refresh_tokens) {
+    const tr = document.createElement("tr");
+    cell(tr, t.jti).className = "mono";
+    cell(tr, fmtTime(t.issued_at));
+    cell(tr, fmtTime(t.expires_at));
+    const revoke = document.createElement("button");
+    revoke.type = "button";
+    revoke.textContent = "Revoke";
+    revoke.onclick = async () => {
+      if (!confirm("Revoke this session?")) return;
+      await run(() => api("POST", "/api/admin/tokens:revoke", { jti: t.jti, expires_at: t.expires_at }));
+      revoke.disabled = true;
+    };
+    cell(tr, "").appendChild(revoke);
+    rows.appendChild(tr);
+  }
+}
+
+async function batch(op, body, question, next) {
+  if (!confirm(question)) return;
+  await run(async () => {
+    const res = await api("POST", "/api/admin/" + op, body);
+    const failed = res.results.find((r) => r.status !== "ok");
+    if (failed) throw new Error(failed.error || failed.status);
+    if (next) location.hash = next;
+    else await route();
+  });
+}
+
+async function renderAudit() {
+  show("audit");
+  const params = new URLSearchParams({ ...state.auditFilter, limit: PAGE_SIZE, offset: state.auditOffset });
+  const data = await api("GET", "/api/admin/audit?" + params);
+  const rows = $("#audit-rows");
+  rows.replaceChildren();
+  for (const e of data.events) {
+    const tr = document.createElement("tr");
+    cell(tr, fmtTime(e.created_at));
+    cell(tr, e.action);
+    cell(tr, e.actor_id);
+    cell(tr, `${e.target_type} ${e.target_id}`);
+    cell(tr, e.ip);
+    cell(tr, (e.changes || []).map((c) => `${c.field}: ${JSON.stringify(c.before)} → ${JSON.stringify(c.after)}`).join("; "));
+    rows.appendChild(tr);
+  }
+  pager($("#audit-page"), state.auditOffset, data.events.length, data.total);
+}
+
+// Routing
+
+async function run(fn) {
+  showError(null);
+  try {
+    await fn();
+  } catch (err) {
+    if (err.status === 401) {
+      sessionStorage.removeItem(TOKEN_KEY);
+      show("login");
+    }
+    showError(err);
+  }
+}
+
+function route() {
+  if (!sessionStorage.getItem(TOKEN_KEY)) {
+    show("login");
+    return Promise.resolve();
+  }
+  const hash = location.hash.replace(/^#\/?/, "");
+  const [view, id] = hash.split("/");
+  return run(() => {
+    switch (view) {
+      case "users":
+        return id ? renderUser(id) : renderUsers();
+      case "audit":
+        return renderAudit();
+      default:
+        return renderStats();
+    }
+  });
+}
+
+$("#login-form").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  const form = new FormData(ev.target);
+  run(async () => {
+    const res = await api("POST", "/api/auth/login", {
+      username: form.get("username"),
+      password: form.get("password"),
+    });
+    if (!res.user || res.user.role !== "admin") {
+      throw new Error("This account is not an administrator.");
+    }
+    sessionStorage.setItem(TOKEN_KEY, res.access_token);
+    ev.target.reset();
+    await route();
+  });
+});
+
+$("#logout").addEventListener("click", async () => {
+  await api("POST", "/api/auth/logout").catch(() => {});
+  sessionStorage.removeItem(TOKEN_KEY);
+  show("login");
+});
+
+$("#search-form").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  state.userQuery = new FormData(ev.target).get("q").trim();
+  state.userOffset = 0;
+  run(renderUsers);
+});
+
+$("#audit-form").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  state.auditFilter = {};
+  for (const [k, v] of new FormData(ev.target)) {
+    if (v.trim()) state.auditFilter[k] = v.trim();
+  }
+  state.auditOffset = 0;
+  run(renderAudit);
+});
+
+for (const button of document.querySelectorAll("[data-page]")) {
+  button.addEventListener("click", () => {
+    const step = Number(button.dataset.page) * PAGE_SIZE;
+    if (button.closest("section").id === "users") {
+      state.userOffset = Math.max(0, state.userOffset + step);
+      run(renderUsers);
+    } else {
+      state.auditOffset = Math.max(0, state.auditOffset + step);
+      run(renderAudit);
+    }
+  });
+}
+
+$("#user-rows").addEventListener("click", (ev) => {
+  const tr = ev.target.closest("tr[data-href]");
+  if (tr) location.hash = tr.dataset.href;
+});
+
+window.addEventListener("hashchange", route);
+route();
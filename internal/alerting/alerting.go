@@ -0,0 +1,253 @@
+// Package alerting evaluates threshold rules against in-process metrics and
+// notifies external services (webhooks, Slack, PagerDuty) when they fire.
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// Evaluation defaults.
+const (
+	DefaultInterval = 15 * time.Second
+	DefaultCooldown = 15 * time.Minute
+	// NotifyTimeout bounds each notification attempt.
+	NotifyTimeout = 10 * time.Second
+)
+
+var alertsFired = metrics.NewCounterVec(
+	"sentinel_alerts_fired_total",
+	"Alerts fired, by rule.",
+	"rule",
+)
+
+// Rule fires when a counter increases by at least Threshold within Window.
+type Rule struct {
+	Name        string
+	Description string
+	// Metric and Labels select the counter series to sum (see metrics.Registry.Sum).
+	Metric    string
+	Labels    map[string]string
+	Threshold float64
+	Window    time.Duration
+}
+
+// Alert describes a fired rule; it is the payload sent to notifiers.
+// Alerts raised directly with Manager.Fire have no threshold or window.
+type Alert struct {
+	Rule        string    `json:"rule"`
+	Description string    `json:"description"`
+	Value       float64   `json:"value"`
+	Threshold   float64   `json:"threshold"`
+	Window      string    `json:"window"`
+	FiredAt     time.Time `json:"fired_at"`
+	// Details carries context about the event behind the alert, such as
+	// the client IP of the request that triggered it.
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Summary is a one-line human-readable description of the alert.
+func (a Alert) Summary() string {
+	if a.Window == "" {
+		return fmt.Sprintf("Sentinel alert %s: %s", a.Rule, a.Description)
+	}
+	return fmt.Sprintf("Sentinel alert %s: %s — %.0f in the last %s (threshold %.0f)",
+		a.Rule, a.Description, a.Value, a.Window, a.Threshold)
+}
+
+// Notifier delivers alerts to an external service.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, a Alert) error
+}
+
+// sample is a metric reading at a point in time.
+type sample struct {
+	at    time.Time
+	value float64
+}
+
+// Manager periodically evaluates rules and notifies on threshold breaches,
+// suppressing repeat notifications for a rule until its cooldown elapses.
+type Manager struct {
+	registry  *metrics.Registry
+	rules     []Rule
+	notifiers []Notifier
+	interval  time.Duration
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	samples   map[string][]sample
+	lastFired map[string]time.Time
+}
+
+// NewManager returns a Manager reading from registry (metrics.Default when
+// nil). Zero interval or cooldown use the package defaults.
+func NewManager(registry *metrics.Registry, rules []Rule, notifiers []Notifier, interval, cooldown time.Duration) *Manager {
+	if registry == nil {
+		registry = metrics.Default
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultCooldown
+	}
+	return &Manager{
+		registry:  registry,
+		rules:     rules,
+		notifiers: notifiers,
+		interval:  interval,
+		cooldown:  cooldown,
+		now:       time.Now,
+		samples:   make(map[string][]sample),
+		lastFired: make(map[string]time.Time),
+	}
+}
+
+// Run evaluates rules every interval until ctx is canceled.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	m.Evaluate(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Evaluate(ctx)
+		}
+	}
+}
+
+// Evaluate takes one reading of every rule's metric, notifies for rules
+// whose increase over their window meets the threshold, and returns the
+// alerts fired.
+func (m *Manager) Evaluate(ctx context.Context) []Alert {
+	now := m.now()
+	var fired []Alert
+
+	m.mu.Lock()
+	for _, rule := range m.rules {
+		value := m.registry.Sum(rule.Metric, rule.Labels)
+		increase := m.record(rule, now, value)
+		if increase < rule.Threshold {
+			continue
+		}
+		if last, ok := m.lastFired[rule.Name]; ok && now.Sub(last) < m.cooldown {
+			continue
+		}
+		m.lastFired[rule.Name] = now
+		fired = append(fired, Alert{
+			Rule:        rule.Name,
+			Description: rule.Description,
+			Value:       increase,
+			Threshold:   rule.Threshold,
+			Window:      rule.Window.String(),
+			FiredAt:     now.UTC(),
+		})
+	}
+	m.mu.Unlock()
+
+	for _, a := range fired {
+		alertsFired.WithLabelValues(a.Rule).Inc()
+		logger.Warn("Alert fired", map[string]interface{}{
+			"rule":      a.Rule,
+			"value":     a.Value,
+			"threshold": a.Threshold,
+			"window":    a.Window,
+		})
+		m.notify(ctx, a)
+	}
+	return fired
+}
+
+// Fire notifies a immediately, for events that warrant an alert on their
+// own rather than by rate. It is not subject to the cooldown.
+func (m *Manager) Fire(ctx context.Context, a Alert) {
+	if a.FiredAt.IsZero() {
+		a.FiredAt = m.now().UTC()
+	}
+	alertsFired.WithLabelValues(a.Rule).Inc()
+	logger.Warn("Alert fired", map[string]interface{}{
+		"rule":        a.Rule,
+		"description": a.Description,
+	})
+	m.notify(ctx, a)
+}
+
+// record appends a reading for rule and returns the increase since the
+// start of its window. Counter resets (restarts) count from zero.
+func (m *Manager) record(rule Rule, now time.Time, value float64) float64 {
+	samples := append(m.samples[rule.Name], sample{at: now, value: value})
+	// Keep one sample at or before the window start as the baseline.
+	cutoff := now.Add(-rule.Window)
+	for len(samples) > 1 && !samples[1].at.After(cutoff) {
+		samples = samples[1:]
+	}
+	m.samples[rule.Name] = samples
+
+	increase := value - samples[0].value
+	if increase < 0 {
+		increase = value
+	}
+	return increase
+}
+
+// notify sends a to every notifier, logging failures.
+func (m *Manager) notify(ctx context.Context, a Alert) {
+	for _, n := range m.notifiers {
+		nctx, cancel := context.WithTimeout(ctx, NotifyTimeout)
+		err := n.Notify(nctx, a)
+		cancel()
+		if err != nil {
+			logger.Error("Alert notification failed", map[string]interface{}{
+				"rule":     a.Rule,
+				"notifier": n.Name(),
+				"error":    err.Error(),
+			})
+		}
+	}
+}
+
+// StandardRules returns Sentinel's built-in per-minute rules for failed
+// logins, 5xx responses, and registrations. A zero threshold disables a rule.
+func StandardRules(failedLogins, serverErrors, registrations float64) []Rule {
+	candidates := []Rule{
+		{
+			Name:        "failed_logins",
+			Description: "failed login attempts (possible credential stuffing)",
+			Metric:      "sentinel_login_attempts_total",
+			Labels:      map[string]string{"result": "failure"},
+			Threshold:   failedLogins,
+		},
+		{
+			Name:        "server_errors",
+			Description: "HTTP 5xx responses",
+			Metric:      "sentinel_http_responses_total",
+			Labels:      map[string]string{"code": "5xx"},
+			Threshold:   serverErrors,
+		},
+		{
+			Name:        "registration_spike",
+			Description: "new user registrations (possible bot signups)",
+			Metric:      "sentinel_registrations_total",
+			Threshold:   registrations,
+		},
+	}
+
+	var rules []Rule
+	for _, r := range candidates {
+		if r.Threshold > 0 {
+			r.Window = time.Minute
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
@@ -0,0 +1,107 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+func TestManagerThresholdAndCooldown(t *testing.T) {
+	reg := metrics.NewRegistry()
+	logins := reg.NewCounterVec("sentinel_login_attempts_total", "Login attempts.", "result")
+
+	var mu sync.Mutex
+	var received []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		received = append(received, body)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	rules := StandardRules(10, 0, 0)
+	if len(rules) != 1 {
+		t.Fatalf("expected zero thresholds to disable rules, got %d rules", len(rules))
+	}
+	m := NewManager(reg, rules, []Notifier{&Slack{WebhookURL: srv.URL}, &PagerDuty{RoutingKey: "key", URL: srv.URL}}, time.Second, 5*time.Minute)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Baseline reading
+	m.Evaluate(ctx)
+
+	// Below threshold within the window
+	logins.WithLabelValues("failure").Add(5)
+	logins.WithLabelValues("success").Add(100)
+	now = now.Add(30 * time.Second)
+	if fired := m.Evaluate(ctx); len(fired) != 0 {
+		t.Fatalf("expected no alert below threshold, got %+v", fired)
+	}
+
+	// Crossing the threshold fires once and notifies every target
+	logins.WithLabelValues("failure").Add(6)
+	now = now.Add(20 * time.Second)
+	fired := m.Evaluate(ctx)
+	if len(fired) != 1 || fired[0].Rule != "failed_logins" || fired[0].Value != 11 {
+		t.Fatalf("expected failed_logins alert with value 11, got %+v", fired)
+	}
+	mu.Lock()
+	if len(received) != 2 {
+		t.Fatalf("expected 2 notifications, got %d", len(received))
+	}
+	if received[1]["dedup_key"] != "sentinel-failed_logins" {
+		t.Errorf("unexpected PagerDuty payload: %v", received[1])
+	}
+	mu.Unlock()
+
+	// Still breaching, but within the cooldown
+	logins.WithLabelValues("failure").Add(20)
+	now = now.Add(time.Minute)
+	if fired := m.Evaluate(ctx); len(fired) != 0 {
+		t.Fatalf("expected cooldown to suppress alert, got %+v", fired)
+	}
+
+	// After the cooldown a sustained breach fires again
+	logins.WithLabelValues("failure").Add(20)
+	now = now.Add(5 * time.Minute)
+	if fired := m.Evaluate(ctx); len(fired) != 1 {
+		t.Fatalf("expected alert after cooldown, got %+v", fired)
+	}
+}
+
+func TestManagerFire(t *testing.T) {
+	var received []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		received = append(received, body)
+	}))
+	defer srv.Close()
+
+	m := NewManager(metrics.NewRegistry(), nil, []Notifier{&Webhook{URL: srv.URL}, &Slack{WebhookURL: srv.URL}}, 0, 0)
+	a := Alert{Rule: "canary_tripped", Description: "canary account 3 used", Details: map[string]string{"ip": "203.0.113.7"}}
+	m.Fire(context.Background(), a)
+	m.Fire(context.Background(), a)
+
+	if len(received) != 4 {
+		t.Fatalf("expected every fired alert to bypass the cooldown, got %d notifications", len(received))
+	}
+	details, _ := received[0]["details"].(map[string]interface{})
+	if received[0]["fired_at"] == nil || details["ip"] != "203.0.113.7" {
+		t.Errorf("unexpected webhook payload: %v", received[0])
+	}
+	if want := ":rotating_light: Sentinel alert canary_tripped: canary account 3 used\n• ip: 203.0.113.7"; received[1]["text"] != want {
+		t.Errorf("Slack text = %q, want %q", received[1]["text"], want)
+	}
+}
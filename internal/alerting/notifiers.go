@@ -0,0 +1,120 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/mayvqt/Sentinel/internal/httpclient"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
+)
+
+// PagerDutyEventsURL is the PagerDuty Events API v2 endpoint.
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// httpClient is created on first use so it picks up the httpclient defaults
+// configured at startup. Notifications may be retried: receivers either
+// deduplicate them (PagerDuty) or tolerate duplicates.
+var httpClient = sync.OnceValue(func() *http.Client {
+	return httpclient.New("alerting", httpclient.Options{Timeout: NotifyTimeout, RetryNonIdempotent: true})
+})
+
+// WebhookEvent is the event type of alerts sent through a webhook
+// dispatcher.
+const WebhookEvent = "alert"
+
+// Webhook posts the alert as JSON to an arbitrary URL. With a Dispatcher,
+// the alert is sent through it to the endpoint ID instead, so it is signed
+// and recorded in the delivery log.
+type Webhook struct {
+	URL        string
+	ID         string
+	Dispatcher *webhooks.Dispatcher
+}
+
+func (w *Webhook) Name() string { return "webhook" }
+
+func (w *Webhook) Notify(ctx context.Context, a Alert) error {
+	if w.Dispatcher != nil {
+		_, err := w.Dispatcher.Deliver(ctx, w.ID, WebhookEvent, a)
+		return err
+	}
+	return postJSON(ctx, w.URL, a)
+}
+
+// Slack posts the alert to a Slack incoming webhook.
+type Slack struct{ WebhookURL string }
+
+func (s *Slack) Name() string { return "slack" }
+
+func (s *Slack) Notify(ctx context.Context, a Alert) error {
+	text := ":rotating_light: " + a.Summary()
+	for _, k := range slices.Sorted(maps.Keys(a.Details)) {
+		text += fmt.Sprintf("\n• %s: %s", k, a.Details[k])
+	}
+	return postJSON(ctx, s.WebhookURL, map[string]string{"text": text})
+}
+
+// PagerDuty triggers an incident through the Events API v2. Alerts for the
+// same rule share a dedup key so PagerDuty groups them into one incident.
+type PagerDuty struct {
+	RoutingKey string
+	// URL overrides PagerDutyEventsURL (for tests or EU endpoints).
+	URL string
+}
+
+func (p *PagerDuty) Name() string { return "pagerduty" }
+
+func (p *PagerDuty) Notify(ctx context.Context, a Alert) error {
+	url := p.URL
+	if url == "" {
+		url = PagerDutyEventsURL
+	}
+	source, _ := os.Hostname()
+	if source == "" {
+		source = "sentinel"
+	}
+	return postJSON(ctx, url, map[string]interface{}{
+		"routing_key":  p.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    "sentinel-" + a.Rule,
+		"payload": map[string]interface{}{
+			"summary":        a.Summary(),
+			"source":         source,
+			"severity":       "critical",
+			"component":      "sentinel",
+			"custom_details": a,
+		},
+	})
+}
+
+// postJSON sends body to url and treats any non-2xx status as an error.
+func postJSON(ctx context.Context, url string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
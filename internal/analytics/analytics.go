@@ -0,0 +1,87 @@
+// Package analytics builds daily usage aggregates for product analytics and
+// exports them to a file, an object store, or an HTTP endpoint, so that
+// analytics needs no access to the database.
+//
+// Reports hold counts only: no user IDs, usernames, email addresses, IP
+// addresses, or anything else that identifies a person.
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+// Audit actions the reports aggregate, as recorded by the handlers.
+const (
+	LoginAction       = "user.login"
+	LoginFailedAction = "user.login.failed"
+)
+
+// DateFormat is the layout of Report.Date.
+const DateFormat = time.DateOnly
+
+// Report aggregates one UTC day.
+type Report struct {
+	Date string `json:"date"`
+	// Signups counts accounts created that day, guests included, that
+	// still exist.
+	Signups int `json:"signups"`
+	// ActiveUsers counts the distinct users who logged in that day.
+	ActiveUsers  int `json:"active_users"`
+	Logins       int `json:"logins"`
+	FailedLogins int `json:"failed_logins"`
+	// LoginFailureRate is the share of login attempts that failed, or 0
+	// without attempts.
+	LoginFailureRate float64 `json:"login_failure_rate"`
+	// TotalUsers counts every account at the time of the export.
+	TotalUsers  int       `json:"total_users"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// Source is the subset of store.Store reports are built from.
+type Source interface {
+	CountUsers(ctx context.Context, filter store.UserFilter) (int, error)
+	ListAuditEvents(ctx context.Context, f store.AuditFilter) ([]models.AuditEvent, int, error)
+	CountAuditTargets(ctx context.Context, f store.AuditFilter) (int, error)
+}
+
+// Day returns the start of the UTC day containing t.
+func Day(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Build aggregates the UTC day starting at day.
+func Build(ctx context.Context, src Source, day time.Time) (*Report, error) {
+	day = Day(day)
+	next := day.AddDate(0, 0, 1)
+	r := &Report{Date: day.Format(DateFormat), GeneratedAt: time.Now().UTC()}
+
+	var err error
+	if r.Signups, err = src.CountUsers(ctx, store.UserFilter{CreatedAfter: day, CreatedBefore: next}); err != nil {
+		return nil, fmt.Errorf("failed to count signups: %w", err)
+	}
+	if r.TotalUsers, err = src.CountUsers(ctx, store.UserFilter{}); err != nil {
+		return nil, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	logins := store.AuditFilter{Action: LoginAction, Since: day, Until: next, Limit: 1}
+	if _, r.Logins, err = src.ListAuditEvents(ctx, logins); err != nil {
+		return nil, fmt.Errorf("failed to count logins: %w", err)
+	}
+	if r.ActiveUsers, err = src.CountAuditTargets(ctx, logins); err != nil {
+		return nil, fmt.Errorf("failed to count active users: %w", err)
+	}
+	failed := store.AuditFilter{Action: LoginFailedAction, Since: day, Until: next, Limit: 1}
+	if _, r.FailedLogins, err = src.ListAuditEvents(ctx, failed); err != nil {
+		return nil, fmt.Errorf("failed to count failed logins: %w", err)
+	}
+	if attempts := r.Logins + r.FailedLogins; attempts > 0 {
+		r.LoginFailureRate = float64(r.FailedLogins) / float64(attempts)
+	}
+	return r, nil
+}
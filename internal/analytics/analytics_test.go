@@ -0,0 +1,101 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/storage"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemStore()
+	day := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, created := range []time.Time{day.Add(-time.Hour), day.Add(time.Hour), day.Add(5 * time.Hour)} {
+		u := &models.User{Username: "user" + string(rune('a'+i)), Password: "h", CreatedAt: created}
+		if _, err := s.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	for _, e := range []*models.AuditEvent{
+		{ActorID: 1, Action: LoginAction, TargetType: "user", TargetID: 1, CreatedAt: day.Add(time.Hour), IP: "192.0.2.1"},
+		{ActorID: 1, Action: LoginAction, TargetType: "user", TargetID: 1, CreatedAt: day.Add(2 * time.Hour)},
+		{ActorID: 2, Action: LoginAction, TargetType: "user", TargetID: 2, CreatedAt: day.Add(3 * time.Hour)},
+		{ActorID: 2, Action: LoginFailedAction, TargetType: "user", TargetID: 2, CreatedAt: day.Add(3 * time.Hour)},
+		{ActorID: 3, Action: LoginAction, TargetType: "user", TargetID: 3, CreatedAt: day.Add(-time.Minute)},
+	} {
+		if err := s.RecordAudit(ctx, e); err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	r, err := Export(ctx, s, &File{Dir: dir}, day.Add(12*time.Hour))
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if r.Date != "2025-06-01" || r.Signups != 2 || r.TotalUsers != 3 || r.ActiveUsers != 2 ||
+		r.Logins != 3 || r.FailedLogins != 1 || r.LoginFailureRate != 0.25 {
+		t.Errorf("unexpected report %+v", r)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "analytics-2025-06-01.json"))
+	if err != nil {
+		t.Fatalf("report file: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		t.Fatalf("report JSON: %v", err)
+	}
+	if _, ok := fields["signups"]; !ok || len(fields) != 8 {
+		t.Errorf("unexpected report fields %v", fields)
+	}
+
+	var got Report
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(req.Body).Decode(&got)
+	}))
+	defer srv.Close()
+	sink, err := ParseSink(srv.URL+"/ingest", "secret", storage.S3Options{})
+	if err != nil {
+		t.Fatalf("ParseSink: %v", err)
+	}
+	if _, err := Export(ctx, s, sink, day); err != nil || got.Logins != 3 {
+		t.Fatalf("HTTP export = %+v, %v", got, err)
+	}
+}
+
+func TestParseSink(t *testing.T) {
+	s3 := storage.S3Options{Endpoint: "http://minio:9000", AccessKeyID: "id", SecretAccessKey: "secret"}
+	for raw, name := range map[string]string{
+		"file:///var/lib/sentinel/analytics": "file",
+		"s3://analytics/sentinel/daily":      "s3",
+		"https://collector.example.com/in":   "http",
+	} {
+		sink, err := ParseSink(raw, "", s3)
+		if err != nil || sink.Name() != name {
+			t.Errorf("ParseSink(%q) = %v, %v; want %s", raw, sink, err, name)
+		}
+	}
+	for _, raw := range []string{"ftp://example.com", "file://", "s3:///prefix", "analytics"} {
+		if _, err := ParseSink(raw, "", s3); err == nil {
+			t.Errorf("ParseSink(%q): expected an error", raw)
+		}
+	}
+	if _, err := ParseSink("s3://bucket", "", storage.S3Options{}); err == nil {
+		t.Error("expected an S3 sink without credentials to be rejected")
+	}
+}
@@ -0,0 +1,170 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/httpclient"
+	"github.com/mayvqt/Sentinel/internal/storage"
+)
+
+// ExportTimeout bounds writing one report to a sink.
+const ExportTimeout = 30 * time.Second
+
+// Sink receives reports. Reports are named after their date, and the same
+// day may be exported more than once (after a restart, for instance), so
+// sinks overwrite or receivers deduplicate by date.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, r *Report) error
+}
+
+// ParseSink returns the sink rawURL names:
+//
+//	file:///var/lib/sentinel/analytics  one JSON file per day in a directory
+//	s3://bucket/prefix                  one object per day (see storage.Bucket), using s3 for the endpoint and credentials
+//	https://collector.example.com/in    one POST per day, with token as a bearer token if set
+func ParseSink(rawURL, token string, s3 storage.S3Options) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid analytics export URL %q", rawURL)
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("analytics export URL %q has no directory", rawURL)
+		}
+		return &File{Dir: u.Path}, nil
+	case "s3":
+		bucket, err := storage.OpenBucket(rawURL, s3)
+		if err != nil {
+			return nil, fmt.Errorf("analytics export: %w", err)
+		}
+		return &Object{Bucket: bucket}, nil
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid analytics export URL %q", rawURL)
+		}
+		return &HTTP{URL: rawURL, Token: token}, nil
+	default:
+		return nil, fmt.Errorf("analytics export URL %q must use file, s3, http, or https", rawURL)
+	}
+}
+
+// fileName returns the name r is stored under.
+func fileName(r *Report) string {
+	return "analytics-" + r.Date + ".json"
+}
+
+// File writes each report to a JSON file in Dir, replacing it atomically.
+type File struct{ Dir string }
+
+func (f *File) Name() string { return "file" }
+
+func (f *File) Write(ctx context.Context, r *Report) error {
+	payload, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.Dir, ".analytics-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(payload, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(f.Dir, fileName(r)))
+}
+
+// Object writes each report to a bucket as an analytics object dated by
+// the report's day.
+type Object struct {
+	Bucket *storage.Bucket
+}
+
+func (o *Object) Name() string { return "s3" }
+
+func (o *Object) Write(ctx context.Context, r *Report) error {
+	day, err := time.Parse(DateFormat, r.Date)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = o.Bucket.Put(ctx, storage.KindAnalytics, day, fileName(r), bytes.NewReader(payload), int64(len(payload)), "application/json")
+	return err
+}
+
+// httpClient is created on first use so it picks up the httpclient
+// defaults configured at startup. Receivers deduplicate reports by date,
+// so posts may be retried.
+var httpClient = sync.OnceValue(func() *http.Client {
+	return httpclient.New("analytics", httpclient.Options{Timeout: ExportTimeout, RetryNonIdempotent: true})
+})
+
+// HTTP posts each report as JSON to URL.
+type HTTP struct {
+	URL   string
+	Token string
+}
+
+func (h *HTTP) Name() string { return "http" }
+
+func (h *HTTP) Write(ctx context.Context, r *Report) error {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Export builds the report for day and writes it to sink.
+func Export(ctx context.Context, src Source, sink Sink, day time.Time) (*Report, error) {
+	r, err := Build(ctx, src, day)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, ExportTimeout)
+	defer cancel()
+	if err := sink.Write(ctx, r); err != nil {
+		return nil, fmt.Errorf("%s sink: %w", sink.Name(), err)
+	}
+	return r, nil
+}
@@ -0,0 +1,69 @@
+package audit
+
+import "strings"
+
+// maxUserAgentLen bounds the User-Agent stored with an audit event.
+const maxUserAgentLen = 256
+
+// UserAgent trims ua to the length stored in the audit log.
+func UserAgent(ua string) string {
+	ua = strings.TrimSpace(ua)
+	if len(ua) > maxUserAgentLen {
+		ua = strings.ToValidUTF8(ua[:maxUserAgentLen], "")
+	}
+	return ua
+}
+
+// Tokens are matched in order, so more specific names come first (Edge and
+// Opera also claim to be Chrome, and Chrome claims to be Safari).
+var (
+	browsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"CriOS/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"Go-http-client/", "Go HTTP client"},
+		{"okhttp/", "OkHttp"},
+		{"python-requests/", "Python requests"},
+	}
+	systems = []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// Device summarizes a User-Agent as "Browser on OS" (for example "Firefox
+// on macOS") for display in login history. Unrecognized agents are
+// "Unknown device".
+func Device(ua string) string {
+	var browser, system string
+	for _, b := range browsers {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range systems {
+		if strings.Contains(ua, s.token) {
+			system = s.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return "Unknown browser on " + system
+	}
+	return "Unknown device"
+}
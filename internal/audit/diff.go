@@ -0,0 +1,153 @@
+// Package audit computes the before/after diffs and request details
+// recorded in the audit log.
+package audit
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/scrub"
+)
+
+// Masked replaces the value of sensitive fields in a diff. The entry still
+// records that the field changed.
+const Masked = "[MASKED]"
+
+// sensitiveKeyParts mark metadata keys whose values are never recorded.
+var sensitiveKeyParts = []string{"password", "secret", "token", "key", "credential", "ssn"}
+
+// DiffUser returns the changes from before to after. Either may be nil to
+// describe a creation or deletion. The password hash is always masked, as
+// are the email and phone, which the audit log, its archives, and backups
+// would otherwise hold in the clear however the users table is stored,
+// and metadata keys that look sensitive; other string values are scrubbed
+// of credentials. Version and timestamps are bookkeeping and not diffed,
+// except the account expiry, which is policy.
+func DiffUser(before, after *models.User) []models.FieldChange {
+	var b, a models.User
+	if before != nil {
+		b = *before
+	}
+	if after != nil {
+		a = *after
+	}
+	present := func(u *models.User, v interface{}) interface{} {
+		if u == nil {
+			return nil
+		}
+		return v
+	}
+
+	changes := []models.FieldChange{}
+	add := func(field string, bv, av interface{}, sensitive bool) {
+		bv, av = present(before, bv), present(after, av)
+		if reflect.DeepEqual(bv, av) {
+			return
+		}
+		changes = append(changes, models.FieldChange{
+			Field:  field,
+			Before: maskValue(bv, sensitive),
+			After:  maskValue(av, sensitive),
+		})
+	}
+
+	add("username", b.Username, a.Username, false)
+	add("email", b.Email, a.Email, true)
+	add("password_hash", b.Password, a.Password, true)
+	add("role", b.Role, a.Role, false)
+	add("avatar_url", b.AvatarURL, a.AvatarURL, false)
+	add("disabled", b.Disabled, a.Disabled, false)
+	add("phone", b.Phone, a.Phone, true)
+	add("sms_otp", b.SMSOTP, a.SMSOTP, false)
+	add("expires_at", expiry(&b), expiry(&a), false)
+
+	keys := make(map[string]bool)
+	for k := range b.Metadata {
+		keys[k] = true
+	}
+	for k := range a.Metadata {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		bv, bok := b.Metadata[k]
+		av, aok := a.Metadata[k]
+		if !bok {
+			bv = nil
+		}
+		if !aok {
+			av = nil
+		}
+		if reflect.DeepEqual(bv, av) {
+			continue
+		}
+		sensitive := sensitiveKey(k)
+		changes = append(changes, models.FieldChange{
+			Field:  "metadata." + k,
+			Before: maskValue(bv, sensitive),
+			After:  maskValue(av, sensitive),
+		})
+	}
+	return changes
+}
+
+// expiry returns u's account expiry as recorded in a diff: an RFC 3339
+// time, or empty when it has none.
+func expiry(u *models.User) string {
+	if u.ExpiresAt == nil {
+		return ""
+	}
+	return u.ExpiresAt.UTC().Format(time.RFC3339)
+}
+
+// sensitiveKey reports whether a metadata key's values must be masked.
+func sensitiveKey(key string) bool {
+	k := strings.ToLower(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(k, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskValue returns the value to record for one side of a change. Absent
+// values stay nil so the diff still shows additions and removals.
+func maskValue(v interface{}, sensitive bool) interface{} {
+	if v == nil {
+		return nil
+	}
+	if sensitive {
+		return Masked
+	}
+	return scrubValue(v)
+}
+
+// scrubValue scrubs credentials from the strings inside a JSON value.
+func scrubValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return scrub.String(val)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = scrubValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = scrubValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/storage"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+// Actions recorded for retention runs, so that the outcome of the last one
+// is visible to every instance.
+const (
+	RetentionAction       = "audit.retention"
+	RetentionFailedAction = "audit.retention.failed"
+	RetentionTarget       = "audit_log"
+)
+
+// archivePageSize is how many events are read at a time while archiving.
+const archivePageSize = 1000
+
+// RetentionStore holds the audit log. store.Store satisfies it.
+type RetentionStore interface {
+	RecordAudit(ctx context.Context, e *models.AuditEvent) error
+	ListAuditEvents(ctx context.Context, f store.AuditFilter) ([]models.AuditEvent, int, error)
+	PurgeAuditEvents(ctx context.Context, cutoff time.Time) (int64, error)
+	WithTx(ctx context.Context, fn func(tx store.Store) error) error
+}
+
+// Retention deletes audit events older than MaxAge. With an Archive bucket,
+// events are first written there one UTC day at a time, as gzipped NDJSON
+// oldest first, and a day is deleted only once it is archived and wholly
+// older than MaxAge; without one they are simply deleted.
+type Retention struct {
+	Store   RetentionStore
+	MaxAge  time.Duration
+	Archive *storage.Bucket
+}
+
+// RetentionRun is the outcome of one Run.
+type RetentionRun struct {
+	Cutoff   time.Time `json:"cutoff"`
+	Archived int       `json:"archived"`
+	Pruned   int64     `json:"pruned"`
+	Objects  []string  `json:"objects,omitempty"`
+}
+
+// Run applies the policy as of now. Runs that delete events or fail are
+// recorded in the audit log; days archived before a failure stay deleted
+// and are reported in the returned run.
+func (r *Retention) Run(ctx context.Context, now time.Time) (*RetentionRun, error) {
+	run := &RetentionRun{Cutoff: now.Add(-r.MaxAge).UTC()}
+	err := r.apply(ctx, run)
+	if ctx.Err() != nil || (err == nil && run.Pruned == 0) {
+		return run, err
+	}
+	e := &models.AuditEvent{
+		Action:     RetentionAction,
+		TargetType: RetentionTarget,
+		Changes: []models.FieldChange{
+			{Field: "cutoff", After: run.Cutoff.Format(time.RFC3339)},
+			{Field: "archived", After: run.Archived},
+			{Field: "pruned", After: run.Pruned},
+		},
+	}
+	if len(run.Objects) > 0 {
+		e.Changes = append(e.Changes, models.FieldChange{Field: "objects", After: run.Objects})
+	}
+	if err != nil {
+		e.Action = RetentionFailedAction
+		e.Changes = append(e.Changes, models.FieldChange{Field: "error", After: err.Error()})
+	}
+	if recErr := r.Store.RecordAudit(ctx, e); recErr != nil && err == nil {
+		err = fmt.Errorf("failed to record retention run: %w", recErr)
+	}
+	return run, err
+}
+
+func (r *Retention) apply(ctx context.Context, run *RetentionRun) error {
+	if r.Archive == nil {
+		n, err := r.Store.PurgeAuditEvents(ctx, run.Cutoff)
+		run.Pruned = n
+		return err
+	}
+	for {
+		oldest, err := r.oldest(ctx, run.Cutoff)
+		if err != nil || oldest == nil {
+			return err
+		}
+		day := oldest.CreatedAt.UTC().Truncate(24 * time.Hour)
+		next := day.Add(24 * time.Hour)
+		if next.After(run.Cutoff) {
+			return nil
+		}
+		key, n, err := r.archiveDay(ctx, day, next)
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %w", day.Format(time.DateOnly), err)
+		}
+		// The archive may have been read from a replica; delete the day on
+		// the primary only if it holds exactly the events archived.
+		var pruned int64
+		err = r.Store.WithTx(ctx, func(tx store.Store) error {
+			_, total, err := tx.ListAuditEvents(ctx, store.AuditFilter{Until: next, Limit: 1})
+			if err != nil {
+				return err
+			}
+			if total != n {
+				return fmt.Errorf("audit log for %s changed while archiving; will retry", day.Format(time.DateOnly))
+			}
+			pruned, err = tx.PurgeAuditEvents(ctx, next)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		run.Archived += n
+		run.Pruned += pruned
+		run.Objects = append(run.Objects, key)
+	}
+}
+
+// oldest returns the oldest event created before cutoff, or nil.
+func (r *Retention) oldest(ctx context.Context, cutoff time.Time) (*models.AuditEvent, error) {
+	_, total, err := r.Store.ListAuditEvents(ctx, store.AuditFilter{Until: cutoff, Limit: 1})
+	if err != nil || total == 0 {
+		return nil, err
+	}
+	events, _, err := r.Store.ListAuditEvents(ctx, store.AuditFilter{Until: cutoff, Limit: 1, Offset: total - 1})
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	return &events[0], nil
+}
+
+// archiveDay writes the events created in [day, next) to the archive and
+// returns the object's key and how many events it holds. Archiving a day
+// again replaces its object.
+func (r *Retention) archiveDay(ctx context.Context, day, next time.Time) (string, int, error) {
+	tmp, err := os.CreateTemp("", "sentinel-audit-*.ndjson.gz")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	f := store.AuditFilter{Since: day, Until: next, Limit: 1}
+	_, total, err := r.Store.ListAuditEvents(ctx, f)
+	if err != nil {
+		return "", 0, err
+	}
+	// Events are listed newest first; walk the pages from the end.
+	written := 0
+	for end := total; end > 0; end -= archivePageSize {
+		f.Offset = max(end-archivePageSize, 0)
+		f.Limit = end - f.Offset
+		events, _, err := r.Store.ListAuditEvents(ctx, f)
+		if err != nil {
+			return "", 0, err
+		}
+		slices.Reverse(events)
+		for i := range events {
+			if err := enc.Encode(&events[i]); err != nil {
+				return "", 0, err
+			}
+		}
+		written += len(events)
+	}
+	if err := zw.Close(); err != nil {
+		return "", 0, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	name := "audit-" + day.Format(time.DateOnly) + ".ndjson.gz"
+	key, err := r.Archive.Put(ctx, storage.KindAudit, day, name, tmp, size, "application/gzip")
+	if err != nil {
+		return "", 0, err
+	}
+	return key, written, nil
+}
@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/storage"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	now := day.Add(3*24*time.Hour + 12*time.Hour)
+	record := func(s store.Store) {
+		for i, at := range []time.Duration{time.Hour, 2 * time.Hour, 25 * time.Hour, 49 * time.Hour, 3 * 24 * time.Hour} {
+			e := &models.AuditEvent{ActorID: int64(i + 1), Action: "user.login", TargetType: "user", CreatedAt: day.Add(at)}
+			if err := s.RecordAudit(ctx, e); err != nil {
+				t.Fatalf("RecordAudit: %v", err)
+			}
+		}
+	}
+
+	// Without an archive, events older than MaxAge are deleted outright.
+	s := store.NewMemStore()
+	record(s)
+	run, err := (&Retention{Store: s, MaxAge: 36 * time.Hour}).Run(ctx, now)
+	if err != nil || run.Pruned != 3 || run.Archived != 0 {
+		t.Fatalf("Run = %+v, %v; want 3 pruned", run, err)
+	}
+	last, _, _ := s.ListAuditEvents(ctx, store.AuditFilter{Limit: 1})
+	if len(last) != 1 || last[0].Action != RetentionAction || last[0].TargetType != RetentionTarget {
+		t.Errorf("retention run not recorded: %+v", last)
+	}
+
+	// With one, whole days are archived oldest first, then deleted; the
+	// day the cutoff falls in waits.
+	dir := t.TempDir()
+	bucket, err := storage.OpenBucket("file://"+dir, storage.S3Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = store.NewMemStore()
+	record(s)
+	run, err = (&Retention{Store: s, MaxAge: 36 * time.Hour, Archive: bucket}).Run(ctx, now)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if run.Archived != 3 || run.Pruned != 3 || len(run.Objects) != 2 || run.Objects[0] != "audit/2025/03/01/audit-2025-03-01.ndjson.gz" {
+		t.Fatalf("Run = %+v; want two days archived", run)
+	}
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(run.Objects[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actors []int64
+	for sc := bufio.NewScanner(zr); sc.Scan(); {
+		var e models.AuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("archived line %q: %v", sc.Text(), err)
+		}
+		actors = append(actors, e.ActorID)
+	}
+	if len(actors) != 2 || actors[0] != 1 || actors[1] != 2 {
+		t.Errorf("archived actors = %v, want [1 2] oldest first", actors)
+	}
+	events, total, _ := s.ListAuditEvents(ctx, store.AuditFilter{})
+	if total != 3 || events[len(events)-1].ActorID != 4 {
+		t.Errorf("unexpected events left: %+v", events)
+	}
+
+	// Nothing is left to do until the next day passes the cutoff, and
+	// runs that do nothing are not recorded.
+	run, err = (&Retention{Store: s, MaxAge: 36 * time.Hour, Archive: bucket}).Run(ctx, now)
+	if err != nil || run.Archived != 0 || run.Pruned != 0 {
+		t.Errorf("second Run = %+v, %v; want nothing done", run, err)
+	}
+	if _, n, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: RetentionAction}); n != 1 {
+		t.Errorf("%d retention runs recorded, want 1", n)
+	}
+}
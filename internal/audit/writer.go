@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	"github.com/mayvqt/Sentinel/internal/models"
+)
+
+// Writer defaults.
+const (
+	DefaultQueueSize = 10000
+	DefaultBatchSize = 100
+)
+
+var (
+	queueDepth = metrics.NewGaugeVec(
+		"sentinel_audit_queue_depth",
+		"Audit events waiting to be written.",
+	)
+	dropped = metrics.NewCounterVec(
+		"sentinel_audit_events_dropped_total",
+		"Audit events never written, by reason: overflow (the queue was full) or error (the write failed).",
+		"reason",
+	)
+)
+
+// Store persists audit events. store.Store satisfies it.
+type Store interface {
+	RecordAudits(ctx context.Context, events []*models.AuditEvent) error
+}
+
+// Writer records audit events in the background, so that writing them
+// adds no latency to the request. Events wait in a bounded queue and are
+// written in batches; when the queue is full the oldest event is dropped.
+// It is safe for concurrent use.
+type Writer struct {
+	store     Store
+	size      int
+	batchSize int
+	wake      chan struct{}
+
+	mu    sync.Mutex
+	queue []*models.AuditEvent
+
+	// writing serializes batches, so events are written in order.
+	writing sync.Mutex
+}
+
+// NewWriter returns a Writer that queues up to size events (DefaultQueueSize
+// when zero) and writes them to s in batches of up to batchSize
+// (DefaultBatchSize when zero). Run must be started for events to be
+// written.
+func NewWriter(s Store, size, batchSize int) *Writer {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &Writer{store: s, size: size, batchSize: batchSize, wake: make(chan struct{}, 1)}
+}
+
+// RecordAudit queues e to be written. It returns an error only for an
+// event that could never be written; its ID is assigned once it is.
+func (w *Writer) RecordAudit(ctx context.Context, e *models.AuditEvent) error {
+	if e == nil || e.Action == "" || e.TargetType == "" {
+		return errors.New("audit event requires an action and target type")
+	}
+	w.mu.Lock()
+	if len(w.queue) >= w.size {
+		w.queue[0] = nil
+		w.queue = w.queue[1:]
+		dropped.WithLabelValues("overflow").Inc()
+	}
+	w.queue = append(w.queue, e)
+	queueDepth.WithLabelValues().Set(float64(len(w.queue)))
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run writes queued events as they arrive until ctx is canceled. Events
+// still queued then are left for Flush.
+func (w *Writer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+			// Writes are not tied to ctx, so a batch is never cut short
+			_ = w.Flush(context.WithoutCancel(ctx))
+		}
+	}
+}
+
+// Flush writes every queued event, returning the first error. Events in
+// a batch that fails are logged and dropped rather than retried, so a
+// broken store cannot fill the queue.
+func (w *Writer) Flush(ctx context.Context) error {
+	w.writing.Lock()
+	defer w.writing.Unlock()
+
+	var firstErr error
+	for {
+		batch := w.next()
+		if len(batch) == 0 {
+			return firstErr
+		}
+		if err := w.store.RecordAudits(ctx, batch); err != nil {
+			dropped.WithLabelValues("error").Add(float64(len(batch)))
+			logger.Error("Failed to write audit events", map[string]interface{}{
+				"events": len(batch),
+				"error":  err.Error(),
+			})
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				return firstErr
+			}
+		}
+	}
+}
+
+// next removes and returns up to batchSize events from the queue.
+func (w *Writer) next() []*models.AuditEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := min(len(w.queue), w.batchSize)
+	batch := make([]*models.AuditEvent, n)
+	copy(batch, w.queue)
+	w.queue = w.queue[n:]
+	queueDepth.WithLabelValues().Set(float64(len(w.queue)))
+	return batch
+}
@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+)
+
+// batchStore records the batches written to it.
+type batchStore struct {
+	mu      sync.Mutex
+	batches [][]*models.AuditEvent
+	err     error
+}
+
+func (s *batchStore) RecordAudits(ctx context.Context, events []*models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *batchStore) actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var actions []string
+	for _, b := range s.batches {
+		for _, e := range b {
+			actions = append(actions, e.Action)
+		}
+	}
+	return actions
+}
+
+func event(action string) *models.AuditEvent {
+	return &models.AuditEvent{Action: action, TargetType: "user", TargetID: 1}
+}
+
+func TestWriterBatchesAndDropsOldest(t *testing.T) {
+	s := &batchStore{}
+	w := NewWriter(s, 3, 2)
+	ctx := context.Background()
+
+	if err := w.RecordAudit(ctx, &models.AuditEvent{TargetType: "user"}); err == nil {
+		t.Error("expected an event without an action to be rejected")
+	}
+	for _, action := range []string{"a", "b", "c", "d"} {
+		if err := w.RecordAudit(ctx, event(action)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The queue holds three, so the oldest event is dropped
+	if got := s.actions(); len(got) != 3 || got[0] != "b" || got[2] != "d" {
+		t.Errorf("wrote %v, want [b c d]", got)
+	}
+	if len(s.batches) != 2 || len(s.batches[0]) != 2 {
+		t.Errorf("expected batches of at most 2, got %d batches", len(s.batches))
+	}
+}
+
+func TestWriterRun(t *testing.T) {
+	s := &batchStore{}
+	w := NewWriter(s, 0, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	w.RecordAudit(ctx, event("user.login"))
+	deadline := time.Now().Add(time.Second)
+	for len(s.actions()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := s.actions(); len(got) != 1 || got[0] != "user.login" {
+		t.Errorf("Run wrote %v", got)
+	}
+	cancel()
+	<-done
+}
+
+func TestWriterFailedBatch(t *testing.T) {
+	failed := errors.New("database is locked")
+	s := &batchStore{err: failed}
+	w := NewWriter(s, 0, 0)
+	w.RecordAudit(context.Background(), event("user.login"))
+	if err := w.Flush(context.Background()); !errors.Is(err, failed) {
+		t.Fatalf("expected the store error, got %v", err)
+	}
+
+	// The failed batch is not retried
+	s.err = nil
+	if err := w.Flush(context.Background()); err != nil || len(s.actions()) != 0 {
+		t.Errorf("expected nothing left to write, got %v, %v", s.actions(), err)
+	}
+}
@@ -3,7 +3,14 @@
 package auth
 
 import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -18,26 +25,165 @@ var (
 	// ErrNoSecret is returned when an Auth instance was created without a
 	// JWT secret in the configuration.
 	ErrNoSecret = errors.New("jwt secret not configured")
+	// ErrTokenTooLarge is returned when a token would exceed the
+	// configured TokenMaxBytes.
+	ErrTokenTooLarge = errors.New("token exceeds maximum size")
+
+	errTokenEmpty      = errors.New("token empty")
+	errTokenExpired    = errors.New("token expired")
+	errTokenFromFuture = errors.New("token issued too far in the future")
+	errNoKeyID         = errors.New("token has no key ID")
+	errUnknownKeyID    = errors.New("token signed with an unknown key")
+
+	// ErrTokenRevoked is returned by ParseToken for tokens whose ID is on
+	// the denylist.
+	ErrTokenRevoked = errors.New("token revoked")
+
+	// ErrCanaryToken is matched (with errors.Is) by the error ParseToken
+	// returns for canary tokens; see CanaryTokenError.
+	ErrCanaryToken = errors.New("canary token")
 )
 
+// CanaryTokenError is returned by ParseToken for a validly signed token
+// registered as a canary. Claims lets callers report which canary was used.
+type CanaryTokenError struct {
+	Claims *Claims
+}
+
+func (e *CanaryTokenError) Error() string { return ErrCanaryToken.Error() }
+
+func (e *CanaryTokenError) Unwrap() error { return ErrCanaryToken }
+
+// TokenTypeSSO marks the token carried in the subdomain single sign-on
+// cookie. It identifies a session to sibling apps and is not accepted as
+// a bearer token.
+const TokenTypeSSO = "sso"
+
+// TokenTypeResource marks a single-use token that grants access to one
+// resource, such as a download link. It is checked and used up through
+// introspection and is not accepted as a bearer token.
+const TokenTypeResource = "resource"
+
+// BearerTokenType reports whether tokens of type t authenticate API
+// requests.
+func BearerTokenType(t string) bool {
+	return t != TokenTypeSSO && t != TokenTypeResource
+}
+
 // Claims is the JWT payload used throughout the API.
 // Keep fields minimal to avoid overloading tokens with data.
 type Claims struct {
 	UserID    string `json:"uid"`
 	Role      string `json:"role"`
-	TokenType string `json:"token_type"` // "access" or "refresh"
+	TokenType string `json:"token_type"` // "access", "refresh", or "sso"
+	// AuthTime is when the user logged in, carried across refresh token
+	// rotation so the session's total lifetime can be bounded.
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	// Scopes are admin capabilities granted beyond the role's, carried by
+	// the short-lived access tokens of approved elevations.
+	Scopes []string `json:"scopes,omitempty"`
+	// SessionID, on access tokens issued at login or refresh, is the ID of
+	// the refresh token issued with them, so that their use can be
+	// credited to the session.
+	SessionID string `json:"sid,omitempty"`
+	// Resource identifies what a resource token grants access to.
+	Resource string `json:"res,omitempty"`
+	// Confirmation, when set, binds the token to a DPoP key; see BoundKey.
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	// ClientID names the registered client the session was started by,
+	// whose token policy applies to it.
+	ClientID string `json:"client_id,omitempty"`
+	// Scope is the space-delimited scope granted to that client. Unlike
+	// Scopes, it grants no admin capabilities; it is for the client's own
+	// APIs to check.
+	Scope string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
+
+	// keyID is the kid header of the token the claims were parsed from.
+	keyID string
 }
 
-type Auth struct{ secret string }
+// Revocations reports whether a token ID has been revoked. It is consulted
+// on every ParseToken call and must be fast and safe for concurrent use.
+type Revocations interface {
+	Contains(jti string) bool
+}
+
+type Auth struct {
+	secret string
+	// previous is the secret that was replaced by secret, whose tokens are
+	// still accepted until they expire; see ParseToken.
+	previous string
+	// keyID and previousKeyID identify secret and previous in the kid
+	// header of the tokens they sign; see KeyID.
+	keyID         string
+	previousKeyID string
+	// skew is the clock drift tolerated when checking exp, nbf, and iat,
+	// in nanoseconds; see SetClockSkew.
+	skew atomic.Int64
+	// maxBytes is the largest token sign issues; zero is unlimited.
+	maxBytes int
+	denylist atomic.Pointer[Revocations]
+	canaries atomic.Pointer[Revocations]
+	proofs   atomic.Pointer[ProofVerifier]
+	// aead, when set, encrypts issued tokens; see SetEncryptionKey.
+	aead cipher.AEAD
+}
 
 // New returns an Auth configured from cfg. If cfg is nil, operations will fail.
 func New(cfg *config.Config) *Auth {
-	var s string
+	a := &Auth{}
 	if cfg != nil {
-		s = cfg.JWTSecret
+		a.secret = cfg.JWTSecret
+		a.previous = cfg.JWTSecretPrevious
+		a.keyID = KeyID(a.secret)
+		a.previousKeyID = KeyID(a.previous)
+		a.skew.Store(int64(max(cfg.TokenClockSkew, 0)))
+		a.maxBytes = max(cfg.TokenMaxBytes, 0)
 	}
-	return &Auth{secret: s}
+	secretEntropy.WithLabelValues().Set(SecretEntropyBits(a.secret))
+	return a
+}
+
+// SetDenylist makes ParseToken reject tokens whose ID is in d. A nil d
+// disables the check.
+func (a *Auth) SetDenylist(d Revocations) {
+	if d == nil {
+		a.denylist.Store(nil)
+		return
+	}
+	a.denylist.Store(&d)
+}
+
+// SetCanaries makes ParseToken reject tokens whose ID is in c with a
+// *CanaryTokenError. Canaries are checked before the denylist, so a canary
+// that is also revoked is still recognized. A nil c disables the check.
+func (a *Auth) SetCanaries(c Revocations) {
+	if c == nil {
+		a.canaries.Store(nil)
+		return
+	}
+	a.canaries.Store(&c)
+}
+
+// Canaries returns the set installed with SetCanaries, or nil.
+func (a *Auth) Canaries() Revocations {
+	if c := a.canaries.Load(); c != nil {
+		return *c
+	}
+	return nil
+}
+
+// ClockSkew returns the clock drift tolerated when validating exp, nbf,
+// and iat claims.
+func (a *Auth) ClockSkew() time.Duration {
+	return time.Duration(a.skew.Load())
+}
+
+// SetClockSkew changes the clock drift tolerated from now on, such as
+// while the local clock is known to be off.
+func (a *Auth) SetClockSkew(d time.Duration) {
+	a.skew.Store(int64(max(d, 0)))
 }
 
 // HashPassword returns a bcrypt hash for pw. Returns ErrEmptyPassword if pw is empty.
@@ -49,7 +195,9 @@ func HashPassword(pw string) (string, error) {
 	// Cost of 12 provides strong security while maintaining reasonable performance
 	// Each increment doubles the time, so 12 is ~4x slower than default (10)
 	const enterpriseCost = 12
+	start := time.Now()
 	b, err := bcrypt.GenerateFromPassword([]byte(pw), enterpriseCost)
+	passwordHashDuration.WithLabelValues("hash").Observe(time.Since(start).Seconds())
 	if err != nil {
 		return "", err
 	}
@@ -61,7 +209,10 @@ func CheckPassword(hash, pw string) error {
 	if hash == "" || pw == "" {
 		return bcrypt.ErrMismatchedHashAndPassword
 	}
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw))
+	start := time.Now()
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw))
+	passwordHashDuration.WithLabelValues("verify").Observe(time.Since(start).Seconds())
+	return err
 }
 
 // GenerateToken signs an access JWT for userID with the given role and ttl.
@@ -78,55 +229,344 @@ func (a *Auth) GenerateTokenWithType(userID, role, tokenType string, ttl time.Du
 		return "", errors.New("ttl must be > 0")
 	}
 	now := time.Now()
-	c := Claims{
+	return a.sign(&Claims{
 		UserID:    userID,
 		Role:      role,
 		TokenType: tokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
 			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	})
+}
+
+// Grant describes the session the tokens issued at login and refresh
+// belong to. The zero value is a plain bearer session.
+type Grant struct {
+	// SessionID is the ID of the session's current refresh token; it is
+	// set on access tokens only.
+	SessionID string
+	// JKT, unless empty, binds the tokens to the DPoP key with that
+	// thumbprint.
+	JKT string
+	// ClientID and Scope are the registered client the session was
+	// started by and the scope granted to it.
+	ClientID string
+	Scope    string
+}
+
+// GenerateSessionToken signs an access JWT like GenerateToken for the
+// session described by g.
+func (a *Auth) GenerateSessionToken(userID, role string, g Grant, ttl time.Duration) (string, error) {
+	if a.secret == "" {
+		return "", ErrNoSecret
+	}
+	if ttl <= 0 {
+		return "", errors.New("ttl must be > 0")
+	}
+	now := time.Now()
+	return a.sign(&Claims{
+		UserID:       userID,
+		Role:         role,
+		TokenType:    "access",
+		SessionID:    g.SessionID,
+		Confirmation: confirmation(g.JKT),
+		ClientID:     g.ClientID,
+		Scope:        g.Scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	})
+}
+
+// IssueResourceToken signs a resource token granting userID access to
+// resource until ttl from now, returning the token and its claims.
+func (a *Auth) IssueResourceToken(userID, resource string, ttl time.Duration) (string, *Claims, error) {
+	if a.secret == "" {
+		return "", nil, ErrNoSecret
+	}
+	if ttl <= 0 {
+		return "", nil, errors.New("ttl must be > 0")
+	}
+	now := time.Now()
+	c := &Claims{
+		UserID:    userID,
+		TokenType: TokenTypeResource,
+		Resource:  resource,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		},
 	}
+	token, err := a.sign(c)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, c, nil
+}
+
+// GenerateRefreshToken signs a refresh JWT for a session that began at
+// authTime and ends at expiresAt.
+func (a *Auth) GenerateRefreshToken(userID, role string, authTime, expiresAt time.Time) (string, error) {
+	token, _, err := a.IssueRefreshToken(userID, role, authTime, expiresAt)
+	return token, err
+}
+
+// IssueRefreshToken is GenerateRefreshToken but also returns the token's
+// claims, whose ID (jti) callers persist to track the session.
+func (a *Auth) IssueRefreshToken(userID, role string, authTime, expiresAt time.Time) (string, *Claims, error) {
+	return a.IssueBoundRefreshToken(userID, role, Grant{}, authTime, expiresAt)
+}
+
+// IssueBoundRefreshToken is IssueRefreshToken for the session described
+// by g, whose SessionID is ignored: a refresh token's own ID names its
+// session.
+func (a *Auth) IssueBoundRefreshToken(userID, role string, g Grant, authTime, expiresAt time.Time) (string, *Claims, error) {
+	if a.secret == "" {
+		return "", nil, ErrNoSecret
+	}
+	now := time.Now()
+	if !expiresAt.After(now) {
+		return "", nil, errors.New("expiry must be in the future")
+	}
+	c := &Claims{
+		UserID:       userID,
+		Role:         role,
+		TokenType:    "refresh",
+		AuthTime:     jwt.NewNumericDate(authTime),
+		Confirmation: confirmation(g.JKT),
+		ClientID:     g.ClientID,
+		Scope:        g.Scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := a.sign(c)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, c, nil
+}
+
+// IssueElevatedToken signs an access JWT carrying scopes beyond role's
+// that expires at expiresAt, returning the token and its claims.
+func (a *Auth) IssueElevatedToken(userID, role string, scopes []string, expiresAt time.Time) (string, *Claims, error) {
+	if a.secret == "" {
+		return "", nil, ErrNoSecret
+	}
+	now := time.Now()
+	if !expiresAt.After(now) {
+		return "", nil, errors.New("expiry must be in the future")
+	}
+	c := &Claims{
+		UserID:    userID,
+		Role:      role,
+		TokenType: "access",
+		Scopes:    scopes,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := a.sign(c)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, c, nil
+}
+
+// KeyID returns the key ID of a signing secret: a short digest that names
+// the secret in a token's kid header without revealing it. It is empty for
+// an empty secret.
+func KeyID(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("sentinel-jwt-kid"))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// sign serializes and signs c with HS256 under the current key ID,
+// assigning a random token ID (jti) to c so the token can be revoked
+// individually, then encrypts the result when an encryption key is
+// configured. Tokens longer than maxBytes are refused with
+// ErrTokenTooLarge rather than issued to be cut off by a proxy.
+func (a *Auth) sign(c *Claims) (string, error) {
+	if c.ID == "" {
+		id, err := newTokenID()
+		if err != nil {
+			return "", err
+		}
+		c.ID = id
+	}
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
-	return t.SignedString([]byte(a.secret))
+	t.Header["kid"] = a.keyID
+	signed, err := t.SignedString([]byte(a.secret))
+	if err != nil {
+		return "", err
+	}
+	if a.aead != nil {
+		if signed, err = encryptToken(a.aead, signed); err != nil {
+			return "", err
+		}
+	}
+	if a.maxBytes > 0 && len(signed) > a.maxBytes {
+		oversizedTokens.WithLabelValues(c.TokenType).Inc()
+		return "", fmt.Errorf("%w: %d bytes, limit %d", ErrTokenTooLarge, len(signed), a.maxBytes)
+	}
+	tokensIssued.WithLabelValues(c.TokenType).Inc()
+	return signed, nil
+}
+
+// newTokenID returns 128 random bits, hex encoded.
+func newTokenID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
 }
 
 // ParseToken validates tokenStr and returns its Claims when valid.
+// Tokens signed with the previous secret, when one is configured, are
+// accepted too, so that the secret can be rotated without invalidating
+// the tokens already issued. The token's kid header selects the secret
+// to check it against; tokens with an unknown kid are rejected without
+// trying either. Rejections are counted by reason for
+// monitoring.
 func (a *Auth) ParseToken(tokenStr string) (*Claims, error) {
 	if a.secret == "" {
 		return nil, ErrNoSecret
 	}
+	c, err := a.parseToken(tokenStr)
+	if err != nil {
+		RecordTokenRejection(RejectionReason(err))
+		return nil, err
+	}
+	return c, nil
+}
+
+func (a *Auth) parseToken(tokenStr string) (*Claims, error) {
 	if tokenStr == "" {
-		return nil, errors.New("token empty")
+		return nil, errTokenEmpty
 	}
-	c := &Claims{}
-	t, err := jwt.ParseWithClaims(tokenStr, c, func(tok *jwt.Token) (interface{}, error) {
-		if _, ok := tok.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
+	if isEncrypted(tokenStr) {
+		if a.aead == nil {
+			return nil, fmt.Errorf("%w: encrypted tokens are not enabled", jwt.ErrTokenUnverifiable)
 		}
-		return []byte(a.secret), nil
-	})
+		inner, err := decryptToken(a.aead, tokenStr)
+		if err != nil {
+			return nil, err
+		}
+		tokenStr = inner
+	}
+	c, err := a.verify(tokenStr, a.keyFor)
+	if errors.Is(err, errNoKeyID) {
+		// Issued before tokens carried a kid: try each secret in turn
+		c, err = a.verify(tokenStr, secretKey(a.secret))
+		if errors.Is(err, jwt.ErrTokenSignatureInvalid) && a.previous != "" {
+			if c, err = a.verify(tokenStr, secretKey(a.previous)); err == nil {
+				previousSecretTokens.WithLabelValues(c.TokenType).Inc()
+			}
+		}
+	} else if err == nil && a.previous != "" && c.keyID == a.previousKeyID {
+		previousSecretTokens.WithLabelValues(c.TokenType).Inc()
+	}
 	if err != nil {
 		return nil, err
 	}
-	if !t.Valid {
-		return nil, errors.New("token invalid")
+
+	// Explicit expiry check (the jwt library checks exp and nbf with the
+	// same leeway, but we add explicit validation)
+	now, skew := time.Now(), a.ClockSkew()
+	if c.ExpiresAt != nil && now.After(c.ExpiresAt.Time.Add(skew)) {
+		return nil, errTokenExpired
+	}
+
+	// Validate issued-at time is not in the future beyond the clock skew
+	// tolerance. This prevents tokens with IssuedAt far in the future while
+	// allowing minor clock drift between hosts.
+	if c.IssuedAt != nil && c.IssuedAt.Time.After(now.Add(skew)) {
+		return nil, errTokenFromFuture
 	}
 
-	// Explicit expiry check (jwt library checks this, but we add explicit validation)
-	if c.ExpiresAt != nil && time.Now().After(c.ExpiresAt.Time) {
-		return nil, errors.New("token expired")
+	if k := a.canaries.Load(); k != nil && c.ID != "" && (*k).Contains(c.ID) {
+		return nil, &CanaryTokenError{Claims: c}
+	}
+	if d := a.denylist.Load(); d != nil && c.ID != "" && (*d).Contains(c.ID) {
+		return nil, ErrTokenRevoked
 	}
 
-	// Validate issued-at time is not in the future (clock skew tolerance: 1 minute)
-	// This prevents tokens with IssuedAt far in the future while allowing minor clock drift
-	if c.IssuedAt != nil {
-		now := time.Now()
-		maxFutureSkew := 1 * time.Minute
-		if c.IssuedAt.Time.After(now.Add(maxFutureSkew)) {
-			return nil, errors.New("token issued too far in the future")
+	return c, nil
+}
+
+// DecodeToken returns the claims of tokenStr, decrypting it first when it
+// is encrypted, without checking its signature or expiry. It is meant for
+// inspecting tokens; use ParseToken to authenticate them.
+func (a *Auth) DecodeToken(tokenStr string) (*Claims, error) {
+	if tokenStr == "" {
+		return nil, errTokenEmpty
+	}
+	if isEncrypted(tokenStr) {
+		if a.aead == nil {
+			return nil, fmt.Errorf("%w: encrypted tokens are not enabled", jwt.ErrTokenUnverifiable)
+		}
+		inner, err := decryptToken(a.aead, tokenStr)
+		if err != nil {
+			return nil, err
 		}
+		tokenStr = inner
+	}
+	c := &Claims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenStr, c); err != nil {
+		return nil, err
 	}
+	return c, nil
+}
+
+// keyFor returns the secret named by tok's kid header.
+func (a *Auth) keyFor(tok *jwt.Token) (interface{}, error) {
+	kid, _ := tok.Header["kid"].(string)
+	switch {
+	case kid == "":
+		return nil, errNoKeyID
+	case kid == a.keyID:
+		return []byte(a.secret), nil
+	case a.previous != "" && kid == a.previousKeyID:
+		return []byte(a.previous), nil
+	}
+	return nil, errUnknownKeyID
+}
+
+// secretKey returns a jwt.Keyfunc that always returns secret.
+func secretKey(secret string) jwt.Keyfunc {
+	return func(*jwt.Token) (interface{}, error) { return []byte(secret), nil }
+}
 
+// verify checks tokenStr's HS256 signature against the secret chosen by
+// key and its registered claims.
+func (a *Auth) verify(tokenStr string, key jwt.Keyfunc) (*Claims, error) {
+	c := &Claims{}
+	t, err := jwt.ParseWithClaims(tokenStr, c, func(tok *jwt.Token) (interface{}, error) {
+		if _, ok := tok.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		c.keyID, _ = tok.Header["kid"].(string)
+		return key(tok)
+	}, jwt.WithLeeway(a.ClockSkew()))
+	if err != nil {
+		return nil, err
+	}
+	if !t.Valid {
+		return nil, errors.New("token invalid")
+	}
 	return c, nil
 }
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -28,6 +30,27 @@ func TestHashAndCheckPassword(t *testing.T) {
 	}
 }
 
+func TestSecretEntropyBits(t *testing.T) {
+	tests := []struct {
+		secret string
+		strong bool
+	}{
+		{"", false},
+		{strings.Repeat("a", 64), false},
+		{"abababababababababababababababababababab", false},
+		{"0123456789abcdef", false},
+		// openssl rand -base64 32
+		{"q8Zl3Vt9rX0mH2yJ7cNwK5eB1uP4sD6fG8hA0zL3xQo=", true},
+		// openssl rand -hex 32
+		{"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", true},
+	}
+	for _, tt := range tests {
+		if got := SecretEntropyBits(tt.secret) >= DefaultMinSecretBits; got != tt.strong {
+			t.Errorf("SecretEntropyBits(%q) = %.0f, want strong = %v", tt.secret, SecretEntropyBits(tt.secret), tt.strong)
+		}
+	}
+}
+
 func TestGenerateAndParseToken(t *testing.T) {
 	cfg := &config.Config{JWTSecret: "test-secret-123"}
 	a := New(cfg)
@@ -163,3 +186,344 @@ func BenchmarkParseToken(b *testing.B) {
 		a.ParseToken(token)
 	}
 }
+
+func TestTokenRejectionReasons(t *testing.T) {
+	a := New(&config.Config{JWTSecret: "test-secret-123"})
+
+	past := time.Now().Add(-time.Hour)
+	expired := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: "1", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(past)}})
+	expiredToken, _ := expired.SignedString([]byte("test-secret-123"))
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: "1"}).SignedString([]byte("other-secret"))
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"empty", "", ReasonMissing},
+		{"garbage", "not-a-jwt", ReasonMalformed},
+		{"expired", expiredToken, ReasonExpired},
+		{"bad signature", forged, ReasonBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := tokenValidationFailures.WithLabelValues(tt.want)
+			before := counter.Value()
+			_, err := a.ParseToken(tt.token)
+			if err == nil {
+				t.Fatal("expected ParseToken to fail")
+			}
+			if got := RejectionReason(err); got != tt.want {
+				t.Errorf("RejectionReason = %q, want %q", got, tt.want)
+			}
+			if counter.Value() != before+1 {
+				t.Errorf("expected %s counter to increment", tt.want)
+			}
+		})
+	}
+}
+
+type idSet map[string]bool
+
+func (s idSet) Contains(jti string) bool { return s[jti] }
+
+func TestCanaryTokens(t *testing.T) {
+	a := New(&config.Config{JWTSecret: "test-secret-123"})
+	tok, err := a.GenerateToken("7", "admin", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := a.ParseToken(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.SetCanaries(idSet{c.ID: true})
+	a.SetDenylist(idSet{c.ID: true})
+	_, err = a.ParseToken(tok)
+	var canary *CanaryTokenError
+	if !errors.As(err, &canary) || canary.Claims.ID != c.ID || !errors.Is(err, ErrCanaryToken) {
+		t.Fatalf("expected a canary error carrying the claims, got %v", err)
+	}
+	if RejectionReason(err) != ReasonCanary {
+		t.Errorf("RejectionReason = %q, want %q", RejectionReason(err), ReasonCanary)
+	}
+
+	a.SetCanaries(nil)
+	if _, err := a.ParseToken(tok); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("expected the revoked token to be rejected once canaries are off, got %v", err)
+	}
+}
+
+func TestClockSkewTolerance(t *testing.T) {
+	const secret = "test-secret-123"
+	now := time.Now()
+	sign := func(rc jwt.RegisteredClaims) string {
+		tok, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: "1", RegisteredClaims: rc}).SignedString([]byte(secret))
+		return tok
+	}
+	justExpired := sign(jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now.Add(-30 * time.Second))})
+	notBeforeSoon := sign(jwt.RegisteredClaims{NotBefore: jwt.NewNumericDate(now.Add(30 * time.Second))})
+	issuedSoon := sign(jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(now.Add(30 * time.Second))})
+	issuedLater := sign(jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(now.Add(5 * time.Minute))})
+
+	lenient := New(&config.Config{JWTSecret: secret, TokenClockSkew: time.Minute})
+	for name, tok := range map[string]string{"exp": justExpired, "nbf": notBeforeSoon, "iat": issuedSoon} {
+		if _, err := lenient.ParseToken(tok); err != nil {
+			t.Errorf("%s within skew rejected: %v", name, err)
+		}
+	}
+	if _, err := lenient.ParseToken(issuedLater); RejectionReason(err) != ReasonNotYetValid {
+		t.Errorf("iat beyond skew: got %v", err)
+	}
+
+	strict := New(&config.Config{JWTSecret: secret})
+	if _, err := strict.ParseToken(justExpired); RejectionReason(err) != ReasonExpired {
+		t.Errorf("expected expiry without skew, got %v", err)
+	}
+	if _, err := strict.ParseToken(notBeforeSoon); RejectionReason(err) != ReasonNotYetValid {
+		t.Errorf("expected nbf rejection without skew, got %v", err)
+	}
+}
+
+func TestPreviousSecret(t *testing.T) {
+	old := New(&config.Config{JWTSecret: "old-secret-123"})
+	issued, err := old.GenerateToken("1", "user", time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	expired, _ := old.GenerateToken("1", "user", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	rotated := New(&config.Config{JWTSecret: "new-secret-456", JWTSecretPrevious: "old-secret-123"})
+	if c, err := rotated.ParseToken(issued); err != nil || c.UserID != "1" {
+		t.Fatalf("expected a token signed with the previous secret to be accepted, got %v", err)
+	}
+	if _, err := rotated.ParseToken(expired); RejectionReason(err) != ReasonExpired {
+		t.Errorf("expected an expired token signed with the previous secret to be rejected as expired, got %v", err)
+	}
+	fresh, _ := rotated.GenerateToken("1", "user", time.Minute)
+	if _, err := old.ParseToken(fresh); RejectionReason(err) != ReasonBadSignature {
+		t.Errorf("expected new tokens to be signed with the new secret, got %v", err)
+	}
+
+	dropped := New(&config.Config{JWTSecret: "new-secret-456"})
+	if _, err := dropped.ParseToken(issued); RejectionReason(err) != ReasonBadSignature {
+		t.Errorf("expected the previous secret's tokens to be rejected once it is removed, got %v", err)
+	}
+}
+
+func TestKeyIDs(t *testing.T) {
+	if KeyID("secret-a") == KeyID("secret-b") || KeyID("secret-a") != KeyID("secret-a") || KeyID("") != "" {
+		t.Fatal("expected key IDs to be stable and to differ by secret")
+	}
+	old := New(&config.Config{JWTSecret: "old-secret-123"})
+	issued, _ := old.GenerateToken("1", "user", time.Minute)
+	tok, _, err := jwt.NewParser().ParseUnverified(issued, &Claims{})
+	if err != nil || tok.Header["kid"] != KeyID("old-secret-123") {
+		t.Fatalf("expected the kid header to name the signing secret, got %v (%v)", tok.Header["kid"], err)
+	}
+
+	rotated := New(&config.Config{JWTSecret: "new-secret-456", JWTSecretPrevious: "old-secret-123"})
+	if _, err := rotated.ParseToken(issued); err != nil {
+		t.Fatalf("expected the kid to select the previous secret, got %v", err)
+	}
+
+	// A kid naming neither secret is rejected, even with a valid signature
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: "1", Role: "user", TokenType: "access"})
+	unknown.Header["kid"] = "unknown"
+	signed, _ := unknown.SignedString([]byte("new-secret-456"))
+	if _, err := rotated.ParseToken(signed); RejectionReason(err) != ReasonBadSignature {
+		t.Errorf("expected an unknown kid to be rejected, got %v", err)
+	}
+
+	// Tokens issued before key IDs were added are still accepted
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: "1", Role: "user", TokenType: "access"})
+	signed, _ = legacy.SignedString([]byte("old-secret-123"))
+	if _, err := rotated.ParseToken(signed); err != nil {
+		t.Errorf("expected a token without a kid to be accepted, got %v", err)
+	}
+}
+
+func TestTokenMaxBytes(t *testing.T) {
+	// TOKEN_MAX_BYTES defaults to 4096
+	a := New(&config.Config{JWTSecret: "test-secret-123", TokenMaxBytes: 4096})
+	token, err := a.GenerateToken("1", "user", time.Minute)
+	if err != nil {
+		t.Fatalf("expected a token to fit the 4096-byte default, got %v", err)
+	}
+
+	small := New(&config.Config{JWTSecret: "test-secret-123", TokenMaxBytes: len(token) - 1})
+	if _, err := small.GenerateToken("1", "user", time.Minute); !errors.Is(err, ErrTokenTooLarge) {
+		t.Errorf("expected ErrTokenTooLarge, got %v", err)
+	}
+	if _, _, err := small.IssueRefreshToken("1", "user", time.Now(), time.Now().Add(time.Hour)); !errors.Is(err, ErrTokenTooLarge) {
+		t.Errorf("expected refresh tokens to be limited too, got %v", err)
+	}
+
+	unlimited := New(&config.Config{JWTSecret: "test-secret-123"})
+	if _, err := unlimited.GenerateToken("1", "user", time.Minute); err != nil {
+		t.Errorf("expected zero to disable the limit, got %v", err)
+	}
+}
+
+func TestDecodeToken(t *testing.T) {
+	a := New(&config.Config{JWTSecret: "test-secret-123"})
+	expired, _ := a.GenerateToken("7", "admin", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, err := a.ParseToken(expired); err == nil {
+		t.Fatal("expected the token to have expired")
+	}
+	c, err := New(&config.Config{JWTSecret: "other-secret"}).DecodeToken(expired)
+	if err != nil || c.UserID != "7" || c.Role != "admin" {
+		t.Errorf("expected an expired token with another key to decode, got %+v (%v)", c, err)
+	}
+	if _, err := a.DecodeToken("not-a-token"); err == nil {
+		t.Error("expected a malformed token to fail to decode")
+	}
+}
+
+func TestEncryptedTokens(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret-123"}
+	plain := New(cfg)
+	a := New(cfg)
+	key, err := ParseEncryptionKey("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if err != nil {
+		t.Fatalf("ParseEncryptionKey: %v", err)
+	}
+	if err := a.SetEncryptionKey(key); err != nil {
+		t.Fatalf("SetEncryptionKey: %v", err)
+	}
+
+	token, err := a.GenerateToken("42", "admin", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		t.Fatalf("expected a 5-part JWE, got %d parts", len(parts))
+	}
+	if strings.Contains(token, "eyJ1aWQi") {
+		t.Fatal("claims must not be readable from an encrypted token")
+	}
+	claims, err := a.ParseToken(token)
+	if err != nil || claims.UserID != "42" {
+		t.Fatalf("ParseToken = %+v, %v", claims, err)
+	}
+
+	// Tokens issued before encryption was enabled remain valid
+	old, _ := plain.GenerateToken("7", "user", time.Hour)
+	if _, err := a.ParseToken(old); err != nil {
+		t.Errorf("expected plain token to be accepted: %v", err)
+	}
+	// Instances without the key cannot read encrypted tokens
+	if _, err := plain.ParseToken(token); err == nil {
+		t.Error("expected encrypted token to be rejected without a key")
+	}
+
+	// Tampering with the ciphertext is detected
+	ct := []byte(parts[3])
+	ct[0] ^= 1
+	parts[3] = string(ct)
+	_, err = a.ParseToken(strings.Join(parts, "."))
+	if err == nil {
+		t.Fatal("expected tampered token to be rejected")
+	}
+	if got := RejectionReason(err); got != ReasonBadSignature && got != ReasonMalformed {
+		t.Errorf("RejectionReason = %q", got)
+	}
+
+	if _, err := ParseEncryptionKey("too-short"); err == nil {
+		t.Error("expected short key to be rejected")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("expected %d codes, got %d", RecoveryCodeCount, len(codes))
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != 19 || strings.Count(c, "-") != 3 || seen[c] {
+			t.Errorf("unexpected code %q", c)
+		}
+		seen[c] = true
+	}
+
+	want := HashRecoveryCode(codes[0])
+	loose := strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+	if HashRecoveryCode(" "+loose+" ") != want {
+		t.Error("hash should ignore case, spaces, and dashes")
+	}
+	if HashRecoveryCode(codes[1]) == want {
+		t.Error("different codes must hash differently")
+	}
+}
+
+func TestLinkTokens(t *testing.T) {
+	a, err := GenerateLinkToken()
+	if err != nil {
+		t.Fatalf("GenerateLinkToken: %v", err)
+	}
+	b, _ := GenerateLinkToken()
+	if a == b || len(a) != 43 || strings.ContainsAny(a, "+/=") {
+		t.Errorf("unexpected tokens %q, %q", a, b)
+	}
+	if HashLinkToken(a) != HashLinkToken(a) || HashLinkToken(a) == HashLinkToken(b) {
+		t.Error("hash should be deterministic and distinguish tokens")
+	}
+}
+
+func TestOTP(t *testing.T) {
+	code, err := GenerateOTP()
+	if err != nil {
+		t.Fatalf("GenerateOTP: %v", err)
+	}
+	if len(code) != OTPDigits || strings.Trim(code, "0123456789") != "" {
+		t.Errorf("unexpected code %q", code)
+	}
+	if HashOTP(1, code) != HashOTP(1, " "+code[:3]+" "+code[3:]) {
+		t.Error("hash should ignore spaces")
+	}
+	if HashOTP(1, code) == HashOTP(2, code) {
+		t.Error("hash should be bound to the user")
+	}
+}
+
+func TestRequestSignatures(t *testing.T) {
+	a := New(&config.Config{JWTSecret: "new-secret", JWTSecretPrevious: "old-secret"})
+	old := New(&config.Config{JWTSecret: "old-secret"})
+	key := a.SigningKey("jti-1")
+	if key == a.SigningKey("jti-2") || key == old.SigningKey("jti-1") {
+		t.Fatal("expected keys to differ by token and secret")
+	}
+
+	body := []byte(`{"role":"admin"}`)
+	sig := SignRequest(key, 1700000000, "nonce-1", "PATCH", "/api/admin/users/2", body)
+	if !a.VerifyRequestSignature("jti-1", sig, 1700000000, "nonce-1", "PATCH", "/api/admin/users/2", body) {
+		t.Error("expected a valid signature to verify")
+	}
+	// Every signed part counts
+	for name, ok := range map[string]bool{
+		"token":     a.VerifyRequestSignature("jti-2", sig, 1700000000, "nonce-1", "PATCH", "/api/admin/users/2", body),
+		"timestamp": a.VerifyRequestSignature("jti-1", sig, 1700000001, "nonce-1", "PATCH", "/api/admin/users/2", body),
+		"nonce":     a.VerifyRequestSignature("jti-1", sig, 1700000000, "nonce-2", "PATCH", "/api/admin/users/2", body),
+		"method":    a.VerifyRequestSignature("jti-1", sig, 1700000000, "nonce-1", "DELETE", "/api/admin/users/2", body),
+		"uri":       a.VerifyRequestSignature("jti-1", sig, 1700000000, "nonce-1", "PATCH", "/api/admin/users/3", body),
+		"body":      a.VerifyRequestSignature("jti-1", sig, 1700000000, "nonce-1", "PATCH", "/api/admin/users/2", []byte(`{"role":"user"}`)),
+	} {
+		if ok {
+			t.Errorf("expected a different %s to fail verification", name)
+		}
+	}
+
+	// Keys handed out before a secret rotation keep working
+	oldSig := SignRequest(old.SigningKey("jti-1"), 1700000000, "nonce-1", "POST", "/", nil)
+	if !a.VerifyRequestSignature("jti-1", oldSig, 1700000000, "nonce-1", "POST", "/", nil) {
+		t.Error("expected a key from the previous secret to verify")
+	}
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Encrypted tokens are nested JWTs (RFC 7519 §5.2): the signed token is the
+// plaintext of a compact JWE (RFC 7516) using direct encryption with a
+// shared 256-bit key and AES-GCM, so clients cannot read the claims.
+const (
+	jweAlg = "dir"
+	jweEnc = "A256GCM"
+)
+
+// jweProtected is the base64url protected header of every token we encrypt;
+// it is also the AES-GCM additional authenticated data.
+var jweProtected = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + jweAlg + `","enc":"` + jweEnc + `","cty":"JWT"}`))
+
+// errTokenUndecryptable is returned for encrypted tokens that fail
+// authentication, i.e. were altered or encrypted under another key.
+var errTokenUndecryptable = errors.New("token could not be decrypted")
+
+// ParseEncryptionKey decodes a 32-byte token encryption key given as 64 hex
+// characters or as standard or URL-safe base64.
+func ParseEncryptionKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := enc.DecodeString(s); err == nil && len(key) == 32 {
+			return key, nil
+		}
+	}
+	return nil, errors.New("token encryption key must be 32 bytes, hex or base64 encoded")
+}
+
+// SetEncryptionKey makes the Auth issue encrypted (JWE) tokens under key,
+// which must be 32 bytes. Plain signed tokens issued earlier are still
+// accepted until they expire. Call it before the Auth is used.
+func (a *Auth) SetEncryptionKey(key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("token encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	a.aead = gcm
+	return nil
+}
+
+// encryptToken wraps the signed token jws in a compact JWE.
+func encryptToken(aead cipher.AEAD, jws string) (string, error) {
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nil, iv, []byte(jws), []byte(jweProtected))
+	split := len(sealed) - aead.Overhead()
+	enc := base64.RawURLEncoding
+	return strings.Join([]string{
+		jweProtected,
+		"", // no encrypted key with direct encryption
+		enc.EncodeToString(iv),
+		enc.EncodeToString(sealed[:split]),
+		enc.EncodeToString(sealed[split:]),
+	}, "."), nil
+}
+
+// isEncrypted reports whether token has the five parts of a compact JWE.
+func isEncrypted(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// decryptToken returns the signed token inside the compact JWE token.
+func decryptToken(aead cipher.AEAD, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		return "", fmt.Errorf("%w: not a direct-encryption JWE", jwt.ErrTokenMalformed)
+	}
+
+	enc := base64.RawURLEncoding
+	rawHeader, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("%w: bad JWE header", jwt.ErrTokenMalformed)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Enc string `json:"enc"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return "", fmt.Errorf("%w: bad JWE header", jwt.ErrTokenMalformed)
+	}
+	if header.Alg != jweAlg || header.Enc != jweEnc {
+		return "", fmt.Errorf("%w: unsupported JWE algorithm %s/%s", jwt.ErrTokenUnverifiable, header.Alg, header.Enc)
+	}
+
+	iv, err1 := enc.DecodeString(parts[2])
+	ciphertext, err2 := enc.DecodeString(parts[3])
+	tag, err3 := enc.DecodeString(parts[4])
+	if err := errors.Join(err1, err2, err3); err != nil || len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+		return "", fmt.Errorf("%w: bad JWE encoding", jwt.ErrTokenMalformed)
+	}
+
+	plain, err := aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", errTokenUndecryptable
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// Token rejection reasons, used as the reason label on
+// sentinel_token_validation_failures_total.
+const (
+	ReasonMissing      = "missing"
+	ReasonMalformed    = "malformed"
+	ReasonBadSignature = "bad_signature"
+	ReasonExpired      = "expired"
+	ReasonNotYetValid  = "not_yet_valid"
+	ReasonWrongType    = "wrong_type"
+	ReasonRevoked      = "revoked"
+	ReasonCanary       = "canary"
+	ReasonInvalid      = "invalid"
+)
+
+var (
+	tokensIssued = metrics.NewCounterVec(
+		"sentinel_tokens_issued_total",
+		"JWTs issued, by token type.",
+		"type",
+	)
+	tokenValidationFailures = metrics.NewCounterVec(
+		"sentinel_token_validation_failures_total",
+		"Tokens rejected during validation, by reason.",
+		"reason",
+	)
+	previousSecretTokens = metrics.NewCounterVec(
+		"sentinel_tokens_previous_secret_total",
+		"Tokens accepted because they were signed with JWT_SECRET_PREVIOUS, by token type.",
+		"type",
+	)
+	oversizedTokens = metrics.NewCounterVec(
+		"sentinel_tokens_oversized_total",
+		"Tokens not issued because they exceeded TOKEN_MAX_BYTES, by token type.",
+		"type",
+	)
+	passwordHashDuration = metrics.NewHistogramVec(
+		"sentinel_password_hash_duration_seconds",
+		"Time spent in bcrypt, by operation (hash or verify).",
+		[]float64{.025, .05, .1, .2, .3, .5, .75, 1, 2},
+		"operation",
+	)
+)
+
+// RecordTokenRejection counts a token rejected for reason outside of
+// ParseToken, for example a refresh token presented as an access token.
+func RecordTokenRejection(reason string) {
+	tokenValidationFailures.WithLabelValues(reason).Inc()
+}
+
+// RejectionReason classifies a ParseToken error as one of the Reason
+// constants, as counted in sentinel_token_validation_failures_total.
+func RejectionReason(err error) string {
+	switch {
+	case errors.Is(err, errTokenEmpty):
+		return ReasonMissing
+	case errors.Is(err, jwt.ErrTokenExpired), errors.Is(err, errTokenExpired):
+		return ReasonExpired
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable),
+		errors.Is(err, errTokenUndecryptable):
+		return ReasonBadSignature
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return ReasonMalformed
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, errTokenFromFuture):
+		return ReasonNotYetValid
+	case errors.Is(err, ErrCanaryToken):
+		return ReasonCanary
+	case errors.Is(err, ErrTokenRevoked):
+		return ReasonRevoked
+	}
+	return ReasonInvalid
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// OTPDigits is the length of codes sent by text message.
+const OTPDigits = 6
+
+var otpLimit = big.NewInt(1_000_000)
+
+// GenerateOTP returns a random OTPDigits-digit one-time code. The code space
+// is small, so callers must expire codes quickly and limit attempts.
+func GenerateOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, otpLimit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", OTPDigits, n.Int64()), nil
+}
+
+// HashOTP returns the stored form of a one-time code bound to userID, so a
+// hash copied between users does not verify. Spaces are ignored.
+func HashOTP(userID int64, code string) string {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	sum := sha256.Sum256([]byte(strconv.FormatInt(userID, 10) + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
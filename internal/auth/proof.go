@@ -0,0 +1,54 @@
+package auth
+
+import "net/http"
+
+// Confirmation is the cnf claim of a sender-constrained token: the key the
+// client must prove it holds to use the token.
+type Confirmation struct {
+	// JKT is the RFC 7638 SHA-256 thumbprint of the client's DPoP public
+	// key.
+	JKT string `json:"jkt"`
+}
+
+// BoundKey returns the thumbprint of the key c's token is bound to, or ""
+// for a plain bearer token.
+func (c *Claims) BoundKey() string {
+	if c.Confirmation == nil {
+		return ""
+	}
+	return c.Confirmation.JKT
+}
+
+// confirmation returns the cnf claim binding a token to jkt, or nil when
+// jkt is empty.
+func confirmation(jkt string) *Confirmation {
+	if jkt == "" {
+		return nil
+	}
+	return &Confirmation{JKT: jkt}
+}
+
+// ProofVerifier checks that the request presenting a key-bound token
+// carries a proof of possession of that key.
+type ProofVerifier interface {
+	VerifyProof(r *http.Request, token string, c *Claims) error
+}
+
+// SetProofVerifier installs the verifier consulted for key-bound tokens;
+// nil removes it, after which such tokens cannot be used.
+func (a *Auth) SetProofVerifier(v ProofVerifier) {
+	if v == nil {
+		a.proofs.Store(nil)
+		return
+	}
+	a.proofs.Store(&v)
+}
+
+// ProofVerifier returns the verifier installed with SetProofVerifier, or
+// nil.
+func (a *Auth) ProofVerifier() ProofVerifier {
+	if v := a.proofs.Load(); v != nil {
+		return *v
+	}
+	return nil
+}
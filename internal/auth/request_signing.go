@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+)
+
+// Headers carrying a request signature; see SignRequest.
+const (
+	SignatureTimestampHeader = "X-Sentinel-Timestamp"
+	SignatureNonceHeader     = "X-Sentinel-Nonce"
+	SignatureHeader          = "X-Sentinel-Request-Signature"
+)
+
+// signingKeyLabel separates request signing keys from other uses of the
+// JWT secret.
+const signingKeyLabel = "sentinel request signing\x00"
+
+// SigningKey returns the request signing key for the access token with ID
+// jti. It is derived from the JWT secret, so every instance computes the
+// same key without storing it, and it cannot be worked out from the token
+// alone: a stolen token cannot sign requests.
+func (a *Auth) SigningKey(jti string) string {
+	return signingKey(a.secret, jti)
+}
+
+func signingKey(secret, jti string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingKeyLabel + jti))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest returns the signature of a request: the hex HMAC-SHA256,
+// keyed with key, of the timestamp (Unix seconds), nonce, method, request
+// URI (path and query), and hex SHA-256 of the body, joined by newlines.
+func SignRequest(key string, timestamp int64, nonce, method, uri string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "\n" + nonce + "\n" + method + "\n" + uri + "\n" + hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyRequestSignature reports whether signature was made by SignRequest
+// with the signing key for jti. Keys derived from the previous JWT secret
+// are accepted too, as its tokens are.
+func (a *Auth) VerifyRequestSignature(jti, signature string, timestamp int64, nonce, method, uri string, body []byte) bool {
+	for _, secret := range []string{a.secret, a.previous} {
+		if secret == "" {
+			continue
+		}
+		want := SignRequest(signingKey(secret, jti), timestamp, nonce, method, uri, body)
+		if hmac.Equal([]byte(want), []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
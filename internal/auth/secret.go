@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"math"
+
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// DefaultMinSecretBits is the estimated entropy below which a JWT secret
+// is considered weak: 128 bits, the strength HS256 is meant to provide.
+const DefaultMinSecretBits = 128
+
+var secretEntropy = metrics.NewGaugeVec(
+	"sentinel_jwt_secret_entropy_bits",
+	"Estimated entropy of the JWT signing secret in use.",
+)
+
+// SecretEntropyBits estimates the entropy of secret in bits from the
+// frequency of its characters: the Shannon entropy per character times the
+// length. It is a conservative estimate for random secrets and exposes
+// repetitive ones, but cannot tell a random secret from a long phrase.
+func SecretEntropyBits(secret string) float64 {
+	if secret == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range secret {
+		counts[r]++
+		n++
+	}
+	var perChar float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		perChar -= p * math.Log2(p)
+	}
+	return perChar * float64(n)
+}
@@ -0,0 +1,179 @@
+// Package backchannel sends OpenID Connect Back-Channel Logout
+// notifications: when a user's sessions end in Sentinel, every registered
+// client receives a signed logout token at its logout URI so it can end its
+// own sessions for that user.
+package backchannel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mayvqt/Sentinel/internal/httpclient"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// LogoutEvent is the events claim member that marks a logout token.
+const LogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// TokenType is the JWT typ header of logout tokens.
+const TokenType = "logout+jwt"
+
+// TokenTTL is how long a logout token is valid after it is issued.
+const TokenTTL = 2 * time.Minute
+
+// SendTimeout bounds delivery to one client, including retries.
+const SendTimeout = 30 * time.Second
+
+// MinSecretLength is the shortest client secret accepted; logout tokens are
+// signed with it using HS256.
+const MinSecretLength = 32
+
+var deliveries = metrics.NewCounterVec(
+	"sentinel_backchannel_logout_total",
+	"Back-channel logout notifications by client and result.",
+	"client", "result",
+)
+
+// Deliveries are retried: each logout token has a unique jti, so clients
+// can recognize a repeated one.
+var httpClient = sync.OnceValue(func() *http.Client {
+	return httpclient.New("backchannel", httpclient.Options{
+		Timeout:            SendTimeout,
+		MaxRetries:         3,
+		RetryNonIdempotent: true,
+	})
+})
+
+// Client is a relying party registered for back-channel logout.
+type Client struct {
+	ID        string
+	LogoutURI string
+	// Secret signs the client's logout tokens (HS256).
+	Secret string
+}
+
+// ParseClients parses a semicolon-separated list of client entries of the
+// form "client_id logout_uri secret", e.g.
+// "billing https://billing.example.com/logout s3cr3t…".
+func ParseClients(spec string) ([]Client, error) {
+	var clients []Client
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("back-channel logout client entry %q must be \"client_id logout_uri secret\"", fields[0])
+		}
+		c := Client{ID: fields[0], LogoutURI: fields[1], Secret: fields[2]}
+		if seen[c.ID] {
+			return nil, fmt.Errorf("duplicate back-channel logout client %q", c.ID)
+		}
+		seen[c.ID] = true
+		u, err := url.Parse(c.LogoutURI)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.Fragment != "" {
+			return nil, fmt.Errorf("back-channel logout client %q has an invalid logout URI", c.ID)
+		}
+		if len(c.Secret) < MinSecretLength {
+			return nil, fmt.Errorf("back-channel logout client %q secret must be at least %d characters", c.ID, MinSecretLength)
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+// Notifier delivers logout tokens to a fixed set of clients.
+type Notifier struct {
+	issuer  string
+	clients []Client
+}
+
+// New returns a Notifier issuing tokens as issuer (Sentinel's public URL).
+func New(issuer string, clients []Client) *Notifier {
+	return &Notifier{issuer: issuer, clients: clients}
+}
+
+// Len returns the number of registered clients.
+func (n *Notifier) Len() int {
+	if n == nil {
+		return 0
+	}
+	return len(n.clients)
+}
+
+// Notify tells every client that subject's sessions have ended, delivering
+// to clients concurrently. It returns once all deliveries have finished,
+// with the failures joined.
+func (n *Notifier) Notify(ctx context.Context, subject string) error {
+	if n.Len() == 0 {
+		return nil
+	}
+	errs := make([]error, len(n.clients))
+	var wg sync.WaitGroup
+	for i, c := range n.clients {
+		wg.Go(func() {
+			err := n.send(ctx, c, subject)
+			result := "sent"
+			if err != nil {
+				result = "error"
+				errs[i] = fmt.Errorf("client %s: %w", c.ID, err)
+			}
+			deliveries.WithLabelValues(c.ID, result).Inc()
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) send(ctx context.Context, c Client, subject string) error {
+	token, err := n.LogoutToken(c, subject, time.Now())
+	if err != nil {
+		return err
+	}
+	form := url.Values{"logout_token": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.LogoutURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// LogoutToken returns the signed logout token for subject addressed to c.
+func (n *Notifier) LogoutToken(c Client, subject string, now time.Time) (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss":    n.issuer,
+		"aud":    c.ID,
+		"sub":    subject,
+		"iat":    now.Unix(),
+		"exp":    now.Add(TokenTTL).Unix(),
+		"jti":    hex.EncodeToString(id[:]),
+		"events": map[string]interface{}{LogoutEvent: map[string]interface{}{}},
+	})
+	t.Header["typ"] = TokenType
+	return t.SignedString([]byte(c.Secret))
+}
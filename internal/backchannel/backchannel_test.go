@@ -0,0 +1,71 @@
+package backchannel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+func TestParseClients(t *testing.T) {
+	clients, err := ParseClients("billing https://billing.example.com/logout " + secret + "; shop http://shop.internal/bcl " + secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 || clients[0].ID != "billing" || clients[1].LogoutURI != "http://shop.internal/bcl" {
+		t.Errorf("unexpected clients %+v", clients)
+	}
+	for _, spec := range []string{
+		"billing https://billing.example.com/logout",
+		"billing ftp://billing.example.com/logout " + secret,
+		"billing https://billing.example.com/logout short",
+		"a https://a.example.com " + secret + "; a https://b.example.com " + secret,
+	} {
+		if _, err := ParseClients(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var calls atomic.Int32
+	var claims jwt.MapClaims
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		token, err := jwt.Parse(r.FormValue("logout_token"), func(tok *jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience("billing"), jwt.WithIssuer("https://auth.example.com"))
+		if err != nil || token.Header["typ"] != TokenType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		claims = token.Claims.(jwt.MapClaims)
+	}))
+	defer srv.Close()
+
+	n := New("https://auth.example.com", []Client{{ID: "billing", LogoutURI: srv.URL, Secret: secret}})
+	if err := n.Notify(context.Background(), "42"); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected a failed delivery to be retried, got %d calls", calls.Load())
+	}
+	events, _ := claims["events"].(map[string]interface{})
+	if claims["sub"] != "42" || claims["jti"] == nil || events[LogoutEvent] == nil {
+		t.Errorf("unexpected logout token claims %v", claims)
+	}
+
+	bad := New("https://auth.example.com", []Client{{ID: "gone", LogoutURI: srv.URL + "/missing", Secret: "wrong"}})
+	if err := bad.Notify(context.Background(), "42"); err == nil || !strings.Contains(err.Error(), "client gone") {
+		t.Errorf("expected a rejected delivery to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+// Package canary recognizes honeypot credentials: bait accounts and tokens
+// that administrators plant where only an attacker would find them, such
+// as a staging database or a config file. No legitimate client ever uses
+// one, so every use is recorded in the audit log and raised immediately as
+// an alert carrying the request's details.
+//
+// The set of canaries is held in memory so every authenticated request can
+// be checked without a database round trip, and is reloaded periodically
+// to pick up canaries registered on other instances.
+package canary
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/alerting"
+	"github.com/mayvqt/Sentinel/internal/audit"
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/syncloop"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+)
+
+// Ways a canary is used, recorded with each trip.
+const (
+	ViaLogin     = "login"
+	ViaMagicLink = "magic_link"
+	ViaToken     = "token"
+)
+
+// AlertRule names the alert raised when a canary is used.
+const AlertRule = "canary_tripped"
+
+// AuditTarget is the target type of canary audit events. Trips are
+// recorded with the action "canary." followed by how the canary was used,
+// e.g. "canary.login".
+const AuditTarget = "canary"
+
+// alertTimeout bounds the delivery of one trip's alert to all notifiers.
+const alertTimeout = time.Minute
+
+var trips = metrics.NewCounterVec(
+	"sentinel_canary_trips_total",
+	"Uses of canary credentials, by canary kind and how they were used.",
+	"kind", "via",
+)
+
+// Source lists registered canaries. store.Store satisfies it.
+type Source interface {
+	ListCanaries(ctx context.Context) ([]models.Canary, error)
+}
+
+// Recorder appends to the audit log. store.Store satisfies it.
+type Recorder interface {
+	RecordAudit(ctx context.Context, e *models.AuditEvent) error
+}
+
+// Trip describes one use of a canary and the request that made it.
+type Trip struct {
+	Canary    models.Canary
+	Via       string
+	IP        string
+	UserAgent string
+	Method    string
+	Path      string
+	Host      string
+	RequestID string
+	At        time.Time
+}
+
+// Alert returns the alert raised for t.
+func (t Trip) Alert() alerting.Alert {
+	target := "token " + t.Canary.TokenID
+	if t.Canary.Kind == models.CanaryAccount {
+		target = "account " + strconv.FormatInt(t.Canary.UserID, 10)
+	}
+	details := map[string]string{
+		"canary_id":  strconv.FormatInt(t.Canary.ID, 10),
+		"kind":       t.Canary.Kind,
+		"via":        t.Via,
+		"ip":         t.IP,
+		"user_agent": t.UserAgent,
+		"method":     t.Method,
+		"path":       t.Path,
+		"host":       t.Host,
+		"request_id": t.RequestID,
+		"note":       t.Canary.Note,
+	}
+	for k, v := range details {
+		if v == "" {
+			delete(details, k)
+		}
+	}
+	return alerting.Alert{
+		Rule:        AlertRule,
+		Description: "canary " + target + " used via " + t.Via + " from " + t.IP + " (credentials may have leaked)",
+		FiredAt:     t.At,
+		Details:     details,
+	}
+}
+
+// Registry is the set of registered canaries. It is safe for concurrent
+// use.
+type Registry struct {
+	audit  Recorder
+	alerts *alerting.Manager
+	now    func() time.Time
+
+	mu  sync.Mutex // serializes writers of set
+	set atomic.Pointer[set]
+}
+
+type set struct {
+	tokens   map[string]models.Canary // by jti
+	accounts map[int64]models.Canary  // by user ID
+}
+
+// New returns an empty registry that records trips through rec and raises
+// them with alerts. Either may be nil; trips are always logged and counted.
+func New(rec Recorder, alerts *alerting.Manager) *Registry {
+	r := &Registry{audit: rec, alerts: alerts, now: time.Now}
+	r.set.Store(newSet(nil))
+	return r
+}
+
+func newSet(canaries []models.Canary) *set {
+	s := &set{tokens: make(map[string]models.Canary), accounts: make(map[int64]models.Canary)}
+	for _, c := range canaries {
+		switch {
+		case c.Kind == models.CanaryToken && c.TokenID != "":
+			s.tokens[c.TokenID] = c
+		case c.Kind == models.CanaryAccount && c.UserID > 0:
+			s.accounts[c.UserID] = c
+		}
+	}
+	return s
+}
+
+// all returns the canaries in s.
+func (s *set) all() []models.Canary {
+	canaries := make([]models.Canary, 0, len(s.tokens)+len(s.accounts))
+	for _, c := range s.tokens {
+		canaries = append(canaries, c)
+	}
+	for _, c := range s.accounts {
+		canaries = append(canaries, c)
+	}
+	return canaries
+}
+
+// Sync replaces the registry's contents with the canaries listed by src.
+func (r *Registry) Sync(ctx context.Context, src Source) error {
+	canaries, err := src.ListCanaries(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.set.Store(newSet(canaries))
+	return nil
+}
+
+// Run keeps the registry in sync with src until ctx is canceled.
+func (r *Registry) Run(ctx context.Context, src Source, interval time.Duration) {
+	syncloop.Run(ctx, interval, "Canary", func(ctx context.Context) error {
+		return r.Sync(ctx, src)
+	})
+}
+
+// Add registers c with this instance immediately, rather than at the next
+// sync.
+func (r *Registry) Add(c models.Canary) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.set.Store(newSet(append(r.set.Load().all(), c)))
+}
+
+// Remove drops the canary with the given ID.
+func (r *Registry) Remove(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kept []models.Canary
+	for _, c := range r.set.Load().all() {
+		if c.ID != id {
+			kept = append(kept, c)
+		}
+	}
+	r.set.Store(newSet(kept))
+}
+
+// Contains reports whether jti is a canary token. It lets the registry be
+// installed with auth.Auth.SetCanaries.
+func (r *Registry) Contains(jti string) bool {
+	_, ok := r.set.Load().tokens[jti]
+	return ok
+}
+
+// Account returns the canary for the bait account userID, if it is one.
+func (r *Registry) Account(userID int64) (models.Canary, bool) {
+	c, ok := r.set.Load().accounts[userID]
+	return c, ok
+}
+
+// ReportCanaryToken trips the canary token presented with req. It
+// implements middleware.CanaryReporter.
+func (r *Registry) ReportCanaryToken(req *http.Request, claims *auth.Claims) {
+	if c, ok := r.set.Load().tokens[claims.ID]; ok {
+		r.Trip(req, c, ViaToken)
+	}
+}
+
+// Trip records that c was used via the given route by req: it is counted,
+// logged, and audited at once, and the alert is sent in the background so
+// the response is not delayed in a way the client could notice.
+func (r *Registry) Trip(req *http.Request, c models.Canary, via string) {
+	t := Trip{
+		Canary:    c,
+		Via:       via,
+		IP:        middleware.ClientIP(req),
+		UserAgent: audit.UserAgent(req.UserAgent()),
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		Host:      req.Host,
+		RequestID: tracing.RequestIDFromContext(req.Context()),
+		At:        r.now().UTC(),
+	}
+	trips.WithLabelValues(c.Kind, via).Inc()
+	logger.FromContext(req.Context()).Warn("Canary credential used", map[string]interface{}{
+		"canary_id": c.ID,
+		"kind":      c.Kind,
+		"via":       via,
+		"ip":        t.IP,
+	})
+
+	if r.audit != nil {
+		err := r.audit.RecordAudit(req.Context(), &models.AuditEvent{
+			ActorID:    c.UserID,
+			Action:     AuditTarget + "." + via,
+			TargetType: AuditTarget,
+			TargetID:   c.ID,
+			RequestID:  t.RequestID,
+			TokenID:    c.TokenID,
+			IP:         t.IP,
+			UserAgent:  t.UserAgent,
+			CreatedAt:  t.At,
+		})
+		if err != nil {
+			logger.FromContext(req.Context()).Error("Failed to record canary trip", map[string]interface{}{
+				"canary_id": c.ID,
+				"error":     err.Error(),
+			})
+		}
+	}
+
+	if r.alerts != nil {
+		ctx := context.WithoutCancel(req.Context())
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, alertTimeout)
+			defer cancel()
+			r.alerts.Fire(ctx, t.Alert())
+		}()
+	}
+}
@@ -0,0 +1,94 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mayvqt/Sentinel/internal/alerting"
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	"github.com/mayvqt/Sentinel/internal/models"
+)
+
+type fakeStore struct {
+	canaries []models.Canary
+	events   []models.AuditEvent
+}
+
+func (f *fakeStore) ListCanaries(ctx context.Context) ([]models.Canary, error) {
+	return f.canaries, nil
+}
+
+func (f *fakeStore) RecordAudit(ctx context.Context, e *models.AuditEvent) error {
+	f.events = append(f.events, *e)
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	src := &fakeStore{canaries: []models.Canary{
+		{ID: 1, Kind: models.CanaryAccount, UserID: 7},
+		{ID: 2, Kind: models.CanaryToken, TokenID: "bait"},
+	}}
+	r := New(nil, nil)
+	if err := r.Sync(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Contains("bait") || r.Contains("other") {
+		t.Error("expected only the canary token to be recognized")
+	}
+	if c, ok := r.Account(7); !ok || c.ID != 1 {
+		t.Errorf("expected account 7 to be a canary, got %+v", c)
+	}
+
+	r.Add(models.Canary{ID: 3, Kind: models.CanaryToken, TokenID: "fresh"})
+	r.Remove(1)
+	if !r.Contains("fresh") || !r.Contains("bait") {
+		t.Error("expected an added canary to be recognized at once")
+	}
+	if _, ok := r.Account(7); ok {
+		t.Error("expected a removed canary to be forgotten")
+	}
+}
+
+func TestTrip(t *testing.T) {
+	alerts := make(chan alerting.Alert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a alerting.Alert
+		json.NewDecoder(r.Body).Decode(&a)
+		alerts <- a
+	}))
+	defer srv.Close()
+
+	rec := &fakeStore{}
+	manager := alerting.NewManager(metrics.NewRegistry(), nil, []alerting.Notifier{&alerting.Webhook{URL: srv.URL}}, 0, 0)
+	r := New(rec, manager)
+	r.Add(models.Canary{ID: 5, Kind: models.CanaryToken, TokenID: "bait", Note: "planted in .env"})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/profile", nil)
+	req.RemoteAddr = "203.0.113.7:4242"
+	req.Header.Set("User-Agent", "curl/8.0")
+	counter := trips.WithLabelValues(models.CanaryToken, ViaToken)
+	before := counter.Value()
+	r.ReportCanaryToken(req, &auth.Claims{})
+	r.ReportCanaryToken(req, &auth.Claims{RegisteredClaims: jwt.RegisteredClaims{ID: "bait"}})
+
+	if counter.Value() != before+1 {
+		t.Errorf("expected one trip to be counted, got %v", counter.Value()-before)
+	}
+	if len(rec.events) != 1 || rec.events[0].Action != "canary.token" || rec.events[0].TargetID != 5 || rec.events[0].IP != "203.0.113.7" {
+		t.Errorf("unexpected audit events %+v", rec.events)
+	}
+	select {
+	case a := <-alerts:
+		if a.Rule != AlertRule || a.Details["ip"] != "203.0.113.7" || a.Details["path"] != "/api/auth/profile" || a.Details["note"] != "planted in .env" {
+			t.Errorf("unexpected alert %+v", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an alert to be sent")
+	}
+}
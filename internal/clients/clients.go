@@ -0,0 +1,218 @@
+// Package clients holds the registry of API consumers, such as mobile
+// apps and server daemons, that need a token policy other than the
+// server's: their own token lifetimes, refresh policy, scopes, and CORS
+// origins. Clients are registered by administrators and stored in the
+// database; a client names itself with the client_id it sends at login,
+// and the tokens issued to it carry that ID so the policy is applied again
+// at refresh.
+//
+// Clients are held in memory, as they are consulted on every login,
+// refresh, and cross-origin request, and reloaded periodically to pick up
+// changes made on other instances.
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/syncloop"
+)
+
+// Bounds of the token lifetimes a client may be given.
+const (
+	MaxAccessTokenTTL  = 24 * time.Hour
+	MaxRefreshTokenTTL = 365 * 24 * time.Hour
+)
+
+// maxIDLength caps client IDs and scopes.
+const maxIDLength = 64
+
+// Store holds registered clients. store.Store satisfies it.
+type Store interface {
+	ListClients(ctx context.Context) ([]models.Client, error)
+}
+
+// ValidID reports an error unless id is 1 to 64 letters, digits, dots,
+// hyphens, or underscores.
+func ValidID(id string) error {
+	if id == "" || len(id) > maxIDLength || strings.IndexFunc(id, func(r rune) bool { return !idChar(r) }) >= 0 {
+		return fmt.Errorf("client ID must be 1 to %d letters, digits, dots, hyphens, or underscores", maxIDLength)
+	}
+	return nil
+}
+
+func idChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '_'
+}
+
+// Validate reports what is wrong with c's policy, if anything.
+func Validate(c *models.Client) error {
+	switch {
+	case c.AccessTokenTTL < 0 || time.Duration(c.AccessTokenTTL)*time.Second > MaxAccessTokenTTL:
+		return fmt.Errorf("access_token_ttl must be between 0 and %d seconds", int(MaxAccessTokenTTL.Seconds()))
+	case c.RefreshTokenTTL < 0 || time.Duration(c.RefreshTokenTTL)*time.Second > MaxRefreshTokenTTL:
+		return fmt.Errorf("refresh_token_ttl must be between 0 and %d seconds", int(MaxRefreshTokenTTL.Seconds()))
+	case c.RefreshPolicy != "" && c.RefreshPolicy != models.RefreshFixed && c.RefreshPolicy != models.RefreshSliding:
+		return fmt.Errorf("refresh_policy must be %q, %q, or empty", models.RefreshFixed, models.RefreshSliding)
+	}
+	for _, scope := range c.AllowedScopes {
+		if scope == "" || len(scope) > maxIDLength || strings.ContainsAny(scope, " \t,") {
+			return fmt.Errorf("scopes must be 1 to %d characters without spaces or commas", maxIDLength)
+		}
+	}
+	for _, origin := range c.CORSOrigins {
+		if err := validOrigin(origin); err != nil {
+			return fmt.Errorf("invalid CORS origin %q: %w", origin, err)
+		}
+	}
+	return nil
+}
+
+// validOrigin reports an error unless origin is an http or https origin:
+// a scheme and host with no path.
+func validOrigin(origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+		u.Path != "" || u.RawQuery != "" || u.Fragment != "" || strings.Contains(origin, ",") {
+		return errors.New("must be a scheme and host, such as https://app.example.com")
+	}
+	if origin != strings.ToLower(origin) {
+		return errors.New("must be lower case, as browsers send it")
+	}
+	return nil
+}
+
+// GrantScope returns the scope granted to c for the space-delimited
+// requested scope: all of its allowed scopes when requested is empty, and
+// otherwise the requested ones, which must all be allowed.
+func GrantScope(c *models.Client, requested string) (string, error) {
+	fields := strings.Fields(requested)
+	if len(fields) == 0 {
+		return strings.Join(c.AllowedScopes, " "), nil
+	}
+	var granted []string
+	for _, scope := range fields {
+		if !slices.Contains(c.AllowedScopes, scope) {
+			return "", fmt.Errorf("client %s may not request scope %q", c.ClientID, scope)
+		}
+		if !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// NarrowScope returns the part of a scope granted earlier that c is still
+// allowed, for tokens refreshed after its allowed scopes were reduced.
+func NarrowScope(c *models.Client, scope string) string {
+	var kept []string
+	for _, s := range strings.Fields(scope) {
+		if slices.Contains(c.AllowedScopes, s) {
+			kept = append(kept, s)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// registry is the in-memory copy of the clients, indexed for lookups.
+type registry struct {
+	byID    map[string]models.Client
+	origins map[string]bool
+}
+
+func newRegistry(byID map[string]models.Client) *registry {
+	reg := &registry{byID: byID, origins: map[string]bool{}}
+	for _, c := range byID {
+		for _, origin := range c.CORSOrigins {
+			reg.origins[origin] = true
+		}
+	}
+	return reg
+}
+
+// Registry looks up registered clients. It is safe for concurrent use.
+type Registry struct {
+	store Store
+
+	mu      sync.Mutex // serializes writers of current
+	current atomic.Pointer[registry]
+}
+
+// New returns a registry with no clients that loads them from s.
+func New(s Store) *Registry {
+	r := &Registry{store: s}
+	r.current.Store(newRegistry(map[string]models.Client{}))
+	return r
+}
+
+// Sync replaces the registry's clients with those in its store.
+func (r *Registry) Sync(ctx context.Context) error {
+	list, err := r.store.ListClients(ctx)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]models.Client, len(list))
+	for _, c := range list {
+		byID[c.ClientID] = c
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current.Store(newRegistry(byID))
+	return nil
+}
+
+// Run keeps the registry in sync with the store until ctx is canceled.
+func (r *Registry) Run(ctx context.Context, interval time.Duration) {
+	syncloop.Run(ctx, interval, "Client registry", r.Sync)
+}
+
+// Set applies c on this instance immediately, rather than at the next
+// sync.
+func (r *Registry) Set(c models.Client) {
+	r.update(func(byID map[string]models.Client) { byID[c.ClientID] = c })
+}
+
+// Remove unregisters clientID on this instance immediately.
+func (r *Registry) Remove(clientID string) {
+	r.update(func(byID map[string]models.Client) { delete(byID, clientID) })
+}
+
+// update replaces the clients with a modified copy.
+func (r *Registry) update(fn func(map[string]models.Client)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	byID := maps.Clone(r.current.Load().byID)
+	fn(byID)
+	r.current.Store(newRegistry(byID))
+}
+
+// Get returns the client registered as clientID. A nil Registry has no
+// clients.
+func (r *Registry) Get(clientID string) (*models.Client, bool) {
+	if r == nil {
+		return nil, false
+	}
+	c, ok := r.current.Load().byID[clientID]
+	if !ok {
+		return nil, false
+	}
+	return &c, true
+}
+
+// AllowsOrigin reports whether a registered client lists origin among its
+// CORS origins.
+func (r *Registry) AllowsOrigin(origin string) bool {
+	return r != nil && origin != "" && r.current.Load().origins[origin]
+}
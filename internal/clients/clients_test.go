@@ -0,0 +1,101 @@
+package clients
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+func TestValidID(t *testing.T) {
+	for _, ok := range []string{"mobile", "billing-daemon.v2", "web_app"} {
+		if err := ValidID(ok); err != nil {
+			t.Errorf("expected %q to be valid: %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"", "a b", "app/1", "ünicode"} {
+		if err := ValidID(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	good := &models.Client{
+		AccessTokenTTL:  300,
+		RefreshTokenTTL: 86400,
+		RefreshPolicy:   models.RefreshSliding,
+		AllowedScopes:   []string{"profile"},
+		CORSOrigins:     []string{"https://app.example.com", "http://localhost:3000"},
+	}
+	if err := Validate(good); err != nil {
+		t.Errorf("expected a valid client: %v", err)
+	}
+	for desc, c := range map[string]*models.Client{
+		"negative ttl":   {AccessTokenTTL: -1},
+		"long ttl":       {AccessTokenTTL: 2 * 24 * 3600},
+		"policy":         {RefreshPolicy: "forever"},
+		"spaced scope":   {AllowedScopes: []string{"a b"}},
+		"wildcard":       {CORSOrigins: []string{"*"}},
+		"origin path":    {CORSOrigins: []string{"https://app.example.com/"}},
+		"origin scheme":  {CORSOrigins: []string{"ftp://app.example.com"}},
+		"origin casing":  {CORSOrigins: []string{"https://App.example.com"}},
+		"empty scope":    {AllowedScopes: []string{""}},
+		"comma in scope": {AllowedScopes: []string{"a,b"}},
+	} {
+		if err := Validate(c); err == nil {
+			t.Errorf("%s: expected an error", desc)
+		}
+	}
+}
+
+func TestScopes(t *testing.T) {
+	c := &models.Client{ClientID: "mobile", AllowedScopes: []string{"profile", "offline"}}
+	if got, err := GrantScope(c, ""); err != nil || got != "profile offline" {
+		t.Errorf("expected every allowed scope by default, got %q (%v)", got, err)
+	}
+	if got, err := GrantScope(c, " offline  offline "); err != nil || got != "offline" {
+		t.Errorf("expected the requested scope, got %q (%v)", got, err)
+	}
+	if _, err := GrantScope(c, "profile admin"); err == nil {
+		t.Error("expected a scope the client is not allowed to be refused")
+	}
+	c.AllowedScopes = []string{"offline"}
+	if got := NarrowScope(c, "profile offline"); got != "offline" {
+		t.Errorf("expected the scope to be narrowed, got %q", got)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemStore()
+	s.SetClient(ctx, &models.Client{ClientID: "web", CORSOrigins: []string{"https://app.example.com"}})
+	r := New(s)
+	if r.AllowsOrigin("https://app.example.com") {
+		t.Error("expected no origins before the first sync")
+	}
+	if err := r.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := r.Get("web"); !ok || c.ClientID != "web" {
+		t.Errorf("expected the client to be loaded, got %+v", c)
+	}
+	if !r.AllowsOrigin("https://app.example.com") || r.AllowsOrigin("https://evil.example.com") || r.AllowsOrigin("") {
+		t.Error("expected only the registered origin to be allowed")
+	}
+
+	r.Set(models.Client{ClientID: "web", CORSOrigins: []string{"https://new.example.com"}})
+	if r.AllowsOrigin("https://app.example.com") || !r.AllowsOrigin("https://new.example.com") {
+		t.Error("expected Set to replace the client's origins")
+	}
+	r.Remove("web")
+	if _, ok := r.Get("web"); ok || r.AllowsOrigin("https://new.example.com") {
+		t.Error("expected Remove to unregister the client")
+	}
+
+	var none *Registry
+	if _, ok := none.Get("web"); ok || none.AllowsOrigin("https://app.example.com") {
+		t.Error("expected a nil registry to have no clients")
+	}
+}
@@ -0,0 +1,155 @@
+// Package clockdrift watches the local clock against external time
+// sources. Token expiry and issue times are only meaningful while the
+// clocks of the instances issuing and checking them agree, so drift beyond
+// a threshold is logged and exported, and can widen the tolerated token
+// clock skew until it is corrected.
+package clockdrift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/doctor"
+	"github.com/mayvqt/Sentinel/internal/httpclient"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// QueryTimeout bounds the query of each source.
+const QueryTimeout = 5 * time.Second
+
+var (
+	clockOffset = metrics.NewGaugeVec(
+		"sentinel_clock_offset_seconds",
+		"How far the local clock is ahead of the time sources (negative when behind), as of the latest check.",
+	)
+	clockDrifted = metrics.NewGaugeVec(
+		"sentinel_clock_drift_exceeded",
+		"Whether the latest clock offset exceeded the drift threshold (1) or not (0).",
+	)
+	clockChecks = metrics.NewCounterVec(
+		"sentinel_clock_drift_checks_total",
+		"Clock drift checks by result (ok, drift, or error when no source answered).",
+		"result",
+	)
+)
+
+// Monitor periodically measures the local clock's offset from Sources.
+type Monitor struct {
+	// Sources are NTP servers (host or host:port) and HTTPS URLs whose
+	// Date header is read. The median of the offsets that answer is used.
+	Sources []string
+	// Threshold is the offset, either way, beyond which the clock drifts.
+	Threshold time.Duration
+	// Adjust, when set, is called with each measured offset, such as to
+	// widen the token clock skew while the clock drifts.
+	Adjust func(offset time.Duration)
+	// Client fetches HTTPS sources; nil uses a client without retries,
+	// which would skew the measurement.
+	Client *http.Client
+}
+
+// Run checks the clock now and then every interval until ctx is canceled.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	if m.Client == nil {
+		m.Client = httpclient.New("clock", httpclient.Options{Timeout: QueryTimeout, MaxRetries: -1})
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.Check(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Clock drift check failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check measures the clock's offset once, records it, and logs drift
+// beyond the threshold. It fails only when no source answers.
+func (m *Monitor) Check(ctx context.Context) (time.Duration, error) {
+	var offsets []time.Duration
+	var errs []error
+	for _, source := range m.Sources {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		offset, err := Query(qctx, m.Client, source)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source, err))
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	if len(offsets) == 0 {
+		clockChecks.WithLabelValues("error").Inc()
+		return 0, errors.Join(errs...)
+	}
+	slices.Sort(offsets)
+	offset := offsets[len(offsets)/2]
+
+	clockOffset.WithLabelValues().Set(offset.Seconds())
+	if offset.Abs() > m.Threshold {
+		clockDrifted.WithLabelValues().Set(1)
+		clockChecks.WithLabelValues("drift").Inc()
+		logger.Warn("Clock drift exceeds threshold", map[string]interface{}{
+			"offset_ms":    offset.Milliseconds(),
+			"threshold_ms": m.Threshold.Milliseconds(),
+			"sources":      len(offsets),
+		})
+	} else {
+		clockDrifted.WithLabelValues().Set(0)
+		clockChecks.WithLabelValues("ok").Inc()
+	}
+	if m.Adjust != nil {
+		m.Adjust(offset)
+	}
+	return offset, nil
+}
+
+// Query returns how far the local clock is ahead of source: an HTTP(S) URL,
+// whose Date header is compared, or an NTP server.
+func Query(ctx context.Context, client *http.Client, source string) (time.Duration, error) {
+	if !strings.HasPrefix(source, "https://") && !strings.HasPrefix(source, "http://") {
+		return doctor.QueryNTP(ctx, source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, source, nil)
+	if err != nil {
+		return 0, err
+	}
+	t0 := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	t1 := time.Now()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.New("response has no valid Date header")
+	}
+	// Date is truncated to the second, so the server's time was on average
+	// half a second later, around the middle of the round trip.
+	local := t0.Add(t1.Sub(t0) / 2)
+	return local.Sub(date.Add(500 * time.Millisecond)), nil
+}
+
+// WidenedSkew returns the token clock skew to tolerate while the clock is
+// offset from its sources: base, plus the offset rounded up to the second
+// when it exceeds threshold, capped at limit.
+func WidenedSkew(base, offset, threshold, limit time.Duration) time.Duration {
+	if offset.Abs() <= threshold {
+		return base
+	}
+	widened := base + offset.Abs().Truncate(time.Second) + time.Second
+	return max(min(widened, limit), base)
+}
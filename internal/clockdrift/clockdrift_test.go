@@ -0,0 +1,65 @@
+package clockdrift
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// dateServer answers with a Date header ahead of the local clock by ahead.
+func dateServer(t *testing.T, ahead time.Duration) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(ahead).UTC().Format(http.TimeFormat))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestCheck(t *testing.T) {
+	var adjusted time.Duration
+	m := &Monitor{
+		Sources: []string{
+			dateServer(t, -time.Minute),
+			dateServer(t, -time.Minute),
+			dateServer(t, time.Hour),
+			"http://127.0.0.1:1",
+		},
+		Threshold: 2 * time.Second,
+		Adjust:    func(offset time.Duration) { adjusted = offset },
+		Client:    http.DefaultClient,
+	}
+	offset, err := m.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	// The median ignores the outlier; Date's one-second resolution leaves
+	// a second of uncertainty.
+	if offset < 59*time.Second || offset > 61*time.Second {
+		t.Errorf("expected the clock to be about a minute ahead, got %v", offset)
+	}
+	if adjusted != offset {
+		t.Errorf("expected Adjust to be called with %v, got %v", offset, adjusted)
+	}
+
+	m.Sources = []string{"http://127.0.0.1:1"}
+	if _, err := m.Check(context.Background()); err == nil {
+		t.Error("expected an error when no source answers")
+	}
+}
+
+func TestWidenedSkew(t *testing.T) {
+	for _, tc := range []struct {
+		offset, want time.Duration
+	}{
+		{time.Second, time.Minute},
+		{-90 * time.Second, 2*time.Minute + 31*time.Second},
+		{time.Hour, 5 * time.Minute},
+	} {
+		if got := WidenedSkew(time.Minute, tc.offset, 2*time.Second, 5*time.Minute); got != tc.want {
+			t.Errorf("offset %v: expected skew %v, got %v", tc.offset, tc.want, got)
+		}
+	}
+}
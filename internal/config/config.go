@@ -2,65 +2,696 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
-
-	"github.com/joho/godotenv"
+	"time"
 )
 
 // Config holds runtime configuration loaded from environment variables.
 type Config struct {
-	Port               string
-	DatabaseURL        string
-	JWTSecret          string
-	TLSCertFile        string
-	TLSKeyFile         string
-	TLSEnabled         bool
+	Port        string
+	DatabaseURL string
+	// JWTSecret is JWT_SECRET, or the contents of JWTSecretFile when that
+	// is set instead.
+	JWTSecret     string
+	JWTSecretFile string
+	// JWTSecretPrevious is JWT_SECRET_PREVIOUS, or the contents of
+	// JWTSecretPreviousFile: the secret JWTSecret replaced. Tokens signed
+	// with it are still accepted until they expire.
+	JWTSecretPrevious     string
+	JWTSecretPreviousFile string
+	// JWTSecretMinBits is the estimated entropy JWTSecret must have. Below
+	// it the server refuses to start in production and warns otherwise.
+	JWTSecretMinBits int
+	// Environment is "development" or "production".
+	Environment string
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSEnabled  bool
+
+	// AdminAddr, when set, moves the admin API and metrics off the public
+	// listener onto this address (e.g. "127.0.0.1:9090").
+	AdminAddr string
+
+	// AdminUIEnabled serves the embedded admin console under /admin/.
+	AdminUIEnabled bool
+
+	// DiagnosticsEnabled exposes pprof, expvar, and heap/goroutine dumps to
+	// admins; dumps are written to DiagnosticsDir.
+	DiagnosticsEnabled bool
+	DiagnosticsDir     string
+
+	// CompressionEnabled compresses textual responses of at least
+	// CompressionMinBytes with Brotli or gzip when clients accept it.
+	CompressionEnabled  bool
+	CompressionMinBytes int
+
+	// Mutual TLS: client certificates are verified against TLSClientCAFile
+	// ("optional" or "required" per TLSClientAuth) and mapped to service
+	// accounts by ServiceAccounts (see mtls.ParseAccounts).
+	TLSClientCAFile    string
+	TLSClientAuth      string
+	ServiceAccounts    string
 	CORSAllowedOrigins []string
+
+	// Read replicas: read-only queries are routed to healthy replicas whose
+	// replication lag is within DatabaseReplicaMaxLag.
+	DatabaseReplicaURLs          []string
+	DatabaseReplicaMaxLag        time.Duration
+	DatabaseReplicaCheckInterval time.Duration
+
+	// DatabaseSlowQueryThreshold logs statements slower than this; zero disables.
+	DatabaseSlowQueryThreshold time.Duration
+
+	// Prometheus metrics endpoint; MetricsToken, when set, is required as a
+	// bearer token to scrape it.
+	MetricsEnabled bool
+	MetricsToken   string
+
+	// Alerting: per-minute thresholds (0 disables a rule) and notification
+	// targets. Alerting runs only when at least one target is configured.
+	AlertFailedLoginsPerMinute  int
+	AlertServerErrorsPerMinute  int
+	AlertRegistrationsPerMinute int
+	AlertCooldown               time.Duration
+	AlertEvaluationInterval     time.Duration
+	AlertWebhookURL             string
+	AlertSlackWebhookURL        string
+	AlertPagerDutyRoutingKey    string
+
+	// Outbound webhooks: comma-separated signing secrets, all of them active
+	// (see webhooks.ParseSecrets), and how long delivery attempts are kept.
+	WebhookSigningSecrets    string
+	WebhookDeliveryRetention time.Duration
+
+	// AnalyticsExportURL, when set, is where daily anonymized analytics
+	// reports are written: a file://, s3://, or http(s):// URL (see
+	// analytics.ParseSink). AnalyticsExportToken authenticates HTTP posts.
+	AnalyticsExportURL   string
+	AnalyticsExportToken string
+
+	// AuditMode is "async" to write login attempts to the audit log in the
+	// background, through a queue of AuditQueueSize events written in
+	// batches of AuditBatchSize, or "sync" to write them before responding.
+	AuditMode      string
+	AuditQueueSize int
+	AuditBatchSize int
+
+	// AuditRetentionDays, when positive, is how many days audit events are
+	// kept. Older events are deleted, after being written to
+	// AuditArchiveURL (an s3:// or file:// URL, see storage.OpenBucket)
+	// when it is set.
+	AuditRetentionDays int
+	AuditArchiveURL    string
+
+	// Chaos testing: the percentage of requests under ChaosPaths (path
+	// prefixes, "/api/" when empty) that are delayed by ChaosLatency,
+	// answered ChaosErrorStatus, or dropped. Refused in production.
+	ChaosLatencyPercent int
+	ChaosLatency        time.Duration
+	ChaosErrorPercent   int
+	ChaosErrorStatus    int
+	ChaosDropPercent    int
+	ChaosPaths          []string
+
+	// Outbound HTTP clients: per-request timeout (including retries), retry
+	// count, and an explicit proxy URL (empty uses HTTP(S)_PROXY).
+	HTTPClientTimeout    time.Duration
+	HTTPClientMaxRetries int
+	HTTPClientProxy      string
+
+	// LogFormat is the application log format, "json" or "console"; empty
+	// means JSON, or console with --dev.
+	LogFormat string
+
+	// ErrorFormat is the default error body, "json" or "problem" (RFC 7807
+	// problem details). Clients can ask for either through Accept.
+	ErrorFormat string
+
+	// Access logs: format is "json", "common", or "combined". AccessLogOutput
+	// is "stdout", "stderr", or a file path; empty keeps JSON access logs in
+	// the application log stream (CLF formats default to stdout).
+	AccessLogFormat string
+	AccessLogOutput string
+
+	// Refresh sessions: token lifetime, sliding mode, and (when sliding) the
+	// hard maximum session length measured from login.
+	RefreshTokenTTL    time.Duration
+	RefreshSliding     bool
+	RefreshMaxLifetime time.Duration
+	// RefreshReuseGrace is how long a retried refresh returns the pair
+	// the first attempt issued; zero disables it.
+	RefreshReuseGrace time.Duration
+	// SessionIdleTimeout, when positive, ends sessions unused for this
+	// long, however long their refresh token has left.
+	SessionIdleTimeout time.Duration
+
+	// TokenEncryptionKey, when set, makes issued tokens encrypted JWEs so
+	// clients cannot read their claims (32 bytes, hex or base64).
+	TokenEncryptionKey string
+
+	// PIIEncryptionKey, when set, encrypts users' email and phone columns
+	// in the database (32 bytes, hex or base64).
+	PIIEncryptionKey string
+
+	// TokenClockSkew is the clock drift tolerated when validating token
+	// exp, nbf, and iat claims.
+	TokenClockSkew time.Duration
+
+	// TokenMaxBytes is the largest token that is issued; zero disables
+	// the limit. It keeps tokens within proxies' header size limits.
+	TokenMaxBytes int
+
+	// RequestSigning is "off", "optional" (check signatures that are
+	// present), or "required" (reject unsigned requests) for the routes
+	// that change credentials and for admin routes. Signed requests are
+	// accepted within RequestSigningWindow of their timestamp.
+	RequestSigning       string
+	RequestSigningWindow time.Duration
+
+	// DPoPEnabled binds the tokens issued at login and refresh to the key
+	// of a DPoP proof (RFC 9449) when the client sends one. Proofs are
+	// accepted within DPoPProofWindow of their iat.
+	DPoPEnabled     bool
+	DPoPProofWindow time.Duration
+
+	// GeoCountryHeader names a trusted proxy header (e.g. CF-IPCountry)
+	// carrying the client's country, recorded with login attempts.
+	GeoCountryHeader string
+
+	// Outgoing email for security notifications. Email is disabled when
+	// SMTPHost is empty.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+	// MailTemplatesDir holds operator overrides of the built-in email
+	// templates; MailAppName is the service name they show.
+	MailTemplatesDir string
+	MailAppName      string
+	// Text messages for phone verification and the SMS second factor.
+	// SMS is disabled when SMSProvider (twilio, vonage, or webhook) is
+	// empty. SMSAccountID and SMSSecret are the provider credentials; for
+	// the webhook provider SMSSecret signs requests to SMSWebhookURL.
+	SMSProvider   string
+	SMSFrom       string
+	SMSAccountID  string
+	SMSSecret     string
+	SMSWebhookURL string
+	// PhoneLoginEnabled lets verified phone numbers stand in for usernames
+	// at registration and login; it requires SMSProvider.
+	PhoneLoginEnabled bool
+	// MagicLinkEnabled allows passwordless sign-in through emailed links
+	// valid for MagicLinkTTL; it requires SMTPHost. MagicLinkDeviceBinding
+	// makes a link work only in the browser that requested it.
+	MagicLinkEnabled       bool
+	MagicLinkTTL           time.Duration
+	MagicLinkDeviceBinding bool
+	// EnumerationProtection hides whether accounts exist from registration
+	// and magic link responses, explaining conflicts by email instead; it
+	// requires SMTPHost.
+	EnumerationProtection bool
+	// GuestEnabled allows anonymous guest sessions with tokens valid for
+	// GuestTokenTTL. Guests that have not registered are deleted
+	// GuestMaxAge after creation.
+	GuestEnabled  bool
+	GuestTokenTTL time.Duration
+	GuestMaxAge   time.Duration
+	// DisableExpiredAccounts has a background job disable accounts whose
+	// expiry has passed. Expired accounts cannot sign in either way.
+	DisableExpiredAccounts bool
+	// SSOCookieDomain enables single sign-on across subdomains: logins set
+	// a signed cookie named SSOCookieName for this domain, valid for
+	// SSOCookieTTL. Empty disables it.
+	SSOCookieDomain string
+	SSOCookieName   string
+	SSOCookieTTL    time.Duration
+	// BackchannelLogoutClients registers clients that are sent OpenID
+	// Connect back-channel logout tokens (see backchannel.ParseClients).
+	BackchannelLogoutClients string
+	// TarpitEnabled delays logins after TarpitThreshold recent failures
+	// from an IP or against an account, starting at TarpitBaseDelay and
+	// doubling up to TarpitMaxDelay. Failures are forgotten TarpitWindow
+	// after the last one. TarpitRejectAtMax answers attempts at the cap
+	// with 429 instead of holding them.
+	TarpitEnabled     bool
+	TarpitThreshold   int
+	TarpitBaseDelay   time.Duration
+	TarpitMaxDelay    time.Duration
+	TarpitWindow      time.Duration
+	TarpitRejectAtMax bool
+	// RateLimitWarnPercent warns when a client has used this percentage
+	// of a rate limit's burst; 0 disables warnings.
+	RateLimitWarnPercent int
+	// RateLimitMaxEntries bounds the clients each in-memory rate limiter
+	// tracks; the least recently active is forgotten when one is full.
+	RateLimitMaxEntries int
+	// DeploymentProfile is "single" for one instance or "multi" for
+	// several sharing one database. RateLimitBackend keeps rate limit
+	// buckets and tarpit failure counts in "memory", per instance, or in
+	// the "store", shared; it defaults to "store" under the multi profile.
+	DeploymentProfile string
+	RateLimitBackend  string
+	// InstanceID names this instance in leader election leases; empty uses
+	// the host name and a random suffix. LeaderLeaseTTL is how long a
+	// leader may go without renewing before another instance takes over
+	// its background jobs.
+	InstanceID     string
+	LeaderLeaseTTL time.Duration
+	// FeatureFlagsFile is a JSON file of feature flag rollouts;
+	// FeatureFlags ("name=on,other=25%") takes precedence over it.
+	FeatureFlagsFile string
+	FeatureFlags     string
+	// PublicURL is the externally visible base URL used in emailed links;
+	// empty uses http://localhost:<port>.
+	PublicURL string
+
+	// Security headers. An empty ContentSecurityPolicy uses the default
+	// policy and "off" omits the header, as "off" does for FrameOptions;
+	// HSTS is omitted when HSTSMaxAge is zero.
+	ContentSecurityPolicy string
+	CSPReportOnly         bool
+	FrameOptions          string
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	// Documents served under /.well-known/. security.txt is served when
+	// SecurityContacts is set and expires SecurityTxtExpires (zero: 180
+	// days ahead of each request); change-password redirects to
+	// ChangePasswordURL when set.
+	SecurityContacts           []string
+	SecurityPolicyURL          string
+	SecurityTxtExpires         time.Time
+	SecurityPreferredLanguages []string
+	ChangePasswordURL          string
+	// WellKnownCacheMaxAge is how long caches may keep those documents;
+	// zero forbids caching them.
+	WellKnownCacheMaxAge time.Duration
+
+	// NTPServer is the time server "sentinel doctor" checks the clock
+	// against; "off" skips the check.
+	NTPServer string
+	// ClockDriftSources are the NTP servers and HTTPS URLs the running
+	// service compares its clock with every ClockDriftInterval; empty
+	// disables the check. Offsets beyond ClockDriftThreshold are reported,
+	// and with ClockDriftAutoSkew widen the token clock skew, up to
+	// ClockDriftMaxSkew.
+	ClockDriftSources   []string
+	ClockDriftInterval  time.Duration
+	ClockDriftThreshold time.Duration
+	ClockDriftAutoSkew  bool
+	ClockDriftMaxSkew   time.Duration
+
+	// DenylistSyncInterval is how often revoked token IDs recorded by other
+	// instances are loaded into the in-memory denylist.
+	DenylistSyncInterval time.Duration
+
+	// BackupDir receives snapshots triggered through the admin API.
+	BackupDir string
+	// BackupUploadURL, when set, is an s3:// or file:// URL that those
+	// snapshots are also uploaded to (see storage.OpenBucket).
+	BackupUploadURL string
+
+	// Object storage used for user-uploaded media such as avatars.
+	StorageBackend   string // "local" or "s3"
+	StorageLocalDir  string
+	StoragePublicURL string
+	S3Endpoint       string
+	S3Region         string
+	S3Bucket         string
+	S3AccessKeyID    string
+	S3SecretKey      string
+	S3UsePathStyle   bool
+
+	// S3ServerSideEncryption ("AES256" or "aws:kms") and S3SSEKMSKeyID ask
+	// S3 to encrypt every object Sentinel uploads at rest.
+	S3ServerSideEncryption string
+	S3SSEKMSKeyID          string
+
+	// Avatar upload limits.
+	AvatarMaxBytes  int64
+	AvatarDimension int
+
+	// AdminRoles defines the delegated admin roles (see rbac.ParseRoles);
+	// empty uses rbac.DefaultRoleSpec.
+	AdminRoles string
+
+	// Just-in-time elevation: ElevationApproval is "admin" to require a
+	// second admin's approval or "self" to approve requests for
+	// capabilities the requester already holds as they are made;
+	// ElevationMaxDuration bounds how long an elevation lasts.
+	ElevationApproval    string
+	ElevationMaxDuration time.Duration
+
+	// Custom user metadata access policies and size limit.
+	MetadataPolicies      string
+	MetadataDefaultPolicy string
+	MetadataMaxBytes      int
+
+	// Username rules. UsernameCharsets lists allowed character classes
+	// (letters, digits, underscore, hyphen, dot, unicode). Names from
+	// UsernameReserved and UsernameReservedFile replace the built-in
+	// reserved list when either is set.
+	UsernameMinLength    int
+	UsernameMaxLength    int
+	UsernameCharsets     []string
+	UsernameReserved     []string
+	UsernameReservedFile string
+
+	// PasswordMinScore is the lowest password strength score (0-4) that
+	// registration accepts; 0 disables the check.
+	PasswordMinScore int
+
+	// Settings lists every variable Load read with its effective value,
+	// secrets masked, and where that value came from.
+	Settings []Setting
 }
 
 // Load reads configuration from .env and environment variables.
 func Load() (*Config, error) {
-	_ = godotenv.Load()
+	env := newEnvironment()
+	env.loadFile(".env")
 
 	locations := []string{".env", ".env.local", "config/.env"}
 	for _, location := range locations {
 		if _, err := os.Stat(location); err == nil {
-			_ = godotenv.Load(location)
+			env.loadFile(location)
 			break
 		}
 	}
 
 	// Parse CORS allowed origins (comma-separated)
-	corsOrigins := []string{}
-	if corsEnv := os.Getenv("CORS_ALLOWED_ORIGINS"); corsEnv != "" {
-		for _, origin := range strings.Split(corsEnv, ",") {
-			trimmed := strings.TrimSpace(origin)
-			if trimmed != "" {
-				corsOrigins = append(corsOrigins, trimmed)
-			}
-		}
-	}
+	corsOrigins := env.getEnvList("CORS_ALLOWED_ORIGINS")
 	// Default to localhost for development if not specified
 	if len(corsOrigins) == 0 {
 		corsOrigins = []string{"http://localhost:3000", "http://localhost:8080"}
+		env.record("CORS_ALLOWED_ORIGINS", strings.Join(corsOrigins, ","), false)
+	}
+
+	profile := strings.ToLower(env.getEnvWithDefault("DEPLOYMENT_PROFILE", "single"))
+	rateLimitBackend := "memory"
+	if profile == "multi" {
+		rateLimitBackend = "store"
+	}
+
+	jwtSecretFile := env.getEnvWithDefault("JWT_SECRET_FILE", "")
+	jwtSecret, err := env.loadSecret("JWT_SECRET", jwtSecretFile)
+	if err != nil {
+		return nil, err
 	}
+	jwtSecretPreviousFile := env.getEnvWithDefault("JWT_SECRET_PREVIOUS_FILE", "")
+	jwtSecretPrevious, err := env.loadSecret("JWT_SECRET_PREVIOUS", jwtSecretPreviousFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsEnabled := os.Getenv("TLS_ENABLED") == "true" || os.Getenv("TLS_ENABLED") == "1"
+	env.record("TLS_ENABLED", strconv.FormatBool(tlsEnabled), false)
+
+	cfg := &Config{
+		Port:                  env.getEnvWithDefault("PORT", ""),
+		DatabaseURL:           env.getEnvWithDefault("DATABASE_URL", ""),
+		JWTSecret:             jwtSecret,
+		JWTSecretFile:         jwtSecretFile,
+		JWTSecretPrevious:     jwtSecretPrevious,
+		JWTSecretPreviousFile: jwtSecretPreviousFile,
+		JWTSecretMinBits:      env.getEnvInt("JWT_SECRET_MIN_BITS", 128),
+		Environment:           strings.ToLower(env.getEnvWithDefault("ENVIRONMENT", "development")),
+		TLSCertFile:           env.getEnvWithDefault("TLS_CERT_FILE", ""),
+		TLSKeyFile:            env.getEnvWithDefault("TLS_KEY_FILE", ""),
+		TLSEnabled:            tlsEnabled,
+		AdminAddr:             env.getEnvWithDefault("ADMIN_ADDR", ""),
+		AdminUIEnabled:        env.getEnvBool("ADMIN_UI_ENABLED", false),
+		DiagnosticsEnabled:    env.getEnvBool("DIAGNOSTICS_ENABLED", false),
+		DiagnosticsDir:        env.getEnvWithDefault("DIAGNOSTICS_DIR", ""),
+		CompressionEnabled:    env.getEnvBool("COMPRESSION_ENABLED", false),
+		CompressionMinBytes:   env.getEnvInt("COMPRESSION_MIN_BYTES", 1024),
+		TLSClientCAFile:       env.getEnvWithDefault("TLS_CLIENT_CA_FILE", ""),
+		TLSClientAuth:         env.getEnvWithDefault("TLS_CLIENT_AUTH", "optional"),
+		ServiceAccounts:       env.getEnvWithDefault("MTLS_SERVICE_ACCOUNTS", ""),
+		CORSAllowedOrigins:    corsOrigins,
+
+		DatabaseReplicaURLs:          env.getEnvList("DATABASE_REPLICA_URLS"),
+		DatabaseReplicaMaxLag:        env.getEnvDuration("DATABASE_REPLICA_MAX_LAG", 5*time.Second),
+		DatabaseReplicaCheckInterval: env.getEnvDuration("DATABASE_REPLICA_CHECK_INTERVAL", 2*time.Second),
+		DatabaseSlowQueryThreshold:   env.getEnvDuration("DATABASE_SLOW_QUERY_THRESHOLD", 200*time.Millisecond),
+		MetricsEnabled:               env.getEnvBool("METRICS_ENABLED", false),
+		MetricsToken:                 env.getEnvWithDefault("METRICS_TOKEN", ""),
+
+		AlertFailedLoginsPerMinute:  env.getEnvInt("ALERT_FAILED_LOGINS_PER_MINUTE", 50),
+		AlertServerErrorsPerMinute:  env.getEnvInt("ALERT_SERVER_ERRORS_PER_MINUTE", 20),
+		AlertRegistrationsPerMinute: env.getEnvInt("ALERT_REGISTRATIONS_PER_MINUTE", 30),
+		AlertCooldown:               env.getEnvDuration("ALERT_COOLDOWN", 15*time.Minute),
+		AlertEvaluationInterval:     env.getEnvDuration("ALERT_EVALUATION_INTERVAL", 15*time.Second),
+		AlertWebhookURL:             env.getEnvWithDefault("ALERT_WEBHOOK_URL", ""),
+		AlertSlackWebhookURL:        env.getEnvWithDefault("ALERT_SLACK_WEBHOOK_URL", ""),
+		AlertPagerDutyRoutingKey:    env.getEnvWithDefault("ALERT_PAGERDUTY_ROUTING_KEY", ""),
+		WebhookSigningSecrets:       env.getEnvWithDefault("WEBHOOK_SIGNING_SECRETS", ""),
+		WebhookDeliveryRetention:    env.getEnvDuration("WEBHOOK_DELIVERY_RETENTION", 30*24*time.Hour),
+		AnalyticsExportURL:          env.getEnvWithDefault("ANALYTICS_EXPORT_URL", ""),
+		AnalyticsExportToken:        env.getEnvWithDefault("ANALYTICS_EXPORT_TOKEN", ""),
+		AuditMode:                   strings.ToLower(env.getEnvWithDefault("AUDIT_MODE", "async")),
+		AuditQueueSize:              env.getEnvInt("AUDIT_QUEUE_SIZE", 10000),
+		AuditBatchSize:              env.getEnvInt("AUDIT_BATCH_SIZE", 100),
+		HTTPClientTimeout:           env.getEnvDuration("HTTP_CLIENT_TIMEOUT", 10*time.Second),
+		HTTPClientMaxRetries:        env.getEnvInt("HTTP_CLIENT_MAX_RETRIES", 2),
+		HTTPClientProxy:             env.getEnvWithDefault("HTTP_CLIENT_PROXY", ""),
+		LogFormat:                   env.getEnvWithDefault("LOG_FORMAT", ""),
+		ErrorFormat:                 strings.ToLower(env.getEnvWithDefault("ERROR_FORMAT", "json")),
+		AccessLogFormat:             env.getEnvWithDefault("ACCESS_LOG_FORMAT", "json"),
+		AccessLogOutput:             env.getEnvWithDefault("ACCESS_LOG_OUTPUT", ""),
+		RefreshTokenTTL:             env.getEnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour),
+		RefreshSliding:              env.getEnvBool("REFRESH_SLIDING", false),
+		RefreshMaxLifetime:          env.getEnvDuration("REFRESH_MAX_LIFETIME", 30*24*time.Hour),
+		RefreshReuseGrace:           env.getEnvDuration("REFRESH_REUSE_GRACE", 0),
+		SessionIdleTimeout:          env.getEnvDuration("SESSION_IDLE_TIMEOUT", 0),
+		TokenEncryptionKey:          env.getEnvWithDefault("TOKEN_ENCRYPTION_KEY", ""),
+		PIIEncryptionKey:            env.getEnvWithDefault("PII_ENCRYPTION_KEY", ""),
+		TokenClockSkew:              env.getEnvDuration("TOKEN_CLOCK_SKEW", time.Minute),
+		TokenMaxBytes:               env.getEnvInt("TOKEN_MAX_BYTES", 4096),
+		RequestSigning:              strings.ToLower(env.getEnvWithDefault("REQUEST_SIGNING", "off")),
+		RequestSigningWindow:        env.getEnvDuration("REQUEST_SIGNING_WINDOW", 5*time.Minute),
+		DPoPEnabled:                 env.getEnvBool("DPOP_ENABLED", false),
+		DPoPProofWindow:             env.getEnvDuration("DPOP_PROOF_WINDOW", time.Minute),
+		GeoCountryHeader:            env.getEnvWithDefault("GEO_COUNTRY_HEADER", ""),
+		SMTPHost:                    env.getEnvWithDefault("SMTP_HOST", ""),
+		SMTPPort:                    env.getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:                env.getEnvWithDefault("SMTP_USERNAME", ""),
+		SMTPPassword:                env.getEnvWithDefault("SMTP_PASSWORD", ""),
+		MailFrom:                    env.getEnvWithDefault("MAIL_FROM", ""),
+		MailTemplatesDir:            env.getEnvWithDefault("MAIL_TEMPLATES_DIR", ""),
+		MailAppName:                 env.getEnvWithDefault("MAIL_APP_NAME", "Sentinel"),
+		SMSProvider:                 env.getEnvWithDefault("SMS_PROVIDER", ""),
+		SMSFrom:                     env.getEnvWithDefault("SMS_FROM", ""),
+		SMSAccountID:                env.getEnvWithDefault("SMS_ACCOUNT_ID", ""),
+		SMSSecret:                   env.getEnvWithDefault("SMS_SECRET", ""),
+		SMSWebhookURL:               env.getEnvWithDefault("SMS_WEBHOOK_URL", ""),
+		PhoneLoginEnabled:           env.getEnvBool("PHONE_LOGIN_ENABLED", false),
+		MagicLinkEnabled:            env.getEnvBool("MAGIC_LINK_ENABLED", false),
+		MagicLinkTTL:                env.getEnvDuration("MAGIC_LINK_TTL", 15*time.Minute),
+		MagicLinkDeviceBinding:      env.getEnvBool("MAGIC_LINK_DEVICE_BINDING", false),
+		EnumerationProtection:       env.getEnvBool("ENUMERATION_PROTECTION", false),
+		GuestEnabled:                env.getEnvBool("GUEST_ENABLED", false),
+		GuestTokenTTL:               env.getEnvDuration("GUEST_TOKEN_TTL", time.Hour),
+		GuestMaxAge:                 env.getEnvDuration("GUEST_MAX_AGE", 30*24*time.Hour),
+		DisableExpiredAccounts:      env.getEnvBool("DISABLE_EXPIRED_ACCOUNTS", false),
+		SSOCookieDomain:             env.getEnvWithDefault("SSO_COOKIE_DOMAIN", ""),
+		SSOCookieName:               env.getEnvWithDefault("SSO_COOKIE_NAME", "sentinel_sso"),
+		SSOCookieTTL:                env.getEnvDuration("SSO_COOKIE_TTL", 12*time.Hour),
+		BackchannelLogoutClients:    env.getEnvWithDefault("BACKCHANNEL_LOGOUT_CLIENTS", ""),
+		TarpitEnabled:               env.getEnvBool("TARPIT_ENABLED", false),
+		TarpitThreshold:             env.getEnvInt("TARPIT_THRESHOLD", 3),
+		TarpitBaseDelay:             env.getEnvDuration("TARPIT_BASE_DELAY", 500*time.Millisecond),
+		TarpitMaxDelay:              env.getEnvDuration("TARPIT_MAX_DELAY", 10*time.Second),
+		TarpitWindow:                env.getEnvDuration("TARPIT_WINDOW", 15*time.Minute),
+		TarpitRejectAtMax:           env.getEnvBool("TARPIT_REJECT_AT_MAX", false),
+		RateLimitWarnPercent:        env.getEnvInt("RATE_LIMIT_WARN_PERCENT", 80),
+		RateLimitMaxEntries:         env.getEnvInt("RATE_LIMIT_MAX_ENTRIES", 100000),
+		DeploymentProfile:           profile,
+		RateLimitBackend:            strings.ToLower(env.getEnvWithDefault("RATE_LIMIT_BACKEND", rateLimitBackend)),
+		InstanceID:                  env.getEnvWithDefault("INSTANCE_ID", ""),
+		LeaderLeaseTTL:              env.getEnvDuration("LEADER_LEASE_TTL", 30*time.Second),
+		FeatureFlagsFile:            env.getEnvWithDefault("FEATURE_FLAGS_FILE", ""),
+		FeatureFlags:                env.getEnvWithDefault("FEATURE_FLAGS", ""),
+		PublicURL:                   env.getEnvWithDefault("PUBLIC_URL", ""),
+		ContentSecurityPolicy:       env.getEnvWithDefault("SECURITY_CSP", ""),
+		CSPReportOnly:               env.getEnvBool("SECURITY_CSP_REPORT_ONLY", false),
+		FrameOptions:                env.getEnvWithDefault("SECURITY_FRAME_OPTIONS", "DENY"),
+		HSTSMaxAge:                  env.getEnvDuration("SECURITY_HSTS_MAX_AGE", 365*24*time.Hour),
+		HSTSIncludeSubdomains:       env.getEnvBool("SECURITY_HSTS_INCLUDE_SUBDOMAINS", true),
+		HSTSPreload:                 env.getEnvBool("SECURITY_HSTS_PRELOAD", false),
+		SecurityContacts:            env.getEnvList("SECURITY_CONTACTS"),
+		SecurityPolicyURL:           env.getEnvWithDefault("SECURITY_POLICY_URL", ""),
+		SecurityTxtExpires:          env.getEnvTime("SECURITY_TXT_EXPIRES"),
+		SecurityPreferredLanguages:  env.getEnvList("SECURITY_PREFERRED_LANGUAGES"),
+		ChangePasswordURL:           env.getEnvWithDefault("CHANGE_PASSWORD_URL", ""),
+		WellKnownCacheMaxAge:        env.getEnvDuration("WELL_KNOWN_CACHE_MAX_AGE", time.Hour),
+		NTPServer:                   env.getEnvWithDefault("NTP_SERVER", "pool.ntp.org"),
+		ClockDriftSources:           env.getEnvList("CLOCK_DRIFT_SOURCES"),
+		ClockDriftInterval:          env.getEnvDuration("CLOCK_DRIFT_INTERVAL", 10*time.Minute),
+		ClockDriftThreshold:         env.getEnvDuration("CLOCK_DRIFT_THRESHOLD", 2*time.Second),
+		ClockDriftAutoSkew:          env.getEnvBool("CLOCK_DRIFT_AUTO_SKEW", false),
+		ClockDriftMaxSkew:           env.getEnvDuration("CLOCK_DRIFT_MAX_SKEW", 5*time.Minute),
+		DenylistSyncInterval:        env.getEnvDuration("DENYLIST_SYNC_INTERVAL", 5*time.Second),
+		BackupDir:                   env.getEnvWithDefault("BACKUP_DIR", "./backups"),
+		BackupUploadURL:             env.getEnvWithDefault("BACKUP_UPLOAD_URL", ""),
+
+		StorageBackend:   env.getEnvWithDefault("STORAGE_BACKEND", "local"),
+		StorageLocalDir:  env.getEnvWithDefault("STORAGE_LOCAL_DIR", "./uploads"),
+		StoragePublicURL: env.getEnvWithDefault("STORAGE_PUBLIC_URL", "/media"),
+		S3Endpoint:       env.getEnvWithDefault("S3_ENDPOINT", ""),
+		S3Region:         env.getEnvWithDefault("S3_REGION", "us-east-1"),
+		S3Bucket:         env.getEnvWithDefault("S3_BUCKET", ""),
+		S3AccessKeyID:    env.getEnvWithDefault("S3_ACCESS_KEY_ID", ""),
+		S3SecretKey:      env.getEnvWithDefault("S3_SECRET_ACCESS_KEY", ""),
+		S3UsePathStyle:   env.getEnvBool("S3_USE_PATH_STYLE", true),
+
+		S3ServerSideEncryption: env.getEnvWithDefault("S3_SERVER_SIDE_ENCRYPTION", ""),
+		S3SSEKMSKeyID:          env.getEnvWithDefault("S3_SSE_KMS_KEY_ID", ""),
+
+		AuditRetentionDays: env.getEnvInt("AUDIT_RETENTION_DAYS", 0),
+		AuditArchiveURL:    env.getEnvWithDefault("AUDIT_ARCHIVE_URL", ""),
+
+		ChaosLatencyPercent: env.getEnvInt("CHAOS_LATENCY_PERCENT", 0),
+		ChaosLatency:        env.getEnvDuration("CHAOS_LATENCY", time.Second),
+		ChaosErrorPercent:   env.getEnvInt("CHAOS_ERROR_PERCENT", 0),
+		ChaosErrorStatus:    env.getEnvInt("CHAOS_ERROR_STATUS", 503),
+		ChaosDropPercent:    env.getEnvInt("CHAOS_DROP_PERCENT", 0),
+		ChaosPaths:          env.getEnvList("CHAOS_PATHS"),
 
-	return &Config{
-		Port:               getEnvWithDefault("PORT", ""),
-		DatabaseURL:        getEnvWithDefault("DATABASE_URL", ""),
-		JWTSecret:          getEnvWithDefault("JWT_SECRET", ""),
-		TLSCertFile:        getEnvWithDefault("TLS_CERT_FILE", ""),
-		TLSKeyFile:         getEnvWithDefault("TLS_KEY_FILE", ""),
-		TLSEnabled:         os.Getenv("TLS_ENABLED") == "true" || os.Getenv("TLS_ENABLED") == "1",
-		CORSAllowedOrigins: corsOrigins,
-	}, nil
+		AvatarMaxBytes:  int64(env.getEnvInt("AVATAR_MAX_BYTES", 2<<20)),
+		AvatarDimension: env.getEnvInt("AVATAR_DIMENSION", 256),
+
+		AdminRoles:           env.getEnvWithDefault("ADMIN_ROLES", ""),
+		ElevationApproval:    env.getEnvWithDefault("ELEVATION_APPROVAL", "admin"),
+		ElevationMaxDuration: env.getEnvDuration("ELEVATION_MAX_DURATION", time.Hour),
+
+		MetadataPolicies:      env.getEnvWithDefault("METADATA_POLICIES", ""),
+		MetadataDefaultPolicy: env.getEnvWithDefault("METADATA_DEFAULT_POLICY", "admin"),
+		MetadataMaxBytes:      env.getEnvInt("METADATA_MAX_BYTES", 4096),
+
+		UsernameMinLength:    env.getEnvInt("USERNAME_MIN_LENGTH", 3),
+		UsernameMaxLength:    env.getEnvInt("USERNAME_MAX_LENGTH", 32),
+		UsernameCharsets:     env.getEnvList("USERNAME_CHARSETS"),
+		UsernameReserved:     env.getEnvList("USERNAME_RESERVED"),
+		UsernameReservedFile: env.getEnvWithDefault("USERNAME_RESERVED_FILE", ""),
+
+		PasswordMinScore: env.getEnvInt("PASSWORD_MIN_SCORE", 0),
+	}
+	cfg.Settings = env.list()
+	return cfg, nil
+}
+
+// loadSecret returns the environment variable name, or the contents of
+// path without trailing line breaks when path is set, so a secret can be
+// mounted as a file (name_FILE) rather than passed in the environment.
+func (e *environment) loadSecret(name, path string) (string, error) {
+	secret := e.getEnvWithDefault(name, "")
+	if path == "" {
+		return secret, nil
+	}
+	if secret != "" {
+		return "", fmt.Errorf("set %s or %s_FILE, not both", name, name)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	secret = strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s_FILE %s is empty", name, path)
+	}
+	e.recordFile(name, secret, path)
+	return secret, nil
 }
 
 // getEnvWithDefault returns the environment variable value or default if not set
-func getEnvWithDefault(key, defaultValue string) string {
+func (e *environment) getEnvWithDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		value = defaultValue
+	}
+	e.record(key, value, false)
+	return value
+}
+
+// getEnvInt returns the integer value of key or defaultValue if unset or invalid.
+func (e *environment) getEnvInt(key string, defaultValue int) int {
+	n, invalid := defaultValue, false
+	if value := os.Getenv(key); value != "" {
+		parsed, err := strconv.Atoi(strings.TrimSpace(value))
+		if err == nil {
+			n = parsed
+		}
+		invalid = err != nil
+	}
+	e.record(key, strconv.Itoa(n), invalid)
+	return n
+}
+
+// getEnvBool returns the boolean value of key ("true"/"1" or "false"/"0"),
+// or defaultValue if unset or unrecognized.
+func (e *environment) getEnvBool(key string, defaultValue bool) bool {
+	b, invalid := defaultValue, false
+	switch value := strings.ToLower(strings.TrimSpace(os.Getenv(key))); value {
+	case "true", "1", "yes":
+		b = true
+	case "false", "0", "no":
+		b = false
+	default:
+		invalid = value != ""
+	}
+	e.record(key, strconv.FormatBool(b), invalid)
+	return b
+}
+
+// getEnvDuration parses key as a Go duration (e.g. "5s", "15m"), returning
+// defaultValue if unset or invalid.
+func (e *environment) getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	d, invalid := defaultValue, false
 	if value := os.Getenv(key); value != "" {
-		return value
+		parsed, err := time.ParseDuration(strings.TrimSpace(value))
+		if err == nil {
+			d = parsed
+		}
+		invalid = err != nil
+	}
+	e.record(key, d.String(), invalid)
+	return d
+}
+
+// getEnvTime parses key as an RFC 3339 timestamp, returning the zero time
+// if unset or invalid.
+func (e *environment) getEnvTime(key string) time.Time {
+	var t time.Time
+	invalid := false
+	if value := os.Getenv(key); value != "" {
+		parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+		if err == nil {
+			t = parsed
+		}
+		invalid = err != nil
+	}
+	formatted := ""
+	if !t.IsZero() {
+		formatted = t.Format(time.RFC3339)
+	}
+	e.record(key, formatted, invalid)
+	return t
+}
+
+// getEnvList splits a comma-separated variable into trimmed, non-empty values.
+func (e *environment) getEnvList(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
 	}
-	return defaultValue
+	e.record(key, strings.Join(values, ","), false)
+	return values
 }
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Sources a Setting's value can come from.
+const (
+	// SourceEnv is the process environment.
+	SourceEnv = "env"
+	// SourceFile is a .env file, or the file named by a *_FILE variable.
+	SourceFile = "file"
+	// SourceDefault means the variable was unset, or invalid and ignored.
+	SourceDefault = "default"
+)
+
+// maskedValue replaces the value of a secret that is set.
+const maskedValue = "********"
+
+// secretSettings are never reported, only whether they are set.
+var secretSettings = map[string]bool{
+	"JWT_SECRET":                  true,
+	"JWT_SECRET_PREVIOUS":         true,
+	"METRICS_TOKEN":               true,
+	"ALERT_SLACK_WEBHOOK_URL":     true,
+	"ALERT_PAGERDUTY_ROUTING_KEY": true,
+	"WEBHOOK_SIGNING_SECRETS":     true,
+	"TOKEN_ENCRYPTION_KEY":        true,
+	"SMTP_PASSWORD":               true,
+	"SMS_SECRET":                  true,
+	"BACKCHANNEL_LOGOUT_CLIENTS":  true,
+	"S3_SECRET_ACCESS_KEY":        true,
+}
+
+// urlSettings are reported with any password in them masked.
+var urlSettings = map[string]bool{
+	"DATABASE_URL":          true,
+	"DATABASE_REPLICA_URLS": true,
+	"HTTP_CLIENT_PROXY":     true,
+	"ALERT_WEBHOOK_URL":     true,
+	"SMS_WEBHOOK_URL":       true,
+}
+
+// Setting is the effective value of one configuration variable.
+type Setting struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Source is SourceEnv, SourceFile, or SourceDefault; File names the
+	// file for SourceFile.
+	Source string `json:"source"`
+	File   string `json:"file,omitempty"`
+	// Invalid is set when the variable was set but could not be parsed,
+	// so the default was used instead.
+	Invalid bool `json:"invalid,omitempty"`
+}
+
+// environment reads configuration variables, remembering which .env file
+// each one not in the process environment came from and recording every
+// variable read as a Setting.
+type environment struct {
+	files    map[string]string
+	settings map[string]Setting
+}
+
+func newEnvironment() *environment {
+	return &environment{files: map[string]string{}, settings: map[string]Setting{}}
+}
+
+// loadFile loads a .env file. As with godotenv.Load, variables that are
+// already set are left alone, so the first file to set one wins.
+func (e *environment) loadFile(path string) {
+	values, err := godotenv.Read(path)
+	if err != nil {
+		return
+	}
+	for key := range values {
+		if _, ok := os.LookupEnv(key); !ok {
+			e.files[key] = path
+		}
+	}
+	_ = godotenv.Load(path)
+}
+
+// record stores the effective value of key. invalid reports that it was
+// set but ignored.
+func (e *environment) record(key, value string, invalid bool) {
+	s := Setting{Name: key, Value: maskSetting(key, value), Source: SourceDefault, Invalid: invalid}
+	if os.Getenv(key) != "" && !invalid {
+		s.Source = SourceEnv
+		if file, ok := e.files[key]; ok {
+			s.Source, s.File = SourceFile, file
+		}
+	}
+	e.settings[key] = s
+}
+
+// recordFile stores the value of a secret read from path.
+func (e *environment) recordFile(key, value, path string) {
+	e.settings[key] = Setting{Name: key, Value: maskSetting(key, value), Source: SourceFile, File: path}
+}
+
+// list returns the recorded settings sorted by name.
+func (e *environment) list() []Setting {
+	settings := make([]Setting, 0, len(e.settings))
+	for _, s := range e.settings {
+		settings = append(settings, s)
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Name < settings[j].Name })
+	return settings
+}
+
+// maskSetting hides the value of a secret, and passwords in URLs.
+func maskSetting(key, value string) string {
+	switch {
+	case value == "":
+		return ""
+	case secretSettings[key]:
+		return maskedValue
+	case urlSettings[key]:
+		parts := strings.Split(value, ",")
+		for i, part := range parts {
+			if u, err := url.Parse(strings.TrimSpace(part)); err == nil && u.User != nil {
+				parts[i] = u.Redacted()
+			}
+		}
+		return strings.Join(parts, ",")
+	}
+	return value
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSettings(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.WriteFile(".env", []byte("MAIL_APP_NAME=Acme\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Unsetenv("MAIL_APP_NAME") })
+	secretFile := filepath.Join(dir, "jwt")
+	if err := os.WriteFile(secretFile, []byte("from-a-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("JWT_SECRET_FILE", secretFile)
+	t.Setenv("SMTP_PASSWORD", "hunter2")
+	t.Setenv("DATABASE_URL", "postgres://app:hunter2@db:5432/sentinel")
+	t.Setenv("SMTP_PORT", "not-a-port")
+	t.Setenv("PORT", "9000")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := map[string]Setting{}
+	for _, s := range cfg.Settings {
+		settings[s.Name] = s
+	}
+
+	want := map[string]Setting{
+		"PORT":           {Name: "PORT", Value: "9000", Source: SourceEnv},
+		"MAIL_APP_NAME":  {Name: "MAIL_APP_NAME", Value: "Acme", Source: SourceFile, File: ".env"},
+		"JWT_SECRET":     {Name: "JWT_SECRET", Value: maskedValue, Source: SourceFile, File: secretFile},
+		"SMTP_PASSWORD":  {Name: "SMTP_PASSWORD", Value: maskedValue, Source: SourceEnv},
+		"DATABASE_URL":   {Name: "DATABASE_URL", Value: "postgres://app:xxxxx@db:5432/sentinel", Source: SourceEnv},
+		"SMTP_PORT":      {Name: "SMTP_PORT", Value: "587", Source: SourceDefault, Invalid: true},
+		"MAGIC_LINK_TTL": {Name: "MAGIC_LINK_TTL", Value: "15m0s", Source: SourceDefault},
+	}
+	for name, w := range want {
+		if got := settings[name]; got != w {
+			t.Errorf("%s: got %+v, want %+v", name, got, w)
+		}
+	}
+	if cfg.JWTSecret != "from-a-file" {
+		t.Errorf("expected secret from file, got %q", cfg.JWTSecret)
+	}
+	for _, s := range cfg.Settings {
+		if s.Value == "hunter2" || s.Value == "from-a-file" {
+			t.Errorf("%s leaks a secret", s.Name)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package denylist
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+const (
+	// bitsPerItem and numHashes give a false positive rate of about 0.1%
+	// at capacity.
+	bitsPerItem = 15
+	numHashes   = 10
+)
+
+// bloom is a fixed-size bloom filter. Bits are set atomically so lookups
+// never take a lock; items cannot be removed, so the filter is rebuilt to
+// forget expired entries.
+type bloom struct {
+	bits     []atomic.Uint64
+	nbits    uint64
+	capacity int
+}
+
+func newBloom(capacity int) *bloom {
+	nbits := uint64(capacity) * bitsPerItem
+	words := (nbits + 63) / 64
+	return &bloom{bits: make([]atomic.Uint64, words), nbits: words * 64, capacity: capacity}
+}
+
+// hashes derives the filter's probe positions from two halves of a 64-bit
+// FNV-1a hash (Kirsch-Mitzenmacher double hashing).
+func (b *bloom) hashes(s string) (h1, h2 uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum>>32 | 1
+}
+
+func (b *bloom) add(s string) {
+	h1, h2 := b.hashes(s)
+	for i := uint64(0); i < numHashes; i++ {
+		pos := (h1 + i*h2) % b.nbits
+		b.bits[pos/64].Or(1 << (pos % 64))
+	}
+}
+
+// mayContain reports false only if s was never added.
+func (b *bloom) mayContain(s string) bool {
+	h1, h2 := b.hashes(s)
+	for i := uint64(0); i < numHashes; i++ {
+		pos := (h1 + i*h2) % b.nbits
+		if b.bits[pos/64].Load()&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
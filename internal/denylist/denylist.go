@@ -0,0 +1,256 @@
+// Package denylist keeps revoked token IDs (JTIs) in memory so every
+// authenticated request can be checked without a database round trip.
+// Lookups go through a lock-free bloom filter first; only its rare positive
+// answers consult the exact set. The set is filled by Add for local
+// revocations and by periodic syncs from the shared store for revocations
+// made by other instances.
+package denylist
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/leader"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/syncloop"
+)
+
+// DefaultSyncInterval is how often Run syncs when given a non-positive
+// interval; revocations must reach every instance sooner than the shared
+// state other packages sync every syncloop.DefaultInterval.
+const DefaultSyncInterval = 5 * time.Second
+
+const (
+	// minCapacity is the smallest filter built; it keeps an empty denylist
+	// from rebuilding on each of its first few entries.
+	minCapacity = 1024
+	// syncOverlap re-reads revocations this far before the last sync so
+	// rows committed out of timestamp order are not missed.
+	syncOverlap = time.Minute
+	// purgeEvery is how often Run deletes expired revocations from the store.
+	purgeEvery = time.Hour
+	// PurgeJob names the purge for leader election.
+	PurgeJob = "revoked-token-purge"
+)
+
+// Lookup results, used as the result label on sentinel_denylist_lookups_total.
+const (
+	resultMiss          = "miss"           // rejected by the bloom filter
+	resultHit           = "hit"            // revoked
+	resultFalsePositive = "false_positive" // passed the filter, not in the set
+)
+
+var (
+	denylistEntries = metrics.NewGaugeVec(
+		"sentinel_denylist_entries",
+		"Unexpired revoked token IDs held in memory.",
+	)
+	denylistLookups = metrics.NewCounterVec(
+		"sentinel_denylist_lookups_total",
+		"Denylist lookups by result (miss, hit, false_positive).",
+		"result",
+	)
+	denylistSyncErrors = metrics.NewCounterVec(
+		"sentinel_denylist_sync_errors_total",
+		"Failed denylist syncs from the store.",
+	)
+)
+
+// Source lists revocations made at or after since. store.Store satisfies it.
+type Source interface {
+	ListRevokedTokens(ctx context.Context, since time.Time) ([]models.RevokedToken, error)
+}
+
+// purger is implemented by sources that can drop expired revocations.
+type purger interface {
+	PurgeRevokedTokens(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// refreshPurger is implemented by sources that also record issued refresh
+// tokens, whose records are dropped on the same schedule.
+type refreshPurger interface {
+	PurgeRefreshTokens(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// Denylist is a set of revoked token IDs, each held until the token it
+// revokes would have expired. It is safe for concurrent use.
+type Denylist struct {
+	filter     atomic.Pointer[bloom]
+	now        func() time.Time
+	lastPurged time.Time // owned by Run
+	leader     *leader.Elector
+
+	mu       sync.RWMutex
+	entries  map[string]time.Time // jti -> token expiry
+	lastSync time.Time
+}
+
+// New returns an empty denylist.
+func New() *Denylist {
+	d := &Denylist{now: time.Now, entries: make(map[string]time.Time)}
+	d.filter.Store(newBloom(minCapacity))
+	return d
+}
+
+// SetLeader makes Run purge the store only while e elects this instance
+// to run PurgeJob. Syncing is unaffected.
+func (d *Denylist) SetLeader(e *leader.Elector) {
+	d.leader = e
+}
+
+// Add denylists jti until expiresAt. Entries that have already expired are
+// ignored.
+func (d *Denylist) Add(jti string, expiresAt time.Time) {
+	if jti == "" || !expiresAt.After(d.now()) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.addLocked(jti, expiresAt)
+	d.resizeLocked()
+}
+
+func (d *Denylist) addLocked(jti string, expiresAt time.Time) {
+	if prev, ok := d.entries[jti]; ok && !expiresAt.After(prev) {
+		return
+	}
+	d.entries[jti] = expiresAt
+	d.filter.Load().add(jti)
+}
+
+// Contains reports whether jti is revoked.
+func (d *Denylist) Contains(jti string) bool {
+	if !d.filter.Load().mayContain(jti) {
+		denylistLookups.WithLabelValues(resultMiss).Inc()
+		return false
+	}
+	d.mu.RLock()
+	expiresAt, ok := d.entries[jti]
+	d.mu.RUnlock()
+	if ok && expiresAt.After(d.now()) {
+		denylistLookups.WithLabelValues(resultHit).Inc()
+		return true
+	}
+	denylistLookups.WithLabelValues(resultFalsePositive).Inc()
+	return false
+}
+
+// Len returns the number of entries, including any that expired since the
+// last prune.
+func (d *Denylist) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.entries)
+}
+
+// Sync loads revocations recorded in src since the previous sync (all of
+// them on the first call) and drops expired entries.
+func (d *Denylist) Sync(ctx context.Context, src Source) error {
+	d.mu.RLock()
+	since := d.lastSync
+	d.mu.RUnlock()
+	if !since.IsZero() {
+		since = since.Add(-syncOverlap)
+	}
+
+	start := d.now()
+	tokens, err := src.ListRevokedTokens(ctx, since)
+	if err != nil {
+		denylistSyncErrors.WithLabelValues().Inc()
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range tokens {
+		d.addLocked(t.JTI, t.ExpiresAt)
+	}
+	d.lastSync = start
+	d.pruneLocked(start)
+	d.resizeLocked()
+	return nil
+}
+
+// Run keeps the denylist in sync with src until ctx is canceled, and
+// purges expired revocations (and refresh token records) from src hourly
+// when it supports it.
+func (d *Denylist) Run(ctx context.Context, src Source, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+	syncloop.Run(ctx, interval, "Denylist", func(ctx context.Context) error {
+		err := d.Sync(ctx, src)
+		d.purge(ctx, src)
+		return err
+	})
+}
+
+func (d *Denylist) purge(ctx context.Context, src Source) {
+	p, ok := src.(purger)
+	if !ok || !d.leader.Leads(ctx, PurgeJob) {
+		return
+	}
+	now := d.now()
+	if now.Sub(d.lastPurged) < purgeEvery {
+		return
+	}
+	d.lastPurged = now
+	n, err := p.PurgeRevokedTokens(ctx, now)
+	if err != nil {
+		logger.Warn("Revoked token purge failed", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if n > 0 {
+		logger.Info("Purged expired revoked tokens", map[string]interface{}{"count": n})
+	}
+
+	if rp, ok := src.(refreshPurger); ok {
+		n, err := rp.PurgeRefreshTokens(ctx, now)
+		if err != nil {
+			logger.Warn("Refresh token purge failed", map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if n > 0 {
+			logger.Info("Purged expired refresh tokens", map[string]interface{}{"count": n})
+		}
+	}
+}
+
+// pruneLocked removes entries that expired before now, rebuilding the
+// filter so their bits stop producing false positives.
+func (d *Denylist) pruneLocked(now time.Time) {
+	pruned := false
+	for jti, expiresAt := range d.entries {
+		if !expiresAt.After(now) {
+			delete(d.entries, jti)
+			pruned = true
+		}
+	}
+	if pruned {
+		d.rebuildLocked()
+	}
+}
+
+// resizeLocked grows the filter once it holds more entries than it was
+// sized for, keeping the false positive rate bounded.
+func (d *Denylist) resizeLocked() {
+	if len(d.entries) > d.filter.Load().capacity {
+		d.rebuildLocked()
+	}
+	denylistEntries.WithLabelValues().Set(float64(len(d.entries)))
+}
+
+// rebuildLocked replaces the filter with one sized for twice the current
+// entries. Readers still holding the old filter get correct answers: it
+// already contains every current entry.
+func (d *Denylist) rebuildLocked() {
+	f := newBloom(max(minCapacity, 2*len(d.entries)))
+	for jti := range d.entries {
+		f.add(jti)
+	}
+	d.filter.Store(f)
+}
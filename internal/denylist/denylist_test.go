@@ -0,0 +1,106 @@
+package denylist
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+)
+
+type fakeSource struct {
+	tokens []models.RevokedToken
+	since  []time.Time
+}
+
+func (f *fakeSource) ListRevokedTokens(ctx context.Context, since time.Time) ([]models.RevokedToken, error) {
+	f.since = append(f.since, since)
+	var out []models.RevokedToken
+	for _, t := range f.tokens {
+		if !t.RevokedAt.Before(since) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+func TestDenylistAddAndExpiry(t *testing.T) {
+	d := New()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	d.Add("a", now.Add(time.Minute))
+	d.Add("old", now.Add(-time.Minute))
+	if !d.Contains("a") {
+		t.Fatal("expected a to be revoked")
+	}
+	if d.Contains("old") || d.Contains("b") {
+		t.Fatal("expired and unknown IDs must not be revoked")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if d.Contains("a") {
+		t.Fatal("entry should lapse once the token expires")
+	}
+}
+
+func TestDenylistSync(t *testing.T) {
+	d := New()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	src := &fakeSource{tokens: []models.RevokedToken{
+		{JTI: "a", RevokedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{JTI: "b", RevokedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Minute)},
+	}}
+	ctx := context.Background()
+
+	if err := d.Sync(ctx, src); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Contains("a") || !d.Contains("b") {
+		t.Fatal("expected synced IDs to be revoked")
+	}
+
+	now = now.Add(5 * time.Minute)
+	src.tokens = append(src.tokens, models.RevokedToken{JTI: "c", RevokedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err := d.Sync(ctx, src); err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Add(-5 * time.Minute).Add(-syncOverlap); !src.since[1].Equal(want) {
+		t.Errorf("second sync since = %v, want %v", src.since[1], want)
+	}
+	if !d.Contains("c") {
+		t.Error("expected c to be picked up incrementally")
+	}
+	if d.Contains("b") || d.Len() != 2 {
+		t.Errorf("expected expired b to be pruned, have %d entries", d.Len())
+	}
+}
+
+func TestDenylistGrowsFilter(t *testing.T) {
+	d := New()
+	exp := time.Now().Add(time.Hour)
+	n := 3 * minCapacity
+	for i := 0; i < n; i++ {
+		d.Add(fmt.Sprintf("jti-%d", i), exp)
+	}
+	if c := d.filter.Load().capacity; c < n {
+		t.Fatalf("filter capacity %d below %d entries", c, n)
+	}
+	for i := 0; i < n; i++ {
+		if !d.Contains(fmt.Sprintf("jti-%d", i)) {
+			t.Fatalf("jti-%d missing after growth", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if d.filter.Load().mayContain(fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 100 {
+		t.Errorf("bloom filter false positive rate too high: %d/10000", falsePositives)
+	}
+}
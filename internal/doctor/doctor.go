@@ -0,0 +1,189 @@
+// Package doctor runs pre-flight checks against a deployment's
+// configuration and dependencies and renders a pass/fail report. It backs
+// the "sentinel doctor" command, which is meant to run before promoting a
+// deployment.
+package doctor
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+// Check outcomes. Only StatusFail makes a report fail.
+const (
+	StatusPass Status = "PASS"
+	StatusWarn Status = "WARN"
+	StatusFail Status = "FAIL"
+	StatusSkip Status = "SKIP"
+)
+
+// DefaultCheckTimeout bounds each check run by Run.
+const DefaultCheckTimeout = 10 * time.Second
+
+// CertificateWarnWindow is how close to expiry a certificate starts to warn.
+const CertificateWarnWindow = 30 * 24 * time.Hour
+
+// Check is a named diagnostic. Run returns its outcome and a short detail.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (Status, string)
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Name     string
+	Status   Status
+	Detail   string
+	Duration time.Duration
+}
+
+// Run runs checks in order, each with its own timeout, and returns their
+// results.
+func Run(ctx context.Context, checks []Check, timeout time.Duration) []Result {
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	results := make([]Result, 0, len(checks))
+	for _, c := range checks {
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		status, detail := c.Run(cctx)
+		cancel()
+		results = append(results, Result{Name: c.Name, Status: status, Detail: detail, Duration: time.Since(start)})
+	}
+	return results
+}
+
+// Passed reports whether no check failed.
+func Passed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == StatusFail {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteReport renders results as an aligned table followed by a summary
+// line.
+func WriteReport(w io.Writer, results []Result) error {
+	width := 0
+	for _, r := range results {
+		width = max(width, len(r.Name))
+	}
+	counts := make(map[Status]int)
+	var b strings.Builder
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(&b, "[%s] %-*s  %s\n", r.Status, width, r.Name, r.Detail)
+	}
+	verdict := "passed"
+	if !Passed(results) {
+		verdict = "FAILED"
+	}
+	fmt.Fprintf(&b, "\n%d checks %s: %d passed, %d warned, %d failed, %d skipped\n",
+		len(results), verdict, counts[StatusPass], counts[StatusWarn], counts[StatusFail], counts[StatusSkip])
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// placeholderSecrets are substrings of example secrets people forget to
+// replace.
+var placeholderSecrets = []string{"change", "example", "your", "placeholder", "secret", "password"}
+
+// SecretStrength checks the JWT signing secret: it must be set, must not
+// look like a placeholder, and should be at least 32 bytes with an
+// estimated minBits of entropy. A secret below minBits fails when enforce
+// is set, as the server then refuses to start, and warns otherwise.
+func SecretStrength(secret string, minBits int, enforce bool) Check {
+	return Check{Name: "jwt secret", Run: func(context.Context) (Status, string) {
+		if secret == "" {
+			return StatusFail, "JWT_SECRET is not set"
+		}
+		lower := strings.ToLower(secret)
+		for _, p := range placeholderSecrets {
+			if strings.Contains(lower, p) {
+				return StatusFail, "JWT_SECRET looks like a placeholder; generate one with `openssl rand -base64 32`"
+			}
+		}
+		distinct := make(map[rune]bool)
+		for _, r := range secret {
+			distinct[r] = true
+		}
+		if len(distinct) < 8 {
+			return StatusFail, fmt.Sprintf("JWT_SECRET uses only %d distinct characters", len(distinct))
+		}
+		bits := auth.SecretEntropyBits(secret)
+		if bits < float64(minBits) {
+			status := StatusWarn
+			if enforce {
+				status = StatusFail
+			}
+			return status, fmt.Sprintf("JWT_SECRET has about %.0f bits of entropy; at least %d are required", bits, minBits)
+		}
+		if len(secret) < 32 {
+			return StatusWarn, fmt.Sprintf("JWT_SECRET is %d bytes; at least 32 is recommended", len(secret))
+		}
+		return StatusPass, fmt.Sprintf("%d bytes, about %.0f bits of entropy", len(secret), bits)
+	}}
+}
+
+// Certificate checks that the TLS key pair loads and that the certificate
+// is currently valid, warning within CertificateWarnWindow of expiry.
+func Certificate(certFile, keyFile string, now func() time.Time) Check {
+	return Check{Name: "tls certificate", Run: func(context.Context) (Status, string) {
+		pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return StatusFail, err.Error()
+		}
+		leaf := pair.Leaf
+		if leaf == nil {
+			return StatusFail, "certificate could not be parsed"
+		}
+		t := now()
+		subject := leaf.Subject.CommonName
+		if len(leaf.DNSNames) > 0 {
+			subject = strings.Join(leaf.DNSNames, ", ")
+		}
+		switch {
+		case t.Before(leaf.NotBefore):
+			return StatusFail, fmt.Sprintf("%s is not valid until %s", subject, leaf.NotBefore.UTC().Format(time.RFC3339))
+		case !t.Before(leaf.NotAfter):
+			return StatusFail, fmt.Sprintf("%s expired %s", subject, leaf.NotAfter.UTC().Format(time.RFC3339))
+		case leaf.NotAfter.Sub(t) < CertificateWarnWindow:
+			return StatusWarn, fmt.Sprintf("%s expires in %d days (%s)", subject,
+				int(leaf.NotAfter.Sub(t).Hours()/24), leaf.NotAfter.UTC().Format(time.RFC3339))
+		}
+		return StatusPass, fmt.Sprintf("%s valid until %s", subject, leaf.NotAfter.UTC().Format(time.RFC3339))
+	}}
+}
+
+// Clock compares the local clock with an NTP server. Tokens are rejected
+// when clocks disagree by more than tolerance (the token clock skew), so
+// that fails; half of it warns. An unreachable server only warns.
+func Clock(server string, tolerance time.Duration) Check {
+	return Check{Name: "clock sync", Run: func(ctx context.Context) (Status, string) {
+		offset, err := QueryNTP(ctx, server)
+		if err != nil {
+			return StatusWarn, "could not query " + server + ": " + err.Error()
+		}
+		detail := fmt.Sprintf("offset %s from %s (tolerance %s)", offset.Round(time.Millisecond), server, tolerance)
+		abs := offset.Abs()
+		switch {
+		case abs > tolerance:
+			return StatusFail, detail
+		case abs > tolerance/2:
+			return StatusWarn, detail
+		}
+		return StatusPass, detail
+	}}
+}
@@ -0,0 +1,184 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecretStrength(t *testing.T) {
+	for secret, want := range map[string]Status{
+		"":                                 StatusFail,
+		"please-change-me-before-prod-use": StatusFail,
+		"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": StatusFail,
+		"k3J9xQ2mZ7vB4nL8":                         StatusWarn,
+		"k3J9xQ2mZ7vB4nL8p1R6tY0wE5uI2oA9":         StatusPass,
+	} {
+		if got, detail := SecretStrength(secret, 64, false).Run(context.Background()); got != want {
+			t.Errorf("SecretStrength(%q) = %s (%s), want %s", secret, got, detail, want)
+		}
+	}
+
+	// Below the minimum, enforcement decides between a warning and a failure
+	low := "k3J9xQ2mZ7vB4nL8p1R6tY0w"
+	if got, _ := SecretStrength(low, 128, false).Run(context.Background()); got != StatusWarn {
+		t.Errorf("expected a warning below the minimum, got %s", got)
+	}
+	if got, detail := SecretStrength(low, 128, true).Run(context.Background()); got != StatusFail || !strings.Contains(detail, "bits of entropy") {
+		t.Errorf("expected a failure below an enforced minimum, got %s (%s)", got, detail)
+	}
+}
+
+// writeCert writes a self-signed certificate valid from notBefore to
+// notAfter and its key, returning their paths.
+func writeCert(t *testing.T, notBefore, notAfter time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "auth.example.com"},
+		DNSNames:     []string{"auth.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestCertificate(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	cases := []struct {
+		name           string
+		from, until    time.Time
+		want           Status
+		detailContains string
+	}{
+		{"valid", now.Add(-time.Hour), now.Add(90 * 24 * time.Hour), StatusPass, "auth.example.com valid until"},
+		{"expiring", now.Add(-time.Hour), now.Add(10 * 24 * time.Hour), StatusWarn, "expires in"},
+		{"expired", now.Add(-48 * time.Hour), now.Add(-time.Hour), StatusFail, "expired"},
+		{"not yet valid", now.Add(time.Hour), now.Add(48 * time.Hour), StatusFail, "not valid until"},
+	}
+	for _, tc := range cases {
+		certFile, keyFile := writeCert(t, tc.from, tc.until)
+		got, detail := Certificate(certFile, keyFile, clock).Run(context.Background())
+		if got != tc.want || !strings.Contains(detail, tc.detailContains) {
+			t.Errorf("%s: got %s (%s)", tc.name, got, detail)
+		}
+	}
+	if got, _ := Certificate("missing.pem", "missing.key", clock).Run(context.Background()); got != StatusFail {
+		t.Errorf("expected missing files to fail, got %s", got)
+	}
+}
+
+// fakeNTP answers SNTP requests with a clock running ahead by skew.
+func fakeNTP(t *testing.T, skew time.Duration) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 48)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+			resp := make([]byte, 48)
+			resp[0] = 0x24 // version 4, mode 4 (server)
+			resp[1] = 2    // stratum
+			copy(resp[24:32], buf[40:48])
+			now := toNTP(time.Now().Add(skew))
+			binary.BigEndian.PutUint64(resp[32:], now)
+			binary.BigEndian.PutUint64(resp[40:], now)
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestClock(t *testing.T) {
+	offset, err := QueryNTP(context.Background(), fakeNTP(t, 5*time.Second))
+	if err != nil {
+		t.Fatalf("QueryNTP: %v", err)
+	}
+	if d := offset + 5*time.Second; d.Abs() > 200*time.Millisecond {
+		t.Errorf("expected local clock about 5s behind, got offset %s", offset)
+	}
+
+	if got, detail := Clock(fakeNTP(t, 0), time.Minute).Run(context.Background()); got != StatusPass {
+		t.Errorf("expected a synchronized clock to pass, got %s (%s)", got, detail)
+	}
+	if got, _ := Clock(fakeNTP(t, 40*time.Second), time.Minute).Run(context.Background()); got != StatusWarn {
+		t.Errorf("expected an offset over half the tolerance to warn, got %s", got)
+	}
+	if got, _ := Clock(fakeNTP(t, -2*time.Minute), time.Minute).Run(context.Background()); got != StatusFail {
+		t.Errorf("expected an offset over the tolerance to fail, got %s", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	silent, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	defer silent.Close()
+	if got, _ := Clock(silent.LocalAddr().String(), time.Minute).Run(ctx); got != StatusWarn {
+		t.Errorf("expected an unreachable server to warn, got %s", got)
+	}
+}
+
+func TestRunAndReport(t *testing.T) {
+	checks := []Check{
+		{Name: "first", Run: func(context.Context) (Status, string) { return StatusPass, "ok" }},
+		{Name: "second check", Run: func(ctx context.Context) (Status, string) {
+			if _, ok := ctx.Deadline(); !ok {
+				return StatusFail, "no deadline"
+			}
+			return StatusSkip, "not configured"
+		}},
+	}
+	results := Run(context.Background(), checks, time.Second)
+	if !Passed(results) {
+		t.Fatalf("expected checks to pass: %+v", results)
+	}
+
+	results = append(results, Result{Name: "third", Status: StatusFail, Detail: "broken"})
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	want := "[PASS] first         ok\n" +
+		"[SKIP] second check  not configured\n" +
+		"[FAIL] third         broken\n" +
+		"\n3 checks FAILED: 1 passed, 0 warned, 1 failed, 1 skipped\n"
+	if buf.String() != want {
+		t.Errorf("report:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,72 @@
+package doctor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and
+// the Unix epoch (1970).
+const ntpEpochOffset = 2208988800
+
+// QueryNTP asks server (host or host:port; port 123 by default) for the
+// time with a single SNTP (RFC 4330) request and returns how far the local
+// clock is ahead of it.
+func QueryNTP(ctx context.Context, server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	req := make([]byte, 48)
+	req[0] = 0x23 // LI 0, version 4, mode 3 (client)
+	t0 := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTP(t0))
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	t3 := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 || resp[0]&0x07 != 4 {
+		return 0, errors.New("invalid NTP response")
+	}
+	if resp[1] == 0 {
+		return 0, errors.New("NTP server is unsynchronized (kiss-o'-death)")
+	}
+
+	// Local offset: ((t1 - t0) + (t2 - t3)) / 2, where t1 and t2 are the
+	// server's receive and transmit times. Negated, it is how far ahead the
+	// local clock is.
+	t1 := fromNTP(binary.BigEndian.Uint64(resp[32:]))
+	t2 := fromNTP(binary.BigEndian.Uint64(resp[40:]))
+	return -(t1.Sub(t0) + t2.Sub(t3)) / 2, nil
+}
+
+// toNTP encodes t as a 64-bit NTP timestamp.
+func toNTP(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+// fromNTP decodes a 64-bit NTP timestamp.
+func fromNTP(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	nanos := (v & 0xffffffff) * 1e9 >> 32
+	return time.Unix(secs, int64(nanos))
+}
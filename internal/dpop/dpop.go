@@ -0,0 +1,300 @@
+// Package dpop implements OAuth 2.0 Demonstrating Proof of Possession (RFC
+// 9449). A client sends a proof, a JWT signed with a key it holds, with
+// each request; tokens issued with a proof are bound to that key through
+// their cnf claim, so a token that leaks is useless without the key.
+package dpop
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// Header carries the proof; Scheme is the Authorization scheme of bound
+// access tokens.
+const (
+	Header = "DPoP"
+	Scheme = "DPoP"
+)
+
+// proofType is the typ header every proof must carry.
+const proofType = "dpop+jwt"
+
+// DefaultWindow is how far a proof's iat may be from the server's clock
+// unless configured otherwise.
+const DefaultWindow = time.Minute
+
+// maxProofLength caps the size of a proof; an RSA key makes the largest.
+const maxProofLength = 8 << 10
+
+// algorithms are the proof signing algorithms accepted.
+var algorithms = []string{"ES256", "ES384", "RS256", "PS256", "EdDSA"}
+
+// Algorithms returns the proof signing algorithms accepted, for discovery
+// metadata and challenges.
+func Algorithms() []string {
+	return slices.Clone(algorithms)
+}
+
+var (
+	// ErrMissing is returned when a request has no proof.
+	ErrMissing = errors.New("DPoP proof required")
+	// ErrInvalid is returned, wrapped with the reason, for proofs that are
+	// malformed, badly signed, stale, or for another request.
+	ErrInvalid = errors.New("invalid DPoP proof")
+	// ErrKeyMismatch is returned when a proof is signed with a key other
+	// than the one the token is bound to.
+	ErrKeyMismatch = errors.New("DPoP proof key does not match the token")
+	// ErrReplayed is returned for a proof that has been used before.
+	ErrReplayed = errors.New("DPoP proof has already been used")
+	// ErrUnavailable is returned when used proofs cannot be checked.
+	ErrUnavailable = errors.New("DPoP proof check unavailable")
+)
+
+// proofResults counts proofs checked, by result: valid, missing, invalid,
+// key_mismatch, or replayed.
+var proofResults = metrics.NewCounterVec(
+	"sentinel_dpop_proofs_total",
+	"DPoP proofs checked, by result.",
+	"result",
+)
+
+// Proof is a verified proof.
+type Proof struct {
+	// JKT is the thumbprint of the key that signed the proof.
+	JKT      string
+	ID       string
+	IssuedAt time.Time
+}
+
+type proofClaims struct {
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// AccessTokenHash returns the ath claim of proofs sent with accessToken.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Verify checks that proof is a well-formed proof signed with the key in
+// its header, for a request with method to target, issued within window
+// of now, and, unless accessToken is empty, sent with accessToken. It
+// does not check whether the proof was used before.
+func Verify(proof, method, target, accessToken string, now time.Time, window time.Duration) (*Proof, error) {
+	if len(proof) > maxProofLength {
+		return nil, fmt.Errorf("%w: too large", ErrInvalid)
+	}
+	var jkt string
+	var c proofClaims
+	_, err := jwt.ParseWithClaims(proof, &c, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != proofType {
+			return nil, fmt.Errorf("typ must be %s", proofType)
+		}
+		key, thumbprint, err := parseJWK(t.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		if !keyFits(t.Method.Alg(), key) {
+			return nil, fmt.Errorf("key does not fit algorithm %s", t.Method.Alg())
+		}
+		jkt = thumbprint
+		return key, nil
+	}, jwt.WithValidMethods(algorithms), jwt.WithTimeFunc(func() time.Time { return now }))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	switch {
+	case c.ID == "":
+		return nil, fmt.Errorf("%w: missing jti", ErrInvalid)
+	case c.IssuedAt == nil || now.Sub(c.IssuedAt.Time).Abs() > window:
+		return nil, fmt.Errorf("%w: iat is missing or outside the allowed window", ErrInvalid)
+	case c.HTM != method:
+		return nil, fmt.Errorf("%w: htm does not match the request method", ErrInvalid)
+	case !sameURL(c.HTU, target):
+		return nil, fmt.Errorf("%w: htu does not match the request URL", ErrInvalid)
+	case accessToken != "" && c.ATH != AccessTokenHash(accessToken):
+		return nil, fmt.Errorf("%w: ath does not match the access token", ErrInvalid)
+	}
+	return &Proof{JKT: jkt, ID: c.ID, IssuedAt: c.IssuedAt.Time}, nil
+}
+
+// keyFits reports whether key is of the type and size alg signs with.
+func keyFits(alg string, key crypto.PublicKey) bool {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return (alg == "ES256" && k.Curve == elliptic.P256()) || (alg == "ES384" && k.Curve == elliptic.P384())
+	case *rsa.PublicKey:
+		return alg == "RS256" || alg == "PS256"
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+// sameURL reports whether a and b name the same resource, ignoring the
+// query and fragment and normalizing the case of the scheme and host and
+// default ports, as RFC 9449 requires of htu.
+func sameURL(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return normalizedURL(ua) == normalizedURL(ub)
+}
+
+func normalizedURL(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if port := u.Port(); (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		host = strings.ToLower(u.Hostname())
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path
+}
+
+// NewProof returns a proof for a request with method to target, signed
+// with key, which must be a P-256 ECDSA or Ed25519 private key. With an
+// accessToken the proof carries its hash, as proofs sent with access
+// tokens must. It is for clients and tests.
+func NewProof(key crypto.Signer, method, target, accessToken string) (string, error) {
+	k, err := publicJWK(key.Public())
+	if err != nil {
+		return "", err
+	}
+	alg := jwt.SigningMethod(jwt.SigningMethodES256)
+	if k.Kty == "OKP" {
+		alg = jwt.SigningMethodEdDSA
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	c := proofClaims{
+		HTM: method,
+		HTU: target,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       hex.EncodeToString(id[:]),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+	}
+	if accessToken != "" {
+		c.ATH = AccessTokenHash(accessToken)
+	}
+	t := jwt.NewWithClaims(alg, c)
+	t.Header["typ"] = proofType
+	t.Header["jwk"] = k
+	return t.SignedString(key)
+}
+
+// NonceStore remembers used proofs, shared by every instance so a proof
+// cannot be replayed against another one.
+type NonceStore interface {
+	UseNonce(ctx context.Context, key string, expiresAt, now time.Time) (bool, error)
+}
+
+// Verifier checks the proofs sent with requests and remembers them so
+// each is used once. It implements auth.ProofVerifier.
+type Verifier struct {
+	Nonces NonceStore
+	// Window is how far a proof's iat may be from now; DefaultWindow when
+	// zero.
+	Window time.Duration
+	// PublicURL, when set, is the externally visible base URL against
+	// which htu is checked, for instances behind a reverse proxy.
+	// Otherwise the request's own scheme and host are used.
+	PublicURL string
+}
+
+func (v *Verifier) window() time.Duration {
+	if v.Window <= 0 {
+		return DefaultWindow
+	}
+	return v.Window
+}
+
+// requestURL returns the URL a client addressed r to.
+func (v *Verifier) requestURL(r *http.Request) string {
+	if v.PublicURL != "" {
+		return strings.TrimRight(v.PublicURL, "/") + r.URL.EscapedPath()
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
+
+// Check verifies the one proof sent with r, for accessToken when it is
+// not empty, and signed with the key with thumbprint jkt when that is not
+// empty, then records it as used.
+func (v *Verifier) Check(r *http.Request, accessToken, jkt string) (*Proof, error) {
+	values := r.Header.Values(Header)
+	if len(values) == 0 {
+		proofResults.WithLabelValues("missing").Inc()
+		return nil, ErrMissing
+	}
+	if len(values) > 1 {
+		proofResults.WithLabelValues("invalid").Inc()
+		return nil, fmt.Errorf("%w: more than one proof", ErrInvalid)
+	}
+	now := time.Now()
+	p, err := Verify(values[0], r.Method, v.requestURL(r), accessToken, now, v.window())
+	if err != nil {
+		proofResults.WithLabelValues("invalid").Inc()
+		return nil, err
+	}
+	if jkt != "" && p.JKT != jkt {
+		proofResults.WithLabelValues("key_mismatch").Inc()
+		return nil, ErrKeyMismatch
+	}
+
+	// The proof is kept until its iat would be rejected anyway
+	fresh, err := v.Nonces.UseNonce(r.Context(), "dpop:"+p.JKT+":"+p.ID, p.IssuedAt.Add(v.window()), now)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("DPoP proof check failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil, ErrUnavailable
+	}
+	if !fresh {
+		proofResults.WithLabelValues("replayed").Inc()
+		return nil, ErrReplayed
+	}
+	proofResults.WithLabelValues("valid").Inc()
+	return p, nil
+}
+
+// VerifyProof checks the proof sent with r for the access token token,
+// whose claims c bind it to a key.
+func (v *Verifier) VerifyProof(r *http.Request, token string, c *auth.Claims) error {
+	_, err := v.Check(r, token, c.BoundKey())
+	return err
+}
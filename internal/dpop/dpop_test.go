@@ -0,0 +1,136 @@
+package dpop
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mayvqt/Sentinel/internal/auth"
+)
+
+func TestThumbprint(t *testing.T) {
+	// The example of RFC 7638, section 3.1
+	k := jwk{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+	if got := k.thumbprint(); got != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("thumbprint = %s", got)
+	}
+	if _, err := k.publicKey(); err != nil {
+		t.Errorf("publicKey: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	const target = "https://auth.example.com/api/auth/profile"
+
+	for name, key := range map[string]crypto.Signer{"ES256": ecKey, "EdDSA": edKey} {
+		t.Run(name, func(t *testing.T) {
+			jkt, _ := Thumbprint(key.Public())
+			proof, err := NewProof(key, "GET", target, "access-token")
+			if err != nil {
+				t.Fatalf("NewProof: %v", err)
+			}
+			now := time.Now()
+			p, err := Verify(proof, "GET", "HTTPS://Auth.Example.com:443/api/auth/profile?x=1", "access-token", now, time.Minute)
+			if err != nil || p.JKT != jkt || p.ID == "" {
+				t.Fatalf("Verify = %+v, %v; want thumbprint %s", p, err, jkt)
+			}
+			for _, c := range []struct {
+				desc, method, target, token string
+				now                         time.Time
+			}{
+				{"method", "POST", target, "access-token", now},
+				{"url", "GET", target + "/x", "access-token", now},
+				{"access token", "GET", target, "other-token", now},
+				{"stale", "GET", target, "access-token", now.Add(2 * time.Minute)},
+			} {
+				if _, err := Verify(proof, c.method, c.target, c.token, c.now, time.Minute); !errors.Is(err, ErrInvalid) {
+					t.Errorf("%s: got %v, want ErrInvalid", c.desc, err)
+				}
+			}
+		})
+	}
+
+	// Proofs must be typed, carry a public key only, and be signed by it
+	sign := func(header map[string]interface{}) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodES256, proofClaims{HTM: "GET", HTU: target, RegisteredClaims: jwt.RegisteredClaims{
+			ID: "1", IssuedAt: jwt.NewNumericDate(time.Now()),
+		}})
+		for k, v := range header {
+			tok.Header[k] = v
+		}
+		s, _ := tok.SignedString(ecKey)
+		return s
+	}
+	pub, _ := publicJWK(ecKey.Public())
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherPub, _ := publicJWK(other.Public())
+	for desc, proof := range map[string]string{
+		"untyped":   sign(map[string]interface{}{"jwk": pub}),
+		"no key":    sign(map[string]interface{}{"typ": proofType}),
+		"private":   sign(map[string]interface{}{"typ": proofType, "jwk": map[string]string{"kty": "EC", "crv": "P-256", "x": pub.X, "y": pub.Y, "d": "AAAA"}}),
+		"wrong key": sign(map[string]interface{}{"typ": proofType, "jwk": otherPub}),
+	} {
+		if _, err := Verify(proof, "GET", target, "", time.Now(), time.Minute); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v, want ErrInvalid", desc, err)
+		}
+	}
+	if _, err := Verify(sign(map[string]interface{}{"typ": proofType, "jwk": pub}), "GET", target, "", time.Now(), time.Minute); err != nil {
+		t.Errorf("hand-built proof rejected: %v", err)
+	}
+}
+
+type nonces struct {
+	mu   sync.Mutex
+	used map[string]bool
+}
+
+func (n *nonces) UseNonce(ctx context.Context, key string, expiresAt, now time.Time) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.used[key] {
+		return false, nil
+	}
+	n.used[key] = true
+	return true, nil
+}
+
+func TestVerifier(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jkt, _ := Thumbprint(key.Public())
+	v := &Verifier{Nonces: &nonces{used: map[string]bool{}}, PublicURL: "https://auth.example.com/"}
+
+	proof, _ := NewProof(key, "GET", "https://auth.example.com/api/auth/profile", "token")
+	req := httptest.NewRequest("GET", "http://10.0.0.5:8080/api/auth/profile", nil)
+	req.Header.Set(Header, proof)
+	if err := v.VerifyProof(req, "token", &auth.Claims{Confirmation: &auth.Confirmation{JKT: jkt}}); err != nil {
+		t.Fatalf("VerifyProof: %v", err)
+	}
+	if _, err := v.Check(req, "token", ""); !errors.Is(err, ErrReplayed) {
+		t.Errorf("replayed proof: got %v", err)
+	}
+
+	proof, _ = NewProof(key, "GET", "https://auth.example.com/api/auth/profile", "token")
+	req.Header.Set(Header, proof)
+	if _, err := v.Check(req, "token", "another-key"); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("other key: got %v", err)
+	}
+	req.Header.Del(Header)
+	if _, err := v.Check(req, "token", jkt); !errors.Is(err, ErrMissing) {
+		t.Errorf("no proof: got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package dpop
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// minRSABits is the smallest RSA modulus accepted in a proof's key.
+const minRSABits = 2048
+
+// jwk holds the public members of the JSON Web Keys accepted in proofs.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// parseJWK reads the jwk header of a proof, returning the public key and
+// its thumbprint. Keys with private or symmetric members are refused.
+func parseJWK(header interface{}) (crypto.PublicKey, string, error) {
+	members, ok := header.(map[string]interface{})
+	if !ok {
+		return nil, "", errors.New("missing jwk header")
+	}
+	for _, private := range []string{"d", "p", "q", "k"} {
+		if _, ok := members[private]; ok {
+			return nil, "", errors.New("jwk contains private key material")
+		}
+	}
+	raw, err := json.Marshal(members)
+	if err != nil {
+		return nil, "", err
+	}
+	var k jwk
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return nil, "", fmt.Errorf("invalid jwk: %w", err)
+	}
+	key, err := k.publicKey()
+	if err != nil {
+		return nil, "", err
+	}
+	return key, k.thumbprint(), nil
+}
+
+// publicKey decodes k.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC coordinates")
+		}
+		point := append(append([]byte{4}, x...), y...)
+		key, err := ecdsa.ParseUncompressedPublicKey(curve, point)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC key: %w", err)
+		}
+		return key, nil
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA key")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < minRSABits || key.E < 3 || key.E%2 == 0 {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits with an odd exponent", minRSABits)
+		}
+		return key, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP key; only Ed25519 is supported")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// thumbprint returns the RFC 7638 SHA-256 thumbprint of k: the hash of its
+// required members, in lexicographic order, without whitespace.
+func (k jwk) thumbprint() string {
+	var canonical string
+	switch k.Kty {
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Crv, k.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// publicJWK encodes a public key as a JWK. Only the key types NewProof
+// signs with are supported.
+func publicJWK(pub crypto.PublicKey) (jwk, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return jwk{}, errors.New("only P-256 EC keys are supported")
+		}
+		point, err := pub.Bytes()
+		if err != nil {
+			return jwk{}, err
+		}
+		return jwk{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(point[1:33]),
+			Y:   base64.RawURLEncoding.EncodeToString(point[33:]),
+		}, nil
+	case ed25519.PublicKey:
+		return jwk{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)}, nil
+	}
+	return jwk{}, fmt.Errorf("unsupported key type %T", pub)
+}
+
+// Thumbprint returns the thumbprint that tokens bound to pub carry in
+// their cnf claim. pub must be a P-256 ECDSA or Ed25519 public key.
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	k, err := publicJWK(pub)
+	if err != nil {
+		return "", err
+	}
+	return k.thumbprint(), nil
+}
@@ -0,0 +1,293 @@
+// Package flags gates new behaviour behind feature flags so it can be
+// rolled out gradually: to a percentage of clients, to named clients, or
+// to everyone. Flags are declared in Definitions. Their rollout comes, in
+// increasing precedence, from a JSON file, the FEATURE_FLAGS environment
+// variable, and overrides set by administrators. Overrides are stored in
+// the database and reloaded periodically to pick up changes made on other
+// instances.
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/syncloop"
+)
+
+// Flags consulted by the service.
+const (
+	// PasswordContext rejects registration passwords that contain the
+	// username or the local part of the email address.
+	PasswordContext = "password-context-check"
+)
+
+// Definition declares a flag.
+type Definition struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Definitions lists every flag, in the order they are reported. Flags are
+// off until configured.
+var Definitions = []Definition{
+	{PasswordContext, "Reject registration passwords that contain the username or the email address's local part"},
+}
+
+// Known reports whether name is a declared flag.
+func Known(name string) bool {
+	return slices.ContainsFunc(Definitions, func(d Definition) bool { return d.Name == name })
+}
+
+// Sources of a flag's rollout, in increasing precedence.
+const (
+	SourceDefault  = "default"
+	SourceFile     = "file"
+	SourceEnv      = "env"
+	SourceOverride = "override"
+)
+
+// Rollout says for whom a flag is on. A disabled flag is off for everyone.
+// An enabled one is on for the listed Subjects and for Percent percent of
+// all others, chosen by a stable hash of the flag name and the subject so
+// that each client keeps its answer as the percentage grows.
+type Rollout struct {
+	Enabled  bool     `json:"enabled"`
+	Percent  int      `json:"percent"`
+	Subjects []string `json:"subjects,omitempty"`
+}
+
+// Validate reports an error unless Percent is between 0 and 100.
+func (r Rollout) Validate() error {
+	if r.Percent < 0 || r.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100, got %d", r.Percent)
+	}
+	return nil
+}
+
+// On reports whether the flag called name is on for subject. An empty
+// subject is only included at 100 percent.
+func (r Rollout) On(name, subject string) bool {
+	if !r.Enabled {
+		return false
+	}
+	if r.Percent >= 100 {
+		return true
+	}
+	if subject == "" {
+		return false
+	}
+	if slices.Contains(r.Subjects, subject) {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + subject))
+	return int(h.Sum32()%100) < r.Percent
+}
+
+// LoadFile reads rollouts from a JSON file mapping flag names to
+// rollouts, e.g. {"password-context-check": {"enabled": true, "percent": 25}}.
+func LoadFile(path string) (map[string]Rollout, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("feature flags file: %w", err)
+	}
+	var rollouts map[string]Rollout
+	if err := json.Unmarshal(data, &rollouts); err != nil {
+		return nil, fmt.Errorf("feature flags file %s: %w", path, err)
+	}
+	for name, r := range rollouts {
+		if !Known(name) {
+			return nil, fmt.Errorf("feature flags file %s: unknown flag %q", path, name)
+		}
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("feature flags file %s: %s: %w", path, name, err)
+		}
+	}
+	return rollouts, nil
+}
+
+// ParseEnv parses comma-separated name=value pairs, where value is "on"
+// (everyone), "off", or a percentage such as "25" or "25%".
+func ParseEnv(spec string) (map[string]Rollout, error) {
+	rollouts := make(map[string]Rollout)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name, value = strings.TrimSpace(name), strings.ToLower(strings.TrimSpace(value))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("FEATURE_FLAGS entry %q: expected name=value", entry)
+		}
+		if !Known(name) {
+			return nil, fmt.Errorf("FEATURE_FLAGS: unknown flag %q", name)
+		}
+		var r Rollout
+		switch value {
+		case "on", "true":
+			r = Rollout{Enabled: true, Percent: 100}
+		case "off", "false":
+		default:
+			n, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil {
+				return nil, fmt.Errorf("FEATURE_FLAGS entry %q: value must be on, off, or a percentage", entry)
+			}
+			r = Rollout{Enabled: true, Percent: n}
+			if err := r.Validate(); err != nil {
+				return nil, fmt.Errorf("FEATURE_FLAGS entry %q: %w", entry, err)
+			}
+		}
+		rollouts[name] = r
+	}
+	return rollouts, nil
+}
+
+// State is a flag's effective rollout and where it came from.
+type State struct {
+	Definition
+	Rollout
+	Source string `json:"source"`
+	// Override is the administrator's override, when one is set.
+	Override *models.FeatureFlag `json:"override,omitempty"`
+}
+
+// configured is a rollout with its source.
+type configured struct {
+	rollout Rollout
+	source  string
+}
+
+// Store holds overrides. store.Store satisfies it.
+type Store interface {
+	ListFeatureFlags(ctx context.Context) ([]models.FeatureFlag, error)
+}
+
+// Set evaluates flags. It is safe for concurrent use. A nil Set has every
+// flag off and no overrides.
+type Set struct {
+	store Store
+	// base holds the configured rollouts, which never change.
+	base map[string]configured
+
+	mu        sync.Mutex // serializes writers of overrides
+	overrides atomic.Pointer[map[string]models.FeatureFlag]
+}
+
+// New returns a set with the rollouts from a file and the environment,
+// either of which may be nil, and no overrides.
+func New(s Store, file, env map[string]Rollout) *Set {
+	base := make(map[string]configured, len(Definitions))
+	for _, d := range Definitions {
+		base[d.Name] = configured{source: SourceDefault}
+	}
+	for name, r := range file {
+		base[name] = configured{rollout: r, source: SourceFile}
+	}
+	for name, r := range env {
+		base[name] = configured{rollout: r, source: SourceEnv}
+	}
+	set := &Set{store: s, base: base}
+	set.overrides.Store(&map[string]models.FeatureFlag{})
+	return set
+}
+
+// Sync replaces the set's overrides with those in its store.
+func (s *Set) Sync(ctx context.Context) error {
+	list, err := s.store.ListFeatureFlags(ctx)
+	if err != nil {
+		return err
+	}
+	overrides := make(map[string]models.FeatureFlag, len(list))
+	for _, f := range list {
+		overrides[f.Name] = f
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides.Store(&overrides)
+	return nil
+}
+
+// Run keeps the overrides in sync with the store until ctx is canceled.
+func (s *Set) Run(ctx context.Context, interval time.Duration) {
+	syncloop.Run(ctx, interval, "Feature flag", s.Sync)
+}
+
+// SetOverride applies f on this instance immediately, rather than at the
+// next sync.
+func (s *Set) SetOverride(f models.FeatureFlag) {
+	s.update(func(overrides map[string]models.FeatureFlag) { overrides[f.Name] = f })
+}
+
+// RemoveOverride drops the override for name on this instance immediately.
+func (s *Set) RemoveOverride(name string) {
+	s.update(func(overrides map[string]models.FeatureFlag) { delete(overrides, name) })
+}
+
+// update replaces the overrides with a modified copy.
+func (s *Set) update(fn func(map[string]models.FeatureFlag)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	overrides := maps.Clone(*s.overrides.Load())
+	fn(overrides)
+	s.overrides.Store(&overrides)
+}
+
+// Enabled reports whether the flag called name is on for subject, which
+// identifies the client it is evaluated for: "user:42", "service:billing",
+// or another stable key such as "username:alice" before an account
+// exists.
+func (s *Set) Enabled(name, subject string) bool {
+	if s == nil {
+		return false
+	}
+	st, ok := s.State(name)
+	return ok && st.On(name, subject)
+}
+
+// State returns the effective rollout of the flag called name, or false
+// if no such flag is declared. A nil Set reports every flag off.
+func (s *Set) State(name string) (State, bool) {
+	for _, d := range Definitions {
+		if d.Name == name {
+			return s.state(d), true
+		}
+	}
+	return State{}, false
+}
+
+// States returns the effective rollout of every flag.
+func (s *Set) States() []State {
+	states := make([]State, 0, len(Definitions))
+	for _, d := range Definitions {
+		states = append(states, s.state(d))
+	}
+	return states
+}
+
+func (s *Set) state(d Definition) State {
+	if s == nil {
+		return State{Definition: d, Source: SourceDefault}
+	}
+	if f, ok := (*s.overrides.Load())[d.Name]; ok {
+		return State{
+			Definition: d,
+			Rollout:    Rollout{Enabled: f.Enabled, Percent: f.Percent, Subjects: f.Subjects},
+			Source:     SourceOverride,
+			Override:   &f,
+		}
+	}
+	c := s.base[d.Name]
+	return State{Definition: d, Rollout: c.rollout, Source: c.source}
+}
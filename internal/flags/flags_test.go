@@ -0,0 +1,118 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+func TestRolloutOn(t *testing.T) {
+	if (Rollout{Percent: 100}).On(PasswordContext, "user:1") {
+		t.Error("expected a disabled flag to be off")
+	}
+	if !(Rollout{Enabled: true, Percent: 100}).On(PasswordContext, "") {
+		t.Error("expected a fully rolled out flag to be on without a subject")
+	}
+	if (Rollout{Enabled: true, Percent: 99}).On(PasswordContext, "") {
+		t.Error("expected a partial rollout to exclude an empty subject")
+	}
+	if !(Rollout{Enabled: true, Subjects: []string{"service:qa"}}).On(PasswordContext, "service:qa") {
+		t.Error("expected a listed subject to be included at 0 percent")
+	}
+
+	// Subjects included at a percentage stay included as it grows, and
+	// roughly that share of subjects is included
+	quarter, half := 0, 0
+	for i := range 1000 {
+		subject := fmt.Sprintf("user:%d", i)
+		inQuarter := (Rollout{Enabled: true, Percent: 25}).On(PasswordContext, subject)
+		inHalf := (Rollout{Enabled: true, Percent: 50}).On(PasswordContext, subject)
+		if inQuarter && !inHalf {
+			t.Fatalf("expected %s to stay included as the rollout grows", subject)
+		}
+		if inQuarter {
+			quarter++
+		}
+		if inHalf {
+			half++
+		}
+	}
+	if quarter < 200 || quarter > 300 || half < 450 || half > 550 {
+		t.Errorf("unexpected rollout shares: %d and %d of 1000", quarter, half)
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	got, err := ParseEnv(" password-context-check = 25% ")
+	if err != nil || got[PasswordContext].Percent != 25 || !got[PasswordContext].Enabled {
+		t.Fatalf("unexpected rollouts %+v (%v)", got, err)
+	}
+	if got, _ := ParseEnv("password-context-check=on"); got[PasswordContext].Percent != 100 {
+		t.Errorf("expected on to mean everyone, got %+v", got)
+	}
+	if got, _ := ParseEnv("password-context-check=off"); got[PasswordContext].Enabled {
+		t.Errorf("expected off to disable the flag, got %+v", got)
+	}
+	for _, bad := range []string{"password-context-check", "unknown=on", "password-context-check=lots", "password-context-check=101"} {
+		if _, err := ParseEnv(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "flags.json")
+	os.WriteFile(path, []byte(`{"password-context-check": {"enabled": true, "percent": 10, "subjects": ["user:1"]}}`), 0o600)
+	got, err := LoadFile(path)
+	if err != nil || got[PasswordContext].Percent != 10 || len(got[PasswordContext].Subjects) != 1 {
+		t.Fatalf("unexpected rollouts %+v (%v)", got, err)
+	}
+	os.WriteFile(path, []byte(`{"unknown": {"enabled": true}}`), 0o600)
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected an unknown flag to be rejected")
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemStore()
+	file := map[string]Rollout{PasswordContext: {Enabled: true, Percent: 100}}
+	set := New(s, file, nil)
+	if st, _ := set.State(PasswordContext); st.Source != SourceFile || !set.Enabled(PasswordContext, "user:1") {
+		t.Fatalf("expected the file's rollout, got %+v", st)
+	}
+	set = New(s, file, map[string]Rollout{PasswordContext: {}})
+	if st, _ := set.State(PasswordContext); st.Source != SourceEnv || set.Enabled(PasswordContext, "user:1") {
+		t.Fatalf("expected the environment to take precedence, got %+v", st)
+	}
+
+	// Overrides made on another instance apply at the next sync
+	s.SetFeatureFlag(ctx, &models.FeatureFlag{Name: PasswordContext, Enabled: true, Subjects: []string{"user:1"}})
+	if err := set.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := set.State(PasswordContext); st.Source != SourceOverride || st.Override == nil {
+		t.Fatalf("expected the override to take precedence, got %+v", st)
+	}
+	if !set.Enabled(PasswordContext, "user:1") || set.Enabled(PasswordContext, "user:2") {
+		t.Error("expected the override to apply to its subject only")
+	}
+	set.RemoveOverride(PasswordContext)
+	if st, _ := set.State(PasswordContext); st.Source != SourceEnv {
+		t.Errorf("expected the environment's rollout once the override is removed, got %+v", st)
+	}
+
+	var none *Set
+	if none.Enabled(PasswordContext, "user:1") {
+		t.Error("expected a nil set to have every flag off")
+	}
+	if _, ok := set.State("unknown"); ok {
+		t.Error("expected no state for an unknown flag")
+	}
+}
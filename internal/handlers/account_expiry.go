@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/audit"
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/validation"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
+)
+
+// Audit actions recorded for account expiry.
+const (
+	auditUserCreate    = "user.create"
+	auditUserExpirySet = "user.expiry.set"
+)
+
+// Defaults of the users:expiring listing.
+const (
+	defaultExpiringWithin = 7 * 24 * time.Hour
+	maxExpiringWithin     = 365 * 24 * time.Hour
+)
+
+// expiredAccountsBatch is how many expired accounts DisableExpiredAccounts
+// disables per query.
+const expiredAccountsBatch = 100
+
+// createUserRequest is the payload for POST /api/admin/users.
+type createUserRequest struct {
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	Password  string     `json:"password"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// setExpiryRequest is the payload for PUT /api/admin/users/{id}/expiry. A
+// null expires_at removes the expiry.
+type setExpiryRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// validExpiry checks that an expiry being set is in the future, writing a
+// 400 when it is not.
+func validExpiry(w http.ResponseWriter, expiresAt *time.Time) bool {
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		writeErrorResponse(w, "expires_at must be in the future", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// AdminCreateUser handles POST /api/admin/users, creating an account with
+// the user role on someone's behalf, such as a contractor's account that
+// expires at expires_at. No recovery codes are issued; the user generates
+// them after signing in.
+func (h *Handlers) AdminCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req createUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Username = validation.SanitizeInput(req.Username)
+	req.Email = validation.SanitizeInput(req.Email)
+	req.Password = validation.SanitizeInput(req.Password)
+	if err := validation.ValidateRegistration(h.usernamePolicy(), req.Username, req.Email, req.Password); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.checkPassword(req.Password, req.Username, req.Email); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validExpiry(w, req.ExpiresAt) {
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		writeErrorResponse(w, "Failed to process password", http.StatusInternalServerError)
+		return
+	}
+	user := &models.User{
+		Username:  req.Username,
+		Email:     req.Email,
+		Password:  hashedPassword,
+		Role:      "user",
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: req.ExpiresAt,
+	}
+	err = h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if _, err := tx.CreateUser(r.Context(), user); err != nil {
+			return err
+		}
+		return recordUserAudit(r.Context(), tx, r, auditUserCreate, nil, user)
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			writeErrorResponse(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logger.FromContext(r.Context()).Error("User creation failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+
+	registrations.WithLabelValues().Inc()
+	logger.FromContext(r.Context()).Info("User created by admin", map[string]interface{}{
+		"user_id":  user.ID,
+		"admin_id": callerID(r),
+	})
+	h.publishEvent(r, webhooks.EventUserRegister, map[string]interface{}{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"admin_id": callerID(r),
+	})
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"user": adminView(user),
+	})
+}
+
+// AdminSetUserExpiry handles PUT /api/admin/users/{id}/expiry, setting or,
+// with a null expires_at, removing the date the account expires. Sessions
+// of an account that has expired end at their next refresh.
+func (h *Handlers) AdminSetUserExpiry(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+	if !h.mayManage(r, user) {
+		writeErrorResponse(w, "Only admins may change admin accounts", http.StatusForbidden)
+		return
+	}
+	var req setExpiryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if !validExpiry(w, req.ExpiresAt) {
+		return
+	}
+
+	before := snapshotUser(user)
+	user.ExpiresAt = req.ExpiresAt
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.UpdateUser(r.Context(), user); err != nil {
+			return err
+		}
+		return recordUserAudit(r.Context(), tx, r, auditUserExpirySet, before, user)
+	})
+	if errors.Is(err, store.ErrVersionConflict) {
+		writeErrorResponse(w, "User was modified by another request; reload and retry", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Account expiry update failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("Account expiry set", map[string]interface{}{
+		"user_id":    user.ID,
+		"admin_id":   callerID(r),
+		"expires_at": req.ExpiresAt,
+	})
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user": adminView(user),
+	})
+}
+
+// AdminListExpiringUsers handles GET /api/admin/users:expiring, listing the
+// enabled accounts that expire within the within duration (default a
+// week), soonest first. Accounts that have already expired but were not
+// yet disabled come first.
+func (h *Handlers) AdminListExpiringUsers(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+	within := defaultExpiringWithin
+	if v := r.URL.Query().Get("within"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxExpiringWithin {
+			writeErrorResponse(w, "within must be a positive duration of at most 8760h", http.StatusBadRequest)
+			return
+		}
+		within = d
+	}
+
+	users, err := h.Store.ListExpiringUsers(r.Context(), time.Now().Add(within), limit)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Expiring user query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	views := make([]*models.User, len(users))
+	for i, u := range users {
+		views[i] = adminView(u)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"users":  views,
+		"within": within.String(),
+	})
+}
+
+// DisableExpiredAccounts disables the enabled accounts that expired by
+// now, recording each in the audit log with no actor, and returns how
+// many it disabled. Their sessions already cannot be refreshed; disabling
+// them also signs them out of back-channel clients and sends user.disable
+// webhooks, and shows the state in the admin listings.
+func (h *Handlers) DisableExpiredAccounts(ctx context.Context, now time.Time) (int, error) {
+	disabled := 0
+	for {
+		users, err := h.Store.ListExpiringUsers(ctx, now, expiredAccountsBatch)
+		if err != nil {
+			return disabled, err
+		}
+		for _, user := range users {
+			before := snapshotUser(user)
+			user.Disabled = true
+			err := h.Store.WithTx(ctx, func(tx store.Store) error {
+				if err := tx.UpdateUser(ctx, user); err != nil {
+					return err
+				}
+				return tx.RecordAudit(ctx, &models.AuditEvent{
+					Action:     auditUserDisable,
+					TargetType: auditTargetUser,
+					TargetID:   user.ID,
+					Changes:    audit.DiffUser(before, user),
+				})
+			})
+			if err != nil {
+				return disabled, err
+			}
+			disabled++
+			h.announceStatusChange(ctx, user.ID, webhooks.EventUserDisable, map[string]interface{}{
+				"user_id": user.ID,
+				"reason":  "expired",
+			})
+		}
+		if len(users) < expiredAccountsBatch {
+			return disabled, nil
+		}
+	}
+}
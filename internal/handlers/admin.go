@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/config"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/mtls"
+	"github.com/mayvqt/Sentinel/internal/rbac"
+	"github.com/mayvqt/Sentinel/internal/storage"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/validation"
+)
+
+// adminView returns the representation of u shown to administrators: the
+// public fields plus all metadata regardless of per-key policy.
+func adminView(u *models.User) *models.User {
+	view := u.PublicUser()
+	view.Metadata = u.Metadata
+	return view
+}
+
+// isStaff reports whether role is the admin role or a delegated admin role.
+func (h *Handlers) isStaff(role string) bool {
+	return role == rbac.RoleAdmin || h.AdminRoles.IsDelegated(role)
+}
+
+// mayManage reports whether the caller of r may change user. Admins may
+// change anyone; delegated admins only users who are not staff, so that
+// they cannot lock out the admins above them.
+func (h *Handlers) mayManage(r *http.Request, user *models.User) bool {
+	if !h.isStaff(user.Role) || mtls.FromContext(r.Context()).HasScope(mtls.ScopeAdmin) {
+		return true
+	}
+	claims, ok := r.Context().Value("user").(*auth.Claims)
+	return ok && (claims.Role == rbac.RoleAdmin || slices.Contains(claims.Scopes, rbac.RoleAdmin))
+}
+
+// validateRole checks that role is a built-in or delegated admin role.
+func (h *Handlers) validateRole(role string) error {
+	if h.AdminRoles.IsDelegated(role) {
+		return nil
+	}
+	return validation.ValidateRole(role)
+}
+
+// pathUser loads the user identified by the {id} path wildcard. On failure it
+// writes the error response and returns false.
+func (h *Handlers) pathUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeErrorResponse(w, "Invalid user ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := h.Store.GetUserByID(r.Context(), id)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		writeErrorResponse(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	return user, true
+}
+
+// AdminGetUser handles GET /api/admin/users/{id}, limited to the fields
+// parameter's fields when it is set. The ETag header carries the version
+// that updates must send back in If-Match.
+func (h *Handlers) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	fields, ok := parseFields(w, r)
+	if !ok {
+		return
+	}
+	user, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusOK, fields.user(adminView(user)))
+}
+
+// AdminUpdateUserMetadata handles PATCH /api/admin/users/{id}/metadata.
+// Admins may write any key, including admin-only and read-only keys. The
+// request must include If-Match with the user's ETag; stale tags get 409.
+func (h *Handlers) AdminUpdateUserMetadata(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+	if !h.mayManage(r, user) {
+		writeErrorResponse(w, "Only admins may change admin accounts", http.StatusForbidden)
+		return
+	}
+	if !h.patchMetadata(w, r, user, true) {
+		return
+	}
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"metadata": user.Metadata,
+	})
+}
+
+// AdminCreateBackup handles POST /api/admin/backups. It snapshots the live
+// database into BackupDir under a timestamped name, and uploads it to
+// BackupBucket when one is set; clients cannot choose the destination.
+func (h *Handlers) AdminCreateBackup(w http.ResponseWriter, r *http.Request) {
+	backupper, ok := h.Store.(store.Backupper)
+	if !ok {
+		writeErrorResponse(w, "The configured store does not support backups", http.StatusNotImplemented)
+		return
+	}
+
+	dir := h.BackupDir
+	if dir == "" {
+		dir = "./backups"
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		logger.FromContext(r.Context()).Error("Failed to create backup directory", map[string]interface{}{
+			"dir":   dir,
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	path := filepath.Join(dir, "sentinel-"+now.Format("20060102T150405.000Z")+".db")
+	if err := backupper.Backup(r.Context(), path); err != nil {
+		logger.FromContext(r.Context()).Error("Database backup failed", map[string]interface{}{
+			"path":  path,
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+	logger.FromContext(r.Context()).Info("Database backup created", map[string]interface{}{
+		"path":       path,
+		"size_bytes": size,
+	})
+
+	resp := map[string]interface{}{
+		"path":       path,
+		"size_bytes": size,
+		"created_at": now.Format(time.RFC3339),
+	}
+	if h.BackupBucket != nil {
+		key, err := h.BackupBucket.PutFile(r.Context(), storage.KindBackups, now, path, "application/vnd.sqlite3")
+		if err != nil {
+			logger.FromContext(r.Context()).Error("Backup upload failed", map[string]interface{}{
+				"path":   path,
+				"bucket": h.BackupBucket.String(),
+				"error":  err.Error(),
+			})
+			writeErrorResponse(w, "Backup created but upload failed", http.StatusBadGateway)
+			return
+		}
+		resp["object_key"] = key
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// Pagination bounds for admin list endpoints.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// parsePagination reads limit and offset query parameters. On invalid
+// values it writes the error response and returns false.
+func parsePagination(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	if limit, ok = parseLimit(w, r); !ok {
+		return 0, 0, false
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeErrorResponse(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// parseLimit reads the limit query parameter. On an invalid value it
+// writes the error response and returns false.
+func parseLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultPageSize, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxPageSize {
+		writeErrorResponse(w, "limit must be between 1 and 100", http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+// searchResult is one entry in the admin user search response.
+type searchResult struct {
+	User       interface{}       `json:"user"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+// AdminSearchUsers handles GET /api/admin/users/search?q=. It matches q
+// against usernames and emails by prefix, substring, or approximate
+// spelling, and returns a page of results with matches wrapped in <mark>.
+// The fields parameter limits the user in each result.
+func (h *Handlers) AdminSearchUsers(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeErrorResponse(w, "Query parameter q is required", http.StatusBadRequest)
+		return
+	}
+	if len(q) > 100 {
+		writeErrorResponse(w, "Query must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	limit, offset, ok := parsePagination(w, r)
+	if !ok {
+		return
+	}
+	fields, ok := parseFields(w, r)
+	if !ok {
+		return
+	}
+
+	hits, total, err := h.Store.SearchUsers(r.Context(), q, limit, offset)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("User search failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	results := make([]searchResult, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, searchResult{
+			User:       fields.user(adminView(hit.User)),
+			Score:      hit.Score,
+			Highlights: hit.Highlights,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// AdminConfig handles GET /api/admin/config, returning the effective
+// configuration with secrets masked and where each value came from. An
+// optional source parameter ("env", "file", or "default") narrows the list.
+func (h *Handlers) AdminConfig(w http.ResponseWriter, r *http.Request) {
+	source := r.URL.Query().Get("source")
+	switch source {
+	case "", config.SourceEnv, config.SourceFile, config.SourceDefault:
+	default:
+		writeErrorResponse(w, "source must be env, file, or default", http.StatusBadRequest)
+		return
+	}
+	settings := make([]config.Setting, 0, len(h.Settings))
+	for _, s := range h.Settings {
+		if source == "" || s.Source == source {
+			settings = append(settings, s)
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"settings": settings})
+}
+
+// AdminStats handles GET /api/admin/stats, returning user and session
+// counts for the admin dashboard.
+func (h *Handlers) AdminStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.Store.Stats(r.Context())
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Stats query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// AdminCountUsers handles GET /api/admin/users:count, returning how many
+// users match the optional role, disabled, since, until, and
+// expires_before parameters.
+func (h *Handlers) AdminCountUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := store.UserFilter{Role: q.Get("role")}
+	if v := q.Get("disabled"); v != "" {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			writeErrorResponse(w, "disabled must be true or false", http.StatusBadRequest)
+			return
+		}
+		f.Disabled = &disabled
+	}
+	for param, dst := range map[string]*time.Time{"since": &f.CreatedAfter, "until": &f.CreatedBefore, "expires_before": &f.ExpiresBefore} {
+		if v := q.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeErrorResponse(w, param+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+
+	n, err := h.Store.CountUsers(r.Context(), f)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("User count failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": n})
+}
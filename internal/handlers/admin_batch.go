@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
+)
+
+const (
+	// maxBatchSize caps the number of IDs accepted by one batch request.
+	maxBatchSize = 1000
+	// batchChunkSize is the number of IDs applied per transaction, bounding
+	// how long the write lock is held and how much work a failure rolls back.
+	batchChunkSize = 100
+)
+
+// Per-item batch outcomes.
+const (
+	batchStatusOK        = "ok"
+	batchStatusUnchanged = "unchanged"
+	batchStatusNotFound  = "not_found"
+	batchStatusSkipped   = "skipped"
+	batchStatusFailed    = "failed"
+)
+
+// batchRequest is the payload for the /api/admin/users:batch* endpoints.
+type batchRequest struct {
+	IDs  []int64 `json:"ids"`
+	Role string  `json:"role,omitempty"`
+	// DryRun is set from the dry_run query parameter.
+	DryRun bool `json:"-"`
+}
+
+// batchItemResult reports the outcome for a single ID.
+type batchItemResult struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchResponse is returned by every batch endpoint. In a dry run, ok
+// results are the users the operation would change. Unchanged users
+// already had the requested state and count as neither succeeded nor
+// failed.
+type batchResponse struct {
+	Results   []batchItemResult `json:"results"`
+	Succeeded int               `json:"succeeded"`
+	Unchanged int               `json:"unchanged"`
+	Failed    int               `json:"failed"`
+	DryRun    bool              `json:"dry_run,omitempty"`
+}
+
+// batchSkip marks an item the operation refused to apply; unlike other
+// errors it does not abort the rest of the chunk.
+type batchSkip string
+
+func (e batchSkip) Error() string { return string(e) }
+
+// batchUnchanged marks an item that already has the requested state, so
+// the operation has nothing to do for it.
+type batchUnchanged string
+
+func (e batchUnchanged) Error() string { return string(e) }
+
+// batchOp applies an operation to one user within a chunk transaction. In
+// a dry run it makes the same checks but changes nothing.
+type batchOp func(ctx context.Context, tx store.Store, id int64, dryRun bool) error
+
+// AdminBatchDisable handles POST /api/admin/users:batchDisable.
+func (h *Handlers) AdminBatchDisable(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBatchRequest(w, r)
+	if !ok {
+		return
+	}
+	self := callerID(r)
+	done := h.runBatch(w, r, "disable", req, func(ctx context.Context, tx store.Store, id int64, dryRun bool) error {
+		if id == self {
+			return batchSkip("cannot disable your own account")
+		}
+		user, err := tx.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return store.ErrNotFound
+		}
+		if !h.mayManage(r, user) {
+			return batchSkip("only admins may disable admin accounts")
+		}
+		if user.Disabled {
+			return batchUnchanged("already disabled")
+		}
+		if dryRun {
+			return nil
+		}
+		before := snapshotUser(user)
+		user.Disabled = true
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		return recordUserAudit(ctx, tx, r, auditUserDisable, before, user)
+	})
+	h.notifyLogout(r, done...)
+	h.publishUserEvents(r, webhooks.EventUserDisable, done)
+}
+
+// AdminBatchDelete handles POST /api/admin/users:batchDelete.
+func (h *Handlers) AdminBatchDelete(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBatchRequest(w, r)
+	if !ok {
+		return
+	}
+	self := callerID(r)
+	done := h.runBatch(w, r, "delete", req, func(ctx context.Context, tx store.Store, id int64, dryRun bool) error {
+		if id == self {
+			return batchSkip("cannot delete your own account")
+		}
+		user, err := tx.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return store.ErrNotFound
+		}
+		if !h.mayManage(r, user) {
+			return batchSkip("only admins may delete admin accounts")
+		}
+		if dryRun {
+			return nil
+		}
+		if err := tx.DeleteUser(ctx, id); err != nil {
+			return err
+		}
+		return recordUserAudit(ctx, tx, r, auditUserDelete, user, nil)
+	})
+	h.notifyLogout(r, done...)
+	h.publishUserEvents(r, webhooks.EventUserDelete, done)
+}
+
+// AdminBatchAssignRole handles POST /api/admin/users:batchAssignRole.
+func (h *Handlers) AdminBatchAssignRole(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBatchRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := h.validateRole(req.Role); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	self := callerID(r)
+	h.runBatch(w, r, "assign_role", req, func(ctx context.Context, tx store.Store, id int64, dryRun bool) error {
+		if id == self && req.Role != "admin" {
+			return batchSkip("cannot remove your own admin role")
+		}
+		user, err := tx.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return store.ErrNotFound
+		}
+		if user.Role == req.Role {
+			return batchUnchanged("already has role " + req.Role)
+		}
+		if dryRun {
+			return nil
+		}
+		before := snapshotUser(user)
+		user.Role = req.Role
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		return recordUserAudit(ctx, tx, r, auditUserRoleAssign, before, user)
+	})
+}
+
+// publishUserEvents publishes an event for each user an admin changed.
+func (h *Handlers) publishUserEvents(r *http.Request, event string, userIDs []int64) {
+	for _, id := range userIDs {
+		h.publishEvent(r, event, map[string]interface{}{
+			"user_id":  id,
+			"admin_id": callerID(r),
+		})
+	}
+}
+
+// decodeBatchRequest parses and validates a batch payload and its dry_run
+// parameter, dropping duplicate IDs. On failure it writes the error
+// response and returns false.
+func decodeBatchRequest(w http.ResponseWriter, r *http.Request) (*batchRequest, bool) {
+	dryRun, ok := parseDryRun(w, r)
+	if !ok {
+		return nil, false
+	}
+	req := batchRequest{DryRun: dryRun}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(req.IDs) == 0 {
+		writeErrorResponse(w, "ids must contain at least one user ID", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(req.IDs) > maxBatchSize {
+		writeErrorResponse(w, fmt.Sprintf("at most %d ids may be sent per request", maxBatchSize), http.StatusBadRequest)
+		return nil, false
+	}
+
+	seen := make(map[int64]bool, len(req.IDs))
+	ids := req.IDs[:0]
+	for _, id := range req.IDs {
+		if id <= 0 {
+			writeErrorResponse(w, "ids must be positive integers", http.StatusBadRequest)
+			return nil, false
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	req.IDs = ids
+	return &req, true
+}
+
+// parseDryRun reads the dry_run query parameter. On an invalid value it
+// writes the error response and returns false.
+func parseDryRun(w http.ResponseWriter, r *http.Request) (dryRun, ok bool) {
+	v := r.URL.Query().Get("dry_run")
+	if v == "" {
+		return false, true
+	}
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		writeErrorResponse(w, "dry_run must be true or false", http.StatusBadRequest)
+		return false, false
+	}
+	return dryRun, true
+}
+
+// callerID returns the authenticated user's ID, or 0 if unknown.
+func callerID(r *http.Request) int64 {
+	claims, ok := r.Context().Value("user").(*auth.Claims)
+	if !ok {
+		return 0
+	}
+	id, _ := strconv.ParseInt(claims.UserID, 10, 64)
+	return id
+}
+
+// runBatch applies op to req.IDs in transactional chunks and writes
+// per-item results. Missing users and skipped or unchanged items are
+// reported without affecting their neighbours; any other error rolls back the whole chunk,
+// whose items are then reported as failed. It returns the IDs the
+// operation changed, or none in a dry run.
+func (h *Handlers) runBatch(w http.ResponseWriter, r *http.Request, action string, req *batchRequest, op batchOp) []int64 {
+	ctx := r.Context()
+	ids := req.IDs
+	resp := batchResponse{Results: make([]batchItemResult, 0, len(ids)), DryRun: req.DryRun}
+
+	for start := 0; start < len(ids); start += batchChunkSize {
+		end := min(start+batchChunkSize, len(ids))
+		chunk := ids[start:end]
+		results := make([]batchItemResult, len(chunk))
+
+		err := h.Store.WithTx(ctx, func(tx store.Store) error {
+			for i, id := range chunk {
+				results[i] = batchItemResult{ID: id, Status: batchStatusOK}
+				err := op(ctx, tx, id, req.DryRun)
+				var skip batchSkip
+				var unchanged batchUnchanged
+				switch {
+				case err == nil:
+				case errors.Is(err, store.ErrNotFound):
+					results[i].Status = batchStatusNotFound
+				case errors.As(err, &skip):
+					results[i].Status = batchStatusSkipped
+					results[i].Error = skip.Error()
+				case errors.As(err, &unchanged):
+					results[i].Status = batchStatusUnchanged
+					results[i].Error = unchanged.Error()
+				default:
+					return fmt.Errorf("user %d: %w", id, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			logger.FromContext(r.Context()).Error("Batch admin operation chunk rolled back", map[string]interface{}{
+				"action": action,
+				"ids":    len(chunk),
+				"error":  err.Error(),
+			})
+			for i, id := range chunk {
+				results[i] = batchItemResult{ID: id, Status: batchStatusFailed, Error: "chunk rolled back after an internal error"}
+			}
+		}
+		resp.Results = append(resp.Results, results...)
+	}
+
+	var done []int64
+	for _, res := range resp.Results {
+		switch res.Status {
+		case batchStatusOK:
+			resp.Succeeded++
+			done = append(done, res.ID)
+		case batchStatusUnchanged:
+			resp.Unchanged++
+		default:
+			resp.Failed++
+		}
+	}
+
+	logger.FromContext(r.Context()).Info("Batch admin operation completed", map[string]interface{}{
+		"action":    action,
+		"admin_id":  callerID(r),
+		"succeeded": resp.Succeeded,
+		"unchanged": resp.Unchanged,
+		"failed":    resp.Failed,
+		"dry_run":   resp.DryRun,
+	})
+	writeJSON(w, http.StatusOK, resp)
+	if resp.DryRun {
+		return nil
+	}
+	return done
+}
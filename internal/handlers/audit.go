@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/audit"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+)
+
+// Audit actions recorded for admin changes to users.
+const (
+	auditUserMetadataUpdate = "user.metadata.update"
+	auditUserDisable        = "user.disable"
+	auditUserDelete         = "user.delete"
+	auditUserRoleAssign     = "user.role.assign"
+	auditUserMerge          = "user.merge"
+)
+
+// auditTargetUser is the target type of user audit events.
+const auditTargetUser = "user"
+
+// recordUserAudit records the acting admin's change from before to after
+// (nil for a deletion). Writing through s lets the event commit or roll
+// back together with the change when s is a transaction.
+func recordUserAudit(ctx context.Context, s store.Store, r *http.Request, action string, before, after *models.User) error {
+	return s.RecordAudit(ctx, userAuditEvent(r, action, before, after))
+}
+
+// userAuditEvent builds the event recordUserAudit records, for callers that
+// add changes of their own.
+func userAuditEvent(r *http.Request, action string, before, after *models.User) *models.AuditEvent {
+	target := before
+	if target == nil {
+		target = after
+	}
+	return &models.AuditEvent{
+		ActorID:    callerID(r),
+		Action:     action,
+		TargetType: auditTargetUser,
+		TargetID:   target.ID,
+		Changes:    audit.DiffUser(before, after),
+		RequestID:  tracing.RequestIDFromContext(r.Context()),
+		TokenID:    callerTokenID(r),
+		IP:         middleware.ClientIP(r),
+		UserAgent:  audit.UserAgent(r.UserAgent()),
+	}
+}
+
+// snapshotUser returns a copy of u to diff against after it is modified.
+func snapshotUser(u *models.User) *models.User {
+	c := *u
+	return &c
+}
+
+// AdminListAudit handles GET /api/admin/audit. Events are filtered by the
+// optional actor_id, target_type, target_id, action, jti, since, and until
+// (RFC 3339) query parameters and returned newest first.
+func (h *Handlers) AdminListAudit(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePagination(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	f := store.AuditFilter{
+		TargetType: q.Get("target_type"),
+		Action:     q.Get("action"),
+		TokenID:    q.Get("jti"),
+		Limit:      limit,
+		Offset:     offset,
+	}
+	for param, dst := range map[string]*int64{"actor_id": &f.ActorID, "target_id": &f.TargetID} {
+		if v := q.Get(param); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				writeErrorResponse(w, param+" must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+	for param, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeErrorResponse(w, param+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+
+	events, total, err := h.Store.ListAuditEvents(r.Context(), f)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Audit query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"events": events,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// AdminAuditRetention handles GET /api/admin/audit/retention, reporting the
+// audit log retention policy, the oldest event still kept, and the outcome
+// of the last retention run, on any instance, that deleted events or
+// failed.
+func (h *Handlers) AdminAuditRetention(w http.ResponseWriter, r *http.Request) {
+	events, total, err := h.Store.ListAuditEvents(r.Context(), store.AuditFilter{Limit: 1})
+	var runs []models.AuditEvent
+	if err == nil && total > 0 {
+		events, _, err = h.Store.ListAuditEvents(r.Context(), store.AuditFilter{Limit: 1, Offset: total - 1})
+	}
+	if err == nil {
+		runs, _, err = h.Store.ListAuditEvents(r.Context(), store.AuditFilter{
+			TargetType: audit.RetentionTarget,
+			Actions:    []string{audit.RetentionAction, audit.RetentionFailedAction},
+			Limit:      1,
+		})
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Audit retention query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"enabled":  h.AuditRetention != nil,
+		"events":   total,
+		"last_run": nil,
+	}
+	if p := h.AuditRetention; p != nil {
+		resp["retention_days"] = int(p.MaxAge / (24 * time.Hour))
+		resp["archive"] = nil
+		if p.Archive != nil {
+			resp["archive"] = p.Archive.String()
+		}
+	}
+	if total > 0 && len(events) > 0 {
+		resp["oldest_event_at"] = events[0].CreatedAt
+	}
+	if len(runs) > 0 {
+		run := map[string]interface{}{
+			"at":        runs[0].CreatedAt,
+			"succeeded": runs[0].Action == audit.RetentionAction,
+		}
+		for _, c := range runs[0].Changes {
+			run[c.Field] = c.After
+		}
+		resp["last_run"] = run
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mayvqt/Sentinel/internal/imaging"
+	"github.com/mayvqt/Sentinel/internal/logger"
+)
+
+// Avatar upload defaults used when the corresponding Handlers fields are zero.
+const (
+	defaultAvatarMaxBytes  = 2 << 20 // 2 MB
+	defaultAvatarDimension = 256
+)
+
+// UploadAvatar handles PUT /api/auth/profile/avatar. It accepts a multipart
+// form with an "avatar" file field, validates size and image type, resizes
+// the image to a square thumbnail, stores it, and updates the user's avatar URL.
+func (h *Handlers) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	if h.Media == nil {
+		writeErrorResponse(w, "Avatar uploads are not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	user, ok := h.currentUser(w, r)
+	if !ok || !checkIfMatch(w, r, user, false) {
+		return
+	}
+
+	maxBytes := h.AvatarMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultAvatarMaxBytes
+	}
+	dimension := h.AvatarDimension
+	if dimension <= 0 {
+		dimension = defaultAvatarDimension
+	}
+
+	// Allow some headroom for multipart boundaries and headers.
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+	if err := r.ParseMultipartForm(maxBytes); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeErrorResponse(w, fmt.Sprintf("Avatar must be at most %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		writeErrorResponse(w, "Invalid multipart payload", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("avatar")
+	if err != nil {
+		writeErrorResponse(w, "Missing avatar file field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxBytes {
+		writeErrorResponse(w, fmt.Sprintf("Avatar must be at most %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		writeErrorResponse(w, "Failed to read avatar", http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > maxBytes {
+		writeErrorResponse(w, fmt.Sprintf("Avatar must be at most %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	img, err := imaging.Decode(data)
+	if err != nil {
+		if errors.Is(err, imaging.ErrTooLarge) {
+			writeErrorResponse(w, "Avatar image dimensions are too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		writeErrorResponse(w, "Avatar must be a PNG, JPEG, or GIF image", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	encoded, err := imaging.EncodePNG(imaging.Thumbnail(img, dimension))
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Avatar encoding failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to process avatar", http.StatusInternalServerError)
+		return
+	}
+
+	key, err := avatarKey(user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Media.Put(r.Context(), key, bytes.NewReader(encoded), int64(len(encoded)), "image/png"); err != nil {
+		logger.FromContext(r.Context()).Error("Avatar storage failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to store avatar", http.StatusInternalServerError)
+		return
+	}
+
+	previous := user.AvatarURL
+	user.AvatarURL = h.Media.URL(key)
+	if err := h.Store.UpdateUser(r.Context(), user); err != nil {
+		_ = h.Media.Delete(r.Context(), key)
+		logger.FromContext(r.Context()).Error("Avatar update failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeUpdateError(w, err, "Failed to update profile")
+		return
+	}
+
+	// Best-effort removal of the replaced avatar.
+	if oldKey, ok := h.Media.KeyFromURL(previous); ok && previous != "" {
+		if err := h.Media.Delete(r.Context(), oldKey); err != nil {
+			logger.FromContext(r.Context()).Warn("Failed to delete previous avatar", map[string]interface{}{
+				"user_id": user.ID,
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusOK, h.profileView(user))
+}
+
+// avatarKey returns a unique object key for a new avatar so that CDN caches
+// never serve a stale image after replacement.
+func avatarKey(userID int64) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("avatars/%d/%s.png", userID, hex.EncodeToString(b)), nil
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/canary"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+	"github.com/mayvqt/Sentinel/internal/validation"
+)
+
+// DefaultCanaryTokenTTL is the lifetime of canary tokens created without
+// expires_at. Expired tokens are rejected before they are recognized as
+// canaries, so the default is long enough to outlast any plausible leak.
+const DefaultCanaryTokenTTL = 10 * 365 * 24 * time.Hour
+
+// Audit actions recorded for canary management.
+const (
+	auditCanaryCreate = "canary.create"
+	auditCanaryDelete = "canary.delete"
+)
+
+// canaryPasswordHash is stored for bait accounts; it is not a valid bcrypt
+// hash, so no password ever matches it.
+const canaryPasswordHash = "!"
+
+// createCanaryRequest is the payload for POST /api/admin/canaries. Account
+// canaries use Username and Email; token canaries use UserID, Role, and
+// ExpiresAt.
+type createCanaryRequest struct {
+	Kind      string     `json:"kind"`
+	Note      string     `json:"note"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	UserID    int64      `json:"user_id"`
+	Role      string     `json:"role"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// canaryTripped reports whether user is a bait account, raising its alert
+// when it is. Callers carry on as they would for any other account, so the
+// client cannot tell that it was caught.
+func (h *Handlers) canaryTripped(r *http.Request, user *models.User, via string) bool {
+	if h.Canaries == nil || user == nil {
+		return false
+	}
+	c, ok := h.Canaries.Account(user.ID)
+	if ok {
+		h.Canaries.Trip(r, c, via)
+	}
+	return ok
+}
+
+// recordCanaryAudit records the acting admin's creation or deletion of c.
+func recordCanaryAudit(r *http.Request, s store.Store, action string, c *models.Canary) error {
+	return s.RecordAudit(r.Context(), &models.AuditEvent{
+		ActorID:    callerID(r),
+		Action:     action,
+		TargetType: canary.AuditTarget,
+		TargetID:   c.ID,
+		RequestID:  tracing.RequestIDFromContext(r.Context()),
+		TokenID:    callerTokenID(r),
+	})
+}
+
+// AdminListCanaries handles GET /api/admin/canaries.
+func (h *Handlers) AdminListCanaries(w http.ResponseWriter, r *http.Request) {
+	canaries, err := h.Store.ListCanaries(r.Context())
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Canary query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if canaries == nil {
+		canaries = []models.Canary{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"canaries": canaries,
+	})
+}
+
+// AdminCreateCanary handles POST /api/admin/canaries. An account canary
+// creates a bait account that can never sign in; a token canary returns a
+// bait access token, shown only in this response, that is never accepted.
+// Any use of either raises an alert.
+func (h *Handlers) AdminCreateCanary(w http.ResponseWriter, r *http.Request) {
+	var req createCanaryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	switch req.Kind {
+	case models.CanaryAccount:
+		h.createCanaryAccount(w, r, req)
+	case models.CanaryToken:
+		h.createCanaryToken(w, r, req)
+	default:
+		writeErrorResponse(w, "kind must be \"account\" or \"token\"", http.StatusBadRequest)
+	}
+}
+
+func (h *Handlers) createCanaryAccount(w http.ResponseWriter, r *http.Request, req createCanaryRequest) {
+	req.Username = validation.SanitizeInput(req.Username)
+	req.Email = validation.SanitizeInput(req.Email)
+	if err := h.usernamePolicy().Validate(req.Username); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Email != "" {
+		if err := validation.ValidateEmail(req.Email); err != nil {
+			writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	user := &models.User{
+		Username:  req.Username,
+		Email:     req.Email,
+		Password:  canaryPasswordHash,
+		Role:      "user",
+		CreatedAt: time.Now().UTC(),
+	}
+	c := &models.Canary{Kind: models.CanaryAccount, Note: req.Note, CreatedBy: callerID(r)}
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		taken, err := tx.UsernameExists(r.Context(), req.Username)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errUsernameTaken
+		}
+		if user.ID, err = tx.CreateUser(r.Context(), user); err != nil {
+			return err
+		}
+		c.UserID = user.ID
+		if err := tx.CreateCanary(r.Context(), c); err != nil {
+			return err
+		}
+		return recordCanaryAudit(r, tx, auditCanaryCreate, c)
+	})
+	if err != nil {
+		if errors.Is(err, errUsernameTaken) || strings.Contains(err.Error(), "already exists") {
+			writeErrorResponse(w, "Username or email already exists", http.StatusConflict)
+			return
+		}
+		logger.FromContext(r.Context()).Error("Canary creation failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create canary", http.StatusInternalServerError)
+		return
+	}
+	if h.Canaries != nil {
+		h.Canaries.Add(*c)
+	}
+
+	logger.FromContext(r.Context()).Info("Canary account created", map[string]interface{}{
+		"canary_id": c.ID,
+		"user_id":   user.ID,
+		"admin_id":  c.CreatedBy,
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"canary": c,
+		"user":   adminView(user),
+	})
+}
+
+func (h *Handlers) createCanaryToken(w http.ResponseWriter, r *http.Request, req createCanaryRequest) {
+	if req.UserID < 0 {
+		writeErrorResponse(w, "user_id must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = "admin"
+	}
+	if err := h.validateRole(req.Role); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(DefaultCanaryTokenTTL)
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			writeErrorResponse(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		expiresAt = *req.ExpiresAt
+	}
+
+	fail := func(err error) {
+		logger.FromContext(r.Context()).Error("Canary creation failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create canary", http.StatusInternalServerError)
+	}
+	token, err := h.Auth.GenerateTokenWithType(strconv.FormatInt(req.UserID, 10), req.Role, "access", expiresAt.Sub(now))
+	if err != nil {
+		fail(err)
+		return
+	}
+	claims, err := h.Auth.ParseToken(token)
+	if err != nil {
+		fail(err)
+		return
+	}
+	// The token is also revoked, so it stays unusable even on an instance
+	// that has not yet loaded the canary
+	if err := h.revokeToken(r, claims.ID, expiresAt); err != nil {
+		fail(err)
+		return
+	}
+	c := &models.Canary{Kind: models.CanaryToken, UserID: req.UserID, TokenID: claims.ID, Note: req.Note, CreatedBy: callerID(r)}
+	err = h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.CreateCanary(r.Context(), c); err != nil {
+			return err
+		}
+		return recordCanaryAudit(r, tx, auditCanaryCreate, c)
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+	if h.Canaries != nil {
+		h.Canaries.Add(*c)
+	}
+
+	logger.FromContext(r.Context()).Info("Canary token created", map[string]interface{}{
+		"canary_id": c.ID,
+		"admin_id":  c.CreatedBy,
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"canary":     c,
+		"token":      token,
+		"expires_at": expiresAt.UTC(),
+	})
+}
+
+// AdminDeleteCanary handles DELETE /api/admin/canaries/{id}. Deleting an
+// account canary also deletes its bait account; a deleted token canary's
+// token stays revoked.
+func (h *Handlers) AdminDeleteCanary(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeErrorResponse(w, "Invalid canary ID", http.StatusBadRequest)
+		return
+	}
+
+	var deleted *models.Canary
+	err = h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		canaries, err := tx.ListCanaries(r.Context())
+		if err != nil {
+			return err
+		}
+		for i := range canaries {
+			if canaries[i].ID == id {
+				deleted = &canaries[i]
+			}
+		}
+		if deleted == nil {
+			return store.ErrNotFound
+		}
+		if err := tx.DeleteCanary(r.Context(), id); err != nil {
+			return err
+		}
+		if deleted.Kind == models.CanaryAccount {
+			err := tx.DeleteUser(r.Context(), deleted.UserID)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+		}
+		return recordCanaryAudit(r, tx, auditCanaryDelete, deleted)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		writeErrorResponse(w, "Canary not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Canary deletion failed", map[string]interface{}{
+			"canary_id": id,
+			"error":     err.Error(),
+		})
+		writeErrorResponse(w, "Failed to delete canary", http.StatusInternalServerError)
+		return
+	}
+	if h.Canaries != nil {
+		h.Canaries.Remove(id)
+	}
+
+	logger.FromContext(r.Context()).Info("Canary deleted", map[string]interface{}{
+		"canary_id": id,
+		"admin_id":  callerID(r),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
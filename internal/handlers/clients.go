@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/clients"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+)
+
+// Audit actions recorded for client registration.
+const (
+	auditClientSet    = "client.set"
+	auditClientDelete = "client.delete"
+)
+
+// auditTargetClient is the target type of client audit events.
+const auditTargetClient = "client"
+
+// defaultAccessTokenTTL is the lifetime of the access tokens issued at
+// login and refresh, unless the client has its own.
+const defaultAccessTokenTTL = time.Hour
+
+// setClientRequest is the payload for PUT /api/admin/clients/{client_id}.
+// Zero values keep the server's policy.
+type setClientRequest struct {
+	Name            string   `json:"name"`
+	AccessTokenTTL  int      `json:"access_token_ttl"`
+	RefreshTokenTTL int      `json:"refresh_token_ttl"`
+	RefreshPolicy   string   `json:"refresh_policy"`
+	AllowedScopes   []string `json:"allowed_scopes"`
+	CORSOrigins     []string `json:"cors_origins"`
+}
+
+// client returns the registered client clientID, or nil when it is empty
+// or not registered.
+func (h *Handlers) client(clientID string) *models.Client {
+	if clientID == "" {
+		return nil
+	}
+	c, _ := h.Clients.Get(clientID)
+	return c
+}
+
+// clientScope checks the client_id and scope sent with a login, returning
+// the client's ID and the scope granted to it; both are empty when no
+// client was named. It writes a 400 and returns false when the client is
+// not registered or asks for a scope it is not allowed.
+func (h *Handlers) clientScope(w http.ResponseWriter, clientID, scope string) (string, string, bool) {
+	if clientID == "" {
+		if scope != "" {
+			writeErrorResponse(w, "scope requires a client_id", http.StatusBadRequest)
+			return "", "", false
+		}
+		return "", "", true
+	}
+	c := h.client(clientID)
+	if c == nil {
+		writeErrorResponse(w, "Unknown client", http.StatusBadRequest)
+		return "", "", false
+	}
+	granted, err := clients.GrantScope(c, scope)
+	if err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return "", "", false
+	}
+	return c.ClientID, granted, true
+}
+
+// accessTokenTTL returns the lifetime of access tokens issued to client,
+// which is nil for sessions without one.
+func accessTokenTTL(client *models.Client) time.Duration {
+	if client != nil && client.AccessTokenTTL > 0 {
+		return time.Duration(client.AccessTokenTTL) * time.Second
+	}
+	return defaultAccessTokenTTL
+}
+
+// slidingRefresh reports whether client's sessions follow the sliding
+// refresh policy rather than the fixed one.
+func (h *Handlers) slidingRefresh(client *models.Client) bool {
+	if client != nil && client.RefreshPolicy != "" {
+		return client.RefreshPolicy == models.RefreshSliding
+	}
+	return h.RefreshSliding
+}
+
+// clientPathID returns the {client_id} path value, writing a 400 when it is
+// not a valid client ID.
+func clientPathID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("client_id")
+	if err := clients.ValidID(id); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return id, true
+}
+
+// recordClientAudit records the acting admin's change to a client; before
+// is nil when it was registered and after when it was deleted.
+func recordClientAudit(r *http.Request, s store.Store, action string, before, after *models.Client) error {
+	var changes []models.FieldChange
+	field := func(name string, get func(*models.Client) interface{}) {
+		var b, a interface{}
+		if before != nil {
+			b = get(before)
+		}
+		if after != nil {
+			a = get(after)
+		}
+		if b != a {
+			changes = append(changes, models.FieldChange{Field: name, Before: b, After: a})
+		}
+	}
+	field("client_id", func(c *models.Client) interface{} { return c.ClientID })
+	field("name", func(c *models.Client) interface{} { return c.Name })
+	field("access_token_ttl", func(c *models.Client) interface{} { return c.AccessTokenTTL })
+	field("refresh_token_ttl", func(c *models.Client) interface{} { return c.RefreshTokenTTL })
+	field("refresh_policy", func(c *models.Client) interface{} { return c.RefreshPolicy })
+	list := func(name string, get func(*models.Client) []string) {
+		var b, a []string
+		if before != nil {
+			b = get(before)
+		}
+		if after != nil {
+			a = get(after)
+		}
+		if !slices.Equal(b, a) {
+			changes = append(changes, models.FieldChange{Field: name, Before: b, After: a})
+		}
+	}
+	list("allowed_scopes", func(c *models.Client) []string { return c.AllowedScopes })
+	list("cors_origins", func(c *models.Client) []string { return c.CORSOrigins })
+
+	target := after
+	if target == nil {
+		target = before
+	}
+	return s.RecordAudit(r.Context(), &models.AuditEvent{
+		ActorID:    callerID(r),
+		Action:     action,
+		TargetType: auditTargetClient,
+		TargetID:   target.ID,
+		Changes:    changes,
+		RequestID:  tracing.RequestIDFromContext(r.Context()),
+		TokenID:    callerTokenID(r),
+	})
+}
+
+// AdminListClients handles GET /api/admin/clients.
+func (h *Handlers) AdminListClients(w http.ResponseWriter, r *http.Request) {
+	list, err := h.Store.ListClients(r.Context())
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Client query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []models.Client{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"clients": list,
+	})
+}
+
+// AdminGetClient handles GET /api/admin/clients/{client_id}.
+func (h *Handlers) AdminGetClient(w http.ResponseWriter, r *http.Request) {
+	id, ok := clientPathID(w, r)
+	if !ok {
+		return
+	}
+	c, err := h.Store.GetClient(r.Context(), id)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Client query failed", map[string]interface{}{
+			"client_id": id,
+			"error":     err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		writeErrorResponse(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"client": c,
+	})
+}
+
+// AdminSetClient handles PUT /api/admin/clients/{client_id}, registering
+// the client or replacing its policy. Sessions it already started follow
+// the new policy from their next refresh.
+func (h *Handlers) AdminSetClient(w http.ResponseWriter, r *http.Request) {
+	id, ok := clientPathID(w, r)
+	if !ok {
+		return
+	}
+	var req setClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	c := &models.Client{
+		ClientID:        id,
+		Name:            req.Name,
+		AccessTokenTTL:  req.AccessTokenTTL,
+		RefreshTokenTTL: req.RefreshTokenTTL,
+		RefreshPolicy:   req.RefreshPolicy,
+		AllowedScopes:   slices.Compact(slices.Sorted(slices.Values(req.AllowedScopes))),
+		CORSOrigins:     slices.Compact(slices.Sorted(slices.Values(req.CORSOrigins))),
+		UpdatedBy:       callerID(r),
+	}
+	if err := clients.Validate(c); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created := false
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		before, err := tx.GetClient(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		created = before == nil
+		if err := tx.SetClient(r.Context(), c); err != nil {
+			return err
+		}
+		return recordClientAudit(r, tx, auditClientSet, before, c)
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Client update failed", map[string]interface{}{
+			"client_id": id,
+			"error":     err.Error(),
+		})
+		writeErrorResponse(w, "Failed to set client", http.StatusInternalServerError)
+		return
+	}
+	if h.Clients != nil {
+		h.Clients.Set(*c)
+	}
+
+	logger.FromContext(r.Context()).Info("Client set", map[string]interface{}{
+		"client_id": id,
+		"admin_id":  c.UpdatedBy,
+	})
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"client": c,
+	})
+}
+
+// AdminDeleteClient handles DELETE /api/admin/clients/{client_id}. The
+// client's sessions can no longer be refreshed.
+func (h *Handlers) AdminDeleteClient(w http.ResponseWriter, r *http.Request) {
+	id, ok := clientPathID(w, r)
+	if !ok {
+		return
+	}
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		before, err := tx.GetClient(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return store.ErrNotFound
+		}
+		if err := tx.DeleteClient(r.Context(), id); err != nil {
+			return err
+		}
+		return recordClientAudit(r, tx, auditClientDelete, before, nil)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		writeErrorResponse(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Client deletion failed", map[string]interface{}{
+			"client_id": id,
+			"error":     err.Error(),
+		})
+		writeErrorResponse(w, "Failed to delete client", http.StatusInternalServerError)
+		return
+	}
+	if h.Clients != nil {
+		h.Clients.Remove(id)
+	}
+
+	logger.FromContext(r.Context()).Info("Client deleted", map[string]interface{}{
+		"client_id": id,
+		"admin_id":  callerID(r),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
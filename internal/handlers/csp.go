@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// CSPReportPath is where browsers send Content-Security-Policy violation
+// reports; the default policy names it in report-uri.
+const CSPReportPath = "/api/csp-report"
+
+// maxCSPReports bounds the reports logged from one request.
+const maxCSPReports = 20
+
+var cspReports = metrics.NewCounterVec(
+	"sentinel_csp_reports_total",
+	"Content-Security-Policy violation reports by violated directive.",
+	"directive",
+)
+
+// directiveName matches CSP directive names, which are used as metric
+// labels only when they look like one.
+var directiveName = regexp.MustCompile(`^[a-z-]{1,32}$`)
+
+// cspViolation holds the fields of a violation report that are logged. It
+// decodes both the legacy report-uri format and the Reporting API body,
+// which name the same fields differently.
+type cspViolation struct {
+	DocumentURI        string `json:"document-uri"`
+	BlockedURI         string `json:"blocked-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	Disposition        string `json:"disposition"`
+
+	// Reporting API names.
+	DocumentURL           string `json:"documentURL"`
+	BlockedURL            string `json:"blockedURL"`
+	EffectiveDirectiveAPI string `json:"effectiveDirective"`
+	SourceFileAPI         string `json:"sourceFile"`
+	LineNumberAPI         int    `json:"lineNumber"`
+}
+
+// CSPReport handles POST /api/csp-report. Browsers send it the
+// Content-Security-Policy violations they see, either one legacy
+// application/csp-report document or a list of application/reports+json
+// reports. Each violation is logged and counted; the response is always
+// 204 so that reports are never retried.
+func (h *Handlers) CSPReport(w http.ResponseWriter, r *http.Request) {
+	var violations []cspViolation
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/reports+json") {
+		var reports []struct {
+			Type string       `json:"type"`
+			Body cspViolation `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&reports); err == nil {
+			for _, report := range reports {
+				if report.Type == "csp-violation" {
+					violations = append(violations, report.Body)
+				}
+			}
+		}
+	} else {
+		var report struct {
+			Body *cspViolation `json:"csp-report"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&report); err == nil && report.Body != nil {
+			violations = append(violations, *report.Body)
+		}
+	}
+
+	log := logger.FromContext(r.Context())
+	for i, v := range violations {
+		if i == maxCSPReports {
+			break
+		}
+		directive := cmp.Or(v.EffectiveDirective, v.EffectiveDirectiveAPI, v.ViolatedDirective)
+		directive, _, _ = strings.Cut(directive, " ")
+		label := directive
+		if !directiveName.MatchString(label) {
+			label = "other"
+		}
+		cspReports.WithLabelValues(label).Inc()
+		log.Warn("Content-Security-Policy violation", map[string]interface{}{
+			"directive":   directive,
+			"document":    stripQuery(cmp.Or(v.DocumentURI, v.DocumentURL)),
+			"blocked":     stripQuery(cmp.Or(v.BlockedURI, v.BlockedURL)),
+			"source_file": stripQuery(cmp.Or(v.SourceFile, v.SourceFileAPI)),
+			"line":        cmp.Or(v.LineNumber, v.LineNumberAPI),
+			"disposition": v.Disposition,
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// stripQuery removes the query and fragment from a reported URL, which
+// may carry tokens such as those in emailed links.
+func stripQuery(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		// Keywords such as "inline" and "eval" are not URLs.
+		raw, _, _ = strings.Cut(raw, "?")
+		return raw
+	}
+	u.RawQuery, u.Fragment = "", ""
+	return u.String()
+}
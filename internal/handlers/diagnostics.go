@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	rpprof "runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+)
+
+// Dump kinds accepted by POST /api/admin/diagnostics/dumps.
+const (
+	dumpGoroutine = "goroutine"
+	dumpHeap      = "heap"
+)
+
+// DebugProfile serves the pprof CPU profile. Unlike pprof.Profile it lifts
+// the server's write timeout for the requested duration (?seconds=, default
+// 30) so profiles longer than the timeout can be collected.
+func (h *Handlers) DebugProfile(w http.ResponseWriter, r *http.Request) {
+	extendWriteDeadline(w, r, 30*time.Second)
+	pprof.Profile(w, r)
+}
+
+// DebugTrace serves a pprof execution trace; see DebugProfile.
+func (h *Handlers) DebugTrace(w http.ResponseWriter, r *http.Request) {
+	extendWriteDeadline(w, r, time.Second)
+	pprof.Trace(w, r)
+}
+
+// extendWriteDeadline allows the response to take the ?seconds= duration
+// (or def) plus a margin. pprof refuses durations past the server's write
+// timeout unless the deadline is moved first.
+func extendWriteDeadline(w http.ResponseWriter, r *http.Request, def time.Duration) {
+	d := def
+	if secs, err := strconv.ParseFloat(r.URL.Query().Get("seconds"), 64); err == nil && secs > 0 {
+		d = time.Duration(secs * float64(time.Second))
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + 10*time.Second))
+}
+
+// AdminCreateDump handles POST /api/admin/diagnostics/dumps?type=goroutine|heap.
+// It writes a goroutine dump (full stacks) or a heap profile into
+// DiagnosticsDir under a timestamped name, for processes that cannot be
+// profiled interactively.
+func (h *Handlers) AdminCreateDump(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("type")
+	var ext string
+	var debug int
+	switch kind {
+	case dumpGoroutine:
+		ext, debug = ".txt", 2
+	case dumpHeap:
+		ext, debug = ".pprof", 0
+	default:
+		writeErrorResponse(w, "type must be goroutine or heap", http.StatusBadRequest)
+		return
+	}
+
+	dir := h.DiagnosticsDir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "sentinel-diagnostics")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		logger.FromContext(r.Context()).Error("Failed to create diagnostics directory", map[string]interface{}{
+			"dir":   dir,
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create dump", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	path := filepath.Join(dir, kind+"-"+now.Format("20060102T150405.000Z")+ext)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err == nil {
+		err = rpprof.Lookup(kind).WriteTo(f, debug)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Diagnostics dump failed", map[string]interface{}{
+			"path":  path,
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create dump", http.StatusInternalServerError)
+		return
+	}
+
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+	logger.FromContext(r.Context()).Info("Diagnostics dump created", map[string]interface{}{
+		"type":       kind,
+		"path":       path,
+		"size_bytes": size,
+	})
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"type":       kind,
+		"path":       path,
+		"size_bytes": size,
+		"created_at": now.Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mayvqt/Sentinel/internal/dpop"
+)
+
+// proofKey checks the DPoP proof sent to a token endpoint and returns the
+// thumbprint of its key, to bind the issued tokens to, or "" for bearer
+// tokens when no proof was sent or DPoP is off. Refreshing tokens bound
+// to the key with thumbprint bound needs a proof signed with that key. It
+// returns false, having written an error, when the proof is missing or
+// invalid.
+func (h *Handlers) proofKey(w http.ResponseWriter, r *http.Request, bound string) (string, bool) {
+	if bound == "" && (h.DPoP == nil || r.Header.Get(dpop.Header) == "") {
+		return "", true
+	}
+	if h.DPoP == nil {
+		writeErrorResponse(w, "DPoP is not enabled", http.StatusBadRequest)
+		return "", false
+	}
+	p, err := h.DPoP.Check(r, "", bound)
+	if errors.Is(err, dpop.ErrUnavailable) {
+		writeErrorResponse(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+		return "", false
+	}
+	if err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return p.JKT, true
+}
+
+// tokenType returns the token_type of access tokens bound to jkt.
+func tokenType(jkt string) string {
+	if jkt != "" {
+		return dpop.Scheme
+	}
+	return "Bearer"
+}
@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/rbac"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+)
+
+// Elevation approval modes (see Handlers.ElevationApproval).
+const (
+	// ElevationApprovalAdmin requires another admin to approve each request.
+	ElevationApprovalAdmin = "admin"
+	// ElevationApprovalSelf approves requests as they are made, if they are
+	// for capabilities the requester's role already grants; they are still
+	// audited. Requests for admin or other capabilities wait for another
+	// admin as under ElevationApprovalAdmin.
+	ElevationApprovalSelf = "self"
+)
+
+// DefaultElevationMaxDuration bounds elevations when ElevationMaxDuration
+// is unset.
+const DefaultElevationMaxDuration = time.Hour
+
+// elevationRequestTTL is how long a request may wait for approval.
+const elevationRequestTTL = 24 * time.Hour
+
+// maxElevationReason bounds the length of an elevation's reason.
+const maxElevationReason = 500
+
+// Audit actions recorded for elevations.
+const (
+	auditElevationRequest = "elevation.request"
+	auditElevationApprove = "elevation.approve"
+	auditElevationDeny    = "elevation.deny"
+	auditElevationToken   = "elevation.token"
+)
+
+// auditTargetElevation is the target type of elevation audit events.
+const auditTargetElevation = "elevation"
+
+// elevationRequest is the payload for POST /api/auth/elevations.
+type elevationRequest struct {
+	Scopes          []string `json:"scopes"`
+	Reason          string   `json:"reason"`
+	DurationMinutes int      `json:"duration_minutes"`
+}
+
+// recordElevationAudit records action on e, with its current state as the
+// changes.
+func recordElevationAudit(r *http.Request, s store.Store, action string, e *models.Elevation) error {
+	changes := []models.FieldChange{
+		{Field: "user_id", After: e.UserID},
+		{Field: "scopes", After: e.Scopes},
+		{Field: "reason", After: e.Reason},
+		{Field: "duration_seconds", After: e.DurationSeconds},
+		{Field: "status", After: e.Status},
+	}
+	if e.TokenID != "" {
+		changes = append(changes, models.FieldChange{Field: "jti", After: e.TokenID})
+	}
+	return s.RecordAudit(r.Context(), &models.AuditEvent{
+		ActorID:    callerID(r),
+		Action:     action,
+		TargetType: auditTargetElevation,
+		TargetID:   e.ID,
+		Changes:    changes,
+		RequestID:  tracing.RequestIDFromContext(r.Context()),
+		TokenID:    callerTokenID(r),
+	})
+}
+
+// elevationMaxDuration returns the longest elevation that may be requested.
+func (h *Handlers) elevationMaxDuration() time.Duration {
+	if h.ElevationMaxDuration <= 0 {
+		return DefaultElevationMaxDuration
+	}
+	return h.ElevationMaxDuration
+}
+
+// selfApprovable reports whether an elevation of role to scopes may be
+// approved by the requester: only when role already grants each scope, and
+// never for admin, so self-service cannot escalate anyone.
+func (h *Handlers) selfApprovable(role string, scopes []string) bool {
+	for _, scope := range scopes {
+		if scope == rbac.RoleAdmin || !h.AdminRoles.Allows(role, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestElevation handles POST /api/auth/elevations. Admins and delegated
+// admins ask for scopes (admin capabilities, or admin for all of them) for
+// a number of minutes, giving a reason. Under self-service approval the
+// response carries the elevated access token at once when the requester's
+// role already grants the scopes; otherwise the request waits for another
+// admin, after which the requester redeems it with RedeemElevation.
+func (h *Handlers) RequestElevation(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !h.isStaff(user.Role) {
+		writeErrorResponse(w, "Only admins and delegated admins may request elevation", http.StatusForbidden)
+		return
+	}
+
+	var req elevationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if scope != rbac.RoleAdmin && !slices.Contains(rbac.Capabilities, scope) {
+			writeErrorResponse(w, "Unknown scope "+strconv.Quote(scope), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		writeErrorResponse(w, "scopes is required", http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" || len(reason) > maxElevationReason {
+		writeErrorResponse(w, "reason is required and must be at most 500 characters", http.StatusBadRequest)
+		return
+	}
+	maxMinutes := int(h.elevationMaxDuration() / time.Minute)
+	if req.DurationMinutes < 1 || req.DurationMinutes > maxMinutes {
+		writeErrorResponse(w, "duration_minutes must be between 1 and "+strconv.Itoa(maxMinutes), http.StatusBadRequest)
+		return
+	}
+
+	e := &models.Elevation{
+		UserID:          user.ID,
+		Scopes:          scopes,
+		Reason:          reason,
+		DurationSeconds: int64(req.DurationMinutes) * 60,
+		Status:          models.ElevationPending,
+	}
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.CreateElevation(r.Context(), e); err != nil {
+			return err
+		}
+		return recordElevationAudit(r, tx, auditElevationRequest, e)
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Elevation request failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to request elevation", http.StatusInternalServerError)
+		return
+	}
+	logger.FromContext(r.Context()).Warn("Elevation requested", map[string]interface{}{
+		"user_id":      user.ID,
+		"elevation_id": e.ID,
+		"scopes":       strings.Join(scopes, ","),
+	})
+
+	if h.ElevationApproval != ElevationApprovalSelf || !h.selfApprovable(user.Role, scopes) {
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"elevation": e})
+		return
+	}
+	if !h.decideElevation(w, r, e, models.ElevationApproved) {
+		return
+	}
+	h.issueElevationToken(w, r, e, user, http.StatusCreated)
+}
+
+// GetElevation handles GET /api/auth/elevations/{id}, returning one of the
+// caller's elevation requests so they can wait for its approval.
+func (h *Handlers) GetElevation(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	e, ok := h.pathElevation(w, r)
+	if !ok {
+		return
+	}
+	if e.UserID != user.ID {
+		writeErrorResponse(w, "Elevation not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"elevation": e})
+}
+
+// RedeemElevation handles POST /api/auth/elevations/{id}/token, issuing
+// the access token of one of the caller's approved elevations. Each
+// elevation is redeemed once, and only until it expires.
+func (h *Handlers) RedeemElevation(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	e, ok := h.pathElevation(w, r)
+	if !ok {
+		return
+	}
+	switch {
+	case e.UserID != user.ID:
+		writeErrorResponse(w, "Elevation not found", http.StatusNotFound)
+	case e.Status != models.ElevationApproved:
+		writeErrorResponse(w, "Elevation is "+e.Status, http.StatusConflict)
+	case e.TokenID != "":
+		writeErrorResponse(w, "Elevation was already redeemed", http.StatusConflict)
+	case !e.ExpiresAt.After(time.Now()):
+		writeErrorResponse(w, "Elevation has expired", http.StatusConflict)
+	case !h.isStaff(user.Role):
+		writeErrorResponse(w, "Only admins and delegated admins may be elevated", http.StatusForbidden)
+	default:
+		h.issueElevationToken(w, r, e, user, http.StatusOK)
+	}
+}
+
+// issueElevationToken issues the access token of approved elevation e to
+// user and writes it with status, unless the elevation was redeemed
+// concurrently.
+func (h *Handlers) issueElevationToken(w http.ResponseWriter, r *http.Request, e *models.Elevation, user *models.User, status int) {
+	token, claims, err := h.Auth.IssueElevatedToken(strconv.FormatInt(user.ID, 10), user.Role, e.Scopes, *e.ExpiresAt)
+	if err != nil {
+		writeErrorResponse(w, "Failed to create access token", http.StatusInternalServerError)
+		return
+	}
+	redeemed := false
+	err = h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if redeemed, err = tx.RedeemElevation(r.Context(), e.ID, claims.ID); err != nil || !redeemed {
+			return err
+		}
+		e.TokenID = claims.ID
+		return recordElevationAudit(r, tx, auditElevationToken, e)
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Elevation token issue failed", map[string]interface{}{
+			"elevation_id": e.ID,
+			"error":        err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create access token", http.StatusInternalServerError)
+		return
+	}
+	if !redeemed {
+		writeErrorResponse(w, "Elevation was already redeemed", http.StatusConflict)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"elevation":    e,
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(*e.ExpiresAt).Seconds()),
+	})
+}
+
+// AdminListElevations handles GET /api/admin/elevations, returning the
+// newest elevation requests, filtered by the status parameter when set.
+func (h *Handlers) AdminListElevations(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.ElevationPending, models.ElevationApproved, models.ElevationDenied:
+	default:
+		writeErrorResponse(w, "status must be pending, approved, or denied", http.StatusBadRequest)
+		return
+	}
+	limit, _, ok := parsePagination(w, r)
+	if !ok {
+		return
+	}
+	elevations, err := h.Store.ListElevations(r.Context(), status, limit)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if elevations == nil {
+		elevations = []models.Elevation{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"elevations": elevations})
+}
+
+// AdminApproveElevation handles POST /api/admin/elevations/{id}/approve.
+// The elevation lasts its requested duration from now.
+func (h *Handlers) AdminApproveElevation(w http.ResponseWriter, r *http.Request) {
+	h.adminDecideElevation(w, r, models.ElevationApproved)
+}
+
+// AdminDenyElevation handles POST /api/admin/elevations/{id}/deny.
+func (h *Handlers) AdminDenyElevation(w http.ResponseWriter, r *http.Request) {
+	h.adminDecideElevation(w, r, models.ElevationDenied)
+}
+
+// adminDecideElevation approves or denies a pending request made by
+// someone other than the caller.
+func (h *Handlers) adminDecideElevation(w http.ResponseWriter, r *http.Request, status string) {
+	e, ok := h.pathElevation(w, r)
+	if !ok {
+		return
+	}
+	switch {
+	case e.UserID == callerID(r):
+		writeErrorResponse(w, "Elevation must be decided by another admin", http.StatusForbidden)
+		return
+	case e.Status != models.ElevationPending:
+		writeErrorResponse(w, "Elevation was already "+e.Status, http.StatusConflict)
+		return
+	case status == models.ElevationApproved && time.Since(e.CreatedAt) > elevationRequestTTL:
+		writeErrorResponse(w, "Elevation request has expired", http.StatusConflict)
+		return
+	}
+	if !h.decideElevation(w, r, e, status) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"elevation": e})
+}
+
+// decideElevation records the caller's decision on pending elevation e,
+// which is updated to match. On failure it writes the error response and
+// returns false.
+func (h *Handlers) decideElevation(w http.ResponseWriter, r *http.Request, e *models.Elevation, status string) bool {
+	now := time.Now().UTC()
+	decided := *e
+	decided.Status, decided.DecidedBy, decided.DecidedAt = status, callerID(r), &now
+	action := auditElevationDeny
+	if status == models.ElevationApproved {
+		expiresAt := now.Add(e.Duration())
+		decided.ExpiresAt = &expiresAt
+		action = auditElevationApprove
+	}
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.DecideElevation(r.Context(), &decided); err != nil {
+			return err
+		}
+		return recordElevationAudit(r, tx, action, &decided)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		writeErrorResponse(w, "Elevation was already decided", http.StatusConflict)
+		return false
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Elevation decision failed", map[string]interface{}{
+			"elevation_id": e.ID,
+			"error":        err.Error(),
+		})
+		writeErrorResponse(w, "Failed to decide elevation", http.StatusInternalServerError)
+		return false
+	}
+	*e = decided
+	return true
+}
+
+// pathElevation loads the elevation identified by the {id} path wildcard.
+// On failure it writes the error response and returns false.
+func (h *Handlers) pathElevation(w http.ResponseWriter, r *http.Request) (*models.Elevation, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeErrorResponse(w, "Invalid elevation ID", http.StatusBadRequest)
+		return nil, false
+	}
+	e, err := h.Store.GetElevation(r.Context(), id)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if e == nil {
+		writeErrorResponse(w, "Elevation not found", http.StatusNotFound)
+		return nil, false
+	}
+	return e, true
+}
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/audit"
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/mail"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+	"github.com/mayvqt/Sentinel/internal/validation"
+)
+
+// Email change link lifetimes: the new address has emailConfirmTTL to
+// confirm, after which the old address can revert for emailRevertTTL.
+const (
+	emailConfirmTTL = 24 * time.Hour
+	emailRevertTTL  = 72 * time.Hour
+)
+
+// Audit actions recorded for a user's own email changes.
+const (
+	auditUserEmailChange = "user.email.change"
+	auditUserEmailRevert = "user.email.revert"
+)
+
+// errEmailTaken aborts an email change, or a registration under
+// enumeration protection, when another account has the address.
+var errEmailTaken = errors.New("email address is already in use")
+
+// changeEmailRequest is the payload for POST /api/auth/email.
+type changeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// emailTokenRequest is the payload for the confirm and revert endpoints,
+// which also accept the token as a ?token= query parameter.
+type emailTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// emailLink returns the URL for path with token in its query string.
+func (h *Handlers) emailLink(path, token string) string {
+	return strings.TrimRight(h.PublicURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// emailToken reads the link token from the query string or JSON body.
+func emailToken(r *http.Request) (string, error) {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token, nil
+	}
+	var req emailTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimSpace(req.Token), nil
+}
+
+// pendingEmail returns the user's unconfirmed email change for their
+// profile, or nil. Lookup failures are logged and treated as none.
+func (h *Handlers) pendingEmail(r *http.Request, userID int64) *models.PendingEmail {
+	c, err := h.Store.GetEmailChange(r.Context(), userID)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to load email change", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return nil
+	}
+	if c == nil || !c.Pending(time.Now()) {
+		return nil
+	}
+	return &models.PendingEmail{Email: c.NewEmail, ExpiresAt: c.ConfirmExpiresAt.UTC()}
+}
+
+// recordEmailAudit records a change the user made to their own email
+// address through an emailed link, so the user is the actor.
+func recordEmailAudit(ctx context.Context, s store.Store, r *http.Request, action string, before, after *models.User) error {
+	return s.RecordAudit(ctx, &models.AuditEvent{
+		ActorID:    after.ID,
+		Action:     action,
+		TargetType: auditTargetUser,
+		TargetID:   after.ID,
+		Changes:    audit.DiffUser(before, after),
+		RequestID:  tracing.RequestIDFromContext(r.Context()),
+		IP:         middleware.ClientIP(r),
+		UserAgent:  audit.UserAgent(r.UserAgent()),
+	})
+}
+
+// ChangeEmail handles POST /api/auth/email. After the caller confirms their
+// password it records the new address as pending and emails it a
+// confirmation link; the account's email is unchanged until confirmed. A
+// new request replaces an earlier pending one.
+func (h *Handlers) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req changeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Email = validation.SanitizeInput(req.Email)
+	req.Password = validation.SanitizeInput(req.Password)
+	if req.Password == "" || auth.CheckPassword(user.Password, req.Password) != nil {
+		writeErrorResponse(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+	if err := validation.ValidateEmail(req.Email); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.EqualFold(req.Email, user.Email) {
+		writeErrorResponse(w, "New email matches the current one", http.StatusBadRequest)
+		return
+	}
+
+	// While the previous address can still revert a change, starting
+	// another would discard its revert link.
+	now := time.Now().UTC()
+	prev, err := h.Store.GetEmailChange(r.Context(), user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if prev != nil && prev.RevertExpiresAt != nil && now.Before(*prev.RevertExpiresAt) {
+		writeErrorResponse(w, "Your email address was changed recently; try again after "+
+			prev.RevertExpiresAt.UTC().Format(time.RFC3339), http.StatusConflict)
+		return
+	}
+
+	token, err := auth.GenerateLinkToken()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	change := &models.EmailChange{
+		UserID:           user.ID,
+		OldEmail:         user.Email,
+		NewEmail:         req.Email,
+		ConfirmTokenHash: auth.HashLinkToken(token),
+		ConfirmExpiresAt: now.Add(emailConfirmTTL),
+	}
+	if err := h.Store.SaveEmailChange(r.Context(), change); err != nil {
+		logger.FromContext(r.Context()).Error("Failed to save email change", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to start email change", http.StatusInternalServerError)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("Email change requested", map[string]interface{}{
+		"user_id": user.ID,
+	})
+	h.sendEmail(r.Context(), user, req.Email, mail.TemplateVerifyEmail, map[string]interface{}{
+		"Email":     req.Email,
+		"Link":      h.emailLink("/api/auth/email/confirm", token),
+		"ExpiresIn": formatDuration(emailConfirmTTL),
+	})
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"pending_email": &models.PendingEmail{Email: change.NewEmail, ExpiresAt: change.ConfirmExpiresAt},
+	})
+}
+
+// ConfirmEmailChange handles GET and POST /api/auth/email/confirm, the link
+// sent to the new address. It switches the account to the new address and
+// sends the old one a link to undo the change within 72 hours.
+func (h *Handlers) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	token, err := emailToken(r)
+	if err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	hash := auth.HashLinkToken(token)
+	change, err := h.Store.GetEmailChangeByToken(r.Context(), hash)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	if token == "" || change == nil || change.ConfirmTokenHash != hash || !change.Pending(now) {
+		writeErrorResponse(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+	user, err := h.Store.GetUserByID(r.Context(), change.UserID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || user.Disabled {
+		writeErrorResponse(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+	if !strings.EqualFold(user.Email, change.OldEmail) {
+		// The address changed some other way since the link was sent.
+		_ = h.Store.DeleteEmailChange(r.Context(), user.ID)
+		writeErrorResponse(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+
+	revertToken, err := auth.GenerateLinkToken()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	revertExpiresAt := now.Add(emailRevertTTL)
+	change.ConfirmedAt = &now
+	change.ConfirmTokenHash = ""
+	change.RevertTokenHash = auth.HashLinkToken(revertToken)
+	change.RevertExpiresAt = &revertExpiresAt
+
+	before := snapshotUser(user)
+	user.Email = change.NewEmail
+	err = h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.UpdateUser(r.Context(), user); err != nil {
+			if strings.Contains(err.Error(), "already exists") {
+				return errEmailTaken
+			}
+			return err
+		}
+		if err := tx.SaveEmailChange(r.Context(), change); err != nil {
+			return err
+		}
+		return recordEmailAudit(r.Context(), tx, r, auditUserEmailChange, before, user)
+	})
+	if errors.Is(err, errEmailTaken) {
+		writeErrorResponse(w, "Email address is already in use", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to confirm email change", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeUpdateError(w, err, "Failed to change email")
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("Email change confirmed", map[string]interface{}{
+		"user_id": user.ID,
+	})
+	h.sendEmail(r.Context(), user, change.OldEmail, mail.TemplateEmailChanged, map[string]interface{}{
+		"Email":     change.NewEmail,
+		"Link":      h.emailLink("/api/auth/email/revert", revertToken),
+		"ExpiresIn": formatDuration(emailRevertTTL),
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"email": user.Email,
+	})
+}
+
+// RevertEmailChange handles GET and POST /api/auth/email/revert, the link
+// sent to the previous address. It restores that address and revokes the
+// account's refresh tokens, since the change may have been an account
+// takeover.
+func (h *Handlers) RevertEmailChange(w http.ResponseWriter, r *http.Request) {
+	token, err := emailToken(r)
+	if err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	hash := auth.HashLinkToken(token)
+	change, err := h.Store.GetEmailChangeByToken(r.Context(), hash)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if token == "" || change == nil || change.RevertTokenHash != hash ||
+		change.RevertExpiresAt == nil || !time.Now().Before(*change.RevertExpiresAt) {
+		writeErrorResponse(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+	user, err := h.Store.GetUserByID(r.Context(), change.UserID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		writeErrorResponse(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+
+	before := snapshotUser(user)
+	user.Email = change.OldEmail
+	err = h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.UpdateUser(r.Context(), user); err != nil {
+			if strings.Contains(err.Error(), "already exists") {
+				return errEmailTaken
+			}
+			return err
+		}
+		if err := tx.DeleteEmailChange(r.Context(), user.ID); err != nil {
+			return err
+		}
+		return recordEmailAudit(r.Context(), tx, r, auditUserEmailRevert, before, user)
+	})
+	if errors.Is(err, errEmailTaken) {
+		writeErrorResponse(w, "Email address is already in use", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to revert email change", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeUpdateError(w, err, "Failed to restore email")
+		return
+	}
+
+	revoked := 0
+	tokens, err := h.Store.ListRefreshTokens(r.Context(), user.ID)
+	for _, t := range tokens {
+		if err = h.revokeToken(r, t.JTI, t.ExpiresAt); err != nil {
+			break
+		}
+		revoked++
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to revoke sessions after email revert", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+	}
+
+	logger.FromContext(r.Context()).Warn("Email change reverted", map[string]interface{}{
+		"user_id":          user.ID,
+		"sessions_revoked": revoked,
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"email":            user.Email,
+		"sessions_revoked": revoked,
+	})
+}
+
+// formatDuration renders d for email copy: whole hours as e.g. "24 hours",
+// anything shorter or uneven in minutes, e.g. "15 minutes".
+func formatDuration(d time.Duration) string {
+	n, unit := int(d/time.Minute), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		n, unit = int(d/time.Hour), "hour"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return strconv.Itoa(n) + " " + unit
+}
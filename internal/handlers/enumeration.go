@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/mail"
+	"github.com/mayvqt/Sentinel/internal/models"
+)
+
+// enumerationResponseTime is the least time registration and magic link
+// requests take under enumeration protection, so that the work done for
+// existing accounts does not show in response times. Up to
+// enumerationJitter is added at random.
+var (
+	enumerationResponseTime = time.Second
+	enumerationJitter       = 100 * time.Millisecond
+)
+
+// registrationAccepted is the response to every registration that passes
+// validation under enumeration protection, whether or not it succeeded.
+const registrationAccepted = "Registration received. If you cannot sign in, check your email"
+
+// isRegistrationConflict reports whether a registration failed because its
+// username, email address, or phone number belongs to another account.
+func isRegistrationConflict(err error) bool {
+	return errors.Is(err, errUsernameTaken) || errors.Is(err, errEmailTaken) ||
+		errors.Is(err, errPhoneTaken) || strings.Contains(err.Error(), "already exists")
+}
+
+// acceptRegistration writes the response every registration that passed
+// validation gets under enumeration protection, once padResponse allows.
+func acceptRegistration(w http.ResponseWriter, r *http.Request, start time.Time) {
+	padResponse(r, start)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"message": registrationAccepted})
+}
+
+// padResponse sleeps until enumerationResponseTime, plus jitter, has
+// passed since start, or the client goes away.
+func padResponse(r *http.Request, start time.Time) {
+	wait := time.Until(start.Add(enumerationResponseTime + rand.N(enumerationJitter)))
+	if wait <= 0 {
+		return
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+}
+
+// notifyRegistrationConflict tells the people involved why a registration
+// answered with registrationAccepted created no account: the owner of an
+// address already in use, or otherwise whoever gave the address.
+func (h *Handlers) notifyRegistrationConflict(r *http.Request, req registerRequest, err error) {
+	// The store may also report a taken address itself, if another
+	// registration claimed it after the check
+	owner, lookupErr := h.Store.GetUserByEmail(r.Context(), req.Email)
+	if lookupErr == nil && owner != nil {
+		h.notifyUser(r, owner, mail.TemplateAccountExists, map[string]interface{}{"Email": req.Email})
+		return
+	}
+	reason := fmt.Sprintf("the username %s is already taken", req.Username)
+	if errors.Is(err, errPhoneTaken) {
+		reason = "the phone number is already in use"
+	}
+	h.sendEmail(r.Context(), &models.User{Username: req.Username}, req.Email, mail.TemplateRegistrationFailed, map[string]interface{}{"Reason": reason})
+}
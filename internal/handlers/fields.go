@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+)
+
+// userFields holds the JSON names of models.User's fields, the values the
+// fields parameter accepts.
+var userFields = jsonFieldNames(reflect.TypeOf(models.User{}))
+
+// jsonFieldNames returns the names struct type t's fields are encoded
+// under, leaving out those never encoded.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// fieldSet is the set of user fields a response is limited to. A nil set
+// selects every field.
+type fieldSet map[string]bool
+
+// parseFields reads the comma-separated fields query parameter, such as
+// fields=id,username,role. On an unknown field it writes the error
+// response and returns false.
+func parseFields(w http.ResponseWriter, r *http.Request) (fieldSet, bool) {
+	v := r.URL.Query().Get("fields")
+	if v == "" {
+		return nil, true
+	}
+	fields := fieldSet{}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !userFields[name] {
+			writeErrorResponse(w, fmt.Sprintf("Unknown field %q in fields", name), http.StatusBadRequest)
+			return nil, false
+		}
+		fields[name] = true
+	}
+	if len(fields) == 0 {
+		return nil, true
+	}
+	return fields, true
+}
+
+// user returns u limited to the selected fields. Selected fields that u
+// leaves out when empty, such as phone, stay out.
+func (f fieldSet) user(u *models.User) interface{} {
+	if f == nil {
+		return u
+	}
+	b, err := json.Marshal(u)
+	if err != nil {
+		return u
+	}
+	var view map[string]json.RawMessage
+	if err := json.Unmarshal(b, &view); err != nil {
+		return u
+	}
+	for name := range view {
+		if !f[name] {
+			delete(view, name)
+		}
+	}
+	return view
+}
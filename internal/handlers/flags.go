@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/mayvqt/Sentinel/internal/flags"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+)
+
+// Audit actions recorded for feature flag overrides.
+const (
+	auditFlagSet    = "flag.set"
+	auditFlagDelete = "flag.delete"
+)
+
+// auditTargetFlag is the target type of feature flag audit events.
+const auditTargetFlag = "flag"
+
+// flagName returns the {name} path value, writing a 404 when it is not a
+// declared flag.
+func flagName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.PathValue("name")
+	if !flags.Known(name) {
+		writeErrorResponse(w, "Feature flag not found", http.StatusNotFound)
+		return "", false
+	}
+	return name, true
+}
+
+// recordFlagAudit records the acting admin's change to a flag override;
+// before is nil when it was created and after when it was deleted.
+func recordFlagAudit(r *http.Request, s store.Store, action string, before, after *models.FeatureFlag) error {
+	var changes []models.FieldChange
+	field := func(name string, get func(*models.FeatureFlag) interface{}) {
+		var b, a interface{}
+		if before != nil {
+			b = get(before)
+		}
+		if after != nil {
+			a = get(after)
+		}
+		if b != a {
+			changes = append(changes, models.FieldChange{Field: name, Before: b, After: a})
+		}
+	}
+	field("name", func(f *models.FeatureFlag) interface{} { return f.Name })
+	field("enabled", func(f *models.FeatureFlag) interface{} { return f.Enabled })
+	field("percent", func(f *models.FeatureFlag) interface{} { return f.Percent })
+	subjects := func(f *models.FeatureFlag) []string {
+		if f == nil {
+			return nil
+		}
+		return f.Subjects
+	}
+	if b, a := subjects(before), subjects(after); !slices.Equal(b, a) {
+		changes = append(changes, models.FieldChange{Field: "subjects", Before: b, After: a})
+	}
+
+	target := after
+	if target == nil {
+		target = before
+	}
+	return s.RecordAudit(r.Context(), &models.AuditEvent{
+		ActorID:    callerID(r),
+		Action:     action,
+		TargetType: auditTargetFlag,
+		TargetID:   target.ID,
+		Changes:    changes,
+		RequestID:  tracing.RequestIDFromContext(r.Context()),
+		TokenID:    callerTokenID(r),
+	})
+}
+
+// AdminListFlags handles GET /api/admin/flags, returning every feature
+// flag with its effective rollout and where it came from.
+func (h *Handlers) AdminListFlags(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"flags": h.Flags.States(),
+	})
+}
+
+// AdminGetFlag handles GET /api/admin/flags/{name}.
+func (h *Handlers) AdminGetFlag(w http.ResponseWriter, r *http.Request) {
+	name, ok := flagName(w, r)
+	if !ok {
+		return
+	}
+	st, _ := h.Flags.State(name)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"flag": st,
+	})
+}
+
+// AdminSetFlag handles PUT /api/admin/flags/{name}, creating or replacing
+// the administrator's override of the flag's configured rollout.
+func (h *Handlers) AdminSetFlag(w http.ResponseWriter, r *http.Request) {
+	name, ok := flagName(w, r)
+	if !ok {
+		return
+	}
+	var req flags.Rollout
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, subject := range req.Subjects {
+		if subject == "" || strings.Contains(subject, ",") {
+			writeErrorResponse(w, "subjects must not be empty or contain commas", http.StatusBadRequest)
+			return
+		}
+	}
+
+	f := &models.FeatureFlag{Name: name, Enabled: req.Enabled, Percent: req.Percent, Subjects: req.Subjects, UpdatedBy: callerID(r)}
+	created := false
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		before, err := tx.GetFeatureFlag(r.Context(), name)
+		if err != nil {
+			return err
+		}
+		created = before == nil
+		if err := tx.SetFeatureFlag(r.Context(), f); err != nil {
+			return err
+		}
+		return recordFlagAudit(r, tx, auditFlagSet, before, f)
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Feature flag update failed", map[string]interface{}{
+			"flag":  name,
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to set feature flag", http.StatusInternalServerError)
+		return
+	}
+	if h.Flags != nil {
+		h.Flags.SetOverride(*f)
+	}
+
+	logger.FromContext(r.Context()).Info("Feature flag overridden", map[string]interface{}{
+		"flag":     name,
+		"enabled":  f.Enabled,
+		"percent":  f.Percent,
+		"subjects": len(f.Subjects),
+		"admin_id": f.UpdatedBy,
+	})
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	st, _ := h.Flags.State(name)
+	writeJSON(w, status, map[string]interface{}{
+		"flag": st,
+	})
+}
+
+// AdminDeleteFlag handles DELETE /api/admin/flags/{name}, removing the
+// override so the configured rollout applies again.
+func (h *Handlers) AdminDeleteFlag(w http.ResponseWriter, r *http.Request) {
+	name, ok := flagName(w, r)
+	if !ok {
+		return
+	}
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		before, err := tx.GetFeatureFlag(r.Context(), name)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return store.ErrNotFound
+		}
+		if err := tx.DeleteFeatureFlag(r.Context(), name); err != nil {
+			return err
+		}
+		return recordFlagAudit(r, tx, auditFlagDelete, before, nil)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		writeErrorResponse(w, "Feature flag has no override", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Feature flag override deletion failed", map[string]interface{}{
+			"flag":  name,
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to delete feature flag override", http.StatusInternalServerError)
+		return
+	}
+	if h.Flags != nil {
+		h.Flags.RemoveOverride(name)
+	}
+
+	logger.FromContext(r.Context()).Info("Feature flag override removed", map[string]interface{}{
+		"flag":     name,
+		"admin_id": callerID(r),
+	})
+	st, _ := h.Flags.State(name)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"flag": st,
+	})
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+// Default guest session lifetimes.
+const (
+	DefaultGuestTokenTTL = time.Hour
+	DefaultGuestMaxAge   = 30 * 24 * time.Hour
+)
+
+// guestUsernamePrefix starts every guest username. No username policy
+// allows a colon, so guests never collide with registered accounts.
+const guestUsernamePrefix = "guest:"
+
+// guestPasswordHash is stored for guests; it is not a bcrypt hash, so no
+// password matches it.
+const guestPasswordHash = "!"
+
+// auditUserGuestUpgrade records a guest turned into a full account.
+const auditUserGuestUpgrade = "user.guest.upgrade"
+
+// errGuestExpired aborts a registration whose guest account is gone.
+var errGuestExpired = errors.New("guest session has expired")
+
+// guestTokenTTL returns the configured guest token lifetime or the default.
+func (h *Handlers) guestTokenTTL() time.Duration {
+	if h.GuestTokenTTL <= 0 {
+		return DefaultGuestTokenTTL
+	}
+	return h.GuestTokenTTL
+}
+
+// guestMaxAge returns how long a guest account is kept or the default.
+func (h *Handlers) guestMaxAge() time.Duration {
+	if h.GuestMaxAge <= 0 {
+		return DefaultGuestMaxAge
+	}
+	return h.GuestMaxAge
+}
+
+// bearerClaims returns the claims of the request's bearer token, or nil
+// when it has none. It is used on routes without the auth middleware.
+func (h *Handlers) bearerClaims(r *http.Request) (*auth.Claims, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, nil
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, errors.New("invalid authorization header")
+	}
+	claims, err := h.Auth.ParseToken(token)
+	if err != nil {
+		middleware.ReportCanaryToken(h.Auth, r, err)
+		return nil, err
+	}
+	return claims, nil
+}
+
+// guestUserID returns the account ID of guest token claims.
+func guestUserID(c *auth.Claims) (int64, bool) {
+	if c == nil || c.Role != models.RoleGuest {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(c.UserID, 10, 64)
+	return id, err == nil
+}
+
+// newGuestUsername returns a random guest username.
+func newGuestUsername() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return guestUsernamePrefix + hex.EncodeToString(b[:]), nil
+}
+
+// Guest handles POST /api/auth/guest. Without a token it creates an
+// anonymous guest account and returns a short-lived access token for it;
+// presenting a guest token returns a fresh one for the same account until
+// the account reaches the guest maximum age. Guests get no refresh token
+// and cannot sign in again once their token is lost. Registering with a
+// guest token turns the guest into a full account with the same ID.
+func (h *Handlers) Guest(w http.ResponseWriter, r *http.Request) {
+	if !h.GuestEnabled {
+		writeErrorResponse(w, "Guest sessions are not enabled", http.StatusNotImplemented)
+		return
+	}
+	claims, err := h.bearerClaims(r)
+	if err != nil {
+		writeErrorResponse(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+
+	status := http.StatusOK
+	var user *models.User
+	if claims != nil {
+		id, ok := guestUserID(claims)
+		if !ok {
+			writeErrorResponse(w, "Only guest tokens can be renewed", http.StatusBadRequest)
+			return
+		}
+		if user, err = h.Store.GetUserByID(r.Context(), id); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if user == nil || user.Role != models.RoleGuest || time.Since(user.CreatedAt) >= h.guestMaxAge() {
+			writeErrorResponse(w, "Guest session has expired", http.StatusUnauthorized)
+			return
+		}
+	} else {
+		username, err := newGuestUsername()
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		user = &models.User{
+			Username:  username,
+			Password:  guestPasswordHash,
+			Role:      models.RoleGuest,
+			CreatedAt: time.Now().UTC(),
+		}
+		if user.ID, err = h.Store.CreateUser(r.Context(), user); err != nil {
+			logger.FromContext(r.Context()).Error("Failed to create guest", map[string]interface{}{
+				"error": err.Error(),
+			})
+			writeErrorResponse(w, "Failed to create guest session", http.StatusInternalServerError)
+			return
+		}
+		status = http.StatusCreated
+	}
+
+	// A token never outlives the account it belongs to
+	expiresAt := user.CreatedAt.Add(h.guestMaxAge())
+	ttl := min(h.guestTokenTTL(), time.Until(expiresAt))
+	token, err := h.Auth.GenerateTokenWithType(strconv.FormatInt(user.ID, 10), models.RoleGuest, "access", ttl)
+	if err != nil {
+		writeErrorResponse(w, "Failed to create authentication token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, map[string]interface{}{
+		"access_token":     token,
+		"token_type":       "Bearer",
+		"expires_in":       int(ttl / time.Second),
+		"guest_expires_at": expiresAt,
+		"user":             h.profileView(user),
+	})
+}
+
+// AdminPurgeGuests handles POST /api/admin/guests:purge, deleting guest
+// accounts older than GuestMaxAge now rather than at the purge job's next
+// run. With dry_run=true it instead reports how many would be deleted and
+// the IDs of up to maxBatchSize of them.
+func (h *Handlers) AdminPurgeGuests(w http.ResponseWriter, r *http.Request) {
+	dryRun, ok := parseDryRun(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	cutoff := time.Now().Add(-h.guestMaxAge())
+
+	if dryRun {
+		f := store.UserFilter{Role: models.RoleGuest, CreatedBefore: cutoff}
+		n, err := h.Store.CountUsers(ctx, f)
+		var ids []int64
+		if err == nil {
+			ids, err = h.Store.ListUserIDs(ctx, f, maxBatchSize)
+		}
+		if err != nil {
+			logger.FromContext(ctx).Error("Guest purge dry run failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"dry_run": true,
+			"count":   n,
+			"ids":     ids,
+			"cutoff":  cutoff.UTC(),
+		})
+		return
+	}
+
+	n, err := h.Store.PurgeGuests(ctx, cutoff)
+	if err != nil {
+		logger.FromContext(ctx).Error("Guest purge failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	logger.FromContext(ctx).Info("Purged expired guest accounts", map[string]interface{}{
+		"count":    n,
+		"admin_id": callerID(r),
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":  n,
+		"cutoff": cutoff.UTC(),
+	})
+}
@@ -3,59 +3,406 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/mayvqt/Sentinel/internal/audit"
 	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/backchannel"
+	"github.com/mayvqt/Sentinel/internal/canary"
+	"github.com/mayvqt/Sentinel/internal/clients"
+	"github.com/mayvqt/Sentinel/internal/config"
+	"github.com/mayvqt/Sentinel/internal/dpop"
+	"github.com/mayvqt/Sentinel/internal/flags"
+	"github.com/mayvqt/Sentinel/internal/health"
+	"github.com/mayvqt/Sentinel/internal/httpjson"
 	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/mail"
+	"github.com/mayvqt/Sentinel/internal/metadata"
+	"github.com/mayvqt/Sentinel/internal/middleware"
 	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/mtls"
+	"github.com/mayvqt/Sentinel/internal/quota"
+	"github.com/mayvqt/Sentinel/internal/rbac"
+	"github.com/mayvqt/Sentinel/internal/sms"
+	"github.com/mayvqt/Sentinel/internal/storage"
 	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tarpit"
 	"github.com/mayvqt/Sentinel/internal/validation"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
 )
 
 type Handlers struct {
 	Store store.Store
 	Auth  *auth.Auth
+
+	// Dependencies holds the dependencies GET /health reports on. New
+	// registers the store; other subsystems add themselves as they are
+	// configured.
+	Dependencies *health.Registry
+
+	// Media stores uploaded avatars. Avatar uploads are disabled when nil.
+	Media storage.Backend
+	// AvatarMaxBytes and AvatarDimension bound avatar uploads; zero values use defaults.
+	AvatarMaxBytes  int64
+	AvatarDimension int
+
+	// Metadata governs per-key access to user metadata; nil uses metadata.Default().
+	Metadata *metadata.Policies
+
+	// AdminRoles defines the delegated admin roles that may be assigned to
+	// users; nil uses rbac.Default().
+	AdminRoles *rbac.Roles
+
+	// ElevationApproval is ElevationApprovalSelf to approve elevation
+	// requests for capabilities the requester already holds as they are
+	// made, or ElevationApprovalAdmin (the default) to require another
+	// admin's approval. ElevationMaxDuration bounds the
+	// duration that may be requested (default 1 hour).
+	ElevationApproval    string
+	ElevationMaxDuration time.Duration
+
+	// UsernamePolicy decides which usernames may be registered; nil uses
+	// validation.DefaultUsernamePolicy().
+	UsernamePolicy *validation.UsernamePolicy
+
+	// EnumerationProtection makes registration and magic link responses
+	// the same whether or not an account exists: registration answers 202
+	// without the new account's details, conflicts are explained by email
+	// instead, and both take a fixed minimum time. Username availability
+	// checks are disabled.
+	EnumerationProtection bool
+
+	// PasswordMinScore is the lowest validation.EstimatePasswordStrength
+	// score a new password may have; zero disables the check.
+	PasswordMinScore int
+
+	// BackupDir receives snapshots created through the admin backup endpoint.
+	BackupDir string
+	// BackupBucket, when set, receives a copy of each of those snapshots.
+	BackupBucket *storage.Bucket
+
+	// DiagnosticsEnabled exposes pprof, expvar, and the dump endpoint to
+	// admins; DiagnosticsDir receives dumps (default: a temp subdirectory).
+	DiagnosticsEnabled bool
+	DiagnosticsDir     string
+
+	// MetricsEnabled exposes GET /metrics; MetricsToken, when set, must be
+	// presented as a bearer token to scrape it.
+	MetricsEnabled bool
+	MetricsToken   string
+
+	// RefreshTokenTTL is the refresh token lifetime (default 7 days). In the
+	// default fixed mode a session ends RefreshTokenTTL after login however
+	// often it is refreshed. With RefreshSliding, each refresh extends it to
+	// RefreshTokenTTL from now, but never past RefreshMaxLifetime (default
+	// 30 days) after login.
+	RefreshTokenTTL    time.Duration
+	RefreshSliding     bool
+	RefreshMaxLifetime time.Duration
+	// RefreshReuseGrace, when positive, makes a refresh token presented
+	// again within this long of its first refresh return the same pair
+	// once, so a client retrying after a timeout does not start a second
+	// session. Otherwise a rotated refresh token is refused. The pairs are
+	// held by the instance that issued them, never in the store, so
+	// retries reaching another instance are refused too.
+	RefreshReuseGrace time.Duration
+	// SessionIdleTimeout, when positive, ends sessions unused for this
+	// long: refreshing fails once neither the refresh token nor the access
+	// tokens issued with it have been used within it (see TouchSession).
+	SessionIdleTimeout time.Duration
+
+	// Denylist, when set, is told about revocations made through this
+	// instance so they apply without waiting for the next store sync.
+	Denylist Denylist
+
+	// ServiceAccounts maps verified TLS client certificates to service
+	// accounts; nil when client certificates are not configured.
+	ServiceAccounts *mtls.Accounts
+
+	// GeoCountryHeader names a request header carrying the client's ISO
+	// country code, set by a trusted proxy or CDN (e.g. CF-IPCountry). It
+	// is recorded with login attempts; empty disables it.
+	GeoCountryHeader string
+
+	// Mailer sends security notifications to users; nil disables them.
+	Mailer mail.Sender
+	// Templates renders notification emails; nil uses the built-ins.
+	Templates *mail.Templates
+
+	// SMS sends phone verification and login codes; nil disables phone
+	// numbers and the SMS second factor.
+	SMS sms.Sender
+	// PhoneLogin lets users give a phone number at registration and sign
+	// in with it, instead of their username, once it is verified.
+	PhoneLogin bool
+
+	// MagicLinkEnabled allows passwordless sign-in through emailed links
+	// valid for MagicLinkTTL (default 15 minutes); it needs a Mailer. With
+	// MagicLinkDeviceBinding a link only works in the browser that asked
+	// for it.
+	MagicLinkEnabled       bool
+	MagicLinkTTL           time.Duration
+	MagicLinkDeviceBinding bool
+
+	// GuestEnabled allows anonymous guest sessions with tokens valid for
+	// GuestTokenTTL (default 1 hour). Guest accounts that have not
+	// registered are purged GuestMaxAge (default 30 days) after creation.
+	GuestEnabled  bool
+	GuestTokenTTL time.Duration
+	GuestMaxAge   time.Duration
+
+	// SSOCookieDomain, when set (e.g. example.com), makes logins also set
+	// a signed cookie for that domain and its subdomains, which sibling
+	// apps verify through /api/auth/sso/check. SSOCookieName and
+	// SSOCookieTTL default to "sentinel_sso" and 12 hours.
+	SSOCookieDomain string
+	SSOCookieName   string
+	SSOCookieTTL    time.Duration
+
+	// Backchannel notifies registered clients when a user logs out or is
+	// disabled or deleted; nil disables back-channel logout.
+	Backchannel *backchannel.Notifier
+
+	// Tarpit delays login attempts from clients and against accounts with
+	// repeated recent failures; nil disables it.
+	Tarpit *tarpit.Tarpit
+
+	// Canaries recognizes bait accounts so that sign-in attempts against
+	// them raise alerts; nil disables the check.
+	Canaries *canary.Registry
+
+	// RequestSigning adds a signing_key to login and refresh responses,
+	// with which clients sign requests to the endpoints that require it;
+	// see middleware.WithRequestSignature.
+	RequestSigning bool
+
+	// DPoP, when set, binds the tokens issued at login and refresh to the
+	// key of a DPoP proof sent with the request; see dpop.
+	DPoP *dpop.Verifier
+
+	// Webhooks delivers webhook events and redelivers them from the
+	// delivery log; nil when no webhook is configured.
+	Webhooks *webhooks.Dispatcher
+
+	// Quotas enforces per-client request quotas; nil disables them.
+	Quotas *quota.Enforcer
+
+	// Clients holds the registered clients whose token policy overrides
+	// the server's; nil when none can be registered, in which case logins
+	// naming a client are refused.
+	Clients *clients.Registry
+
+	// Flags gates behaviour being rolled out gradually; nil leaves every
+	// flag off.
+	Flags *flags.Set
+
+	// PublicURL is the externally visible base URL of this service (e.g.
+	// https://auth.example.com), used to build links sent by email.
+	PublicURL string
+
+	// AuditLog, when set, records login attempts in the background rather
+	// than while the client waits; nil records them through Store.
+	AuditLog *audit.Writer
+	// AuditRetention is the audit log retention policy reported by
+	// GET /api/admin/audit/retention; nil keeps events forever.
+	AuditRetention *audit.Retention
+
+	// Settings is the effective configuration reported by
+	// GET /api/admin/config, with secrets already masked.
+	Settings []config.Setting
+
+	// background tracks work that requests leave running, such as
+	// notification emails, so Drain can wait for it on shutdown.
+	background sync.WaitGroup
+
+	// refreshReplays holds the responses RefreshReuseGrace replays.
+	refreshReplays refreshReplays
+	// sessionUses throttles the session activity TouchSession records.
+	sessionUses sessionUses
 }
 
+// Default refresh session lifetimes.
+const (
+	DefaultRefreshTokenTTL    = 7 * 24 * time.Hour
+	DefaultRefreshMaxLifetime = 30 * 24 * time.Hour
+	// MaxRefreshReuseGrace bounds RefreshReuseGrace: the window covers a
+	// retry, not a second client sharing the token.
+	MaxRefreshReuseGrace = time.Minute
+)
+
 // New returns a Handlers instance with injected dependencies.
 func New(s store.Store, a *auth.Auth) *Handlers {
-	return &Handlers{Store: s, Auth: a}
+	h := &Handlers{Store: s, Auth: a, Dependencies: health.NewRegistry()}
+	h.Dependencies.Register("store", s)
+	return h
 }
 
 // ErrorResponse represents a structured error response.
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
-}
+type ErrorResponse = httpjson.ErrorResponse
 
 // writeErrorResponse writes a simple JSON error response.
 func writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
+	httpjson.Error(w, message, statusCode)
+}
+
+// writeJSON writes v as a JSON response with the given status code. The
+// body is encoded before the status is sent; see package httpjson.
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	httpjson.Write(w, statusCode, v)
+}
+
+// currentUser loads the authenticated user identified by the claims the auth
+// middleware placed in the request context. On failure it writes the error
+// response and returns false.
+func (h *Handlers) currentUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	claims, ok := r.Context().Value("user").(*auth.Claims)
+	if !ok {
+		writeErrorResponse(w, "Authentication required", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	userID, err := strconv.ParseInt(claims.UserID, 10, 64)
+	if err != nil {
+		writeErrorResponse(w, "Invalid user ID in token", http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := h.Store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		writeErrorResponse(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	if user.Disabled {
+		writeErrorResponse(w, "Account is disabled", http.StatusForbidden)
+		return nil, false
+	}
+	if user.Expired(time.Now()) {
+		writeErrorResponse(w, "Account has expired", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
 
-	response := ErrorResponse{
-		Error:   http.StatusText(statusCode),
-		Message: message,
+// userETag returns the strong entity tag for the user's current version.
+func userETag(u *models.User) string {
+	return `"` + strconv.FormatInt(u.Version, 10) + `"`
+}
+
+// checkIfMatch enforces optimistic locking preconditions: an If-Match header
+// must name the user's current ETag (or "*"). When required is set a missing
+// header is rejected with 428. On failure it writes the error response and
+// returns false.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, u *models.User, required bool) bool {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if required {
+			writeErrorResponse(w, "If-Match header with the user's ETag is required", http.StatusPreconditionRequired)
+			return false
+		}
+		return true
+	}
+
+	current := userETag(u)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	w.Header().Set("ETag", current)
+	writeErrorResponse(w, "User was modified by another request; reload and retry", http.StatusConflict)
+	return false
+}
+
+// writeUpdateError maps a failed UpdateUser to a response: 409 for version
+// conflicts, 404 for missing users, and 500 with fallback otherwise.
+func writeUpdateError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, store.ErrVersionConflict):
+		writeErrorResponse(w, "User was modified by another request; reload and retry", http.StatusConflict)
+	case errors.Is(err, store.ErrNotFound):
+		writeErrorResponse(w, "User not found", http.StatusNotFound)
+	default:
+		writeErrorResponse(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+// metadataPolicies returns the configured metadata policies or the defaults.
+func (h *Handlers) metadataPolicies() *metadata.Policies {
+	if h.Metadata != nil {
+		return h.Metadata
 	}
+	return metadata.Default()
+}
 
-	json.NewEncoder(w).Encode(response)
+// usernamePolicy returns the configured username policy or the default.
+func (h *Handlers) usernamePolicy() *validation.UsernamePolicy {
+	if h.UsernamePolicy != nil {
+		return h.UsernamePolicy
+	}
+	return validation.DefaultUsernamePolicy()
 }
 
+// templates returns the configured email templates or the built-ins.
+func (h *Handlers) templates() *mail.Templates {
+	if h.Templates != nil {
+		return h.Templates
+	}
+	return mail.DefaultTemplates()
+}
+
+// profileView returns the user's own view of their account: the public
+// fields plus the metadata keys they are allowed to read.
+func (h *Handlers) profileView(u *models.User) *models.User {
+	view := u.PublicUser()
+	if len(u.Metadata) > 0 {
+		view.Metadata = h.metadataPolicies().VisibleToUser(u.Metadata)
+	}
+	return view
+}
+
+// errUsernameTaken aborts the registration transaction when the username exists.
+var errUsernameTaken = errors.New("username already exists")
+
+// errPhoneTaken aborts a registration or phone change when another account
+// has verified the number.
+var errPhoneTaken = errors.New("phone number already in use")
+
 // registerRequest is the expected payload for POST /register.
 type registerRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Phone, with phone login enabled, is texted a verification code.
+	Phone string `json:"phone,omitempty"`
 }
 
 // loginRequest is the expected payload for POST /login.
 type loginRequest struct {
 	Username string `json:"username"`
+	// Phone may be sent instead of Username when phone login is enabled.
+	Phone    string `json:"phone,omitempty"`
 	Password string `json:"password"`
+	// RecoveryCode may be sent instead of Password; it is consumed on use.
+	RecoveryCode string `json:"recovery_code,omitempty"`
+	// OTPCode is the code texted to users with the SMS second factor on.
+	OTPCode string `json:"otp_code,omitempty"`
+	// ClientID names the registered client signing in, whose token policy
+	// applies to the session, and Scope the space-delimited scope it
+	// requests; see clients.GrantScope.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 }
 
 // refreshRequest is the expected payload for POST /refresh.
@@ -63,8 +410,11 @@ type refreshRequest struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-// Register handles POST /api/auth/register and creates a new user.
+// Register handles POST /api/auth/register and creates a new user. When
+// called with a guest token, the guest account becomes the new user and
+// keeps its ID.
 func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	log := logger.WithFields(map[string]interface{}{
 		"handler":  "register",
 		"method":   r.Method,
@@ -74,7 +424,7 @@ func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
 
 	var req registerRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Warn("Invalid JSON payload in registration request", map[string]interface{}{
+		logger.FromContext(r.Context()).Warn("Invalid JSON payload in registration request", map[string]interface{}{
 			"handler": "register",
 			"error":   err.Error(),
 		})
@@ -86,6 +436,7 @@ func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
 	req.Username = validation.SanitizeInput(req.Username)
 	req.Email = validation.SanitizeInput(req.Email)
 	req.Password = validation.SanitizeInput(req.Password)
+	req.Phone = validation.SanitizeInput(req.Phone)
 
 	log = logger.WithFields(map[string]interface{}{
 		"handler":  "register",
@@ -94,30 +445,46 @@ func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
 	})
 
 	// Validate the registration request
-	if err := validation.ValidateRegisterRequest(req.Username, req.Email, req.Password); err != nil {
+	if err := validation.ValidateRegistration(h.usernamePolicy(), req.Username, req.Email, req.Password); err != nil {
 		log.Warn("Registration validation failed", map[string]interface{}{
 			"error": err.Error(),
 		})
 		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	// Check if user already exists
-	existingUser, err := h.Store.GetUserByUsername(r.Context(), req.Username)
-	if err != nil {
-		log.Error("Database error while checking existing user", map[string]interface{}{
+	if err := h.checkPassword(req.Password, req.Username, req.Email); err != nil {
+		log.Warn("Registration validation failed", map[string]interface{}{
 			"error": err.Error(),
 		})
-		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if existingUser != nil {
-		log.Warn("Registration attempt with existing username")
-		writeErrorResponse(w, "Username already exists", http.StatusConflict)
+	if req.Phone != "" {
+		if !h.phoneLoginEnabled() {
+			writeErrorResponse(w, "Phone registration is not enabled", http.StatusBadRequest)
+			return
+		}
+		if err := validation.ValidatePhone(req.Phone); err != nil {
+			writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Upgrade the caller's guest account rather than creating a new one.
+	// Tokens for registered accounts are ignored here.
+	claims, err := h.bearerClaims(r)
+	if err != nil {
+		writeErrorResponse(w, "Invalid or expired token", http.StatusUnauthorized)
 		return
 	}
+	guestID, upgrade := guestUserID(claims)
+	if upgrade {
+		// Audit the upgrade as performed by the guest
+		r = r.WithContext(context.WithValue(r.Context(), "user", claims))
+	}
 
-	// Hash password with strong settings
+	// Hash password with strong settings. This happens before the
+	// transaction so the write lock is not held during bcrypt.
 	hashedPassword, err := auth.HashPassword(req.Password)
 	if err != nil {
 		log.Error("Password hashing failed", map[string]interface{}{
@@ -136,8 +503,86 @@ func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
 		CreatedAt: time.Now().UTC(),
 	}
 
-	userID, err := h.Store.CreateUser(r.Context(), user)
+	// Check for an existing user and create the new one atomically
+	var userID int64
+	var recoveryCodes []string
+	err = h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if h.EnumerationProtection {
+			taken, err := tx.EmailExists(r.Context(), req.Email)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return errEmailTaken
+			}
+		}
+		taken, err := tx.UsernameExists(r.Context(), req.Username)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errUsernameTaken
+		}
+		if req.Phone != "" {
+			existingUser, err := tx.GetUserByPhone(r.Context(), req.Phone)
+			if err != nil {
+				return err
+			}
+			if existingUser != nil {
+				return errPhoneTaken
+			}
+		}
+		if upgrade {
+			guest, err := tx.GetUserByID(r.Context(), guestID)
+			if err != nil {
+				return err
+			}
+			if guest == nil || guest.Role != models.RoleGuest {
+				return errGuestExpired
+			}
+			upgraded := snapshotUser(guest)
+			upgraded.Username, upgraded.Email, upgraded.Password, upgraded.Role = user.Username, user.Email, user.Password, user.Role
+			if err := tx.UpgradeGuest(r.Context(), upgraded); err != nil {
+				return err
+			}
+			if err := recordUserAudit(r.Context(), tx, r, auditUserGuestUpgrade, guest, upgraded); err != nil {
+				return err
+			}
+			user, userID = upgraded, upgraded.ID
+		} else if userID, err = tx.CreateUser(r.Context(), user); err != nil {
+			return err
+		}
+		// The codes could not be shown under enumeration protection; the
+		// user generates them after signing in
+		if h.EnumerationProtection {
+			return nil
+		}
+		recoveryCodes, err = issueRecoveryCodes(r.Context(), tx, userID)
+		return err
+	})
+	if err != nil && h.EnumerationProtection && isRegistrationConflict(err) {
+		log.Warn("Registration conflict hidden by enumeration protection", map[string]interface{}{
+			"error": err.Error(),
+		})
+		h.notifyRegistrationConflict(r, req, err)
+		acceptRegistration(w, r, start)
+		return
+	}
 	if err != nil {
+		if errors.Is(err, errUsernameTaken) {
+			log.Warn("Registration attempt with existing username")
+			writeErrorResponse(w, "Username already exists", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, errGuestExpired) || errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrVersionConflict) {
+			writeErrorResponse(w, "Guest session has expired", http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, errPhoneTaken) {
+			log.Warn("Registration attempt with existing phone number")
+			writeErrorResponse(w, "Phone number already in use", http.StatusConflict)
+			return
+		}
 		if strings.Contains(err.Error(), "already exists") {
 			log.Warn("User creation failed due to duplicate", map[string]interface{}{
 				"error": err.Error(),
@@ -152,19 +597,58 @@ func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	registrations.WithLabelValues().Inc()
 	log.Info("User successfully registered", map[string]interface{}{
 		"user_id": userID,
+		"guest":   upgrade,
 	})
 
-	// Return success response with user ID (no sensitive data)
+	// The guest token would otherwise stay valid with the guest role
+	if upgrade {
+		if err := h.revokeToken(r, claims.ID, claims.ExpiresAt.Time); err != nil {
+			log.Error("Failed to revoke guest token", map[string]interface{}{
+				"user_id": userID,
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	// Return the user ID and the one-time recovery codes, which are not
+	// shown again
 	response := map[string]interface{}{
-		"id":      userID,
-		"message": "User created successfully",
+		"id":             userID,
+		"message":        "User created successfully",
+		"recovery_codes": recoveryCodes,
+	}
+
+	// The number is saved once the user confirms the texted code through
+	// /api/auth/phone/verify; a failed send can be retried from there.
+	if req.Phone != "" {
+		user.ID = userID
+		expiresAt, err := h.sendOTP(r, user, models.OTPPurposePhoneVerify, req.Phone)
+		if err != nil {
+			log.Error("Failed to send phone verification code", map[string]interface{}{
+				"user_id": userID,
+				"error":   err.Error(),
+			})
+		} else {
+			response["phone_verification"] = map[string]interface{}{
+				"phone":      req.Phone,
+				"expires_at": expiresAt,
+			}
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(response)
+	h.publishEvent(r, webhooks.EventUserRegister, map[string]interface{}{
+		"user_id":  userID,
+		"username": req.Username,
+	})
+	if h.EnumerationProtection {
+		acceptRegistration(w, r, start)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, response)
 }
 
 // Login handles POST /api/auth/login and returns access and refresh tokens.
@@ -177,116 +661,267 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 
 	// Sanitize inputs
 	req.Username = validation.SanitizeInput(req.Username)
+	req.Phone = validation.SanitizeInput(req.Phone)
 	req.Password = validation.SanitizeInput(req.Password)
+	req.RecoveryCode = validation.SanitizeInput(req.RecoveryCode)
+	req.OTPCode = validation.SanitizeInput(req.OTPCode)
 
 	// Basic validation
-	if req.Username == "" || req.Password == "" {
+	byPhone := req.Username == "" && req.Phone != ""
+	if byPhone && !h.phoneLoginEnabled() {
+		writeErrorResponse(w, "Phone login is not enabled", http.StatusBadRequest)
+		return
+	}
+	if (req.Username == "" && !byPhone) || (req.Password == "" && req.RecoveryCode == "") {
 		writeErrorResponse(w, "Username and password are required", http.StatusBadRequest)
 		return
 	}
+	usedRecoveryCode := req.Password == ""
+	clientID, scope, ok := h.clientScope(w, req.ClientID, req.Scope)
+	if !ok {
+		return
+	}
+	jkt, ok := h.proofKey(w, r, "")
+	if !ok {
+		return
+	}
 
-	// Get user from store
-	user, err := h.Store.GetUserByUsername(r.Context(), req.Username)
+	// Get user from store; only verified phone numbers are stored
+	var user *models.User
+	var err error
+	if byPhone {
+		user, err = h.Store.GetUserByPhone(r.Context(), req.Phone)
+	} else {
+		user, err = h.Store.GetUserByUsername(r.Context(), req.Username)
+	}
 	if err != nil {
 		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	// Check if user exists and verify password
-	if user == nil || auth.CheckPassword(user.Password, req.Password) != nil {
+	// Slow down repeated failures before spending time on bcrypt
+	identifier := req.Username
+	if byPhone {
+		identifier = req.Phone
+	}
+	keys := tarpitKeys(r, user, identifier)
+	if !h.tarpitWait(w, r, keys) {
+		return
+	}
+	// Bait accounts fail like any wrong password after raising the alarm
+	h.canaryTripped(r, user, canary.ViaLogin)
+
+	// Check if user exists and verify the password or recovery code
+	verified := false
+	if user != nil && usedRecoveryCode {
+		verified, err = h.Store.UseRecoveryCode(r.Context(), user.ID, auth.HashRecoveryCode(req.RecoveryCode))
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	} else if user != nil {
+		verified = auth.CheckPassword(user.Password, req.Password) == nil
+	}
+	if !verified {
 		// Use the same error message for both cases to prevent username enumeration
+		loginAttempts.WithLabelValues("failure").Inc()
+		h.tarpitFail(r, keys)
+		if user != nil {
+			h.recordLogin(r, user, auditUserLoginFailed)
+		}
 		writeErrorResponse(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Generate access token (1 hour) and refresh token (7 days)
-	accessToken, err := h.Auth.GenerateTokenWithType(
-		strconv.FormatInt(user.ID, 10),
-		user.Role,
-		"access",
-		1*time.Hour,
-	)
-	if err != nil {
-		writeErrorResponse(w, "Failed to create authentication token", http.StatusInternalServerError)
+	// Only reveal the disabled state after the password has been verified
+	if user.Disabled {
+		loginAttempts.WithLabelValues("disabled").Inc()
+		h.recordLogin(r, user, auditUserLoginFailed)
+		writeErrorResponse(w, "Account is disabled", http.StatusForbidden)
+		return
+	}
+	if user.Expired(time.Now()) {
+		loginAttempts.WithLabelValues("expired").Inc()
+		h.recordLogin(r, user, auditUserLoginFailed)
+		writeErrorResponse(w, "Account has expired", http.StatusForbidden)
+		return
+	}
+
+	// Password logins with the SMS second factor also need a texted code;
+	// a recovery code stands in for both.
+	if user.SMSOTP && !usedRecoveryCode && !h.requireLoginOTP(w, r, user, req.OTPCode) {
 		return
 	}
 
-	refreshToken, err := h.Auth.GenerateTokenWithType(
+	response, ok := h.startSession(w, r, user, auth.Grant{JKT: jkt, ClientID: clientID, Scope: scope})
+	if !ok {
+		return
+	}
+	// Forgive the account's failures but not the IP's, so an attacker
+	// cannot clear their record by signing in to an account of their own
+	if h.Tarpit != nil {
+		h.Tarpit.Reset(r.Context(), keys[1])
+	}
+	if usedRecoveryCode {
+		for k, v := range h.recoveryLoginCompleted(r, user) {
+			response[k] = v
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// startSession issues access and refresh tokens for the session g to user
+// after a successful login and records it, returning the login response.
+// The tokens follow the token policy of g's client, if any. It returns
+// false, having written an error, if the tokens cannot be issued.
+func (h *Handlers) startSession(w http.ResponseWriter, r *http.Request, user *models.User, g auth.Grant) (map[string]interface{}, bool) {
+	// Generate refresh token (see refreshExpiry) and access token (1 hour
+	// unless the client's policy says otherwise)
+	now := time.Now()
+	client := h.client(g.ClientID)
+	expiresAt := h.refreshExpiry(now, nil, now, h.sessionTTL(r, user.ID, client), h.slidingRefresh(client))
+	refreshToken, sessionID, err := h.issueRefreshToken(r, user.ID, user.Role, g, now, expiresAt, "")
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to issue refresh token", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create refresh token", http.StatusInternalServerError)
+		return nil, false
+	}
+	g.SessionID = sessionID
+	ttl := accessTokenTTL(client)
+	accessToken, err := h.Auth.GenerateSessionToken(
 		strconv.FormatInt(user.ID, 10),
 		user.Role,
-		"refresh",
-		7*24*time.Hour,
+		g,
+		ttl,
 	)
 	if err != nil {
-		writeErrorResponse(w, "Failed to create refresh token", http.StatusInternalServerError)
-		return
+		writeErrorResponse(w, "Failed to create authentication token", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	loginAttempts.WithLabelValues("success").Inc()
+	h.alertNewLogin(r, user)
+	h.recordLogin(r, user, auditUserLogin)
+	if h.ssoEnabled() {
+		h.issueSSOCookie(w, r, user.ID, user.Role)
 	}
 
 	// Return tokens and basic user info (no sensitive data)
 	response := map[string]interface{}{
 		"access_token":  accessToken,
 		"refresh_token": refreshToken,
-		"token_type":    "Bearer",
-		"expires_in":    3600, // 1 hour in seconds
-		"user":          user.PublicUser(),
+		"token_type":    tokenType(g.JKT),
+		"expires_in":    int(ttl.Seconds()),
+		"user":          h.profileView(user),
 	}
+	if g.Scope != "" {
+		response["scope"] = g.Scope
+	}
+	if key := h.signingKey(accessToken); key != "" {
+		response["signing_key"] = key
+	}
+	return response, true
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// signingKey returns the request signing key for accessToken, or "" when
+// request signing is off.
+func (h *Handlers) signingKey(accessToken string) string {
+	if !h.RequestSigning {
+		return ""
+	}
+	claims, err := h.Auth.DecodeToken(accessToken)
+	if err != nil {
+		return ""
+	}
+	return h.Auth.SigningKey(claims.ID)
 }
 
-// Health returns a basic health check response.
+// Health handles GET /health, checking every registered dependency. It
+// answers 503 when a critical one, such as the store, fails, and 200 with
+// status "degraded" when only optional ones do. Failures are logged rather
+// than returned, as their messages may name internal hosts.
 func (h *Handlers) Health(w http.ResponseWriter, r *http.Request) {
-	// Check database connectivity
-	if err := h.Store.Ping(r.Context()); err != nil {
-		writeErrorResponse(w, "Database unavailable", http.StatusServiceUnavailable)
-		return
+	report := h.Dependencies.Check(r.Context())
+	for name, res := range report.Checks {
+		if res.Err != nil {
+			logger.FromContext(r.Context()).Warn("Health check failed", map[string]interface{}{
+				"dependency": name,
+				"critical":   res.Critical,
+				"error":      res.Err.Error(),
+			})
+		}
 	}
 
 	response := map[string]interface{}{
-		"status":    "ok",
+		"status":    report.Status,
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 		"version":   "0.1.0",
+		"checks":    report.Checks,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	// A degraded service still serves requests
+	status := http.StatusOK
+	if report.Status == health.StatusUnavailable {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, response)
 }
 
-// Me returns the authenticated user's profile (requires auth middleware).
+// Me returns the authenticated user's profile (requires auth middleware),
+// limited to the fields parameter's fields when it is set.
 func (h *Handlers) Me(w http.ResponseWriter, r *http.Request) {
-	// Extract user claims from context (set by auth middleware)
-	claims, ok := r.Context().Value("user").(*auth.Claims)
+	fields, ok := parseFields(w, r)
 	if !ok {
-		writeErrorResponse(w, "Authentication required", http.StatusUnauthorized)
 		return
 	}
-
-	// Parse user ID from claims
-	userID, err := strconv.ParseInt(claims.UserID, 10, 64)
-	if err != nil {
-		writeErrorResponse(w, "Invalid user ID in token", http.StatusBadRequest)
+	// Load the user identified by the token claims (set by auth middleware)
+	user, ok := h.currentUser(w, r)
+	if !ok {
 		return
 	}
 
-	// Get user from store
-	user, err := h.Store.GetUserByID(r.Context(), userID)
-	if err != nil {
-		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
-		return
+	// Return user profile (excluding sensitive data)
+	view := h.profileView(user)
+	if fields == nil || fields["pending_email"] {
+		view.PendingEmail = h.pendingEmail(r, user.ID)
 	}
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusOK, fields.user(view))
+}
 
-	if user == nil {
-		writeErrorResponse(w, "User not found", http.StatusNotFound)
-		return
+// refreshExpiry returns when the refresh token issued now for a session that
+// began at authTime expires, given the session's ttl (see sessionTTL) and
+// whether it follows the sliding policy (see slidingRefresh). prev is the
+// token being rotated, nil at login.
+func (h *Handlers) refreshExpiry(authTime time.Time, prev *auth.Claims, now time.Time, ttl time.Duration, sliding bool) time.Time {
+	if !sliding {
+		// Fixed window: rotation keeps the expiry set at login.
+		if prev != nil && prev.ExpiresAt != nil {
+			return prev.ExpiresAt.Time
+		}
+		return now.Add(ttl)
 	}
 
-	// Return user profile (excluding sensitive data)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user.PublicUser())
+	maxLifetime := h.RefreshMaxLifetime
+	if maxLifetime <= 0 {
+		maxLifetime = DefaultRefreshMaxLifetime
+	}
+	expiresAt := now.Add(ttl)
+	if limit := authTime.Add(maxLifetime); expiresAt.After(limit) {
+		expiresAt = limit
+	}
+	return expiresAt
 }
 
 // RefreshToken exchanges a refresh token for new access and refresh tokens.
+// The new refresh token belongs to the same session; its expiry follows the
+// fixed or sliding policy (see Handlers.RefreshTokenTTL), or that of the
+// client that started the session.
 func (h *Handlers) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	var req refreshRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -297,15 +932,23 @@ func (h *Handlers) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	// Validate refresh token
 	claims, err := h.Auth.ParseToken(req.RefreshToken)
 	if err != nil {
+		middleware.ReportCanaryToken(h.Auth, r, err)
 		writeErrorResponse(w, "Invalid or expired refresh token", http.StatusUnauthorized)
 		return
 	}
 
 	// Verify token type
 	if claims.TokenType != "refresh" {
+		auth.RecordTokenRejection(auth.ReasonWrongType)
 		writeErrorResponse(w, "Token is not a refresh token", http.StatusBadRequest)
 		return
 	}
+	// Bound sessions stay bound; a proof sent with a bearer refresh token
+	// binds the session from now on.
+	jkt, ok := h.proofKey(w, r, claims.BoundKey())
+	if !ok {
+		return
+	}
 
 	// Parse user ID
 	userID, err := strconv.ParseInt(claims.UserID, 10, 64)
@@ -325,27 +968,92 @@ func (h *Handlers) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		writeErrorResponse(w, "User not found", http.StatusUnauthorized)
 		return
 	}
+	if user.Disabled {
+		writeErrorResponse(w, "Account is disabled", http.StatusForbidden)
+		return
+	}
+	now := time.Now()
+	if user.Expired(now) {
+		writeErrorResponse(w, "Account has expired", http.StatusForbidden)
+		return
+	}
 
-	// Generate new access token and refresh token (token rotation)
-	newAccessToken, err := h.Auth.GenerateTokenWithType(
-		claims.UserID,
-		claims.Role,
-		"access",
-		1*time.Hour,
-	)
+	if !h.sessionActive(w, r, claims, now) {
+		return
+	}
+
+	// Sessions of a client follow its current policy, and end when it is
+	// unregistered
+	var client *models.Client
+	scope := claims.Scope
+	if claims.ClientID != "" {
+		if client = h.client(claims.ClientID); client == nil {
+			writeErrorResponse(w, "Client is no longer registered, please log in again", http.StatusUnauthorized)
+			return
+		}
+		scope = clients.NarrowScope(client, scope)
+	}
+
+	// A retry within the grace window gets the pair already issued, once
+	// the session has passed the same checks as the first attempt
+	if response, ok := h.refreshReplays.take(claims.ID, now); ok {
+		refreshReplaysTotal.WithLabelValues().Inc()
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	// Rotate the refresh token within the same session. Tokens issued
+	// before auth_time existed date their session from issuance.
+	authTime := now
+	if claims.AuthTime != nil {
+		authTime = claims.AuthTime.Time
+	} else if claims.IssuedAt != nil {
+		authTime = claims.IssuedAt.Time
+	}
+	expiresAt := h.refreshExpiry(authTime, claims, now, h.sessionTTL(r, userID, client), h.slidingRefresh(client))
+	if !expiresAt.After(now) {
+		writeErrorResponse(w, "Session expired, please log in again", http.StatusUnauthorized)
+		return
+	}
+
+	// Each refresh token rotates once; presenting it again is refused,
+	// except for the retry replayed above
+	fresh, err := h.Store.UseNonce(r.Context(), refreshNonceKey(claims.ID), claims.ExpiresAt.Time, now)
 	if err != nil {
-		writeErrorResponse(w, "Failed to create access token", http.StatusInternalServerError)
+		logger.FromContext(r.Context()).Error("Failed to consume refresh token", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !fresh {
+		writeErrorResponse(w, "Refresh token has already been used", http.StatusUnauthorized)
 		return
 	}
 
-	newRefreshToken, err := h.Auth.GenerateTokenWithType(
+	g := auth.Grant{JKT: jkt, ClientID: claims.ClientID, Scope: scope}
+	newRefreshToken, sessionID, err := h.issueRefreshToken(r, userID, claims.Role, g, authTime, expiresAt, claims.ID)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to issue refresh token", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	// Generate new access token for the rotated session
+	g.SessionID = sessionID
+	ttl := accessTokenTTL(client)
+	newAccessToken, err := h.Auth.GenerateSessionToken(
 		claims.UserID,
 		claims.Role,
-		"refresh",
-		7*24*time.Hour,
+		g,
+		ttl,
 	)
 	if err != nil {
-		writeErrorResponse(w, "Failed to create refresh token", http.StatusInternalServerError)
+		writeErrorResponse(w, "Failed to create access token", http.StatusInternalServerError)
 		return
 	}
 
@@ -353,10 +1061,18 @@ func (h *Handlers) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"access_token":  newAccessToken,
 		"refresh_token": newRefreshToken,
-		"token_type":    "Bearer",
-		"expires_in":    3600, // 1 hour in seconds
+		"token_type":    tokenType(jkt),
+		"expires_in":    int(ttl.Seconds()),
+	}
+	if scope != "" {
+		response["scope"] = scope
+	}
+	if key := h.signingKey(newAccessToken); key != "" {
+		response["signing_key"] = key
+	}
+	if h.RefreshReuseGrace > 0 {
+		h.refreshReplays.put(claims.ID, response, now.Add(h.RefreshReuseGrace))
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, http.StatusOK, response)
 }
@@ -3,17 +3,51 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mayvqt/Sentinel/internal/analytics"
+	"github.com/mayvqt/Sentinel/internal/audit"
 	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/backchannel"
+	"github.com/mayvqt/Sentinel/internal/canary"
+	"github.com/mayvqt/Sentinel/internal/clients"
 	"github.com/mayvqt/Sentinel/internal/config"
+	"github.com/mayvqt/Sentinel/internal/denylist"
+	"github.com/mayvqt/Sentinel/internal/dpop"
+	"github.com/mayvqt/Sentinel/internal/flags"
+	"github.com/mayvqt/Sentinel/internal/health"
+	"github.com/mayvqt/Sentinel/internal/mail"
+	"github.com/mayvqt/Sentinel/internal/middleware"
 	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/mtls"
+	"github.com/mayvqt/Sentinel/internal/quota"
+	"github.com/mayvqt/Sentinel/internal/sms"
+	"github.com/mayvqt/Sentinel/internal/storage"
 	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tarpit"
+	"github.com/mayvqt/Sentinel/internal/validation"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
+	"github.com/mayvqt/Sentinel/pkg/webhook"
 )
 
 func setupTestHandlers() (*Handlers, store.Store) {
@@ -102,6 +136,30 @@ func TestRegisterLoginHealth(t *testing.T) {
 		t.Fatalf("expected 200 from health, got %d", hw.Result().StatusCode)
 	}
 
+	// Registered dependencies are aggregated, and only critical ones make
+	// the service unavailable
+	down := health.CheckerFunc(func(context.Context) error { return errors.New("connection refused") })
+	checkHealth := func(wantCode int, wantStatus string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.Health(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+		var resp struct {
+			Status string                   `json:"status"`
+			Checks map[string]health.Result `json:"checks"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != wantCode || resp.Status != wantStatus || resp.Checks["store"].Status != health.StatusOK {
+			t.Errorf("got %d %s, want %d %s: %s", w.Code, resp.Status, wantCode, wantStatus, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), "connection refused") {
+			t.Error("expected failure details to stay out of the response")
+		}
+	}
+	h.Dependencies.RegisterOptional("mail", down)
+	checkHealth(http.StatusOK, health.StatusDegraded)
+	h.Dependencies.Register("queue", down)
+	checkHealth(http.StatusServiceUnavailable, health.StatusUnavailable)
+
 	_ = s.Close()
 }
 
@@ -325,3 +383,3025 @@ func TestMeEndpoint(t *testing.T) {
 		})
 	}
 }
+
+func TestFieldSelection(t *testing.T) {
+	h, s := setupTestHandlers()
+	if _, err := s.CreateUser(context.Background(), &models.User{Username: "partial", Email: "p@example.com", Password: "hash", Role: "user"}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	get := func(handler http.HandlerFunc, target string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.SetPathValue("id", "1")
+		req = req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "1", Role: "admin"}))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	code, profile := get(h.Me, "/api/auth/profile?fields=id,%20username,role")
+	if code != http.StatusOK || len(profile) != 3 || profile["username"] != "partial" || profile["email"] != nil {
+		t.Fatalf("expected only the selected fields, got %d: %v", code, profile)
+	}
+	if _, profile := get(h.Me, "/api/auth/profile"); profile["email"] != "p@example.com" {
+		t.Errorf("expected every field without fields, got %v", profile)
+	}
+	if code, _ := get(h.Me, "/api/auth/profile?fields=id,password"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown field, got %d", code)
+	}
+	if code, user := get(h.AdminGetUser, "/api/admin/users/1?fields=email"); code != http.StatusOK || len(user) != 1 || user["email"] != "p@example.com" {
+		t.Errorf("expected only the email, got %d: %v", code, user)
+	}
+	_, page := get(h.AdminSearchUsers, "/api/admin/users/search?q=partial&fields=id")
+	results, _ := page["results"].([]interface{})
+	if len(results) != 1 || len(results[0].(map[string]interface{})["user"].(map[string]interface{})) != 1 {
+		t.Errorf("expected search results limited to the ID, got %v", page)
+	}
+}
+
+// avatarRequest builds an authenticated multipart PUT carrying data as the avatar file.
+func avatarRequest(t *testing.T, userID string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("avatar", "avatar.png")
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	fw.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPut, "/api/auth/profile/avatar", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	ctx := context.WithValue(req.Context(), "user", &auth.Claims{UserID: userID, Role: "user"})
+	return req.WithContext(ctx)
+}
+
+func TestUploadAvatar(t *testing.T) {
+	h, s := setupTestHandlers()
+	media, err := storage.NewLocal(t.TempDir(), "/media")
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	h.Media = media
+	h.AvatarDimension = 32
+
+	user := &models.User{Username: "avataruser", Email: "a@example.com", Password: "hash", Role: "user"}
+	if _, err := s.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 60))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 60; y++ {
+			img.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	var pngData bytes.Buffer
+	png.Encode(&pngData, img)
+
+	w := httptest.NewRecorder()
+	h.UploadAvatar(w, avatarRequest(t, "1", pngData.Bytes()))
+	if w.Code != http.StatusOK {
+		t.Fatalf("UploadAvatar() status = %d, body: %s", w.Code, w.Body.String())
+	}
+
+	var got models.User
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if !strings.HasPrefix(got.AvatarURL, "/media/avatars/1/") {
+		t.Fatalf("unexpected avatar URL %q", got.AvatarURL)
+	}
+
+	// The stored object is a resized square PNG served by the local backend
+	fetch := httptest.NewRecorder()
+	media.ServeHTTP(fetch, httptest.NewRequest(http.MethodGet, got.AvatarURL, nil))
+	if fetch.Code != http.StatusOK {
+		t.Fatalf("expected stored avatar to be served, got %d", fetch.Code)
+	}
+	stored, err := png.Decode(fetch.Body)
+	if err != nil {
+		t.Fatalf("stored avatar is not a PNG: %v", err)
+	}
+	if b := stored.Bounds(); b.Dx() != 32 || b.Dy() != 32 {
+		t.Fatalf("expected 32x32 avatar, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	// Non-image content is rejected
+	w = httptest.NewRecorder()
+	h.UploadAvatar(w, avatarRequest(t, "1", []byte("<html>not an image</html>")))
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415 for non-image upload, got %d", w.Code)
+	}
+
+	// Oversized uploads are rejected
+	h.AvatarMaxBytes = 16
+	w = httptest.NewRecorder()
+	h.UploadAvatar(w, avatarRequest(t, "1", pngData.Bytes()))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for oversized upload, got %d", w.Code)
+	}
+}
+
+func TestMetadataPolicies(t *testing.T) {
+	h, s := setupTestHandlers()
+	user := &models.User{Username: "metauser", Email: "m@example.com", Password: "hash", Role: "user"}
+	if _, err := s.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	asUser := func(method, path, body string) *http.Request {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		return req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "1", Role: "user"}))
+	}
+
+	// Users may write user-editable keys
+	w := httptest.NewRecorder()
+	h.UpdateProfileMetadata(w, asUser(http.MethodPatch, "/api/auth/profile/metadata", `{"locale":"fr-FR"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for user-editable key, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Users may not write keys without a user policy
+	w = httptest.NewRecorder()
+	h.UpdateProfileMetadata(w, asUser(http.MethodPatch, "/api/auth/profile/metadata", `{"plan":"enterprise"}`))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for admin-only key, got %d", w.Code)
+	}
+
+	// Admins may write any key
+	req := httptest.NewRequest(http.MethodPatch, "/api/admin/users/1/metadata", strings.NewReader(`{"plan":"enterprise"}`))
+	req.SetPathValue("id", "1")
+	req.Header.Set("If-Match", "*")
+	w = httptest.NewRecorder()
+	h.AdminUpdateUserMetadata(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for admin metadata update, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The profile shows user-visible keys only
+	w = httptest.NewRecorder()
+	h.Me(w, asUser(http.MethodGet, "/api/auth/profile", ""))
+	var profile models.User
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	if profile.Metadata["locale"] != "fr-FR" {
+		t.Fatalf("expected locale in profile metadata, got %v", profile.Metadata)
+	}
+	if _, ok := profile.Metadata["plan"]; ok {
+		t.Fatalf("admin-only key leaked into profile: %v", profile.Metadata)
+	}
+
+	// Oversized metadata is rejected
+	big := `{"display_name":"` + strings.Repeat("x", 5000) + `"}`
+	w = httptest.NewRecorder()
+	h.UpdateProfileMetadata(w, asUser(http.MethodPatch, "/api/auth/profile/metadata", big))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for oversized metadata, got %d", w.Code)
+	}
+}
+
+func TestOptimisticLocking(t *testing.T) {
+	h, s := setupTestHandlers()
+	user := &models.User{Username: "lockuser", Email: "l@example.com", Password: "hash", Role: "user"}
+	if _, err := s.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	adminPatch := func(ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/admin/users/1/metadata", strings.NewReader(body))
+		req.SetPathValue("id", "1")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		h.AdminUpdateUserMetadata(w, req)
+		return w
+	}
+
+	// The ETag reflects the stored version
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/users/1", nil)
+	req.SetPathValue("id", "1")
+	w := httptest.NewRecorder()
+	h.AdminGetUser(w, req)
+	etag := w.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("expected ETag \"1\", got %q", etag)
+	}
+
+	// Admin updates require a precondition
+	if w := adminPatch("", `{"plan":"pro"}`); w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected 428 without If-Match, got %d", w.Code)
+	}
+
+	// First writer wins and receives the new ETag
+	w = adminPatch(etag, `{"plan":"pro"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for matching If-Match, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("ETag"); got != `"2"` {
+		t.Fatalf("expected ETag \"2\" after update, got %q", got)
+	}
+
+	// A second writer holding the stale ETag gets a conflict
+	w = adminPatch(etag, `{"plan":"enterprise"}`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for stale If-Match, got %d", w.Code)
+	}
+	current, _ := s.GetUserByID(context.Background(), 1)
+	if current.Metadata["plan"] != "pro" {
+		t.Fatalf("stale update overwrote data: %v", current.Metadata)
+	}
+
+	// The store rejects stale versions directly
+	stale := *current
+	stale.Version--
+	if err := s.UpdateUser(context.Background(), &stale); !errors.Is(err, store.ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+}
+
+func TestAdminBatchOperations(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	for _, name := range []string{"batchadmin", "batchone", "batchtwo"} {
+		if _, err := s.CreateUser(ctx, &models.User{Username: name, Email: name + "@example.com", Password: "hash", Role: "user"}); err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+	}
+
+	asAdmin := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/users:batch", strings.NewReader(body))
+		return req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "1", Role: "admin"}))
+	}
+	decode := func(w *httptest.ResponseRecorder) batchResponse {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp batchResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode batch response: %v", err)
+		}
+		return resp
+	}
+
+	// Disable reports per-item outcomes, including the caller's own account
+	w := httptest.NewRecorder()
+	h.AdminBatchDisable(w, asAdmin(`{"ids":[1,2,2,99]}`))
+	resp := decode(w)
+	want := []string{batchStatusSkipped, batchStatusOK, batchStatusNotFound}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("expected %d results (duplicates dropped), got %+v", len(want), resp.Results)
+	}
+	for i, status := range want {
+		if resp.Results[i].Status != status {
+			t.Errorf("result %d: expected %s, got %+v", i, status, resp.Results[i])
+		}
+	}
+	if resp.Succeeded != 1 || resp.Failed != 2 {
+		t.Errorf("expected 1 succeeded / 2 failed, got %d / %d", resp.Succeeded, resp.Failed)
+	}
+	if u, _ := s.GetUserByID(ctx, 2); !u.Disabled {
+		t.Fatal("expected user 2 to be disabled")
+	}
+
+	// Disabled users are locked out of authenticated endpoints
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/profile", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "2", Role: "user"}))
+	w = httptest.NewRecorder()
+	h.Me(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for disabled user, got %d", w.Code)
+	}
+
+	// Role assignment validates the role and applies it
+	w = httptest.NewRecorder()
+	h.AdminBatchAssignRole(w, asAdmin(`{"ids":[3],"role":"superuser"}`))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid role, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.AdminBatchAssignRole(w, asAdmin(`{"ids":[3],"role":"moderator"}`))
+	if resp := decode(w); resp.Succeeded != 1 {
+		t.Fatalf("expected role assignment to succeed, got %+v", resp.Results)
+	}
+	if u, _ := s.GetUserByID(ctx, 3); u.Role != "moderator" {
+		t.Fatalf("expected role moderator, got %s", u.Role)
+	}
+
+	// Users already in the requested state are reported as unchanged, in
+	// dry runs too, rather than counted as succeeded
+	w = httptest.NewRecorder()
+	h.AdminBatchAssignRole(w, asAdmin(`{"ids":[3],"role":"moderator"}`))
+	if resp := decode(w); resp.Succeeded != 0 || resp.Unchanged != 1 || resp.Results[0].Status != batchStatusUnchanged {
+		t.Errorf("expected the role assignment to be a no-op, got %+v", resp)
+	}
+	dry := httptest.NewRequest(http.MethodPost, "/api/admin/users:batchDisable?dry_run=true", strings.NewReader(`{"ids":[2,3]}`))
+	w = httptest.NewRecorder()
+	h.AdminBatchDisable(w, dry.WithContext(context.WithValue(dry.Context(), "user", &auth.Claims{UserID: "1", Role: "admin"})))
+	resp = decode(w)
+	if resp.Succeeded != 1 || resp.Unchanged != 1 || resp.Failed != 0 || resp.Results[0].Status != batchStatusUnchanged || resp.Results[1].Status != batchStatusOK {
+		t.Errorf("expected a dry run to count only user 3 as changed, got %+v", resp)
+	}
+
+	// A dry run reports what would be deleted without deleting it
+	dry = httptest.NewRequest(http.MethodPost, "/api/admin/users:batchDelete?dry_run=true", strings.NewReader(`{"ids":[1,2,99]}`))
+	w = httptest.NewRecorder()
+	h.AdminBatchDelete(w, dry.WithContext(context.WithValue(dry.Context(), "user", &auth.Claims{UserID: "1", Role: "admin"})))
+	resp = decode(w)
+	if !resp.DryRun || resp.Succeeded != 1 || resp.Results[1].Status != batchStatusOK || resp.Results[2].Status != batchStatusNotFound {
+		t.Fatalf("unexpected dry run result %+v", resp)
+	}
+	if u, _ := s.GetUserByID(ctx, 2); u == nil {
+		t.Fatal("expected a dry run to leave user 2 in place")
+	}
+	if events, _, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: auditUserDelete}); len(events) != 0 {
+		t.Errorf("expected a dry run not to be audited, got %+v", events)
+	}
+
+	// Delete removes users
+	w = httptest.NewRecorder()
+	h.AdminBatchDelete(w, asAdmin(`{"ids":[2,3]}`))
+	if resp := decode(w); resp.Succeeded != 2 {
+		t.Fatalf("expected both deletes to succeed, got %+v", resp.Results)
+	}
+	if u, _ := s.GetUserByID(ctx, 3); u != nil {
+		t.Fatal("expected user 3 to be deleted")
+	}
+
+	// Oversized batches are rejected up front
+	ids := make([]string, maxBatchSize+1)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i + 1)
+	}
+	w = httptest.NewRecorder()
+	h.AdminBatchDelete(w, asAdmin(`{"ids":[`+strings.Join(ids, ",")+`]}`))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for oversized batch, got %d", w.Code)
+	}
+}
+
+func TestAdminAuditLog(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	for _, name := range []string{"auditadmin", "audited"} {
+		if _, err := s.CreateUser(ctx, &models.User{Username: name, Email: name + "@example.com", Password: "hash", Role: "user"}); err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+	}
+	adminClaims := &auth.Claims{UserID: "1", Role: "admin", RegisteredClaims: jwt.RegisteredClaims{ID: "admin-jti"}}
+	asAdmin := func(req *http.Request) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), "user", adminClaims))
+	}
+
+	// Admin metadata changes are diffed, with sensitive keys masked
+	req := httptest.NewRequest(http.MethodPatch, "/api/admin/users/2/metadata", strings.NewReader(`{"plan":"pro","api_token":"abc123"}`))
+	req.SetPathValue("id", "2")
+	req.Header.Set("If-Match", "*")
+	w := httptest.NewRecorder()
+	h.AdminUpdateUserMetadata(w, asAdmin(req))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for admin metadata update, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Role changes through batch endpoints are recorded too
+	w = httptest.NewRecorder()
+	h.AdminBatchAssignRole(w, asAdmin(httptest.NewRequest(http.MethodPost, "/api/admin/users:batchAssignRole", strings.NewReader(`{"ids":[2],"role":"moderator"}`))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for role assignment, got %d", w.Code)
+	}
+
+	// Users editing their own metadata are not audited as admin actions
+	req = httptest.NewRequest(http.MethodPatch, "/api/auth/profile/metadata", strings.NewReader(`{"locale":"de-DE"}`))
+	w = httptest.NewRecorder()
+	h.UpdateProfileMetadata(w, req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "2", Role: "user"})))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for profile metadata update, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.AdminListAudit(w, asAdmin(httptest.NewRequest(http.MethodGet, "/api/admin/audit?target_id=2", nil)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for audit query, got %d: %s", w.Code, w.Body.String())
+	}
+	var page struct {
+		Events []models.AuditEvent `json:"events"`
+		Total  int                 `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to decode audit response: %v", err)
+	}
+	if page.Total != 2 || len(page.Events) != 2 {
+		t.Fatalf("expected 2 audit events, got %d: %+v", page.Total, page.Events)
+	}
+
+	role, meta := page.Events[0], page.Events[1]
+	if role.Action != auditUserRoleAssign || role.ActorID != 1 || role.TokenID != "admin-jti" || len(role.Changes) != 1 ||
+		role.Changes[0] != (models.FieldChange{Field: "role", Before: "user", After: "moderator"}) {
+		t.Errorf("unexpected role audit event: %+v", role)
+	}
+	if meta.Action != auditUserMetadataUpdate || len(meta.Changes) != 2 {
+		t.Fatalf("unexpected metadata audit event: %+v", meta)
+	}
+	if c := meta.Changes[0]; c.Field != "metadata.api_token" || c.Before != nil || c.After != "[MASKED]" {
+		t.Errorf("expected masked api_token change, got %+v", c)
+	}
+	if c := meta.Changes[1]; c.Field != "metadata.plan" || c.After != "pro" {
+		t.Errorf("expected plan change, got %+v", c)
+	}
+
+	w = httptest.NewRecorder()
+	h.AdminListAudit(w, asAdmin(httptest.NewRequest(http.MethodGet, "/api/admin/audit?since=yesterday", nil)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid since, got %d", w.Code)
+	}
+}
+
+func TestAdminAuditRetention(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	now := time.Now()
+
+	w := httptest.NewRecorder()
+	h.AdminAuditRetention(w, httptest.NewRequest(http.MethodGet, "/api/admin/audit/retention", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"enabled":false`) || !strings.Contains(w.Body.String(), `"last_run":null`) {
+		t.Fatalf("expected retention to be reported off, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, at := range []time.Time{now.AddDate(0, 0, -100), now.AddDate(0, 0, -10)} {
+		if err := s.RecordAudit(ctx, &models.AuditEvent{Action: "user.login", TargetType: "user", CreatedAt: at}); err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+	}
+	h.AuditRetention = &audit.Retention{Store: s, MaxAge: 90 * 24 * time.Hour}
+	if _, err := h.AuditRetention.Run(ctx, now); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	h.AdminAuditRetention(w, httptest.NewRequest(http.MethodGet, "/api/admin/audit/retention", nil))
+	var status struct {
+		Enabled       bool      `json:"enabled"`
+		RetentionDays int       `json:"retention_days"`
+		Events        int       `json:"events"`
+		OldestEventAt time.Time `json:"oldest_event_at"`
+		LastRun       struct {
+			Succeeded bool `json:"succeeded"`
+			Pruned    int  `json:"pruned"`
+		} `json:"last_run"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode retention status: %v", err)
+	}
+	if !status.Enabled || status.RetentionDays != 90 || status.Events != 2 || !status.LastRun.Succeeded || status.LastRun.Pruned != 1 {
+		t.Errorf("unexpected retention status: %s", w.Body.String())
+	}
+	if !status.OldestEventAt.Equal(now.AddDate(0, 0, -10)) {
+		t.Errorf("oldest_event_at = %v, want the 10-day-old event", status.OldestEventAt)
+	}
+}
+
+func TestAdminUserTimeline(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	id, err := s.CreateUser(ctx, &models.User{Username: "timeline", Email: "tl@example.com", Password: "hash", Role: "user"})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	start := time.Now().UTC().Add(-time.Hour)
+	for _, tok := range []*models.RefreshToken{
+		{JTI: "root", UserID: id, IssuedAt: start, ExpiresAt: start.Add(24 * time.Hour)},
+		{JTI: "child", UserID: id, ParentJTI: "root", IssuedAt: start.Add(2 * time.Minute), ExpiresAt: start.Add(24 * time.Hour)},
+	} {
+		if err := s.SaveRefreshToken(ctx, tok); err != nil {
+			t.Fatalf("SaveRefreshToken: %v", err)
+		}
+	}
+	for _, e := range []*models.AuditEvent{
+		{ActorID: id, Action: auditUserLogin, TargetType: auditTargetUser, TargetID: id, CreatedAt: start},
+		{ActorID: id + 100, Action: auditUserDisable, TargetType: auditTargetUser, TargetID: id, CreatedAt: start.Add(3 * time.Minute)},
+		{ActorID: id + 100, Action: auditUserDisable, TargetType: auditTargetUser, TargetID: id + 1, CreatedAt: start.Add(3 * time.Minute)},
+	} {
+		if err := s.RecordAudit(ctx, e); err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/users/"+strconv.FormatInt(id, 10)+"/timeline?"+query, nil)
+		req.SetPathValue("id", strconv.FormatInt(id, 10))
+		w := httptest.NewRecorder()
+		h.AdminUserTimeline(w, req)
+		return w
+	}
+	var types []string
+	for query, pages := "limit=1", 0; ; pages++ {
+		if pages > 4 {
+			t.Fatal("timeline did not end")
+		}
+		w := get(query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for timeline, got %d: %s", w.Code, w.Body.String())
+		}
+		var page struct {
+			Entries    []timelineEntry `json:"entries"`
+			NextCursor string          `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Failed to decode timeline: %v", err)
+		}
+		for _, e := range page.Entries {
+			types = append(types, e.Type)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query = "limit=1&cursor=" + page.NextCursor
+	}
+	want := []string{timelineAudit, timelineTokenRefresh, timelineAudit, timelineSessionStart}
+	if !slices.Equal(types, want) {
+		t.Errorf("timeline = %v, want %v", types, want)
+	}
+
+	if w := get("cursor=not-a-cursor"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid cursor, got %d", w.Code)
+	}
+}
+
+func TestRefreshSessionExpiry(t *testing.T) {
+	h, s := setupTestHandlers()
+	if _, err := s.CreateUser(context.Background(), &models.User{Username: "sliding", Email: "s@example.com", Password: "hash", Role: "user"}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	refresh := func(token string) (*auth.Claims, int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.RefreshToken(w, httptest.NewRequest(http.MethodPost, "/api/auth/refresh", strings.NewReader(`{"refresh_token":"`+token+`"}`)))
+		if w.Code != http.StatusOK {
+			return nil, w.Code
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		claims, err := h.Auth.ParseToken(resp["refresh_token"].(string))
+		if err != nil {
+			t.Fatalf("Failed to parse rotated refresh token: %v", err)
+		}
+		return claims, w.Code
+	}
+
+	// A session that logged in 2 days ago with a 3-day token; each token
+	// rotates only once
+	now := time.Now()
+	authTime := now.Add(-48 * time.Hour)
+	session := func() string {
+		t.Helper()
+		token, err := h.Auth.GenerateRefreshToken("1", "user", authTime, authTime.Add(72*time.Hour))
+		if err != nil {
+			t.Fatalf("GenerateRefreshToken: %v", err)
+		}
+		return token
+	}
+
+	// Fixed mode keeps the expiry set at login
+	h.RefreshTokenTTL = 72 * time.Hour
+	claims, code := refresh(session())
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if got := claims.ExpiresAt.Time.Unix(); got != authTime.Add(72*time.Hour).Unix() {
+		t.Errorf("fixed mode changed expiry: got %v", claims.ExpiresAt.Time)
+	}
+	if claims.AuthTime == nil || claims.AuthTime.Unix() != authTime.Unix() {
+		t.Errorf("auth_time not carried across rotation: %v", claims.AuthTime)
+	}
+
+	// Sliding mode extends the expiry, capped at the maximum session lifetime
+	h.RefreshSliding = true
+	h.RefreshMaxLifetime = 4 * 24 * time.Hour
+	claims, code = refresh(session())
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if got := claims.ExpiresAt.Time.Unix(); got != authTime.Add(4*24*time.Hour).Unix() {
+		t.Errorf("sliding expiry not capped at max lifetime: got %v", claims.ExpiresAt.Time)
+	}
+
+	// Sessions older than the maximum lifetime must log in again
+	h.RefreshMaxLifetime = 24 * time.Hour
+	if _, code := refresh(session()); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for session past max lifetime, got %d", code)
+	}
+}
+
+func TestRefreshTokensRecorded(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	hash, _ := auth.HashPassword("password123")
+	if _, err := s.CreateUser(ctx, &models.User{Username: "tracked", Email: "t@example.com", Password: hash, Role: "user"}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.Login(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username":"tracked","password":"password123"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for login, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	first, err := h.Auth.ParseToken(resp["refresh_token"].(string))
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if first.ID == "" || first.NotBefore == nil {
+		t.Fatalf("expected jti and nbf on refresh token: %+v", first)
+	}
+
+	w = httptest.NewRecorder()
+	h.RefreshToken(w, httptest.NewRequest(http.MethodPost, "/api/auth/refresh", strings.NewReader(`{"refresh_token":"`+resp["refresh_token"].(string)+`"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for refresh, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/users/1/refresh-tokens", nil)
+	req.SetPathValue("id", "1")
+	w = httptest.NewRecorder()
+	h.AdminListRefreshTokens(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for refresh token listing, got %d", w.Code)
+	}
+	var list struct {
+		RefreshTokens []models.RefreshToken `json:"refresh_tokens"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.RefreshTokens) != 2 {
+		t.Fatalf("expected 2 recorded refresh tokens, got %+v", list.RefreshTokens)
+	}
+	rotated, err := s.GetRefreshToken(ctx, first.ID)
+	if err != nil || rotated == nil || rotated.UserID != 1 {
+		t.Fatalf("login refresh token not recorded: %+v, %v", rotated, err)
+	}
+	// Records hold token IDs only, never the tokens themselves
+	if b, _ := json.Marshal(list.RefreshTokens); strings.Contains(string(b), resp["refresh_token"].(string)) {
+		t.Errorf("refresh token material persisted: %s", b)
+	}
+	var child *models.RefreshToken
+	for i := range list.RefreshTokens {
+		if list.RefreshTokens[i].ParentJTI == first.ID {
+			child = &list.RefreshTokens[i]
+		}
+	}
+	if child == nil {
+		t.Errorf("rotated token not linked to its parent: %+v", list.RefreshTokens)
+	}
+}
+
+func TestRefreshReuseGrace(t *testing.T) {
+	h, s := setupTestHandlers()
+	if _, err := s.CreateUser(context.Background(), &models.User{Username: "retrier", Email: "r@example.com", Password: "hash", Role: "user"}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	session := func() string {
+		t.Helper()
+		token, err := h.Auth.GenerateRefreshToken("1", "user", time.Now(), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("GenerateRefreshToken: %v", err)
+		}
+		return token
+	}
+	refresh := func(token string) (string, int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.RefreshToken(w, httptest.NewRequest(http.MethodPost, "/api/auth/refresh", strings.NewReader(`{"refresh_token":"`+token+`"}`)))
+		if w.Code != http.StatusOK {
+			return "", w.Code
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp["refresh_token"].(string), w.Code
+	}
+
+	// Without a grace window a rotated token is refused
+	token := session()
+	if _, code := refresh(token); code != http.StatusOK {
+		t.Fatalf("expected 200 for refresh, got %d", code)
+	}
+	if _, code := refresh(token); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 reusing a rotated token, got %d", code)
+	}
+
+	// A retry within the window gets the same pair, once
+	h.RefreshReuseGrace = time.Minute
+	token = session()
+	first, code := refresh(token)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200 for refresh, got %d", code)
+	}
+	if retry, code := refresh(token); code != http.StatusOK || retry != first {
+		t.Errorf("expected a retry to return the pair already issued, got %d", code)
+	}
+	if _, code := refresh(token); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 once the pair was replayed, got %d", code)
+	}
+	if _, code := refresh(first); code != http.StatusOK {
+		t.Errorf("expected the replayed pair to refresh, got %d", code)
+	}
+
+	// A retry is checked like the first attempt: once its client is
+	// unregistered, the pair already issued is not replayed
+	h.Clients = clients.New(s)
+	h.Clients.Set(models.Client{ClientID: "mobile"})
+	token, _, err := h.Auth.IssueBoundRefreshToken("1", "user", auth.Grant{ClientID: "mobile"}, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("IssueBoundRefreshToken: %v", err)
+	}
+	if _, code := refresh(token); code != http.StatusOK {
+		t.Fatalf("expected 200 for refresh, got %d", code)
+	}
+	h.Clients.Remove("mobile")
+	if _, code := refresh(token); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 replaying a pair for an unregistered client, got %d", code)
+	}
+
+	// Not after the window has passed
+	h.RefreshReuseGrace = time.Nanosecond
+	token = session()
+	if _, code := refresh(token); code != http.StatusOK {
+		t.Fatalf("expected 200 for refresh, got %d", code)
+	}
+	time.Sleep(time.Millisecond)
+	if _, code := refresh(token); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 after the grace window, got %d", code)
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	if _, err := s.CreateUser(ctx, &models.User{Username: "idler", Email: "i@example.com", Password: "hash", Role: "user"}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	h.SessionIdleTimeout = 30 * time.Minute
+	now := time.Now()
+	token, claims, err := h.Auth.IssueRefreshToken("1", "user", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("IssueRefreshToken: %v", err)
+	}
+	// Recorded as issued 40 minutes ago and never used since
+	if err := s.SaveRefreshToken(ctx, &models.RefreshToken{JTI: claims.ID, UserID: 1, IssuedAt: now.Add(-40 * time.Minute), ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("SaveRefreshToken: %v", err)
+	}
+	refresh := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.RefreshToken(w, httptest.NewRequest(http.MethodPost, "/api/auth/refresh", strings.NewReader(`{"refresh_token":"`+token+`"}`)))
+		return w
+	}
+	if w := refresh(); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "inactivity") {
+		t.Fatalf("expected an idle session to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Using an access token of the session keeps it alive
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/profile", nil)
+	h.TouchSession(req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "1", SessionID: claims.ID})))
+	if rec, _ := s.GetRefreshToken(ctx, claims.ID); rec.LastUsedAt.IsZero() {
+		t.Fatal("session use not recorded")
+	}
+	w := refresh()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected a recently used session to refresh, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The new access token is tied to the rotated refresh token
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	access, _ := h.Auth.ParseToken(resp["access_token"].(string))
+	rotated, _ := h.Auth.ParseToken(resp["refresh_token"].(string))
+	if access == nil || rotated == nil || access.SessionID == "" || access.SessionID != rotated.ID {
+		t.Errorf("access token sid %+v does not name the rotated refresh token %+v", access, rotated)
+	}
+
+	// Uses are written at most once a minute per session
+	var uses sessionUses
+	if !uses.due("a", now) || uses.due("a", now.Add(30*time.Second)) || !uses.due("b", now) {
+		t.Error("expected the first use of each session to be due, and not a second within a minute")
+	}
+	if !uses.due("a", now.Add(61*time.Second)) {
+		t.Error("expected a use a minute later to be due")
+	}
+}
+
+func TestDPoPBinding(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	hash, _ := auth.HashPassword("password123")
+	if _, err := s.CreateUser(ctx, &models.User{Username: "holder", Email: "k@example.com", Password: hash, Role: "user"}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	h.DPoP = &dpop.Verifier{Nonces: s}
+	h.Auth.SetProofVerifier(h.DPoP)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jkt, _ := dpop.Thumbprint(key.Public())
+	post := func(handler http.HandlerFunc, path, body string, signer crypto.Signer) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if signer != nil {
+			proof, _ := dpop.NewProof(signer, http.MethodPost, "http://example.com"+path, "")
+			req.Header.Set(dpop.Header, proof)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	code, resp := post(h.Login, "/api/auth/login", `{"username":"holder","password":"password123"}`, key)
+	if code != http.StatusOK || resp["token_type"] != "DPoP" {
+		t.Fatalf("expected a DPoP login, got %d: %v", code, resp)
+	}
+	access, _ := h.Auth.ParseToken(resp["access_token"].(string))
+	refresh, _ := h.Auth.ParseToken(resp["refresh_token"].(string))
+	if access.BoundKey() != jkt || refresh.BoundKey() != jkt {
+		t.Fatalf("expected both tokens bound to %s, got %+v and %+v", jkt, access.Confirmation, refresh.Confirmation)
+	}
+
+	// The access token needs the DPoP scheme and a fresh proof
+	protected := middleware.WithAuth(h.Auth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	use := func(scheme string, proof bool) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/profile", nil)
+		req.Header.Set("Authorization", scheme+" "+resp["access_token"].(string))
+		if proof {
+			p, _ := dpop.NewProof(key, http.MethodGet, "http://example.com/api/auth/profile", resp["access_token"].(string))
+			req.Header.Set(dpop.Header, p)
+		}
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := use("Bearer", true); code != http.StatusUnauthorized {
+		t.Errorf("expected a bound token sent as a bearer token to be refused, got %d", code)
+	}
+	if code := use("DPoP", false); code != http.StatusUnauthorized {
+		t.Errorf("expected a bound token without a proof to be refused, got %d", code)
+	}
+	if code := use("DPoP", true); code != http.StatusOK {
+		t.Errorf("expected a bound token with a proof to be accepted, got %d", code)
+	}
+
+	// Refreshing needs a proof by the same key, and stays bound
+	body := `{"refresh_token":"` + resp["refresh_token"].(string) + `"}`
+	if code, _ := post(h.RefreshToken, "/api/auth/refresh", body, nil); code != http.StatusBadRequest {
+		t.Errorf("expected a refresh without a proof to be refused, got %d", code)
+	}
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if code, _ := post(h.RefreshToken, "/api/auth/refresh", body, other); code != http.StatusBadRequest {
+		t.Errorf("expected a refresh with another key to be refused, got %d", code)
+	}
+	code, resp = post(h.RefreshToken, "/api/auth/refresh", body, key)
+	if code != http.StatusOK || resp["token_type"] != "DPoP" {
+		t.Fatalf("expected a bound refresh, got %d: %v", code, resp)
+	}
+	if access, _ := h.Auth.ParseToken(resp["access_token"].(string)); access.BoundKey() != jkt {
+		t.Errorf("expected the refreshed access token to stay bound, got %+v", access.Confirmation)
+	}
+
+	// Without a proof, login issues bearer tokens as before
+	if code, resp := post(h.Login, "/api/auth/login", `{"username":"holder","password":"password123"}`, nil); code != http.StatusOK || resp["token_type"] != "Bearer" {
+		t.Errorf("expected a bearer login, got %d: %v", code, resp)
+	}
+}
+
+func TestValidateTokenBatch(t *testing.T) {
+	h, _ := setupTestHandlers()
+	access, _ := h.Auth.GenerateToken("1", "user", time.Hour)
+	expired, _ := h.Auth.GenerateToken("2", "user", time.Nanosecond)
+	sso, _ := h.Auth.GenerateTokenWithType("3", "user", auth.TokenTypeSSO, time.Hour)
+	resource, _, _ := h.Auth.IssueResourceToken("4", "files/report.pdf", time.Hour)
+	time.Sleep(time.Millisecond)
+
+	validate := func(body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.ValidateTokenBatch(w, httptest.NewRequest(http.MethodPost, "/api/auth/validate-batch", strings.NewReader(body)))
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	code, resp := validate(`{"tokens":["` + access + `","` + expired + `","` + sso + `","` + resource + `","not-a-token"]}`)
+	if code != http.StatusOK || resp["valid"] != float64(1) || resp["invalid"] != float64(4) {
+		t.Fatalf("unexpected response %d: %v", code, resp)
+	}
+	results := resp["results"].([]interface{})
+	first := results[0].(map[string]interface{})
+	if first["valid"] != true || first["claims"].(map[string]interface{})["uid"] != "1" {
+		t.Errorf("expected the access token to be valid with its claims, got %v", first)
+	}
+	for i, reason := range []string{auth.ReasonExpired, auth.ReasonWrongType, auth.ReasonWrongType, auth.ReasonMalformed} {
+		if got := results[i+1].(map[string]interface{}); got["valid"] != false || got["reason"] != reason || got["claims"] != nil {
+			t.Errorf("result %d: expected %s, got %v", i+1, reason, got)
+		}
+	}
+
+	if code, _ := validate(`{"tokens":[]}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for no tokens, got %d", code)
+	}
+	tokens := strings.Repeat(`"x",`, maxValidateBatch) + `"x"`
+	if code, _ := validate(`{"tokens":[` + tokens + `]}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for too many tokens, got %d", code)
+	}
+}
+
+func TestResourceTokens(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	if _, err := s.CreateUser(ctx, &models.User{Username: "downloader", Email: "d@example.com", Password: "hash", Role: "user"}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	mint := func(body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/resource-tokens", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "1", Role: "user"}))
+		w := httptest.NewRecorder()
+		h.CreateResourceToken(w, req)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	introspect := func(token, resource string) map[string]interface{} {
+		body, _ := json.Marshal(resourceIntrospectRequest{Token: token, Resource: resource})
+		w := httptest.NewRecorder()
+		h.IntrospectResourceToken(w, httptest.NewRequest(http.MethodPost, "/api/auth/resource-tokens/introspect", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("introspect: got %d: %s", w.Code, w.Body.String())
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	code, resp := mint(`{"resource":"files/report.pdf","ttl_seconds":60}`)
+	if code != http.StatusCreated || resp["resource"] != "files/report.pdf" {
+		t.Fatalf("mint: got %d: %v", code, resp)
+	}
+	token := resp["token"].(string)
+	claims, err := h.Auth.ParseToken(token)
+	if err != nil || claims.TokenType != auth.TokenTypeResource || claims.ExpiresAt.Sub(time.Now()) > time.Minute {
+		t.Fatalf("unexpected resource token claims %+v, %v", claims, err)
+	}
+
+	// The wrong resource leaves the token unused
+	if got := introspect(token, "files/other.pdf"); got["active"] != false || got["reason"] != reasonResourceMismatch {
+		t.Errorf("expected a resource mismatch, got %v", got)
+	}
+	got := introspect(token, "files/report.pdf")
+	if got["active"] != true || got["sub"] != "1" || got["jti"] != resp["jti"] {
+		t.Fatalf("expected the token to be active, got %v", got)
+	}
+	if got := introspect(token, "files/report.pdf"); got["active"] != false || got["reason"] != reasonAlreadyUsed {
+		t.Errorf("expected a second use to be refused, got %v", got)
+	}
+
+	access, _ := h.Auth.GenerateToken("1", "user", time.Hour)
+	if got := introspect(access, "files/report.pdf"); got["active"] != false || got["reason"] != auth.ReasonWrongType {
+		t.Errorf("expected an access token to be refused, got %v", got)
+	}
+	orphan, _, _ := h.Auth.IssueResourceToken("99", "files/report.pdf", time.Minute)
+	if got := introspect(orphan, "files/report.pdf"); got["active"] != false || got["reason"] != auth.ReasonInvalid {
+		t.Errorf("expected a token of a missing user to be refused, got %v", got)
+	}
+
+	for _, body := range []string{`{}`, `{"resource":"x","ttl_seconds":3601}`, `{"resource":"x","ttl_seconds":-1}`, `{"resource":"` + strings.Repeat("x", maxResourceLength+1) + `"}`} {
+		if code, _ := mint(body); code != http.StatusBadRequest {
+			t.Errorf("mint %.40s: expected 400, got %d", body, code)
+		}
+	}
+}
+
+func TestLoginHistory(t *testing.T) {
+	h, s := setupTestHandlers()
+	h.GeoCountryHeader = "CF-IPCountry"
+	hash, _ := auth.HashPassword("password123")
+	if _, err := s.CreateUser(context.Background(), &models.User{Username: "historian", Email: "h@example.com", Password: hash, Role: "user"}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	login := func(username, password string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username":"`+username+`","password":"`+password+`"}`))
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		req.Header.Set("CF-IPCountry", "de")
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4) Gecko/20100101 Firefox/125.0")
+		w := httptest.NewRecorder()
+		h.Login(w, req)
+		return w.Code
+	}
+	if code := login("historian", "wrong-password"); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", code)
+	}
+	if code := login("nobody", "password123"); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", code)
+	}
+	if code := login("historian", "password123"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/login-history", nil)
+	w := httptest.NewRecorder()
+	h.LoginHistory(w, req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "1", Role: "user"})))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for login history, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Logins []loginHistoryEntry `json:"logins"`
+		Total  int                 `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode login history: %v", err)
+	}
+	if resp.Total != 2 || len(resp.Logins) != 2 || !resp.Logins[0].Success || resp.Logins[1].Success {
+		t.Fatalf("expected success then failure, got %+v", resp)
+	}
+	if e := resp.Logins[0]; e.IP != "203.0.113.7" || e.Country != "DE" || e.Device != "Firefox on macOS" {
+		t.Errorf("unexpected login entry: %+v", e)
+	}
+
+	// Analytics aggregates these actions from the audit log
+	if analytics.LoginAction != auditUserLogin || analytics.LoginFailedAction != auditUserLoginFailed {
+		t.Error("analytics counts different login actions than the handlers record")
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	h, s := setupTestHandlers()
+	mailer := make(fakeMailer, 4)
+	h.Mailer = mailer
+	hash, _ := auth.HashPassword("password123")
+	if _, err := s.CreateUser(context.Background(), &models.User{Username: "prefers", Email: "p@example.com", Password: hash, Role: "user"}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	serve := func(handler http.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/auth/preferences", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "1", Role: "user"})))
+		return w
+	}
+	if w := serve(h.GetPreferences, http.MethodGet, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"login_alerts":false`) {
+		t.Fatalf("expected default preferences, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, body := range []string{
+		`{"login_alert":true}`,
+		`{"locale":"english"}`,
+		`{"session_timeout_minutes":3}`,
+		`{"session_timeout_minutes":20160}`,
+	} {
+		if w := serve(h.UpdatePreferences, http.MethodPut, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	w := serve(h.UpdatePreferences, http.MethodPut, `{"login_alerts":true,"session_timeout_minutes":30,"locale":"de-AT"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	login := func(ip string) map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username":"prefers","password":"password123"}`))
+		req.Header.Set("X-Forwarded-For", ip)
+		w := httptest.NewRecorder()
+		h.Login(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	// The session timeout shortens the refresh token
+	resp := login("203.0.113.7")
+	claims, err := h.Auth.ParseToken(resp["refresh_token"].(string))
+	if err != nil {
+		t.Fatalf("Failed to parse refresh token: %v", err)
+	}
+	if left := time.Until(claims.ExpiresAt.Time); left > 30*time.Minute || left < 29*time.Minute {
+		t.Errorf("expected the refresh token to expire in 30 minutes, got %v", left)
+	}
+
+	// Only a login from a new address is alerted about, in the user's locale
+	login("203.0.113.7")
+	login("198.51.100.4")
+	select {
+	case m := <-mailer:
+		if m.To != "p@example.com" || m.Language != "de-AT" || !strings.Contains(m.Body, "198.51.100.4") {
+			t.Errorf("unexpected login alert: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a login alert")
+	}
+	select {
+	case m := <-mailer:
+		t.Errorf("expected a single login alert, also got %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// fakeMailer records sent messages on a channel.
+type fakeMailer chan mail.Message
+
+func (f fakeMailer) Send(ctx context.Context, m mail.Message) error {
+	f <- m
+	return nil
+}
+
+func TestEnumerationProtection(t *testing.T) {
+	h, s := setupTestHandlers()
+	mailer := make(fakeMailer, 4)
+	h.Mailer = mailer
+	h.EnumerationProtection = true
+	defer func(d time.Duration) { enumerationResponseTime = d }(enumerationResponseTime)
+	enumerationResponseTime = 50 * time.Millisecond
+
+	register := func(body string) *httptest.ResponseRecorder {
+		start := time.Now()
+		w := httptest.NewRecorder()
+		h.Register(w, httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(body)))
+		if elapsed := time.Since(start); w.Code == http.StatusAccepted && elapsed < enumerationResponseTime {
+			t.Errorf("response took %s, want at least %s", elapsed, enumerationResponseTime)
+		}
+		return w
+	}
+	expectEmail := func(to, template string) {
+		t.Helper()
+		select {
+		case m := <-mailer:
+			if m.To != to || !strings.Contains(m.Body, template) {
+				t.Errorf("unexpected email to %s: %s", m.To, m.Body)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expected an email to %s", to)
+		}
+	}
+
+	// Success and every kind of conflict look the same
+	created := register(`{"username":"alice","email":"alice@example.com","password":"SecurePass123!"}`)
+	if created.Code != http.StatusAccepted || strings.Contains(created.Body.String(), "recovery_codes") {
+		t.Fatalf("unexpected response %d: %s", created.Code, created.Body.String())
+	}
+	user, _ := s.GetUserByUsername(context.Background(), "alice")
+	if user == nil {
+		t.Fatal("expected the account to be created")
+	}
+	takenEmail := register(`{"username":"alice2","email":"Alice@example.com","password":"SecurePass123!"}`)
+	expectEmail("alice@example.com", "already belongs to the account alice")
+	takenName := register(`{"username":"alice","email":"other@example.com","password":"SecurePass123!"}`)
+	expectEmail("other@example.com", "the username alice is already taken")
+	for _, w := range []*httptest.ResponseRecorder{takenEmail, takenName} {
+		if w.Code != created.Code || w.Body.String() != created.Body.String() || w.Header().Get("Cache-Control") != created.Header().Get("Cache-Control") {
+			t.Errorf("conflict distinguishable from success: %d %s", w.Code, w.Body.String())
+		}
+	}
+	if u, _ := s.GetUserByUsername(context.Background(), "alice2"); u != nil {
+		t.Error("expected no account for a taken address")
+	}
+
+	// Validation errors do not depend on other accounts
+	if w := register(`{"username":"bob","email":"bob@example.com","password":"weak"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a weak password, got %d", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	h.UsernameAvailable(w, httptest.NewRequest(http.MethodGet, "/api/auth/username-available?u=alice", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("expected username checks to be disabled, got %d", w.Code)
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	h, _ := setupTestHandlers()
+	h.Register(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(`{"username":"signer","email":"signer@example.com","password":"SecurePass123!"}`)))
+	login := func() map[string]interface{} {
+		w := httptest.NewRecorder()
+		h.Login(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username":"signer","password":"SecurePass123!"}`)))
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	if resp := login(); resp["signing_key"] != nil {
+		t.Errorf("expected no signing key with request signing off, got %v", resp["signing_key"])
+	}
+
+	h.RequestSigning = true
+	resp := login()
+	claims, err := h.Auth.ParseToken(resp["access_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp["signing_key"] != h.Auth.SigningKey(claims.ID) {
+		t.Errorf("expected the signing key for the access token, got %v", resp["signing_key"])
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	h, _ := setupTestHandlers()
+	mailer := make(fakeMailer, 4)
+	h.Mailer = mailer
+
+	w := httptest.NewRecorder()
+	h.Register(w, httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(`{"username":"rescued","email":"rescued@example.com","password":"SecurePass123!"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var reg struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &reg)
+	if len(reg.RecoveryCodes) != auth.RecoveryCodeCount {
+		t.Fatalf("expected %d recovery codes at registration, got %v", auth.RecoveryCodeCount, reg.RecoveryCodes)
+	}
+
+	login := func(code string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.Login(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username":"rescued","recovery_code":"`+code+`"}`)))
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	code, resp := login(strings.ToUpper(reg.RecoveryCodes[0]))
+	if code != http.StatusOK || resp["access_token"] == nil {
+		t.Fatalf("expected login with recovery code, got %d: %v", code, resp)
+	}
+	if resp["recovery_codes_remaining"] != float64(auth.RecoveryCodeCount-1) || resp["regenerate_recovery_codes"] != true {
+		t.Errorf("expected regeneration prompt, got %v", resp)
+	}
+	select {
+	case m := <-mailer:
+		if m.To != "rescued@example.com" || !strings.Contains(m.Body, "recovery code") {
+			t.Errorf("unexpected notification: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a notification email")
+	}
+	if code, _ := login(reg.RecoveryCodes[0]); code != http.StatusUnauthorized {
+		t.Fatalf("expected used code to be rejected, got %d", code)
+	}
+
+	asUser := func(req *http.Request) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "1", Role: "user"}))
+	}
+	w = httptest.NewRecorder()
+	h.RegenerateRecoveryCodes(w, asUser(httptest.NewRequest(http.MethodPost, "/api/auth/recovery-codes", strings.NewReader(`{"password":"wrong"}`))))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the password, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.RegenerateRecoveryCodes(w, asUser(httptest.NewRequest(http.MethodPost, "/api/auth/recovery-codes?format=txt", strings.NewReader(`{"password":"SecurePass123!"}`))))
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("expected a text download, got %d %v", w.Code, w.Header())
+	}
+	if code, _ := login(reg.RecoveryCodes[1]); code != http.StatusUnauthorized {
+		t.Errorf("expected old codes to stop working after regeneration, got %d", code)
+	}
+
+	w = httptest.NewRecorder()
+	h.RecoveryCodeStatus(w, asUser(httptest.NewRequest(http.MethodGet, "/api/auth/recovery-codes", nil)))
+	if !strings.Contains(w.Body.String(), `"remaining":10`) {
+		t.Errorf("unexpected status: %s", w.Body.String())
+	}
+}
+
+func TestEmailChange(t *testing.T) {
+	h, s := setupTestHandlers()
+	mailer := make(fakeMailer, 4)
+	h.Mailer = mailer
+	h.PublicURL = "https://auth.example.com/"
+	ctx := context.Background()
+	hash, _ := auth.HashPassword("SecurePass123!")
+	id, _ := s.CreateUser(ctx, &models.User{Username: "mover", Email: "old@example.com", Password: hash, Role: "user"})
+	s.SaveRefreshToken(ctx, &models.RefreshToken{JTI: "session-1", UserID: id, ExpiresAt: time.Now().Add(time.Hour)})
+
+	asUser := func(req *http.Request) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: strconv.FormatInt(id, 10), Role: "user"}))
+	}
+	// linkToken waits for an email to the given address and returns the
+	// token from the link it contains.
+	linkToken := func(to string) string {
+		t.Helper()
+		select {
+		case m := <-mailer:
+			if m.To != to {
+				t.Fatalf("expected mail to %s, got %+v", to, m)
+			}
+			_, token, ok := strings.Cut(m.Body, "?token=")
+			if !ok || !strings.Contains(m.Body, "https://auth.example.com/api/auth/email/") {
+				t.Fatalf("expected a link in %q", m.Body)
+			}
+			return strings.Fields(token)[0]
+		case <-time.After(time.Second):
+			t.Fatalf("expected mail to %s", to)
+		}
+		return ""
+	}
+
+	w := httptest.NewRecorder()
+	h.ChangeEmail(w, asUser(httptest.NewRequest(http.MethodPost, "/api/auth/email", strings.NewReader(`{"email":"new@example.com","password":"wrong"}`))))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the password, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ChangeEmail(w, asUser(httptest.NewRequest(http.MethodPost, "/api/auth/email", strings.NewReader(`{"email":"new@example.com","password":"SecurePass123!"}`))))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	confirm := linkToken("new@example.com")
+
+	// Until confirmed, the profile shows the pending address
+	w = httptest.NewRecorder()
+	h.Me(w, asUser(httptest.NewRequest(http.MethodGet, "/api/auth/profile", nil)))
+	var profile models.User
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	if profile.Email != "old@example.com" || profile.PendingEmail == nil || profile.PendingEmail.Email != "new@example.com" {
+		t.Fatalf("unexpected profile: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ConfirmEmailChange(w, httptest.NewRequest(http.MethodGet, "/api/auth/email/confirm?token=bogus", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown token, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ConfirmEmailChange(w, httptest.NewRequest(http.MethodGet, "/api/auth/email/confirm?token="+confirm, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if u, _ := s.GetUserByID(ctx, id); u.Email != "new@example.com" {
+		t.Fatalf("expected email to change, got %s", u.Email)
+	}
+	revert := linkToken("old@example.com")
+	w = httptest.NewRecorder()
+	h.ConfirmEmailChange(w, httptest.NewRequest(http.MethodGet, "/api/auth/email/confirm?token="+confirm, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected a confirm link to work once, got %d", w.Code)
+	}
+
+	// Another change is refused while the old address can still revert
+	w = httptest.NewRecorder()
+	h.ChangeEmail(w, asUser(httptest.NewRequest(http.MethodPost, "/api/auth/email", strings.NewReader(`{"email":"third@example.com","password":"SecurePass123!"}`))))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 during the revert window, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.RevertEmailChange(w, httptest.NewRequest(http.MethodPost, "/api/auth/email/revert", strings.NewReader(`{"token":"`+revert+`"}`)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"sessions_revoked":1`) {
+		t.Fatalf("expected revert to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if u, _ := s.GetUserByID(ctx, id); u.Email != "old@example.com" {
+		t.Errorf("expected old email to be restored, got %s", u.Email)
+	}
+	if revoked, _ := s.ListRevokedTokens(ctx, time.Time{}); len(revoked) != 1 || revoked[0].JTI != "session-1" {
+		t.Errorf("expected the session to be revoked, got %+v", revoked)
+	}
+	events, _, _ := s.ListAuditEvents(ctx, store.AuditFilter{TargetID: id})
+	if len(events) != 2 || events[0].Action != auditUserEmailRevert || events[1].Action != auditUserEmailChange {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+	// The addresses themselves are masked in the audit log
+	if c := events[1].Changes; len(c) == 0 || c[0] != (models.FieldChange{Field: "email", Before: "[MASKED]", After: "[MASKED]"}) {
+		t.Errorf("expected a masked email change, got %+v", c)
+	}
+}
+
+// fakeSMS records sent text messages on a channel.
+type fakeSMS chan sms.Message
+
+func (f fakeSMS) Send(ctx context.Context, m sms.Message) error {
+	f <- m
+	return nil
+}
+
+func TestPhoneVerificationAndSMSOTP(t *testing.T) {
+	h, s := setupTestHandlers()
+	texts := make(fakeSMS, 4)
+	h.SMS = texts
+	ctx := context.Background()
+	hash, _ := auth.HashPassword("SecurePass123!")
+	id, _ := s.CreateUser(ctx, &models.User{Username: "texter", Email: "texter@example.com", Password: hash, Role: "user"})
+
+	asUser := func(req *http.Request) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: strconv.FormatInt(id, 10), Role: "user"}))
+	}
+	// code returns the one-time code from the next text to phone.
+	code := func(phone string) string {
+		t.Helper()
+		select {
+		case m := <-texts:
+			if m.To != phone {
+				t.Fatalf("expected a text to %s, got %+v", phone, m)
+			}
+			for _, f := range strings.Fields(m.Body) {
+				if f = strings.TrimSuffix(f, "."); len(f) == auth.OTPDigits && strings.Trim(f, "0123456789") == "" {
+					return f
+				}
+			}
+			t.Fatalf("no code in %q", m.Body)
+		default:
+			t.Fatalf("expected a text to %s", phone)
+		}
+		return ""
+	}
+	call := func(handler http.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, asUser(httptest.NewRequest(method, "/", strings.NewReader(body))))
+		return w
+	}
+
+	if w := call(h.StartPhoneVerification, http.MethodPost, `{"phone":"555-0100"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a non-E.164 number, got %d", w.Code)
+	}
+	if w := call(h.StartPhoneVerification, http.MethodPost, `{"phone":"+14155550100"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	verify := code("+14155550100")
+	if w := call(h.StartPhoneVerification, http.MethodPost, `{"phone":"+14155550100"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected an immediate resend to be refused, got %d", w.Code)
+	}
+	if w := call(h.SetSMSOTP, http.MethodPost, `{"enabled":true,"password":"SecurePass123!"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected SMS OTP to need a verified phone, got %d", w.Code)
+	}
+	wrong := "000000"
+	if verify == wrong {
+		wrong = "111111"
+	}
+	if w := call(h.VerifyPhone, http.MethodPost, `{"code":"`+wrong+`"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a wrong code to fail, got %d", w.Code)
+	}
+	if w := call(h.VerifyPhone, http.MethodPost, `{"code":"`+verify+`"}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"phone":"+14155550100"`) {
+		t.Fatalf("expected the phone to be verified, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(h.SetSMSOTP, http.MethodPost, `{"enabled":true,"password":"SecurePass123!"}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"sms_otp":true`) {
+		t.Fatalf("expected SMS OTP to turn on, got %d: %s", w.Code, w.Body.String())
+	}
+
+	login := func(body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.Login(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body)))
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	status, resp := login(`{"username":"texter","password":"SecurePass123!"}`)
+	if status != http.StatusUnauthorized || resp["otp_required"] != true || resp["access_token"] != nil {
+		t.Fatalf("expected a code to be required, got %d: %v", status, resp)
+	}
+	otp := code("+14155550100")
+	if status, _ := login(`{"username":"texter","password":"wrong","otp_code":"` + otp + `"}`); status != http.StatusUnauthorized {
+		t.Fatalf("expected the password to still be required, got %d", status)
+	}
+	if status, resp := login(`{"username":"texter","password":"SecurePass123!","otp_code":"` + otp + `"}`); status != http.StatusOK || resp["access_token"] == nil {
+		t.Fatalf("expected login with the code, got %d: %v", status, resp)
+	}
+	if status, _ := login(`{"username":"texter","password":"SecurePass123!","otp_code":"` + otp + `"}`); status != http.StatusUnauthorized {
+		t.Errorf("expected a code to work once, got %d", status)
+	}
+
+	// Attempts are limited: after otpMaxAttempts wrong guesses the code is gone
+	s.DeleteOTPChallenge(ctx, id, models.OTPPurposeLogin)
+	login(`{"username":"texter","password":"SecurePass123!"}`)
+	otp = code("+14155550100")
+	for i := 0; i < otpMaxAttempts; i++ {
+		login(`{"username":"texter","password":"SecurePass123!","otp_code":"` + wrong + `"}`)
+	}
+	if status, _ := login(`{"username":"texter","password":"SecurePass123!","otp_code":"` + otp + `"}`); status != http.StatusUnauthorized {
+		t.Errorf("expected the code to be discarded after too many guesses, got %d", status)
+	}
+
+	if w := call(h.RemovePhone, http.MethodDelete, `{"password":"SecurePass123!"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the phone to be removed, got %d: %s", w.Code, w.Body.String())
+	}
+	if u, _ := s.GetUserByID(ctx, id); u.Phone != "" || u.SMSOTP {
+		t.Errorf("expected phone and SMS OTP to be cleared, got %+v", u)
+	}
+	if status, _ := login(`{"username":"texter","password":"SecurePass123!"}`); status != http.StatusOK {
+		t.Errorf("expected a plain password login again, got %d", status)
+	}
+	events, _, _ := s.ListAuditEvents(ctx, store.AuditFilter{TargetID: id})
+	actions := map[string]bool{}
+	for _, e := range events {
+		actions[e.Action] = true
+	}
+	if !actions[auditUserPhoneVerify] || !actions[auditUserSMSOTP] || !actions[auditUserPhoneRemove] {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+}
+
+func TestPhoneLogin(t *testing.T) {
+	h, s := setupTestHandlers()
+	texts := make(fakeSMS, 4)
+	h.SMS = texts
+	register := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.Register(w, httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(body)))
+		return w
+	}
+	login := func(body string) int {
+		w := httptest.NewRecorder()
+		h.Login(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if w := register(`{"username":"caller","email":"caller@example.com","password":"SecurePass123!","phone":"+14155550100"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected phone registration to be off by default, got %d", w.Code)
+	}
+	if code := login(`{"phone":"+14155550100","password":"SecurePass123!"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected phone login to be off by default, got %d", code)
+	}
+
+	h.PhoneLogin = true
+	w := register(`{"username":"caller","email":"caller@example.com","password":"SecurePass123!","phone":"+14155550100"}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"phone_verification"`) {
+		t.Fatalf("expected 201 with a pending verification, got %d: %s", w.Code, w.Body.String())
+	}
+	var m sms.Message
+	select {
+	case m = <-texts:
+	default:
+		t.Fatal("expected a verification text")
+	}
+	if code := login(`{"phone":"+14155550100","password":"SecurePass123!"}`); code != http.StatusUnauthorized {
+		t.Fatalf("expected an unverified number not to sign in, got %d", code)
+	}
+
+	user, _ := s.GetUserByUsername(context.Background(), "caller")
+	asUser := func(req *http.Request) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: strconv.FormatInt(user.ID, 10), Role: "user"}))
+	}
+	verify := strings.TrimSuffix(strings.Fields(m.Body)[4], ".")
+	w = httptest.NewRecorder()
+	h.VerifyPhone(w, asUser(httptest.NewRequest(http.MethodPost, "/api/auth/phone/verify", strings.NewReader(`{"code":"`+verify+`"}`))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the number to be verified, got %d: %s", w.Code, w.Body.String())
+	}
+	if code := login(`{"phone":"+14155550100","password":"SecurePass123!"}`); code != http.StatusOK {
+		t.Fatalf("expected login by phone, got %d", code)
+	}
+	if code := login(`{"phone":"+14155550100","password":"wrong"}`); code != http.StatusUnauthorized {
+		t.Errorf("expected a wrong password to fail, got %d", code)
+	}
+
+	if w := register(`{"username":"copycat","email":"copycat@example.com","password":"SecurePass123!","phone":"+14155550100"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected a verified number to be taken, got %d", w.Code)
+	}
+	hash, _ := auth.HashPassword("SecurePass123!")
+	otherID, _ := s.CreateUser(context.Background(), &models.User{Username: "other", Email: "other@example.com", Password: hash, Role: "user"})
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/phone", strings.NewReader(`{"phone":"+14155550100"}`))
+	h.StartPhoneVerification(w, req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: strconv.FormatInt(otherID, 10), Role: "user"})))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected another account's number to be refused, got %d", w.Code)
+	}
+}
+
+func TestMagicLink(t *testing.T) {
+	h, s := setupTestHandlers()
+	mailer := make(fakeMailer, 4)
+	h.Mailer = mailer
+	h.PublicURL = "https://auth.example.com"
+	ctx := context.Background()
+	hash, _ := auth.HashPassword("SecurePass123!")
+	id, _ := s.CreateUser(ctx, &models.User{Username: "linker", Email: "linker@example.com", Password: hash, Role: "user"})
+
+	request := func(email string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.RequestMagicLink(w, httptest.NewRequest(http.MethodPost, "/api/auth/magic-link", strings.NewReader(`{"email":"`+email+`"}`)))
+		return w
+	}
+	verify := func(token string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/magic-link/verify?token="+token, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		h.VerifyMagicLink(w, req)
+		return w
+	}
+	linkToken := func() string {
+		t.Helper()
+		select {
+		case m := <-mailer:
+			_, token, ok := strings.Cut(m.Body, "/api/auth/magic-link/verify?token=")
+			if m.To != "linker@example.com" || !ok {
+				t.Fatalf("unexpected mail %+v", m)
+			}
+			return strings.Fields(token)[0]
+		case <-time.After(time.Second):
+			t.Fatal("expected a sign-in link")
+		}
+		return ""
+	}
+
+	if w := request("linker@example.com"); w.Code != http.StatusNotImplemented {
+		t.Fatalf("expected magic links to be off by default, got %d", w.Code)
+	}
+	h.MagicLinkEnabled = true
+
+	unknown := request("nobody@example.com")
+	w := request("linker@example.com")
+	if w.Code != http.StatusAccepted || unknown.Code != w.Code || unknown.Body.String() != w.Body.String() {
+		t.Fatalf("expected identical responses for known and unknown addresses, got %d %s / %d %s",
+			w.Code, w.Body.String(), unknown.Code, unknown.Body.String())
+	}
+	token := linkToken()
+	request("linker@example.com")
+	select {
+	case m := <-mailer:
+		t.Fatalf("expected repeated requests to be throttled, got %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if w := verify("bogus"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown token, got %d", w.Code)
+	}
+	w = verify(token)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"access_token"`) {
+		t.Fatalf("expected tokens, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := verify(token); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a link to work once, got %d", w.Code)
+	}
+
+	// With device binding, the link only works alongside the cookie set
+	// on the requesting browser.
+	h.MagicLinkDeviceBinding = true
+	if l, _ := s.GetMagicLink(ctx, id); l != nil {
+		t.Fatalf("expected the used link to be gone, got %+v", l)
+	}
+	w = request("linker@example.com")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != magicLinkCookie || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("expected a device cookie, got %+v", cookies)
+	}
+	token = linkToken()
+	if w := verify(token, &http.Cookie{Name: magicLinkCookie, Value: "elsewhere"}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected another browser to be refused, got %d", w.Code)
+	}
+	if w := verify(token, cookies[0]); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a link opened elsewhere to be spent, got %d", w.Code)
+	}
+
+	events, _, _ := s.ListAuditEvents(ctx, store.AuditFilter{TargetID: id})
+	counts := map[string]int{}
+	for _, e := range events {
+		counts[e.Action]++
+	}
+	if counts[auditUserMagicLinkRequest] != 2 || counts[auditUserLogin] != 1 || counts[auditUserLoginFailed] != 1 {
+		t.Errorf("unexpected audit events: %v", counts)
+	}
+}
+
+func TestUsernameAvailable(t *testing.T) {
+	h, s := setupTestHandlers()
+	policy, err := validation.NewUsernamePolicy(4, 12, []string{validation.CharsLetters, validation.CharsDot}, []string{"sentinel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.UsernamePolicy = policy
+	s.CreateUser(context.Background(), &models.User{Username: "taken", Email: "taken@example.com", Password: "hash", Role: "user"})
+
+	check := func(u string) usernameAvailability {
+		w := httptest.NewRecorder()
+		h.UsernameAvailable(w, httptest.NewRequest(http.MethodGet, "/api/auth/username-available?u="+u, nil))
+		var resp usernameAvailability
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	if r := check("free.name"); !r.Available {
+		t.Errorf("expected free.name to be available: %+v", r)
+	}
+	// Taken and reserved names look the same
+	if r, want := check("taken"), (usernameAvailability{Username: "taken", Reason: "unavailable"}); r != want {
+		t.Errorf("got %+v, want %+v", r, want)
+	}
+	if r := check("Sentinel"); r.Available || r.Reason != "unavailable" || r.Message != "" {
+		t.Errorf("unexpected result for a reserved name: %+v", r)
+	}
+	if r := check("bad_name"); r.Available || r.Reason != "invalid" || !strings.Contains(r.Message, "letters and dots") {
+		t.Errorf("unexpected result for an invalid name: %+v", r)
+	}
+
+	// Registration applies the same policy
+	w := httptest.NewRecorder()
+	h.Register(w, httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(`{"username":"abc","email":"abc@example.com","password":"SecurePass123!"}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "at least 4 characters") {
+		t.Errorf("expected the configured minimum length to apply, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPasswordStrength(t *testing.T) {
+	h, _ := setupTestHandlers()
+	h.PasswordMinScore = 3
+
+	check := func(body string) passwordStrengthResponse {
+		w := httptest.NewRecorder()
+		h.PasswordStrength(w, httptest.NewRequest(http.MethodPost, "/api/auth/password-strength", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body.String())
+		}
+		var resp passwordStrengthResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	if r := check(`{"password":"x7$Kq!9zLm"}`); !r.Pass || r.Score != 4 || r.MinScore != 3 || len(r.Feedback) != 0 {
+		t.Errorf("unexpected result for a strong password: %+v", r)
+	}
+	if r := check(`{"password":"short"}`); r.Pass || !strings.Contains(r.Error, "at least 8 characters") {
+		t.Errorf("unexpected result for a short password: %+v", r)
+	}
+
+	// The verdict matches registration's
+	body := `{"username":"alice","email":"alice@example.com","password":"Alice2024!"}`
+	r := check(body)
+	if r.Pass || r.Score >= 3 || !slices.Contains(r.Feedback, "Avoid your username and email address") {
+		t.Errorf("unexpected result for a guessable password: %+v", r)
+	}
+	w := httptest.NewRecorder()
+	h.Register(w, httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), r.Error) {
+		t.Errorf("expected registration to fail with %q, got %d: %s", r.Error, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.PasswordStrength(w, httptest.NewRequest(http.MethodPost, "/api/auth/password-strength", strings.NewReader(`{}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a password, got %d", w.Code)
+	}
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	h, _ := setupTestHandlers()
+	revoked := denylist.New()
+	h.Auth.SetDenylist(revoked)
+	h.Denylist = revoked
+
+	access, err := h.Auth.GenerateToken("1", "user", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	refresh, err := h.Auth.GenerateRefreshToken("1", "user", time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	otherRefresh, _ := h.Auth.GenerateRefreshToken("2", "user", time.Now(), time.Now().Add(time.Hour))
+	claims, err := h.Auth.ParseToken(access)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	logout := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/logout", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.Logout(w, req.WithContext(context.WithValue(req.Context(), "user", claims)))
+		return w.Code
+	}
+
+	// Another user's refresh token is refused
+	if code := logout(`{"refresh_token":"` + otherRefresh + `"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for foreign refresh token, got %d", code)
+	}
+	if _, err := h.Auth.ParseToken(access); err != nil {
+		t.Fatalf("failed logout must not revoke the access token: %v", err)
+	}
+
+	if code := logout(`{"refresh_token":"` + refresh + `"}`); code != http.StatusNoContent {
+		t.Fatalf("expected 204 for logout, got %d", code)
+	}
+	for _, token := range []string{access, refresh} {
+		if _, err := h.Auth.ParseToken(token); !errors.Is(err, auth.ErrTokenRevoked) {
+			t.Errorf("expected token to be revoked, got %v", err)
+		}
+	}
+
+	// Revocations persist so other instances pick them up on sync
+	fresh := denylist.New()
+	if err := fresh.Sync(context.Background(), h.Store); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if fresh.Len() != 2 || !fresh.Contains(claims.ID) {
+		t.Errorf("expected 2 persisted revocations, got %d", fresh.Len())
+	}
+}
+
+func TestAdminRevokeToken(t *testing.T) {
+	h, _ := setupTestHandlers()
+	revoked := denylist.New()
+	h.Auth.SetDenylist(revoked)
+	h.Denylist = revoked
+
+	token, _ := h.Auth.GenerateToken("2", "user", time.Hour)
+	claims, _ := h.Auth.ParseToken(token)
+	revoke := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/tokens:revoke", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.AdminRevokeToken(w, req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "1", Role: "admin"})))
+		return w.Code
+	}
+
+	if code := revoke(`{}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 without jti, got %d", code)
+	}
+	if code := revoke(`{"jti":"x","expires_at":"2000-01-01T00:00:00Z"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for past expiry, got %d", code)
+	}
+	if code := revoke(`{"jti":"` + claims.ID + `"}`); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if _, err := h.Auth.ParseToken(token); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("expected revoked token to be rejected, got %v", err)
+	}
+}
+
+func TestAdminCreateDump(t *testing.T) {
+	h, _ := setupTestHandlers()
+	h.DiagnosticsDir = t.TempDir()
+
+	for _, kind := range []string{"goroutine", "heap"} {
+		w := httptest.NewRecorder()
+		h.AdminCreateDump(w, httptest.NewRequest(http.MethodPost, "/api/admin/diagnostics/dumps?type="+kind, nil))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected 201 for %s dump, got %d: %s", kind, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Path string `json:"path"`
+			Size int64  `json:"size_bytes"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if filepath.Dir(resp.Path) != h.DiagnosticsDir || resp.Size == 0 {
+			t.Errorf("unexpected %s dump: %+v", kind, resp)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.AdminCreateDump(w, httptest.NewRequest(http.MethodPost, "/api/admin/diagnostics/dumps?type=../../etc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown dump type, got %d", w.Code)
+	}
+}
+
+func TestAdminConfig(t *testing.T) {
+	h, _ := setupTestHandlers()
+	h.Settings = []config.Setting{
+		{Name: "JWT_SECRET", Value: "********", Source: config.SourceEnv},
+		{Name: "PORT", Value: "8080", Source: config.SourceDefault},
+	}
+
+	get := func(query string) (int, []config.Setting) {
+		w := httptest.NewRecorder()
+		h.AdminConfig(w, httptest.NewRequest(http.MethodGet, "/api/admin/config"+query, nil))
+		var resp struct {
+			Settings []config.Setting `json:"settings"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Settings
+	}
+
+	if code, settings := get(""); code != http.StatusOK || len(settings) != 2 {
+		t.Fatalf("expected every setting, got %d %+v", code, settings)
+	}
+	if _, settings := get("?source=env"); len(settings) != 1 || settings[0].Name != "JWT_SECRET" {
+		t.Errorf("expected only env settings, got %+v", settings)
+	}
+	if code, _ := get("?source=vault"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown source, got %d", code)
+	}
+}
+
+func TestAdminPurgeGuests(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	old := time.Now().Add(-2 * DefaultGuestMaxAge)
+	for i, created := range []time.Time{old, old, time.Now()} {
+		name := guestUsernamePrefix + strconv.Itoa(i)
+		if _, err := s.CreateUser(ctx, &models.User{Username: name, Password: guestPasswordHash, Role: models.RoleGuest, CreatedAt: created}); err != nil {
+			t.Fatalf("Failed to create guest: %v", err)
+		}
+	}
+	purge := func(query string) map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.AdminPurgeGuests(w, httptest.NewRequest(http.MethodPost, "/api/admin/guests:purge"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	w := httptest.NewRecorder()
+	h.AdminPurgeGuests(w, httptest.NewRequest(http.MethodPost, "/api/admin/guests:purge?dry_run=maybe", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid dry_run, got %d", w.Code)
+	}
+
+	resp := purge("?dry_run=true")
+	if resp["dry_run"] != true || resp["count"] != float64(2) || len(resp["ids"].([]interface{})) != 2 {
+		t.Fatalf("unexpected dry run result %v", resp)
+	}
+	if n, _ := s.CountUsers(ctx, store.UserFilter{Role: models.RoleGuest}); n != 3 {
+		t.Fatalf("expected a dry run to delete nothing, %d guests left", n)
+	}
+
+	if resp := purge(""); resp["count"] != float64(2) {
+		t.Fatalf("unexpected purge result %v", resp)
+	}
+	if n, _ := s.CountUsers(ctx, store.UserFilter{Role: models.RoleGuest}); n != 1 {
+		t.Fatalf("expected only the recent guest to remain, %d left", n)
+	}
+}
+
+func TestGuestSessions(t *testing.T) {
+	h, s := setupTestHandlers()
+	revoked := denylist.New()
+	h.Auth.SetDenylist(revoked)
+	h.Denylist = revoked
+
+	type guestResponse struct {
+		AccessToken string       `json:"access_token"`
+		User        *models.User `json:"user"`
+	}
+	guest := func(token string) (*httptest.ResponseRecorder, guestResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/guest", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.Guest(w, req)
+		var resp guestResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	if w, _ := guest(""); w.Code != http.StatusNotImplemented {
+		t.Fatalf("expected guest sessions to be off by default, got %d", w.Code)
+	}
+	h.GuestEnabled = true
+
+	w, created := guest("")
+	if w.Code != http.StatusCreated || created.AccessToken == "" || created.User == nil || created.User.Role != models.RoleGuest {
+		t.Fatalf("expected a guest session, got %d: %s", w.Code, w.Body.String())
+	}
+	claims, err := h.Auth.ParseToken(created.AccessToken)
+	if err != nil || claims.Role != models.RoleGuest || claims.UserID != strconv.FormatInt(created.User.ID, 10) {
+		t.Fatalf("unexpected guest claims %+v (%v)", claims, err)
+	}
+	w, renewed := guest(created.AccessToken)
+	if w.Code != http.StatusOK || renewed.User == nil || renewed.User.ID != created.User.ID {
+		t.Fatalf("expected the guest token to be renewed, got %d: %s", w.Code, w.Body.String())
+	}
+	userToken, _ := h.Auth.GenerateToken("99", "user", time.Hour)
+	if w, _ := guest(userToken); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 renewing a non-guest token, got %d", w.Code)
+	}
+
+	register := func(token, username string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/register",
+			strings.NewReader(`{"username":"`+username+`","email":"`+username+`@example.com","password":"SecurePass123!"}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.Register(w, req)
+		return w
+	}
+	w = register(renewed.AccessToken, "upgraded")
+	var reg struct {
+		ID int64 `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &reg)
+	if w.Code != http.StatusCreated || reg.ID != created.User.ID {
+		t.Fatalf("expected the guest to keep its ID on registration, got %d: %s", w.Code, w.Body.String())
+	}
+	u, _ := s.GetUserByID(context.Background(), created.User.ID)
+	if u == nil || u.Username != "upgraded" || u.Role != "user" {
+		t.Fatalf("expected the guest account to be upgraded, got %+v", u)
+	}
+	if _, err := h.Auth.ParseToken(renewed.AccessToken); err == nil {
+		t.Error("expected the guest token to be revoked after the upgrade")
+	}
+	events, _, _ := s.ListAuditEvents(context.Background(), store.AuditFilter{Action: auditUserGuestUpgrade})
+	if len(events) != 1 || events[0].ActorID != created.User.ID {
+		t.Errorf("expected an upgrade audit event by the guest, got %+v", events)
+	}
+	if w := register(created.AccessToken, "again"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an upgraded guest's token to be rejected, got %d", w.Code)
+	}
+
+	login := httptest.NewRecorder()
+	h.Login(login, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username":"upgraded","password":"SecurePass123!"}`)))
+	if login.Code != http.StatusOK {
+		t.Errorf("expected the upgraded account to sign in, got %d: %s", login.Code, login.Body.String())
+	}
+}
+
+func TestSSOCookie(t *testing.T) {
+	h, s := setupTestHandlers()
+	revoked := denylist.New()
+	h.Auth.SetDenylist(revoked)
+	h.Denylist = revoked
+	ctx := context.Background()
+	hash, _ := auth.HashPassword("SecurePass123!")
+	id, _ := s.CreateUser(ctx, &models.User{Username: "sso", Email: "sso@example.com", Password: hash, Role: "user"})
+
+	login := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.Login(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username":"sso","password":"SecurePass123!"}`)))
+		return w
+	}
+	check := func(c *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/sso/check", nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.SSOCheck(w, req)
+		return w
+	}
+
+	if w := login(); len(w.Result().Cookies()) != 0 {
+		t.Fatalf("expected no SSO cookie by default, got %v", w.Result().Cookies())
+	}
+	if w := check(nil); w.Code != http.StatusNotImplemented {
+		t.Fatalf("expected SSO checks to be off by default, got %d", w.Code)
+	}
+	h.SSOCookieDomain = "example.com"
+
+	w := login()
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == DefaultSSOCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Domain != "example.com" || !cookie.HttpOnly || cookie.Value == "" {
+		t.Fatalf("expected a domain-wide SSO cookie, got %v", w.Result().Cookies())
+	}
+	if w := check(nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a cookie, got %d", w.Code)
+	}
+	w = check(cookie)
+	if w.Code != http.StatusOK || w.Header().Get("X-Sentinel-User-Id") != strconv.FormatInt(id, 10) || !strings.Contains(w.Body.String(), `"username":"sso"`) {
+		t.Fatalf("expected the signed-in user, got %d %v: %s", w.Code, w.Header(), w.Body.String())
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	json.Unmarshal(login().Body.Bytes(), &tokens)
+	if w := check(&http.Cookie{Name: DefaultSSOCookieName, Value: tokens.AccessToken}); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an access token to be rejected as an SSO cookie, got %d", w.Code)
+	}
+
+	claims, _ := h.Auth.ParseToken(tokens.AccessToken)
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)
+	req.AddCookie(cookie)
+	lw := httptest.NewRecorder()
+	h.Logout(lw, req.WithContext(context.WithValue(req.Context(), "user", claims)))
+	if lw.Code != http.StatusNoContent || len(lw.Result().Cookies()) != 1 || lw.Result().Cookies()[0].MaxAge >= 0 {
+		t.Fatalf("expected logout to clear the SSO cookie, got %d %v", lw.Code, lw.Result().Cookies())
+	}
+	if w := check(cookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the SSO cookie to be revoked on logout, got %d", w.Code)
+	}
+}
+
+func TestBackchannelLogout(t *testing.T) {
+	h, s := setupTestHandlers()
+	const secret = "0123456789abcdef0123456789abcdef"
+	subjects := make(chan string, 4)
+	rp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := jwt.Parse(r.FormValue("logout_token"), func(*jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sub, _ := token.Claims.GetSubject()
+		subjects <- sub
+	}))
+	defer rp.Close()
+	h.Backchannel = backchannel.New("https://auth.example.com", []backchannel.Client{{ID: "app", LogoutURI: rp.URL, Secret: secret}})
+	next := func() string {
+		t.Helper()
+		select {
+		case sub := <-subjects:
+			return sub
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected a logout token")
+		}
+		return ""
+	}
+
+	ctx := context.Background()
+	id, _ := s.CreateUser(ctx, &models.User{Username: "leaver", Email: "leaver@example.com", Password: "h", Role: "user"})
+	access, _ := h.Auth.GenerateToken(strconv.FormatInt(id, 10), "user", time.Hour)
+	claims, _ := h.Auth.ParseToken(access)
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)
+	w := httptest.NewRecorder()
+	h.Logout(w, req.WithContext(context.WithValue(req.Context(), "user", claims)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("logout: %d %s", w.Code, w.Body.String())
+	}
+	if sub := next(); sub != strconv.FormatInt(id, 10) {
+		t.Errorf("expected a logout token for user %d, got %q", id, sub)
+	}
+
+	admin := &auth.Claims{UserID: "999", Role: "admin"}
+	req = httptest.NewRequest(http.MethodPost, "/api/admin/users:batchDisable", strings.NewReader(fmt.Sprintf(`{"ids":[%d,%d]}`, id, id+100)))
+	w = httptest.NewRecorder()
+	h.AdminBatchDisable(w, req.WithContext(context.WithValue(req.Context(), "user", admin)))
+	if sub := next(); sub != strconv.FormatInt(id, 10) {
+		t.Errorf("expected a logout token for the disabled user, got %q", sub)
+	}
+	select {
+	case sub := <-subjects:
+		t.Errorf("expected no logout token for a missing user, got %q", sub)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Disabling the user again changes nothing, so nothing is announced
+	req = httptest.NewRequest(http.MethodPost, "/api/admin/users:batchDisable", strings.NewReader(fmt.Sprintf(`{"ids":[%d]}`, id)))
+	w = httptest.NewRecorder()
+	h.AdminBatchDisable(w, req.WithContext(context.WithValue(req.Context(), "user", admin)))
+	select {
+	case sub := <-subjects:
+		t.Errorf("expected no logout token for an already disabled user, got %q", sub)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLoginTarpit(t *testing.T) {
+	h, s := setupTestHandlers()
+	h.Tarpit = tarpit.New(tarpit.Config{Threshold: 2, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond, RejectAtMax: true})
+	hash, _ := auth.HashPassword("SecurePass123!")
+	s.CreateUser(context.Background(), &models.User{Username: "target", Email: "target@example.com", Password: hash, Role: "user"})
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.Login(w, httptest.NewRequest(http.MethodPost, "/api/auth/login",
+			strings.NewReader(`{"username":"`+username+`","password":"`+password+`"}`)))
+		return w
+	}
+
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if w := login("target", "wrong"); w.Code != want {
+			t.Fatalf("attempt %d: expected %d, got %d", i+1, want, w.Code)
+		}
+	}
+	w := login("target", "SecurePass123!")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected the right password to be turned away at the cap too, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if d := h.Tarpit.Delay(context.Background(), "user:nobody"); d != 0 {
+		t.Errorf("expected other accounts to be unaffected, got %v", d)
+	}
+}
+
+func TestCanaries(t *testing.T) {
+	h, s := setupTestHandlers()
+	revoked := denylist.New()
+	h.Denylist = revoked
+	h.Auth.SetDenylist(revoked)
+	h.Canaries = canary.New(s, nil)
+	h.Auth.SetCanaries(h.Canaries)
+	ctx := context.Background()
+
+	admin := &auth.Claims{UserID: "999", Role: "admin"}
+	create := func(body string) map[string]json.RawMessage {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/canaries", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.AdminCreateCanary(w, req.WithContext(context.WithValue(req.Context(), "user", admin)))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected 201 creating %s, got %d: %s", body, w.Code, w.Body.String())
+		}
+		var resp map[string]json.RawMessage
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+	trips := func(action string) int {
+		_, n, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: action})
+		return n
+	}
+
+	var account models.Canary
+	json.Unmarshal(create(`{"kind":"account","username":"svc_backup","email":"backup@example.com","note":"staging dump"}`)["canary"], &account)
+	w := httptest.NewRecorder()
+	h.Login(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username":"svc_backup","password":"!"}`)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a bait account login to fail like any other, got %d", w.Code)
+	}
+	if trips("canary.login") != 1 {
+		t.Error("expected the bait account login to be recorded as a canary trip")
+	}
+
+	var token string
+	json.Unmarshal(create(`{"kind":"token"}`)["token"], &token)
+	w = httptest.NewRecorder()
+	h.RefreshToken(w, httptest.NewRequest(http.MethodPost, "/api/auth/refresh", strings.NewReader(`{"refresh_token":"`+token+`"}`)))
+	if w.Code != http.StatusUnauthorized || trips("canary.token") != 1 {
+		t.Errorf("expected the canary token to be rejected and recorded, got %d", w.Code)
+	}
+	h.Auth.SetCanaries(nil)
+	if _, err := h.Auth.ParseToken(token); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("expected the canary token to be revoked as well, got %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/admin/canaries/"+strconv.FormatInt(account.ID, 10), nil)
+	req.SetPathValue("id", strconv.FormatInt(account.ID, 10))
+	w = httptest.NewRecorder()
+	h.AdminDeleteCanary(w, req.WithContext(context.WithValue(req.Context(), "user", admin)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 deleting the canary, got %d", w.Code)
+	}
+	if u, _ := s.GetUserByID(ctx, account.UserID); u != nil {
+		t.Error("expected the bait account to be deleted with its canary")
+	}
+	if _, ok := h.Canaries.Account(account.UserID); ok {
+		t.Error("expected the deleted canary to be forgotten")
+	}
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	h, s := setupTestHandlers()
+	status := http.StatusBadGateway
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	h.Webhooks = webhooks.New(s, nil, webhooks.Endpoint{ID: "alerts", URL: srv.URL})
+	admin := &auth.Claims{UserID: "999", Role: "admin"}
+	call := func(handler http.HandlerFunc, method, id, delivery string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/admin/webhooks/"+id+"/deliveries", nil)
+		req.SetPathValue("id", id)
+		req.SetPathValue("delivery", delivery)
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(context.WithValue(req.Context(), "user", admin)))
+		return w
+	}
+
+	first, err := h.Webhooks.Deliver(context.Background(), "alerts", "alert", map[string]string{"rule": "failed_logins"})
+	if err == nil {
+		t.Fatal("expected the delivery to fail")
+	}
+
+	status = http.StatusOK
+	w := call(h.AdminRedeliverWebhook, http.MethodPost, "alerts", strconv.FormatInt(first.ID, 10))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 redelivering, got %d: %s", w.Code, w.Body.String())
+	}
+	var redelivered struct {
+		Delivery models.WebhookDelivery `json:"delivery"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &redelivered)
+	if redelivered.Delivery.RedeliveryOf != first.ID || redelivered.Delivery.EventID != first.EventID || redelivered.Delivery.StatusCode != http.StatusOK {
+		t.Errorf("unexpected redelivery %+v", redelivered.Delivery)
+	}
+
+	w = call(h.AdminListWebhookDeliveries, http.MethodGet, "alerts", "")
+	var page struct {
+		Deliveries []models.WebhookDelivery `json:"deliveries"`
+		Total      int                      `json:"total"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if w.Code != http.StatusOK || page.Total != 2 || page.Deliveries[0].ID != redelivered.Delivery.ID {
+		t.Errorf("expected both deliveries newest first, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := call(h.AdminListWebhookDeliveries, http.MethodGet, "other", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown webhook, got %d", w.Code)
+	}
+	if w := call(h.AdminRedeliverWebhook, http.MethodPost, "alerts", "12345"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown delivery, got %d", w.Code)
+	}
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	h, s := setupTestHandlers()
+	events := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events <- r.Header.Get(webhook.EventHeader)
+	}))
+	defer srv.Close()
+	h.Webhooks = webhooks.New(s, nil)
+	admin := &auth.Claims{UserID: "999", Role: "admin"}
+	call := func(handler http.HandlerFunc, method, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/admin/webhooks/"+id, strings.NewReader(body))
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(context.WithValue(req.Context(), "user", admin)))
+		return w
+	}
+
+	if w := call(h.AdminCreateWebhook, http.MethodPost, "", `{"url":"`+srv.URL+`","events":["user.explode"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown event type, got %d", w.Code)
+	}
+	w := call(h.AdminCreateWebhook, http.MethodPost, "", `{"url":"`+srv.URL+`","events":["user.login"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating a webhook, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Webhook models.Webhook `json:"webhook"`
+		Secret  string         `json:"secret"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	id := strconv.FormatInt(created.Webhook.ID, 10)
+	if !created.Webhook.Enabled || len(created.Secret) < webhooks.MinSecretLength || strings.Contains(w.Body.String(), `"webhook":{"secret"`) {
+		t.Fatalf("unexpected created webhook %s", w.Body.String())
+	}
+	if w := call(h.AdminGetWebhook, http.MethodGet, id, ""); w.Code != http.StatusOK || strings.Contains(w.Body.String(), created.Secret) {
+		t.Errorf("expected the webhook without its secret, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A login is published to the subscription through the outbox
+	user := &models.User{Username: "alice", Password: "h", Role: "user"}
+	user.ID, _ = s.CreateUser(context.Background(), user)
+	h.recordLogin(httptest.NewRequest(http.MethodPost, "/api/auth/login", nil), user, auditUserLogin)
+	if n, err := h.Webhooks.Dispatch(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected the login to be dispatched, got %d (%v)", n, err)
+	}
+	select {
+	case e := <-events:
+		if e != webhooks.EventUserLogin {
+			t.Errorf("expected a user.login event, got %q", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the login to be published")
+	}
+
+	w = call(h.AdminUpdateWebhook, http.MethodPatch, id, `{"enabled":false,"secret":""}`)
+	var updated struct {
+		Webhook models.Webhook `json:"webhook"`
+		Secret  string         `json:"secret"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &updated)
+	if w.Code != http.StatusOK || updated.Webhook.Enabled || updated.Secret == "" || updated.Secret == created.Secret {
+		t.Fatalf("expected the webhook disabled with a rotated secret, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Test deliveries reach disabled webhooks
+	w = call(h.AdminTestWebhook, http.MethodPost, id, "")
+	if w.Code != http.StatusOK || <-events != webhooks.EventTest {
+		t.Errorf("expected a test delivery, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, total, _ := s.ListWebhookDeliveries(context.Background(), id, 10, 0); total != 2 {
+		t.Errorf("expected the login and test deliveries to be logged, got %d", total)
+	}
+
+	// Replays resend outbox events in the range, even to disabled webhooks
+	from, to := time.Now().Add(-time.Hour).Format(time.RFC3339), time.Now().Add(time.Hour).Format(time.RFC3339)
+	if w := call(h.AdminReplayWebhook, http.MethodPost, id, `{"from":"`+to+`","to":"`+from+`"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an empty range, got %d", w.Code)
+	}
+	if w := call(h.AdminReplayWebhook, http.MethodPost, "42", `{"from":"`+from+`","to":"`+to+`"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 replaying to an unknown webhook, got %d", w.Code)
+	}
+	w = call(h.AdminReplayWebhook, http.MethodPost, id, `{"from":"`+from+`","to":"`+to+`"}`)
+	if w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), `"events":1`) {
+		t.Fatalf("expected the login to be replayed, got %d: %s", w.Code, w.Body.String())
+	}
+	select {
+	case e := <-events:
+		if e != webhooks.EventUserLogin {
+			t.Errorf("expected the user.login event to be replayed, got %q", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the login to be replayed")
+	}
+
+	if w := call(h.AdminDeleteWebhook, http.MethodDelete, id, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 deleting the webhook, got %d", w.Code)
+	}
+	if w := call(h.AdminTestWebhook, http.MethodPost, id, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 testing a deleted webhook, got %d", w.Code)
+	}
+}
+
+func TestAdminQuotas(t *testing.T) {
+	h, s := setupTestHandlers()
+	h.Quotas = quota.New(s)
+	ctx := context.Background()
+	admin := &auth.Claims{UserID: "999", Role: "admin"}
+	call := func(handler http.HandlerFunc, method, subject, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/admin/quotas/"+subject, strings.NewReader(body))
+		req.SetPathValue("subject", subject)
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(context.WithValue(req.Context(), "user", admin)))
+		return w
+	}
+
+	if w := call(h.AdminSetQuota, http.MethodPut, "billing", `{"daily":10}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a subject without a prefix, got %d", w.Code)
+	}
+	if w := call(h.AdminSetQuota, http.MethodPut, "service:billing", `{"daily":-1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative limit, got %d", w.Code)
+	}
+	if w := call(h.AdminSetQuota, http.MethodPut, "service:billing", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a quota without limits, got %d", w.Code)
+	}
+	if w := call(h.AdminSetQuota, http.MethodPut, "service:billing", `{"daily":1,"monthly":100}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating a quota, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The quota applies on this instance at once
+	r := httptest.NewRequest(http.MethodGet, "/api/admin/stats", nil)
+	r = r.WithContext(mtls.ContextWithIdentity(r.Context(), &mtls.Identity{Account: &mtls.ServiceAccount{Name: "billing"}}))
+	for i, want := range []bool{true, false} {
+		if got := h.Quotas.Enforce(httptest.NewRecorder(), r); got != want {
+			t.Fatalf("request %d: expected Enforce = %v", i+1, want)
+		}
+	}
+
+	w := call(h.AdminSetQuota, http.MethodPut, "service:billing", `{"daily":5,"monthly":100}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 adjusting a quota, got %d: %s", w.Code, w.Body.String())
+	}
+	w = call(h.AdminGetQuota, http.MethodGet, "service:billing", "")
+	var got struct {
+		Quota struct {
+			Daily int64         `json:"daily"`
+			Usage []quota.Usage `json:"usage"`
+		} `json:"quota"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if w.Code != http.StatusOK || got.Quota.Daily != 5 || len(got.Quota.Usage) != 2 || got.Quota.Usage[0].Used != 2 || got.Quota.Usage[0].Remaining != 3 {
+		t.Errorf("expected the adjusted quota with its usage, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(h.AdminListQuotas, http.MethodGet, "", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"subject":"service:billing"`) {
+		t.Errorf("expected the quota to be listed, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, n, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: "quota.set"}); n != 2 {
+		t.Errorf("expected both changes to be audited, got %d", n)
+	}
+
+	if w := call(h.AdminDeleteQuota, http.MethodDelete, "service:billing", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 deleting a quota, got %d", w.Code)
+	}
+	if w := call(h.AdminGetQuota, http.MethodGet, "service:billing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted quota, got %d", w.Code)
+	}
+	if !h.Quotas.Enforce(httptest.NewRecorder(), r) {
+		t.Error("expected a deleted quota to stop applying")
+	}
+}
+
+func TestClientPolicies(t *testing.T) {
+	h, s := setupTestHandlers()
+	h.Clients = clients.New(s)
+	ctx := context.Background()
+	hash, _ := auth.HashPassword("password123")
+	if _, err := s.CreateUser(ctx, &models.User{Username: "daemon", Email: "d@example.com", Password: hash, Role: "user"}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	admin := &auth.Claims{UserID: "999", Role: "admin"}
+	call := func(handler http.HandlerFunc, method, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/admin/clients/"+id, strings.NewReader(body))
+		req.SetPathValue("client_id", id)
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(context.WithValue(req.Context(), "user", admin)))
+		return w
+	}
+	post := func(handler http.HandlerFunc, body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body)))
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	for _, body := range []string{`{"access_token_ttl":-1}`, `{"refresh_policy":"forever"}`, `{"cors_origins":["*"]}`} {
+		if w := call(h.AdminSetClient, http.MethodPut, "mobile", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	if w := call(h.AdminSetClient, http.MethodPut, "bad!id", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid client ID, got %d", w.Code)
+	}
+	body := `{"name":"Mobile","access_token_ttl":300,"refresh_token_ttl":3600,"refresh_policy":"sliding","allowed_scopes":["profile","offline"],"cors_origins":["https://app.example.com"]}`
+	if w := call(h.AdminSetClient, http.MethodPut, "mobile", body); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 registering a client, got %d: %s", w.Code, w.Body.String())
+	}
+	if !h.Clients.AllowsOrigin("https://app.example.com") {
+		t.Error("expected the client's origin to be allowed at once")
+	}
+
+	// Logins naming the client get its lifetimes and the scope it asked for
+	if code, _ := post(h.Login, `{"username":"daemon","password":"password123","client_id":"other"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown client, got %d", code)
+	}
+	if code, _ := post(h.Login, `{"username":"daemon","password":"password123","client_id":"mobile","scope":"admin"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a scope the client may not request, got %d", code)
+	}
+	if code, _ := post(h.Login, `{"username":"daemon","password":"password123","scope":"profile"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a scope without a client, got %d", code)
+	}
+	code, resp := post(h.Login, `{"username":"daemon","password":"password123","client_id":"mobile","scope":"profile"}`)
+	if code != http.StatusOK || resp["expires_in"] != float64(300) || resp["scope"] != "profile" {
+		t.Fatalf("expected the client's policy at login, got %d: %v", code, resp)
+	}
+	access, _ := h.Auth.ParseToken(resp["access_token"].(string))
+	refresh, _ := h.Auth.ParseToken(resp["refresh_token"].(string))
+	if access.ClientID != "mobile" || access.Scope != "profile" || access.ExpiresAt.Sub(access.IssuedAt.Time) != 5*time.Minute {
+		t.Errorf("unexpected access token claims %+v", access)
+	}
+	if refresh.ClientID != "mobile" || refresh.ExpiresAt.Sub(refresh.IssuedAt.Time) != time.Hour {
+		t.Errorf("unexpected refresh token claims %+v", refresh)
+	}
+
+	// A narrowed policy applies at the next refresh
+	body = `{"access_token_ttl":600,"refresh_policy":"fixed","allowed_scopes":["offline"]}`
+	if w := call(h.AdminSetClient, http.MethodPut, "mobile", body); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 updating a client, got %d: %s", w.Code, w.Body.String())
+	}
+	code, resp = post(h.RefreshToken, `{"refresh_token":"`+resp["refresh_token"].(string)+`"}`)
+	if code != http.StatusOK || resp["expires_in"] != float64(600) || resp["scope"] != nil {
+		t.Fatalf("expected the updated policy at refresh, got %d: %v", code, resp)
+	}
+	rotated, _ := h.Auth.ParseToken(resp["refresh_token"].(string))
+	if !rotated.ExpiresAt.Equal(refresh.ExpiresAt.Time) {
+		t.Errorf("expected the fixed policy to keep the session's expiry, got %v want %v", rotated.ExpiresAt, refresh.ExpiresAt)
+	}
+	if w := call(h.AdminListClients, http.MethodGet, "", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"client_id":"mobile"`) {
+		t.Errorf("expected the client to be listed, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, n, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: "client.set"}); n != 2 {
+		t.Errorf("expected both changes to be audited, got %d", n)
+	}
+
+	// Unregistering the client ends its sessions
+	if w := call(h.AdminDeleteClient, http.MethodDelete, "mobile", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 deleting a client, got %d", w.Code)
+	}
+	if w := call(h.AdminGetClient, http.MethodGet, "mobile", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted client, got %d", w.Code)
+	}
+	if code, _ := post(h.RefreshToken, `{"refresh_token":"`+resp["refresh_token"].(string)+`"}`); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 refreshing a deleted client's session, got %d", code)
+	}
+}
+
+func TestAccountExpiry(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	admin := &auth.Claims{UserID: "999", Role: "admin"}
+	call := func(handler http.HandlerFunc, method, target, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(context.WithValue(req.Context(), "user", admin)))
+		return w
+	}
+	post := func(handler http.HandlerFunc, body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body)))
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	soon := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	body := `{"username":"contractor","email":"c@example.com","password":"My-Contractor-2024","expires_at":"` + past + `"}`
+	if w := call(h.AdminCreateUser, http.MethodPost, "/api/admin/users", "", body); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an expiry in the past, got %d", w.Code)
+	}
+	body = `{"username":"contractor","email":"c@example.com","password":"My-Contractor-2024","expires_at":"` + soon + `"}`
+	w := call(h.AdminCreateUser, http.MethodPost, "/api/admin/users", "", body)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"expires_at":"`+soon+`"`) {
+		t.Fatalf("expected 201 creating an expiring account, got %d: %s", w.Code, w.Body.String())
+	}
+	user, _ := s.GetUserByUsername(ctx, "contractor")
+	id := strconv.FormatInt(user.ID, 10)
+
+	code, resp := post(h.Login, `{"username":"contractor","password":"My-Contractor-2024"}`)
+	if code != http.StatusOK {
+		t.Fatalf("expected the account to log in before it expires, got %d", code)
+	}
+	if w := call(h.AdminListExpiringUsers, http.MethodGet, "/api/admin/users:expiring?within=2h", "", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"username":"contractor"`) {
+		t.Errorf("expected the account to be listed as expiring, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(h.AdminListExpiringUsers, http.MethodGet, "/api/admin/users:expiring?within=-1h", "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative window, got %d", w.Code)
+	}
+
+	// Once it has expired the account can neither log in nor refresh
+	expired := time.Now().Add(-time.Minute)
+	user.ExpiresAt = &expired
+	if err := s.UpdateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := post(h.Login, `{"username":"contractor","password":"My-Contractor-2024"}`); code != http.StatusForbidden {
+		t.Errorf("expected 403 logging in to an expired account, got %d", code)
+	}
+	if code, _ := post(h.RefreshToken, `{"refresh_token":"`+resp["refresh_token"].(string)+`"}`); code != http.StatusForbidden {
+		t.Errorf("expected 403 refreshing an expired account's session, got %d", code)
+	}
+
+	// Extending the expiry restores access; it is audited
+	if w := call(h.AdminSetUserExpiry, http.MethodPut, "/api/admin/users/"+id+"/expiry", id, `{"expires_at":"`+past+`"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 setting an expiry in the past, got %d", w.Code)
+	}
+	if w := call(h.AdminSetUserExpiry, http.MethodPut, "/api/admin/users/"+id+"/expiry", id, `{"expires_at":null}`); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "expires_at") {
+		t.Fatalf("expected 200 removing the expiry, got %d: %s", w.Code, w.Body.String())
+	}
+	if code, _ := post(h.Login, `{"username":"contractor","password":"My-Contractor-2024"}`); code != http.StatusOK {
+		t.Errorf("expected the account to log in again, got %d", code)
+	}
+	if _, n, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: "user.expiry.set"}); n != 1 {
+		t.Errorf("expected the expiry change to be audited, got %d events", n)
+	}
+
+	// The cleanup job disables accounts that have expired, and only those
+	user, _ = s.GetUserByID(ctx, user.ID)
+	user.ExpiresAt = &expired
+	if err := s.UpdateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := auth.HashPassword("password123")
+	later := time.Now().Add(24 * time.Hour)
+	s.CreateUser(ctx, &models.User{Username: "intern", Email: "i@example.com", Password: hash, Role: "user", ExpiresAt: &later})
+	n, err := h.DisableExpiredAccounts(ctx, time.Now())
+	if err != nil || n != 1 {
+		t.Fatalf("expected one account to be disabled, got %d (%v)", n, err)
+	}
+	if user, _ = s.GetUserByID(ctx, user.ID); !user.Disabled {
+		t.Error("expected the expired account to be disabled")
+	}
+	if intern, _ := s.GetUserByUsername(ctx, "intern"); intern.Disabled {
+		t.Error("expected an account that has not expired to stay enabled")
+	}
+	events, _, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: "user.disable"})
+	if len(events) != 1 || events[0].ActorID != 0 || events[0].TargetID != user.ID {
+		t.Errorf("expected a user.disable event with no actor, got %+v", events)
+	}
+	if n, _ := h.DisableExpiredAccounts(ctx, time.Now()); n != 0 {
+		t.Errorf("expected nothing left to disable, got %d", n)
+	}
+}
+
+func TestScheduledStatusChanges(t *testing.T) {
+	h, s := setupTestHandlers()
+	mailer := make(fakeMailer, 4)
+	h.Mailer = mailer
+	ctx := context.Background()
+	hash, _ := auth.HashPassword("password123")
+	user := &models.User{Username: "contractor", Email: "c@example.com", Password: hash, Role: "user"}
+	s.CreateUser(ctx, user)
+	id := strconv.FormatInt(user.ID, 10)
+	call := func(handler http.HandlerFunc, method, changeID, body string, caller *auth.Claims) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/admin/users/"+id+"/status-changes", strings.NewReader(body))
+		req.SetPathValue("id", id)
+		req.SetPathValue("change_id", changeID)
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(context.WithValue(req.Context(), "user", caller)))
+		return w
+	}
+	admin := &auth.Claims{UserID: "999", Role: "admin"}
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	soon := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for desc, body := range map[string]string{
+		"unknown action": `{"action":"delete","run_at":"` + soon + `"}`,
+		"past run_at":    `{"action":"suspend","run_at":"` + past + `"}`,
+		"long reason":    `{"action":"suspend","run_at":"` + soon + `","reason":"` + strings.Repeat("x", 501) + `"}`,
+	} {
+		if w := call(h.AdminScheduleStatusChange, http.MethodPost, "", body, admin); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", desc, w.Code)
+		}
+	}
+	self := &auth.Claims{UserID: id, Role: "admin"}
+	if w := call(h.AdminScheduleStatusChange, http.MethodPost, "", `{"action":"suspend","run_at":"`+soon+`"}`, self); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 scheduling a suspension of your own account, got %d", w.Code)
+	}
+
+	w := call(h.AdminScheduleStatusChange, http.MethodPost, "", `{"action":"suspend","run_at":"`+soon+`","reason":"Contract ended","notify":true}`, admin)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"status":"pending"`) {
+		t.Fatalf("expected 201 scheduling a suspension, got %d: %s", w.Code, w.Body.String())
+	}
+	later := time.Now().Add(90 * time.Minute).UTC().Format(time.RFC3339)
+	w = call(h.AdminScheduleStatusChange, http.MethodPost, "", `{"action":"reactivate","run_at":"`+later+`"}`, admin)
+	var created struct {
+		StatusChange models.StatusChange `json:"status_change"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	changeID := strconv.FormatInt(created.StatusChange.ID, 10)
+	if w := call(h.AdminListStatusChanges, http.MethodGet, "", "", admin); w.Code != http.StatusOK || strings.Count(w.Body.String(), `"status":"pending"`) != 2 {
+		t.Errorf("expected two pending changes, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A pending change can be canceled once
+	if w := call(h.AdminCancelStatusChange, http.MethodDelete, changeID, "", admin); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 canceling a pending change, got %d", w.Code)
+	}
+	if w := call(h.AdminCancelStatusChange, http.MethodDelete, changeID, "", admin); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 canceling a canceled change, got %d", w.Code)
+	}
+	if w := call(h.AdminCancelStatusChange, http.MethodDelete, "12345", "", admin); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 canceling an unknown change, got %d", w.Code)
+	}
+
+	// Nothing happens before run_at; then the account is suspended as the
+	// admin who scheduled it and the user is told why
+	if n, err := h.RunStatusChanges(ctx, time.Now()); err != nil || n != 0 {
+		t.Errorf("expected nothing due yet, got %d (%v)", n, err)
+	}
+	n, err := h.RunStatusChanges(ctx, time.Now().Add(2*time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("expected one change to be applied, got %d (%v)", n, err)
+	}
+	if user, _ = s.GetUserByID(ctx, user.ID); !user.Disabled {
+		t.Error("expected the account to be suspended")
+	}
+	events, _, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: "user.disable"})
+	if len(events) != 1 || events[0].ActorID != 999 || events[0].TargetID != user.ID {
+		t.Errorf("expected a user.disable event by the scheduling admin, got %+v", events)
+	}
+	select {
+	case m := <-mailer:
+		if m.To != "c@example.com" || !strings.Contains(m.Body, "Contract ended") {
+			t.Errorf("unexpected suspension email: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a suspension email")
+	}
+
+	// A reactivation re-enables the account, without an email unless asked
+	w = call(h.AdminScheduleStatusChange, http.MethodPost, "", `{"action":"reactivate","run_at":"`+soon+`"}`, admin)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 scheduling a reactivation, got %d", w.Code)
+	}
+	if n, err := h.RunStatusChanges(ctx, time.Now().Add(2*time.Hour)); err != nil || n != 1 {
+		t.Fatalf("expected the reactivation to be applied, got %d (%v)", n, err)
+	}
+	if user, _ = s.GetUserByID(ctx, user.ID); user.Disabled {
+		t.Error("expected the account to be reactivated")
+	}
+	if _, n, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: "user.enable"}); n != 1 {
+		t.Errorf("expected one user.enable event, got %d", n)
+	}
+	if _, n, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: "user.status_change.schedule", TargetID: user.ID}); n != 3 {
+		t.Errorf("expected the schedulings to be audited on the user, got %d", n)
+	}
+	select {
+	case m := <-mailer:
+		t.Errorf("expected no reactivation email, got %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAdminFlags(t *testing.T) {
+	h, s := setupTestHandlers()
+	h.Flags = flags.New(s, nil, nil)
+	ctx := context.Background()
+	admin := &auth.Claims{UserID: "999", Role: "admin"}
+	call := func(handler http.HandlerFunc, method, name, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/admin/flags/"+name, strings.NewReader(body))
+		req.SetPathValue("name", name)
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(context.WithValue(req.Context(), "user", admin)))
+		return w
+	}
+	register := func(username string) int {
+		body := fmt.Sprintf(`{"username":%q,"email":"%s@example.com","password":"My-%s-2024"}`, username, strings.ToLower(username), username)
+		w := httptest.NewRecorder()
+		h.Register(w, httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if w := call(h.AdminListFlags, http.MethodGet, "", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"source":"default"`) {
+		t.Fatalf("expected the declared flags, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(h.AdminSetFlag, http.MethodPut, "unknown", `{"enabled":true}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an undeclared flag, got %d", w.Code)
+	}
+	if w := call(h.AdminSetFlag, http.MethodPut, flags.PasswordContext, `{"enabled":true,"percent":101}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a percentage over 100, got %d", w.Code)
+	}
+	if code := register("Gradual"); code != http.StatusCreated {
+		t.Fatalf("expected the flag to be off by default, got %d", code)
+	}
+
+	// Rolled out to one subject only
+	w := call(h.AdminSetFlag, http.MethodPut, flags.PasswordContext, `{"enabled":true,"subjects":["username:rollout"]}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"source":"override"`) {
+		t.Fatalf("expected 201 creating an override, got %d: %s", w.Code, w.Body.String())
+	}
+	if code := register("Rollout"); code != http.StatusBadRequest {
+		t.Errorf("expected a targeted subject to get the new rule, got %d", code)
+	}
+	if code := register("Untargeted"); code != http.StatusCreated {
+		t.Errorf("expected other subjects to keep the old behaviour, got %d", code)
+	}
+	if w := call(h.AdminSetFlag, http.MethodPut, flags.PasswordContext, `{"enabled":true,"percent":100}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 replacing an override, got %d", w.Code)
+	}
+	if code := register("Everyone"); code != http.StatusBadRequest {
+		t.Errorf("expected the rule to apply to everyone, got %d", code)
+	}
+	if _, n, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: "flag.set"}); n != 2 {
+		t.Errorf("expected both changes to be audited, got %d", n)
+	}
+
+	if w := call(h.AdminDeleteFlag, http.MethodDelete, flags.PasswordContext, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"source":"default"`) {
+		t.Fatalf("expected the configured rollout after removing the override, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(h.AdminDeleteFlag, http.MethodDelete, flags.PasswordContext, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without an override, got %d", w.Code)
+	}
+	if code := register("Restored"); code != http.StatusCreated {
+		t.Errorf("expected the flag to be off again, got %d", code)
+	}
+}
+
+func TestElevation(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	for _, u := range []*models.User{
+		{Username: "helper", Email: "helper@example.com", Password: "hash", Role: "support"},
+		{Username: "boss", Email: "boss@example.com", Password: "hash", Role: "admin"},
+		{Username: "plain", Email: "plain@example.com", Password: "hash", Role: "user"},
+	} {
+		if _, err := s.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	call := func(handler http.HandlerFunc, caller *auth.Claims, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/elevations", strings.NewReader(body))
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(context.WithValue(req.Context(), "user", caller)))
+		return w
+	}
+	helper := &auth.Claims{UserID: "1", Role: "support"}
+	boss := &auth.Claims{UserID: "2", Role: "admin"}
+	request := `{"scopes":["user.write","user.write"],"reason":"ticket 42","duration_minutes":15}`
+
+	if w := call(h.RequestElevation, &auth.Claims{UserID: "3", Role: "user"}, "", request); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a plain user, got %d", w.Code)
+	}
+	for _, body := range []string{
+		`{"scopes":["user.delete"],"reason":"r","duration_minutes":15}`,
+		`{"scopes":["user.write"],"reason":" ","duration_minutes":15}`,
+		`{"scopes":["user.write"],"reason":"r","duration_minutes":61}`,
+	} {
+		if w := call(h.RequestElevation, helper, "", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	// Requests wait for another admin
+	w := call(h.RequestElevation, helper, "", request)
+	if w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), `"scopes":["user.write"]`) {
+		t.Fatalf("expected a pending request, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(h.RedeemElevation, helper, "1", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 redeeming a pending request, got %d", w.Code)
+	}
+	if w := call(h.AdminApproveElevation, helper, "1", ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 approving one's own request, got %d", w.Code)
+	}
+	if w := call(h.AdminApproveElevation, boss, "1", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 approving, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(h.AdminDenyElevation, boss, "1", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 deciding twice, got %d", w.Code)
+	}
+	if w := call(h.RedeemElevation, boss, "1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 redeeming another user's elevation, got %d", w.Code)
+	}
+
+	w = call(h.RedeemElevation, helper, "1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 redeeming, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	claims, err := h.Auth.ParseToken(resp.AccessToken)
+	if err != nil || len(claims.Scopes) != 1 || claims.Scopes[0] != "user.write" || claims.Role != "support" {
+		t.Fatalf("unexpected elevated token %+v (%v)", claims, err)
+	}
+	if left := time.Until(claims.ExpiresAt.Time); left > 15*time.Minute || left < 14*time.Minute {
+		t.Errorf("expected the token to last 15 minutes, got %v", left)
+	}
+	if w := call(h.RedeemElevation, helper, "1", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 redeeming twice, got %d", w.Code)
+	}
+
+	// Self-service approval issues the token at once for capabilities the
+	// requester's role grants, but never escalates it
+	h.ElevationApproval = ElevationApprovalSelf
+	held := `{"scopes":["user.read"],"reason":"ticket 42","duration_minutes":15}`
+	if w := call(h.RequestElevation, helper, "", held); w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), "access_token") {
+		t.Errorf("expected a token under self-service approval, got %d: %s", w.Code, w.Body.String())
+	}
+	for caller, body := range map[*auth.Claims]string{
+		helper: `{"scopes":["admin"],"reason":"r","duration_minutes":15}`,
+		boss:   `{"scopes":["admin"],"reason":"r","duration_minutes":15}`,
+	} {
+		if w := call(h.RequestElevation, caller, "", body); w.Code != http.StatusAccepted || strings.Contains(w.Body.String(), "access_token") {
+			t.Errorf("%s: expected admin to wait for approval under self-service, got %d: %s", caller.Role, w.Code, w.Body.String())
+		}
+	}
+	if w := call(h.RequestElevation, helper, "", request); w.Code != http.StatusAccepted || strings.Contains(w.Body.String(), "access_token") {
+		t.Errorf("expected a capability the role lacks to wait for approval, got %d: %s", w.Code, w.Body.String())
+	}
+
+	events, _, _ := s.ListAuditEvents(ctx, store.AuditFilter{TargetType: "elevation"})
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	if want := []string{"elevation.request", "elevation.request", "elevation.request", "elevation.token", "elevation.approve", "elevation.request", "elevation.token", "elevation.approve", "elevation.request"}; !slices.Equal(actions, want) {
+		t.Errorf("expected audit events %v, got %v", want, actions)
+	}
+}
+
+func TestAdminMergeUser(t *testing.T) {
+	h, s := setupTestHandlers()
+	ctx := context.Background()
+	target, _ := s.CreateUser(ctx, &models.User{Username: "original", Email: "o@example.com", Password: "hash", Role: "admin",
+		Metadata: map[string]interface{}{"plan": "pro"}})
+	source, _ := s.CreateUser(ctx, &models.User{Username: "social", Email: "s@example.com", Password: "!", Role: "user",
+		AvatarURL: "https://img.example.com/a.png", Metadata: map[string]interface{}{"plan": "free", "locale": "de"}})
+	if err := s.SaveRefreshToken(ctx, &models.RefreshToken{JTI: "social-session", UserID: source, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	merge := func(id int64, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/users/"+strconv.FormatInt(id, 10)+"/merge", strings.NewReader(body))
+		req.SetPathValue("id", strconv.FormatInt(id, 10))
+		w := httptest.NewRecorder()
+		h.AdminMergeUser(w, req.WithContext(context.WithValue(req.Context(), "user", &auth.Claims{UserID: "999", Role: "admin"})))
+		return w
+	}
+
+	for body, status := range map[string]int{
+		fmt.Sprintf(`{"source_id":%d,"strategy":"newest"}`, source): http.StatusBadRequest,
+		fmt.Sprintf(`{"source_id":%d}`, target):                     http.StatusBadRequest,
+		`{"source_id":12345}`:                                       http.StatusNotFound,
+	} {
+		if w := merge(target, body); w.Code != status {
+			t.Errorf("%s: expected %d, got %d", body, status, w.Code)
+		}
+	}
+
+	w := merge(target, fmt.Sprintf(`{"source_id":%d}`, source))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	u, _ := s.GetUserByID(ctx, target)
+	if u.Email != "o@example.com" || u.Role != "admin" || u.AvatarURL != "https://img.example.com/a.png" ||
+		u.Metadata["plan"] != "pro" || u.Metadata["locale"] != "de" {
+		t.Errorf("expected the target's values kept and gaps filled, got %+v", u)
+	}
+	if gone, _ := s.GetUserByID(ctx, source); gone != nil {
+		t.Error("expected the source account to be deleted")
+	}
+	if rt, _ := s.GetRefreshToken(ctx, "social-session"); rt == nil || rt.UserID != target {
+		t.Errorf("expected the session to move to the target, got %+v", rt)
+	}
+	events, _, _ := s.ListAuditEvents(ctx, store.AuditFilter{Action: auditUserMerge})
+	if len(events) != 1 || events[0].TargetID != target || !strings.Contains(fmt.Sprint(events[0].Changes), "merged_user_id") {
+		t.Errorf("expected the merge to be audited on the target, got %+v", events)
+	}
+
+	// prefer_source takes the duplicate's values where it has them
+	other, _ := s.CreateUser(ctx, &models.User{Username: "other", Email: "new@example.com", Password: "!", Role: "user"})
+	if w := merge(target, fmt.Sprintf(`{"source_id":%d,"strategy":"prefer_source"}`, other)); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if u, _ := s.GetUserByID(ctx, target); u.Email != "new@example.com" || u.Username != "original" {
+		t.Errorf("expected the source's email on the original account, got %+v", u)
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	h, _ := setupTestHandlers()
+	for _, tc := range []struct {
+		contentType, body string
+	}{
+		{"application/csp-report", `{"csp-report":{"document-uri":"https://auth.example.com/admin/?token=secret","violated-directive":"script-src-elem","blocked-uri":"inline"}}`},
+		{"application/reports+json", `[{"type":"csp-violation","body":{"documentURL":"https://auth.example.com/admin/","effectiveDirective":"img-src","blockedURL":"https://cdn.example.com/x.png"}}]`},
+		{"application/csp-report", `not json`},
+	} {
+		req := httptest.NewRequest(http.MethodPost, CSPReportPath, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+		h.CSPReport(w, req)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s: expected 204, got %d", tc.contentType, w.Code)
+		}
+	}
+
+	if got := stripQuery("https://auth.example.com/magic?token=secret#x"); got != "https://auth.example.com/magic" {
+		t.Errorf("expected the query to be stripped, got %q", got)
+	}
+	if got := stripQuery("inline"); got != "inline" {
+		t.Errorf("expected keywords to be kept, got %q", got)
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/audit"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
+)
+
+// Audit actions recorded for login attempts against an existing account.
+const (
+	auditUserLogin       = "user.login"
+	auditUserLoginFailed = "user.login.failed"
+)
+
+// loginHistoryEntry is one attempt in the GET /api/auth/login-history
+// response.
+type loginHistoryEntry struct {
+	Time      time.Time `json:"time"`
+	Success   bool      `json:"success"`
+	IP        string    `json:"ip,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	Device    string    `json:"device"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// recordLogin records a login attempt against user in the audit log,
+// through AuditLog when it is set. Failures are logged rather than
+// returned: an audit outage must not lock users out.
+func (h *Handlers) recordLogin(r *http.Request, user *models.User, action string) {
+	var recorder interface {
+		RecordAudit(ctx context.Context, e *models.AuditEvent) error
+	} = h.Store
+	if h.AuditLog != nil {
+		recorder = h.AuditLog
+	}
+	err := recorder.RecordAudit(r.Context(), &models.AuditEvent{
+		ActorID:    user.ID,
+		Action:     action,
+		TargetType: auditTargetUser,
+		TargetID:   user.ID,
+		RequestID:  tracing.RequestIDFromContext(r.Context()),
+		IP:         middleware.ClientIP(r),
+		UserAgent:  audit.UserAgent(r.UserAgent()),
+		Country:    h.requestCountry(r),
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to record login attempt", map[string]interface{}{
+			"user_id": user.ID,
+			"action":  action,
+			"error":   err.Error(),
+		})
+	}
+	if event, ok := loginEvents[action]; ok {
+		h.publishEvent(r, event, map[string]interface{}{
+			"user_id":  user.ID,
+			"username": user.Username,
+			"ip":       middleware.ClientIP(r),
+		})
+	}
+}
+
+// loginEvents maps the login audit actions that are published to webhook
+// subscriptions to their event types.
+var loginEvents = map[string]string{
+	auditUserLogin:       webhooks.EventUserLogin,
+	auditUserLoginFailed: webhooks.EventUserLoginFailed,
+}
+
+// requestCountry reads the client's country from GeoCountryHeader, which a
+// trusted proxy or CDN sets (for example CF-IPCountry).
+func (h *Handlers) requestCountry(r *http.Request) string {
+	if h.GeoCountryHeader == "" {
+		return ""
+	}
+	c := strings.ToUpper(strings.TrimSpace(r.Header.Get(h.GeoCountryHeader)))
+	if len(c) != 2 || c == "XX" {
+		return ""
+	}
+	return c
+}
+
+// LoginHistory handles GET /api/auth/login-history, returning the caller's
+// recent successful and failed logins newest first, paginated with limit
+// and offset.
+func (h *Handlers) LoginHistory(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	limit, offset, ok := parsePagination(w, r)
+	if !ok {
+		return
+	}
+
+	events, total, err := h.Store.ListAuditEvents(r.Context(), store.AuditFilter{
+		TargetType: auditTargetUser,
+		TargetID:   user.ID,
+		Actions:    []string{auditUserLogin, auditUserLoginFailed},
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Login history query failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logins := make([]loginHistoryEntry, 0, len(events))
+	for _, e := range events {
+		logins = append(logins, loginHistoryEntry{
+			Time:      e.CreatedAt,
+			Success:   e.Action == auditUserLogin,
+			IP:        e.IP,
+			Country:   e.Country,
+			Device:    audit.Device(e.UserAgent),
+			UserAgent: e.UserAgent,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"logins": logins,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/canary"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/mail"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/validation"
+)
+
+// DefaultMagicLinkTTL is how long an emailed sign-in link is valid when
+// no lifetime is configured.
+const DefaultMagicLinkTTL = 15 * time.Minute
+
+// magicLinkResendInterval is the minimum time between links emailed to
+// one account, however many clients ask.
+const magicLinkResendInterval = time.Minute
+
+// magicLinkCookie binds a link to the browser that requested it when
+// device binding is on.
+const (
+	magicLinkCookie     = "sentinel_magic_link"
+	magicLinkCookiePath = "/api/auth/magic-link"
+)
+
+// auditUserMagicLinkRequest records a sign-in link emailed to a user.
+const auditUserMagicLinkRequest = "user.magic_link.request"
+
+// magicLinkRequest is the payload for POST /api/auth/magic-link.
+type magicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// magicLinkVerifyRequest is the payload for POST
+// /api/auth/magic-link/verify, which also accepts the token as a ?token=
+// query parameter.
+type magicLinkVerifyRequest struct {
+	Token string `json:"token"`
+	// OTPCode is required for accounts with the SMS second factor.
+	OTPCode string `json:"otp_code,omitempty"`
+	// ClientID and Scope name the client signing in, as at login.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// magicLinkTTL returns the configured link lifetime or the default.
+func (h *Handlers) magicLinkTTL() time.Duration {
+	if h.MagicLinkTTL <= 0 {
+		return DefaultMagicLinkTTL
+	}
+	return h.MagicLinkTTL
+}
+
+// magicLinksEnabled writes an error and returns false unless magic links
+// are enabled and email can be sent.
+func (h *Handlers) magicLinksEnabled(w http.ResponseWriter) bool {
+	if !h.MagicLinkEnabled || h.Mailer == nil {
+		writeErrorResponse(w, "Magic link login is not enabled", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// setMagicLinkCookie sets, or with an empty value clears, the device
+// binding cookie.
+func (h *Handlers) setMagicLinkCookie(w http.ResponseWriter, r *http.Request, value string, maxAge time.Duration) {
+	c := &http.Cookie{
+		Name:     magicLinkCookie,
+		Value:    value,
+		Path:     magicLinkCookiePath,
+		MaxAge:   int(maxAge / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(h.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// RequestMagicLink handles POST /api/auth/magic-link. It emails a
+// single-use sign-in link to the account with the given address. The
+// response is the same whether or not such an account exists, so it
+// cannot be used to discover addresses.
+func (h *Handlers) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if !h.magicLinksEnabled(w) {
+		return
+	}
+	var req magicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Email = validation.SanitizeInput(req.Email)
+	if err := validation.ValidateEmail(req.Email); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The cookie is set for unknown addresses too, for the same reason.
+	var deviceHash string
+	if h.MagicLinkDeviceBinding {
+		nonce, err := auth.GenerateLinkToken()
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		deviceHash = auth.HashLinkToken(nonce)
+		h.setMagicLinkCookie(w, r, nonce, h.magicLinkTTL())
+	}
+	if err := h.sendMagicLink(r, req.Email, deviceHash); err != nil {
+		logger.FromContext(r.Context()).Error("Failed to send magic link", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	// Sending a link takes longer than finding no account
+	if h.EnumerationProtection {
+		padResponse(r, start)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"message": "If the address belongs to an account, a sign-in link has been sent",
+	})
+}
+
+// sendMagicLink emails a new link to the enabled account with address
+// email, if there is one and it was not sent a link within
+// magicLinkResendInterval.
+func (h *Handlers) sendMagicLink(r *http.Request, email, deviceHash string) error {
+	user, err := h.Store.GetUserByEmail(r.Context(), email)
+	if err != nil || user == nil || user.Disabled || user.Expired(time.Now()) {
+		return err
+	}
+	if h.canaryTripped(r, user, canary.ViaMagicLink) {
+		return nil
+	}
+	now := time.Now().UTC()
+	prev, err := h.Store.GetMagicLink(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+	if prev != nil && now.Sub(prev.CreatedAt) < magicLinkResendInterval {
+		logger.FromContext(r.Context()).Warn("Magic link requested again too soon", map[string]interface{}{
+			"user_id": user.ID,
+		})
+		return nil
+	}
+
+	token, err := auth.GenerateLinkToken()
+	if err != nil {
+		return err
+	}
+	link := &models.MagicLink{
+		UserID:     user.ID,
+		TokenHash:  auth.HashLinkToken(token),
+		DeviceHash: deviceHash,
+		ExpiresAt:  now.Add(h.magicLinkTTL()),
+		CreatedAt:  now,
+	}
+	if err := h.Store.SaveMagicLink(r.Context(), link); err != nil {
+		return err
+	}
+	h.recordLogin(r, user, auditUserMagicLinkRequest)
+	logger.FromContext(r.Context()).Info("Magic link sent", map[string]interface{}{
+		"user_id": user.ID,
+	})
+	h.notifyUser(r, user, mail.TemplateMagicLink, map[string]interface{}{
+		"Link":      h.emailLink("/api/auth/magic-link/verify", token),
+		"ExpiresIn": formatDuration(h.magicLinkTTL()),
+	})
+	return nil
+}
+
+// VerifyMagicLink handles GET and POST /api/auth/magic-link/verify, the
+// emailed link. It exchanges the link's token for access and refresh
+// tokens, as a password login would. Accounts with the SMS second factor
+// must also send otp_code, by POST; the link stays valid until then.
+func (h *Handlers) VerifyMagicLink(w http.ResponseWriter, r *http.Request) {
+	if !h.magicLinksEnabled(w) {
+		return
+	}
+	var req magicLinkVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		req.Token = token
+	}
+	req.Token = strings.TrimSpace(req.Token)
+	req.OTPCode = validation.SanitizeInput(req.OTPCode)
+	clientID, scope, ok := h.clientScope(w, req.ClientID, req.Scope)
+	if !ok {
+		return
+	}
+	jkt, ok := h.proofKey(w, r, "")
+	if !ok {
+		return
+	}
+
+	hash := auth.HashLinkToken(req.Token)
+	link, err := h.Store.GetMagicLinkByToken(r.Context(), hash)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if req.Token == "" || link == nil || !time.Now().Before(link.ExpiresAt) {
+		writeErrorResponse(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+	user, err := h.Store.GetUserByID(r.Context(), link.UserID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		writeErrorResponse(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+
+	// A link opened in another browser may have been intercepted, so it
+	// is spent rather than left for a second try.
+	if link.DeviceHash != "" {
+		c, err := r.Cookie(magicLinkCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(auth.HashLinkToken(c.Value)), []byte(link.DeviceHash)) != 1 {
+			_, _ = h.Store.UseMagicLink(r.Context(), hash)
+			loginAttempts.WithLabelValues("failure").Inc()
+			h.recordLogin(r, user, auditUserLoginFailed)
+			writeErrorResponse(w, "Open the link in the browser that requested it", http.StatusForbidden)
+			return
+		}
+	}
+	if user.Disabled {
+		_, _ = h.Store.UseMagicLink(r.Context(), hash)
+		loginAttempts.WithLabelValues("disabled").Inc()
+		h.recordLogin(r, user, auditUserLoginFailed)
+		writeErrorResponse(w, "Account is disabled", http.StatusForbidden)
+		return
+	}
+	if user.Expired(time.Now()) {
+		_, _ = h.Store.UseMagicLink(r.Context(), hash)
+		loginAttempts.WithLabelValues("expired").Inc()
+		h.recordLogin(r, user, auditUserLoginFailed)
+		writeErrorResponse(w, "Account has expired", http.StatusForbidden)
+		return
+	}
+	if user.SMSOTP && !h.requireLoginOTP(w, r, user, req.OTPCode) {
+		return
+	}
+
+	used, err := h.Store.UseMagicLink(r.Context(), hash)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !used {
+		writeErrorResponse(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+	response, ok := h.startSession(w, r, user, auth.Grant{JKT: jkt, ClientID: clientID, Scope: scope})
+	if !ok {
+		return
+	}
+	if link.DeviceHash != "" {
+		h.setMagicLinkCookie(w, r, "", 0)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, response)
+}
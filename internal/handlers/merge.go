@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
+)
+
+// Strategies for fields that both accounts of a merge have set.
+const (
+	// mergeKeepTarget keeps the surviving account's values and only fills
+	// in what it lacks.
+	mergeKeepTarget = "keep_target"
+	// mergePreferSource takes the merged account's values wherever it has
+	// them.
+	mergePreferSource = "prefer_source"
+)
+
+// mergeRequest is the payload for POST /api/admin/users/{id}/merge.
+type mergeRequest struct {
+	SourceID int64  `json:"source_id"`
+	Strategy string `json:"strategy"`
+}
+
+// AdminMergeUser handles POST /api/admin/users/{id}/merge, which merges the
+// duplicate account source_id into {id}. The duplicate's sessions and
+// audit history move to {id}, its email, phone, avatar, and metadata are
+// combined with {id}'s according to strategy, and it is deleted. {id}
+// keeps its username, password, role, and disabled state.
+func (h *Handlers) AdminMergeUser(w http.ResponseWriter, r *http.Request) {
+	target, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+	var req mergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.Strategy == "" {
+		req.Strategy = mergeKeepTarget
+	}
+	switch {
+	case req.Strategy != mergeKeepTarget && req.Strategy != mergePreferSource:
+		writeErrorResponse(w, "strategy must be keep_target or prefer_source", http.StatusBadRequest)
+		return
+	case req.SourceID <= 0:
+		writeErrorResponse(w, "source_id must be a positive integer", http.StatusBadRequest)
+		return
+	case req.SourceID == target.ID:
+		writeErrorResponse(w, "cannot merge an account into itself", http.StatusBadRequest)
+		return
+	case req.SourceID == callerID(r):
+		writeErrorResponse(w, "cannot merge away your own account", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	source, err := h.Store.GetUserByID(ctx, req.SourceID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if source == nil {
+		writeErrorResponse(w, "Source user not found", http.StatusNotFound)
+		return
+	}
+
+	merged := mergeUsers(target, source, req.Strategy)
+	var counts store.MergeCounts
+	err = h.Store.WithTx(ctx, func(tx store.Store) error {
+		var err error
+		if counts, err = tx.MergeUsers(ctx, source.ID, target.ID); err != nil {
+			return err
+		}
+		// The source is gone, so its email and phone are free to take
+		if err := tx.UpdateUser(ctx, merged); err != nil {
+			return err
+		}
+		e := userAuditEvent(r, auditUserMerge, target, merged)
+		e.Changes = append(e.Changes,
+			models.FieldChange{Field: "merged_user_id", After: source.ID},
+			models.FieldChange{Field: "merged_username", After: source.Username},
+			models.FieldChange{Field: "strategy", After: req.Strategy},
+		)
+		return tx.RecordAudit(ctx, e)
+	})
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	case errors.Is(err, store.ErrVersionConflict):
+		writeErrorResponse(w, "User was modified concurrently; retry the merge", http.StatusConflict)
+		return
+	case err != nil:
+		logger.FromContext(ctx).Error("User merge failed", map[string]interface{}{
+			"user_id":   target.ID,
+			"source_id": source.ID,
+			"error":     err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.FromContext(ctx).Info("Users merged", map[string]interface{}{
+		"user_id":        target.ID,
+		"source_id":      source.ID,
+		"admin_id":       callerID(r),
+		"refresh_tokens": counts.RefreshTokens,
+		"audit_events":   counts.AuditEvents,
+	})
+	h.notifyLogout(r, source.ID)
+	h.publishEvent(r, webhooks.EventUserMerge, map[string]interface{}{
+		"user_id":        target.ID,
+		"merged_user_id": source.ID,
+		"admin_id":       callerID(r),
+	})
+	w.Header().Set("ETag", userETag(merged))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user":  adminView(merged),
+		"moved": counts,
+	})
+}
+
+// mergeUsers returns target with source's email, phone, avatar, and
+// metadata combined into it by strategy. The SMS OTP setting follows the
+// phone number it belongs to.
+func mergeUsers(target, source *models.User, strategy string) *models.User {
+	merged := snapshotUser(target)
+	merged.Metadata = maps.Clone(target.Metadata)
+	take := func(mine, theirs string) bool {
+		return theirs != "" && (mine == "" || strategy == mergePreferSource)
+	}
+	if take(merged.Email, source.Email) {
+		merged.Email = source.Email
+	}
+	if take(merged.AvatarURL, source.AvatarURL) {
+		merged.AvatarURL = source.AvatarURL
+	}
+	if take(merged.Phone, source.Phone) {
+		merged.Phone, merged.SMSOTP = source.Phone, source.SMSOTP
+	}
+	for k, v := range source.Metadata {
+		if _, ok := merged.Metadata[k]; !ok || strategy == mergePreferSource {
+			if merged.Metadata == nil {
+				merged.Metadata = make(map[string]interface{})
+			}
+			merged.Metadata[k] = v
+		}
+	}
+	return merged
+}
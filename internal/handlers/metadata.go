@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metadata"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+// UpdateProfileMetadata handles PATCH /api/auth/profile/metadata. The body is
+// a JSON object merged into the user's metadata; null values delete keys.
+// Only keys with user write access may be changed. An If-Match header, when
+// sent, must carry the profile's current ETag.
+func (h *Handlers) UpdateProfileMetadata(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !h.patchMetadata(w, r, user, false) {
+		return
+	}
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"metadata": h.metadataPolicies().VisibleToUser(user.Metadata),
+	})
+}
+
+// patchMetadata decodes a merge patch from r, applies it to user with the
+// given privileges, and persists the result. Admin patches must carry an
+// If-Match precondition. On failure it writes the error response and returns
+// false.
+func (h *Handlers) patchMetadata(w http.ResponseWriter, r *http.Request, user *models.User, asAdmin bool) bool {
+	if !checkIfMatch(w, r, user, asAdmin) {
+		return false
+	}
+
+	var patch map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		writeErrorResponse(w, "Request body must be a JSON object", http.StatusBadRequest)
+		return false
+	}
+
+	updated, err := h.metadataPolicies().ApplyPatch(user.Metadata, patch, asAdmin)
+	if err != nil {
+		var policyErr *metadata.PolicyError
+		if errors.As(err, &policyErr) && policyErr.Forbidden {
+			writeErrorResponse(w, err.Error(), http.StatusForbidden)
+			return false
+		}
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	// Admin changes are recorded in the audit log in the same transaction.
+	before := snapshotUser(user)
+	user.Metadata = updated
+	err = h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.UpdateUser(r.Context(), user); err != nil {
+			return err
+		}
+		if !asAdmin {
+			return nil
+		}
+		return recordUserAudit(r.Context(), tx, r, auditUserMetadataUpdate, before, user)
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Metadata update failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeUpdateError(w, err, "Failed to update metadata")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	"github.com/mayvqt/Sentinel/internal/mtls"
+)
+
+var (
+	loginAttempts = metrics.NewCounterVec(
+		"sentinel_login_attempts_total",
+		"Login attempts by result (success, failure, disabled, expired).",
+		"result",
+	)
+	registrations = metrics.NewCounterVec(
+		"sentinel_registrations_total",
+		"Successful user registrations.",
+	)
+	refreshReplaysTotal = metrics.NewCounterVec(
+		"sentinel_refresh_replays_total",
+		"Retried refreshes answered with the pair already issued, within REFRESH_REUSE_GRACE.",
+	)
+	sessionIdleTimeouts = metrics.NewCounterVec(
+		"sentinel_session_idle_timeouts_total",
+		"Refreshes refused because the session was unused for SESSION_IDLE_TIMEOUT.",
+	)
+)
+
+// Metrics serves process metrics in the Prometheus text format. When
+// MetricsToken is configured, scrapers must send it as a bearer token or
+// present a client certificate whose service account has the metrics scope.
+func (h *Handlers) Metrics(w http.ResponseWriter, r *http.Request) {
+	if h.MetricsToken != "" && !mtls.FromContext(r.Context()).HasScope(mtls.ScopeMetrics) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.MetricsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			writeErrorResponse(w, "Invalid metrics token", http.StatusUnauthorized)
+			return
+		}
+	}
+	metrics.Default.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
+)
+
+// notifyTimeout bounds delivery of a single notification email.
+const notifyTimeout = 30 * time.Second
+
+// notifyUser emails user the named template in the background so SMTP
+// latency never delays the response. data holds the template's own
+// variables; Username is added. It is a no-op when no Mailer is configured
+// or the user has no email address; rendering and delivery failures are
+// logged. Emails are rendered in the user's preferred locale.
+func (h *Handlers) notifyUser(r *http.Request, user *models.User, template string, data map[string]interface{}) {
+	h.sendEmail(r.Context(), user, user.Email, template, data)
+}
+
+// sendEmail is notifyUser for an explicit address, such as one the user is
+// moving to or away from, and for work done outside a request.
+func (h *Handlers) sendEmail(ctx context.Context, user *models.User, to, template string, data map[string]interface{}) {
+	if h.Mailer == nil || to == "" {
+		return
+	}
+	locale := h.preferences(ctx, user.ID).Locale
+	vars := map[string]interface{}{"Username": user.Username, "Locale": locale}
+	for k, v := range data {
+		vars[k] = v
+	}
+	msg, err := h.templates().Render(template, to, vars)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to render notification email", map[string]interface{}{
+			"user_id":  user.ID,
+			"template": template,
+			"error":    err.Error(),
+		})
+		return
+	}
+	msg.Language = locale
+	ctx = context.WithoutCancel(ctx)
+	userID := user.ID
+	h.background.Go(func() {
+		ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		defer cancel()
+		if err := h.Mailer.Send(ctx, msg); err != nil {
+			logger.FromContext(ctx).Error("Failed to send notification email", map[string]interface{}{
+				"user_id":  userID,
+				"template": template,
+				"error":    err.Error(),
+			})
+		}
+	})
+}
+
+// notifyLogout tells back-channel logout clients, in the background, that
+// the sessions of the given users have ended. It is a no-op when no
+// clients are registered; delivery failures are logged.
+func (h *Handlers) notifyLogout(r *http.Request, userIDs ...int64) {
+	if h.Backchannel.Len() == 0 || len(userIDs) == 0 {
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+	h.background.Go(func() {
+		for _, id := range userIDs {
+			if err := h.Backchannel.Notify(ctx, strconv.FormatInt(id, 10)); err != nil {
+				logger.FromContext(ctx).Error("Back-channel logout failed", map[string]interface{}{
+					"user_id": id,
+					"error":   err.Error(),
+				})
+			}
+		}
+	})
+}
+
+// Drain waits until work that requests left running in the background,
+// such as notification emails and webhook replays, has finished, or until
+// ctx is done.
+func (h *Handlers) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// publishEvent adds an event to the webhook outbox, from which it is
+// delivered to the subscriptions in the background, so a slow endpoint
+// never delays the response. It is a no-op when webhooks are not
+// configured; failures are logged.
+func (h *Handlers) publishEvent(r *http.Request, event string, data map[string]interface{}) {
+	if h.Webhooks == nil {
+		return
+	}
+	// A client disconnecting must not lose an event for a completed action
+	ctx := context.WithoutCancel(r.Context())
+	if err := h.Webhooks.Publish(ctx, webhooks.Event{Type: event, Data: data}); err != nil {
+		logger.FromContext(ctx).Error("Failed to publish webhook event", map[string]interface{}{
+			"event": event,
+			"error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mayvqt/Sentinel/internal/flags"
+	"github.com/mayvqt/Sentinel/internal/validation"
+)
+
+type passwordStrengthRequest struct {
+	Password string `json:"password"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// passwordStrengthResponse is the response of POST
+// /api/auth/password-strength. Pass is whether registration would accept
+// the password, and Error is the message it would reject it with.
+type passwordStrengthResponse struct {
+	Pass     bool   `json:"pass"`
+	Error    string `json:"error,omitempty"`
+	MinScore int    `json:"min_score"`
+	validation.PasswordStrength
+}
+
+// checkPassword applies the server's password policy beyond the basic
+// rules of validation.ValidatePassword: the context check, when its flag
+// is on, and PasswordMinScore.
+func (h *Handlers) checkPassword(password, username, email string) error {
+	// No account exists yet, so the rollout is keyed by the username
+	if h.Flags.Enabled(flags.PasswordContext, "username:"+strings.ToLower(username)) {
+		if err := validation.ValidatePasswordContext(password, username, email); err != nil {
+			return err
+		}
+	}
+	return validation.ValidatePasswordStrength(password, h.PasswordMinScore, username, email)
+}
+
+// PasswordStrength handles POST /api/auth/password-strength, giving sign-up
+// forms the verdict registration will reach on a password along with a
+// strength estimate and suggestions. The username and email are optional
+// but make the verdict exact, since passwords built from them score lower.
+func (h *Handlers) PasswordStrength(w http.ResponseWriter, r *http.Request) {
+	var req passwordStrengthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Password = validation.SanitizeInput(req.Password)
+	req.Username = validation.SanitizeInput(req.Username)
+	req.Email = validation.SanitizeInput(req.Email)
+	if req.Password == "" {
+		writeErrorResponse(w, "Password is required", http.StatusBadRequest)
+		return
+	}
+
+	resp := passwordStrengthResponse{
+		MinScore:         h.PasswordMinScore,
+		PasswordStrength: validation.EstimatePasswordStrength(req.Password, req.Username, req.Email),
+	}
+	err := validation.ValidatePassword(req.Password)
+	if err == nil {
+		err = h.checkPassword(req.Password, req.Username, req.Email)
+	}
+	resp.Pass = err == nil
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/sms"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/validation"
+)
+
+// One-time code limits: a code is valid for otpTTL and otpMaxAttempts
+// guesses, and a new one is not sent within otpResendInterval of the last.
+const (
+	otpTTL            = 10 * time.Minute
+	otpMaxAttempts    = 5
+	otpResendInterval = 30 * time.Second
+)
+
+// Audit actions recorded for a user's own phone settings.
+const (
+	auditUserPhoneVerify = "user.phone.verify"
+	auditUserPhoneRemove = "user.phone.remove"
+	auditUserSMSOTP      = "user.sms_otp.update"
+)
+
+var (
+	// errOTPTooSoon rejects a code request made within otpResendInterval
+	// of the previous one.
+	errOTPTooSoon = errors.New("a code was sent recently")
+	// errOTPInvalid covers wrong, expired, exhausted, and missing codes.
+	errOTPInvalid = errors.New("invalid or expired code")
+)
+
+// phoneRequest is the payload for POST /api/auth/phone.
+type phoneRequest struct {
+	Phone string `json:"phone"`
+}
+
+// otpCodeRequest is the payload for POST /api/auth/phone/verify.
+type otpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// passwordRequest confirms a sensitive change with the caller's password.
+type passwordRequest struct {
+	Password string `json:"password"`
+}
+
+// smsOTPRequest is the payload for POST /api/auth/sms-otp.
+type smsOTPRequest struct {
+	Enabled  bool   `json:"enabled"`
+	Password string `json:"password"`
+}
+
+// sendOTP replaces the user's challenge for purpose with a new code and
+// texts it to phone, returning when the code expires. It returns
+// errOTPTooSoon if a code for the same purpose and phone was sent less
+// than otpResendInterval ago.
+func (h *Handlers) sendOTP(r *http.Request, user *models.User, purpose, phone string) (time.Time, error) {
+	now := time.Now().UTC()
+	prev, err := h.Store.GetOTPChallenge(r.Context(), user.ID, purpose)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if prev != nil && prev.Phone == phone && now.Sub(prev.CreatedAt) < otpResendInterval {
+		return prev.ExpiresAt, errOTPTooSoon
+	}
+
+	code, err := auth.GenerateOTP()
+	if err != nil {
+		return time.Time{}, err
+	}
+	challenge := &models.OTPChallenge{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Phone:     phone,
+		CodeHash:  auth.HashOTP(user.ID, code),
+		ExpiresAt: now.Add(otpTTL),
+		CreatedAt: now,
+	}
+	if err := h.Store.SaveOTPChallenge(r.Context(), challenge); err != nil {
+		return time.Time{}, err
+	}
+
+	body := fmt.Sprintf("Your %s code is %s. It expires in %d minutes. Don't share it with anyone.",
+		h.templates().AppName(), code, int(otpTTL/time.Minute))
+	ctx, cancel := context.WithTimeout(r.Context(), sms.SendTimeout)
+	defer cancel()
+	if err := h.SMS.Send(ctx, sms.Message{To: phone, Body: body}); err != nil {
+		// Drop the undelivered code so the user can ask again immediately.
+		_ = h.Store.DeleteOTPChallenge(r.Context(), user.ID, purpose)
+		return time.Time{}, fmt.Errorf("failed to send code: %w", err)
+	}
+	return challenge.ExpiresAt, nil
+}
+
+// checkOTP verifies code against the user's challenge for purpose and
+// consumes it on success. Every wrong guess counts against
+// otpMaxAttempts; once they are used up the challenge is discarded.
+func (h *Handlers) checkOTP(ctx context.Context, userID int64, purpose, code string) (*models.OTPChallenge, error) {
+	c, err := h.Store.GetOTPChallenge(ctx, userID, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil || code == "" {
+		return nil, errOTPInvalid
+	}
+	if !time.Now().Before(c.ExpiresAt) || c.Attempts >= otpMaxAttempts {
+		_ = h.Store.DeleteOTPChallenge(ctx, userID, purpose)
+		return nil, errOTPInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(c.CodeHash), []byte(auth.HashOTP(userID, code))) != 1 {
+		c.Attempts++
+		if c.Attempts >= otpMaxAttempts {
+			err = h.Store.DeleteOTPChallenge(ctx, userID, purpose)
+		} else {
+			err = h.Store.SaveOTPChallenge(ctx, c)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, errOTPInvalid
+	}
+	if err := h.Store.DeleteOTPChallenge(ctx, userID, purpose); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// writeOTPSendError reports a failed sendOTP.
+func writeOTPSendError(w http.ResponseWriter, r *http.Request, userID int64, err error) {
+	if errors.Is(err, errOTPTooSoon) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(otpResendInterval/time.Second)))
+		writeErrorResponse(w, "A code was sent recently; wait before requesting another", http.StatusTooManyRequests)
+		return
+	}
+	logger.FromContext(r.Context()).Error("Failed to send verification code", map[string]interface{}{
+		"user_id": userID,
+		"error":   err.Error(),
+	})
+	writeErrorResponse(w, "Failed to send verification code", http.StatusBadGateway)
+}
+
+// phoneLoginEnabled reports whether phone numbers may be used to register
+// and sign in, which needs SMS to verify them.
+func (h *Handlers) phoneLoginEnabled() bool {
+	return h.PhoneLogin && h.SMS != nil
+}
+
+// smsEnabled writes an error and returns false when no SMS provider is
+// configured.
+func (h *Handlers) smsEnabled(w http.ResponseWriter) bool {
+	if h.SMS == nil {
+		writeErrorResponse(w, "SMS is not enabled", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// StartPhoneVerification handles POST /api/auth/phone. It texts a code to
+// the given E.164 number; the number is saved to the account only once
+// the code is confirmed through VerifyPhone.
+func (h *Handlers) StartPhoneVerification(w http.ResponseWriter, r *http.Request) {
+	if !h.smsEnabled(w) {
+		return
+	}
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req phoneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Phone = validation.SanitizeInput(req.Phone)
+	if err := validation.ValidatePhone(req.Phone); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Phone == user.Phone {
+		writeErrorResponse(w, "Phone number is already verified", http.StatusBadRequest)
+		return
+	}
+	owner, err := h.Store.GetUserByPhone(r.Context(), req.Phone)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if owner != nil {
+		writeErrorResponse(w, "Phone number already in use", http.StatusConflict)
+		return
+	}
+
+	expiresAt, err := h.sendOTP(r, user, models.OTPPurposePhoneVerify, req.Phone)
+	if err != nil {
+		writeOTPSendError(w, r, user.ID, err)
+		return
+	}
+	logger.FromContext(r.Context()).Info("Phone verification code sent", map[string]interface{}{
+		"user_id": user.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"phone":      req.Phone,
+		"expires_at": expiresAt,
+	})
+}
+
+// VerifyPhone handles POST /api/auth/phone/verify, saving the number a
+// code was sent to once the caller confirms the code.
+func (h *Handlers) VerifyPhone(w http.ResponseWriter, r *http.Request) {
+	if !h.smsEnabled(w) {
+		return
+	}
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req otpCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	challenge, err := h.checkOTP(r.Context(), user.ID, models.OTPPurposePhoneVerify, validation.SanitizeInput(req.Code))
+	if errors.Is(err, errOTPInvalid) {
+		writeErrorResponse(w, "Invalid or expired code", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	before := snapshotUser(user)
+	user.Phone = challenge.Phone
+	if err := h.updateOwnUser(r, before, user, auditUserPhoneVerify); err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			// Another account verified the number after the code was sent.
+			writeErrorResponse(w, "Phone number already in use", http.StatusConflict)
+			return
+		}
+		writeUpdateError(w, err, "Failed to save phone number")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.profileView(user))
+}
+
+// RemovePhone handles DELETE /api/auth/phone. After the caller confirms
+// their password it removes the number and turns off the SMS second
+// factor.
+func (h *Handlers) RemovePhone(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req passwordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Password = validation.SanitizeInput(req.Password)
+	if req.Password == "" || auth.CheckPassword(user.Password, req.Password) != nil {
+		writeErrorResponse(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+	if user.Phone == "" {
+		writeErrorResponse(w, "No phone number is set", http.StatusNotFound)
+		return
+	}
+
+	before := snapshotUser(user)
+	user.Phone = ""
+	user.SMSOTP = false
+	if err := h.updateOwnUser(r, before, user, auditUserPhoneRemove); err != nil {
+		writeUpdateError(w, err, "Failed to remove phone number")
+		return
+	}
+	_ = h.Store.DeleteOTPChallenge(r.Context(), user.ID, models.OTPPurposeLogin)
+	writeJSON(w, http.StatusOK, h.profileView(user))
+}
+
+// SetSMSOTP handles POST /api/auth/sms-otp. After the caller confirms
+// their password it turns the SMS second factor on or off; turning it on
+// requires a verified phone number.
+func (h *Handlers) SetSMSOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req smsOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Password = validation.SanitizeInput(req.Password)
+	if req.Password == "" || auth.CheckPassword(user.Password, req.Password) != nil {
+		writeErrorResponse(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+	if req.Enabled && (h.SMS == nil || user.Phone == "") {
+		writeErrorResponse(w, "A verified phone number is required", http.StatusBadRequest)
+		return
+	}
+
+	if user.SMSOTP != req.Enabled {
+		before := snapshotUser(user)
+		user.SMSOTP = req.Enabled
+		if err := h.updateOwnUser(r, before, user, auditUserSMSOTP); err != nil {
+			writeUpdateError(w, err, "Failed to update SMS verification")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, h.profileView(user))
+}
+
+// updateOwnUser saves a change the caller made to their own account and
+// audits it under action.
+func (h *Handlers) updateOwnUser(r *http.Request, before, user *models.User, action string) error {
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.UpdateUser(r.Context(), user); err != nil {
+			return err
+		}
+		return recordUserAudit(r.Context(), tx, r, action, before, user)
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to update user", map[string]interface{}{
+			"user_id": user.ID,
+			"action":  action,
+			"error":   err.Error(),
+		})
+		return err
+	}
+	logger.FromContext(r.Context()).Info("User updated", map[string]interface{}{
+		"user_id": user.ID,
+		"action":  action,
+	})
+	return nil
+}
+
+// requireLoginOTP enforces the SMS second factor on a password login. With
+// no code it texts one to the user's phone and asks for it; otherwise it
+// checks the code. It returns false, having written the response, unless
+// the login may proceed.
+func (h *Handlers) requireLoginOTP(w http.ResponseWriter, r *http.Request, user *models.User, code string) bool {
+	if h.SMS == nil {
+		writeErrorResponse(w, "SMS verification is unavailable; sign in with a recovery code", http.StatusServiceUnavailable)
+		return false
+	}
+	if code == "" {
+		expiresAt, err := h.sendOTP(r, user, models.OTPPurposeLogin, user.Phone)
+		if err != nil && !errors.Is(err, errOTPTooSoon) {
+			writeOTPSendError(w, r, user.ID, err)
+			return false
+		}
+		writeOTPRequired(w, "Enter the code sent to your phone", user.Phone, expiresAt)
+		return false
+	}
+
+	_, err := h.checkOTP(r.Context(), user.ID, models.OTPPurposeLogin, code)
+	if errors.Is(err, errOTPInvalid) {
+		loginAttempts.WithLabelValues("failure").Inc()
+		h.tarpitFail(r, tarpitKeys(r, user, ""))
+		h.recordLogin(r, user, auditUserLoginFailed)
+		writeOTPRequired(w, "Invalid or expired code", user.Phone, time.Time{})
+		return false
+	}
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// writeOTPRequired answers a login that needs an SMS code. The response
+// carries the phone number's last digits so the user knows where to look.
+func writeOTPRequired(w http.ResponseWriter, message, phone string, expiresAt time.Time) {
+	resp := map[string]interface{}{
+		"error":        http.StatusText(http.StatusUnauthorized),
+		"message":      message,
+		"otp_required": true,
+		"phone_hint":   phoneHint(phone),
+	}
+	if !expiresAt.IsZero() {
+		resp["expires_at"] = expiresAt
+	}
+	writeJSON(w, http.StatusUnauthorized, resp)
+}
+
+// phoneHint masks all but the last two digits of phone.
+func phoneHint(phone string) string {
+	if len(phone) <= 2 {
+		return phone
+	}
+	return strings.Repeat("•", len(phone)-2) + phone[len(phone)-2:]
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/audit"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/mail"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+// minSessionTimeout is the shortest session timeout a user may choose.
+const minSessionTimeout = 5 * time.Minute
+
+// loginAlertHistory is how many of a user's recent logins a new one is
+// compared with before alerting.
+const loginAlertHistory = 50
+
+// localeTag matches the BCP 47 language tags accepted as locales: a
+// language subtag followed by optional script, region, or variant subtags.
+var localeTag = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8}){0,3}$`)
+
+// GetPreferences handles GET /api/auth/preferences, returning the caller's
+// notification and security preferences, or the defaults if they never
+// saved any.
+func (h *Handlers) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	p, err := h.Store.GetPreferences(r.Context(), user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if p == nil {
+		p = &models.Preferences{}
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// UpdatePreferences handles PUT /api/auth/preferences, replacing the
+// caller's preferences with the request body. Fields left out take their
+// defaults; unknown fields are rejected so that typos are not silently
+// ignored.
+func (h *Handlers) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var p models.Preferences
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		writeErrorResponse(w, "Invalid preferences: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg := h.validatePreferences(&p); msg != "" {
+		writeErrorResponse(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Store.SetPreferences(r.Context(), user.ID, &p); err != nil {
+		logger.FromContext(r.Context()).Error("Preferences update failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to update preferences", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, &p)
+}
+
+// validatePreferences returns why p cannot be saved, or "" if it can.
+func (h *Handlers) validatePreferences(p *models.Preferences) string {
+	if p.SessionTimeoutMinutes != 0 {
+		timeout := time.Duration(p.SessionTimeoutMinutes) * time.Minute
+		if timeout < minSessionTimeout || timeout > h.refreshTokenTTL() {
+			return "session_timeout_minutes must be 0 or between " +
+				strconv.Itoa(int(minSessionTimeout/time.Minute)) + " and " +
+				strconv.Itoa(int(h.refreshTokenTTL()/time.Minute))
+		}
+	}
+	if p.Locale != "" && !localeTag.MatchString(p.Locale) {
+		return "locale must be a BCP 47 language tag such as \"en\" or \"pt-BR\""
+	}
+	return ""
+}
+
+// preferences returns userID's preferences, or the defaults if they never
+// saved any or they cannot be loaded; load failures are logged.
+func (h *Handlers) preferences(ctx context.Context, userID int64) models.Preferences {
+	p, err := h.Store.GetPreferences(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to load preferences", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return models.Preferences{}
+	}
+	if p == nil {
+		return models.Preferences{}
+	}
+	return *p
+}
+
+// refreshTokenTTL returns the server's refresh token lifetime.
+func (h *Handlers) refreshTokenTTL() time.Duration {
+	if h.RefreshTokenTTL <= 0 {
+		return DefaultRefreshTokenTTL
+	}
+	return h.RefreshTokenTTL
+}
+
+// sessionTTL returns the refresh token lifetime for userID's sessions
+// started by client, nil for none: the client's or else the server's,
+// shortened by the user's session timeout preference.
+func (h *Handlers) sessionTTL(r *http.Request, userID int64, client *models.Client) time.Duration {
+	ttl := h.refreshTokenTTL()
+	if client != nil && client.RefreshTokenTTL > 0 {
+		ttl = time.Duration(client.RefreshTokenTTL) * time.Second
+	}
+	if minutes := h.preferences(r.Context(), userID).SessionTimeoutMinutes; minutes > 0 {
+		ttl = min(ttl, time.Duration(minutes)*time.Minute)
+	}
+	return ttl
+}
+
+// alertNewLogin emails user about the login r when they asked for login
+// alerts and none of their recent logins came from the same address and
+// device. The first login ever recorded is not alerted about.
+func (h *Handlers) alertNewLogin(r *http.Request, user *models.User) {
+	if h.Mailer == nil || user.Email == "" || !h.preferences(r.Context(), user.ID).LoginAlerts {
+		return
+	}
+	events, _, err := h.Store.ListAuditEvents(r.Context(), store.AuditFilter{
+		TargetType: auditTargetUser,
+		TargetID:   user.ID,
+		Actions:    []string{auditUserLogin},
+		Limit:      loginAlertHistory,
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Login history query failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	ip := middleware.ClientIP(r)
+	device := audit.Device(audit.UserAgent(r.UserAgent()))
+	for _, e := range events {
+		if e.IP == ip && audit.Device(e.UserAgent) == device {
+			return
+		}
+	}
+	h.notifyUser(r, user, mail.TemplateNewLogin, map[string]interface{}{
+		"Device":  device,
+		"IP":      ip,
+		"Country": h.requestCountry(r),
+		"Time":    time.Now().UTC().Format(time.RFC1123),
+	})
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/quota"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+)
+
+// Audit actions recorded for quota management.
+const (
+	auditQuotaSet    = "quota.set"
+	auditQuotaDelete = "quota.delete"
+)
+
+// auditTargetQuota is the target type of quota audit events.
+const auditTargetQuota = "quota"
+
+// setQuotaRequest is the payload for PUT /api/admin/quotas/{subject}. A
+// zero limit is unlimited.
+type setQuotaRequest struct {
+	Daily   int64 `json:"daily"`
+	Monthly int64 `json:"monthly"`
+}
+
+// quotaStatus is a quota with the client's use of it so far.
+type quotaStatus struct {
+	models.Quota
+	Usage []quota.Usage `json:"usage"`
+}
+
+// quotaSubject returns the {subject} path value, writing a 400 when it is
+// not a valid quota subject.
+func quotaSubject(w http.ResponseWriter, r *http.Request) (string, bool) {
+	subject := r.PathValue("subject")
+	if err := quota.ValidSubject(subject); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return subject, true
+}
+
+// recordQuotaAudit records the acting admin's change to a quota; before
+// is nil when it was created and after when it was deleted.
+func recordQuotaAudit(r *http.Request, s store.Store, action string, before, after *models.Quota) error {
+	var changes []models.FieldChange
+	field := func(name string, get func(*models.Quota) interface{}) {
+		var b, a interface{}
+		if before != nil {
+			b = get(before)
+		}
+		if after != nil {
+			a = get(after)
+		}
+		if b != a {
+			changes = append(changes, models.FieldChange{Field: name, Before: b, After: a})
+		}
+	}
+	field("subject", func(q *models.Quota) interface{} { return q.Subject })
+	field("daily", func(q *models.Quota) interface{} { return q.Daily })
+	field("monthly", func(q *models.Quota) interface{} { return q.Monthly })
+
+	target := after
+	if target == nil {
+		target = before
+	}
+	return s.RecordAudit(r.Context(), &models.AuditEvent{
+		ActorID:    callerID(r),
+		Action:     action,
+		TargetType: auditTargetQuota,
+		TargetID:   target.ID,
+		Changes:    changes,
+		RequestID:  tracing.RequestIDFromContext(r.Context()),
+		TokenID:    callerTokenID(r),
+	})
+}
+
+// quotaStatusOf returns q with the client's current usage.
+func (h *Handlers) quotaStatusOf(r *http.Request, q models.Quota) (quotaStatus, error) {
+	usage, err := quota.CurrentUsage(r.Context(), h.Store, q, time.Now())
+	return quotaStatus{Quota: q, Usage: usage}, err
+}
+
+// AdminListQuotas handles GET /api/admin/quotas, returning every quota
+// with the client's usage in the current day and month.
+func (h *Handlers) AdminListQuotas(w http.ResponseWriter, r *http.Request) {
+	fail := func(err error) {
+		logger.FromContext(r.Context()).Error("Quota query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+	}
+	list, err := h.Store.ListQuotas(r.Context())
+	if err != nil {
+		fail(err)
+		return
+	}
+	statuses := make([]quotaStatus, 0, len(list))
+	for _, q := range list {
+		st, err := h.quotaStatusOf(r, q)
+		if err != nil {
+			fail(err)
+			return
+		}
+		statuses = append(statuses, st)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"quotas": statuses,
+	})
+}
+
+// AdminGetQuota handles GET /api/admin/quotas/{subject}.
+func (h *Handlers) AdminGetQuota(w http.ResponseWriter, r *http.Request) {
+	subject, ok := quotaSubject(w, r)
+	if !ok {
+		return
+	}
+	q, err := h.Store.GetQuota(r.Context(), subject)
+	var st quotaStatus
+	if err == nil && q != nil {
+		st, err = h.quotaStatusOf(r, *q)
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Quota query failed", map[string]interface{}{
+			"subject": subject,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if q == nil {
+		writeErrorResponse(w, "Quota not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"quota": st,
+	})
+}
+
+// AdminSetQuota handles PUT /api/admin/quotas/{subject}, creating or
+// replacing the client's quota. Usage already counted in the current
+// periods is kept.
+func (h *Handlers) AdminSetQuota(w http.ResponseWriter, r *http.Request) {
+	subject, ok := quotaSubject(w, r)
+	if !ok {
+		return
+	}
+	var req setQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.Daily < 0 || req.Monthly < 0 {
+		writeErrorResponse(w, "daily and monthly must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.Daily == 0 && req.Monthly == 0 {
+		writeErrorResponse(w, "Set daily or monthly; delete the quota to remove all limits", http.StatusBadRequest)
+		return
+	}
+
+	q := &models.Quota{Subject: subject, Daily: req.Daily, Monthly: req.Monthly, UpdatedBy: callerID(r)}
+	created := false
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		before, err := tx.GetQuota(r.Context(), subject)
+		if err != nil {
+			return err
+		}
+		created = before == nil
+		if err := tx.SetQuota(r.Context(), q); err != nil {
+			return err
+		}
+		return recordQuotaAudit(r, tx, auditQuotaSet, before, q)
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Quota update failed", map[string]interface{}{
+			"subject": subject,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to set quota", http.StatusInternalServerError)
+		return
+	}
+	if h.Quotas != nil {
+		h.Quotas.Set(*q)
+	}
+
+	logger.FromContext(r.Context()).Info("Quota set", map[string]interface{}{
+		"subject":  subject,
+		"daily":    q.Daily,
+		"monthly":  q.Monthly,
+		"admin_id": q.UpdatedBy,
+	})
+	st, err := h.quotaStatusOf(r, *q)
+	if err != nil {
+		// The quota is set; only the usage could not be read
+		st = quotaStatus{Quota: *q}
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"quota": st,
+	})
+}
+
+// AdminDeleteQuota handles DELETE /api/admin/quotas/{subject}, removing
+// the client's limits.
+func (h *Handlers) AdminDeleteQuota(w http.ResponseWriter, r *http.Request) {
+	subject, ok := quotaSubject(w, r)
+	if !ok {
+		return
+	}
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		before, err := tx.GetQuota(r.Context(), subject)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return store.ErrNotFound
+		}
+		if err := tx.DeleteQuota(r.Context(), subject); err != nil {
+			return err
+		}
+		return recordQuotaAudit(r, tx, auditQuotaDelete, before, nil)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		writeErrorResponse(w, "Quota not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Quota deletion failed", map[string]interface{}{
+			"subject": subject,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to delete quota", http.StatusInternalServerError)
+		return
+	}
+	if h.Quotas != nil {
+		h.Quotas.Remove(subject)
+	}
+
+	logger.FromContext(r.Context()).Info("Quota deleted", map[string]interface{}{
+		"subject":  subject,
+		"admin_id": callerID(r),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/mail"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+	"github.com/mayvqt/Sentinel/internal/validation"
+)
+
+// auditUserRecoveryCodeUsed records a login with a recovery code.
+const auditUserRecoveryCodeUsed = "user.recovery_code.used"
+
+// lowRecoveryCodes is the remaining count at or below which clients are
+// told to regenerate codes.
+const lowRecoveryCodes = 3
+
+// recoveryCodesFile is the download name for ?format=txt.
+const recoveryCodesFile = "sentinel-recovery-codes.txt"
+
+// regenerateRecoveryCodesRequest is the payload for POST
+// /api/auth/recovery-codes.
+type regenerateRecoveryCodesRequest struct {
+	Password string `json:"password"`
+}
+
+// issueRecoveryCodes replaces the user's recovery codes with a fresh set,
+// stored hashed through s, and returns the plaintext codes.
+func issueRecoveryCodes(ctx context.Context, s store.Store, userID int64) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = auth.HashRecoveryCode(c)
+	}
+	if err := s.SetRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// recoveryLoginCompleted records and announces a successful login with a
+// recovery code, returning the response fields that prompt the client to
+// regenerate codes.
+func (h *Handlers) recoveryLoginCompleted(r *http.Request, user *models.User) map[string]interface{} {
+	remaining, err := h.Store.CountRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to count recovery codes", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+	}
+	if err := h.Store.RecordAudit(r.Context(), &models.AuditEvent{
+		ActorID:    user.ID,
+		Action:     auditUserRecoveryCodeUsed,
+		TargetType: auditTargetUser,
+		TargetID:   user.ID,
+		RequestID:  tracing.RequestIDFromContext(r.Context()),
+	}); err != nil {
+		logger.FromContext(r.Context()).Error("Failed to record recovery code use", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+	}
+	logger.FromContext(r.Context()).Warn("Login with recovery code", map[string]interface{}{
+		"user_id":   user.ID,
+		"remaining": remaining,
+	})
+	h.notifyUser(r, user, mail.TemplateRecoveryCodeUsed, map[string]interface{}{
+		"Remaining": remaining,
+	})
+
+	return map[string]interface{}{
+		"recovery_codes_remaining":  remaining,
+		"regenerate_recovery_codes": true,
+	}
+}
+
+// RecoveryCodeStatus handles GET /api/auth/recovery-codes, reporting how
+// many unused recovery codes the caller has and whether to regenerate.
+func (h *Handlers) RecoveryCodeStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	remaining, err := h.Store.CountRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"remaining":  remaining,
+		"regenerate": remaining <= lowRecoveryCodes,
+	})
+}
+
+// RegenerateRecoveryCodes handles POST /api/auth/recovery-codes. After the
+// caller confirms their password it replaces all recovery codes and
+// returns the new ones, as JSON or, with ?format=txt, as a text file
+// download. The codes are never shown again.
+func (h *Handlers) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req regenerateRecoveryCodesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Password = validation.SanitizeInput(req.Password)
+	if req.Password == "" || auth.CheckPassword(user.Password, req.Password) != nil {
+		writeErrorResponse(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := issueRecoveryCodes(r.Context(), h.Store, user.ID)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to regenerate recovery codes", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	logger.FromContext(r.Context()).Info("Recovery codes regenerated", map[string]interface{}{
+		"user_id": user.ID,
+	})
+	h.notifyUser(r, user, mail.TemplateRecoveryCodesRegenerated, nil)
+
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("format") == "txt" {
+		body := "Sentinel recovery codes for " + user.Username + "\n" +
+			"Each code can be used once to sign in without your password.\n\n" +
+			strings.Join(codes, "\n") + "\n"
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+recoveryCodesFile+`"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(body))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+)
+
+// issueRefreshToken signs a refresh token for userID's session g and
+// records its ID (jti), linked to parentJTI when it replaces a rotated
+// token, returning the token and its ID. Only the ID is persisted, never
+// the token (see models.RefreshToken).
+func (h *Handlers) issueRefreshToken(r *http.Request, userID int64, role string, g auth.Grant, authTime, expiresAt time.Time, parentJTI string) (string, string, error) {
+	token, claims, err := h.Auth.IssueBoundRefreshToken(strconv.FormatInt(userID, 10), role, g, authTime, expiresAt)
+	if err != nil {
+		return "", "", err
+	}
+	if err := h.Store.SaveRefreshToken(r.Context(), &models.RefreshToken{
+		JTI:       claims.ID,
+		UserID:    userID,
+		ParentJTI: parentJTI,
+		IssuedAt:  claims.IssuedAt.Time,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return "", "", err
+	}
+	return token, claims.ID, nil
+}
+
+// refreshNonceKey returns the nonce key that marks the refresh token with
+// ID jti as rotated.
+func refreshNonceKey(jti string) string {
+	return "refresh:" + jti
+}
+
+// refreshReplays remembers the response to each refresh for
+// RefreshReuseGrace, keyed by the ID of the refresh token presented, so a
+// retry gets the same pair instead of a second session. The zero value is
+// ready to use.
+type refreshReplays struct {
+	mu      sync.Mutex
+	entries map[string]refreshReplay
+	// nextPrune is when put next forgets expired entries.
+	nextPrune time.Time
+}
+
+type refreshReplay struct {
+	response map[string]interface{}
+	expires  time.Time
+}
+
+// put remembers response for jti until expires, forgetting expired
+// entries about once per grace window.
+func (p *refreshReplays) put(jti string, response map[string]interface{}, expires time.Time) {
+	if jti == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.entries == nil {
+		p.entries = map[string]refreshReplay{}
+	}
+	if now := time.Now(); !now.Before(p.nextPrune) {
+		for id, e := range p.entries {
+			if !now.Before(e.expires) {
+				delete(p.entries, id)
+			}
+		}
+		p.nextPrune = expires
+	}
+	p.entries[jti] = refreshReplay{response: response, expires: expires}
+}
+
+// take returns and forgets the response remembered for jti, if it has not
+// expired by now. Each response is replayed at most once.
+func (p *refreshReplays) take(jti string, now time.Time) (map[string]interface{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.entries[jti]
+	if !ok {
+		return nil, false
+	}
+	delete(p.entries, jti)
+	return e.response, now.Before(e.expires)
+}
+
+// callerTokenID returns the ID (jti) of the token that authenticated r, or
+// "" when there is none.
+func callerTokenID(r *http.Request) string {
+	if claims, ok := r.Context().Value("user").(*auth.Claims); ok {
+		return claims.ID
+	}
+	return ""
+}
+
+// AdminListRefreshTokens handles GET /api/admin/users/{id}/refresh-tokens,
+// listing the user's unexpired refresh tokens newest first. Their jti
+// values can be passed to POST /api/admin/tokens:revoke.
+func (h *Handlers) AdminListRefreshTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+	tokens, err := h.Store.ListRefreshTokens(r.Context(), user.ID)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Refresh token query failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"refresh_tokens": tokens,
+	})
+}
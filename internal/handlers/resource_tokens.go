@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+)
+
+// Resource token lifetimes: the default when a request names none, and
+// the longest a request may ask for.
+const (
+	DefaultResourceTokenTTL = 5 * time.Minute
+	MaxResourceTokenTTL     = time.Hour
+)
+
+// maxResourceLength caps the resource identifier a token is bound to.
+const maxResourceLength = 512
+
+// resourceNonceKey returns the nonce key that marks the resource token
+// with ID jti as used.
+func resourceNonceKey(jti string) string {
+	return "resource:" + jti
+}
+
+// Introspection reasons specific to resource tokens; the others are the
+// auth.Reason constants.
+const (
+	reasonResourceMismatch = "resource_mismatch"
+	reasonAlreadyUsed      = "already_used"
+)
+
+var resourceTokens = metrics.NewCounterVec(
+	"sentinel_resource_tokens_total",
+	"Resource tokens issued and introspected, by result (issued, consumed, or the rejection reason).",
+	"result",
+)
+
+// resourceTokenRequest is the payload for POST /api/auth/resource-tokens.
+type resourceTokenRequest struct {
+	Resource   string `json:"resource"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+}
+
+// resourceTokenResponse is the body of a successful mint.
+type resourceTokenResponse struct {
+	Token     string    `json:"token"`
+	Resource  string    `json:"resource"`
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// resourceIntrospectRequest is the payload for POST
+// /api/auth/resource-tokens/introspect.
+type resourceIntrospectRequest struct {
+	Token    string `json:"token"`
+	Resource string `json:"resource"`
+}
+
+// resourceIntrospection is the result of introspecting a resource token.
+// Only active results carry the token's details.
+type resourceIntrospection struct {
+	Active   bool   `json:"active"`
+	Reason   string `json:"reason,omitempty"`
+	Subject  string `json:"sub,omitempty"`
+	Resource string `json:"resource,omitempty"`
+	JTI      string `json:"jti,omitempty"`
+	Exp      int64  `json:"exp,omitempty"`
+}
+
+// CreateResourceToken handles POST /api/auth/resource-tokens. It mints a
+// short-lived token that grants the caller's account access to one
+// resource, once, for applications to embed in download links and
+// similar. The application checks and uses up the token with
+// IntrospectResourceToken when the link is followed.
+func (h *Handlers) CreateResourceToken(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("user").(*auth.Claims)
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req resourceTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.Resource == "" {
+		writeErrorResponse(w, "resource is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Resource) > maxResourceLength {
+		writeErrorResponse(w, fmt.Sprintf("resource must be at most %d bytes", maxResourceLength), http.StatusBadRequest)
+		return
+	}
+	ttl := DefaultResourceTokenTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+		if ttl <= 0 || ttl > MaxResourceTokenTTL {
+			writeErrorResponse(w, fmt.Sprintf("ttl_seconds must be between 1 and %d", int(MaxResourceTokenTTL/time.Second)), http.StatusBadRequest)
+			return
+		}
+	}
+
+	token, rc, err := h.Auth.IssueResourceToken(claims.UserID, req.Resource, ttl)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to issue resource token", map[string]interface{}{
+			"user_id": claims.UserID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	resourceTokens.WithLabelValues("issued").Inc()
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, resourceTokenResponse{
+		Token:     token,
+		Resource:  req.Resource,
+		JTI:       rc.ID,
+		ExpiresAt: rc.ExpiresAt.Time.UTC(),
+	})
+}
+
+// IntrospectResourceToken handles POST
+// /api/auth/resource-tokens/introspect. The application serving a
+// resource sends the token from the link with the resource being
+// fetched. An active result uses the token up, so any later
+// introspection of it is inactive with reason already_used; a token
+// presented for another resource is left unused.
+func (h *Handlers) IntrospectResourceToken(w http.ResponseWriter, r *http.Request) {
+	var req resourceIntrospectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" || req.Resource == "" {
+		writeErrorResponse(w, "token and resource are required", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	inactive := func(reason string) {
+		resourceTokens.WithLabelValues(reason).Inc()
+		writeJSON(w, http.StatusOK, resourceIntrospection{Reason: reason})
+	}
+	claims, err := h.Auth.ParseToken(req.Token)
+	if err != nil {
+		middleware.ReportCanaryToken(h.Auth, r, err)
+		inactive(auth.RejectionReason(err))
+		return
+	}
+	if claims.TokenType != auth.TokenTypeResource {
+		auth.RecordTokenRejection(auth.ReasonWrongType)
+		inactive(auth.ReasonWrongType)
+		return
+	}
+	if claims.Resource != req.Resource {
+		inactive(reasonResourceMismatch)
+		return
+	}
+	// The account must still be able to sign in when the link is used.
+	userID, err := strconv.ParseInt(claims.UserID, 10, 64)
+	if err != nil {
+		inactive(auth.ReasonInvalid)
+		return
+	}
+	user, err := h.Store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || user.Disabled || user.Expired(time.Now()) {
+		inactive(auth.ReasonInvalid)
+		return
+	}
+
+	fresh, err := h.Store.UseNonce(r.Context(), resourceNonceKey(claims.ID), claims.ExpiresAt.Time, time.Now())
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to consume resource token", map[string]interface{}{
+			"jti":   claims.ID,
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !fresh {
+		inactive(reasonAlreadyUsed)
+		return
+	}
+	resourceTokens.WithLabelValues("consumed").Inc()
+	writeJSON(w, http.StatusOK, resourceIntrospection{
+		Active:   true,
+		Subject:  claims.UserID,
+		Resource: claims.Resource,
+		JTI:      claims.ID,
+		Exp:      claims.ExpiresAt.Unix(),
+	})
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+)
+
+// Denylist receives revocations made by this instance so they take effect
+// immediately rather than at the next sync from the store.
+type Denylist interface {
+	Add(jti string, expiresAt time.Time)
+}
+
+// revokeTokenRequest is the payload for POST /api/admin/tokens:revoke.
+type revokeTokenRequest struct {
+	JTI       string     `json:"jti"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// revokeToken persists the revocation of jti until expiresAt and adds it to
+// the local denylist. The entry outlives expiresAt by the clock skew
+// tolerance, since the token is still accepted for that long.
+func (h *Handlers) revokeToken(r *http.Request, jti string, expiresAt time.Time) error {
+	if h.Auth != nil {
+		expiresAt = expiresAt.Add(h.Auth.ClockSkew())
+	}
+	if err := h.Store.RevokeToken(r.Context(), &models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}); err != nil {
+		return err
+	}
+	if h.Denylist != nil {
+		h.Denylist.Add(jti, expiresAt)
+	}
+	return nil
+}
+
+// Logout handles POST /api/auth/logout. It revokes the access token used to
+// call it and, when the body carries one, the caller's refresh token. With
+// single sign-on it also revokes and clears the SSO cookie.
+func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("user").(*auth.Claims)
+	if !ok {
+		writeErrorResponse(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	revoke := []*auth.Claims{claims}
+	if req.RefreshToken != "" {
+		refresh, err := h.Auth.ParseToken(req.RefreshToken)
+		if err != nil || refresh.TokenType != "refresh" || refresh.UserID != claims.UserID {
+			writeErrorResponse(w, "Invalid refresh token", http.StatusBadRequest)
+			return
+		}
+		revoke = append(revoke, refresh)
+	}
+	// Logging out here also ends the session in sibling apps
+	if h.ssoEnabled() {
+		if sso := h.ssoClaims(r); sso != nil && sso.UserID == claims.UserID {
+			revoke = append(revoke, sso)
+		}
+	}
+
+	for _, c := range revoke {
+		if c.ID == "" || c.ExpiresAt == nil {
+			// Tokens issued before token IDs existed cannot be revoked
+			// individually; they simply run out.
+			continue
+		}
+		if err := h.revokeToken(r, c.ID, c.ExpiresAt.Time); err != nil {
+			logger.FromContext(r.Context()).Error("Token revocation failed", map[string]interface{}{
+				"user_id": claims.UserID,
+				"error":   err.Error(),
+			})
+			writeErrorResponse(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if h.ssoEnabled() {
+		h.setSSOCookie(w, r, "", 0)
+	}
+	if id, err := strconv.ParseInt(claims.UserID, 10, 64); err == nil {
+		h.notifyLogout(r, id)
+	}
+	logger.FromContext(r.Context()).Info("User logged out", map[string]interface{}{
+		"user_id": claims.UserID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maxTokenLifetime bounds the lifetime of any token this instance issues.
+func (h *Handlers) maxTokenLifetime() time.Duration {
+	return max(DefaultRefreshMaxLifetime, h.RefreshMaxLifetime, h.RefreshTokenTTL, h.SSOCookieTTL)
+}
+
+// AdminRevokeToken handles POST /api/admin/tokens:revoke, denylisting a
+// token by its ID. Without expires_at the revocation is kept for the longest
+// lifetime any token can have.
+func (h *Handlers) AdminRevokeToken(w http.ResponseWriter, r *http.Request) {
+	var req revokeTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.JTI == "" {
+		writeErrorResponse(w, "jti is required", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(h.maxTokenLifetime())
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			writeErrorResponse(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		expiresAt = *req.ExpiresAt
+	}
+
+	if err := h.revokeToken(r, req.JTI, expiresAt); err != nil {
+		logger.FromContext(r.Context()).Error("Token revocation failed", map[string]interface{}{
+			"jti":   req.JTI,
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("Token revoked by admin", map[string]interface{}{
+		"jti":      req.JTI,
+		"admin_id": callerID(r),
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"jti":        req.JTI,
+		"expires_at": expiresAt.UTC(),
+	})
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+)
+
+// sessionTouchInterval is how often at most a session's use is written to
+// the store, so the recorded time may trail the last use by this much.
+const sessionTouchInterval = time.Minute
+
+// MinSessionIdleTimeout is the shortest SessionIdleTimeout that cannot end
+// a session in use because its activity was recorded late.
+const MinSessionIdleTimeout = 5 * sessionTouchInterval
+
+// sessionUses remembers when each session's use was last recorded by this
+// instance. The zero value is ready to use.
+type sessionUses struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	// nextPrune is when due next forgets sessions not recently recorded.
+	nextPrune time.Time
+}
+
+// due reports whether a use of session sid at now should be recorded, and
+// if so notes that it was.
+func (u *sessionUses) due(sid string, now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.last == nil {
+		u.last = map[string]time.Time{}
+	}
+	if !now.Before(u.nextPrune) {
+		for id, t := range u.last {
+			if now.Sub(t) >= sessionTouchInterval {
+				delete(u.last, id)
+			}
+		}
+		u.nextPrune = now.Add(sessionTouchInterval)
+	}
+	if t, ok := u.last[sid]; ok && now.Sub(t) < sessionTouchInterval {
+		return false
+	}
+	u.last[sid] = now
+	return true
+}
+
+// forget makes the next use of sid due, such as after recording it failed.
+func (u *sessionUses) forget(sid string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.last, sid)
+}
+
+// TouchSession records that the session of r's access token was used, so
+// that it does not reach SessionIdleTimeout. It must run after
+// authentication; requests without a session token are ignored.
+func (h *Handlers) TouchSession(r *http.Request) {
+	if h.SessionIdleTimeout <= 0 {
+		return
+	}
+	claims, ok := r.Context().Value("user").(*auth.Claims)
+	if !ok || claims.SessionID == "" {
+		return
+	}
+	now := time.Now()
+	if !h.sessionUses.due(claims.SessionID, now) {
+		return
+	}
+	if err := h.Store.TouchRefreshToken(r.Context(), claims.SessionID, now); err != nil {
+		h.sessionUses.forget(claims.SessionID)
+		logger.FromContext(r.Context()).Error("Failed to record session activity", map[string]interface{}{
+			"user_id": claims.UserID,
+			"error":   err.Error(),
+		})
+	}
+}
+
+// sessionActive reports whether the session of the refresh token with
+// claims was used within SessionIdleTimeout of now, writing an error if
+// not.
+func (h *Handlers) sessionActive(w http.ResponseWriter, r *http.Request, claims *auth.Claims, now time.Time) bool {
+	if h.SessionIdleTimeout <= 0 {
+		return true
+	}
+	var last time.Time
+	if claims.IssuedAt != nil {
+		last = claims.IssuedAt.Time
+	}
+	t, err := h.Store.GetRefreshToken(r.Context(), claims.ID)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Refresh token query failed", map[string]interface{}{
+			"user_id": claims.UserID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if t != nil {
+		last = t.LastActivity()
+	}
+	if idle := now.Sub(last); idle > h.SessionIdleTimeout {
+		sessionIdleTimeouts.WithLabelValues().Inc()
+		logger.FromContext(r.Context()).Info("Session ended for inactivity", map[string]interface{}{
+			"user_id":      claims.UserID,
+			"jti":          claims.ID,
+			"idle_seconds": int64(idle.Seconds()),
+		})
+		writeErrorResponse(w, "Session expired due to inactivity, please log in again", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
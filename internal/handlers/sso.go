@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+)
+
+// Default single sign-on cookie settings.
+const (
+	DefaultSSOCookieName = "sentinel_sso"
+	DefaultSSOCookieTTL  = 12 * time.Hour
+)
+
+// Headers set on successful SSO checks, for reverse proxies that forward
+// them to the protected app (e.g. nginx auth_request, Traefik forwardAuth).
+const (
+	ssoHeaderUserID   = "X-Sentinel-User-Id"
+	ssoHeaderUsername = "X-Sentinel-Username"
+	ssoHeaderRole     = "X-Sentinel-Role"
+)
+
+// ssoCheckResponse is the body of a successful SSO check.
+type ssoCheckResponse struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ssoEnabled reports whether logins set the SSO cookie.
+func (h *Handlers) ssoEnabled() bool {
+	return h.SSOCookieDomain != ""
+}
+
+// ssoCookieName returns the configured cookie name or the default.
+func (h *Handlers) ssoCookieName() string {
+	if h.SSOCookieName == "" {
+		return DefaultSSOCookieName
+	}
+	return h.SSOCookieName
+}
+
+// ssoCookieTTL returns the configured cookie lifetime or the default.
+func (h *Handlers) ssoCookieTTL() time.Duration {
+	if h.SSOCookieTTL <= 0 {
+		return DefaultSSOCookieTTL
+	}
+	return h.SSOCookieTTL
+}
+
+// setSSOCookie sets, or with an empty value clears, the domain-wide SSO
+// cookie.
+func (h *Handlers) setSSOCookie(w http.ResponseWriter, r *http.Request, value string, maxAge time.Duration) {
+	c := &http.Cookie{
+		Name:     h.ssoCookieName(),
+		Value:    value,
+		Domain:   h.SSOCookieDomain,
+		Path:     "/",
+		MaxAge:   int(maxAge / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(h.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// issueSSOCookie sets the SSO cookie for a user who just signed in. A
+// failure is logged rather than failing the login, which has already
+// succeeded for this app.
+func (h *Handlers) issueSSOCookie(w http.ResponseWriter, r *http.Request, userID int64, role string) {
+	token, err := h.Auth.GenerateTokenWithType(strconv.FormatInt(userID, 10), role, auth.TokenTypeSSO, h.ssoCookieTTL())
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to issue SSO cookie", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return
+	}
+	h.setSSOCookie(w, r, token, h.ssoCookieTTL())
+}
+
+// ssoClaims returns the claims of the request's SSO cookie, or nil when it
+// has none or it is not valid.
+func (h *Handlers) ssoClaims(r *http.Request) *auth.Claims {
+	c, err := r.Cookie(h.ssoCookieName())
+	if err != nil || c.Value == "" {
+		return nil
+	}
+	claims, err := h.Auth.ParseToken(c.Value)
+	if err != nil {
+		middleware.ReportCanaryToken(h.Auth, r, err)
+		return nil
+	}
+	if claims.TokenType != auth.TokenTypeSSO {
+		auth.RecordTokenRejection(auth.ReasonWrongType)
+		return nil
+	}
+	return claims
+}
+
+// SSOCheck handles GET /api/auth/sso/check. Sibling apps on the cookie
+// domain, or a reverse proxy in front of them, call it with the visitor's
+// SSO cookie to learn who is signed in. It returns 200 with the user and
+// identifying headers, or 401 when there is no valid session.
+func (h *Handlers) SSOCheck(w http.ResponseWriter, r *http.Request) {
+	if !h.ssoEnabled() {
+		writeErrorResponse(w, "Single sign-on is not enabled", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	claims := h.ssoClaims(r)
+	if claims == nil {
+		writeErrorResponse(w, "No valid single sign-on session", http.StatusUnauthorized)
+		return
+	}
+	userID, err := strconv.ParseInt(claims.UserID, 10, 64)
+	if err != nil {
+		writeErrorResponse(w, "No valid single sign-on session", http.StatusUnauthorized)
+		return
+	}
+	user, err := h.Store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// The role comes from the store so that changes apply immediately
+	if user == nil || user.Disabled || user.Expired(time.Now()) {
+		writeErrorResponse(w, "No valid single sign-on session", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set(ssoHeaderUserID, strconv.FormatInt(user.ID, 10))
+	w.Header().Set(ssoHeaderUsername, user.Username)
+	w.Header().Set(ssoHeaderRole, user.Role)
+	writeJSON(w, http.StatusOK, ssoCheckResponse{
+		ID:        user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		ExpiresAt: claims.ExpiresAt.Time.UTC(),
+	})
+}
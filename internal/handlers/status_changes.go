@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/audit"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/mail"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
+)
+
+// Audit actions recorded for scheduled status changes. Applying one is
+// recorded as auditUserDisable or auditUserEnable.
+const (
+	auditUserEnable           = "user.enable"
+	auditStatusChangeSchedule = "user.status_change.schedule"
+	auditStatusChangeCancel   = "user.status_change.cancel"
+)
+
+// maxStatusChangeReason bounds the length of a status change's reason.
+const maxStatusChangeReason = 500
+
+// dueStatusChangesBatch is how many due status changes RunStatusChanges
+// applies per query.
+const dueStatusChangesBatch = 100
+
+// scheduleStatusChangeRequest is the payload for POST
+// /api/admin/users/{id}/status-changes.
+type scheduleStatusChangeRequest struct {
+	Action string    `json:"action"`
+	RunAt  time.Time `json:"run_at"`
+	Reason string    `json:"reason"`
+	Notify bool      `json:"notify"`
+}
+
+// statusChangeAuditEvent builds the event recording action on the
+// scheduled change c to user, with c's fields as the changes.
+func statusChangeAuditEvent(r *http.Request, action string, user *models.User, c *models.StatusChange) *models.AuditEvent {
+	e := userAuditEvent(r, action, user, user)
+	e.Changes = []models.FieldChange{
+		{Field: "status_change_id", After: c.ID},
+		{Field: "action", After: c.Action},
+		{Field: "run_at", After: c.RunAt.UTC().Format(time.RFC3339)},
+		{Field: "reason", After: c.Reason},
+		{Field: "notify", After: c.Notify},
+		{Field: "status", After: c.Status},
+	}
+	return e
+}
+
+// AdminScheduleStatusChange handles POST
+// /api/admin/users/{id}/status-changes, scheduling the account to be
+// suspended (disabled) or reactivated (enabled) at run_at. With notify,
+// the user is emailed when it happens.
+func (h *Handlers) AdminScheduleStatusChange(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+	if !h.mayManage(r, user) {
+		writeErrorResponse(w, "Only admins may change admin accounts", http.StatusForbidden)
+		return
+	}
+	var req scheduleStatusChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	switch {
+	case req.Action != models.StatusChangeSuspend && req.Action != models.StatusChangeReactivate:
+		writeErrorResponse(w, `action must be "suspend" or "reactivate"`, http.StatusBadRequest)
+		return
+	case !req.RunAt.After(time.Now()):
+		writeErrorResponse(w, "run_at must be in the future", http.StatusBadRequest)
+		return
+	case len(req.Reason) > maxStatusChangeReason:
+		writeErrorResponse(w, "reason must be at most 500 characters", http.StatusBadRequest)
+		return
+	case req.Action == models.StatusChangeSuspend && user.ID == callerID(r):
+		writeErrorResponse(w, "Cannot suspend your own account", http.StatusBadRequest)
+		return
+	}
+
+	c := &models.StatusChange{
+		UserID:    user.ID,
+		Action:    req.Action,
+		RunAt:     req.RunAt.UTC(),
+		Reason:    req.Reason,
+		Notify:    req.Notify,
+		Status:    models.StatusChangePending,
+		CreatedBy: callerID(r),
+	}
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.CreateStatusChange(r.Context(), c); err != nil {
+			return err
+		}
+		return tx.RecordAudit(r.Context(), statusChangeAuditEvent(r, auditStatusChangeSchedule, user, c))
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Status change scheduling failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to schedule status change", http.StatusInternalServerError)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("Status change scheduled", map[string]interface{}{
+		"user_id":          user.ID,
+		"admin_id":         c.CreatedBy,
+		"status_change_id": c.ID,
+		"action":           c.Action,
+		"run_at":           c.RunAt,
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"status_change": c,
+	})
+}
+
+// AdminListStatusChanges handles GET /api/admin/users/{id}/status-changes,
+// listing the account's scheduled status changes, including applied and
+// canceled ones, in the order they run.
+func (h *Handlers) AdminListStatusChanges(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+	changes, err := h.Store.ListStatusChanges(r.Context(), user.ID)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Status change query failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if changes == nil {
+		changes = []models.StatusChange{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status_changes": changes,
+	})
+}
+
+// AdminCancelStatusChange handles DELETE
+// /api/admin/users/{id}/status-changes/{change_id}, canceling a change
+// that has not been applied yet.
+func (h *Handlers) AdminCancelStatusChange(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+	if !h.mayManage(r, user) {
+		writeErrorResponse(w, "Only admins may change admin accounts", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("change_id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeErrorResponse(w, "Invalid status change ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		c, err := tx.GetStatusChange(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		if c == nil || c.UserID != user.ID {
+			return store.ErrNotFound
+		}
+		if c.Status != models.StatusChangePending {
+			return errStatusChangeCompleted
+		}
+		now := time.Now().UTC()
+		if err := tx.CompleteStatusChange(r.Context(), id, models.StatusChangeCanceled, now); err != nil {
+			return err
+		}
+		c.Status, c.CompletedAt = models.StatusChangeCanceled, &now
+		return tx.RecordAudit(r.Context(), statusChangeAuditEvent(r, auditStatusChangeCancel, user, c))
+	})
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeErrorResponse(w, "Status change not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errStatusChangeCompleted):
+		writeErrorResponse(w, "Status change was already applied or canceled", http.StatusConflict)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Error("Status change cancellation failed", map[string]interface{}{
+			"user_id":          user.ID,
+			"status_change_id": id,
+			"error":            err.Error(),
+		})
+		writeErrorResponse(w, "Failed to cancel status change", http.StatusInternalServerError)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("Status change canceled", map[string]interface{}{
+		"user_id":          user.ID,
+		"admin_id":         callerID(r),
+		"status_change_id": id,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errStatusChangeCompleted is returned when canceling a status change that
+// is no longer pending.
+var errStatusChangeCompleted = errors.New("status change is not pending")
+
+// RunStatusChanges applies the scheduled status changes due by now and
+// returns how many it applied. Each is audited as the admin who scheduled
+// it; a change whose account is already in the requested state is marked
+// done without an audit event.
+func (h *Handlers) RunStatusChanges(ctx context.Context, now time.Time) (int, error) {
+	applied := 0
+	for {
+		due, err := h.Store.ListDueStatusChanges(ctx, now, dueStatusChangesBatch)
+		if err != nil {
+			return applied, err
+		}
+		for _, c := range due {
+			if err := h.applyStatusChange(ctx, c, now); err != nil {
+				return applied, err
+			}
+			applied++
+		}
+		if len(due) < dueStatusChangesBatch {
+			return applied, nil
+		}
+	}
+}
+
+// applyStatusChange disables or enables c's account and marks c done,
+// then announces the change.
+func (h *Handlers) applyStatusChange(ctx context.Context, c models.StatusChange, now time.Time) error {
+	disable := c.Action == models.StatusChangeSuspend
+	var user *models.User
+	changed := false
+	err := h.Store.WithTx(ctx, func(tx store.Store) error {
+		var err error
+		if user, err = tx.GetUserByID(ctx, c.UserID); err != nil {
+			return err
+		}
+		if user != nil && user.Disabled != disable {
+			before := snapshotUser(user)
+			user.Disabled = disable
+			if err := tx.UpdateUser(ctx, user); err != nil {
+				return err
+			}
+			action := auditUserEnable
+			if disable {
+				action = auditUserDisable
+			}
+			err := tx.RecordAudit(ctx, &models.AuditEvent{
+				ActorID:    c.CreatedBy,
+				Action:     action,
+				TargetType: auditTargetUser,
+				TargetID:   user.ID,
+				Changes: append(audit.DiffUser(before, user),
+					models.FieldChange{Field: "status_change_id", After: c.ID}),
+			})
+			if err != nil {
+				return err
+			}
+			changed = true
+		}
+		return tx.CompleteStatusChange(ctx, c.ID, models.StatusChangeDone, now)
+	})
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	event, template := webhooks.EventUserEnable, mail.TemplateAccountReactivated
+	if disable {
+		event, template = webhooks.EventUserDisable, mail.TemplateAccountSuspended
+	}
+	h.announceStatusChange(ctx, user.ID, event, map[string]interface{}{
+		"user_id":          user.ID,
+		"admin_id":         c.CreatedBy,
+		"status_change_id": c.ID,
+	})
+	if c.Notify {
+		h.sendEmail(ctx, user, user.Email, template, map[string]interface{}{
+			"Reason": c.Reason,
+			"Time":   now.UTC().Format(time.RFC1123),
+		})
+	}
+	return nil
+}
+
+// announceStatusChange tells webhook subscribers, and for a disabled
+// account the back-channel logout clients, that userID was disabled or
+// re-enabled outside a request. event is webhooks.EventUserDisable or
+// webhooks.EventUserEnable. Failures are logged.
+func (h *Handlers) announceStatusChange(ctx context.Context, userID int64, event string, data map[string]interface{}) {
+	if event == webhooks.EventUserDisable && h.Backchannel.Len() > 0 {
+		if err := h.Backchannel.Notify(ctx, strconv.FormatInt(userID, 10)); err != nil {
+			logger.FromContext(ctx).Error("Back-channel logout failed", map[string]interface{}{
+				"user_id": userID,
+				"error":   err.Error(),
+			})
+		}
+	}
+	if h.Webhooks == nil {
+		return
+	}
+	if err := h.Webhooks.Publish(ctx, webhooks.Event{Type: event, Data: data}); err != nil {
+		logger.FromContext(ctx).Error("Failed to publish webhook event", map[string]interface{}{
+			"event": event,
+			"error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/middleware"
+	"github.com/mayvqt/Sentinel/internal/models"
+)
+
+// tarpitKeys returns the tarpit keys for a login attempt: the client IP
+// and the account, or the identifier as given when no account matched.
+func tarpitKeys(r *http.Request, user *models.User, identifier string) []string {
+	account := "user:" + strings.ToLower(identifier)
+	if user != nil {
+		account = "user:" + strconv.FormatInt(user.ID, 10)
+	}
+	return []string{"ip:" + middleware.ClientIP(r), account}
+}
+
+// tarpitWait holds a login attempt for the delay its keys have earned. When
+// the tarpit turns the attempt away instead it writes a 429 and returns
+// false.
+func (h *Handlers) tarpitWait(w http.ResponseWriter, r *http.Request, keys []string) bool {
+	if h.Tarpit == nil {
+		return true
+	}
+	d, ok := h.Tarpit.Wait(r.Context(), keys...)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+		writeErrorResponse(w, "Too many failed attempts. Please try again later.", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// tarpitFail records a failed login attempt.
+func (h *Handlers) tarpitFail(r *http.Request, keys []string) {
+	if h.Tarpit != nil {
+		h.Tarpit.Fail(r.Context(), keys...)
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+// Timeline entry types. A refresh token without a parent starts a session;
+// one with a parent replaced it when the client refreshed.
+const (
+	timelineAudit        = "audit"
+	timelineSessionStart = "session.start"
+	timelineTokenRefresh = "token.refresh"
+)
+
+// Timeline sources, in the order entries made at the same time are listed.
+const (
+	sourceAudit = "audit"
+	sourceToken = "token"
+)
+
+// timelineEntry is one entry of a user's timeline. Exactly one of Event
+// and Token is set.
+type timelineEntry struct {
+	At    time.Time            `json:"at"`
+	Type  string               `json:"type"`
+	Event *models.AuditEvent   `json:"event,omitempty"`
+	Token *models.RefreshToken `json:"token,omitempty"`
+}
+
+// timelineCursor is the position of an entry in the timeline: entries are
+// ordered by time, newest first, then audit events before tokens, then by
+// descending audit ID or token ID.
+type timelineCursor struct {
+	at     time.Time
+	source string
+	id     string
+}
+
+func (e *timelineEntry) cursor() timelineCursor {
+	if e.Event != nil {
+		return timelineCursor{e.At, sourceAudit, strconv.FormatInt(e.Event.ID, 10)}
+	}
+	return timelineCursor{e.At, sourceToken, e.Token.JTI}
+}
+
+// after reports whether the entry at c comes after (is older than) prev.
+func (c timelineCursor) after(prev timelineCursor) bool {
+	if !c.at.Equal(prev.at) {
+		return c.at.Before(prev.at)
+	}
+	if c.source != prev.source {
+		return c.source == sourceToken
+	}
+	if c.source == sourceAudit {
+		a, _ := strconv.ParseInt(c.id, 10, 64)
+		b, _ := strconv.ParseInt(prev.id, 10, 64)
+		return a < b
+	}
+	return c.id < prev.id
+}
+
+func (c timelineCursor) String() string {
+	raw := strconv.FormatInt(c.at.UnixNano(), 10) + "." + c.source + "." + c.id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseTimelineCursor(s string) (timelineCursor, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return timelineCursor{}, false
+	}
+	parts := strings.SplitN(string(raw), ".", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return timelineCursor{}, false
+	}
+	ns, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return timelineCursor{}, false
+	}
+	c := timelineCursor{at: time.Unix(0, ns).UTC(), source: parts[1], id: parts[2]}
+	switch c.source {
+	case sourceAudit:
+		if id, err := strconv.ParseInt(c.id, 10, 64); err != nil || id <= 0 {
+			return timelineCursor{}, false
+		}
+	case sourceToken:
+	default:
+		return timelineCursor{}, false
+	}
+	return c, true
+}
+
+// AdminUserTimeline handles GET /api/admin/users/{id}/timeline, merging the
+// audit events the user performed or was the subject of with the sessions
+// they started and the refresh tokens issued to them into one feed, newest
+// first. Pages hold up to limit entries; next_cursor, when present, is
+// passed back as cursor for the next page. Refresh tokens are only known
+// until they expire.
+func (h *Handlers) AdminUserTimeline(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+	var cur *timelineCursor
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		c, ok := parseTimelineCursor(v)
+		if !ok {
+			writeErrorResponse(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		cur = &c
+	}
+	user, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Fetch one more than a page from each source; the merged page then
+	// tells whether anything is left.
+	f := store.AuditFilter{UserID: user.ID, Limit: limit + 1}
+	if cur != nil {
+		f.Until = cur.at
+		if cur.source == sourceAudit {
+			f.BeforeID, _ = strconv.ParseInt(cur.id, 10, 64)
+		}
+	}
+	events, _, err := h.Store.ListAuditEvents(r.Context(), f)
+	var tokens []models.RefreshToken
+	if err == nil {
+		tokens, err = h.Store.ListRefreshTokens(r.Context(), user.ID)
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Timeline query failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]timelineEntry, 0, len(events)+len(tokens))
+	for i := range events {
+		entries = append(entries, timelineEntry{At: events[i].CreatedAt.UTC(), Type: timelineAudit, Event: &events[i]})
+	}
+	for i := range tokens {
+		t := &tokens[i]
+		e := timelineEntry{At: t.IssuedAt.UTC(), Type: timelineSessionStart, Token: t}
+		if t.ParentJTI != "" {
+			e.Type = timelineTokenRefresh
+		}
+		entries = append(entries, e)
+	}
+	if cur != nil {
+		entries = slices.DeleteFunc(entries, func(e timelineEntry) bool {
+			return !e.cursor().after(*cur)
+		})
+	}
+	slices.SortFunc(entries, func(a, b timelineEntry) int {
+		switch {
+		case a.cursor().after(b.cursor()):
+			return 1
+		case b.cursor().after(a.cursor()):
+			return -1
+		}
+		return 0
+	})
+
+	resp := map[string]interface{}{"limit": limit}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		resp["next_cursor"] = entries[limit-1].cursor().String()
+	}
+	resp["entries"] = entries
+	writeJSON(w, http.StatusOK, resp)
+}
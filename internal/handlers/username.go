@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/mayvqt/Sentinel/internal/validation"
+)
+
+// usernameAvailability is the response of GET /api/auth/username-available.
+// Reason is "invalid" with Message set when the name breaks the username
+// policy, and "unavailable" when it is taken or reserved; the two cases are
+// not distinguished so the endpoint reveals as little as possible.
+type usernameAvailability struct {
+	Username  string `json:"username"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// UsernameAvailable handles GET /api/auth/username-available?u=, letting
+// sign-up forms check a name before submitting. It is rate limited tightly
+// because it necessarily reveals whether an account exists, and disabled
+// under enumeration protection.
+func (h *Handlers) UsernameAvailable(w http.ResponseWriter, r *http.Request) {
+	if h.EnumerationProtection {
+		writeErrorResponse(w, "Username checks are disabled", http.StatusNotImplemented)
+		return
+	}
+	username := validation.SanitizeInput(r.URL.Query().Get("u"))
+	if username == "" {
+		writeErrorResponse(w, "Query parameter u is required", http.StatusBadRequest)
+		return
+	}
+
+	resp := usernameAvailability{Username: username}
+	policy := h.usernamePolicy()
+	if policy.IsReserved(username) {
+		resp.Reason = "unavailable"
+	} else if err := policy.Validate(username); err != nil {
+		resp.Reason = "invalid"
+		if ve, ok := err.(validation.ValidationError); ok {
+			resp.Message = ve.Message
+		}
+	} else {
+		taken, err := h.Store.UsernameExists(r.Context(), username)
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		resp.Available = !taken
+		if !resp.Available {
+			resp.Reason = "unavailable"
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
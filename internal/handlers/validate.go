@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+)
+
+// maxValidateBatch caps the number of tokens accepted by one
+// validate-batch request.
+const maxValidateBatch = 100
+
+// validateBatchRequest is the payload for POST /api/auth/validate-batch.
+type validateBatchRequest struct {
+	Tokens []string `json:"tokens"`
+}
+
+// tokenValidation reports the outcome for one token. Reason is one of the
+// auth.Reason constants.
+type tokenValidation struct {
+	Valid  bool         `json:"valid"`
+	Claims *auth.Claims `json:"claims,omitempty"`
+	Reason string       `json:"reason,omitempty"`
+}
+
+// ValidateTokenBatch handles POST /api/auth/validate-batch. Gateways send
+// the bearer tokens of up to maxValidateBatch requests at once and get a
+// result for each, in order: valid tokens with their claims, rejected
+// ones with the reason. A token is valid exactly when it would be
+// accepted as a bearer token on Sentinel's own routes.
+func (h *Handlers) ValidateTokenBatch(w http.ResponseWriter, r *http.Request) {
+	var req validateBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tokens) == 0 {
+		writeErrorResponse(w, "tokens must contain at least one token", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tokens) > maxValidateBatch {
+		writeErrorResponse(w, fmt.Sprintf("at most %d tokens may be sent per request", maxValidateBatch), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]tokenValidation, len(req.Tokens))
+	valid := 0
+	for i, token := range req.Tokens {
+		claims, err := h.Auth.ParseToken(token)
+		switch {
+		case err != nil:
+			middleware.ReportCanaryToken(h.Auth, r, err)
+			results[i].Reason = auth.RejectionReason(err)
+		case !auth.BearerTokenType(claims.TokenType):
+			auth.RecordTokenRejection(auth.ReasonWrongType)
+			results[i].Reason = auth.ReasonWrongType
+		default:
+			results[i] = tokenValidation{Valid: true, Claims: claims}
+			valid++
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+		"valid":   valid,
+		"invalid": len(results) - valid,
+	})
+}
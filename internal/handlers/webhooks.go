@@ -0,0 +1,519 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
+)
+
+// Audit actions recorded for webhook management. Redeliveries and test
+// deliveries target the new delivery; the others target the webhook.
+const (
+	auditWebhookCreate    = "webhook.create"
+	auditWebhookUpdate    = "webhook.update"
+	auditWebhookDelete    = "webhook.delete"
+	auditWebhookTest      = "webhook.test"
+	auditWebhookRedeliver = "webhook.redeliver"
+	auditWebhookReplay    = "webhook.replay"
+)
+
+// maxReplayEvents caps the outbox events one replay request may resend.
+const maxReplayEvents = 10000
+
+// Audit target types for webhook management.
+const (
+	auditTargetWebhook         = "webhook"
+	auditTargetWebhookDelivery = "webhook_delivery"
+)
+
+// webhookRequest is the payload for creating (POST /api/admin/webhooks) and
+// updating (PATCH /api/admin/webhooks/{id}) a subscription; fields left
+// out of an update keep their values. An empty or omitted secret is
+// generated and returned once in the response.
+type webhookRequest struct {
+	URL     *string   `json:"url"`
+	Events  *[]string `json:"events"`
+	Secret  *string   `json:"secret"`
+	Enabled *bool     `json:"enabled"`
+}
+
+// apply copies the fields set in req to wh, generating a secret when one
+// is requested or wh has none. It returns the generated secret, if any.
+func (req *webhookRequest) apply(wh *models.Webhook) (string, error) {
+	if req.URL != nil {
+		wh.URL = *req.URL
+	}
+	if req.Events != nil {
+		wh.Events = *req.Events
+	}
+	if req.Enabled != nil {
+		wh.Enabled = *req.Enabled
+	}
+	if req.Secret != nil {
+		wh.Secret = *req.Secret
+	}
+	if wh.Secret != "" {
+		return "", nil
+	}
+	secret, err := webhooks.NewSecret()
+	wh.Secret = secret
+	return secret, err
+}
+
+// recordWebhookAudit records the acting admin's action on a webhook or one
+// of its deliveries.
+func recordWebhookAudit(r *http.Request, s store.Store, action, targetType string, targetID int64) error {
+	return s.RecordAudit(r.Context(), &models.AuditEvent{
+		ActorID:    callerID(r),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		RequestID:  tracing.RequestIDFromContext(r.Context()),
+		TokenID:    callerTokenID(r),
+	})
+}
+
+// webhookID parses the {id} path value of a subscription, writing a 400
+// when it is not one.
+func webhookID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeErrorResponse(w, "Invalid webhook ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// webhookResponse is the body returned for a subscription; secret is set
+// only when it was just generated.
+func webhookResponse(wh *models.Webhook, secret string) map[string]interface{} {
+	resp := map[string]interface{}{"webhook": wh}
+	if secret != "" {
+		resp["secret"] = secret
+	}
+	return resp
+}
+
+// AdminListWebhooks handles GET /api/admin/webhooks, returning every
+// subscription without its secret, and the event types they may name.
+func (h *Handlers) AdminListWebhooks(w http.ResponseWriter, r *http.Request) {
+	list, err := h.Store.ListWebhooks(r.Context())
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Webhook query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []models.Webhook{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"webhooks": list,
+		"events":   webhooks.Events,
+	})
+}
+
+// AdminCreateWebhook handles POST /api/admin/webhooks. Subscriptions are
+// enabled unless enabled is false.
+func (h *Handlers) AdminCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	wh := &models.Webhook{Enabled: true, CreatedBy: callerID(r)}
+	secret, err := req.apply(wh)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Webhook secret generation failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+	if err := webhooks.Validate(wh); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.CreateWebhook(r.Context(), wh); err != nil {
+			return err
+		}
+		return recordWebhookAudit(r, tx, auditWebhookCreate, auditTargetWebhook, wh.ID)
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Webhook creation failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("Webhook created", map[string]interface{}{
+		"webhook_id": wh.ID,
+		"admin_id":   wh.CreatedBy,
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, webhookResponse(wh, secret))
+}
+
+// AdminGetWebhook handles GET /api/admin/webhooks/{id}.
+func (h *Handlers) AdminGetWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	wh, err := h.Store.GetWebhook(r.Context(), id)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Webhook lookup failed", map[string]interface{}{
+			"webhook_id": id,
+			"error":      err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if wh == nil {
+		writeErrorResponse(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhookResponse(wh, ""))
+}
+
+// AdminUpdateWebhook handles PATCH /api/admin/webhooks/{id}. Sending an
+// empty secret rotates it; the new one is returned once.
+func (h *Handlers) AdminUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	var updated *models.Webhook
+	var secret string
+	var invalid error
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		wh, err := tx.GetWebhook(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		if wh == nil {
+			return store.ErrNotFound
+		}
+		if secret, err = req.apply(wh); err != nil {
+			return err
+		}
+		if invalid = webhooks.Validate(wh); invalid != nil {
+			return invalid
+		}
+		if err := tx.UpdateWebhook(r.Context(), wh); err != nil {
+			return err
+		}
+		updated = wh
+		return recordWebhookAudit(r, tx, auditWebhookUpdate, auditTargetWebhook, id)
+	})
+	if invalid != nil {
+		writeErrorResponse(w, invalid.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		writeErrorResponse(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Webhook update failed", map[string]interface{}{
+			"webhook_id": id,
+			"error":      err.Error(),
+		})
+		writeErrorResponse(w, "Failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("Webhook updated", map[string]interface{}{
+		"webhook_id":     id,
+		"admin_id":       callerID(r),
+		"secret_rotated": secret != "",
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, webhookResponse(updated, secret))
+}
+
+// AdminDeleteWebhook handles DELETE /api/admin/webhooks/{id}. Its delivery
+// log is kept until it expires.
+func (h *Handlers) AdminDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	err := h.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.DeleteWebhook(r.Context(), id); err != nil {
+			return err
+		}
+		return recordWebhookAudit(r, tx, auditWebhookDelete, auditTargetWebhook, id)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		writeErrorResponse(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Webhook deletion failed", map[string]interface{}{
+			"webhook_id": id,
+			"error":      err.Error(),
+		})
+		writeErrorResponse(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("Webhook deleted", map[string]interface{}{
+		"webhook_id": id,
+		"admin_id":   callerID(r),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// webhookEndpoint resolves the {id} path value to a configured webhook or
+// a subscription, writing the error response when there is none.
+func (h *Handlers) webhookEndpoint(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	if h.Webhooks == nil {
+		writeErrorResponse(w, "Webhook not found", http.StatusNotFound)
+		return "", false
+	}
+	ep, err := h.Webhooks.Endpoint(r.Context(), id)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Webhook lookup failed", map[string]interface{}{
+			"webhook": id,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return "", false
+	}
+	if ep == nil {
+		writeErrorResponse(w, "Webhook not found", http.StatusNotFound)
+		return "", false
+	}
+	return id, true
+}
+
+// recordDeliveryAudit records a delivery an admin triggered. Failures are
+// logged: the delivery has already been made.
+func (h *Handlers) recordDeliveryAudit(r *http.Request, action string, del *models.WebhookDelivery) {
+	if err := recordWebhookAudit(r, h.Store, action, auditTargetWebhookDelivery, del.ID); err != nil {
+		logger.FromContext(r.Context()).Error("Failed to record webhook delivery audit", map[string]interface{}{
+			"action":      action,
+			"delivery_id": del.ID,
+			"error":       err.Error(),
+		})
+	}
+}
+
+// AdminTestWebhook handles POST /api/admin/webhooks/{id}/test, sending a
+// webhook.test event even if the webhook is disabled. The attempt is
+// returned whether or not the endpoint accepted it.
+func (h *Handlers) AdminTestWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.webhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	del, err := h.Webhooks.Test(r.Context(), id)
+	if del == nil {
+		logger.FromContext(r.Context()).Error("Webhook test delivery failed", map[string]interface{}{
+			"webhook": id,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Failed to deliver webhook", http.StatusInternalServerError)
+		return
+	}
+	h.recordDeliveryAudit(r, auditWebhookTest, del)
+
+	logger.FromContext(r.Context()).Info("Webhook test delivered", map[string]interface{}{
+		"webhook":     id,
+		"delivery_id": del.ID,
+		"status_code": del.StatusCode,
+		"admin_id":    callerID(r),
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"delivery": del,
+	})
+}
+
+// AdminListWebhookDeliveries handles GET /api/admin/webhooks/{id}/deliveries,
+// returning a page of the webhook's delivery attempts, newest first.
+func (h *Handlers) AdminListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.webhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	limit, offset, ok := parsePagination(w, r)
+	if !ok {
+		return
+	}
+	deliveries, total, err := h.Store.ListWebhookDeliveries(r.Context(), id, limit, offset)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Webhook delivery query failed", map[string]interface{}{
+			"webhook": id,
+			"error":   err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"deliveries": deliveries,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
+
+// AdminRedeliverWebhook handles
+// POST /api/admin/webhooks/{id}/deliveries/{delivery}/redeliver. The event
+// is sent again with the same event ID and a fresh signature, and the new
+// attempt is returned whether or not the endpoint accepted it.
+func (h *Handlers) AdminRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.webhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := strconv.ParseInt(r.PathValue("delivery"), 10, 64)
+	if err != nil || deliveryID <= 0 {
+		writeErrorResponse(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+	prev, err := h.Store.GetWebhookDelivery(r.Context(), deliveryID)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Webhook delivery lookup failed", map[string]interface{}{
+			"delivery_id": deliveryID,
+			"error":       err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if prev == nil || prev.WebhookID != id {
+		writeErrorResponse(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	del, err := h.Webhooks.Redeliver(r.Context(), prev)
+	if del == nil {
+		logger.FromContext(r.Context()).Error("Webhook redelivery failed", map[string]interface{}{
+			"delivery_id": deliveryID,
+			"error":       err.Error(),
+		})
+		writeErrorResponse(w, "Failed to redeliver webhook", http.StatusInternalServerError)
+		return
+	}
+	h.recordDeliveryAudit(r, auditWebhookRedeliver, del)
+
+	logger.FromContext(r.Context()).Info("Webhook redelivered", map[string]interface{}{
+		"webhook":       id,
+		"delivery_id":   del.ID,
+		"redelivery_of": deliveryID,
+		"status_code":   del.StatusCode,
+		"admin_id":      callerID(r),
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"delivery": del,
+	})
+}
+
+// replayRequest is the payload for POST /api/admin/webhooks/{id}/replay.
+type replayRequest struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// AdminReplayWebhook handles POST /api/admin/webhooks/{id}/replay,
+// resending the outbox events emitted in [from, to) that the subscription
+// receives, with their original event IDs, even if it is disabled. The
+// events are sent in the background; each attempt appears in the delivery
+// log.
+func (h *Handlers) AdminReplayWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	var req replayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.From.IsZero() || req.To.IsZero() || !req.From.Before(req.To) {
+		writeErrorResponse(w, "from and to must be RFC 3339 times with from before to", http.StatusBadRequest)
+		return
+	}
+	if h.Webhooks == nil {
+		writeErrorResponse(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	wh, err := h.Store.GetWebhook(r.Context(), id)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Webhook lookup failed", map[string]interface{}{
+			"webhook_id": id,
+			"error":      err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if wh == nil {
+		writeErrorResponse(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	events, err := h.Store.ListOutboxEvents(r.Context(), req.From, req.To, maxReplayEvents+1)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Outbox query failed", map[string]interface{}{
+			"webhook_id": id,
+			"error":      err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(events) > maxReplayEvents {
+		writeErrorResponse(w, "Too many events in range; narrow from and to", http.StatusBadRequest)
+		return
+	}
+	matched := events[:0]
+	for _, e := range events {
+		if wh.Receives(e.Type) {
+			matched = append(matched, e)
+		}
+	}
+
+	if err := recordWebhookAudit(r, h.Store, auditWebhookReplay, auditTargetWebhook, id); err != nil {
+		logger.FromContext(r.Context()).Error("Failed to record webhook replay audit", map[string]interface{}{
+			"webhook_id": id,
+			"error":      err.Error(),
+		})
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+	h.background.Go(func() { h.Webhooks.Replay(ctx, wh, matched) })
+
+	logger.FromContext(r.Context()).Info("Webhook replay started", map[string]interface{}{
+		"webhook_id": id,
+		"events":     len(matched),
+		"from":       req.From,
+		"to":         req.To,
+		"admin_id":   callerID(r),
+	})
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"events": len(matched),
+	})
+}
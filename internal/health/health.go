@@ -0,0 +1,177 @@
+// Package health aggregates the health of the service's dependencies. The
+// store, the mailer, and the webhook dispatcher register themselves in a
+// Registry, and the health endpoint reports on everything registered.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// Checker is a dependency whose health can be checked. Ping returns nil
+// when the dependency is usable. The store and the SMTP mailer satisfy it
+// as they are.
+type Checker interface {
+	Ping(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function to Checker.
+type CheckerFunc func(ctx context.Context) error
+
+// Ping calls f.
+func (f CheckerFunc) Ping(ctx context.Context) error { return f(ctx) }
+
+// Statuses of a Report and of each Result.
+const (
+	StatusOK = "ok"
+	// StatusDegraded means an optional dependency failed; the service
+	// still works, with reduced function.
+	StatusDegraded = "degraded"
+	// StatusUnavailable means a critical dependency failed.
+	StatusUnavailable = "unavailable"
+	// StatusError is the status of a failed Result.
+	StatusError = "error"
+)
+
+// DefaultTimeout bounds each check unless the caller's context is shorter.
+const DefaultTimeout = 2 * time.Second
+
+// dependencyUp reports the outcome of each dependency's latest check.
+var dependencyUp = metrics.NewGaugeVec(
+	"sentinel_dependency_up",
+	"Whether each dependency passed its latest health check (1) or not (0).",
+	"dependency",
+)
+
+// Result is the outcome of checking one dependency.
+type Result struct {
+	Status string `json:"status"`
+	// Critical dependencies make the service unavailable when they fail.
+	Critical   bool    `json:"critical"`
+	DurationMS float64 `json:"duration_ms"`
+	// Err is the failure, for logging; it may name internal hosts and is
+	// not serialized.
+	Err error `json:"-"`
+}
+
+// Report is the outcome of checking every registered dependency.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type dependency struct {
+	name     string
+	checker  Checker
+	critical bool
+}
+
+// Registry holds the dependencies to check. It is safe for concurrent use.
+type Registry struct {
+	mu   sync.RWMutex
+	deps []dependency
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a critical dependency, without which the service cannot
+// work. Registering a name again replaces the earlier checker.
+func (r *Registry) Register(name string, c Checker) {
+	r.add(dependency{name: name, checker: c, critical: true})
+}
+
+// RegisterOptional adds a dependency whose failure degrades the service
+// but leaves it usable, such as the mailer.
+func (r *Registry) RegisterOptional(name string, c Checker) {
+	r.add(dependency{name: name, checker: c})
+}
+
+func (r *Registry) add(d dependency) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.deps {
+		if r.deps[i].name == d.name {
+			r.deps[i] = d
+			return
+		}
+	}
+	r.deps = append(r.deps, d)
+}
+
+// Check checks every dependency concurrently, each within DefaultTimeout,
+// and returns their results. The report is unavailable when a critical
+// dependency failed and degraded when only optional ones did.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.RLock()
+	deps := append([]dependency(nil), r.deps...)
+	r.mu.RUnlock()
+
+	results := make([]Result, len(deps))
+	var wg sync.WaitGroup
+	for i, d := range deps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+			defer cancel()
+			start := time.Now()
+			err := d.checker.Ping(ctx)
+			results[i] = Result{Status: StatusOK, Critical: d.critical, DurationMS: float64(time.Since(start).Microseconds()) / 1000, Err: err}
+			if err != nil {
+				results[i].Status = StatusError
+				dependencyUp.WithLabelValues(d.name).Set(0)
+			} else {
+				dependencyUp.WithLabelValues(d.name).Set(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(deps))}
+	for i, d := range deps {
+		res := results[i]
+		report.Checks[d.name] = res
+		switch {
+		case res.Err == nil:
+		case d.critical:
+			report.Status = StatusUnavailable
+		case report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// cached is a Checker that reuses its last result for a while.
+type cached struct {
+	checker Checker
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// Cached returns a Checker that checks c at most once per ttl and reports
+// the last result in between, for dependencies too slow or costly to
+// check on every probe, such as an SMTP server.
+func Cached(c Checker, ttl time.Duration) Checker {
+	return &cached{checker: c, ttl: ttl}
+}
+
+func (c *cached) Ping(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checked.IsZero() && time.Since(c.checked) < c.ttl {
+		return c.err
+	}
+	c.err = c.checker.Ping(ctx)
+	c.checked = time.Now()
+	return c.err
+}
@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if report := r.Check(context.Background()); report.Status != StatusOK || len(report.Checks) != 0 {
+		t.Errorf("unexpected report for no dependencies: %+v", report)
+	}
+
+	var storeErr, mailErr error
+	r.Register("store", CheckerFunc(func(context.Context) error { return storeErr }))
+	r.RegisterOptional("mail", CheckerFunc(func(context.Context) error { return mailErr }))
+	status := func() string { return r.Check(context.Background()).Status }
+
+	if got := status(); got != StatusOK {
+		t.Errorf("got %s, want ok", got)
+	}
+	mailErr = errors.New("smtp down")
+	if got := status(); got != StatusDegraded {
+		t.Errorf("got %s with an optional dependency down, want degraded", got)
+	}
+	storeErr = errors.New("database locked")
+	report := r.Check(context.Background())
+	if report.Status != StatusUnavailable {
+		t.Errorf("got %s with a critical dependency down, want unavailable", report.Status)
+	}
+	if res := report.Checks["store"]; res.Status != StatusError || !res.Critical || res.Err != storeErr {
+		t.Errorf("unexpected store result %+v", res)
+	}
+
+	// Registering a name again replaces it
+	r.RegisterOptional("store", CheckerFunc(func(context.Context) error { return nil }))
+	if report := r.Check(context.Background()); len(report.Checks) != 2 || report.Checks["store"].Critical {
+		t.Errorf("expected the store check to be replaced: %+v", report.Checks)
+	}
+
+	// Checks run concurrently, each within the timeout
+	r.Register("slow", CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	start := time.Now()
+	if report := r.Check(context.Background()); report.Status != StatusUnavailable || time.Since(start) > DefaultTimeout+time.Second {
+		t.Errorf("expected a hung check to time out, got %s after %s", report.Status, time.Since(start))
+	}
+}
+
+func TestCached(t *testing.T) {
+	calls := 0
+	c := Cached(CheckerFunc(func(context.Context) error {
+		calls++
+		return errors.New("down")
+	}), time.Hour)
+	for range 3 {
+		if err := c.Ping(context.Background()); err == nil {
+			t.Error("expected the cached error")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected one check within the ttl, got %d", calls)
+	}
+}
@@ -0,0 +1,347 @@
+// Package httpclient builds the instrumented HTTP clients Sentinel uses for
+// outbound calls (webhooks, object storage, third-party APIs). Clients share
+// timeouts, retry with exponential backoff, a per-host circuit breaker,
+// proxy settings, trace propagation, and metrics.
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+)
+
+// Defaults applied to zero Options fields unless overridden with Configure.
+const (
+	DefaultTimeout          = 10 * time.Second
+	DefaultMaxRetries       = 2
+	DefaultRetryBackoff     = 200 * time.Millisecond
+	DefaultMaxRetryBackoff  = 5 * time.Second
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned without contacting the host while its circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("httpclient: circuit breaker open")
+
+var (
+	clientRequests = metrics.NewCounterVec(
+		"sentinel_http_client_requests_total",
+		"Outbound HTTP attempts by client, method, and status class (error for transport failures).",
+		"client", "method", "code",
+	)
+	clientDuration = metrics.NewHistogramVec(
+		"sentinel_http_client_request_duration_seconds",
+		"Outbound HTTP attempt latency by client.",
+		nil, "client",
+	)
+	clientRetries = metrics.NewCounterVec(
+		"sentinel_http_client_retries_total",
+		"Outbound HTTP retries by client.",
+		"client",
+	)
+	clientRejections = metrics.NewCounterVec(
+		"sentinel_http_client_circuit_open_total",
+		"Outbound HTTP requests rejected by an open circuit breaker.",
+		"client",
+	)
+)
+
+// Options configures a client. Zero fields take the configured defaults.
+type Options struct {
+	// Timeout bounds each request including retries.
+	Timeout time.Duration
+	// MaxRetries is the number of retries after the first attempt; a
+	// negative value disables retries.
+	MaxRetries      int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// RetryNonIdempotent also retries POST and PATCH requests. Only set it
+	// when the receiver deduplicates deliveries.
+	RetryNonIdempotent bool
+	// BreakerThreshold consecutive failures open a host's circuit for
+	// BreakerCooldown; a negative threshold disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// Proxy is an explicit proxy URL; empty uses HTTP_PROXY/HTTPS_PROXY/NO_PROXY.
+	Proxy string
+}
+
+var (
+	defaultsMu sync.RWMutex
+	defaults   = Options{
+		Timeout:          DefaultTimeout,
+		MaxRetries:       DefaultMaxRetries,
+		RetryBackoff:     DefaultRetryBackoff,
+		MaxRetryBackoff:  DefaultMaxRetryBackoff,
+		BreakerThreshold: DefaultBreakerThreshold,
+		BreakerCooldown:  DefaultBreakerCooldown,
+	}
+)
+
+// Configure replaces the defaults used by clients created afterwards. Zero
+// fields keep the package defaults.
+func Configure(opts Options) error {
+	if opts.Proxy != "" {
+		if _, err := parseProxy(opts.Proxy); err != nil {
+			return err
+		}
+	}
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	defaults = merge(opts, defaults)
+	return nil
+}
+
+// merge fills zero fields of opts from base.
+func merge(opts, base Options) Options {
+	if opts.Timeout <= 0 {
+		opts.Timeout = base.Timeout
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = base.MaxRetries
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = base.RetryBackoff
+	}
+	if opts.MaxRetryBackoff <= 0 {
+		opts.MaxRetryBackoff = base.MaxRetryBackoff
+	}
+	if opts.BreakerThreshold == 0 {
+		opts.BreakerThreshold = base.BreakerThreshold
+	}
+	if opts.BreakerCooldown <= 0 {
+		opts.BreakerCooldown = base.BreakerCooldown
+	}
+	if opts.Proxy == "" {
+		opts.Proxy = base.Proxy
+	}
+	return opts
+}
+
+func parseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q", raw)
+	}
+	return u, nil
+}
+
+// New returns a client named name (the metrics label) configured by opts
+// over the current defaults.
+func New(name string, opts Options) *http.Client {
+	defaultsMu.RLock()
+	opts = merge(opts, defaults)
+	defaultsMu.RUnlock()
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.Proxy = http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		if u, err := parseProxy(opts.Proxy); err == nil {
+			base.Proxy = http.ProxyURL(u)
+		}
+	}
+	base.DialContext = (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	base.TLSHandshakeTimeout = 5 * time.Second
+
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &transport{
+			name:    name,
+			base:    base,
+			opts:    opts,
+			breaker: newBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
+		},
+	}
+}
+
+// transport adds tracing, retries, circuit breaking, and metrics.
+type transport struct {
+	name    string
+	base    http.RoundTripper
+	opts    Options
+	breaker *breaker
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if !t.breaker.allow(host) {
+		clientRejections.WithLabelValues(t.name).Inc()
+		return nil, fmt.Errorf("%w for %s", ErrCircuitOpen, host)
+	}
+
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	tracing.Inject(req.Context(), req.Header)
+
+	retryable := t.canRetry(req)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		start := time.Now()
+		resp, err := t.base.RoundTrip(req)
+		clientDuration.WithLabelValues(t.name).Observe(time.Since(start).Seconds())
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode/100) + "xx"
+		}
+		clientRequests.WithLabelValues(t.name, req.Method, code).Inc()
+
+		// Cancellation by the caller says nothing about the host's health.
+		if req.Context().Err() == nil {
+			t.breaker.record(host, err == nil && resp.StatusCode < 500)
+		} else {
+			t.breaker.release(host)
+		}
+
+		if !retryable || attempt >= t.opts.MaxRetries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt, resp)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		clientRetries.WithLabelValues(t.name).Inc()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		if !t.breaker.allow(host) {
+			clientRejections.WithLabelValues(t.name).Inc()
+			return nil, fmt.Errorf("%w for %s", ErrCircuitOpen, host)
+		}
+	}
+}
+
+// canRetry reports whether req may be sent more than once.
+func (t *transport) canRetry(req *http.Request) bool {
+	if t.opts.MaxRetries <= 0 {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return t.opts.RetryNonIdempotent
+}
+
+// shouldRetry retries transport errors, 429, and transient 5xx responses.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before retry attempt+1: exponential with full
+// jitter, or the server's Retry-After (in seconds) when given, capped at
+// MaxRetryBackoff.
+func (t *transport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			return min(time.Duration(secs)*time.Second, t.opts.MaxRetryBackoff)
+		}
+	}
+	ceiling := min(t.opts.RetryBackoff<<attempt, t.opts.MaxRetryBackoff)
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// breaker is a per-host consecutive-failure circuit breaker. After
+// threshold failures the host is rejected for cooldown, then one trial
+// request is let through; its outcome closes or re-opens the circuit.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now, hosts: make(map[string]*circuit)}
+}
+
+func (b *breaker) allow(host string) bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.hosts[host]
+	if !ok || c.failures < b.threshold {
+		return true
+	}
+	if b.now().Before(c.openUntil) || c.trial {
+		return false
+	}
+	c.trial = true
+	return true
+}
+
+// release ends a trial request without recording an outcome.
+func (b *breaker) release(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.hosts[host]; ok {
+		c.trial = false
+	}
+}
+
+func (b *breaker) record(host string, success bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.hosts[host]
+	if !ok {
+		if success {
+			return
+		}
+		c = &circuit{}
+		b.hosts[host] = c
+	}
+	c.trial = false
+	if success {
+		delete(b.hosts, host)
+		return
+	}
+	c.failures++
+	if c.failures >= b.threshold {
+		c.openUntil = b.now().Add(b.cooldown)
+	}
+}
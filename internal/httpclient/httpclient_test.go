@@ -0,0 +1,95 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetriesTransientFailures(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Request-ID") != "" {
+			t.Errorf("unexpected request ID outside a request context")
+		}
+		body := make([]byte, 5)
+		n, _ := r.Body.Read(body)
+		if string(body[:n]) != "hello" {
+			t.Errorf("retry sent body %q", body[:n])
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	client := New("test", Options{RetryBackoff: time.Millisecond, RetryNonIdempotent: true})
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || calls.Load() != 3 {
+		t.Fatalf("expected success on third attempt, got %d after %d calls", resp.StatusCode, calls.Load())
+	}
+
+	// POST is not retried unless the client opts in.
+	calls.Store(0)
+	client = New("test", Options{RetryBackoff: time.Millisecond})
+	resp, err = client.Post(srv.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Fatalf("expected a single attempt, got %d after %d calls", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	client := New("test", Options{MaxRetries: -1, BreakerThreshold: 2, BreakerCooldown: time.Hour})
+	tr := client.Transport.(*transport)
+	now := time.Now()
+	tr.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("open circuit should not contact the host, got %d calls", calls.Load())
+	}
+
+	// After the cooldown a successful trial closes the circuit.
+	healthy.Store(true)
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request after cooldown failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,131 @@
+// Package httpjson writes JSON HTTP responses. Bodies are encoded into
+// memory before anything is sent, so an encoding failure becomes a clean
+// 500 instead of a truncated body behind an already-written status, and
+// every response carries an exact Content-Length.
+//
+// Errors are written as ErrorResponse bodies, or as RFC 7807 problem
+// details to responses marked with PreferProblems.
+package httpjson
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+)
+
+// encodeFailure is sent when a response value cannot be encoded.
+var encodeFailure = []byte(`{"error":"Internal Server Error","message":"Failed to encode response"}` + "\n")
+
+// ProblemContentType is the media type of problem details.
+const ProblemContentType = "application/problem+json"
+
+// requestIDHeader is the response header the request ID middleware sets,
+// which becomes a problem's instance.
+const requestIDHeader = "X-Request-ID"
+
+// ErrorResponse is the body of API errors unless problem details are
+// preferred.
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body. Type is always
+// "about:blank", so Title is the status text.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance identifies this occurrence by its request ID, as
+	// "urn:sentinel:request:<id>".
+	Instance string `json:"instance,omitempty"`
+}
+
+// problemWriter marks a response whose errors are problem details.
+type problemWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (pw *problemWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// PreferProblems returns w marked so that Error writes problem details to
+// it, even through writers that wrap it later, as long as they have an
+// Unwrap method.
+func PreferProblems(w http.ResponseWriter) http.ResponseWriter {
+	return &problemWriter{w}
+}
+
+// prefersProblems reports whether w, or a writer it wraps, was returned by
+// PreferProblems.
+func prefersProblems(w http.ResponseWriter) bool {
+	for {
+		switch v := w.(type) {
+		case *problemWriter:
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// Write sends v as a JSON response with the given status code and returns
+// the number of body bytes written.
+func Write(w http.ResponseWriter, statusCode int, v interface{}) int {
+	return write(w, statusCode, "application/json", v)
+}
+
+func write(w http.ResponseWriter, statusCode int, contentType string, v interface{}) int {
+	body, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("Failed to encode JSON response", map[string]interface{}{
+			"status": statusCode,
+			"error":  err.Error(),
+		})
+		statusCode, contentType, body = http.StatusInternalServerError, "application/json", encodeFailure
+	} else {
+		// Match json.Encoder output, which clients may rely on.
+		body = append(body, '\n')
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(statusCode)
+	n, err := w.Write(body)
+	if err != nil {
+		// The client went away; nothing more can be sent.
+		logger.Debug("Failed to write JSON response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	return n
+}
+
+// Error sends an ErrorResponse for statusCode with message, or problem
+// details when w prefers them.
+func Error(w http.ResponseWriter, message string, statusCode int) int {
+	if prefersProblems(w) {
+		p := Problem{
+			Type:   "about:blank",
+			Title:  http.StatusText(statusCode),
+			Status: statusCode,
+			Detail: message,
+		}
+		if id := w.Header().Get(requestIDHeader); id != "" {
+			p.Instance = "urn:sentinel:request:" + id
+		}
+		return write(w, statusCode, ProblemContentType, p)
+	}
+	return Write(w, statusCode, ErrorResponse{
+		Error:   http.StatusText(statusCode),
+		Message: message,
+	})
+}
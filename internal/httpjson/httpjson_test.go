@@ -0,0 +1,77 @@
+package httpjson
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	w := httptest.NewRecorder()
+	n := Write(w, http.StatusCreated, map[string]int{"id": 7})
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("status = %d, want 201", w.Code)
+	}
+	if got := w.Body.String(); got != "{\"id\":7}\n" {
+		t.Errorf("body = %q", got)
+	}
+	if n != w.Body.Len() || w.Header().Get("Content-Length") != strconv.Itoa(n) {
+		t.Errorf("Content-Length %q, wrote %d of %d bytes", w.Header().Get("Content-Length"), n, w.Body.Len())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestWriteEncodeFailure(t *testing.T) {
+	w := httptest.NewRecorder()
+	Write(w, http.StatusOK, map[string]interface{}{"bad": make(chan int)})
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	if got := w.Body.String(); got != string(encodeFailure) {
+		t.Errorf("body = %q", got)
+	}
+}
+
+func TestError(t *testing.T) {
+	w := httptest.NewRecorder()
+	Error(w, `quote " and <tag>`, http.StatusBadRequest)
+
+	want := "{\"error\":\"Bad Request\",\"message\":\"quote \\\" and \\u003ctag\\u003e\"}\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
+
+// unwrapper stands in for middleware writers that wrap the response.
+type unwrapper struct{ http.ResponseWriter }
+
+func (u unwrapper) Unwrap() http.ResponseWriter { return u.ResponseWriter }
+
+func TestErrorProblem(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Request-ID", "abc123")
+	Error(unwrapper{PreferProblems(rec)}, "Invalid token", http.StatusUnauthorized)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != ProblemContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	want := "{\"type\":\"about:blank\",\"title\":\"Unauthorized\",\"status\":401,\"detail\":\"Invalid token\",\"instance\":\"urn:sentinel:request:abc123\"}\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+
+	// Successful responses are unaffected
+	rec = httptest.NewRecorder()
+	Write(PreferProblems(rec), http.StatusOK, map[string]int{"id": 7})
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
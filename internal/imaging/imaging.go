@@ -0,0 +1,115 @@
+// Package imaging decodes, validates, and resizes uploaded images.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+
+	_ "image/gif"  // Register GIF decoder
+	_ "image/jpeg" // Register JPEG decoder
+)
+
+// MaxSourcePixels bounds decoded image dimensions to avoid decompression bombs.
+const MaxSourcePixels = 40_000_000
+
+var (
+	// ErrUnsupportedType is returned for content that is not an accepted image format.
+	ErrUnsupportedType = errors.New("unsupported image type")
+
+	// ErrTooLarge is returned when the image dimensions exceed MaxSourcePixels.
+	ErrTooLarge = errors.New("image dimensions too large")
+)
+
+// allowedTypes lists sniffed MIME types accepted for uploads.
+var allowedTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// DetectType sniffs the MIME type of data and reports whether it is an allowed image type.
+func DetectType(data []byte) (string, bool) {
+	mime := http.DetectContentType(data)
+	return mime, allowedTypes[mime]
+}
+
+// Decode validates and decodes an uploaded image, rejecting unsupported
+// formats and oversized dimensions before allocating pixel buffers.
+func Decode(data []byte) (image.Image, error) {
+	if _, ok := DetectType(data); !ok {
+		return nil, ErrUnsupportedType
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxSourcePixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	return img, nil
+}
+
+// Thumbnail center-crops src to a square and scales it to size x size
+// using area averaging, which gives good quality for downscaling.
+func Thumbnail(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+
+	rgba := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(rgba, rgba.Bounds(), src, crop.Min, draw.Src)
+
+	if side <= size {
+		return rgba
+	}
+	return downscale(rgba, size)
+}
+
+// downscale averages the source pixels covered by each destination pixel.
+func downscale(src *image.RGBA, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	side := src.Bounds().Dx()
+	for y := 0; y < size; y++ {
+		y0, y1 := y*side/size, (y+1)*side/size
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, (x+1)*side/size
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := src.RGBAAt(sx, sy)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					bl += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+// EncodePNG encodes img as PNG.
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
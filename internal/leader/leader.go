@@ -0,0 +1,189 @@
+// Package leader makes sure background jobs that act on the shared
+// database, such as purges of expired records, run on one instance of a
+// multi-instance deployment rather than on all of them.
+//
+// Each job has a lease in the store. The instance holding it is the job's
+// leader and renews it every third of its lifetime; the others keep
+// trying to take it. When the leader stops renewing, because it crashed
+// or lost the database, another instance takes over once the lease
+// expires. Leases are released on shutdown so that takeover is immediate.
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// DefaultLeaseTTL is used by New when given a non-positive TTL.
+const DefaultLeaseTTL = 30 * time.Second
+
+var leading = metrics.NewGaugeVec(
+	"sentinel_leader_jobs",
+	"Background jobs this instance leads (1) or follows (0).",
+	"job",
+)
+
+// Store holds leases. store.Store satisfies it.
+type Store interface {
+	AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+// Elector campaigns for the leases of the jobs it has been asked about. It
+// is safe for concurrent use. A nil Elector leads every job, which is what
+// a single instance wants.
+type Elector struct {
+	store Store
+	id    string
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu sync.Mutex
+	// held maps each job asked about to when this instance's lease on it
+	// runs out; the zero time when another instance holds it.
+	held map[string]time.Time
+}
+
+// New returns an elector that holds leases in s as id for ttl
+// (DefaultLeaseTTL when zero). An empty id is replaced by the host name
+// and a random suffix, unique to this process.
+func New(s Store, id string, ttl time.Duration) *Elector {
+	if id == "" {
+		id = DefaultID()
+	}
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	return &Elector{store: s, id: id, ttl: ttl, now: time.Now, held: make(map[string]time.Time)}
+}
+
+// DefaultID returns the host name followed by a random suffix.
+func DefaultID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "sentinel"
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// ID returns the holder name of this instance's leases.
+func (e *Elector) ID() string {
+	return e.id
+}
+
+// Leads reports whether this instance leads job and should run it now.
+// The first call for a job campaigns for it immediately; after that the
+// lease is kept by Run. Leadership is judged from the local clock with
+// the lease's expiry counted from before it was requested, so a leader
+// steps down no later than others may take over.
+func (e *Elector) Leads(ctx context.Context, job string) bool {
+	if e == nil {
+		return true
+	}
+	e.mu.Lock()
+	until, known := e.held[job]
+	e.mu.Unlock()
+	if !known {
+		until = e.campaign(ctx, job)
+	}
+	return e.now().Before(until)
+}
+
+// campaign tries to take or renew the lease on job, recording and
+// returning when it runs out. On a store error the lease is assumed lost:
+// a job skipped once is better than one run twice.
+func (e *Elector) campaign(ctx context.Context, job string) time.Time {
+	start := e.now()
+	ok, err := e.store.AcquireLease(ctx, job, e.id, start, e.ttl)
+	if err != nil && ctx.Err() == nil {
+		logger.Warn("Leader lease renewal failed", map[string]interface{}{
+			"job":   job,
+			"error": err.Error(),
+		})
+	}
+	var until time.Time
+	if ok && err == nil {
+		until = start.Add(e.ttl)
+	}
+
+	e.mu.Lock()
+	prev, known := e.held[job]
+	e.held[job] = until
+	e.mu.Unlock()
+
+	wasLeader := known && start.Before(prev)
+	switch {
+	case !until.IsZero() && !wasLeader:
+		logger.Info("Became leader for background job", map[string]interface{}{"job": job, "instance": e.id})
+	case until.IsZero() && wasLeader:
+		logger.Warn("Lost leadership of background job", map[string]interface{}{"job": job, "instance": e.id})
+	}
+	if until.IsZero() {
+		leading.WithLabelValues(job).Set(0)
+	} else {
+		leading.WithLabelValues(job).Set(1)
+	}
+	return until
+}
+
+// Run renews the leases this instance holds and campaigns for the others
+// every third of the lease TTL, until ctx is canceled.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, job := range e.jobs() {
+				e.campaign(ctx, job)
+			}
+		}
+	}
+}
+
+// jobs returns the jobs asked about so far.
+func (e *Elector) jobs() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	jobs := make([]string, 0, len(e.held))
+	for job := range e.held {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// Release gives up every lease this instance holds, so that other
+// instances take over without waiting for them to expire. It is called on
+// shutdown, after Run has stopped.
+func (e *Elector) Release(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now()
+	for job, until := range e.held {
+		if !now.Before(until) {
+			continue
+		}
+		if err := e.store.ReleaseLease(ctx, job, e.id); err != nil {
+			logger.Warn("Leader lease release failed", map[string]interface{}{
+				"job":   job,
+				"error": err.Error(),
+			})
+		}
+		e.held[job] = time.Time{}
+		leading.WithLabelValues(job).Set(0)
+	}
+}
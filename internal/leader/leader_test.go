@@ -0,0 +1,74 @@
+package leader
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+func TestNilElectorLeadsEverything(t *testing.T) {
+	var e *Elector
+	if !e.Leads(context.Background(), "guest-purge") {
+		t.Error("expected a nil elector to lead every job")
+	}
+}
+
+func TestTakeover(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemStore()
+	now := time.Now()
+	clock := func() time.Time { return now }
+	a, b := New(s, "a", time.Minute), New(s, "b", time.Minute)
+	a.now, b.now = clock, clock
+
+	if !a.Leads(ctx, "guest-purge") {
+		t.Fatal("expected the first instance to become leader")
+	}
+	if b.Leads(ctx, "guest-purge") {
+		t.Fatal("expected a single leader")
+	}
+	if !b.Leads(ctx, "quota-purge") {
+		t.Error("expected jobs to be led independently")
+	}
+
+	// a renews its lease while b keeps campaigning
+	now = now.Add(40 * time.Second)
+	a.campaign(ctx, "guest-purge")
+	b.campaign(ctx, "guest-purge")
+	now = now.Add(40 * time.Second)
+	if !a.Leads(ctx, "guest-purge") || b.Leads(ctx, "guest-purge") {
+		t.Fatal("expected a renewed lease to keep its leader")
+	}
+
+	// a stops renewing, as if it had crashed; its own view expires with
+	// the lease, and b takes over at its next campaign
+	now = now.Add(time.Minute)
+	if a.Leads(ctx, "guest-purge") {
+		t.Error("expected a leader that failed to renew to step down")
+	}
+	b.campaign(ctx, "guest-purge")
+	if !b.Leads(ctx, "guest-purge") {
+		t.Fatal("expected the follower to take over an expired lease")
+	}
+	a.campaign(ctx, "guest-purge")
+	if a.Leads(ctx, "guest-purge") {
+		t.Error("expected the old leader to follow")
+	}
+
+	// Releasing on shutdown hands over without waiting for expiry
+	b.Release(ctx)
+	a.campaign(ctx, "guest-purge")
+	if !a.Leads(ctx, "guest-purge") {
+		t.Error("expected a released lease to be taken immediately")
+	}
+}
+
+func TestDefaultID(t *testing.T) {
+	a, b := DefaultID(), DefaultID()
+	if a == b || !strings.Contains(a, "-") {
+		t.Errorf("expected distinct host-based IDs, got %q and %q", a, b)
+	}
+}
@@ -0,0 +1,148 @@
+// Package lifecycle shuts the service down in a fixed order. Subsystems
+// register hooks in the phase they belong to as they start, and Shutdown
+// runs them phase by phase: the listeners stop accepting requests first,
+// then background work is drained and queues flushed while the store is
+// still open, then jobs are stopped, and the store is closed last.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+)
+
+// Phase is a step of shutdown. Phases run in increasing order.
+type Phase int
+
+// Shutdown phases, in the order they run.
+const (
+	// StopAccepting stops the listeners and waits for in-flight requests.
+	StopAccepting Phase = iota
+	// Drain waits for work that requests started in the background, such
+	// as notification emails.
+	Drain
+	// Flush delivers what is still queued, such as webhook events.
+	Flush
+	// StopJobs stops background jobs and releases their leases.
+	StopJobs
+	// Close releases the store, files, and other resources.
+	Close
+)
+
+var phaseNames = map[Phase]string{
+	StopAccepting: "stop-accepting",
+	Drain:         "drain",
+	Flush:         "flush",
+	StopJobs:      "stop-jobs",
+	Close:         "close",
+}
+
+func (p Phase) String() string {
+	if name, ok := phaseNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("phase-%d", int(p))
+}
+
+// DefaultHookTimeout bounds a hook registered without a timeout.
+const DefaultHookTimeout = 5 * time.Second
+
+// Hook is a shutdown step. It should return once ctx is done.
+type Hook func(ctx context.Context) error
+
+type hook struct {
+	phase   Phase
+	name    string
+	timeout time.Duration
+	fn      Hook
+}
+
+// Manager holds shutdown hooks. It is safe for concurrent use.
+type Manager struct {
+	mu    sync.Mutex
+	hooks []hook
+	once  sync.Once
+	err   error
+}
+
+// New returns a Manager with no hooks.
+func New() *Manager {
+	return &Manager{}
+}
+
+// OnShutdown registers fn to run in phase, named name in logs and errors.
+// Hooks in the same phase run one at a time in the order they were
+// registered. Each is given at most timeout (DefaultHookTimeout when zero),
+// after which Shutdown moves on without it.
+func (m *Manager) OnShutdown(phase Phase, name string, timeout time.Duration, fn Hook) {
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{phase: phase, name: name, timeout: timeout, fn: fn})
+}
+
+// Shutdown runs the registered hooks phase by phase. A hook that fails or
+// times out is logged and does not stop the ones after it; the errors are
+// returned joined. Shutdown runs the hooks once: later calls wait for the
+// first and return its result.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.once.Do(func() {
+		m.mu.Lock()
+		hooks := make([]hook, len(m.hooks))
+		copy(hooks, m.hooks)
+		m.mu.Unlock()
+
+		var errs []error
+		for phase := StopAccepting; phase <= Close; phase++ {
+			for _, h := range hooks {
+				if h.phase != phase {
+					continue
+				}
+				if err := run(ctx, h); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+				}
+			}
+		}
+		m.err = errors.Join(errs...)
+	})
+	return m.err
+}
+
+// run calls h with its timeout, returning early if it overruns.
+func run(ctx context.Context, h hook) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- h.fn(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		select {
+		case err = <-done:
+		default:
+			err = ctx.Err()
+		}
+	}
+
+	fields := map[string]interface{}{
+		"hook":        h.name,
+		"phase":       h.phase.String(),
+		"duration_ms": time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+		logger.Error("Shutdown hook failed", fields)
+		return err
+	}
+	logger.Info("Shutdown hook completed", fields)
+	return nil
+}
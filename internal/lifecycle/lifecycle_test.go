@@ -0,0 +1,67 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestShutdownOrder(t *testing.T) {
+	m := New()
+	var ran []string
+	record := func(name string) Hook {
+		return func(context.Context) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	m.OnShutdown(Close, "store", 0, record("store"))
+	m.OnShutdown(StopJobs, "jobs", 0, record("jobs"))
+	m.OnShutdown(StopAccepting, "http", 0, record("http"))
+	m.OnShutdown(Close, "access-log", 0, record("access-log"))
+	m.OnShutdown(Flush, "webhooks", 0, record("webhooks"))
+	m.OnShutdown(Drain, "notifications", 0, record("notifications"))
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"http", "notifications", "webhooks", "jobs", "store", "access-log"}
+	if !slices.Equal(ran, want) {
+		t.Errorf("hooks ran as %v, want %v", ran, want)
+	}
+
+	// Hooks run once
+	if err := m.Shutdown(context.Background()); err != nil || len(ran) != len(want) {
+		t.Errorf("second Shutdown ran hooks again: %v, %v", ran, err)
+	}
+}
+
+func TestShutdownFailures(t *testing.T) {
+	m := New()
+	failed := errors.New("flush failed")
+	closed := false
+	m.OnShutdown(Flush, "webhooks", 0, func(context.Context) error { return failed })
+	m.OnShutdown(StopJobs, "stuck", 20*time.Millisecond, func(context.Context) error {
+		// Ignores its context, so Shutdown must give up on it
+		time.Sleep(time.Second)
+		return nil
+	})
+	m.OnShutdown(Close, "store", 0, func(context.Context) error {
+		closed = true
+		return nil
+	})
+
+	start := time.Now()
+	err := m.Shutdown(context.Background())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Shutdown waited %v for a stuck hook", elapsed)
+	}
+	if !errors.Is(err, failed) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the failure and the timeout, got %v", err)
+	}
+	if !closed {
+		t.Error("hooks after a failure did not run")
+	}
+}
@@ -0,0 +1,307 @@
+// Package loadgen drives a mix of sign-up, login, refresh, and profile
+// requests against a running Sentinel at a fixed rate and reports latency
+// percentiles for each operation. cmd/loadgen is its command-line front
+// end.
+//
+// Load is open-loop: requests start on schedule whether or not earlier
+// ones have finished, as real clients do, so a slow server shows up as
+// higher latency rather than as a lower request rate.
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for zero Options fields.
+const (
+	DefaultSessions    = 10
+	DefaultMaxInFlight = 256
+	DefaultTimeout     = 30 * time.Second
+)
+
+// Options configures a load test.
+type Options struct {
+	// BaseURL is the instance under test, e.g. "http://localhost:8080".
+	BaseURL  string
+	RPS      float64
+	Duration time.Duration
+	Mix      Mix
+	// Sessions is how many accounts are registered and logged in before
+	// the test starts; login, refresh, and me requests use them in turn.
+	Sessions int
+	// MaxInFlight bounds outstanding requests. Requests due while it is
+	// reached are skipped and counted as dropped.
+	MaxInFlight int
+	// Prefix starts the username of every account created; by default it
+	// is random, so that runs against one instance do not collide.
+	Prefix string
+	Client *http.Client
+}
+
+// Report is the outcome of a load test.
+type Report struct {
+	Mix         string        `json:"mix"`
+	TargetRPS   float64       `json:"target_rps"`
+	AchievedRPS float64       `json:"achieved_rps"`
+	Duration    time.Duration `json:"duration_ns"`
+	Dropped     int64         `json:"dropped"`
+	Ops         []OpStats     `json:"ops"`
+}
+
+// session is a seeded account and the tokens it last received.
+type session struct {
+	username     string
+	accessToken  string
+	refreshToken string
+}
+
+type runner struct {
+	opts     Options
+	password string
+	client   *http.Client
+	sessions chan *session
+	rec      *recorder
+	// registered numbers the accounts created during the test.
+	registered atomic.Int64
+}
+
+// Run seeds opts.Sessions accounts, then sends requests at opts.RPS for
+// opts.Duration, or until ctx is canceled, and waits for them to finish.
+// It fails only if the accounts cannot be seeded; failed requests are
+// counted in the report.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if opts.BaseURL == "" || opts.RPS <= 0 || opts.Duration <= 0 || len(opts.Mix) == 0 {
+		return nil, errors.New("a base URL, a positive rate and duration, and a mix are required")
+	}
+	opts.BaseURL = strings.TrimRight(opts.BaseURL, "/")
+	if opts.Sessions <= 0 {
+		opts.Sessions = DefaultSessions
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = DefaultMaxInFlight
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "loadgen" + randomHex(4)
+	}
+	r := &runner{
+		opts: opts,
+		// Long and mixed enough for any password policy.
+		password: "Lg-" + randomHex(8) + "-Pw9!",
+		client:   opts.Client,
+		sessions: make(chan *session, opts.Sessions),
+		rec:      newRecorder(),
+	}
+	if r.client == nil {
+		r.client = &http.Client{Timeout: DefaultTimeout}
+	}
+	if err := r.seed(ctx); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+	interval := time.Duration(float64(time.Second) / opts.RPS)
+	ticker := time.NewTicker(max(interval, time.Microsecond))
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	var dropped int64
+	inFlight := make(chan struct{}, opts.MaxInFlight)
+	weights := opts.Mix.total()
+	start := time.Now()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+		}
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			dropped++
+			continue
+		}
+		op := opts.Mix.pick(mrand.IntN(weights))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			// Requests in flight when the test ends finish on their own
+			// timeout rather than being canceled and counted as errors.
+			r.do(context.WithoutCancel(ctx), op)
+		}()
+	}
+	elapsed := time.Since(start)
+	wg.Wait()
+
+	report := &Report{
+		Mix:       opts.Mix.String(),
+		TargetRPS: opts.RPS,
+		Duration:  elapsed,
+		Dropped:   dropped,
+		Ops:       r.rec.stats(),
+	}
+	var total int
+	for _, s := range report.Ops {
+		total += s.Requests
+	}
+	report.AchievedRPS = float64(total) / elapsed.Seconds()
+	return report, nil
+}
+
+// seed registers and logs in the session accounts.
+func (r *runner) seed(ctx context.Context) error {
+	errs := make(chan error, r.opts.Sessions)
+	var wg sync.WaitGroup
+	for i := range r.opts.Sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := &session{username: fmt.Sprintf("%s-s%d", r.opts.Prefix, i)}
+			if err := r.register(ctx, s.username); err != nil {
+				errs <- fmt.Errorf("seeding %s: %w", s.username, err)
+				return
+			}
+			if err := r.login(ctx, s); err != nil {
+				errs <- fmt.Errorf("seeding %s: %w", s.username, err)
+				return
+			}
+			r.sessions <- s
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	var all []error
+	for err := range errs {
+		all = append(all, err)
+	}
+	return errors.Join(all...)
+}
+
+// do runs one op and records how long it took.
+func (r *runner) do(ctx context.Context, op string) {
+	if op == OpRegister {
+		username := fmt.Sprintf("%s-r%d", r.opts.Prefix, r.registered.Add(1))
+		start := time.Now()
+		err := r.register(ctx, username)
+		r.rec.record(op, time.Since(start), err != nil)
+		return
+	}
+
+	// A session is used by one request at a time, since refreshing
+	// rotates its tokens.
+	s := <-r.sessions
+	defer func() { r.sessions <- s }()
+	start := time.Now()
+	var err error
+	switch op {
+	case OpLogin:
+		err = r.login(ctx, s)
+	case OpRefresh:
+		err = r.refresh(ctx, s)
+	case OpMe:
+		_, err = r.request(ctx, http.MethodGet, "/api/auth/profile", s.accessToken, nil)
+	}
+	r.rec.record(op, time.Since(start), err != nil)
+}
+
+func (r *runner) register(ctx context.Context, username string) error {
+	_, err := r.request(ctx, http.MethodPost, "/api/auth/register", "", map[string]string{
+		"username": username,
+		"email":    username + "@example.com",
+		"password": r.password,
+	})
+	return err
+}
+
+// tokens is the part of login and refresh responses that sessions keep.
+type tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (r *runner) login(ctx context.Context, s *session) error {
+	body, err := r.request(ctx, http.MethodPost, "/api/auth/login", "", map[string]string{
+		"username": s.username,
+		"password": r.password,
+	})
+	if err != nil {
+		return err
+	}
+	return s.update(body)
+}
+
+func (r *runner) refresh(ctx context.Context, s *session) error {
+	body, err := r.request(ctx, http.MethodPost, "/api/auth/refresh", "", map[string]string{
+		"refresh_token": s.refreshToken,
+	})
+	if err != nil {
+		return err
+	}
+	return s.update(body)
+}
+
+func (s *session) update(body []byte) error {
+	var t tokens
+	if err := json.Unmarshal(body, &t); err != nil || t.AccessToken == "" || t.RefreshToken == "" {
+		return errors.New("response has no tokens")
+	}
+	s.accessToken, s.refreshToken = t.AccessToken, t.RefreshToken
+	return nil
+}
+
+// request sends a JSON request and returns the response body, or an error
+// for a transport failure or a non-2xx status.
+func (r *runner) request(ctx context.Context, method, path, token string, payload interface{}) ([]byte, error) {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.opts.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s: status %d", method, path, resp.StatusCode)
+	}
+	return b, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
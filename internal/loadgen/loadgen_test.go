@@ -0,0 +1,144 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseMix(t *testing.T) {
+	m, err := ParseMix(DefaultMix)
+	if err != nil || m.String() != DefaultMix || m.total() != 20 {
+		t.Fatalf("ParseMix(DefaultMix) = %v, %v", m, err)
+	}
+	if got := m.pick(0); got != OpRegister {
+		t.Errorf("pick(0) = %q, want register", got)
+	}
+	if got := m.pick(3); got != OpRefresh {
+		t.Errorf("pick(3) = %q, want refresh", got)
+	}
+	if got := m.pick(19); got != OpMe {
+		t.Errorf("pick(19) = %q, want me", got)
+	}
+	if m, err := ParseMix(" me = 3, login=0 ,"); err != nil || m.String() != "me=3" {
+		t.Errorf("zero weights and spaces: got %v, %v", m, err)
+	}
+	for _, s := range []string{"", "login=0", "me", "me=x", "me=-1", "logout=1"} {
+		if _, err := ParseMix(s); err == nil {
+			t.Errorf("ParseMix(%q) succeeded", s)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var l []time.Duration
+	for i := 1; i <= 100; i++ {
+		l = append(l, time.Duration(i))
+	}
+	for p, want := range map[float64]time.Duration{50: 50, 90: 90, 99: 99, 100: 100, 0: 1} {
+		if got := percentile(l, p); got != want {
+			t.Errorf("percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile(l[:1], 99); got != 1 {
+		t.Errorf("percentile of one = %v", got)
+	}
+}
+
+// fakeServer answers the auth endpoints well enough for a session to
+// register, log in, refresh, and read its profile. Refresh tokens are
+// single-use, as in Sentinel.
+type fakeServer struct {
+	mu      sync.Mutex
+	users   map[string]bool
+	refresh map[string]string // refresh token -> username
+	issued  atomic.Int64
+}
+
+func (f *fakeServer) issue(w http.ResponseWriter, username string) {
+	n := strconv.FormatInt(f.issued.Add(1), 10)
+	f.refresh["r"+n] = username
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "a" + n, "refresh_token": "r" + n})
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	json.NewDecoder(r.Body).Decode(&body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/api/auth/register":
+		if f.users[body["username"]] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.users[body["username"]] = true
+		w.WriteHeader(http.StatusCreated)
+	case "/api/auth/login":
+		if !f.users[body["username"]] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.issue(w, body["username"])
+	case "/api/auth/refresh":
+		username, ok := f.refresh[body["refresh_token"]]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		delete(f.refresh, body["refresh_token"])
+		f.issue(w, username)
+	case "/api/auth/profile":
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv := httptest.NewServer(&fakeServer{users: map[string]bool{}, refresh: map[string]string{}})
+	defer srv.Close()
+	mix, _ := ParseMix("register=1,login=1,refresh=2,me=4")
+	report, err := Run(context.Background(), Options{
+		BaseURL:  srv.URL + "/",
+		RPS:      400,
+		Duration: 250 * time.Millisecond,
+		Mix:      mix,
+		Sessions: 4,
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var total int
+	for _, s := range report.Ops {
+		total += s.Requests
+		// Refreshing the same session concurrently would reuse a rotated
+		// token and fail.
+		if s.Errors != 0 {
+			t.Errorf("%s: %d of %d requests failed", s.Op, s.Errors, s.Requests)
+		}
+		if s.P50 > s.P90 || s.P90 > s.P99 || s.P99 > s.Max {
+			t.Errorf("%s: percentiles out of order: %+v", s.Op, s)
+		}
+	}
+	if total == 0 || report.AchievedRPS <= 0 || report.Mix != mix.String() {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// Seeding failures fail the run.
+	srv.Close()
+	if _, err := Run(context.Background(), Options{BaseURL: srv.URL, RPS: 1, Duration: time.Second, Mix: mix}); err == nil {
+		t.Error("Run against a closed server succeeded")
+	}
+	if _, err := Run(context.Background(), Options{BaseURL: srv.URL, Duration: time.Second, Mix: mix}); err == nil {
+		t.Error("Run without a rate succeeded")
+	}
+}
@@ -0,0 +1,84 @@
+package loadgen
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Operations a load test can mix.
+const (
+	OpRegister = "register" // POST /api/auth/register with a new account
+	OpLogin    = "login"    // POST /api/auth/login as a seeded account
+	OpRefresh  = "refresh"  // POST /api/auth/refresh, rotating a session's refresh token
+	OpMe       = "me"       // GET /api/auth/profile with a session's access token
+)
+
+// Ops lists the operations in the order they are reported.
+var Ops = []string{OpRegister, OpLogin, OpRefresh, OpMe}
+
+// DefaultMix resembles an API whose clients mostly read with tokens they
+// already hold.
+const DefaultMix = "register=1,login=2,refresh=2,me=15"
+
+// Mix weights the operations of a load test. Each request picks an
+// operation with probability proportional to its weight.
+type Mix map[string]int
+
+// ParseMix parses a comma-separated list of op=weight pairs, such as
+// DefaultMix. Operations left out are not run.
+func ParseMix(s string) (Mix, error) {
+	m := Mix{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		op, weight, ok := strings.Cut(part, "=")
+		op = strings.TrimSpace(op)
+		if !ok || !slices.Contains(Ops, op) {
+			return nil, fmt.Errorf("invalid mix entry %q: want op=weight with op one of %s", part, strings.Join(Ops, ", "))
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid weight in mix entry %q", part)
+		}
+		if n > 0 {
+			m[op] = n
+		}
+	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("mix %q has no operations", s)
+	}
+	return m, nil
+}
+
+// pick returns the operation n selects, for n in [0, total weight).
+func (m Mix) pick(n int) string {
+	for _, op := range Ops {
+		if n < m[op] {
+			return op
+		}
+		n -= m[op]
+	}
+	return ""
+}
+
+func (m Mix) total() int {
+	var t int
+	for _, w := range m {
+		t += w
+	}
+	return t
+}
+
+func (m Mix) String() string {
+	var parts []string
+	for _, op := range Ops {
+		if m[op] > 0 {
+			parts = append(parts, op+"="+strconv.Itoa(m[op]))
+		}
+	}
+	return strings.Join(parts, ",")
+}
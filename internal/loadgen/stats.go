@@ -0,0 +1,84 @@
+package loadgen
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// OpStats summarizes the requests made for one operation.
+type OpStats struct {
+	Op       string        `json:"op"`
+	Requests int           `json:"requests"`
+	Errors   int           `json:"errors"`
+	P50      time.Duration `json:"p50_ns"`
+	P90      time.Duration `json:"p90_ns"`
+	P99      time.Duration `json:"p99_ns"`
+	Max      time.Duration `json:"max_ns"`
+}
+
+// ErrorRate returns the fraction of requests that failed.
+func (s OpStats) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests)
+}
+
+// recorder collects request latencies by operation. It is safe for
+// concurrent use.
+type recorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{latencies: map[string][]time.Duration{}, errors: map[string]int{}}
+}
+
+// record adds a request for op that took d; failed requests count towards
+// the latencies too, since a slow failure is still slow.
+func (r *recorder) record(op string, d time.Duration, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[op] = append(r.latencies[op], d)
+	if failed {
+		r.errors[op]++
+	}
+}
+
+// stats returns the summary of each operation that ran, in Ops order.
+func (r *recorder) stats() []OpStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []OpStats
+	for _, op := range Ops {
+		l := slices.Clone(r.latencies[op])
+		if len(l) == 0 {
+			continue
+		}
+		slices.Sort(l)
+		out = append(out, OpStats{
+			Op:       op,
+			Requests: len(l),
+			Errors:   r.errors[op],
+			P50:      percentile(l, 50),
+			P90:      percentile(l, 90),
+			P99:      percentile(l, 99),
+			Max:      l[len(l)-1],
+		})
+	}
+	return out
+}
+
+// percentile returns the p-th percentile of sorted by the nearest-rank
+// method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
@@ -2,10 +2,16 @@
 package logger
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/mayvqt/Sentinel/internal/scrub"
 )
 
 // Level represents the log level.
@@ -18,9 +24,61 @@ const (
 	LevelError Level = "error"
 )
 
+// Format selects how log entries are written.
+type Format string
+
+const (
+	// FormatJSON writes each entry as one JSON object, the default.
+	FormatJSON Format = "json"
+	// FormatConsole writes each entry as one human-readable line, with the
+	// level colored when writing to a terminal, for local development.
+	FormatConsole Format = "console"
+	// FormatJournald writes each entry as one line prefixed with its
+	// syslog priority, such as <6> for info, and without a timestamp, for
+	// systemd's journal, which records both itself.
+	FormatJournald Format = "journald"
+)
+
+// ParseFormat validates a log format name; empty means FormatJSON.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(s))); f {
+	case "":
+		return FormatJSON, nil
+	case FormatJSON, FormatConsole, FormatJournald:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown log format %q (want json, console, or journald)", s)
+}
+
+// ANSI escape sequences used by FormatConsole.
+const (
+	colorReset = "\x1b[0m"
+	colorFaint = "\x1b[2m"
+)
+
+// levelColors are the colors of each level's name in FormatConsole.
+var levelColors = map[Level]string{
+	LevelDebug: "\x1b[90m",
+	LevelInfo:  "\x1b[36m",
+	LevelWarn:  "\x1b[33m",
+	LevelError: "\x1b[31m",
+}
+
+// journaldPriorities are the syslog priorities of each level in
+// FormatJournald.
+var journaldPriorities = map[Level]int{
+	LevelDebug: 7,
+	LevelInfo:  6,
+	LevelWarn:  4,
+	LevelError: 3,
+}
+
 // Logger provides structured logging functionality.
 type Logger struct {
 	level  Level
+	format Format
+	// color enables ANSI colors in FormatConsole.
+	color  bool
 	logger *log.Logger
 }
 
@@ -36,6 +94,7 @@ type LogEntry struct {
 func New(level Level) *Logger {
 	return &Logger{
 		level:  level,
+		format: FormatJSON,
 		logger: log.New(os.Stdout, "", 0),
 	}
 }
@@ -58,11 +117,21 @@ func (l *Logger) log(level Level, message string, fields map[string]interface{})
 		return
 	}
 
+	// Credentials must never reach the log stream, even inside error text.
 	entry := LogEntry{
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		Level:     level,
-		Message:   message,
-		Fields:    fields,
+		Message:   scrub.String(message),
+		Fields:    scrub.Fields(fields),
+	}
+
+	switch l.format {
+	case FormatConsole:
+		l.logger.Println(formatConsole(entry, l.color))
+		return
+	case FormatJournald:
+		l.logger.Println(formatJournald(entry))
+		return
 	}
 
 	jsonData, err := json.Marshal(entry)
@@ -74,6 +143,52 @@ func (l *Logger) log(level Level, message string, fields map[string]interface{})
 	l.logger.Println(string(jsonData))
 }
 
+// formatConsole renders entry as "15:04:05 INFO  message key=value ...",
+// with fields sorted by key and values quoted when they contain spaces.
+// With color, the level is colored and the time and keys are faint.
+func formatConsole(entry LogEntry, color bool) string {
+	paint := func(code, s string) string {
+		if !color {
+			return s
+		}
+		return code + s + colorReset
+	}
+	var b strings.Builder
+	ts := entry.Timestamp
+	if t, err := time.Parse(time.RFC3339, ts); err == nil {
+		ts = t.Local().Format(time.TimeOnly)
+	}
+	level := fmt.Sprintf("%-5s", strings.ToUpper(string(entry.Level)))
+	fmt.Fprintf(&b, "%s %s %s", paint(colorFaint, ts), paint(levelColors[entry.Level], level), entry.Message)
+	writeFields(&b, entry.Fields, func(k string) string { return paint(colorFaint, k) })
+	return b.String()
+}
+
+// formatJournald renders entry as "<6>message key=value ...".
+func formatJournald(entry LogEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>%s", journaldPriorities[entry.Level], entry.Message)
+	writeFields(&b, entry.Fields, func(k string) string { return k })
+	return b.String()
+}
+
+// writeFields appends " key=value" for each field, sorted by key, with
+// values quoted when they contain spaces. key renders the "key=" part.
+func writeFields(b *strings.Builder, fields map[string]interface{}, key func(string) string) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := fmt.Sprint(fields[k])
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(b, " %s%s", key(k+"="), v)
+	}
+}
+
 // Debug logs a debug message with optional fields.
 func (l *Logger) Debug(message string, fields ...map[string]interface{}) {
 	var f map[string]interface{}
@@ -193,6 +308,19 @@ func SetLevel(level Level) {
 	defaultLogger.level = level
 }
 
+// SetFormat sets the global logger format. FormatConsole is colored when
+// standard output is a terminal and NO_COLOR is not set.
+func SetFormat(format Format) {
+	defaultLogger.format = format
+	defaultLogger.color = format == FormatConsole && isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 // Global logging functions
 func Debug(message string, fields ...map[string]interface{}) {
 	defaultLogger.Debug(message, fields...)
@@ -213,3 +341,27 @@ func Error(message string, fields ...map[string]interface{}) {
 func WithFields(fields map[string]interface{}) *ContextLogger {
 	return defaultLogger.WithFields(fields)
 }
+
+type contextKey struct{}
+
+// ContextWithFields returns a copy of ctx whose FromContext logger adds
+// fields (merged over any fields already attached) to every entry.
+func ContextWithFields(ctx context.Context, fields map[string]interface{}) context.Context {
+	merged := make(map[string]interface{}, len(fields))
+	if existing, ok := ctx.Value(contextKey{}).(map[string]interface{}); ok {
+		for k, v := range existing {
+			merged[k] = v
+		}
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, contextKey{}, merged)
+}
+
+// FromContext returns a logger that includes the fields attached to ctx
+// with ContextWithFields, such as the request and trace IDs.
+func FromContext(ctx context.Context) *ContextLogger {
+	fields, _ := ctx.Value(contextKey{}).(map[string]interface{})
+	return defaultLogger.WithFields(fields)
+}
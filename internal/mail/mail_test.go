@@ -0,0 +1,202 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg, err := Format(`"Sentinel" <no-reply@example.com>`, Message{
+		To:      "ana@example.com",
+		Subject: "Código de recuperación usado",
+		Body:    "Line one\nLine two is long enough that quoted-printable has to wrap it somewhere past seventy-six chars.",
+	}, date)
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+
+	parsed, err := netmail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatalf("message does not parse: %v", err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if subject != "Código de recuperación usado" {
+		t.Errorf("subject = %q", subject)
+	}
+	if got := parsed.Header.Get("To"); got != "<ana@example.com>" {
+		t.Errorf("To = %q", got)
+	}
+	if got, _ := parsed.Header.Date(); !got.Equal(date) {
+		t.Errorf("Date = %v", got)
+	}
+	if id := parsed.Header.Get("Message-ID"); !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Message-ID = %q", id)
+	}
+
+	for _, bad := range []Message{
+		{To: "ana@example.com", Subject: "hi\r\nBcc: eve@example.com"},
+		{To: "not an address"},
+	} {
+		if _, err := Format("a@example.com", bad, date); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestFormatHTML(t *testing.T) {
+	msg, err := Format("no-reply@example.com", Message{
+		To:      "ana@example.com",
+		Subject: "Hello",
+		Body:    "Plain text",
+		HTML:    "<p>Rich text</p>",
+	}, time.Now())
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	parsed, err := netmail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatalf("message does not parse: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q", parsed.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "Plain text"},
+		{"text/html; charset=utf-8", "<p>Rich text</p>"},
+	} {
+		part, err := mr.NextPart() // decodes quoted-printable
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Type") != want.contentType || string(body) != want.body {
+			t.Errorf("part %q = %q", part.Header.Get("Content-Type"), body)
+		}
+	}
+}
+
+// TestSMTPSend runs a minimal SMTP server that records the transaction.
+func TestSMTPSend(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	transcript := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var seen strings.Builder
+		io.WriteString(conn, "220 test ESMTP\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			seen.WriteString(line)
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				io.WriteString(conn, "250 test\r\n")
+			case cmd == "DATA":
+				io.WriteString(conn, "354 go ahead\r\n")
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					seen.WriteString(l)
+				}
+				io.WriteString(conn, "250 queued\r\n")
+			case cmd == "QUIT":
+				io.WriteString(conn, "221 bye\r\n")
+				transcript <- seen.String()
+				return
+			default:
+				io.WriteString(conn, "250 ok\r\n")
+			}
+		}
+		transcript <- seen.String()
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	s, err := NewSMTP(host, portNum, "", "", "no-reply@example.com")
+	if err != nil {
+		t.Fatalf("NewSMTP: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Send(ctx, Message{To: "ana@example.com", Subject: "Hello", Body: "Hi Ana"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	got := <-transcript
+	for _, want := range []string{"MAIL FROM:<no-reply@example.com>", "RCPT TO:<ana@example.com>", "Subject: Hello", "Hi Ana"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("transcript missing %q:\n%s", want, got)
+		}
+	}
+
+	if _, err := NewSMTP("", 0, "", "", "no-reply@example.com"); err == nil {
+		t.Error("expected missing host to be rejected")
+	}
+}
+
+func TestSMTPPing(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		io.WriteString(conn, "220 test ESMTP\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(strings.ToUpper(line), "QUIT") {
+				io.WriteString(conn, "221 bye\r\n")
+				return
+			}
+			io.WriteString(conn, "250 ok\r\n")
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	s, _ := NewSMTP(host, portNum, "", "", "no-reply@example.com")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	// Nothing listens once the listener is closed
+	ln.Close()
+	if err := s.Ping(ctx); err == nil {
+		t.Error("expected an unreachable server to fail")
+	}
+}
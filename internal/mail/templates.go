@@ -0,0 +1,331 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Names of the built-in templates.
+const (
+	TemplateVerifyEmail              = "verify-email"
+	TemplateEmailChanged             = "email-changed"
+	TemplateRecoveryCodeUsed         = "recovery-code-used"
+	TemplateRecoveryCodesRegenerated = "recovery-codes-regenerated"
+	TemplateMagicLink                = "magic-link"
+	TemplateAccountExists            = "account-exists"
+	TemplateRegistrationFailed       = "registration-failed"
+	TemplateNewLogin                 = "new-login"
+	TemplateAccountSuspended         = "account-suspended"
+	TemplateAccountReactivated       = "account-reactivated"
+)
+
+// Template parts. Each template has files named <name><suffix>; every
+// built-in has all three.
+const (
+	subjectSuffix = ".subject.tmpl"
+	textSuffix    = ".txt.tmpl"
+	htmlSuffix    = ".html.tmpl"
+)
+
+// TemplateVar documents a variable available to a template.
+type TemplateVar struct {
+	Name        string
+	Description string
+	// Example is used when validating templates.
+	Example interface{}
+}
+
+// TemplateSpec describes a template and the variables it is rendered with.
+type TemplateSpec struct {
+	Name        string
+	Description string
+	Vars        []TemplateVar
+}
+
+// commonVars are passed to every template.
+var commonVars = []TemplateVar{
+	{"Username", "the account's username", "ana"},
+	{"AppName", "the service name shown to users", "Sentinel"},
+	{"Locale", "the recipient's preferred language tag, e.g. \"de\", or empty", "de"},
+}
+
+// Specs lists the built-in templates.
+var Specs = []TemplateSpec{
+	{
+		Name:        TemplateVerifyEmail,
+		Description: "Sent to a new address to confirm an email change",
+		Vars: []TemplateVar{
+			{"Email", "the address being confirmed", "ana@new.example.com"},
+			{"Link", "the confirmation link", "https://auth.example.com/api/auth/email/confirm?token=…"},
+			{"ExpiresIn", "how long the link is valid, e.g. \"24 hours\"", "24 hours"},
+		},
+	},
+	{
+		Name:        TemplateEmailChanged,
+		Description: "Sent to the previous address after an email change, with a link to undo it",
+		Vars: []TemplateVar{
+			{"Email", "the new address", "ana@new.example.com"},
+			{"Link", "the revert link", "https://auth.example.com/api/auth/email/revert?token=…"},
+			{"ExpiresIn", "how long the link is valid", "72 hours"},
+		},
+	},
+	{
+		Name:        TemplateRecoveryCodeUsed,
+		Description: "Sent after a recovery code is used to sign in",
+		Vars: []TemplateVar{
+			{"Remaining", "the number of unused recovery codes left", 9},
+		},
+	},
+	{
+		Name:        TemplateRecoveryCodesRegenerated,
+		Description: "Sent after a new set of recovery codes is generated",
+	},
+	{
+		Name:        TemplateMagicLink,
+		Description: "Sent on request with a single-use link that signs in without a password",
+		Vars: []TemplateVar{
+			{"Link", "the sign-in link", "https://auth.example.com/api/auth/magic-link/verify?token=…"},
+			{"ExpiresIn", "how long the link is valid", "15 minutes"},
+		},
+	},
+	{
+		Name:        TemplateAccountExists,
+		Description: "Sent to an account's address when someone tries to register with it, under enumeration protection",
+		Vars: []TemplateVar{
+			{"Email", "the address registration was attempted with", "ana@example.com"},
+		},
+	},
+	{
+		Name:        TemplateRegistrationFailed,
+		Description: "Sent to the address given at registration when it fails for a reason the response hides, under enumeration protection",
+		Vars: []TemplateVar{
+			{"Reason", "why no account was created", "the username ana is already taken"},
+		},
+	},
+	{
+		Name:        TemplateNewLogin,
+		Description: "Sent after a sign-in from a new device or address, to users who turned on login alerts",
+		Vars: []TemplateVar{
+			{"Device", "the browser and platform signed in from", "Firefox on Linux"},
+			{"IP", "the client address", "203.0.113.7"},
+			{"Country", "the client's country code, when known", "DE"},
+			{"Time", "when the sign-in happened", "2024-05-01 09:14 UTC"},
+		},
+	},
+	{
+		Name:        TemplateAccountSuspended,
+		Description: "Sent when an account is suspended by a scheduled status change that asked to notify the user",
+		Vars: []TemplateVar{
+			{"Reason", "the reason the admin gave, or empty", "contract ended"},
+			{"Time", "when the account was suspended", "2024-05-01 09:14 UTC"},
+		},
+	},
+	{
+		Name:        TemplateAccountReactivated,
+		Description: "Sent when an account is reactivated by a scheduled status change that asked to notify the user",
+		Vars: []TemplateVar{
+			{"Reason", "the reason the admin gave, or empty", "contract renewed"},
+			{"Time", "when the account was reactivated", "2024-05-01 09:14 UTC"},
+		},
+	},
+}
+
+// DefaultAppName is the AppName variable when none is configured.
+const DefaultAppName = "Sentinel"
+
+//go:embed templates
+var builtinTemplates embed.FS
+
+// Templates renders the built-in templates, with any operator overrides.
+type Templates struct {
+	appName string
+	sets    map[string]*templateSet
+}
+
+type templateSet struct {
+	spec    TemplateSpec
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+	// overrides lists the files that replaced built-in parts.
+	overrides []string
+}
+
+var defaultTemplates = func() *Templates {
+	t, err := LoadTemplates("", "")
+	if err != nil {
+		panic(err) // the built-in templates are fixed at build time
+	}
+	return t
+}()
+
+// DefaultTemplates returns the built-in templates.
+func DefaultTemplates() *Templates {
+	return defaultTemplates
+}
+
+// LoadTemplates returns the built-in templates with parts replaced by files
+// in dir (any of <name>.subject.tmpl, <name>.txt.tmpl, <name>.html.tmpl).
+// Empty dir means no overrides, and empty appName means DefaultAppName.
+// Files in dir that match no template part are rejected so a misspelled
+// name does not silently fall back to the built-in.
+func LoadTemplates(dir, appName string) (*Templates, error) {
+	if appName == "" {
+		appName = DefaultAppName
+	}
+	t := &Templates{appName: appName, sets: make(map[string]*templateSet)}
+
+	known := make(map[string]bool)
+	for _, spec := range Specs {
+		for _, suffix := range []string{subjectSuffix, textSuffix, htmlSuffix} {
+			known[spec.Name+suffix] = true
+		}
+	}
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mail templates: %w", err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() && !known[e.Name()] {
+				return nil, fmt.Errorf("unknown mail template file %s", filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+
+	for _, spec := range Specs {
+		set := &templateSet{spec: spec}
+		read := func(suffix string) (string, string, error) {
+			file := spec.Name + suffix
+			if dir != "" {
+				b, err := os.ReadFile(filepath.Join(dir, file))
+				if err == nil {
+					set.overrides = append(set.overrides, filepath.Join(dir, file))
+					return file, string(b), nil
+				}
+				if !errors.Is(err, fs.ErrNotExist) {
+					return "", "", fmt.Errorf("failed to read mail template: %w", err)
+				}
+			}
+			b, err := builtinTemplates.ReadFile("templates/" + file)
+			if err != nil {
+				return "", "", nil // no built-in part
+			}
+			return file, string(b), nil
+		}
+
+		var err error
+		if set.subject, err = parseText(read(subjectSuffix)); err != nil {
+			return nil, err
+		}
+		if set.text, err = parseText(read(textSuffix)); err != nil {
+			return nil, err
+		}
+		if set.subject == nil || set.text == nil {
+			return nil, fmt.Errorf("mail template %s needs a subject and a text body", spec.Name)
+		}
+		name, src, err := read(htmlSuffix)
+		if err != nil {
+			return nil, err
+		}
+		if src != "" {
+			if set.html, err = htmltemplate.New(name).Option("missingkey=error").Parse(src); err != nil {
+				return nil, fmt.Errorf("invalid mail template: %w", err)
+			}
+		}
+		t.sets[spec.Name] = set
+	}
+	return t, nil
+}
+
+func parseText(name, src string, err error) (*texttemplate.Template, error) {
+	if err != nil || src == "" {
+		return nil, err
+	}
+	tmpl, err := texttemplate.New(name).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mail template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// Render builds the message for template name addressed to to. data holds
+// the template's variables; Username must be set by the caller, AppName is
+// filled in, and Locale defaults to empty. Referencing a variable the template does not define is an
+// error.
+func (t *Templates) Render(name, to string, data map[string]interface{}) (Message, error) {
+	set, ok := t.sets[name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown mail template %q", name)
+	}
+	vars := map[string]interface{}{"AppName": t.appName, "Locale": ""}
+	for k, v := range data {
+		vars[k] = v
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := set.subject.Execute(&subject, vars); err != nil {
+		return Message{}, err
+	}
+	if err := set.text.Execute(&text, vars); err != nil {
+		return Message{}, err
+	}
+	if set.html != nil {
+		if err := set.html.Execute(&html, vars); err != nil {
+			return Message{}, err
+		}
+	}
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// AppName returns the service name shown in messages.
+func (t *Templates) AppName() string {
+	return t.appName
+}
+
+// Overrides returns the override files used for template name.
+func (t *Templates) Overrides(name string) []string {
+	if set, ok := t.sets[name]; ok {
+		return set.overrides
+	}
+	return nil
+}
+
+// Validate renders every template with the example values of its
+// documented variables, reporting each template that fails by name.
+func (t *Templates) Validate() map[string]error {
+	failures := make(map[string]error)
+	for _, spec := range Specs {
+		data := make(map[string]interface{})
+		for _, v := range spec.AllVars() {
+			data[v.Name] = v.Example
+		}
+		msg, err := t.Render(spec.Name, "ana@example.com", data)
+		if err == nil && strings.ContainsAny(msg.Subject, "\r\n") {
+			err = errors.New("subject must be a single line")
+		}
+		if err != nil {
+			failures[spec.Name] = err
+		}
+	}
+	return failures
+}
+
+// AllVars returns every variable available to spec's template, the ones
+// common to all templates first.
+func (spec TemplateSpec) AllVars() []TemplateVar {
+	return append(append([]TemplateVar{}, commonVars...), spec.Vars...)
+}
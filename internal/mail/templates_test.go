@@ -0,0 +1,92 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultTemplates(t *testing.T) {
+	tmpl := DefaultTemplates()
+	if failures := tmpl.Validate(); len(failures) != 0 {
+		t.Fatalf("built-in templates fail validation: %v", failures)
+	}
+
+	msg, err := tmpl.Render(TemplateRecoveryCodeUsed, "ana@example.com", map[string]interface{}{
+		"Username":  "ana<script>",
+		"Remaining": 1,
+	})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if msg.To != "ana@example.com" || msg.Subject != "A recovery code was used to sign in" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if !strings.Contains(msg.Body, "Sentinel account (ana<script>)") || !strings.Contains(msg.Body, "1 unused recovery code left") {
+		t.Errorf("unexpected text body:\n%s", msg.Body)
+	}
+	if !strings.Contains(msg.HTML, "ana&lt;script&gt;") {
+		t.Errorf("expected the HTML body to escape variables:\n%s", msg.HTML)
+	}
+
+	if _, err := tmpl.Render(TemplateRecoveryCodeUsed, "ana@example.com", map[string]interface{}{"Username": "ana"}); err == nil {
+		t.Error("expected a missing variable to fail")
+	}
+	if _, err := tmpl.Render("nope", "ana@example.com", nil); err == nil {
+		t.Error("expected an unknown template to fail")
+	}
+}
+
+func TestTemplateOverrides(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("verify-email.subject.tmpl", "{{.AppName}}: confirm {{.Email}}\n")
+	write("verify-email.txt.tmpl", "Hi {{.Username}}, confirm at {{.Link}}\n")
+
+	tmpl, err := LoadTemplates(dir, "Acme ID")
+	if err != nil {
+		t.Fatalf("LoadTemplates: %v", err)
+	}
+	msg, err := tmpl.Render(TemplateVerifyEmail, "ana@new.example.com", map[string]interface{}{
+		"Username": "ana", "Email": "ana@new.example.com", "Link": "https://x/confirm", "ExpiresIn": "24 hours",
+	})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if msg.Subject != "Acme ID: confirm ana@new.example.com" || msg.Body != "Hi ana, confirm at https://x/confirm\n" {
+		t.Errorf("overrides not applied: %+v", msg)
+	}
+	if !strings.Contains(msg.HTML, "Acme ID account") {
+		t.Errorf("expected the built-in HTML part to remain:\n%s", msg.HTML)
+	}
+	if got := tmpl.Overrides(TemplateVerifyEmail); len(got) != 2 {
+		t.Errorf("Overrides = %v", got)
+	}
+
+	// An undocumented variable parses but fails validation
+	write("email-changed.txt.tmpl", "Changed to {{.NewEmail}}\n")
+	tmpl, err = LoadTemplates(dir, "")
+	if err != nil {
+		t.Fatalf("LoadTemplates: %v", err)
+	}
+	if failures := tmpl.Validate(); len(failures) != 1 || failures[TemplateEmailChanged] == nil {
+		t.Errorf("expected only email-changed to fail, got %v", failures)
+	}
+
+	write("email-changed.txt.tmpl", "{{if}}\n")
+	if _, err := LoadTemplates(dir, ""); err == nil {
+		t.Error("expected a syntax error to be rejected")
+	}
+	os.Remove(filepath.Join(dir, "email-changed.txt.tmpl"))
+
+	write("verfy-email.txt.tmpl", "typo")
+	if _, err := LoadTemplates(dir, ""); err == nil || !strings.Contains(err.Error(), "verfy-email.txt.tmpl") {
+		t.Errorf("expected a misspelled file to be rejected, got %v", err)
+	}
+}
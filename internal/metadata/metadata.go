@@ -0,0 +1,145 @@
+// Package metadata enforces per-key access policies and size limits on the
+// free-form JSON metadata integrating applications attach to users.
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Access describes who may read and write a metadata key.
+type Access string
+
+const (
+	// AccessUser keys are readable and writable by the user and by admins.
+	AccessUser Access = "user"
+	// AccessReadOnly keys are readable by the user but writable only by admins.
+	AccessReadOnly Access = "readonly"
+	// AccessAdmin keys are hidden from the user and writable only by admins.
+	AccessAdmin Access = "admin"
+)
+
+// DefaultPolicySpec is used when no policy spec is configured.
+const DefaultPolicySpec = "display_name:user,locale:user,timezone:user,preferences:user"
+
+// DefaultMaxBytes bounds the serialized size of a user's metadata object.
+const DefaultMaxBytes = 4096
+
+var keyRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// Policies maps metadata keys to their access level.
+type Policies struct {
+	keys     map[string]Access
+	fallback Access
+	maxBytes int
+}
+
+// ParsePolicies parses a comma-separated list of key:access pairs, e.g.
+// "locale:user,plan:readonly,notes:admin". Keys not listed get fallback
+// access. maxBytes <= 0 uses DefaultMaxBytes.
+func ParsePolicies(spec string, fallback Access, maxBytes int) (*Policies, error) {
+	if fallback == "" {
+		fallback = AccessAdmin
+	}
+	if !validAccess(fallback) {
+		return nil, fmt.Errorf("invalid metadata default policy %q", fallback)
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+
+	p := &Policies{keys: make(map[string]Access), fallback: fallback, maxBytes: maxBytes}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, access, ok := strings.Cut(entry, ":")
+		key, access = strings.TrimSpace(key), strings.TrimSpace(access)
+		if !ok || !keyRegex.MatchString(key) || !validAccess(Access(access)) {
+			return nil, fmt.Errorf("invalid metadata policy entry %q", entry)
+		}
+		p.keys[key] = Access(access)
+	}
+	return p, nil
+}
+
+// Default returns the built-in policies.
+func Default() *Policies {
+	p, _ := ParsePolicies(DefaultPolicySpec, AccessAdmin, DefaultMaxBytes)
+	return p
+}
+
+func validAccess(a Access) bool {
+	return a == AccessUser || a == AccessReadOnly || a == AccessAdmin
+}
+
+// AccessFor returns the access level for key.
+func (p *Policies) AccessFor(key string) Access {
+	if a, ok := p.keys[key]; ok {
+		return a
+	}
+	return p.fallback
+}
+
+// VisibleToUser returns the subset of m the user may read.
+func (p *Policies) VisibleToUser(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if p.AccessFor(k) != AccessAdmin {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// PolicyError reports a rejected metadata update. Forbidden is set when the
+// caller lacks write access, as opposed to the update being malformed.
+type PolicyError struct {
+	Key       string
+	Message   string
+	Forbidden bool
+}
+
+func (e *PolicyError) Error() string {
+	if e.Key == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("metadata key %q: %s", e.Key, e.Message)
+}
+
+// ApplyPatch merges patch into current following JSON merge-patch rules at
+// the top level (a null value deletes the key) and returns the result.
+// When asAdmin is false only user-writable keys may be changed. The result
+// is checked against the size limit.
+func (p *Policies) ApplyPatch(current, patch map[string]interface{}, asAdmin bool) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(current)+len(patch))
+	for k, v := range current {
+		out[k] = v
+	}
+
+	for k, v := range patch {
+		if !keyRegex.MatchString(k) {
+			return nil, &PolicyError{Key: k, Message: "keys must be 1-64 characters of letters, digits, '_', '-', or '.'"}
+		}
+		if !asAdmin && p.AccessFor(k) != AccessUser {
+			return nil, &PolicyError{Key: k, Message: "not writable by users", Forbidden: true}
+		}
+		if v == nil {
+			delete(out, k)
+			continue
+		}
+		out[k] = v
+	}
+
+	encoded, err := json.Marshal(out)
+	if err != nil {
+		return nil, &PolicyError{Message: "metadata is not valid JSON"}
+	}
+	if len(encoded) > p.maxBytes {
+		return nil, &PolicyError{Message: fmt.Sprintf("metadata exceeds %d bytes", p.maxBytes)}
+	}
+	return out, nil
+}
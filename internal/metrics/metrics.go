@@ -0,0 +1,418 @@
+// Package metrics implements a small, dependency-free registry of counters,
+// gauges, and histograms rendered in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are latency buckets in seconds suited to HTTP and DB calls.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// Registry holds metric families and renders them for scraping.
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Default is the process-wide registry used by the package-level constructors.
+var Default = NewRegistry()
+
+// family is a named metric with a fixed label schema and many series.
+type family struct {
+	name       string
+	help       string
+	typ        metricType
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+	funcs  map[string]funcSeries
+}
+
+type series struct {
+	mu          sync.Mutex
+	labelValues []string
+	value       float64
+	counts      []uint64 // histogram bucket counts (non-cumulative)
+	sum         float64
+	count       uint64
+}
+
+type funcSeries struct {
+	labelValues []string
+	fn          func() float64
+}
+
+// register returns the family called name, creating it if needed. Re-registering
+// with the same shape returns the existing family, so constructors are idempotent.
+func (r *Registry) register(name, help string, typ metricType, buckets []float64, labelNames []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || strings.Join(f.labelNames, ",") != strings.Join(labelNames, ",") {
+			panic(fmt.Sprintf("metrics: %s re-registered with a different type or labels", name))
+		}
+		return f
+	}
+	f := &family{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*series),
+		funcs:      make(map[string]funcSeries),
+	}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.typ == typeHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) setFunc(labelValues []string, fn func() float64) {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.funcs[strings.Join(labelValues, "\xff")] = funcSeries{labelValues: append([]string(nil), labelValues...), fn: fn}
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct{ s *series }
+
+// Inc adds one.
+func (c Counter) Inc() { c.Add(1) }
+
+// Add adds v, which must be non-negative.
+func (c Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.s.mu.Lock()
+	c.s.value += v
+	c.s.mu.Unlock()
+}
+
+// Value returns the current count.
+func (c Counter) Value() float64 {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	return c.s.value
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ s *series }
+
+// Set replaces the value.
+func (g Gauge) Set(v float64) {
+	g.s.mu.Lock()
+	g.s.value = v
+	g.s.mu.Unlock()
+}
+
+// Add adds v (which may be negative).
+func (g Gauge) Add(v float64) {
+	g.s.mu.Lock()
+	g.s.value += v
+	g.s.mu.Unlock()
+}
+
+// Value returns the current value.
+func (g Gauge) Value() float64 {
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+	return g.s.value
+}
+
+// Histogram samples observations into buckets.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// Observe records v.
+func (h Histogram) Observe(v float64) {
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.s.counts[i]++
+			break
+		}
+	}
+	h.s.sum += v
+	h.s.count++
+}
+
+// CounterVec is a counter family partitioned by labels.
+type CounterVec struct{ f *family }
+
+// WithLabelValues returns the counter for the given label values.
+func (v CounterVec) WithLabelValues(values ...string) Counter { return Counter{v.f.get(values)} }
+
+// GaugeVec is a gauge family partitioned by labels.
+type GaugeVec struct{ f *family }
+
+// WithLabelValues returns the gauge for the given label values.
+func (v GaugeVec) WithLabelValues(values ...string) Gauge { return Gauge{v.f.get(values)} }
+
+// SetFunc makes the series for values report fn() at scrape time.
+func (v GaugeVec) SetFunc(fn func() float64, values ...string) { v.f.setFunc(values, fn) }
+
+// HistogramVec is a histogram family partitioned by labels.
+type HistogramVec struct{ f *family }
+
+// WithLabelValues returns the histogram for the given label values.
+func (v HistogramVec) WithLabelValues(values ...string) Histogram {
+	return Histogram{s: v.f.get(values), buckets: v.f.buckets}
+}
+
+// NewCounterVec registers a labelled counter family.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) CounterVec {
+	return CounterVec{r.register(name, help, typeCounter, nil, labelNames)}
+}
+
+// NewGaugeVec registers a labelled gauge family.
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) GaugeVec {
+	return GaugeVec{r.register(name, help, typeGauge, nil, labelNames)}
+}
+
+// NewHistogramVec registers a labelled histogram family. Nil buckets use DefaultBuckets.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return HistogramVec{r.register(name, help, typeHistogram, sorted, labelNames)}
+}
+
+// NewCounterFunc registers a counter whose value is read from fn at scrape
+// time (for cumulative values maintained elsewhere, such as sql.DBStats).
+func (r *Registry) NewCounterFunc(name, help string, labelNames []string, labelValues []string, fn func() float64) {
+	r.register(name, help, typeCounter, nil, labelNames).setFunc(labelValues, fn)
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn at scrape time.
+func (r *Registry) NewGaugeFunc(name, help string, labelNames []string, labelValues []string, fn func() float64) {
+	r.register(name, help, typeGauge, nil, labelNames).setFunc(labelValues, fn)
+}
+
+// NewCounterVec registers a labelled counter family in Default.
+func NewCounterVec(name, help string, labelNames ...string) CounterVec {
+	return Default.NewCounterVec(name, help, labelNames...)
+}
+
+// NewGaugeVec registers a labelled gauge family in Default.
+func NewGaugeVec(name, help string, labelNames ...string) GaugeVec {
+	return Default.NewGaugeVec(name, help, labelNames...)
+}
+
+// NewHistogramVec registers a labelled histogram family in Default.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labelNames...)
+}
+
+// Sum returns the total of every series in the named family whose labels
+// include all of match (nil matches everything). Histograms contribute their
+// observation count. Unknown families sum to zero.
+func (r *Registry) Sum(name string, match map[string]string) float64 {
+	r.mu.RLock()
+	f, ok := r.families[name]
+	r.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+
+	matches := func(values []string) bool {
+		for k, want := range match {
+			found := false
+			for i, n := range f.labelNames {
+				if n == k {
+					found = values[i] == want
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var total float64
+	for _, s := range f.series {
+		if !matches(s.labelValues) {
+			continue
+		}
+		s.mu.Lock()
+		if f.typ == typeHistogram {
+			total += float64(s.count)
+		} else {
+			total += s.value
+		}
+		s.mu.Unlock()
+	}
+	for _, fs := range f.funcs {
+		if matches(fs.labelValues) {
+			total += fs.fn()
+		}
+	}
+	return total
+}
+
+// WriteTo renders every family in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		r.mu.RLock()
+		f := r.families[name]
+		r.mu.RUnlock()
+		f.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (f *family) write(b *strings.Builder) {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.series)+len(f.funcs))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	for k := range f.funcs {
+		if _, dup := f.series[k]; !dup {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	series := make([]*series, 0, len(keys))
+	funcs := make([]funcSeries, 0, len(keys))
+	for _, k := range keys {
+		if s, ok := f.series[k]; ok {
+			series = append(series, s)
+		} else {
+			funcs = append(funcs, f.funcs[k])
+		}
+	}
+	f.mu.Unlock()
+
+	if len(series) == 0 && len(funcs) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.typ)
+
+	for _, s := range series {
+		s.mu.Lock()
+		if f.typ == typeHistogram {
+			var cumulative uint64
+			for i, upper := range f.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, f.labels(s.labelValues, "le", formatFloat(upper)), cumulative)
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, f.labels(s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(b, "%s_sum%s %s\n", f.name, f.labels(s.labelValues, "", ""), formatFloat(s.sum))
+			fmt.Fprintf(b, "%s_count%s %d\n", f.name, f.labels(s.labelValues, "", ""), s.count)
+		} else {
+			fmt.Fprintf(b, "%s%s %s\n", f.name, f.labels(s.labelValues, "", ""), formatFloat(s.value))
+		}
+		s.mu.Unlock()
+	}
+	for _, fs := range funcs {
+		fmt.Fprintf(b, "%s%s %s\n", f.name, f.labels(fs.labelValues, "", ""), formatFloat(fs.fn()))
+	}
+}
+
+// labels renders {name="value",...}, optionally with one extra pair.
+func (f *family) labels(values []string, extraName, extraValue string) string {
+	if len(values) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(values)+1)
+	for i, v := range values {
+		pairs = append(pairs, f.labelNames[i]+`="`+escapeLabel(v)+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests served.", "route")
+	requests.WithLabelValues(`/a"b`).Add(2)
+	requests.WithLabelValues("/c").Inc()
+
+	latency := r.NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	latency.WithLabelValues("/a").Observe(0.05)
+	latency.WithLabelValues("/a").Observe(0.5)
+	latency.WithLabelValues("/a").Observe(5)
+
+	r.NewGaugeFunc("test_up", "Always one.", nil, nil, func() float64 { return 1 })
+
+	// Re-registering the same family is idempotent
+	r.NewCounterVec("test_requests_total", "Requests served.", "route").WithLabelValues("/c").Inc()
+
+	var out strings.Builder
+	if _, err := r.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+
+	want := `# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/a",le="0.1"} 1
+test_latency_seconds_bucket{route="/a",le="1"} 2
+test_latency_seconds_bucket{route="/a",le="+Inf"} 3
+test_latency_seconds_sum{route="/a"} 5.55
+test_latency_seconds_count{route="/a"} 3
+# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"b"} 2
+test_requests_total{route="/c"} 2
+# HELP test_up Always one.
+# TYPE test_up gauge
+test_up 1
+`
+	if out.String() != want {
+		t.Fatalf("unexpected exposition:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
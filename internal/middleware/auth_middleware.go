@@ -2,34 +2,68 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/dpop"
+	"github.com/mayvqt/Sentinel/internal/httpjson"
 )
 
+// CanaryReporter is implemented by canary sets (see auth.Auth.SetCanaries)
+// that want to hear about each request presenting a canary token.
+type CanaryReporter interface {
+	ReportCanaryToken(r *http.Request, c *auth.Claims)
+}
+
+// ReportCanaryToken passes a canary token rejection from a.ParseToken to
+// a's canary set when it is a CanaryReporter. Other errors are ignored.
+func ReportCanaryToken(a *auth.Auth, r *http.Request, err error) {
+	var canary *auth.CanaryTokenError
+	if !errors.As(err, &canary) {
+		return
+	}
+	if rep, ok := a.Canaries().(CanaryReporter); ok {
+		rep.ReportCanaryToken(r, canary.Claims)
+	}
+}
+
 // WithAuth validates Bearer tokens and stores claims in request context.
+// Tokens bound to a DPoP key must instead be sent with the DPoP scheme and
+// a proof of possession, checked by a's proof verifier.
 func WithAuth(a *auth.Auth) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
+				auth.RecordTokenRejection(auth.ReasonMissing)
 				writeAuthError(w, "Authorization header required", http.StatusUnauthorized)
 				return
 			}
 
-			// Expect format: "Bearer <token>"
-			const bearerPrefix = "Bearer "
-			if len(authHeader) <= len(bearerPrefix) || authHeader[:len(bearerPrefix)] != bearerPrefix {
+			// Expect format: "Bearer <token>" or "DPoP <token>"
+			scheme, token, ok := strings.Cut(authHeader, " ")
+			if !ok || token == "" || (scheme != "Bearer" && scheme != dpop.Scheme) {
+				auth.RecordTokenRejection(auth.ReasonMalformed)
 				writeAuthError(w, "Invalid authorization header format", http.StatusUnauthorized)
 				return
 			}
 
-			token := authHeader[len(bearerPrefix):]
 			claims, err := a.ParseToken(token)
 			if err != nil {
+				ReportCanaryToken(a, r, err)
+				writeAuthError(w, "Invalid or expired token", http.StatusUnauthorized)
+				return
+			}
+			if !auth.BearerTokenType(claims.TokenType) {
+				auth.RecordTokenRejection(auth.ReasonWrongType)
 				writeAuthError(w, "Invalid or expired token", http.StatusUnauthorized)
 				return
 			}
+			if !checkProof(w, r, a, scheme, token, claims) {
+				return
+			}
 
 			// Add claims to request context
 			ctx := context.WithValue(r.Context(), "user", claims)
@@ -38,9 +72,62 @@ func WithAuth(a *auth.Auth) func(http.Handler) http.Handler {
 	}
 }
 
+// checkProof requires the DPoP scheme and a valid proof for tokens bound
+// to a key, and the Bearer scheme for others. It returns false, having
+// written an error, when the request may not proceed.
+func checkProof(w http.ResponseWriter, r *http.Request, a *auth.Auth, scheme, token string, claims *auth.Claims) bool {
+	if claims.BoundKey() == "" {
+		if scheme == dpop.Scheme {
+			writeDPoPError(w, "Token is not bound to a DPoP key")
+			return false
+		}
+		return true
+	}
+	verifier := a.ProofVerifier()
+	if scheme != dpop.Scheme || verifier == nil {
+		writeDPoPError(w, "Token must be sent with the DPoP scheme and a proof")
+		return false
+	}
+	err := verifier.VerifyProof(r, token, claims)
+	if errors.Is(err, dpop.ErrUnavailable) {
+		writeAuthError(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	if err != nil {
+		writeDPoPError(w, err.Error())
+		return false
+	}
+	return true
+}
+
+// writeDPoPError writes a 401 asking for a DPoP proof.
+func writeDPoPError(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `DPoP error="invalid_dpop_proof", algs="`+strings.Join(dpop.Algorithms(), " ")+`"`)
+	writeAuthError(w, message, http.StatusUnauthorized)
+}
+
+// RequireRole rejects requests whose authenticated claims do not carry one of
+// the given roles. It must run after WithAuth.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value("user").(*auth.Claims)
+			if !ok {
+				writeAuthError(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			for _, role := range roles {
+				if claims.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			writeAuthError(w, "Insufficient permissions", http.StatusForbidden)
+		})
+	}
+}
+
 // writeAuthError writes a structured authentication error response.
 func writeAuthError(w http.ResponseWriter, message string, statusCode int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	w.Write([]byte(`{"error":"` + http.StatusText(statusCode) + `","message":"` + message + `"}`))
+	httpjson.Error(w, message, statusCode)
 }
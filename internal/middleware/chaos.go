@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/httpjson"
+	"github.com/mayvqt/Sentinel/internal/logger"
+)
+
+// ChaosHeader names the faults injected into a response, so that clients
+// under test can tell them from real failures. A dropped connection has
+// no response to carry it.
+const ChaosHeader = "X-Chaos-Fault"
+
+// Chaos describes the faults WithChaos injects. Each percentage, from 0 to
+// 100, is the chance that a request gets that fault; a request may be both
+// delayed and then failed or dropped.
+type Chaos struct {
+	LatencyPercent int
+	Latency        time.Duration
+	// ErrorPercent of requests are answered ErrorStatus (503 when zero)
+	// without being handled.
+	ErrorPercent int
+	ErrorStatus  int
+	// DropPercent of requests have their connection closed without a
+	// response.
+	DropPercent int
+	// Paths limits faults to requests whose path starts with one of them;
+	// empty means every request.
+	Paths []string
+}
+
+// Enabled reports whether c injects any fault.
+func (c Chaos) Enabled() bool {
+	return (c.LatencyPercent > 0 && c.Latency > 0) || c.ErrorPercent > 0 || c.DropPercent > 0
+}
+
+func (c Chaos) applies(path string) bool {
+	if len(c.Paths) == 0 {
+		return true
+	}
+	for _, p := range c.Paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// chance reports true percent times out of 100.
+func chance(percent int) bool {
+	return percent > 0 && rand.IntN(100) < percent
+}
+
+// WithChaos injects the faults c describes into requests, for testing how
+// clients cope with a slow or failing Sentinel. It is for development
+// and staging only.
+func WithChaos(c Chaos) func(http.Handler) http.Handler {
+	status := c.ErrorStatus
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !c.applies(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if c.Latency > 0 && chance(c.LatencyPercent) {
+				w.Header().Add(ChaosHeader, "latency")
+				t := time.NewTimer(c.Latency)
+				select {
+				case <-t.C:
+				case <-r.Context().Done():
+					t.Stop()
+					return
+				}
+			}
+			switch {
+			case chance(c.DropPercent):
+				// Request logging never sees the request finish, so the
+				// drop is logged here.
+				logger.FromContext(r.Context()).Info("Dropped connection for chaos testing", map[string]interface{}{
+					"method": r.Method,
+					"path":   r.URL.Path,
+				})
+				// The server closes the connection, or resets the stream
+				// under HTTP/2, without logging a panic.
+				panic(http.ErrAbortHandler)
+			case chance(c.ErrorPercent):
+				w.Header().Add(ChaosHeader, "error")
+				if status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "1")
+				}
+				httpjson.Error(w, "Injected fault", status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
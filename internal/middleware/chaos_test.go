@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChaos(t *testing.T) {
+	handled := false
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handled = true })
+	serve := func(c Chaos, path string) *httptest.ResponseRecorder {
+		handled = false
+		w := httptest.NewRecorder()
+		WithChaos(c)(ok).ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if (Chaos{Latency: time.Second}).Enabled() || (Chaos{LatencyPercent: 100}).Enabled() {
+		t.Error("latency without a percentage or a delay should not enable chaos")
+	}
+
+	// Errors are answered without handling the request, and marked.
+	w := serve(Chaos{ErrorPercent: 100, Paths: []string{"/api/"}}, "/api/auth/login")
+	if w.Code != http.StatusServiceUnavailable || handled || w.Header().Get(ChaosHeader) != "error" || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("got %d (handled %v, headers %v), want an injected 503", w.Code, handled, w.Header())
+	}
+	w = serve(Chaos{ErrorPercent: 100, ErrorStatus: http.StatusInternalServerError}, "/health")
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Retry-After") != "" {
+		t.Errorf("got %d, want an injected 500 without Retry-After", w.Code)
+	}
+
+	// Paths outside the configured prefixes are left alone.
+	w = serve(Chaos{ErrorPercent: 100, Paths: []string{"/api/"}}, "/health")
+	if w.Code != http.StatusOK || !handled || w.Header().Get(ChaosHeader) != "" {
+		t.Errorf("got %d (handled %v), want /health untouched", w.Code, handled)
+	}
+
+	// Delayed requests are still handled.
+	start := time.Now()
+	w = serve(Chaos{LatencyPercent: 100, Latency: 20 * time.Millisecond}, "/api/auth/profile")
+	if time.Since(start) < 20*time.Millisecond || !handled || w.Header().Get(ChaosHeader) != "latency" {
+		t.Errorf("expected a delayed, handled request; took %v, handled %v", time.Since(start), handled)
+	}
+	if w := serve(Chaos{ErrorPercent: 0, DropPercent: 0}, "/api/auth/profile"); w.Code != http.StatusOK || !handled {
+		t.Error("expected requests to pass with no faults configured")
+	}
+
+	// Dropped requests get no response at all.
+	srv := httptest.NewServer(WithChaos(Chaos{DropPercent: 100})(ok))
+	defer srv.Close()
+	handled = false
+	resp, err := http.Get(srv.URL + "/api/auth/profile")
+	if err == nil {
+		resp.Body.Close()
+		t.Errorf("expected a dropped connection, got %d", resp.StatusCode)
+	}
+	if handled {
+		t.Error("dropped request was handled")
+	}
+}
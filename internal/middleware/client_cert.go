@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/mtls"
+	"github.com/mayvqt/Sentinel/internal/rbac"
+)
+
+// WithClientIdentity resolves a verified TLS client certificate to its
+// service account and stores the identity in the request context (see
+// mtls.FromContext). Requests without a client certificate pass through
+// unchanged.
+func WithClientIdentity(accounts *mtls.Accounts) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := accounts.FromConnectionState(r.TLS)
+			if id == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			fields := map[string]interface{}{"client_identity": id.Subject}
+			if id.Account != nil {
+				fields["service_account"] = id.Account.Name
+			}
+			ctx := mtls.ContextWithIdentity(r.Context(), id)
+			ctx = logger.ContextWithFields(ctx, fields)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AllowScope admits requests from service accounts granted scope without
+// further checks; every other request goes through otherwise, typically
+// WithAuth and RequireRole.
+func AllowScope(scope string, otherwise ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fallback := next
+		for i := len(otherwise) - 1; i >= 0; i-- {
+			fallback = otherwise[i](fallback)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if mtls.FromContext(r.Context()).HasScope(scope) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			fallback.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireCapability admits admins, delegated admins whose role grants
+// capability (see rbac.Roles), callers whose token's elevated scopes
+// include it or admin, and service accounts granted capability or the
+// admin scope. Other authenticated callers get a 403. With
+// rbac.RoleAdmin as capability only admins and admin service accounts are
+// admitted.
+func RequireCapability(a *auth.Auth, roles *rbac.Roles, capability string) func(http.Handler) http.Handler {
+	return AllowScope(mtls.ScopeAdmin, AllowScope(capability, WithAuth(a), requireGrant(roles, capability)))
+}
+
+// requireGrant rejects requests whose role does not grant capability. It
+// must run after WithAuth.
+func requireGrant(roles *rbac.Roles, capability string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value("user").(*auth.Claims)
+			if !ok {
+				writeAuthError(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			if !roles.Allows(claims.Role, capability) &&
+				!slices.Contains(claims.Scopes, capability) && !slices.Contains(claims.Scopes, rbac.RoleAdmin) {
+				writeAuthError(w, "Insufficient permissions", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
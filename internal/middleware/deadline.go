@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/httpjson"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/mtls"
+)
+
+// Headers carrying a caller's deadline.
+const (
+	// RequestDeadlineHeader holds the time, in RFC 3339, by which the
+	// caller needs an answer.
+	RequestDeadlineHeader = "X-Request-Deadline"
+	// GRPCTimeoutHeader holds how long the caller will wait, in the gRPC
+	// format: up to eight digits and a unit (H, M, S, m, u, or n), such as
+	// 250m for 250 milliseconds.
+	GRPCTimeoutHeader = "Grpc-Timeout"
+)
+
+// grpcTimeoutUnits maps gRPC timeout units to durations.
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// WithRequestDeadline bounds the request context by the deadline a
+// service account caller sends in RequestDeadlineHeader or
+// GRPCTimeoutHeader, so that work it has given up on, store queries
+// included, is abandoned. Only deadlines sooner than max from now apply;
+// headers from other callers, and malformed ones, are ignored. Requests
+// whose deadline has already passed are answered 504 without being
+// handled.
+func WithRequestDeadline(max time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id := mtls.FromContext(r.Context()); id == nil || id.Account == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			now := time.Now()
+			deadline, err := parseDeadline(r.Header, now)
+			if err != nil {
+				logger.FromContext(r.Context()).Warn("Ignoring malformed request deadline", map[string]interface{}{
+					"error": err.Error(),
+				})
+			}
+			if err != nil || deadline.IsZero() || !deadline.Before(now.Add(max)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !deadline.After(now) {
+				httpjson.Error(w, "Request deadline exceeded", http.StatusGatewayTimeout)
+				return
+			}
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+			ctx = logger.ContextWithFields(ctx, map[string]interface{}{
+				"deadline_ms": deadline.Sub(now).Milliseconds(),
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// parseDeadline returns the deadline in h, the sooner of the two when both
+// headers are set, or the zero time when neither is.
+func parseDeadline(h http.Header, now time.Time) (time.Time, error) {
+	var deadline time.Time
+	if v := h.Get(RequestDeadlineHeader); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, errors.New(RequestDeadlineHeader + " must be an RFC 3339 timestamp")
+		}
+		deadline = t
+	}
+	if v := h.Get(GRPCTimeoutHeader); v != "" {
+		d, err := parseGRPCTimeout(v)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t := now.Add(d); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	return deadline, nil
+}
+
+// parseGRPCTimeout parses a gRPC timeout value such as 250m.
+func parseGRPCTimeout(v string) (time.Duration, error) {
+	invalid := errors.New(GRPCTimeoutHeader + " must be up to 8 digits and a unit (H, M, S, m, u, or n)")
+	if len(v) < 2 || len(v) > 9 {
+		return 0, invalid
+	}
+	unit, ok := grpcTimeoutUnits[v[len(v)-1]]
+	if !ok {
+		return 0, invalid
+	}
+	n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+	if err != nil {
+		return 0, invalid
+	}
+	// Only hours can overflow a Duration; clamp them to a value still far
+	// beyond any server limit.
+	if unit == time.Hour && n > 1<<20 {
+		n = 1 << 20
+	}
+	return time.Duration(n) * unit, nil
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/mtls"
+)
+
+func TestRequestDeadline(t *testing.T) {
+	var remaining time.Duration
+	h := WithRequestDeadline(15 * time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remaining = 0
+		if deadline, ok := r.Context().Deadline(); ok {
+			remaining = time.Until(deadline)
+		}
+	}))
+	serve := func(trusted bool, header, value string) int {
+		remaining = -1
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/profile", nil)
+		req.Header.Set(header, value)
+		if trusted {
+			id := &mtls.Identity{Subject: "cn:gateway", Account: &mtls.ServiceAccount{Name: "gateway"}}
+			req = req.WithContext(mtls.ContextWithIdentity(req.Context(), id))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, tc := range []struct {
+		name    string
+		trusted bool
+		header  string
+		value   string
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"grpc timeout", true, GRPCTimeoutHeader, "2S", time.Second, 2 * time.Second},
+		{"milliseconds", true, GRPCTimeoutHeader, "500m", 0, 500 * time.Millisecond},
+		{"absolute deadline", true, RequestDeadlineHeader, time.Now().Add(3 * time.Second).Format(time.RFC3339Nano), 2 * time.Second, 3 * time.Second},
+		{"beyond the server limit", true, GRPCTimeoutHeader, "1M", 0, 0},
+		{"malformed", true, GRPCTimeoutHeader, "2s", 0, 0},
+		{"untrusted caller", false, GRPCTimeoutHeader, "2S", 0, 0},
+	} {
+		if code := serve(tc.trusted, tc.header, tc.value); code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tc.name, code)
+		}
+		if remaining < tc.min || remaining > tc.max {
+			t.Errorf("%s: expected a deadline between %v and %v away, got %v", tc.name, tc.min, tc.max, remaining)
+		}
+	}
+
+	if code := serve(true, RequestDeadlineHeader, time.Now().Add(-time.Second).Format(time.RFC3339)); code != http.StatusGatewayTimeout || remaining != -1 {
+		t.Errorf("expected a passed deadline to be answered 504 without handling, got %d", code)
+	}
+}
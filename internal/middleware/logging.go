@@ -1,10 +1,27 @@
 package middleware
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	"github.com/mayvqt/Sentinel/internal/tracing"
+)
+
+var (
+	httpResponses = metrics.NewCounterVec(
+		"sentinel_http_responses_total",
+		"HTTP responses by route and status class (2xx, 4xx, 5xx, ...).",
+		"route", "code",
+	)
+	httpDuration = metrics.NewHistogramVec(
+		"sentinel_http_request_duration_seconds",
+		"HTTP request latency by route.",
+		nil, "route",
+	)
 )
 
 // responseWriter records status and response size for logging.
@@ -28,7 +45,13 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
-// WithLogging returns middleware that logs HTTP requests.
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// WithLogging returns middleware that logs HTTP requests in the format and
+// to the destination configured with SetAccessLog.
 func WithLogging() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -41,7 +64,7 @@ func WithLogging() func(http.Handler) http.Handler {
 			}
 
 			// Get client IP
-			clientIP := getClientIP(r)
+			clientIP := ClientIP(r)
 
 			// Process request
 			next.ServeHTTP(wrapped, r)
@@ -49,6 +72,14 @@ func WithLogging() func(http.Handler) http.Handler {
 			// Log request details
 			duration := time.Since(start)
 
+			status := wrapped.statusCode
+			if status == 0 {
+				status = http.StatusOK
+			}
+			route := routeLabel(r)
+			httpResponses.WithLabelValues(route, strconv.Itoa(status/100)+"xx").Inc()
+			httpDuration.WithLabelValues(route).Observe(duration.Seconds())
+
 			fields := map[string]interface{}{
 				"method":      r.Method,
 				"path":        r.URL.Path,
@@ -59,9 +90,9 @@ func WithLogging() func(http.Handler) http.Handler {
 				"bytes":       wrapped.written,
 			}
 
-			// Add request ID if available
-			if requestID := GetRequestID(r.Context()); requestID != "" {
-				fields["request_id"] = requestID
+			// Add request and trace IDs if available
+			for k, v := range tracing.LogFields(r.Context()) {
+				fields[k] = v
 			}
 
 			// Add query parameters if present
@@ -69,13 +100,43 @@ func WithLogging() func(http.Handler) http.Handler {
 				fields["query"] = r.URL.RawQuery
 			}
 
+			format, out := accessLogTarget()
+			switch format {
+			case AccessLogCommon, AccessLogCombined:
+				if out != nil {
+					writeAccessLine(out, formatCLF(r, clientIP, status, wrapped.written, start, format == AccessLogCombined))
+				}
+				return
+			}
+
 			// Log level based on status code
-			message := "HTTP request processed"
+			level := logger.LevelInfo
 			if wrapped.statusCode >= 500 {
-				logger.Error(message, fields)
+				level = logger.LevelError
 			} else if wrapped.statusCode >= 400 {
+				level = logger.LevelWarn
+			}
+			const message = "HTTP request processed"
+
+			if out != nil {
+				line, err := json.Marshal(logger.LogEntry{
+					Timestamp: time.Now().UTC().Format(time.RFC3339),
+					Level:     level,
+					Message:   message,
+					Fields:    fields,
+				})
+				if err == nil {
+					writeAccessLine(out, line)
+				}
+				return
+			}
+
+			switch level {
+			case logger.LevelError:
+				logger.Error(message, fields)
+			case logger.LevelWarn:
 				logger.Warn(message, fields)
-			} else {
+			default:
 				logger.Info(message, fields)
 			}
 		})
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mayvqt/Sentinel/internal/httpjson"
+)
+
+// WithErrorFormat negotiates the format of error responses through Accept.
+// Clients that accept application/problem+json at least as readily as
+// application/json get RFC 7807 problem details; clients that ask for
+// application/json get the usual error body. Everyone else gets problem
+// details when problemDefault is set.
+func WithErrorFormat(problemDefault bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept")
+			if prefersProblems(r.Header.Get("Accept"), problemDefault) {
+				w = httpjson.PreferProblems(w)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// prefersProblems reports whether an Accept header asks for problem
+// details rather than plain JSON errors, falling back to problemDefault
+// when it names neither.
+func prefersProblems(header string, problemDefault bool) bool {
+	problemQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					quality = f
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case httpjson.ProblemContentType:
+			problemQ = quality
+		case "application/json":
+			jsonQ = quality
+		}
+	}
+	switch {
+	case problemQ > 0 && problemQ >= jsonQ:
+		return true
+	case jsonQ > 0:
+		return false
+	}
+	return problemDefault
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import "net/http"
+
+// QuotaEnforcer admits or rejects a request against its client's request
+// quota, writing the response itself when it rejects one. quota.Enforcer
+// implements it.
+type QuotaEnforcer interface {
+	Enforce(w http.ResponseWriter, r *http.Request) bool
+}
+
+// WithQuota enforces per-client request quotas. It must run after
+// authentication, which identifies the client.
+func WithQuota(q QuotaEnforcer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if q.Enforce(w, r) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -2,41 +2,143 @@
 package middleware
 
 import (
-	"encoding/json"
+	"container/list"
+	"context"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/mayvqt/Sentinel/internal/httpjson"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// rateLimitDecisions counts allowed and rejected requests per route so
+// credential-stuffing spikes show up as rejections on the auth routes.
+var rateLimitDecisions = metrics.NewCounterVec(
+	"sentinel_ratelimit_requests_total",
+	"Requests seen by the rate limiter, by route and decision (allowed or rejected).",
+	"route", "decision",
+)
+
+// rateLimitWarnings counts clients reported for nearing a rate limit.
+var rateLimitWarnings = metrics.NewCounterVec(
+	"sentinel_ratelimit_warnings_total",
+	"Clients that used most of their rate limit burst, by route.",
+	"route",
+)
+
+// rateLimitBackendErrors counts failed lookups in a shared rate limit
+// backend; such requests are allowed.
+var rateLimitBackendErrors = metrics.NewCounterVec(
+	"sentinel_ratelimit_backend_errors_total",
+	"Failed lookups in the shared rate limit backend; the requests were allowed.",
 )
 
-// RateLimiter is a token-bucket limiter optimized for concurrency.
+// rateLimitVisitors reports how many clients each limiter tracks in memory.
+var rateLimitVisitors = metrics.NewGaugeVec(
+	"sentinel_ratelimit_visitors",
+	"Clients tracked in memory by each rate limiter.",
+	"limiter",
+)
+
+// rateLimitEvictions counts clients dropped because a limiter was full,
+// which happens under floods of distinct (often spoofed) client IPs.
+var rateLimitEvictions = metrics.NewCounterVec(
+	"sentinel_ratelimit_evictions_total",
+	"Clients evicted from a full rate limiter, least recently active first.",
+	"limiter",
+)
+
+// DefaultRateLimitMaxEntries is the number of clients a limiter tracks
+// unless SetMaxEntries changes it. Each takes a few hundred bytes.
+const DefaultRateLimitMaxEntries = 100_000
+
+// RateLimitBackend holds token buckets shared by every instance, so that
+// a client's limit applies across all of them rather than to each.
+// store.Store implements it.
+type RateLimitBackend interface {
+	// TakeRateLimitToken refills the bucket for key with one token per
+	// rate since its last refill, up to capacity, then takes a token if
+	// one is left. It returns whether a token was taken and how many
+	// remain.
+	TakeRateLimitToken(ctx context.Context, key string, rate time.Duration, capacity int, now time.Time) (bool, int, error)
+}
+
+// RateLimitWarnCooldown is the minimum time between warnings for one
+// client of a limiter, so a client hovering near its limit is reported
+// once a minute rather than on every request.
+const RateLimitWarnCooldown = time.Minute
+
+// RateLimitWarning describes a client that has used most of its burst
+// capacity and will soon be rejected with 429.
+type RateLimitWarning struct {
+	Key      string `json:"key"`
+	Route    string `json:"route"`
+	Used     int    `json:"used"`
+	Capacity int    `json:"capacity"`
+}
+
+// RateLimitWarnFunc is called for each warning, in the request that
+// triggered it.
+type RateLimitWarnFunc func(r *http.Request, w RateLimitWarning)
+
+// RateLimiter is a token-bucket limiter optimized for concurrency. It
+// tracks at most maxEntries clients; when a new client arrives at a full
+// limiter, the least recently active one is forgotten, so a flood of
+// spoofed addresses costs bounded memory. A forgotten client starts over
+// with a full bucket.
 type RateLimiter struct {
-	mu       sync.RWMutex
+	mu       sync.Mutex
 	visitors map[string]*visitor
-	rate     time.Duration // Time between requests
-	capacity int           // Maximum burst capacity
-	stopChan chan struct{} // Channel to stop cleanup goroutine
-	stopped  int32         // Atomic flag to indicate if stopped
+	// lru holds the visitors' keys, most recently active first.
+	lru        *list.List
+	maxEntries int
+	rate       time.Duration // Time between requests
+	capacity   int           // Maximum burst capacity
+	stopChan   chan struct{} // Channel to stop cleanup goroutine
+	stopped    int32         // Atomic flag to indicate if stopped
+	warnAt     int           // Tokens used at which to warn; 0 disables
+	onWarn     RateLimitWarnFunc
+	name       string           // Labels metrics and namespaces keys in backend
+	backend    RateLimitBackend // Shared buckets; nil keeps them in memory
 }
 
 type visitor struct {
 	mu       sync.Mutex
+	elem     *list.Element // The visitor's entry in lru
 	lastSeen time.Time
 	tokens   int
+	// refilledAt is when tokens was last exact: time since then that
+	// adds up to less than a whole token is carried over to the next
+	// request instead of being lost.
+	refilledAt time.Time
+	warnedAt   time.Time
 }
 
 // NewRateLimiter creates a new rate limiter.
+// name: identifies the limiter in metrics and in a shared backend
 // rate: minimum time between requests (e.g., time.Second for 1 req/sec)
 // capacity: maximum burst requests allowed
-func NewRateLimiter(rate time.Duration, capacity int) *RateLimiter {
+func NewRateLimiter(name string, rate time.Duration, capacity int) *RateLimiter {
 	rl := &RateLimiter{
-		visitors: make(map[string]*visitor),
-		rate:     rate,
-		capacity: capacity,
-		stopChan: make(chan struct{}),
-		stopped:  0,
+		visitors:   make(map[string]*visitor),
+		lru:        list.New(),
+		maxEntries: DefaultRateLimitMaxEntries,
+		rate:       rate,
+		capacity:   capacity,
+		stopChan:   make(chan struct{}),
+		stopped:    0,
+		name:       name,
 	}
+	rateLimitVisitors.SetFunc(func() float64 {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		return float64(len(rl.visitors))
+	}, name)
 
 	// Start cleanup goroutine
 	go rl.cleanup()
@@ -51,56 +153,129 @@ func (rl *RateLimiter) Stop() {
 	}
 }
 
+// WarnAt makes the limiter warn when a client has used percent of its
+// burst capacity: a log line, the sentinel_ratelimit_warnings_total
+// metric, and a call to fn when it is non-nil. percent outside 1-99
+// disables warnings. It must be called before the limiter is used.
+func (rl *RateLimiter) WarnAt(percent int, fn RateLimitWarnFunc) {
+	if percent <= 0 || percent >= 100 {
+		rl.warnAt, rl.onWarn = 0, nil
+		return
+	}
+	// Round up, but never warn before the first request of a burst
+	rl.warnAt = max((rl.capacity*percent+99)/100, 1)
+	rl.onWarn = fn
+}
+
+// SetMaxEntries bounds the number of clients the limiter tracks; n <= 0
+// restores DefaultRateLimitMaxEntries. It must be called before the
+// limiter is used.
+func (rl *RateLimiter) SetMaxEntries(n int) {
+	if n <= 0 {
+		n = DefaultRateLimitMaxEntries
+	}
+	rl.maxEntries = n
+}
+
+// Share keeps the limiter's buckets in b, under keys prefixed with its
+// name, instead of in memory. Limiters sharing a backend need distinct
+// names. Clients are still tracked in memory for warnings. It must be
+// called before the limiter is used.
+func (rl *RateLimiter) Share(b RateLimitBackend) {
+	rl.backend = b
+}
+
 // Allow checks if a request should be allowed based on the client IP.
-// Uses fine-grained locking for better concurrency.
+// A shared limiter takes the token from its backend, failing open when the
+// backend errs; otherwise the client's bucket is refilled and taken from
+// in memory under the client's own lock.
 func (rl *RateLimiter) Allow(ip string) bool {
-	now := time.Now()
+	allowed, _ := rl.take(context.Background(), ip, time.Now())
+	return allowed
+}
 
-	// Try to get existing visitor with read lock first
-	rl.mu.RLock()
+// take consumes a token for ip, reporting whether the request is allowed
+// and how many tokens of the burst are in use when it is newly due a
+// warning (0 otherwise).
+func (rl *RateLimiter) take(ctx context.Context, ip string, now time.Time) (bool, int) {
+	rl.mu.Lock()
 	v, exists := rl.visitors[ip]
-	rl.mu.RUnlock()
-
-	if !exists {
-		// Create new visitor with write lock
-		rl.mu.Lock()
-		// Double-check in case another goroutine created it
-		v, exists = rl.visitors[ip]
-		if !exists {
-			v = &visitor{
-				lastSeen: now,
-				tokens:   rl.capacity - 1, // Use one token
-			}
-			rl.visitors[ip] = v
-			rl.mu.Unlock()
-			return true
+	if exists {
+		rl.lru.MoveToFront(v.elem)
+	} else {
+		if len(rl.visitors) >= rl.maxEntries {
+			oldest := rl.lru.Back().Value.(string)
+			rl.remove(oldest, rl.visitors[oldest])
+			rateLimitEvictions.WithLabelValues(rl.name).Inc()
 		}
-		rl.mu.Unlock()
+		v = &visitor{lastSeen: now, tokens: rl.capacity, refilledAt: now}
+		v.elem = rl.lru.PushFront(ip)
+		rl.visitors[ip] = v
 	}
+	rl.mu.Unlock()
 
 	// Lock the specific visitor for thread-safe token updates
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
-	// Add tokens based on time elapsed
-	elapsed := now.Sub(v.lastSeen)
-	tokensToAdd := int(elapsed / rl.rate)
-
-	if tokensToAdd > 0 {
-		v.tokens += tokensToAdd
-		if v.tokens > rl.capacity {
-			v.tokens = rl.capacity
+	if rl.backend != nil {
+		// The shared bucket decides; the visitor only tracks warnings
+		allowed, remaining, err := rl.backend.TakeRateLimitToken(ctx, rl.name+":"+ip, rl.rate, rl.capacity, now)
+		if err != nil {
+			// Fail open: an outage of the store must not take down the API
+			rateLimitBackendErrors.WithLabelValues().Inc()
+			logger.FromContext(ctx).Error("Shared rate limit lookup failed", map[string]interface{}{
+				"limiter": rl.name,
+				"error":   err.Error(),
+			})
+			return true, 0
+		}
+		v.lastSeen, v.tokens = now, remaining
+		if !allowed {
+			return false, 0
 		}
-		v.lastSeen = now
+		return true, v.warning(rl, now)
 	}
 
+	v.lastSeen = now
+	v.tokens, v.refilledAt = refill(v.tokens, v.refilledAt, rl.rate, rl.capacity, now)
+
 	// Check if we can consume a token
 	if v.tokens > 0 {
 		v.tokens--
-		return true
+		return true, v.warning(rl, now)
 	}
 
-	return false
+	return false, 0
+}
+
+// refill adds the whole tokens accrued at one per rate between refilledAt
+// and now, up to capacity, and returns the new count and refill time. The
+// refill time advances only by the whole tokens added, so a client
+// returning every 1.5 rates gets two tokens per three rates rather than
+// one per two; but a full bucket accrues nothing, so the time starts over
+// at now. A clock behind refilledAt adds nothing.
+func refill(tokens int, refilledAt time.Time, rate time.Duration, capacity int, now time.Time) (int, time.Time) {
+	if n := now.Sub(refilledAt) / rate; n > 0 {
+		tokens = int(min(int64(tokens)+int64(n), int64(capacity)))
+		refilledAt = refilledAt.Add(n * rate)
+	}
+	if tokens >= capacity {
+		tokens, refilledAt = capacity, now
+	}
+	return tokens, refilledAt
+}
+
+// warning returns the tokens in use if v has reached the limiter's warning
+// level and was not warned about within RateLimitWarnCooldown, and 0
+// otherwise. The caller must hold v.mu.
+func (v *visitor) warning(rl *RateLimiter, now time.Time) int {
+	used := rl.capacity - v.tokens
+	if rl.warnAt == 0 || used < rl.warnAt || now.Sub(v.warnedAt) < RateLimitWarnCooldown {
+		return 0
+	}
+	v.warnedAt = now
+	return used
 }
 
 // cleanup removes old visitor entries to prevent memory leaks.
@@ -122,48 +297,77 @@ func (rl *RateLimiter) cleanup() {
 // cleanupVisitors removes stale visitor entries.
 func (rl *RateLimiter) cleanupVisitors() {
 	cutoff := time.Now().Add(-10 * time.Minute)
-	toDelete := make([]string, 0)
 
-	// Collect IPs to delete with read lock
-	rl.mu.RLock()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
 	for ip, v := range rl.visitors {
 		v.mu.Lock()
-		if v.lastSeen.Before(cutoff) {
-			toDelete = append(toDelete, ip)
-		}
+		stale := v.lastSeen.Before(cutoff)
 		v.mu.Unlock()
-	}
-	rl.mu.RUnlock()
-
-	// Delete with write lock if needed
-	if len(toDelete) > 0 {
-		rl.mu.Lock()
-		for _, ip := range toDelete {
-			delete(rl.visitors, ip)
+		if stale {
+			rl.remove(ip, v)
 		}
-		rl.mu.Unlock()
 	}
 }
 
+// remove forgets the visitor for ip. The caller must hold rl.mu.
+func (rl *RateLimiter) remove(ip string, v *visitor) {
+	rl.lru.Remove(v.elem)
+	delete(rl.visitors, ip)
+}
+
 // WithRateLimit returns middleware that enforces rate limiting.
 func WithRateLimit(rl *RateLimiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Extract client IP
-			ip := getClientIP(r)
+			ip := ClientIP(r)
 
-			if !rl.Allow(ip) {
+			allowed, used := rl.take(r.Context(), ip, time.Now())
+			if !allowed {
+				rateLimitDecisions.WithLabelValues(routeLabel(r), "rejected").Inc()
 				writeRateLimitError(w)
 				return
 			}
+			rateLimitDecisions.WithLabelValues(routeLabel(r), "allowed").Inc()
+			if used > 0 {
+				rl.warn(r, RateLimitWarning{Key: ip, Route: routeLabel(r), Used: used, Capacity: rl.capacity})
+			}
 
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-// getClientIP extracts the client IP address from the request.
-func getClientIP(r *http.Request) string {
+// warn reports a client nearing its rate limit.
+func (rl *RateLimiter) warn(r *http.Request, w RateLimitWarning) {
+	rateLimitWarnings.WithLabelValues(w.Route).Inc()
+	logger.FromContext(r.Context()).Warn("Client nearing rate limit", map[string]interface{}{
+		"key":      w.Key,
+		"route":    w.Route,
+		"used":     w.Used,
+		"capacity": w.Capacity,
+	})
+	if rl.onWarn != nil {
+		rl.onWarn(r, w)
+	}
+}
+
+// routeLabel returns the path of the ServeMux pattern that matched r (e.g.
+// "/api/admin/users/{id}"), keeping metric label cardinality bounded.
+func routeLabel(r *http.Request) string {
+	if r.Pattern == "" {
+		return "unmatched"
+	}
+	if _, path, ok := strings.Cut(r.Pattern, " "); ok {
+		return path
+	}
+	return r.Pattern
+}
+
+// ClientIP extracts the client IP address from the request, preferring
+// the X-Forwarded-For and X-Real-IP headers set by a reverse proxy.
+func ClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header (for requests behind proxy)
 	forwarded := r.Header.Get("X-Forwarded-For")
 	if forwarded != "" {
@@ -192,14 +396,6 @@ func getClientIP(r *http.Request) string {
 
 // writeRateLimitError writes a rate limit exceeded error response.
 func writeRateLimitError(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Retry-After", "60") // Suggest retry after 60 seconds
-	w.WriteHeader(http.StatusTooManyRequests)
-
-	response := map[string]string{
-		"error":   "Too Many Requests",
-		"message": "Rate limit exceeded. Please try again later.",
-	}
-
-	json.NewEncoder(w).Encode(response)
+	httpjson.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
 }
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterFractionalAccrual(t *testing.T) {
+	rl := NewRateLimiter("test", time.Second, 3)
+	defer rl.Stop()
+	start := time.Unix(1700000000, 0)
+	take := func(offset time.Duration) bool {
+		allowed, _ := rl.take(context.Background(), "10.0.0.1", start.Add(offset))
+		return allowed
+	}
+
+	for range 3 {
+		if !take(0) {
+			t.Fatal("expected the burst to be allowed")
+		}
+	}
+	if take(0) {
+		t.Fatal("expected the empty bucket to reject")
+	}
+
+	// A client retrying every 0.75s gets the full rate of one request per
+	// second, rather than one per 1.5s as when the 0.5s left over after
+	// each token was dropped
+	var allowed []time.Duration
+	for i := 1; i <= 12; i++ {
+		offset := time.Duration(i) * 750 * time.Millisecond
+		if take(offset) {
+			allowed = append(allowed, offset)
+		}
+	}
+	if len(allowed) != 9 {
+		t.Errorf("expected 9 of 12 requests over 9s to be allowed, got %v", allowed)
+	}
+}
+
+func TestRateLimiterFullBucketAccruesNothing(t *testing.T) {
+	rl := NewRateLimiter("test", time.Second, 2)
+	defer rl.Stop()
+	start := time.Unix(1700000000, 0)
+	take := func(offset time.Duration) bool {
+		allowed, _ := rl.take(context.Background(), "10.0.0.1", start.Add(offset))
+		return allowed
+	}
+
+	take(0)
+	// Full again from 1s; the idle time after that buys nothing
+	if !take(10*time.Second+900*time.Millisecond) || !take(10*time.Second+900*time.Millisecond) {
+		t.Fatal("expected a full burst after idling")
+	}
+	if take(11 * time.Second) {
+		t.Error("expected no token 0.1s after emptying a full bucket")
+	}
+	if !take(11*time.Second + 900*time.Millisecond) {
+		t.Error("expected a token 1s after emptying a full bucket")
+	}
+
+	// A clock that goes backwards adds nothing
+	if take(5 * time.Second) {
+		t.Error("expected a clock behind the last refill to add nothing")
+	}
+}
+
+func TestRateLimiterEviction(t *testing.T) {
+	rl := NewRateLimiter("test", time.Second, 1)
+	defer rl.Stop()
+	rl.SetMaxEntries(2)
+	now := time.Unix(1700000000, 0)
+	take := func(ip string) bool {
+		allowed, _ := rl.take(context.Background(), ip, now)
+		return allowed
+	}
+
+	take("10.0.0.1")
+	take("10.0.0.2")
+	take("10.0.0.1") // most recently active again
+	take("10.0.0.3") // evicts 10.0.0.2
+	if len(rl.visitors) != 2 || rl.visitors["10.0.0.2"] != nil {
+		t.Fatalf("expected the least recently active client to be evicted, got %v", rl.visitors)
+	}
+	if take("10.0.0.1") {
+		t.Error("expected the retained client to stay limited")
+	}
+	if !take("10.0.0.2") {
+		t.Error("expected the evicted client to start over with a full bucket")
+	}
+}
@@ -6,18 +6,14 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"net/http"
-)
-
-// ContextKey is a type for context keys to avoid collisions
-type ContextKey string
 
-const (
-	// RequestIDKey is the context key for request IDs
-	RequestIDKey ContextKey = "request_id"
-	// RequestIDHeader is the HTTP header name for request IDs
-	RequestIDHeader = "X-Request-ID"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/tracing"
 )
 
+// RequestIDHeader is the HTTP header name for request IDs
+const RequestIDHeader = tracing.RequestIDHeader
+
 // generateRequestID creates a new random request ID.
 func generateRequestID() string {
 	b := make([]byte, 16)
@@ -28,9 +24,11 @@ func generateRequestID() string {
 	return hex.EncodeToString(b)
 }
 
-// WithRequestID adds a unique request ID to each request.
-// If the client provides an X-Request-ID header, it will be used;
-// otherwise, a new one is generated.
+// WithRequestID adds a unique request ID and a W3C trace context to each
+// request. If the client provides an X-Request-ID header, it will be used;
+// otherwise, a new one is generated. A valid incoming traceparent is
+// continued (with its tracestate), otherwise a new trace is started. Both
+// are attached to the request context for logging and outbound propagation.
 func WithRequestID() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -40,12 +38,15 @@ func WithRequestID() func(http.Handler) http.Handler {
 				// Generate new request ID
 				requestID = generateRequestID()
 			}
+			span := tracing.FromRequest(r)
 
 			// Add request ID to response header
 			w.Header().Set(RequestIDHeader, requestID)
 
-			// Add request ID to context
-			ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
+			// Add request ID, trace context, and their log fields to context
+			ctx := tracing.ContextWithRequestID(r.Context(), requestID)
+			ctx = tracing.ContextWithSpan(ctx, span)
+			ctx = logger.ContextWithFields(ctx, tracing.LogFields(ctx))
 
 			// Process request with enriched context
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -55,8 +56,5 @@ func WithRequestID() func(http.Handler) http.Handler {
 
 // GetRequestID extracts the request ID from the context.
 func GetRequestID(ctx context.Context) string {
-	if reqID, ok := ctx.Value(RequestIDKey).(string); ok {
-		return reqID
-	}
-	return ""
+	return tracing.RequestIDFromContext(ctx)
 }
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/mayvqt/Sentinel/internal/scrub"
+)
+
+// scrubWriter buffers 5xx response bodies so they can be scrubbed before
+// they are sent. Other responses pass straight through.
+type scrubWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+}
+
+func (sw *scrubWriter) WriteHeader(code int) {
+	if sw.wroteHeader {
+		return
+	}
+	sw.wroteHeader = true
+	sw.status = code
+	if code < 500 {
+		sw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (sw *scrubWriter) Write(b []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if sw.status >= 500 {
+		return sw.buf.Write(b)
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (sw *scrubWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// finish sends a buffered 5xx response with credentials redacted.
+func (sw *scrubWriter) finish() {
+	if sw.status < 500 {
+		return
+	}
+	body := scrub.Bytes(sw.buf.Bytes())
+	sw.ResponseWriter.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	sw.ResponseWriter.WriteHeader(sw.status)
+	_, _ = sw.ResponseWriter.Write(body)
+}
+
+// WithSecretScrubbing redacts JWTs, bcrypt hashes, and connection string
+// credentials from 5xx response bodies so internal errors cannot leak them
+// to clients. It should wrap the whole router.
+func WithSecretScrubbing() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &scrubWriter{ResponseWriter: w}
+			defer sw.finish()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
@@ -2,6 +2,10 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/httpjson"
 )
 
 // WithMaxBodySize limits the size of request bodies to prevent DoS attacks.
@@ -9,7 +13,7 @@ func WithMaxBodySize(maxBytes int64) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.ContentLength > maxBytes {
-				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				httpjson.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
 				return
 			}
 			// Enforce limit even if Content-Length not set
@@ -19,12 +23,74 @@ func WithMaxBodySize(maxBytes int64) func(http.Handler) http.Handler {
 	}
 }
 
+// DefaultContentSecurityPolicy allows only same-origin resources, forbids
+// framing, and sends violation reports to /api/csp-report.
+const DefaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self'; " +
+	"style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; " +
+	"font-src 'self'; " +
+	"connect-src 'self'; " +
+	"frame-ancestors 'none'; " +
+	"report-uri /api/csp-report"
+
+// SecurityHeaders configures the headers set by WithSecurityHeaders.
+type SecurityHeaders struct {
+	// ContentSecurityPolicy is the Content-Security-Policy header; empty
+	// omits it.
+	ContentSecurityPolicy string
+	// CSPReportOnly sends the policy as Content-Security-Policy-Report-Only,
+	// so violations are reported but not blocked, for trying out a policy.
+	CSPReportOnly bool
+	// FrameOptions is the X-Frame-Options header, DENY or SAMEORIGIN;
+	// empty omits it.
+	FrameOptions string
+	// HSTSMaxAge is the Strict-Transport-Security max-age sent over HTTPS;
+	// zero omits the header.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+}
+
+// DefaultSecurityHeaders returns the headers used unless configured
+// otherwise.
+func DefaultSecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		ContentSecurityPolicy: DefaultContentSecurityPolicy,
+		FrameOptions:          "DENY",
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+	}
+}
+
+// hsts renders the Strict-Transport-Security header, or "" when disabled.
+func (c SecurityHeaders) hsts() string {
+	if c.HSTSMaxAge <= 0 {
+		return ""
+	}
+	v := "max-age=" + strconv.FormatInt(int64(c.HSTSMaxAge.Seconds()), 10)
+	if c.HSTSIncludeSubdomains {
+		v += "; includeSubDomains"
+	}
+	if c.HSTSPreload {
+		v += "; preload"
+	}
+	return v
+}
+
 // WithSecurityHeaders adds common security headers to responses.
-func WithSecurityHeaders() func(http.Handler) http.Handler {
+func WithSecurityHeaders(cfg SecurityHeaders) func(http.Handler) http.Handler {
+	cspHeader := "Content-Security-Policy"
+	if cfg.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	hsts := cfg.hsts()
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Prevent clickjacking attacks
-			w.Header().Set("X-Frame-Options", "DENY")
+			if cfg.FrameOptions != "" {
+				w.Header().Set("X-Frame-Options", cfg.FrameOptions)
+			}
 
 			// Prevent MIME type sniffing
 			w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -36,18 +102,13 @@ func WithSecurityHeaders() func(http.Handler) http.Handler {
 			w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
 
 			// Content Security Policy
-			w.Header().Set("Content-Security-Policy",
-				"default-src 'self'; "+
-					"script-src 'self'; "+
-					"style-src 'self' 'unsafe-inline'; "+
-					"img-src 'self' data:; "+
-					"font-src 'self'; "+
-					"connect-src 'self'; "+
-					"frame-ancestors 'none'")
+			if cfg.ContentSecurityPolicy != "" {
+				w.Header().Set(cspHeader, cfg.ContentSecurityPolicy)
+			}
 
 			// Strict Transport Security (only set over HTTPS)
-			if r.TLS != nil {
-				w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+			if r.TLS != nil && hsts != "" {
+				w.Header().Set("Strict-Transport-Security", hsts)
 			}
 
 			next.ServeHTTP(w, r)
@@ -55,14 +116,32 @@ func WithSecurityHeaders() func(http.Handler) http.Handler {
 	}
 }
 
+// WithCacheControl sets the Cache-Control header of every response to
+// value, such as "no-store", before the handler runs. Handlers that serve
+// cacheable content set their own.
+func WithCacheControl(value string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", value)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // WithCORS adds CORS headers for cross-origin requests.
 func WithCORS(allowedOrigins []string) func(http.Handler) http.Handler {
+	return WithCORSFunc(allowedOrigins, nil)
+}
+
+// WithCORSFunc is WithCORS that also allows the origins allow reports
+// true for, such as those of registered clients, when it is not nil.
+func WithCORSFunc(allowedOrigins []string, allow func(origin string) bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			origin := r.Header.Get("Origin")
 
 			// Check if origin is allowed
-			allowed := false
+			allowed := allow != nil && allow(origin)
 			for _, allowedOrigin := range allowedOrigins {
 				if allowedOrigin == "*" || allowedOrigin == origin {
 					allowed = true
@@ -74,8 +153,8 @@ func WithCORS(allowedOrigins []string) func(http.Handler) http.Handler {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 			}
 
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, DPoP")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
 
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	serve := func(cfg SecurityHeaders) http.Header {
+		h := WithSecurityHeaders(cfg)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.TLS = &tls.ConnectionState{}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Header()
+	}
+
+	got := serve(DefaultSecurityHeaders())
+	if got.Get("Content-Security-Policy") != DefaultContentSecurityPolicy || got.Get("X-Frame-Options") != "DENY" ||
+		got.Get("Strict-Transport-Security") != "max-age=31536000; includeSubDomains" {
+		t.Errorf("unexpected default headers %v", got)
+	}
+
+	got = serve(SecurityHeaders{
+		ContentSecurityPolicy: "default-src 'self'",
+		CSPReportOnly:         true,
+		FrameOptions:          "SAMEORIGIN",
+		HSTSMaxAge:            2 * 365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		HSTSPreload:           true,
+	})
+	if got.Get("Content-Security-Policy") != "" || got.Get("Content-Security-Policy-Report-Only") != "default-src 'self'" {
+		t.Errorf("expected a report-only policy, got %v", got)
+	}
+	if got.Get("X-Frame-Options") != "SAMEORIGIN" || got.Get("Strict-Transport-Security") != "max-age=63072000; includeSubDomains; preload" {
+		t.Errorf("unexpected headers %v", got)
+	}
+
+	got = serve(SecurityHeaders{})
+	for _, name := range []string{"Content-Security-Policy", "X-Frame-Options", "Strict-Transport-Security"} {
+		if got.Get(name) != "" {
+			t.Errorf("expected %s to be omitted, got %q", name, got.Get(name))
+		}
+	}
+	if got.Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("expected nosniff to be sent regardless")
+	}
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import "net/http"
+
+// SessionToucher records that the session of an authenticated request was
+// used. handlers.Handlers implements it.
+type SessionToucher interface {
+	TouchSession(r *http.Request)
+}
+
+// WithSessionActivity records each request's session activity before
+// handling it, for idle timeouts. It must run after authentication.
+func WithSessionActivity(s SessionToucher) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.TouchSession(r)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/config"
+)
+
+// fakeNonces is a NonceStore that never expires nonces.
+type fakeNonces map[string]bool
+
+func (f fakeNonces) UseNonce(ctx context.Context, key string, expiresAt, now time.Time) (bool, error) {
+	if f[key] {
+		return false, nil
+	}
+	f[key] = true
+	return true, nil
+}
+
+func TestRequestSignature(t *testing.T) {
+	a := auth.New(&config.Config{JWTSecret: "test-secret"})
+	claims := &auth.Claims{UserID: "1", Role: "admin", RegisteredClaims: jwt.RegisteredClaims{ID: "jti-1"}}
+	key := a.SigningKey(claims.ID)
+
+	serve := func(required bool, nonces fakeNonces, req *http.Request) *httptest.ResponseRecorder {
+		var body strings.Builder
+		h := WithRequestSignature(a, nonces, time.Minute, required)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The handler still gets the body
+			buf := make([]byte, 64)
+			n, _ := r.Body.Read(buf)
+			body.Write(buf[:n])
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), "user", claims)))
+		if w.Code == http.StatusOK && req.Method == http.MethodPost && body.String() != `{"role":"user"}` {
+			t.Errorf("handler got body %q", body.String())
+		}
+		return w
+	}
+	signed := func(ts time.Time, nonce, signKey string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/users/2?x=1", strings.NewReader(`{"role":"user"}`))
+		req.Header.Set(auth.SignatureTimestampHeader, strconv.FormatInt(ts.Unix(), 10))
+		req.Header.Set(auth.SignatureNonceHeader, nonce)
+		req.Header.Set(auth.SignatureHeader, auth.SignRequest(signKey, ts.Unix(), nonce, http.MethodPost, "/api/admin/users/2?x=1", []byte(`{"role":"user"}`)))
+		return req
+	}
+
+	nonces := fakeNonces{}
+	if w := serve(true, nonces, signed(time.Now(), "nonce-0123456789", key)); w.Code != http.StatusOK {
+		t.Fatalf("expected a signed request to pass, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(true, nonces, signed(time.Now(), "nonce-0123456789", key)); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "already been processed") {
+		t.Errorf("expected a replay to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	for name, req := range map[string]*http.Request{
+		"stale timestamp": signed(time.Now().Add(-2*time.Minute), "nonce-stale-012345", key),
+		"short nonce":     signed(time.Now(), "short", key),
+		"wrong key":       signed(time.Now(), "nonce-wrong-key-01", a.SigningKey("jti-2")),
+	} {
+		if w := serve(false, nonces, req); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", name, w.Code)
+		}
+	}
+
+	unsigned := func(method string) *http.Request {
+		return httptest.NewRequest(method, "/api/admin/users/2?x=1", strings.NewReader(`{"role":"user"}`))
+	}
+	if w := serve(true, nonces, unsigned(http.MethodPost)); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unsigned request to be rejected when required, got %d", w.Code)
+	}
+	if w := serve(false, nonces, unsigned(http.MethodPost)); w.Code != http.StatusOK {
+		t.Errorf("expected an unsigned request to pass when optional, got %d", w.Code)
+	}
+	if w := serve(true, nonces, unsigned(http.MethodGet)); w.Code != http.StatusOK {
+		t.Errorf("expected reads to pass unsigned, got %d", w.Code)
+	}
+}
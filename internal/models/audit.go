@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// AuditEvent records an administrative change: who did what to which record,
+// and how the record's fields changed.
+type AuditEvent struct {
+	ID         int64         `json:"id" db:"id"`
+	ActorID    int64         `json:"actor_id" db:"actor_id"`
+	Action     string        `json:"action" db:"action"` // e.g. "user.disable"
+	TargetType string        `json:"target_type" db:"target_type"`
+	TargetID   int64         `json:"target_id" db:"target_id"`
+	Changes    []FieldChange `json:"changes" db:"changes"`
+	RequestID  string        `json:"request_id,omitempty" db:"request_id"`
+	// TokenID is the ID (jti) of the token the actor authenticated with.
+	TokenID string `json:"jti,omitempty" db:"jti"`
+	// IP, UserAgent, and Country describe the request that caused the
+	// event. Country comes from a trusted proxy header and may be empty.
+	IP        string    `json:"ip,omitempty" db:"ip"`
+	UserAgent string    `json:"user_agent,omitempty" db:"user_agent"`
+	Country   string    `json:"country,omitempty" db:"country"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// FieldChange is one entry of an audit diff. Before or After is nil when
+// the field was absent (for example, on creation or deletion).
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
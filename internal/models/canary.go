@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Canary kinds.
+const (
+	// CanaryAccount is a bait account: any attempt to sign in as it is a
+	// sign that the user list has leaked.
+	CanaryAccount = "account"
+	// CanaryToken is a bait access token planted where a leak would
+	// expose it; it is never accepted.
+	CanaryToken = "token"
+)
+
+// Canary is a honeypot credential registered by an administrator. No
+// legitimate client ever uses one, so any use raises an alert.
+type Canary struct {
+	ID   int64  `json:"id" db:"id"`
+	Kind string `json:"kind" db:"kind"`
+	// UserID is the bait account of an account canary.
+	UserID int64 `json:"user_id,omitempty" db:"user_id"`
+	// TokenID is the ID (jti) of a token canary.
+	TokenID   string    `json:"jti,omitempty" db:"jti"`
+	Note      string    `json:"note,omitempty" db:"note"`
+	CreatedBy int64     `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
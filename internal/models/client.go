@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Refresh policies a client may choose instead of the server's.
+const (
+	// RefreshFixed ends a session a fixed time after login however often
+	// it is refreshed.
+	RefreshFixed = "fixed"
+	// RefreshSliding extends a session on each refresh, up to the
+	// server's maximum session lifetime.
+	RefreshSliding = "sliding"
+)
+
+// Client is a registered API consumer, such as a mobile app or a server
+// daemon, with its own token policy. Tokens issued to it at login carry
+// its ClientID, and the policy is applied again whenever they are
+// refreshed. Zero values keep the server's policy.
+type Client struct {
+	ID       int64  `json:"id" db:"id"`
+	ClientID string `json:"client_id" db:"client_id"`
+	Name     string `json:"name" db:"name"`
+	// AccessTokenTTL and RefreshTokenTTL are token lifetimes in seconds.
+	AccessTokenTTL  int `json:"access_token_ttl" db:"access_token_ttl"`
+	RefreshTokenTTL int `json:"refresh_token_ttl" db:"refresh_token_ttl"`
+	// RefreshPolicy is RefreshFixed or RefreshSliding, or empty for the
+	// server's.
+	RefreshPolicy string `json:"refresh_policy" db:"refresh_policy"`
+	// AllowedScopes are the scopes the client may request; a client with
+	// none may not request any.
+	AllowedScopes []string `json:"allowed_scopes" db:"allowed_scopes"`
+	// CORSOrigins are browser origins allowed to call the API on top of
+	// the configured ones.
+	CORSOrigins []string  `json:"cors_origins" db:"cors_origins"`
+	UpdatedBy   int64     `json:"updated_by" db:"updated_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
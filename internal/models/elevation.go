@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Elevation statuses.
+const (
+	ElevationPending  = "pending"
+	ElevationApproved = "approved"
+	ElevationDenied   = "denied"
+)
+
+// Elevation is a request by an admin or delegated admin for scopes beyond
+// their role's for a limited time. Once approved, the requester redeems it
+// once for an access token carrying the scopes, which expires at ExpiresAt.
+type Elevation struct {
+	ID     int64    `json:"id" db:"id"`
+	UserID int64    `json:"user_id" db:"user_id"`
+	Scopes []string `json:"scopes" db:"scopes"`
+	Reason string   `json:"reason" db:"reason"`
+	// DurationSeconds is how long the elevation lasts once approved.
+	DurationSeconds int64  `json:"duration_seconds" db:"duration_seconds"`
+	Status          string `json:"status" db:"status"`
+	// DecidedBy is the admin who approved or denied the request, or the
+	// requester when it was approved without review.
+	DecidedBy int64      `json:"decided_by,omitempty" db:"decided_by"`
+	DecidedAt *time.Time `json:"decided_at,omitempty" db:"decided_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	// TokenID is the ID (jti) of the token issued for the elevation, which
+	// audit events for the actions taken with it carry.
+	TokenID   string    `json:"jti,omitempty" db:"jti"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Duration returns how long the elevation lasts once approved.
+func (e *Elevation) Duration() time.Duration {
+	return time.Duration(e.DurationSeconds) * time.Second
+}
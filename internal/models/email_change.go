@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// EmailChange is a user's pending or recently confirmed change of email
+// address. Only hashes of the emailed tokens are stored. While pending,
+// ConfirmTokenHash is set; once confirmed, the old address may undo the
+// change with the revert token until RevertExpiresAt.
+type EmailChange struct {
+	UserID           int64      `json:"user_id" db:"user_id"`
+	OldEmail         string     `json:"old_email" db:"old_email"`
+	NewEmail         string     `json:"new_email" db:"new_email"`
+	ConfirmTokenHash string     `json:"-" db:"confirm_token_hash"`
+	ConfirmExpiresAt time.Time  `json:"confirm_expires_at" db:"confirm_expires_at"`
+	ConfirmedAt      *time.Time `json:"confirmed_at,omitempty" db:"confirmed_at"`
+	RevertTokenHash  string     `json:"-" db:"revert_token_hash"`
+	RevertExpiresAt  *time.Time `json:"revert_expires_at,omitempty" db:"revert_expires_at"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Pending reports whether the change still awaits confirmation at now.
+func (c *EmailChange) Pending(now time.Time) bool {
+	return c.ConfirmedAt == nil && now.Before(c.ConfirmExpiresAt)
+}
+
+// PendingEmail describes an unconfirmed email change in profile responses.
+type PendingEmail struct {
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// FeatureFlag is an administrator's override of a feature flag's rollout,
+// taking precedence over the configured one. When Enabled, the flag is on
+// for the listed Subjects and for Percent percent of all others.
+type FeatureFlag struct {
+	ID        int64     `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	Enabled   bool      `json:"enabled" db:"enabled"`
+	Percent   int       `json:"percent" db:"percent"`
+	Subjects  []string  `json:"subjects" db:"subjects"`
+	UpdatedBy int64     `json:"updated_by" db:"updated_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// MagicLink is an emailed single-use sign-in link. A user has at most one
+// outstanding link; only the token's hash is stored.
+type MagicLink struct {
+	UserID    int64  `json:"user_id" db:"user_id"`
+	TokenHash string `json:"-" db:"token_hash"`
+	// DeviceHash, when set, is the hash of a cookie given to the browser
+	// that requested the link; the link only works alongside it.
+	DeviceHash string    `json:"-" db:"device_hash"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
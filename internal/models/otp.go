@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// OTP challenge purposes.
+const (
+	OTPPurposePhoneVerify = "phone_verify" // proves ownership of OTPChallenge.Phone
+	OTPPurposeLogin       = "login"        // second factor for a password login
+)
+
+// OTPChallenge is a one-time code sent to a user's phone. A user has at
+// most one outstanding challenge per purpose; only the code's hash is
+// stored.
+type OTPChallenge struct {
+	UserID    int64     `json:"user_id" db:"user_id"`
+	Purpose   string    `json:"purpose" db:"purpose"`
+	Phone     string    `json:"phone" db:"phone"`
+	CodeHash  string    `json:"-" db:"code_hash"`
+	Attempts  int       `json:"attempts" db:"attempts"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Preferences are a user's notification and security settings. Users who
+// never saved any have the zero value.
+type Preferences struct {
+	// LoginAlerts emails the user after a sign-in from an address and
+	// device not among their recent sign-ins.
+	LoginAlerts bool `json:"login_alerts" db:"login_alerts"`
+	// SessionTimeoutMinutes, when set, ends the user's sessions that many
+	// minutes after sign-in (or, with sliding sessions, after their last
+	// refresh) if sooner than the server's refresh token lifetime.
+	SessionTimeoutMinutes int `json:"session_timeout_minutes" db:"session_timeout_minutes"`
+	// Locale is the BCP 47 language tag of the user's emails, such as
+	// "de" or "pt-BR"; empty uses the templates' language.
+	Locale    string    `json:"locale" db:"locale"`
+	UpdatedAt time.Time `json:"updated_at,omitzero" db:"updated_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Quota caps how many requests a client may make per UTC day and per
+// UTC month. A zero limit is unlimited.
+type Quota struct {
+	ID int64 `json:"id" db:"id"`
+	// Subject identifies the client: "service:" followed by a service
+	// account name, or "user:" followed by a user ID.
+	Subject   string    `json:"subject" db:"subject"`
+	Daily     int64     `json:"daily" db:"daily"`
+	Monthly   int64     `json:"monthly" db:"monthly"`
+	UpdatedBy int64     `json:"updated_by" db:"updated_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Actions a scheduled status change can take.
+const (
+	StatusChangeSuspend    = "suspend"
+	StatusChangeReactivate = "reactivate"
+)
+
+// Scheduled status change states.
+const (
+	StatusChangePending  = "pending"
+	StatusChangeDone     = "done"
+	StatusChangeCanceled = "canceled"
+)
+
+// StatusChange is a suspension or reactivation of a user's account that
+// an admin scheduled for RunAt. A background job applies it once it is
+// due, disabling or re-enabling the account.
+type StatusChange struct {
+	ID     int64     `json:"id" db:"id"`
+	UserID int64     `json:"user_id" db:"user_id"`
+	Action string    `json:"action" db:"action"`
+	RunAt  time.Time `json:"run_at" db:"run_at"`
+	// Reason is included in the notification email, if one is sent.
+	Reason string `json:"reason,omitempty" db:"reason"`
+	// Notify emails the user when the change is applied.
+	Notify    bool   `json:"notify" db:"notify"`
+	Status    string `json:"status" db:"status"`
+	CreatedBy int64  `json:"created_by" db:"created_by"`
+	// CompletedAt is when the change was applied or canceled.
+	CompletedAt *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
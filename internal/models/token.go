@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// RevokedToken is a denylisted token ID (JTI). Entries are only needed until
+// the token would have expired anyway.
+type RevokedToken struct {
+	JTI       string    `json:"jti" db:"jti"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at" db:"revoked_at"`
+}
+
+// RefreshToken records an issued refresh token by ID so sessions can be
+// listed and individual tokens revoked. ParentJTI links a rotated token to
+// the one it replaced. The token itself is never stored: it is signed with
+// the JWT secret, which is not in the database, so a database dump cannot
+// be used to mint or replay sessions.
+type RefreshToken struct {
+	JTI       string    `json:"jti" db:"jti"`
+	UserID    int64     `json:"user_id" db:"user_id"`
+	ParentJTI string    `json:"parent_jti,omitempty" db:"parent_jti"`
+	IssuedAt  time.Time `json:"issued_at" db:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	// LastUsedAt is when an access token issued with this refresh token
+	// was last used, to the minute; zero if it never was or activity is
+	// not tracked.
+	LastUsedAt time.Time `json:"last_used_at,omitzero" db:"last_used_at"`
+}
+
+// LastActivity returns when the session was last active as of this
+// token: when it was issued or, if later, last used.
+func (t *RefreshToken) LastActivity() time.Time {
+	if t.LastUsedAt.After(t.IssuedAt) {
+		return t.LastUsedAt
+	}
+	return t.IssuedAt
+}
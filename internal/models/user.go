@@ -10,10 +10,38 @@ type User struct {
 	Email     string    `json:"email" db:"email"`
 	Password  string    `json:"-" db:"password_hash"` // Never serialize password hash
 	Role      string    `json:"role" db:"role"`
+	AvatarURL string    `json:"avatar_url,omitempty" db:"avatar_url"`
+	Version   int64     `json:"version" db:"version"`       // Incremented on every update; exposed as the ETag
+	Disabled  bool      `json:"disabled" db:"disabled"`     // Disabled users cannot log in or refresh tokens
+	Phone     string    `json:"phone,omitempty" db:"phone"` // Verified E.164 phone number
+	SMSOTP    bool      `json:"sms_otp" db:"sms_otp"`       // Password logins also require a code sent to Phone
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+
+	// ExpiresAt, when set, ends a temporary account such as a
+	// contractor's: from then on it cannot log in or refresh tokens.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+
+	// Metadata holds free-form application data. Access is governed by
+	// per-key policies, so it is never copied by PublicUser.
+	Metadata map[string]interface{} `json:"metadata,omitempty" db:"metadata"`
+
+	// PendingEmail is set in the user's own profile while a change of
+	// email address awaits confirmation. It is not stored on the user.
+	PendingEmail *PendingEmail `json:"pending_email,omitempty" db:"-"`
 }
 
+// Expired reports whether the account has an expiry that has passed by
+// now.
+func (u *User) Expired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
+}
+
+// RoleGuest is the role of an anonymous account created for a guest
+// session. Guests have no password and become full accounts, keeping their
+// ID, when they register.
+const RoleGuest = "guest"
+
 // PublicUser returns a safe representation of the user for API responses.
 func (u *User) PublicUser() *User {
 	return &User{
@@ -21,8 +49,14 @@ func (u *User) PublicUser() *User {
 		Username:  u.Username,
 		Email:     u.Email,
 		Role:      u.Role,
+		AvatarURL: u.AvatarURL,
+		Version:   u.Version,
+		Disabled:  u.Disabled,
+		Phone:     u.Phone,
+		SMSOTP:    u.SMSOTP,
 		CreatedAt: u.CreatedAt,
 		UpdatedAt: u.UpdatedAt,
-		// Password field is omitted
+		ExpiresAt: u.ExpiresAt,
+		// Password and Metadata fields are omitted
 	}
 }
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebhookAllEvents in Webhook.Events subscribes to every event type.
+const WebhookAllEvents = "*"
+
+// Webhook is an endpoint subscribed, at runtime, to Sentinel's events.
+type Webhook struct {
+	ID  int64  `json:"id" db:"id"`
+	URL string `json:"url" db:"url"`
+	// Events lists the event types delivered to the endpoint, or
+	// WebhookAllEvents.
+	Events []string `json:"events" db:"events"`
+	// Secret signs the requests sent to the endpoint. It is write-only:
+	// the API returns it only when it is generated.
+	Secret    string    `json:"-" db:"secret"`
+	Enabled   bool      `json:"enabled" db:"enabled"`
+	CreatedBy int64     `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Subscribed reports whether w is enabled and receives events of the given
+// type.
+func (w *Webhook) Subscribed(event string) bool {
+	return w.Enabled && w.Receives(event)
+}
+
+// Receives reports whether w's events include the given type, whether or
+// not it is enabled.
+func (w *Webhook) Receives(event string) bool {
+	for _, e := range w.Events {
+		if e == event || e == WebhookAllEvents {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery records one attempt to deliver an event to a webhook
+// endpoint. Redeliveries are separate records that share the EventID.
+type WebhookDelivery struct {
+	ID        int64  `json:"id" db:"id"`
+	WebhookID string `json:"webhook_id" db:"webhook_id"`
+	EventID   string `json:"event_id" db:"event_id"`
+	Event     string `json:"event" db:"event"`
+	URL       string `json:"url" db:"url"`
+	// Payload is the JSON request body.
+	Payload json.RawMessage `json:"payload" db:"payload"`
+	// StatusCode is the endpoint's response status, or 0 when no response
+	// was received; Error then says why.
+	StatusCode int    `json:"status_code" db:"status_code"`
+	Error      string `json:"error,omitempty" db:"error"`
+	DurationMS int64  `json:"duration_ms" db:"duration_ms"`
+	// RedeliveryOf is the ID of the delivery this one repeated.
+	RedeliveryOf int64     `json:"redelivery_of,omitempty" db:"redelivery_of"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// Succeeded reports whether the endpoint accepted the delivery.
+func (d *WebhookDelivery) Succeeded() bool {
+	return d.StatusCode >= 200 && d.StatusCode <= 299
+}
+
+// OutboxEvent is an emitted event held in the outbox. It is persisted
+// before delivery, so events emitted before a crash are still delivered
+// after a restart, and kept afterwards so they can be replayed.
+type OutboxEvent struct {
+	ID      int64  `json:"id" db:"id"`
+	EventID string `json:"event_id" db:"event_id"`
+	Type    string `json:"type" db:"type"`
+	// Payload is the JSON request body delivered to subscriptions.
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	// DispatchedAt is set once the event has been delivered to its
+	// subscriptions, successfully or not.
+	DispatchedAt *time.Time `json:"dispatched_at,omitempty" db:"dispatched_at"`
+}
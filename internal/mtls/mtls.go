@@ -0,0 +1,203 @@
+// Package mtls maps verified TLS client certificates to service accounts.
+// Machines on an internal network authenticate with a certificate issued by
+// a configured CA instead of a user JWT; the certificate's identity (a URI
+// SAN such as a SPIFFE ID, a DNS name, an email address, or the subject
+// common name) selects a service account whose scopes gate what it may call.
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Client authentication modes for the TLS listener.
+const (
+	// ModeOptional verifies certificates that clients present but lets
+	// clients without one continue with other authentication.
+	ModeOptional = "optional"
+	// ModeRequired rejects TLS handshakes without a valid client certificate.
+	ModeRequired = "required"
+)
+
+// Scopes checked by Sentinel's own routes.
+const (
+	// ScopeAdmin grants access to the /api/admin endpoints. Accounts may
+	// instead be granted the capabilities of delegated admins (see rbac),
+	// such as user.read, for the endpoints those cover.
+	ScopeAdmin = "admin"
+	// ScopeMetrics grants access to GET /metrics without the metrics token.
+	ScopeMetrics = "metrics"
+	// ScopeValidate grants access to POST /api/auth/validate-batch.
+	ScopeValidate = "validate"
+	// ScopeIntrospect grants access to POST
+	// /api/auth/resource-tokens/introspect.
+	ScopeIntrospect = "introspect"
+)
+
+// Identity prefixes for certificate fields other than URI SANs.
+const (
+	prefixDNS   = "dns:"
+	prefixEmail = "email:"
+	prefixCN    = "cn:"
+)
+
+// ServiceAccount is a machine principal and the scopes it was granted.
+type ServiceAccount struct {
+	Name   string
+	Scopes []string
+}
+
+// Identity is the authenticated peer of a request made with a verified
+// client certificate.
+type Identity struct {
+	// Subject is the certificate identity that matched, or the first
+	// candidate when no account matched.
+	Subject string
+	// Account is nil when the certificate is valid but maps to no account.
+	Account *ServiceAccount
+}
+
+// HasScope reports whether the identity's account was granted scope.
+func (id *Identity) HasScope(scope string) bool {
+	return id != nil && id.Account != nil && slices.Contains(id.Account.Scopes, scope)
+}
+
+// Accounts maps certificate identities to service accounts.
+type Accounts struct {
+	byIdentity map[string]*ServiceAccount
+}
+
+// ParseAccounts parses a semicolon-separated list of service account
+// entries of the form "identity account scope,scope", e.g.
+// "spiffe://corp/billing billing admin,metrics; cn:prometheus prom metrics".
+// Identities are URI SANs as written, or dns:, email:, or cn: followed by
+// the DNS SAN, email SAN, or subject common name.
+func ParseAccounts(spec string) (*Accounts, error) {
+	a := &Accounts{byIdentity: make(map[string]*ServiceAccount)}
+	for _, entry := range strings.Split(spec, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("invalid service account entry %q", strings.TrimSpace(entry))
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("service account entry %q has no account name", strings.TrimSpace(entry))
+		}
+		identity := fields[0]
+		if _, dup := a.byIdentity[identity]; dup {
+			return nil, fmt.Errorf("duplicate service account identity %q", identity)
+		}
+		account := &ServiceAccount{Name: fields[1]}
+		if len(fields) == 3 {
+			for _, scope := range strings.Split(fields[2], ",") {
+				if scope = strings.TrimSpace(scope); scope != "" {
+					account.Scopes = append(account.Scopes, scope)
+				}
+			}
+		}
+		a.byIdentity[identity] = account
+	}
+	return a, nil
+}
+
+// Len returns the number of configured identities.
+func (a *Accounts) Len() int {
+	if a == nil {
+		return 0
+	}
+	return len(a.byIdentity)
+}
+
+// Identify returns the identity of a verified client certificate. URI SANs
+// are tried first, then DNS and email SANs, then the common name.
+func (a *Accounts) Identify(cert *x509.Certificate) *Identity {
+	candidates := Candidates(cert)
+	if len(candidates) == 0 {
+		return nil
+	}
+	if a != nil {
+		for _, c := range candidates {
+			if account, ok := a.byIdentity[c]; ok {
+				return &Identity{Subject: c, Account: account}
+			}
+		}
+	}
+	return &Identity{Subject: candidates[0]}
+}
+
+// Candidates lists the identities a certificate can be matched by, in
+// priority order.
+func Candidates(cert *x509.Certificate) []string {
+	var out []string
+	for _, u := range cert.URIs {
+		out = append(out, u.String())
+	}
+	for _, name := range cert.DNSNames {
+		out = append(out, prefixDNS+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		out = append(out, prefixEmail+email)
+	}
+	if cn := cert.Subject.CommonName; cn != "" {
+		out = append(out, prefixCN+cn)
+	}
+	return out
+}
+
+// FromConnectionState returns the identity of the verified client
+// certificate on a TLS connection, or nil when none was presented.
+func (a *Accounts) FromConnectionState(cs *tls.ConnectionState) *Identity {
+	if cs == nil || len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return a.Identify(cs.VerifiedChains[0][0])
+}
+
+// ServerConfig returns TLS settings that verify client certificates against
+// the PEM bundle at caFile in the given mode.
+func ServerConfig(caFile, mode string) (*tls.Config, error) {
+	var clientAuth tls.ClientAuthType
+	switch mode {
+	case ModeOptional, "":
+		clientAuth = tls.VerifyClientCertIfGiven
+	case ModeRequired:
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid client auth mode %q (want %s or %s)", mode, ModeOptional, ModeRequired)
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("client CA file contains no PEM certificates")
+	}
+	return &tls.Config{
+		ClientAuth: clientAuth,
+		ClientCAs:  pool,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+type contextKey struct{}
+
+// ContextWithIdentity returns a copy of ctx carrying id.
+func ContextWithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the client certificate identity stored in ctx, or nil.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
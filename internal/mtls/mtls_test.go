@@ -0,0 +1,132 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseAccounts(t *testing.T) {
+	a, err := ParseAccounts("spiffe://corp/billing billing admin,metrics; cn:prometheus prom metrics ;dns:batch.internal batch")
+	if err != nil {
+		t.Fatalf("ParseAccounts: %v", err)
+	}
+	if a.Len() != 3 {
+		t.Fatalf("expected 3 accounts, got %d", a.Len())
+	}
+	if acct := a.byIdentity["cn:prometheus"]; acct == nil || acct.Name != "prom" || len(acct.Scopes) != 1 {
+		t.Errorf("unexpected prometheus account: %+v", acct)
+	}
+	if acct := a.byIdentity["dns:batch.internal"]; acct == nil || len(acct.Scopes) != 0 {
+		t.Errorf("unexpected batch account: %+v", acct)
+	}
+
+	for _, bad := range []string{"cn:lonely", "a b c d", "cn:x one; cn:x two"} {
+		if _, err := ParseAccounts(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestIdentifyPriority(t *testing.T) {
+	a, _ := ParseAccounts("spiffe://corp/api api admin; cn:api-client fallback metrics")
+	u, _ := url.Parse("spiffe://corp/api")
+	cert := &x509.Certificate{URIs: []*url.URL{u}, Subject: pkix.Name{CommonName: "api-client"}}
+
+	id := a.Identify(cert)
+	if id.Subject != "spiffe://corp/api" || !id.HasScope(ScopeAdmin) || id.HasScope(ScopeMetrics) {
+		t.Errorf("expected URI SAN to win, got %+v", id)
+	}
+
+	unknown := a.Identify(&x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}})
+	if unknown.Subject != "cn:stranger" || unknown.Account != nil || unknown.HasScope(ScopeAdmin) {
+		t.Errorf("unexpected identity for unmapped cert: %+v", unknown)
+	}
+	var none *Identity
+	if none.HasScope(ScopeAdmin) {
+		t.Error("nil identity must have no scopes")
+	}
+}
+
+func TestServerConfigVerifiesClients(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0o600)
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "prometheus"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTmpl, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := ServerConfig(caFile, ModeOptional)
+	if err != nil {
+		t.Fatalf("ServerConfig: %v", err)
+	}
+	accounts, _ := ParseAccounts("cn:prometheus prom metrics")
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := accounts.FromConnectionState(r.TLS); id.HasScope(ScopeMetrics) {
+			w.Write([]byte(id.Account.Name))
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(certs ...tls.Certificate) int {
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get(tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}); code != http.StatusOK {
+		t.Errorf("expected 200 with client certificate, got %d", code)
+	}
+	if code := get(); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without client certificate, got %d", code)
+	}
+
+	if _, err := ServerConfig(caFile, "sometimes"); err == nil {
+		t.Error("expected invalid mode to be rejected")
+	}
+}
@@ -0,0 +1,214 @@
+// Package pii encrypts personally identifiable fields, such as email
+// addresses and phone numbers, before they are stored.
+//
+// Values are envelope encrypted: each one is sealed with AES-256-GCM under
+// a fresh data key, and the data key is sealed by a KeyWrapper, which holds
+// the key encryption key locally or delegates to a KMS. Because the
+// ciphertext is randomized, lookups use a blind index instead: an HMAC of
+// the value under a separate index key, which is equal for equal values
+// but reveals nothing else about them.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the size of keys and data keys in bytes.
+const KeySize = 32
+
+// version prefixes every ciphertext so the format can change later.
+const version = "v1"
+
+// ErrUndecryptable is returned for ciphertexts that fail authentication,
+// i.e. were altered, moved to another field, or sealed under another key.
+var ErrUndecryptable = errors.New("pii: value could not be decrypted")
+
+// KeyWrapper seals and opens data keys under a key encryption key. A KMS
+// client can implement it so the key encryption key never leaves the KMS.
+type KeyWrapper interface {
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// Cipher encrypts field values and computes their blind indexes. It is
+// safe for concurrent use.
+type Cipher struct {
+	wrapper  KeyWrapper
+	indexKey []byte
+}
+
+// ParseKey decodes a 32-byte key given as 64 hex characters or as standard
+// or URL-safe base64.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := enc.DecodeString(s); err == nil && len(key) == KeySize {
+			return key, nil
+		}
+	}
+	return nil, errors.New("PII encryption key must be 32 bytes, hex or base64 encoded")
+}
+
+// New returns a Cipher whose key encryption key and index key are both
+// derived from key, which must be 32 bytes.
+func New(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("PII encryption key must be 32 bytes, got %d", len(key))
+	}
+	kek, err := hkdf.Key(sha256.New, key, nil, "sentinel pii key encryption", KeySize)
+	if err != nil {
+		return nil, err
+	}
+	indexKey, err := hkdf.Key(sha256.New, key, nil, "sentinel pii blind index", KeySize)
+	if err != nil {
+		return nil, err
+	}
+	wrapper, err := NewLocalWrapper(kek)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithWrapper(wrapper, indexKey)
+}
+
+// NewWithWrapper returns a Cipher that wraps data keys with w and computes
+// blind indexes under indexKey, which must be 32 bytes. The index key
+// cannot change without recomputing every stored index.
+func NewWithWrapper(w KeyWrapper, indexKey []byte) (*Cipher, error) {
+	if w == nil {
+		return nil, errors.New("PII key wrapper is required")
+	}
+	if len(indexKey) != KeySize {
+		return nil, fmt.Errorf("PII index key must be 32 bytes, got %d", len(indexKey))
+	}
+	return &Cipher{wrapper: w, indexKey: indexKey}, nil
+}
+
+// Encrypt seals value for field, which binds the ciphertext to the field so
+// it cannot be copied into another one.
+func (c *Cipher) Encrypt(field, value string) (string, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := c.wrapper.WrapKey(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, []byte(value), []byte(field))
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return version + "." + enc.EncodeToString(wrapped) + "." + enc.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a ciphertext Encrypt returned for field.
+func (c *Cipher) Decrypt(field, ciphertext string) (string, error) {
+	parts := strings.Split(ciphertext, ".")
+	if len(parts) != 3 || parts[0] != version {
+		return "", errors.New("pii: unsupported ciphertext format")
+	}
+	enc := base64.RawURLEncoding
+	wrapped, err1 := enc.DecodeString(parts[1])
+	sealed, err2 := enc.DecodeString(parts[2])
+	if err := errors.Join(err1, err2); err != nil {
+		return "", errors.New("pii: bad ciphertext encoding")
+	}
+	dataKey, err := c.wrapper.UnwrapKey(wrapped)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(aead, sealed, []byte(field))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Index returns the blind index of value for field as 64 hex characters.
+// Callers normalize value first, e.g. lowercasing email addresses, so that
+// values that should match do.
+func (c *Cipher) Index(field, value string) string {
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// localWrapper wraps data keys with AES-GCM under a key held in memory.
+type localWrapper struct {
+	aead cipher.AEAD
+}
+
+// NewLocalWrapper returns a KeyWrapper that seals data keys under kek,
+// which must be 32 bytes.
+func NewLocalWrapper(kek []byte) (KeyWrapper, error) {
+	if len(kek) != KeySize {
+		return nil, fmt.Errorf("PII key encryption key must be 32 bytes, got %d", len(kek))
+	}
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	return localWrapper{aead: aead}, nil
+}
+
+func (w localWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	return seal(w.aead, dataKey, nil)
+}
+
+func (w localWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return open(w.aead, wrapped, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plain under a random nonce, which it prepends.
+func seal(aead cipher.AEAD, plain, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, ad), nil
+}
+
+// open reverses seal.
+func open(aead cipher.AEAD, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrUndecryptable
+	}
+	n := aead.NonceSize()
+	plain, err := aead.Open(nil, sealed[:n], sealed[n:], ad)
+	if err != nil {
+		return nil, ErrUndecryptable
+	}
+	return plain, nil
+}
@@ -0,0 +1,49 @@
+package pii
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCipher(t *testing.T) {
+	c, err := New(bytes.Repeat([]byte{1}, KeySize))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	a, err := c.Encrypt("email", "alice@example.com")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	b, _ := c.Encrypt("email", "alice@example.com")
+	if a == b || strings.Contains(a, "alice") {
+		t.Errorf("ciphertexts should be randomized and opaque: %q, %q", a, b)
+	}
+	if got, err := c.Decrypt("email", a); err != nil || got != "alice@example.com" {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+	if _, err := c.Decrypt("phone", a); !errors.Is(err, ErrUndecryptable) {
+		t.Errorf("a ciphertext moved to another field should not decrypt, got %v", err)
+	}
+
+	other, _ := New(bytes.Repeat([]byte{2}, KeySize))
+	if _, err := other.Decrypt("email", a); !errors.Is(err, ErrUndecryptable) {
+		t.Errorf("another key should not decrypt, got %v", err)
+	}
+
+	if c.Index("email", "a@b.c") != c.Index("email", "a@b.c") {
+		t.Error("blind indexes should be deterministic")
+	}
+	if c.Index("email", "a@b.c") == c.Index("phone", "a@b.c") || c.Index("email", "a@b.c") == other.Index("email", "a@b.c") {
+		t.Error("blind indexes should depend on the field and the key")
+	}
+
+	if _, err := ParseKey(strings.Repeat("ab", KeySize)); err != nil {
+		t.Errorf("ParseKey hex: %v", err)
+	}
+	if _, err := ParseKey("too short"); err == nil {
+		t.Error("ParseKey should reject short keys")
+	}
+}
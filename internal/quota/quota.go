@@ -0,0 +1,309 @@
+// Package quota enforces daily and monthly request quotas per client, on
+// top of the per-IP burst rate limits. A client is a service account
+// authenticated with a TLS client certificate or an authenticated user;
+// quotas are set by administrators and stored in the database, as are the
+// request counters, so every instance enforces the same totals.
+//
+// Quota definitions are held in memory so requests from clients without
+// one cost nothing, and are reloaded periodically to pick up changes made
+// on other instances.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/httpjson"
+	"github.com/mayvqt/Sentinel/internal/leader"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/mtls"
+	"github.com/mayvqt/Sentinel/internal/syncloop"
+)
+
+// purgeInterval is how often Run deletes expired counters.
+const purgeInterval = time.Hour
+
+// PurgeJob names the counter purge for leader election.
+const PurgeJob = "quota-usage-purge"
+
+// Subject prefixes naming the kind of client a quota applies to.
+const (
+	PrefixService = "service:"
+	PrefixUser    = "user:"
+)
+
+// Quota periods. Both are calendar periods in UTC.
+const (
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+)
+
+// Response headers describing the quota period closest to running out.
+const (
+	HeaderLimit     = "X-Quota-Limit"
+	HeaderRemaining = "X-Quota-Remaining"
+	// HeaderReset is the number of seconds until the period resets.
+	HeaderReset  = "X-Quota-Reset"
+	HeaderPeriod = "X-Quota-Period"
+)
+
+var decisions = metrics.NewCounterVec(
+	"sentinel_quota_requests_total",
+	"Requests by clients with a quota, by decision (allowed or rejected).",
+	"decision",
+)
+
+// Store holds quotas and request counters. store.Store satisfies it.
+type Store interface {
+	ListQuotas(ctx context.Context) ([]models.Quota, error)
+	IncrementQuotaUsage(ctx context.Context, subject, period string, expiresAt time.Time) (int64, error)
+	QuotaUsage(ctx context.Context, subject, period string) (int64, error)
+	PurgeQuotaUsage(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// ValidSubject reports an error unless subject names a service account
+// ("service:billing") or a user ID ("user:42").
+func ValidSubject(subject string) error {
+	if name, ok := strings.CutPrefix(subject, PrefixService); ok {
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return errors.New("service account name must not be empty or contain spaces")
+		}
+		return nil
+	}
+	if id, ok := strings.CutPrefix(subject, PrefixUser); ok {
+		if n, err := strconv.ParseInt(id, 10, 64); err != nil || n <= 0 {
+			return errors.New("user ID must be a positive integer")
+		}
+		return nil
+	}
+	return fmt.Errorf("subject must start with %q or %q", PrefixService, PrefixUser)
+}
+
+// Subject returns the quota subject of the client making r: its service
+// account when it presented a certificate mapped to one, otherwise the
+// authenticated user, or "" for anonymous requests.
+func Subject(r *http.Request) string {
+	if id := mtls.FromContext(r.Context()); id != nil && id.Account != nil {
+		return PrefixService + id.Account.Name
+	}
+	if claims, ok := r.Context().Value("user").(*auth.Claims); ok && claims.UserID != "" {
+		return PrefixUser + claims.UserID
+	}
+	return ""
+}
+
+// Window is one quota period containing a point in time.
+type Window struct {
+	Period string
+	// Key identifies the period's counter, e.g. "day:2024-05-01".
+	Key   string
+	Reset time.Time
+}
+
+// Windows returns the day and month containing t.
+func Windows(t time.Time) []Window {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return []Window{
+		{Period: PeriodDay, Key: PeriodDay + ":" + day.Format(time.DateOnly), Reset: day.AddDate(0, 0, 1)},
+		{Period: PeriodMonth, Key: PeriodMonth + ":" + month.Format("2006-01"), Reset: month.AddDate(0, 1, 0)},
+	}
+}
+
+// limit returns q's limit for period.
+func limit(q models.Quota, period string) int64 {
+	if period == PeriodDay {
+		return q.Daily
+	}
+	return q.Monthly
+}
+
+// Usage is a client's use of one quota period. Limit is 0 when the
+// period is unlimited.
+type Usage struct {
+	Period    string    `json:"period"`
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// Exceeded reports whether the period's limit has been passed.
+func (u Usage) Exceeded() bool {
+	return u.Limit > 0 && u.Used > u.Limit
+}
+
+func newUsage(w Window, limit, used int64) Usage {
+	return Usage{Period: w.Period, Limit: limit, Used: used, Remaining: max(limit-used, 0), Reset: w.Reset}
+}
+
+// Enforcer applies quotas to requests. It is safe for concurrent use.
+type Enforcer struct {
+	store  Store
+	now    func() time.Time
+	leader *leader.Elector
+
+	mu     sync.Mutex // serializes writers of quotas
+	quotas atomic.Pointer[map[string]models.Quota]
+}
+
+// New returns an enforcer with no quotas that counts requests in s.
+func New(s Store) *Enforcer {
+	e := &Enforcer{store: s, now: time.Now}
+	e.quotas.Store(&map[string]models.Quota{})
+	return e
+}
+
+// Sync replaces the enforcer's quotas with those in its store.
+func (e *Enforcer) Sync(ctx context.Context) error {
+	list, err := e.store.ListQuotas(ctx)
+	if err != nil {
+		return err
+	}
+	quotas := make(map[string]models.Quota, len(list))
+	for _, q := range list {
+		quotas[q.Subject] = q
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.quotas.Store(&quotas)
+	return nil
+}
+
+// SetLeader makes Run purge counters only while e elects this instance to
+// run PurgeJob. Syncing is unaffected.
+func (e *Enforcer) SetLeader(l *leader.Elector) {
+	e.leader = l
+}
+
+// Run keeps the quotas in sync with the store, and deletes expired
+// counters every hour while this instance leads PurgeJob, until ctx is
+// canceled.
+func (e *Enforcer) Run(ctx context.Context, interval time.Duration) {
+	var purged time.Time
+	purge := func() {
+		if now := e.now(); now.Sub(purged) >= purgeInterval && e.leader.Leads(ctx, PurgeJob) {
+			purged = now
+			if _, err := e.store.PurgeQuotaUsage(ctx, now); err != nil && ctx.Err() == nil {
+				logger.Warn("Quota usage purge failed", map[string]interface{}{"error": err.Error()})
+			}
+		}
+	}
+	purge()
+	syncloop.Run(ctx, interval, "Quota", func(ctx context.Context) error {
+		err := e.Sync(ctx)
+		purge()
+		return err
+	})
+}
+
+// Set applies q on this instance immediately, rather than at the next
+// sync.
+func (e *Enforcer) Set(q models.Quota) {
+	e.update(func(quotas map[string]models.Quota) { quotas[q.Subject] = q })
+}
+
+// Remove drops the quota for subject on this instance immediately.
+func (e *Enforcer) Remove(subject string) {
+	e.update(func(quotas map[string]models.Quota) { delete(quotas, subject) })
+}
+
+// update replaces the quotas with a modified copy.
+func (e *Enforcer) update(fn func(map[string]models.Quota)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	quotas := maps.Clone(*e.quotas.Load())
+	fn(quotas)
+	e.quotas.Store(&quotas)
+}
+
+// CurrentUsage returns the client's use of q in the day and month
+// containing now, as counted in s.
+func CurrentUsage(ctx context.Context, s Store, q models.Quota, now time.Time) ([]Usage, error) {
+	var usage []Usage
+	for _, w := range Windows(now) {
+		used, err := s.QuotaUsage(ctx, q.Subject, w.Key)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, newUsage(w, limit(q, w.Period), used))
+	}
+	return usage, nil
+}
+
+// Enforce counts r against its client's quota and sets the quota headers.
+// When a limit has been passed it writes a 429 and returns false. Requests
+// from clients without a quota pass untouched; if the counters cannot be
+// updated the request is let through, so an outage of the store does not
+// lock clients out. Rejected requests are counted too. A nil Enforcer
+// admits everything.
+func (e *Enforcer) Enforce(w http.ResponseWriter, r *http.Request) bool {
+	if e == nil {
+		return true
+	}
+	subject := Subject(r)
+	if subject == "" {
+		return true
+	}
+	q, ok := (*e.quotas.Load())[subject]
+	if !ok {
+		return true
+	}
+
+	var tightest *Usage
+	for _, win := range Windows(e.now()) {
+		lim := limit(q, win.Period)
+		if lim <= 0 {
+			continue
+		}
+		used, err := e.store.IncrementQuotaUsage(r.Context(), subject, win.Key, win.Reset)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("Quota usage update failed", map[string]interface{}{
+				"subject": subject,
+				"error":   err.Error(),
+			})
+			return true
+		}
+		u := newUsage(win, lim, used)
+		if tightest == nil || (u.Exceeded() && !tightest.Exceeded()) ||
+			(u.Exceeded() == tightest.Exceeded() && u.Remaining < tightest.Remaining) {
+			tightest = &u
+		}
+	}
+	if tightest == nil {
+		return true
+	}
+
+	resetIn := max(int64(tightest.Reset.Sub(e.now()).Seconds()+0.5), 0)
+	h := w.Header()
+	h.Set(HeaderLimit, strconv.FormatInt(tightest.Limit, 10))
+	h.Set(HeaderRemaining, strconv.FormatInt(tightest.Remaining, 10))
+	h.Set(HeaderReset, strconv.FormatInt(resetIn, 10))
+	h.Set(HeaderPeriod, tightest.Period)
+	if !tightest.Exceeded() {
+		decisions.WithLabelValues("allowed").Inc()
+		return true
+	}
+
+	decisions.WithLabelValues("rejected").Inc()
+	logger.FromContext(r.Context()).Warn("Request quota exceeded", map[string]interface{}{
+		"subject": subject,
+		"period":  tightest.Period,
+		"limit":   tightest.Limit,
+	})
+	h.Set("Retry-After", strconv.FormatInt(resetIn, 10))
+	httpjson.Error(w, "Request quota exceeded for this "+tightest.Period, http.StatusTooManyRequests)
+	return false
+}
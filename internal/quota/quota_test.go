@@ -0,0 +1,112 @@
+package quota
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/mtls"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+func TestValidSubject(t *testing.T) {
+	for _, ok := range []string{"service:billing", "user:42"} {
+		if err := ValidSubject(ok); err != nil {
+			t.Errorf("expected %q to be valid: %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"", "billing", "service:", "service:a b", "user:0", "user:bob"} {
+		if err := ValidSubject(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestSubject(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if s := Subject(r); s != "" {
+		t.Errorf("expected no subject for an anonymous request, got %q", s)
+	}
+	ctx := context.WithValue(r.Context(), "user", &auth.Claims{UserID: "7"})
+	if s := Subject(r.WithContext(ctx)); s != "user:7" {
+		t.Errorf("expected the user, got %q", s)
+	}
+	ctx = mtls.ContextWithIdentity(ctx, &mtls.Identity{Subject: "cn:billing", Account: &mtls.ServiceAccount{Name: "billing"}})
+	if s := Subject(r.WithContext(ctx)); s != "service:billing" {
+		t.Errorf("expected the service account to take precedence, got %q", s)
+	}
+}
+
+func TestWindows(t *testing.T) {
+	w := Windows(time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC))
+	if w[0].Key != "day:2024-12-31" || !w[0].Reset.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected day window %+v", w[0])
+	}
+	if w[1].Key != "month:2024-12" || !w[1].Reset.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected month window %+v", w[1])
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemStore()
+	s.SetQuota(ctx, &models.Quota{Subject: "user:7", Daily: 2, Monthly: 10})
+	e := New(s)
+	e.now = func() time.Time { return time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC) }
+	if err := e.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(userID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/auth/profile", nil)
+		r = r.WithContext(context.WithValue(r.Context(), "user", &auth.Claims{UserID: userID}))
+		w := httptest.NewRecorder()
+		if !e.Enforce(w, r) && w.Code != http.StatusTooManyRequests {
+			t.Fatalf("expected a 429 when rejected, got %d", w.Code)
+		}
+		return w
+	}
+
+	w := request("7")
+	if w.Header().Get(HeaderLimit) != "2" || w.Header().Get(HeaderRemaining) != "1" || w.Header().Get(HeaderPeriod) != PeriodDay || w.Header().Get(HeaderReset) != "3600" {
+		t.Errorf("unexpected quota headers %v", w.Header())
+	}
+	if w := request("7"); w.Code != http.StatusOK || w.Header().Get(HeaderRemaining) != "0" {
+		t.Errorf("expected the last request of the day to be allowed, got %d %v", w.Code, w.Header())
+	}
+	if w := request("7"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("expected the daily quota to be enforced, got %d %v", w.Code, w.Header())
+	}
+	if w := request("8"); w.Code != http.StatusOK || w.Header().Get(HeaderLimit) != "" {
+		t.Errorf("expected clients without a quota to pass untouched, got %d %v", w.Code, w.Header())
+	}
+
+	// The next day, the monthly quota still counts yesterday's requests
+	e.now = func() time.Time { return time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC) }
+	if w := request("7"); w.Code != http.StatusOK || w.Header().Get(HeaderRemaining) != "1" {
+		t.Errorf("expected a new day to reset the daily quota, got %d %v", w.Code, w.Header())
+	}
+	usage, err := CurrentUsage(ctx, s, models.Quota{Subject: "user:7", Daily: 2, Monthly: 10}, e.now())
+	if err != nil || len(usage) != 2 || usage[0].Used != 1 || usage[1].Used != 4 || usage[1].Remaining != 6 {
+		t.Errorf("unexpected usage %+v (%v)", usage, err)
+	}
+
+	e.Remove("user:7")
+	if w := request("7"); w.Header().Get(HeaderLimit) != "" {
+		t.Errorf("expected a removed quota not to apply, got %v", w.Header())
+	}
+	e.Set(models.Quota{Subject: "user:8", Monthly: 1})
+	request("8")
+	if w := request("8"); w.Code != http.StatusTooManyRequests || w.Header().Get(HeaderPeriod) != PeriodMonth {
+		t.Errorf("expected the monthly quota to be enforced, got %d %v", w.Code, w.Header())
+	}
+
+	var nilEnforcer *Enforcer
+	if !nilEnforcer.Enforce(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil)) {
+		t.Error("expected a nil Enforcer to admit requests")
+	}
+}
@@ -0,0 +1,127 @@
+// Package rbac maps delegated admin roles to the admin capabilities they
+// grant. The admin role grants every capability and is the only one that
+// may use the admin endpoints no capability covers, such as backups and
+// webhooks; delegated roles such as support grant a few, so helpdesk staff
+// can look users up without being able to delete them.
+package rbac
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Capabilities checked by the admin routes.
+const (
+	// UserRead grants searching, counting, and viewing users and their
+	// sessions.
+	UserRead = "user.read"
+	// UserWrite grants editing user metadata and disabling, deleting, and
+	// purging users who are not admins or delegated admins.
+	UserWrite = "user.write"
+	// AuditRead grants reading the audit log.
+	AuditRead = "audit.read"
+	// TokenRevoke grants revoking tokens.
+	TokenRevoke = "token.revoke"
+)
+
+// Capabilities lists every capability a delegated role may grant.
+var Capabilities = []string{UserRead, UserWrite, AuditRead, TokenRevoke}
+
+// Built-in roles, which cannot be redefined.
+const (
+	// RoleAdmin grants every capability.
+	RoleAdmin = "admin"
+	// RoleUser grants none.
+	RoleUser = "user"
+)
+
+// DefaultRoleSpec is used when no role spec is configured.
+const DefaultRoleSpec = "support:user.read,audit.read; billing:user.read,user.write; security:user.read,audit.read,token.revoke"
+
+var roleName = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// builtin is used by a nil Roles.
+var builtin = Default()
+
+// Roles maps delegated role names to the capabilities they grant.
+type Roles struct {
+	grants map[string][]string
+}
+
+// ParseRoles parses a semicolon-separated list of role:capability,...
+// entries, e.g. "support:user.read,audit.read; auditor:audit.read".
+func ParseRoles(spec string) (*Roles, error) {
+	r := &Roles{grants: make(map[string][]string)}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, caps, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || !roleName.MatchString(name) {
+			return nil, fmt.Errorf("invalid admin role entry %q", entry)
+		}
+		if name == RoleAdmin || name == RoleUser {
+			return nil, fmt.Errorf("admin role entry %q redefines a built-in role", entry)
+		}
+		if _, dup := r.grants[name]; dup {
+			return nil, fmt.Errorf("duplicate admin role %q", name)
+		}
+		var grants []string
+		for _, c := range strings.Split(caps, ",") {
+			c = strings.TrimSpace(c)
+			if !slices.Contains(Capabilities, c) {
+				return nil, fmt.Errorf("admin role %q has unknown capability %q", name, c)
+			}
+			grants = append(grants, c)
+		}
+		r.grants[name] = grants
+	}
+	return r, nil
+}
+
+// Default returns the built-in delegated roles.
+func Default() *Roles {
+	r, _ := ParseRoles(DefaultRoleSpec)
+	return r
+}
+
+// Allows reports whether role grants capability. The admin role grants
+// every capability, including RoleAdmin, which names the admin-only
+// endpoints. A nil Roles has the built-in roles.
+func (r *Roles) Allows(role, capability string) bool {
+	if role == RoleAdmin {
+		return true
+	}
+	return slices.Contains(r.Grants(role), capability)
+}
+
+// Grants returns the capabilities role grants, or nil for roles that are
+// not delegated admin roles.
+func (r *Roles) Grants(role string) []string {
+	if r == nil {
+		r = builtin
+	}
+	return r.grants[role]
+}
+
+// IsDelegated reports whether role is a delegated admin role.
+func (r *Roles) IsDelegated(role string) bool {
+	return len(r.Grants(role)) > 0
+}
+
+// Names returns the delegated role names, sorted.
+func (r *Roles) Names() []string {
+	if r == nil {
+		r = builtin
+	}
+	names := make([]string, 0, len(r.grants))
+	for name := range r.grants {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
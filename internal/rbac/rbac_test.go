@@ -0,0 +1,36 @@
+package rbac
+
+import "testing"
+
+func TestParseRoles(t *testing.T) {
+	r, err := ParseRoles("helpdesk:user.read; auditor: audit.read , user.read")
+	if err != nil {
+		t.Fatalf("ParseRoles: %v", err)
+	}
+	if !r.Allows("helpdesk", UserRead) || r.Allows("helpdesk", UserWrite) {
+		t.Error("helpdesk should grant user.read only")
+	}
+	if !r.Allows("auditor", AuditRead) || !r.Allows(RoleAdmin, TokenRevoke) || !r.Allows(RoleAdmin, RoleAdmin) {
+		t.Error("expected auditor and admin grants")
+	}
+	if r.Allows("auditor", RoleAdmin) || r.IsDelegated(RoleUser) || r.IsDelegated("support") {
+		t.Error("delegated roles must not grant admin, and only configured roles are delegated")
+	}
+
+	for _, spec := range []string{
+		"admin:user.read",
+		"helpdesk",
+		"helpdesk:user.delete",
+		"helpdesk:user.read; helpdesk:audit.read",
+		"Help Desk:user.read",
+	} {
+		if _, err := ParseRoles(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+
+	var defaults *Roles
+	if got := defaults.Names(); len(got) != 3 || got[0] != "billing" {
+		t.Errorf("unexpected built-in roles %v", got)
+	}
+}
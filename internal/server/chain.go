@@ -0,0 +1,109 @@
+package server
+
+import "net/http"
+
+// slot is a middleware's position in a chain. A chain nests its middleware
+// in slot order, outermost first, whatever order they were added in, so
+// every route wraps its handler the same way.
+type slot int
+
+const (
+	// slotRequestID comes first so that everything after it, including
+	// rejections, is logged and answered with the request's ID.
+	slotRequestID slot = iota
+	slotBodyLimit
+	slotSecurityHeaders
+	// slotCacheControl sets the default Cache-Control, which handlers may
+	// override.
+	slotCacheControl
+	slotRateLimit
+	slotCORS
+	slotAuth
+	// slotSignature checks request signatures, which are keyed by the
+	// caller's token.
+	slotSignature
+	slotQuota
+	// slotActivity records that the caller's session was used, once the
+	// request has been admitted.
+	slotActivity
+	// slotLogging follows auth so that it sees the caller's claims.
+	slotLogging
+	// slotChaos injects faults inside logging, so that they are logged
+	// like real ones.
+	slotChaos
+	numSlots
+)
+
+var slotNames = [numSlots]string{
+	slotRequestID:       "request-id",
+	slotBodyLimit:       "body-limit",
+	slotSecurityHeaders: "security-headers",
+	slotCacheControl:    "cache-control",
+	slotRateLimit:       "rate-limit",
+	slotCORS:            "cors",
+	slotAuth:            "auth",
+	slotSignature:       "signature",
+	slotQuota:           "quota",
+	slotActivity:        "activity",
+	slotLogging:         "logging",
+	slotChaos:           "chaos",
+}
+
+func (s slot) String() string { return slotNames[s] }
+
+// chain holds at most one middleware per slot. Chains are values: with and
+// without return modified copies, so a route group extends a shared chain
+// without changing it for the others.
+type chain [numSlots]func(http.Handler) http.Handler
+
+// with returns c with mw in slot s, replacing any middleware already there.
+func (c chain) with(s slot, mw func(http.Handler) http.Handler) chain {
+	c[s] = mw
+	return c
+}
+
+// without returns c with slot s empty.
+func (c chain) without(s slot) chain {
+	c[s] = nil
+	return c
+}
+
+// slots returns the occupied slots, outermost first.
+func (c chain) slots() []slot {
+	var slots []slot
+	for s := range numSlots {
+		if c[s] != nil {
+			slots = append(slots, s)
+		}
+	}
+	return slots
+}
+
+// then wraps h in the chain's middleware. OPTIONS requests that get
+// through the chain are CORS preflights it did not answer (see
+// withMethods); they are answered without calling h.
+func (c chain) then(h http.Handler) http.Handler {
+	h = refuseOptions(h)
+	for s := numSlots - 1; s >= 0; s-- {
+		if c[s] != nil {
+			h = c[s](h)
+		}
+	}
+	return h
+}
+
+// thenFunc is then for a handler function.
+func (c chain) thenFunc(fn http.HandlerFunc) http.Handler {
+	return c.then(fn)
+}
+
+// refuseOptions answers OPTIONS requests with 204 instead of calling next.
+func refuseOptions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
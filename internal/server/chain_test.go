@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/config"
+	"github.com/mayvqt/Sentinel/internal/handlers"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	serve := func(c chain) []string {
+		calls = nil
+		c.thenFunc(func(http.ResponseWriter, *http.Request) { calls = append(calls, "handler") }).
+			ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		return calls
+	}
+
+	// Middleware nest in slot order however they were added
+	base := chain{}.
+		with(slotLogging, record("logging")).
+		with(slotAuth, record("auth")).
+		with(slotRequestID, record("request-id"))
+	if got, want := serve(base), []string{"request-id", "auth", "logging", "handler"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Groups extend a chain without changing it
+	group := base.with(slotAuth, record("admin")).with(slotRateLimit, record("rate-limit")).without(slotLogging)
+	if got, want := serve(group), []string{"request-id", "rate-limit", "admin", "handler"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := serve(base), []string{"request-id", "auth", "logging", "handler"}; !slices.Equal(got, want) {
+		t.Errorf("expected the base chain to be unchanged, got %v", got)
+	}
+	if got := group.slots(); !slices.Equal(got, []slot{slotRequestID, slotRateLimit, slotAuth}) {
+		t.Errorf("unexpected slots %v", got)
+	}
+}
+
+func TestRoutesHaveBaseMiddleware(t *testing.T) {
+	s := store.NewMemStore()
+	a := auth.New(&config.Config{JWTSecret: "test-secret-123"})
+	h := handlers.New(s, a)
+	h.MetricsEnabled = true
+	srv := New(":0", s, h, []string{"http://localhost:3000"}, WithAdminUI())
+	handler := srv.httpServer.Handler
+
+	userToken, _ := a.GenerateToken("1", "user", time.Minute)
+	for _, tt := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{"GET", "/health", "", http.StatusOK},
+		{"GET", "/metrics", "", http.StatusOK},
+		{"POST", "/api/auth/login", "", http.StatusBadRequest},
+		{"GET", "/api/auth/sso/check", "", http.StatusNotImplemented},
+		{"GET", "/api/auth/profile", "", http.StatusUnauthorized},
+		{"POST", "/api/auth/logout", "", http.StatusUnauthorized},
+		{"GET", "/api/admin/stats", "", http.StatusUnauthorized},
+		{"GET", "/api/admin/stats", userToken, http.StatusForbidden},
+		{"POST", "/api/auth/resource-tokens/introspect", "", http.StatusUnauthorized},
+		{"POST", "/api/auth/resource-tokens/introspect", userToken, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader("{"))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, w.Code, tt.status)
+		}
+		if w.Header().Get(middleware.RequestIDHeader) == "" {
+			t.Errorf("%s %s: missing request ID", tt.method, tt.path)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s %s: missing security headers", tt.method, tt.path)
+		}
+		if w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s %s: Cache-Control %q, want no-store", tt.method, tt.path, w.Header().Get("Cache-Control"))
+		}
+	}
+}
+
+func TestDelegatedAdminRoutes(t *testing.T) {
+	s := store.NewMemStore()
+	a := auth.New(&config.Config{JWTSecret: "test-secret-123"})
+	h := handlers.New(s, a)
+	handler := New(":0", s, h, nil).httpServer.Handler
+	for _, u := range []*models.User{
+		{Username: "root", Email: "root@example.com", Password: "hash", Role: "admin"},
+		{Username: "customer", Email: "customer@example.com", Password: "hash", Role: "user"},
+	} {
+		if _, err := s.CreateUser(context.Background(), u); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+
+	support, _ := a.GenerateToken("3", "support", time.Minute)
+	billing, _ := a.GenerateToken("4", "billing", time.Minute)
+	elevated, _, _ := a.IssueElevatedToken("3", "support", []string{"token.revoke"}, time.Now().Add(time.Minute))
+	elevatedAdmin, _, _ := a.IssueElevatedToken("3", "support", []string{"admin"}, time.Now().Add(time.Minute))
+	requests := 0
+	serve := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		// Each request comes from its own address to stay under the rate limit
+		requests++
+		req.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", requests)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	for _, tt := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{"GET", "/api/admin/users/2", support, http.StatusOK},
+		{"GET", "/api/admin/audit", support, http.StatusOK},
+		{"POST", "/api/admin/tokens:revoke", support, http.StatusForbidden},
+		{"POST", "/api/admin/users:batchDisable", support, http.StatusForbidden},
+		{"GET", "/api/admin/audit", billing, http.StatusForbidden},
+		{"GET", "/api/admin/stats", support, http.StatusForbidden},
+		{"POST", "/api/admin/users:batchAssignRole", billing, http.StatusForbidden},
+		{"POST", "/api/admin/tokens:revoke", elevated, http.StatusBadRequest},
+		{"GET", "/api/admin/stats", elevated, http.StatusForbidden},
+		{"GET", "/api/admin/stats", elevatedAdmin, http.StatusOK},
+	} {
+		if w := serve(tt.method, tt.path, tt.token, "{}"); w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, w.Code, tt.status)
+		}
+	}
+
+	// Delegated admins cannot act on admins
+	w := serve("POST", "/api/admin/users:batchDisable", billing, `{"ids":[1,2]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("batchDisable: status %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Results []struct{ Status string } `json:"results"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Results) != 2 || resp.Results[0].Status != "skipped" || resp.Results[1].Status != "ok" {
+		t.Errorf("expected the admin skipped and the user disabled, got %s", w.Body.String())
+	}
+}
+
+func TestSessionActivityRecorded(t *testing.T) {
+	s := store.NewMemStore()
+	a := auth.New(&config.Config{JWTSecret: "test-secret-123"})
+	h := handlers.New(s, a)
+	h.SessionIdleTimeout = time.Hour
+	handler := New(":0", s, h, nil).httpServer.Handler
+	ctx := context.Background()
+	if _, err := s.CreateUser(ctx, &models.User{Username: "active", Email: "active@example.com", Password: "hash", Role: "user"}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := s.SaveRefreshToken(ctx, &models.RefreshToken{JTI: "session", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("SaveRefreshToken: %v", err)
+	}
+
+	token, _ := a.GenerateSessionToken("1", "user", auth.Grant{SessionID: "session"}, time.Minute)
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if rec, _ := s.GetRefreshToken(ctx, "session"); rec == nil || rec.LastUsedAt.IsZero() {
+		t.Errorf("expected the session's use to be recorded, got %+v", rec)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mayvqt/Sentinel/internal/httpjson"
+)
+
+// routeMethods are the methods routes are registered with. HEAD is served
+// by every GET route.
+var routeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// withMethods serves mux, answering for it when a path is routed but not
+// for the request's method. Other methods get a 405 with an Allow header
+// listing the route's methods, and OPTIONS requests get a 204 with the
+// same header. CORS preflights are passed to the route for the method they
+// ask about, whose chain answers them if the route allows CORS. The
+// answers are wrapped in c, so they are logged and rate limited like
+// routes.
+func withMethods(mux *http.ServeMux, c chain) http.Handler {
+	// The chain answers OPTIONS requests itself; see chain.then.
+	options := c.then(http.NotFoundHandler())
+	notAllowed := c.thenFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpjson.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			if method := r.Header.Get("Access-Control-Request-Method"); method != "" && r.Header.Get("Origin") != "" {
+				if route, pattern := mux.Handler(withMethod(r, method)); pattern != "" {
+					route.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		allowed := allowedMethods(mux, r)
+		switch {
+		case allowed == nil:
+			mux.ServeHTTP(w, r)
+		case r.Method == http.MethodOptions:
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			options.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			notAllowed.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allowedMethods returns the methods mux routes r's path for, with
+// OPTIONS, or nil when the path is not routed.
+func allowedMethods(mux *http.ServeMux, r *http.Request) []string {
+	var methods []string
+	for _, method := range routeMethods {
+		if _, pattern := mux.Handler(withMethod(r, method)); pattern != "" {
+			methods = append(methods, method)
+		}
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+	return append(methods, http.MethodOptions)
+}
+
+// withMethod returns a shallow copy of r with method, for looking up the
+// route that would serve it.
+func withMethod(r *http.Request, method string) *http.Request {
+	r = r.WithContext(r.Context())
+	r.Method = method
+	return r
+}
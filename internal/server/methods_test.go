@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/config"
+	"github.com/mayvqt/Sentinel/internal/handlers"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+func TestMethodHandling(t *testing.T) {
+	s := store.NewMemStore()
+	h := handlers.New(s, auth.New(&config.Config{JWTSecret: "test-secret-123"}))
+	handler := New(":0", s, h, []string{"http://localhost:3000"}).httpServer.Handler
+
+	serve := func(method, path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodGet, "/api/auth/register", nil)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST, OPTIONS" {
+		t.Errorf("GET register: expected 405 allowing POST, got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+	if w.Header().Get("Content-Type") != "application/json" || w.Header().Get(middleware.RequestIDHeader) == "" {
+		t.Errorf("GET register: expected a JSON error with base headers, got %v", w.Header())
+	}
+
+	w = serve(http.MethodDelete, "/api/auth/recovery-codes", nil)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD, POST, OPTIONS" {
+		t.Errorf("DELETE recovery-codes: expected 405 allowing GET and POST, got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	// net/http discards the body of HEAD responses.
+	if w = serve(http.MethodHead, "/health", nil); w.Code != http.StatusOK {
+		t.Errorf("HEAD health: expected 200, got %d", w.Code)
+	}
+
+	w = serve(http.MethodOptions, "/api/auth/login", nil)
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "POST, OPTIONS" {
+		t.Errorf("OPTIONS login: expected 204 allowing POST, got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	// Preflights reach the chain of the method they ask about.
+	preflight := map[string]string{"Origin": "http://localhost:3000", "Access-Control-Request-Method": "PATCH"}
+	w = serve(http.MethodOptions, "/api/auth/profile/metadata", preflight)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Errorf("preflight: expected CORS headers, got %d %v", w.Code, w.Header())
+	}
+	preflight["Access-Control-Request-Method"] = "POST"
+	if w = serve(http.MethodOptions, "/api/csp-report", preflight); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("preflight to a route without CORS: expected no CORS headers, got %v", w.Header())
+	}
+
+	if w = serve(http.MethodGet, "/api/unknown", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown path: expected 404, got %d", w.Code)
+	}
+}
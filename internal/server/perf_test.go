@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/config"
+	"github.com/mayvqt/Sentinel/internal/handlers"
+	"github.com/mayvqt/Sentinel/internal/loadgen"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+// perfBudgets bounds the time per operation of each benchmark through the
+// full middleware chain, with the in-memory store. They are set well
+// above typical results, so that only a real regression fails; login and
+// register are dominated by bcrypt.
+var perfBudgets = map[string]struct {
+	bench  func(*testing.B)
+	budget time.Duration
+}{
+	"register": {BenchmarkRegister, time.Second},
+	"login":    {BenchmarkLogin, time.Second},
+	"refresh":  {BenchmarkRefresh, 2 * time.Millisecond},
+	"me":       {BenchmarkMe, time.Millisecond},
+}
+
+// TestPerformanceBudgets fails if an operation has become slower than its
+// budget. PERF_BUDGET_SCALE multiplies every budget, for slow machines.
+// It is skipped with -short and under the race detector, which slows
+// everything several times over.
+func TestPerformanceBudgets(t *testing.T) {
+	if testing.Short() || raceEnabled {
+		t.Skip("performance budgets are not checked with -short or -race")
+	}
+	scale := 1.0
+	if v := os.Getenv("PERF_BUDGET_SCALE"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			t.Fatalf("invalid PERF_BUDGET_SCALE %q", v)
+		}
+		scale = f
+	}
+	for name, p := range perfBudgets {
+		t.Run(name, func(t *testing.T) {
+			r := testing.Benchmark(p.bench)
+			if r.N == 0 {
+				t.Fatal("benchmark failed")
+			}
+			got, budget := time.Duration(r.NsPerOp()), time.Duration(float64(p.budget)*scale)
+			if got > budget {
+				t.Errorf("%s takes %s per op, over its budget of %s", name, got, budget)
+			}
+		})
+	}
+}
+
+// perfServer returns the full handler chain over an in-memory store, with
+// rate limits raised out of the way, and an account to log in as.
+func perfServer(tb testing.TB) http.Handler {
+	s := store.NewMemStore()
+	a := auth.New(&config.Config{JWTSecret: "test-secret-123"})
+	h := handlers.New(s, a)
+	hash, err := auth.HashPassword("Perf-test-pw-1")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := s.CreateUser(context.Background(), &models.User{Username: "perf", Email: "perf@example.com", Password: hash, Role: "user"}); err != nil {
+		tb.Fatal(err)
+	}
+	return New(":0", s, h, nil, WithRelaxedRateLimits(1<<20)).httpServer.Handler
+}
+
+func perfRequest(tb testing.TB, handler http.Handler, method, path, token, body string, status int) []byte {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != status {
+		tb.Fatalf("%s %s: status %d, want %d: %s", method, path, w.Code, status, w.Body)
+	}
+	return w.Body.Bytes()
+}
+
+func perfLogin(tb testing.TB, handler http.Handler) (access, refresh string) {
+	body := perfRequest(tb, handler, "POST", "/api/auth/login", "", `{"username":"perf","password":"Perf-test-pw-1"}`, http.StatusOK)
+	var resp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		tb.Fatal(err)
+	}
+	return resp.AccessToken, resp.RefreshToken
+}
+
+func BenchmarkRegister(b *testing.B) {
+	handler := perfServer(b)
+	i := 0
+	for b.Loop() {
+		i++
+		body := fmt.Sprintf(`{"username":"bench%d","email":"bench%d@example.com","password":"Perf-test-pw-1"}`, i, i)
+		perfRequest(b, handler, "POST", "/api/auth/register", "", body, http.StatusCreated)
+	}
+}
+
+func BenchmarkLogin(b *testing.B) {
+	handler := perfServer(b)
+	for b.Loop() {
+		perfLogin(b, handler)
+	}
+}
+
+func BenchmarkRefresh(b *testing.B) {
+	handler := perfServer(b)
+	_, refresh := perfLogin(b, handler)
+	for b.Loop() {
+		body := perfRequest(b, handler, "POST", "/api/auth/refresh", "", `{"refresh_token":"`+refresh+`"}`, http.StatusOK)
+		var resp struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			b.Fatal(err)
+		}
+		refresh = resp.RefreshToken
+	}
+}
+
+func BenchmarkMe(b *testing.B) {
+	handler := perfServer(b)
+	access, _ := perfLogin(b, handler)
+	for b.Loop() {
+		perfRequest(b, handler, "GET", "/api/auth/profile", access, "", http.StatusOK)
+	}
+}
+
+// TestLoadgen runs the load generator against the real handler chain, to
+// catch requests it makes that the API no longer accepts.
+func TestLoadgen(t *testing.T) {
+	handler := perfServer(t)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	mix, _ := loadgen.ParseMix("refresh=1,me=3")
+	report, err := loadgen.Run(context.Background(), loadgen.Options{
+		BaseURL:  srv.URL,
+		RPS:      100,
+		Duration: 200 * time.Millisecond,
+		Mix:      mix,
+		Sessions: 2,
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	for _, s := range report.Ops {
+		if s.Errors != 0 {
+			t.Errorf("%s: %d of %d requests failed", s.Op, s.Errors, s.Requests)
+		}
+	}
+	if len(report.Ops) == 0 {
+		t.Error("no requests made")
+	}
+}
@@ -3,145 +3,619 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
 	"fmt"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"time"
 
+	"github.com/mayvqt/Sentinel/internal/adminui"
 	"github.com/mayvqt/Sentinel/internal/handlers"
 	"github.com/mayvqt/Sentinel/internal/middleware"
+	"github.com/mayvqt/Sentinel/internal/mtls"
+	"github.com/mayvqt/Sentinel/internal/rbac"
+	"github.com/mayvqt/Sentinel/internal/storage"
 	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/wellknown"
 )
 
 // Server holds the HTTP server and store.
 type Server struct {
-	httpServer  *http.Server
+	httpServer *http.Server
+	// adminServer serves the admin and metrics routes when they are bound
+	// to their own address; nil when they share httpServer.
+	adminServer *http.Server
 	store       store.Store
 	tlsCertFile string
 	tlsKeyFile  string
 	tlsEnabled  bool
+	// onReady, when set, is called once the listeners are bound.
+	onReady func()
+}
+
+// Option customizes a Server built by New.
+type Option func(*options)
+
+type options struct {
+	adminAddr string
+	// compressMinSize enables response compression when positive.
+	compressMinSize int
+	// wellKnown, when set, serves the /.well-known/ documents.
+	wellKnown *wellknown.Config
+	adminUI   bool
+	// rateLimitWarnPercent enables rate limit warnings when positive.
+	rateLimitWarnPercent int
+	rateLimitWarn        middleware.RateLimitWarnFunc
+	// rateLimits, when set, holds rate limit buckets shared by instances.
+	rateLimits middleware.RateLimitBackend
+	// rateLimitFactor multiplies every rate limit when above 1.
+	rateLimitFactor int
+	// rateLimitMaxEntries bounds the clients each rate limiter tracks;
+	// zero uses middleware.DefaultRateLimitMaxEntries.
+	rateLimitMaxEntries int
+	// problemDetails makes problem details the default error format.
+	problemDetails bool
+	// signing, when set, checks request signatures on sensitive and admin
+	// routes.
+	signing *signingOptions
+	onReady func()
+	// securityHeaders, when set, replaces the default security headers.
+	securityHeaders *middleware.SecurityHeaders
+	// chaos, when set, injects faults into every route.
+	chaos *middleware.Chaos
+}
+
+type signingOptions struct {
+	window   time.Duration
+	required bool
+}
+
+// WithAdminListener serves the admin API and metrics on addr (for example
+// "127.0.0.1:9090") instead of the public listener. The admin listener
+// also answers /health and shares the public listener's TLS settings.
+func WithAdminListener(addr string) Option {
+	return func(o *options) { o.adminAddr = addr }
+}
+
+// WithCompression compresses textual responses of at least minSize bytes
+// with Brotli or gzip for clients that accept it. Large admin listings and
+// audit pages benefit most; pprof profiles are already compressed and are
+// left alone.
+func WithCompression(minSize int) Option {
+	return func(o *options) { o.compressMinSize = minSize }
+}
+
+// WithWellKnown serves security.txt, the change-password redirect, and
+// the discovery documents described by cfg under /.well-known/.
+func WithWellKnown(cfg wellknown.Config) Option {
+	return func(o *options) { o.wellKnown = &cfg }
+}
+
+// WithAdminUI serves the embedded admin console under /admin/ alongside
+// the admin API (on the admin listener when one is configured).
+func WithAdminUI() Option {
+	return func(o *options) { o.adminUI = true }
+}
+
+// WithRateLimitWarnings warns when a client has used percent of a rate
+// limit's burst, before it starts getting 429s. Warnings are logged and
+// counted, and passed to fn when it is non-nil.
+func WithRateLimitWarnings(percent int, fn middleware.RateLimitWarnFunc) Option {
+	return func(o *options) {
+		o.rateLimitWarnPercent = percent
+		o.rateLimitWarn = fn
+	}
+}
+
+// WithSharedRateLimits keeps rate limit buckets in b rather than in each
+// instance's memory, so that several instances behind a load balancer
+// enforce one limit per client between them.
+func WithSharedRateLimits(b middleware.RateLimitBackend) Option {
+	return func(o *options) { o.rateLimits = b }
+}
+
+// WithRelaxedRateLimits multiplies the rate and burst of every rate limit
+// by factor, for local development where a frontend reloading in a loop
+// should not be throttled.
+func WithRelaxedRateLimits(factor int) Option {
+	return func(o *options) { o.rateLimitFactor = factor }
+}
+
+// WithRateLimitMaxEntries bounds the number of clients each rate limiter
+// tracks in memory. When a limiter is full, its least recently active
+// client is forgotten.
+func WithRateLimitMaxEntries(n int) Option {
+	return func(o *options) { o.rateLimitMaxEntries = n }
+}
+
+// WithProblemDetails answers errors with RFC 7807 problem details unless
+// the client's Accept header asks for application/json. Without it, only
+// clients that accept application/problem+json get problem details.
+func WithProblemDetails() Option {
+	return func(o *options) { o.problemDetails = true }
+}
+
+// WithRequestSigning checks request signatures on the routes that change
+// credentials and on admin routes, rejecting requests whose timestamp is
+// more than window from now or whose nonce was already used. With
+// required, unsigned requests to those routes are rejected too; otherwise
+// only invalid signatures are. Nonces are kept in the server's store.
+func WithRequestSigning(window time.Duration, required bool) Option {
+	return func(o *options) { o.signing = &signingOptions{window: window, required: required} }
+}
+
+// WithSecurityHeaders sets the security headers sent on every response
+// in place of middleware.DefaultSecurityHeaders.
+func WithSecurityHeaders(cfg middleware.SecurityHeaders) Option {
+	return func(o *options) { o.securityHeaders = &cfg }
+}
+
+// WithOnReady calls fn once Start has bound every listener, when the
+// server can accept connections, such as to tell a supervisor that
+// startup is complete.
+func WithOnReady(fn func()) Option {
+	return func(o *options) { o.onReady = fn }
+}
+
+// WithChaos injects the faults cfg describes into requests, for testing
+// clients against a failing server. Never use it in production.
+func WithChaos(cfg middleware.Chaos) Option {
+	return func(o *options) { o.chaos = &cfg }
 }
 
 // New constructs a Server with middleware and routes configured.
 // corsOrigins specifies allowed CORS origins; pass nil or empty slice to disable CORS.
-func New(addr string, s store.Store, h *handlers.Handlers, corsOrigins []string) *Server {
+func New(addr string, s store.Store, h *handlers.Handlers, corsOrigins []string, opts ...Option) *Server {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	mux := http.NewServeMux()
+	// Management routes are registered on adminMux, which is the public mux
+	// unless a separate admin listener is configured.
+	adminMux := mux
+	if o.adminAddr != "" {
+		adminMux = http.NewServeMux()
+	}
 
 	// Create rate limiters for different endpoints
-	authRateLimit := middleware.NewRateLimiter(time.Second*2, 5)   // 5 requests per 2 seconds for auth
-	generalRateLimit := middleware.NewRateLimiter(time.Second, 10) // 10 requests per second for general
+	f := max(o.rateLimitFactor, 1)
+	authRateLimit := middleware.NewRateLimiter("auth", time.Second*2/time.Duration(f), 5*f)      // 5 requests per 2 seconds for auth
+	generalRateLimit := middleware.NewRateLimiter("general", time.Second/time.Duration(f), 10*f) // 10 requests per second for general
+	// Username checks reveal whether accounts exist: a burst of 10, then 10 per minute
+	usernameRateLimit := middleware.NewRateLimiter("username", 6*time.Second/time.Duration(f), 10*f)
+	for _, rl := range []*middleware.RateLimiter{authRateLimit, generalRateLimit, usernameRateLimit} {
+		rl.SetMaxEntries(o.rateLimitMaxEntries)
+		if o.rateLimits != nil {
+			rl.Share(o.rateLimits)
+		}
+		if o.rateLimitWarnPercent > 0 {
+			rl.WarnAt(o.rateLimitWarnPercent, o.rateLimitWarn)
+		}
+	}
+
+	// Middleware groups. Every route is built from base, so none can miss
+	// request IDs, security headers, or logging; see chain for the order
+	// in which they nest. Responses carry tokens or user data unless a
+	// route says otherwise, so none may be stored by a browser or CDN.
+	const maxAuthBodySize = 1 << 20 // 1 MB
+	securityHeaders := middleware.DefaultSecurityHeaders()
+	if o.securityHeaders != nil {
+		securityHeaders = *o.securityHeaders
+	}
+	// Registered clients may add browser origins of their own.
+	cors := middleware.WithCORS(corsOrigins)
+	if h.Clients != nil {
+		cors = middleware.WithCORSFunc(corsOrigins, h.Clients.AllowsOrigin)
+	}
+	base := chain{}.
+		with(slotRequestID, middleware.WithRequestID()).
+		with(slotSecurityHeaders, middleware.WithSecurityHeaders(securityHeaders)).
+		with(slotCacheControl, middleware.WithCacheControl("no-store")).
+		with(slotLogging, middleware.WithLogging())
+	if o.chaos != nil {
+		base = base.with(slotChaos, middleware.WithChaos(*o.chaos))
+	}
+	// public serves unauthenticated pages and documents.
+	public := base.with(slotRateLimit, middleware.WithRateLimit(generalRateLimit))
+	// credentials accepts passwords, codes, and emailed links from browsers
+	// without a session, under the stricter auth rate limit.
+	credentials := base.
+		with(slotBodyLimit, middleware.WithMaxBodySize(maxAuthBodySize)).
+		with(slotRateLimit, middleware.WithRateLimit(authRateLimit)).
+		with(slotCORS, cors)
+	// user requires a signed-in caller and counts against their quota.
+	user := public.
+		with(slotCORS, cors).
+		with(slotAuth, middleware.WithAuth(h.Auth)).
+		with(slotQuota, middleware.WithQuota(h.Quotas))
+	if h.SessionIdleTimeout > 0 {
+		user = user.with(slotActivity, middleware.WithSessionActivity(h))
+	}
+	// sensitive changes a signed-in caller's credentials or contact details.
+	sensitive := user.
+		with(slotBodyLimit, middleware.WithMaxBodySize(maxAuthBodySize)).
+		with(slotRateLimit, middleware.WithRateLimit(authRateLimit))
+	// admin requires an authenticated admin, a token elevated to admin, or
+	// a client certificate with the admin scope.
+	admin := user.
+		with(slotBodyLimit, middleware.WithMaxBodySize(maxAuthBodySize)).
+		with(slotAuth, middleware.RequireCapability(h.Auth, h.AdminRoles, rbac.RoleAdmin))
+	if o.signing != nil {
+		signature := middleware.WithRequestSignature(h.Auth, s, o.signing.window, o.signing.required)
+		sensitive = sensitive.with(slotSignature, signature)
+		admin = admin.with(slotSignature, signature)
+	}
 
 	// Health check endpoint
-	mux.Handle("/health", applyMiddleware(
-		http.HandlerFunc(h.Health),
-		middleware.WithRequestID(),
-		middleware.WithSecurityHeaders(),
-		middleware.WithRateLimit(generalRateLimit),
-		middleware.WithLogging(),
-	))
+	health := public.thenFunc(h.Health)
+	mux.Handle("GET /health", health)
+	if adminMux != mux {
+		adminMux.Handle("GET /health", health)
+	}
+
+	if o.wellKnown != nil {
+		mux.Handle("GET /.well-known/", public.then(wellknown.Handler(*o.wellKnown)))
+	}
+
+	// Browsers report Content-Security-Policy violations to the origin of
+	// the page, which may be the admin listener.
+	const maxCSPReportSize = 64 << 10 // 64 KB
+	cspReport := public.
+		with(slotBodyLimit, middleware.WithMaxBodySize(maxCSPReportSize)).
+		thenFunc(h.CSPReport)
+	mux.Handle("POST "+handlers.CSPReportPath, cspReport)
+	if adminMux != mux {
+		adminMux.Handle("POST "+handlers.CSPReportPath, cspReport)
+	}
 
 	// Authentication endpoints with /api/auth prefix and stricter rate limiting
-	// Limit request body size to 1MB for auth endpoints
-	const maxAuthBodySize = 1 << 20 // 1 MB
+	mux.Handle("POST /api/auth/register", credentials.thenFunc(h.Register))
+	login := credentials.thenFunc(h.Login)
+	mux.Handle("POST /api/auth/login", login)
+	mux.Handle("POST /api/auth/refresh", credentials.thenFunc(h.RefreshToken))
+
+	// Each guest session creates an account, so it is rate limited like
+	// registration.
+	mux.Handle("POST /api/auth/guest", credentials.thenFunc(h.Guest))
 
-	mux.Handle("/api/auth/register", applyMiddleware(
-		http.HandlerFunc(h.Register),
-		middleware.WithRequestID(),
-		middleware.WithMaxBodySize(maxAuthBodySize),
-		middleware.WithSecurityHeaders(),
-		middleware.WithRateLimit(authRateLimit),
-		middleware.WithCORS(corsOrigins),
-		middleware.WithLogging(),
-	))
-
-	mux.Handle("/api/auth/login", applyMiddleware(
-		http.HandlerFunc(h.Login),
-		middleware.WithRequestID(),
-		middleware.WithMaxBodySize(maxAuthBodySize),
-		middleware.WithSecurityHeaders(),
-		middleware.WithRateLimit(authRateLimit),
-		middleware.WithCORS(corsOrigins),
-		middleware.WithLogging(),
-	))
-
-	mux.Handle("/api/auth/refresh", applyMiddleware(
-		http.HandlerFunc(h.RefreshToken),
-		middleware.WithRequestID(),
-		middleware.WithMaxBodySize(maxAuthBodySize),
-		middleware.WithSecurityHeaders(),
-		middleware.WithRateLimit(authRateLimit),
-		middleware.WithCORS(corsOrigins),
-		middleware.WithLogging(),
-	))
+	// Sibling apps and forward-auth proxies call this on every request
+	// from a handful of addresses, so it is not rate limited per client.
+	mux.Handle("GET /api/auth/sso/check", base.with(slotCORS, cors).thenFunc(h.SSOCheck))
+
+	// Magic links sign in without a session or password, so they get the
+	// stricter auth rate limit.
+	mux.Handle("POST /api/auth/magic-link", credentials.thenFunc(h.RequestMagicLink))
+	mux.Handle("GET /api/auth/magic-link/verify", credentials.thenFunc(h.VerifyMagicLink))
+	mux.Handle("POST /api/auth/magic-link/verify", credentials.thenFunc(h.VerifyMagicLink))
+
+	mux.Handle("GET /api/auth/username-available", public.
+		with(slotRateLimit, middleware.WithRateLimit(usernameRateLimit)).
+		with(slotCORS, cors).
+		thenFunc(h.UsernameAvailable))
+
+	// Sign-up forms check passwords as they are typed, so this takes the
+	// general rate limit rather than the auth one.
+	mux.Handle("POST /api/auth/password-strength", credentials.
+		with(slotRateLimit, middleware.WithRateLimit(generalRateLimit)).
+		thenFunc(h.PasswordStrength))
+
+	// Gateways validate tokens for many requests at once, from a handful
+	// of addresses, so this is not rate limited per client. Callers need a
+	// client certificate with the validate scope or an admin token, since
+	// the response reveals the claims of encrypted tokens.
+	mux.Handle("POST /api/auth/validate-batch", base.
+		with(slotBodyLimit, middleware.WithMaxBodySize(maxAuthBodySize)).
+		with(slotAuth, middleware.AllowScope(mtls.ScopeValidate, middleware.WithAuth(h.Auth), middleware.RequireRole("admin"))).
+		thenFunc(h.ValidateTokenBatch))
+
+	// Resource tokens are minted by signed-in callers and introspected by
+	// the servers behind the links, which like gateways call from a few
+	// addresses and so are not rate limited per client. Introspecting uses
+	// a token up, so only those servers may: callers need a client
+	// certificate with the introspect scope or an admin token, and anyone
+	// else holding a link cannot burn it.
+	mux.Handle("POST /api/auth/resource-tokens", user.
+		with(slotBodyLimit, middleware.WithMaxBodySize(maxAuthBodySize)).
+		thenFunc(h.CreateResourceToken))
+	mux.Handle("POST /api/auth/resource-tokens/introspect", base.
+		with(slotBodyLimit, middleware.WithMaxBodySize(maxAuthBodySize)).
+		with(slotAuth, middleware.AllowScope(mtls.ScopeIntrospect, middleware.WithAuth(h.Auth), middleware.RequireRole("admin"))).
+		thenFunc(h.IntrospectResourceToken))
 
 	// Protected endpoints with /api/auth prefix
-	mux.Handle("/api/auth/profile", applyMiddleware(
-		http.HandlerFunc(h.Me),
-		middleware.WithRequestID(),
-		middleware.WithSecurityHeaders(),
-		middleware.WithRateLimit(generalRateLimit),
-		middleware.WithCORS(corsOrigins),
-		middleware.WithAuth(h.Auth),
-		middleware.WithLogging(),
-	))
-
-	srv := &http.Server{
-		Addr:           addr,
-		Handler:        mux,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   15 * time.Second,
-		IdleTimeout:    60 * time.Second,
-		MaxHeaderBytes: 1 << 20, // 1 MB
+	mux.Handle("GET /api/auth/profile", user.thenFunc(h.Me))
+
+	// Avatar uploads enforce their own (larger) body limit in the handler,
+	// so they are not signed: checking a signature reads the whole body
+	mux.Handle("PUT /api/auth/profile/avatar", sensitive.without(slotBodyLimit).without(slotSignature).thenFunc(h.UploadAvatar))
+
+	mux.Handle("PATCH /api/auth/profile/metadata", user.
+		with(slotBodyLimit, middleware.WithMaxBodySize(maxAuthBodySize)).
+		thenFunc(h.UpdateProfileMetadata))
+
+	mux.Handle("GET /api/auth/recovery-codes", sensitive.thenFunc(h.RecoveryCodeStatus))
+	mux.Handle("POST /api/auth/recovery-codes", sensitive.thenFunc(h.RegenerateRecoveryCodes))
+
+	mux.Handle("POST /api/auth/email", sensitive.thenFunc(h.ChangeEmail))
+
+	mux.Handle("POST /api/auth/phone", sensitive.thenFunc(h.StartPhoneVerification))
+	mux.Handle("POST /api/auth/phone/verify", sensitive.thenFunc(h.VerifyPhone))
+	mux.Handle("DELETE /api/auth/phone", sensitive.thenFunc(h.RemovePhone))
+	mux.Handle("POST /api/auth/sms-otp", sensitive.thenFunc(h.SetSMSOTP))
+
+	// Links emailed during an email change carry their own token, so they
+	// work without a session; GET lets them be opened directly.
+	mux.Handle("GET /api/auth/email/confirm", credentials.thenFunc(h.ConfirmEmailChange))
+	mux.Handle("POST /api/auth/email/confirm", credentials.thenFunc(h.ConfirmEmailChange))
+	mux.Handle("GET /api/auth/email/revert", credentials.thenFunc(h.RevertEmailChange))
+	mux.Handle("POST /api/auth/email/revert", credentials.thenFunc(h.RevertEmailChange))
+
+	mux.Handle("GET /api/auth/login-history", user.thenFunc(h.LoginHistory))
+
+	// Admins and delegated admins request time-boxed elevated scopes
+	mux.Handle("POST /api/auth/elevations", sensitive.thenFunc(h.RequestElevation))
+	mux.Handle("GET /api/auth/elevations/{id}", user.thenFunc(h.GetElevation))
+	mux.Handle("POST /api/auth/elevations/{id}/token", sensitive.thenFunc(h.RedeemElevation))
+
+	// Preferences include security settings such as the session timeout
+	mux.Handle("GET /api/auth/preferences", user.thenFunc(h.GetPreferences))
+	mux.Handle("PUT /api/auth/preferences", sensitive.thenFunc(h.UpdatePreferences))
+
+	// Logging out is never refused for quota
+	logout := user.
+		with(slotBodyLimit, middleware.WithMaxBodySize(maxAuthBodySize)).
+		without(slotQuota).
+		thenFunc(h.Logout)
+	mux.Handle("POST /api/auth/logout", logout)
+
+	// Admin endpoints require an authenticated admin; delegated admins may
+	// use those their role grants the capability for (see rbac)
+	adminRoute := admin.thenFunc
+	// delegatedRoute also admits delegated admins and service accounts
+	// granted capability.
+	delegatedRoute := func(capability string, fn http.HandlerFunc) http.Handler {
+		return admin.with(slotAuth, middleware.RequireCapability(h.Auth, h.AdminRoles, capability)).thenFunc(fn)
+	}
+	adminMux.Handle("GET /api/admin/users/search", delegatedRoute(rbac.UserRead, h.AdminSearchUsers))
+	adminMux.Handle("GET /api/admin/users:count", delegatedRoute(rbac.UserRead, h.AdminCountUsers))
+	adminMux.Handle("GET /api/admin/users:expiring", delegatedRoute(rbac.UserRead, h.AdminListExpiringUsers))
+	adminMux.Handle("POST /api/admin/users", delegatedRoute(rbac.UserWrite, h.AdminCreateUser))
+	adminMux.Handle("GET /api/admin/users/{id}", delegatedRoute(rbac.UserRead, h.AdminGetUser))
+	adminMux.Handle("PATCH /api/admin/users/{id}/metadata", delegatedRoute(rbac.UserWrite, h.AdminUpdateUserMetadata))
+	adminMux.Handle("PUT /api/admin/users/{id}/expiry", delegatedRoute(rbac.UserWrite, h.AdminSetUserExpiry))
+	adminMux.Handle("GET /api/admin/users/{id}/status-changes", delegatedRoute(rbac.UserRead, h.AdminListStatusChanges))
+	adminMux.Handle("POST /api/admin/users/{id}/status-changes", delegatedRoute(rbac.UserWrite, h.AdminScheduleStatusChange))
+	adminMux.Handle("DELETE /api/admin/users/{id}/status-changes/{change_id}", delegatedRoute(rbac.UserWrite, h.AdminCancelStatusChange))
+	adminMux.Handle("GET /api/admin/users/{id}/refresh-tokens", delegatedRoute(rbac.UserRead, h.AdminListRefreshTokens))
+	adminMux.Handle("GET /api/admin/users/{id}/timeline", delegatedRoute(rbac.AuditRead, h.AdminUserTimeline))
+	adminMux.Handle("POST /api/admin/users/{id}/merge", adminRoute(h.AdminMergeUser))
+	adminMux.Handle("POST /api/admin/users:batchDisable", delegatedRoute(rbac.UserWrite, h.AdminBatchDisable))
+	adminMux.Handle("POST /api/admin/users:batchDelete", delegatedRoute(rbac.UserWrite, h.AdminBatchDelete))
+	adminMux.Handle("POST /api/admin/users:batchAssignRole", adminRoute(h.AdminBatchAssignRole))
+	adminMux.Handle("POST /api/admin/guests:purge", delegatedRoute(rbac.UserWrite, h.AdminPurgeGuests))
+	adminMux.Handle("POST /api/admin/backups", adminRoute(h.AdminCreateBackup))
+	adminMux.Handle("GET /api/admin/audit", delegatedRoute(rbac.AuditRead, h.AdminListAudit))
+	adminMux.Handle("GET /api/admin/audit/retention", delegatedRoute(rbac.AuditRead, h.AdminAuditRetention))
+	adminMux.Handle("POST /api/admin/tokens:revoke", delegatedRoute(rbac.TokenRevoke, h.AdminRevokeToken))
+	adminMux.Handle("GET /api/admin/elevations", adminRoute(h.AdminListElevations))
+	adminMux.Handle("POST /api/admin/elevations/{id}/approve", adminRoute(h.AdminApproveElevation))
+	adminMux.Handle("POST /api/admin/elevations/{id}/deny", adminRoute(h.AdminDenyElevation))
+	adminMux.Handle("GET /api/admin/stats", adminRoute(h.AdminStats))
+	adminMux.Handle("GET /api/admin/config", adminRoute(h.AdminConfig))
+	adminMux.Handle("GET /api/admin/canaries", adminRoute(h.AdminListCanaries))
+	adminMux.Handle("POST /api/admin/canaries", adminRoute(h.AdminCreateCanary))
+	adminMux.Handle("DELETE /api/admin/canaries/{id}", adminRoute(h.AdminDeleteCanary))
+	adminMux.Handle("GET /api/admin/webhooks", adminRoute(h.AdminListWebhooks))
+	adminMux.Handle("POST /api/admin/webhooks", adminRoute(h.AdminCreateWebhook))
+	adminMux.Handle("GET /api/admin/webhooks/{id}", adminRoute(h.AdminGetWebhook))
+	adminMux.Handle("PATCH /api/admin/webhooks/{id}", adminRoute(h.AdminUpdateWebhook))
+	adminMux.Handle("DELETE /api/admin/webhooks/{id}", adminRoute(h.AdminDeleteWebhook))
+	adminMux.Handle("POST /api/admin/webhooks/{id}/test", adminRoute(h.AdminTestWebhook))
+	adminMux.Handle("GET /api/admin/webhooks/{id}/deliveries", adminRoute(h.AdminListWebhookDeliveries))
+	adminMux.Handle("POST /api/admin/webhooks/{id}/deliveries/{delivery}/redeliver", adminRoute(h.AdminRedeliverWebhook))
+	adminMux.Handle("POST /api/admin/webhooks/{id}/replay", adminRoute(h.AdminReplayWebhook))
+	adminMux.Handle("GET /api/admin/quotas", adminRoute(h.AdminListQuotas))
+	adminMux.Handle("GET /api/admin/quotas/{subject}", adminRoute(h.AdminGetQuota))
+	adminMux.Handle("PUT /api/admin/quotas/{subject}", adminRoute(h.AdminSetQuota))
+	adminMux.Handle("DELETE /api/admin/quotas/{subject}", adminRoute(h.AdminDeleteQuota))
+	adminMux.Handle("GET /api/admin/clients", adminRoute(h.AdminListClients))
+	adminMux.Handle("GET /api/admin/clients/{client_id}", adminRoute(h.AdminGetClient))
+	adminMux.Handle("PUT /api/admin/clients/{client_id}", adminRoute(h.AdminSetClient))
+	adminMux.Handle("DELETE /api/admin/clients/{client_id}", adminRoute(h.AdminDeleteClient))
+	adminMux.Handle("GET /api/admin/flags", adminRoute(h.AdminListFlags))
+	adminMux.Handle("GET /api/admin/flags/{name}", adminRoute(h.AdminGetFlag))
+	adminMux.Handle("PUT /api/admin/flags/{name}", adminRoute(h.AdminSetFlag))
+	adminMux.Handle("DELETE /api/admin/flags/{name}", adminRoute(h.AdminDeleteFlag))
+
+	// Admin console; its API calls are authorized like any other admin client
+	if o.adminUI {
+		adminMux.Handle("GET "+adminui.Prefix, public.then(adminui.Handler()))
+		adminMux.Handle("GET /admin", http.RedirectHandler(adminui.Prefix, http.StatusMovedPermanently))
+		if adminMux != mux {
+			// The console signs in from its own origin
+			adminMux.Handle("POST /api/auth/login", login)
+			adminMux.Handle("POST /api/auth/logout", logout)
+		}
+	}
+
+	// Runtime diagnostics: pprof, expvar, and on-demand dumps
+	if h.DiagnosticsEnabled {
+		adminMux.Handle("GET /debug/pprof/", adminRoute(pprof.Index))
+		adminMux.Handle("GET /debug/pprof/cmdline", adminRoute(pprof.Cmdline))
+		adminMux.Handle("GET /debug/pprof/profile", adminRoute(h.DebugProfile))
+		adminMux.Handle("GET /debug/pprof/symbol", adminRoute(pprof.Symbol))
+		adminMux.Handle("POST /debug/pprof/symbol", adminRoute(pprof.Symbol))
+		adminMux.Handle("GET /debug/pprof/trace", adminRoute(h.DebugTrace))
+		adminMux.Handle("GET /debug/vars", adminRoute(expvar.Handler().ServeHTTP))
+		adminMux.Handle("POST /api/admin/diagnostics/dumps", adminRoute(h.AdminCreateDump))
+	}
+
+	// Prometheus scrape endpoint; the handler enforces the optional bearer token
+	if h.MetricsEnabled {
+		// Scrapes are not logged, to keep them from drowning out requests
+		adminMux.Handle("GET /metrics", public.without(slotLogging).thenFunc(h.Metrics))
+	}
+
+	// Serve locally stored media (avatars) when using the local backend
+	if local, ok := h.Media.(*storage.Local); ok && local.Prefix() != "" {
+		mux.Handle("GET "+local.Prefix(), public.then(local))
 	}
 
-	return &Server{
-		httpServer:  srv,
+	server := &Server{
+		httpServer:  newHTTPServer(addr, h, withMethods(mux, public), o),
 		store:       s,
 		tlsCertFile: "",
 		tlsKeyFile:  "",
 		tlsEnabled:  false,
+		onReady:     o.onReady,
+	}
+	if adminMux != mux {
+		server.adminServer = newHTTPServer(o.adminAddr, h, withMethods(adminMux, public), o)
+	}
+	return server
+}
+
+// writeTimeout bounds how long a request may take to answer. Callers may
+// ask for less with a request deadline header.
+const writeTimeout = 15 * time.Second
+
+// newHTTPServer wraps routes with the server-wide middleware and timeouts.
+func newHTTPServer(addr string, h *handlers.Handlers, routes http.Handler, o options) *http.Server {
+	handler := middleware.WithErrorFormat(o.problemDetails)(routes)
+	// Inside WithClientIdentity, which tells it who the caller is.
+	handler = middleware.WithRequestDeadline(writeTimeout)(handler)
+	handler = middleware.WithSecretScrubbing()(middleware.WithClientIdentity(h.ServiceAccounts)(handler))
+	if o.compressMinSize > 0 {
+		// Outermost, so secret scrubbing still sees plain bodies.
+		handler = middleware.WithCompression(o.compressMinSize)(handler)
+	}
+	return &http.Server{
+		Addr:           addr,
+		Handler:        handler,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   writeTimeout,
+		IdleTimeout:    60 * time.Second,
+		MaxHeaderBytes: 1 << 20, // 1 MB
 	}
 }
 
 // NewWithTLS constructs a Server with TLS/HTTPS support enabled.
-func NewWithTLS(addr string, s store.Store, h *handlers.Handlers, corsOrigins []string, certFile, keyFile string) *Server {
-	server := New(addr, s, h, corsOrigins)
+func NewWithTLS(addr string, s store.Store, h *handlers.Handlers, corsOrigins []string, certFile, keyFile string, opts ...Option) *Server {
+	server := New(addr, s, h, corsOrigins, opts...)
 	server.tlsCertFile = certFile
 	server.tlsKeyFile = keyFile
 	server.tlsEnabled = true
 	return server
 }
 
-// applyMiddleware composes middleware into a single http.Handler.
-func applyMiddleware(handler http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
-	for i := len(middlewares) - 1; i >= 0; i-- {
-		handler = middlewares[i](handler)
+// SetClientTLS applies client certificate verification settings (see
+// mtls.ServerConfig) to a TLS server. It must be called before Start.
+func (s *Server) SetClientTLS(cfg *tls.Config) {
+	s.httpServer.TLSConfig = cfg
+	if s.adminServer != nil {
+		s.adminServer.TLSConfig = cfg.Clone()
 	}
-	return handler
 }
 
-// Start runs the HTTP server until ctx is canceled.
+// Start runs the HTTP server (and the admin listener, if configured) until
+// ctx is canceled or either fails.
 func (s *Server) Start(ctx context.Context) error {
 	go func() {
 		<-ctx.Done()
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		_ = s.httpServer.Shutdown(shutdownCtx)
+		_ = s.Shutdown(shutdownCtx)
+	}()
+
+	// Both listeners are bound before either serves, so that onReady is
+	// only called once every address accepts connections.
+	publicLn, err := s.bind(s.httpServer)
+	if err != nil {
+		return err
+	}
+	if s.adminServer == nil {
+		s.ready()
+		return s.servePublic(publicLn)
+	}
+	adminLn, err := s.bind(s.adminServer)
+	if err != nil {
+		publicLn.Close()
+		return fmt.Errorf("admin listener: %w", err)
+	}
+	s.ready()
+
+	adminErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("🔧 Admin listener on %s://%s\n", s.scheme(), s.adminServer.Addr)
+		adminErr <- s.serve(s.adminServer, adminLn)
 	}()
+	publicErr := make(chan error, 1)
+	go func() { publicErr <- s.servePublic(publicLn) }()
+
+	// Whichever listener stops first brings the other down with it.
+	select {
+	case err = <-adminErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			err = fmt.Errorf("admin listener: %w", err)
+		}
+	case err = <-publicErr:
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = s.Shutdown(shutdownCtx)
+	return err
+}
 
-	protocol := "http"
+func (s *Server) ready() {
+	if s.onReady != nil {
+		s.onReady()
+	}
+}
+
+func (s *Server) servePublic(ln net.Listener) error {
+	if s.tlsEnabled {
+		fmt.Printf("� Sentinel server listening on %s://%s (TLS enabled)\n", s.scheme(), s.httpServer.Addr)
+	} else {
+		fmt.Printf("⚠️  Sentinel server listening on %s://%s (TLS disabled - not recommended for production)\n", s.scheme(), s.httpServer.Addr)
+	}
+	return s.serve(s.httpServer, ln)
+}
+
+// bind listens on srv's address, defaulting like ListenAndServe does.
+func (s *Server) bind(srv *http.Server) (net.Listener, error) {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":" + s.scheme()
+	}
+	return net.Listen("tcp", addr)
+}
+
+func (s *Server) serve(srv *http.Server, ln net.Listener) error {
 	if s.tlsEnabled {
-		protocol = "https"
-		fmt.Printf("� Sentinel server listening on %s://%s (TLS enabled)\n", protocol, s.httpServer.Addr)
-		return s.httpServer.ListenAndServeTLS(s.tlsCertFile, s.tlsKeyFile)
+		return srv.ServeTLS(ln, s.tlsCertFile, s.tlsKeyFile)
 	}
+	return srv.Serve(ln)
+}
 
-	fmt.Printf("⚠️  Sentinel server listening on %s://%s (TLS disabled - not recommended for production)\n", protocol, s.httpServer.Addr)
-	return s.httpServer.ListenAndServe()
+func (s *Server) scheme() string {
+	if s.tlsEnabled {
+		return "https"
+	}
+	return "http"
 }
 
-// Shutdown gracefully stops the HTTP server.
+// Shutdown gracefully stops the HTTP server and the admin listener.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	var adminErr error
+	if s.adminServer != nil {
+		adminErr = s.adminServer.Shutdown(ctx)
+	}
+	return errors.Join(s.httpServer.Shutdown(ctx), adminErr)
 }
 
 // Close releases server resources (store close).
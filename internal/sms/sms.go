@@ -0,0 +1,233 @@
+// Package sms delivers text messages, such as one-time verification codes,
+// through a pluggable provider: Twilio, Vonage, or a generic webhook.
+package sms
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/httpclient"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	webhooksig "github.com/mayvqt/Sentinel/pkg/webhook"
+)
+
+// Provider names accepted by New.
+const (
+	ProviderTwilio  = "twilio"
+	ProviderVonage  = "vonage"
+	ProviderWebhook = "webhook"
+)
+
+// Provider API endpoints; tests and regional deployments may override them
+// through Config.BaseURL.
+const (
+	TwilioBaseURL = "https://api.twilio.com"
+	VonageBaseURL = "https://rest.nexmo.com"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of a webhook request body
+// when a webhook secret is configured. Such requests also carry the
+// timestamped signature verified by pkg/webhook, which should be preferred
+// as it also rejects replayed requests.
+const SignatureHeader = "X-Sentinel-Signature"
+
+// SendTimeout bounds a single delivery attempt.
+const SendTimeout = 10 * time.Second
+
+var messagesSent = metrics.NewCounterVec(
+	"sentinel_sms_messages_total",
+	"Text messages handed to the SMS provider, by provider and result.",
+	"provider", "result",
+)
+
+// httpClient is created on first use so it picks up the httpclient
+// defaults configured at startup. Sends are not retried: a retry after a
+// lost response could deliver the same code twice.
+var httpClient = sync.OnceValue(func() *http.Client {
+	return httpclient.New("sms", httpclient.Options{Timeout: SendTimeout})
+})
+
+// Message is a text message to a single E.164 phone number.
+type Message struct {
+	To   string
+	Body string
+}
+
+// Sender delivers text messages.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Config selects and configures a provider.
+type Config struct {
+	Provider string
+	// From is the sender number or alphanumeric sender ID (Twilio, Vonage).
+	From string
+	// AccountID and Secret are the Twilio account SID and auth token, or
+	// the Vonage API key and secret. For the webhook provider, Secret signs
+	// request bodies.
+	AccountID string
+	Secret    string
+	// URL is the webhook endpoint.
+	URL string
+	// BaseURL overrides the provider's API endpoint.
+	BaseURL string
+}
+
+// New returns the Sender described by cfg.
+func New(cfg Config) (Sender, error) {
+	var s Sender
+	switch strings.ToLower(cfg.Provider) {
+	case ProviderTwilio:
+		if cfg.AccountID == "" || cfg.Secret == "" || cfg.From == "" {
+			return nil, errors.New("twilio requires an account SID, auth token, and from number")
+		}
+		s = &twilio{cfg: withBaseURL(cfg, TwilioBaseURL)}
+	case ProviderVonage:
+		if cfg.AccountID == "" || cfg.Secret == "" || cfg.From == "" {
+			return nil, errors.New("vonage requires an API key, API secret, and from number")
+		}
+		s = &vonage{cfg: withBaseURL(cfg, VonageBaseURL)}
+	case ProviderWebhook:
+		if _, err := url.ParseRequestURI(cfg.URL); err != nil {
+			return nil, fmt.Errorf("invalid sms webhook URL: %w", err)
+		}
+		s = &webhook{cfg: cfg}
+	default:
+		return nil, fmt.Errorf("unknown sms provider %q", cfg.Provider)
+	}
+	return instrumented{provider: strings.ToLower(cfg.Provider), next: s}, nil
+}
+
+func withBaseURL(cfg Config, def string) Config {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = def
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	return cfg
+}
+
+// instrumented counts deliveries by provider and result.
+type instrumented struct {
+	provider string
+	next     Sender
+}
+
+func (i instrumented) Send(ctx context.Context, m Message) error {
+	err := i.next.Send(ctx, m)
+	result := "sent"
+	if err != nil {
+		result = "error"
+	}
+	messagesSent.WithLabelValues(i.provider, result).Inc()
+	return err
+}
+
+// twilio sends through the Twilio Programmable Messaging API.
+type twilio struct{ cfg Config }
+
+func (t *twilio) Send(ctx context.Context, m Message) error {
+	form := url.Values{"To": {m.To}, "From": {t.cfg.From}, "Body": {m.Body}}
+	endpoint := t.cfg.BaseURL + "/2010-04-01/Accounts/" + url.PathEscape(t.cfg.AccountID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.cfg.AccountID, t.cfg.Secret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = do(req)
+	return err
+}
+
+// vonage sends through the Vonage (Nexmo) SMS API, which reports failures
+// per message in a 200 response.
+type vonage struct{ cfg Config }
+
+func (v *vonage) Send(ctx context.Context, m Message) error {
+	payload, _ := json.Marshal(map[string]string{
+		"api_key":    v.cfg.AccountID,
+		"api_secret": v.cfg.Secret,
+		"from":       v.cfg.From,
+		"to":         strings.TrimPrefix(m.To, "+"),
+		"text":       m.Body,
+		"type":       "unicode",
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.cfg.BaseURL+"/sms/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	body, err := do(req)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Messages []struct {
+			Status    string `json:"status"`
+			ErrorText string `json:"error-text"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("vonage: invalid response: %w", err)
+	}
+	for _, msg := range resp.Messages {
+		if msg.Status != "0" {
+			return fmt.Errorf("vonage: status %s: %s", msg.Status, msg.ErrorText)
+		}
+	}
+	return nil
+}
+
+// webhook posts {"to": ..., "body": ...} as JSON to an operator endpoint
+// that forwards it to any other gateway.
+type webhook struct{ cfg Config }
+
+func (h *webhook) Send(ctx context.Context, m Message) error {
+	payload, _ := json.Marshal(map[string]string{"to": m.To, "body": m.Body})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(h.cfg.Secret, payload))
+		req.Header.Set(webhooksig.SignatureHeader, webhooksig.Sign([]string{h.cfg.Secret}, time.Now(), payload))
+	}
+	_, err = do(req)
+	return err
+}
+
+// Sign returns the hex HMAC-SHA256 of body under secret, as sent in
+// SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// do sends req and returns the response body, treating any non-2xx status
+// as an error.
+func do(req *http.Request) ([]byte, error) {
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return body, nil
+}
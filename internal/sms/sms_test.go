@@ -0,0 +1,112 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	webhooksig "github.com/mayvqt/Sentinel/pkg/webhook"
+)
+
+func TestTwilio(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" || user != "AC123" || pass != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		if r.Form.Get("To") != "+14155550100" || r.Form.Get("From") != "+14155550199" || r.Form.Get("Body") != "code 123456" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{Provider: "twilio", AccountID: "AC123", Secret: "token", From: "+14155550199", BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(context.Background(), Message{To: "+14155550100", Body: "code 123456"}); err != nil {
+		t.Errorf("Send: %v", err)
+	}
+
+	s, _ = New(Config{Provider: "twilio", AccountID: "AC123", Secret: "wrong", From: "+14155550199", BaseURL: srv.URL})
+	if err := s.Send(context.Background(), Message{To: "+14155550100", Body: "code 123456"}); err == nil {
+		t.Error("expected a rejected request to fail")
+	}
+}
+
+func TestVonage(t *testing.T) {
+	status := "0"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/sms/json" || req["api_key"] != "key" || req["to"] != "4915112345678" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `{"message-count":"1","messages":[{"status":"`+status+`","error-text":"Throttled"}]}`)
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{Provider: "vonage", AccountID: "key", Secret: "secret", From: "Sentinel", BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(context.Background(), Message{To: "+4915112345678", Body: "hi"}); err != nil {
+		t.Errorf("Send: %v", err)
+	}
+	status = "1"
+	if err := s.Send(context.Background(), Message{To: "+4915112345678", Body: "hi"}); err == nil {
+		t.Error("expected a per-message failure to be reported")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got map[string]string
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		if r.Header.Get(SignatureHeader) != Sign("s3cret", body) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if err := webhooksig.Verify(r.Header.Get(webhooksig.SignatureHeader), body, []string{"s3cret"}, webhooksig.DefaultTolerance, time.Now()); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{Provider: "webhook", URL: srv.URL, Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(context.Background(), Message{To: "+14155550100", Body: "hi"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got["to"] != "+14155550100" || got["body"] != "hi" || signature == "" {
+		t.Errorf("unexpected delivery %v (signature %q)", got, signature)
+	}
+}
+
+func TestNewRejectsIncompleteConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{Provider: "twilio", AccountID: "AC123"},
+		{Provider: "vonage", Secret: "x", From: "y"},
+		{Provider: "webhook", URL: "not a url"},
+		{Provider: "carrier-pigeon"},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
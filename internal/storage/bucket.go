@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Kinds of objects written through a Bucket. The kind is the first key
+// segment after the bucket's prefix, so that lifecycle rules can expire or
+// archive each kind on its own schedule.
+const (
+	KindBackups   = "backups"
+	KindAudit     = "audit"
+	KindExports   = "exports"
+	KindAnalytics = "analytics"
+)
+
+// Bucket writes operational objects, such as backups and archives, under
+// a key prefix of a Backend. Keys are named
+// <prefix>/<kind>/<yyyy>/<mm>/<dd>/<name>, dated by when the object was
+// made (or, for archives, the day they cover), so that listing or expiring
+// a kind or a day is a prefix match.
+type Bucket struct {
+	Backend Backend
+	Prefix  string
+}
+
+// OpenBucket returns the bucket rawURL names: s3://bucket/prefix for an
+// S3-compatible bucket, using opts for the endpoint, credentials, and
+// encryption, or file:///dir for a local directory. Google Cloud Storage
+// is reached through its S3-compatible endpoint with HMAC keys.
+func OpenBucket(rawURL string, opts S3Options) (*Bucket, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid object storage URL %q", rawURL)
+	}
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("object storage URL %q has no bucket", rawURL)
+		}
+		opts.Bucket = u.Host
+		opts.PublicURL = ""
+		backend, err := NewS3(opts)
+		if err != nil {
+			return nil, err
+		}
+		return &Bucket{Backend: backend, Prefix: strings.Trim(u.Path, "/")}, nil
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("object storage URL %q has no directory", rawURL)
+		}
+		backend, err := NewLocal(u.Path, "")
+		if err != nil {
+			return nil, err
+		}
+		return &Bucket{Backend: backend}, nil
+	default:
+		return nil, fmt.Errorf("object storage URL %q must use s3 or file", rawURL)
+	}
+}
+
+// Key returns the key of the object of kind named name made at t.
+func (b *Bucket) Key(kind string, t time.Time, name string) string {
+	return path.Join(b.Prefix, kind, t.UTC().Format("2006/01/02"), name)
+}
+
+// Put writes size bytes from r as the object of kind named name made at t
+// and returns its key.
+func (b *Bucket) Put(ctx context.Context, kind string, t time.Time, name string, r io.Reader, size int64, contentType string) (string, error) {
+	key := b.Key(kind, t, name)
+	if err := b.Backend.Put(ctx, key, r, size, contentType); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// PutFile uploads the file at name as the object of kind made at t, named
+// after the file, and returns its key.
+func (b *Bucket) PutFile(ctx context.Context, kind string, t time.Time, name, contentType string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return b.Put(ctx, kind, t, filepath.Base(name), f, info.Size(), contentType)
+}
+
+// String returns a description of the bucket for logs.
+func (b *Bucket) String() string {
+	switch be := b.Backend.(type) {
+	case *S3:
+		return "s3://" + path.Join(be.opts.Bucket, b.Prefix)
+	case *Local:
+		return "file://" + be.dir
+	}
+	return b.Prefix
+}
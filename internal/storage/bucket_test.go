@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	ctx := context.Background()
+	made := time.Date(2025, 2, 3, 23, 0, 0, 0, time.FixedZone("UTC-5", -5*60*60))
+
+	dir := t.TempDir()
+	local, err := OpenBucket("file://"+dir, S3Options{})
+	if err != nil {
+		t.Fatalf("OpenBucket: %v", err)
+	}
+	src := filepath.Join(t.TempDir(), "sentinel.db")
+	if err := os.WriteFile(src, []byte("snapshot"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := local.PutFile(ctx, KindBackups, made, src, "application/vnd.sqlite3")
+	if err != nil || key != "backups/2025/02/04/sentinel.db" {
+		t.Fatalf("PutFile = %q, %v", key, err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, filepath.FromSlash(key))); string(got) != "snapshot" {
+		t.Errorf("unexpected object contents %q", got)
+	}
+
+	var gotPath, gotSSE, gotKey, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotSSE = r.Header.Get("X-Amz-Server-Side-Encryption")
+		gotKey = r.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id")
+		gotAuth = r.Header.Get("Authorization")
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+	opts := S3Options{
+		Endpoint: srv.URL, AccessKeyID: "id", SecretAccessKey: "secret", UsePathStyle: true,
+		ServerSideEncryption: "aws:kms", SSEKMSKeyID: "alias/sentinel", HTTPClient: srv.Client(),
+	}
+	remote, err := OpenBucket("s3://ops/sentinel/prod/", opts)
+	if err != nil {
+		t.Fatalf("OpenBucket: %v", err)
+	}
+	if remote.String() != "s3://ops/sentinel/prod" {
+		t.Errorf("String = %q", remote.String())
+	}
+	key, err = remote.Put(ctx, KindAudit, made, "audit.jsonl", strings.NewReader("{}"), 2, "application/x-ndjson")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if key != "sentinel/prod/audit/2025/02/04/audit.jsonl" || gotPath != "/ops/"+key {
+		t.Errorf("unexpected key %q or request path %q", key, gotPath)
+	}
+	if gotSSE != "aws:kms" || gotKey != "alias/sentinel" ||
+		!strings.Contains(gotAuth, "x-amz-server-side-encryption;x-amz-server-side-encryption-aws-kms-key-id") {
+		t.Errorf("encryption headers not sent or not signed: %q %q %q", gotSSE, gotKey, gotAuth)
+	}
+
+	for _, raw := range []string{"s3:///prefix", "file://", "gs://bucket"} {
+		if _, err := OpenBucket(raw, opts); err == nil {
+			t.Errorf("OpenBucket(%q): expected an error", raw)
+		}
+	}
+	opts.ServerSideEncryption = "rot13"
+	if _, err := OpenBucket("s3://ops", opts); err == nil {
+		t.Error("expected an unknown server-side encryption to be rejected")
+	}
+}
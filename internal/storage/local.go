@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores objects on the local filesystem and can serve them over HTTP.
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocal returns a Local backend rooted at dir. baseURL is the URL prefix
+// under which objects are served (e.g. "/media"). The directory is created
+// lazily on first write.
+func NewLocal(dir, baseURL string) (*Local, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("local storage directory is required")
+	}
+	return &Local{dir: dir, baseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+// Put writes the object atomically via a temporary file and rename.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	path := filepath.Join(l.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store object: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the object at key, ignoring missing files.
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(filepath.Join(l.dir, filepath.FromSlash(key)))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+// URL returns baseURL/key.
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + key
+}
+
+// KeyFromURL strips baseURL from url.
+func (l *Local) KeyFromURL(url string) (string, bool) {
+	prefix := l.baseURL + "/"
+	if !strings.HasPrefix(url, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(url, prefix), true
+}
+
+// Prefix returns the URL path prefix objects are served under, or "" when
+// baseURL is an absolute URL served by something other than Sentinel.
+func (l *Local) Prefix() string {
+	if strings.HasPrefix(l.baseURL, "/") {
+		return l.baseURL + "/"
+	}
+	return ""
+}
+
+// ServeHTTP serves stored objects read-only. Directory listings are disabled.
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, l.Prefix())
+	if !validKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+	path := filepath.Join(l.dir, filepath.FromSlash(key))
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/httpclient"
+)
+
+// S3Options configures an S3-compatible backend (AWS S3, MinIO, R2, ...).
+type S3Options struct {
+	Endpoint        string // e.g. "https://s3.us-east-1.amazonaws.com" or "http://minio:9000"
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	UsePathStyle    bool   // address the bucket as endpoint/bucket rather than bucket.endpoint
+	PublicURL       string // optional CDN/public base URL; defaults to the bucket URL
+	// ServerSideEncryption asks the bucket to encrypt objects at rest:
+	// "AES256" for bucket-managed keys or "aws:kms" for SSEKMSKeyID (or
+	// the bucket's default KMS key when empty).
+	ServerSideEncryption string
+	SSEKMSKeyID          string
+	HTTPClient           *http.Client
+}
+
+// maxSignedPayload is the largest object whose body is hashed into the
+// request signature. Larger objects, such as database backups, are
+// streamed with an unsigned payload rather than held in memory; TLS
+// protects their integrity in transit.
+const maxSignedPayload = 8 << 20
+
+// unsignedPayload is the SigV4 payload hash of streamed bodies.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3 stores objects in an S3-compatible bucket using SigV4-signed requests.
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3 validates opts and returns an S3 backend.
+func NewS3(opts S3Options) (*S3, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket are required")
+	}
+	if opts.AccessKeyID == "" || opts.SecretAccessKey == "" {
+		return nil, errors.New("s3 credentials are required")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	switch opts.ServerSideEncryption {
+	case "", "AES256", "aws:kms":
+	default:
+		return nil, fmt.Errorf("s3 server-side encryption must be AES256 or aws:kms, got %q", opts.ServerSideEncryption)
+	}
+	if opts.SSEKMSKeyID != "" && opts.ServerSideEncryption != "aws:kms" {
+		return nil, errors.New("an s3 KMS key ID requires aws:kms server-side encryption")
+	}
+	u, err := url.Parse(strings.TrimRight(opts.Endpoint, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", opts.Endpoint)
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = httpclient.New("s3", httpclient.Options{Timeout: 30 * time.Second})
+	}
+	// A relative public URL makes no sense for a remote bucket.
+	if strings.HasPrefix(opts.PublicURL, "/") {
+		opts.PublicURL = ""
+	}
+	return &S3{opts: opts, endpoint: u, client: client}, nil
+}
+
+// objectURL returns the request URL for key.
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	escaped := (&url.URL{Path: key}).EscapedPath()
+	if s.opts.UsePathStyle {
+		u.Path = "/" + s.opts.Bucket + "/" + key
+		u.RawPath = "/" + s.opts.Bucket + "/" + escaped
+	} else {
+		u.Host = s.opts.Bucket + "." + u.Host
+		u.Path = "/" + key
+		u.RawPath = "/" + escaped
+	}
+	return &u
+}
+
+// Put uploads the object with a single PUT request.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	var body io.Reader = io.LimitReader(r, size)
+	payloadHash := unsignedPayload
+	if size <= maxSignedPayload {
+		b, err := io.ReadAll(io.LimitReader(r, size+1))
+		if err != nil {
+			return fmt.Errorf("failed to read object: %w", err)
+		}
+		if int64(len(b)) != size {
+			return fmt.Errorf("object size mismatch: expected %d bytes, got %d", size, len(b))
+		}
+		body, payloadHash = bytes.NewReader(b), sha256Hex(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	if s.opts.ServerSideEncryption != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", s.opts.ServerSideEncryption)
+	}
+	if s.opts.SSEKMSKeyID != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.opts.SSEKMSKeyID)
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+	return s.do(req)
+}
+
+// Delete removes the object; S3 reports success for missing keys.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, sha256Hex(nil), time.Now().UTC())
+	return s.do(req)
+}
+
+// URL returns the public URL for key.
+func (s *S3) URL(key string) string {
+	if s.opts.PublicURL != "" {
+		return strings.TrimRight(s.opts.PublicURL, "/") + "/" + key
+	}
+	return s.objectURL(key).String()
+}
+
+// KeyFromURL strips the public URL prefix from url.
+func (s *S3) KeyFromURL(u string) (string, bool) {
+	prefix := strings.TrimSuffix(s.URL("x"), "x")
+	if !strings.HasPrefix(u, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(u, prefix), true
+}
+
+func (s *S3) do(req *http.Request) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3 request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("s3 %s %s: status %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sign adds AWS Signature Version 4 headers to req, whose body hashes to
+// payloadHash. The host, the content type, and every x-amz-* header are
+// signed.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host"}
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			signedHeaders = append(signedHeaders, name)
+		}
+	}
+	slices.Sort(signedHeaders)
+	var canonicalHeaders strings.Builder
+	for _, h := range signedHeaders {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.opts.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.opts.SecretAccessKey), date)
+	key = hmacSHA256(key, s.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.opts.AccessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
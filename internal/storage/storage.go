@@ -0,0 +1,69 @@
+// Package storage provides pluggable object storage backends for
+// user-uploaded media such as profile avatars, and buckets for operational
+// objects such as backups and archives.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mayvqt/Sentinel/internal/config"
+)
+
+// ErrInvalidKey is returned when an object key is empty or attempts to
+// escape the storage root.
+var ErrInvalidKey = errors.New("invalid object key")
+
+// Backend stores and removes objects and resolves their public URLs.
+type Backend interface {
+	// Put writes size bytes from r under key with the given content type.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Delete removes the object stored under key. Missing objects are not an error.
+	Delete(ctx context.Context, key string) error
+
+	// URL returns the public URL clients can use to fetch key.
+	URL(key string) string
+
+	// KeyFromURL reverses URL, returning the key and true when url was
+	// produced by this backend.
+	KeyFromURL(url string) (string, bool)
+}
+
+// New returns the Backend selected by cfg.StorageBackend.
+func New(cfg *config.Config) (Backend, error) {
+	switch strings.ToLower(cfg.StorageBackend) {
+	case "", "local":
+		return NewLocal(cfg.StorageLocalDir, cfg.StoragePublicURL)
+	case "s3":
+		return NewS3(S3Options{
+			Endpoint:             cfg.S3Endpoint,
+			Region:               cfg.S3Region,
+			Bucket:               cfg.S3Bucket,
+			AccessKeyID:          cfg.S3AccessKeyID,
+			SecretAccessKey:      cfg.S3SecretKey,
+			UsePathStyle:         cfg.S3UsePathStyle,
+			PublicURL:            cfg.StoragePublicURL,
+			ServerSideEncryption: cfg.S3ServerSideEncryption,
+			SSEKMSKeyID:          cfg.S3SSEKMSKeyID,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+}
+
+// validKey rejects empty keys and keys containing path traversal segments.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	sqlite "modernc.org/sqlite"
+)
+
+// Backupper is implemented by stores that support consistent online
+// snapshots of their data.
+type Backupper interface {
+	// Backup writes a consistent snapshot of the live database to dstPath,
+	// which must not already exist.
+	Backup(ctx context.Context, dstPath string) error
+
+	// Restore replaces the database contents with the snapshot at srcPath.
+	// The service should be stopped while restoring.
+	Restore(ctx context.Context, srcPath string) error
+}
+
+// ErrBackupUnsupported is returned when the configured store cannot be backed up.
+var ErrBackupUnsupported = errors.New("store does not support backups")
+
+// sqliteBackuper is implemented by modernc.org/sqlite driver connections.
+type sqliteBackuper interface {
+	NewBackup(dstURI string) (*sqlite.Backup, error)
+	NewRestore(srcURI string) (*sqlite.Backup, error)
+}
+
+// Backup uses SQLite's online backup API, which copies pages under a read
+// transaction and is safe against concurrent writers in WAL mode. The
+// snapshot is written to a temporary file and renamed into place.
+func (s *sqliteStore) Backup(ctx context.Context, dstPath string) error {
+	dstPath = strings.TrimPrefix(dstPath, "sqlite://")
+	if _, err := os.Stat(dstPath); err == nil {
+		return fmt.Errorf("backup destination %s already exists", dstPath)
+	}
+
+	tmp := dstPath + ".partial"
+	_ = os.Remove(tmp)
+	defer os.Remove(tmp)
+
+	err := s.withDriverConn(ctx, func(b sqliteBackuper) error {
+		bk, err := b.NewBackup(tmp)
+		if err != nil {
+			return err
+		}
+		// Copy every page in one step so the snapshot is a single consistent view
+		if _, err := bk.Step(-1); err != nil {
+			_ = bk.Finish()
+			return err
+		}
+		return bk.Finish()
+	})
+	if err != nil {
+		return fmt.Errorf("backup failed: %w", err)
+	}
+
+	if err := os.Rename(tmp, dstPath); err != nil {
+		return fmt.Errorf("failed to finalize backup: %w", err)
+	}
+	return nil
+}
+
+// Restore verifies the snapshot's integrity and copies it over the live
+// database through the backup API, so WAL state stays consistent.
+func (s *sqliteStore) Restore(ctx context.Context, srcPath string) error {
+	srcPath = strings.TrimPrefix(srcPath, "sqlite://")
+	if err := verifySnapshot(ctx, srcPath); err != nil {
+		return err
+	}
+
+	err := s.withDriverConn(ctx, func(b sqliteBackuper) error {
+		bk, err := b.NewRestore(srcPath)
+		if err != nil {
+			return err
+		}
+		if _, err := bk.Step(-1); err != nil {
+			_ = bk.Finish()
+			return err
+		}
+		return bk.Finish()
+	})
+	if err != nil {
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
+	// Bring the restored schema up to date
+	return s.migrate()
+}
+
+// withDriverConn runs fn with the raw driver connection of a pooled connection.
+func (s *sqliteStore) withDriverConn(ctx context.Context, fn func(sqliteBackuper) error) error {
+	if s.tx != nil {
+		return errors.New("backup cannot run inside a transaction")
+	}
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		b, ok := driverConn.(sqliteBackuper)
+		if !ok {
+			return ErrBackupUnsupported
+		}
+		return fn(b)
+	})
+}
+
+// verifySnapshot opens path read-only and runs an integrity check.
+func verifySnapshot(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("backup file: %w", err)
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=query_only(1)")
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("backup is not a valid SQLite database: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("backup failed integrity check: %s", result)
+	}
+	var users int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&users); err != nil {
+		return fmt.Errorf("backup is not a Sentinel database: %w", err)
+	}
+	return nil
+}
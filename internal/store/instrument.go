@@ -0,0 +1,182 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// DefaultSlowQueryThreshold is the query duration above which statements are
+// logged when no threshold is configured explicitly.
+const DefaultSlowQueryThreshold = 200 * time.Millisecond
+
+// maxLoggedStatement caps the length of statements in slow query logs.
+const maxLoggedStatement = 1000
+
+var (
+	queryDuration = metrics.NewHistogramVec(
+		"sentinel_db_query_duration_seconds",
+		"Database query latency by pool and operation.",
+		nil, "pool", "operation",
+	)
+	queryErrors = metrics.NewCounterVec(
+		"sentinel_db_query_errors_total",
+		"Database queries that returned an error.",
+		"pool", "operation",
+	)
+	slowQueries = metrics.NewCounterVec(
+		"sentinel_db_slow_queries_total",
+		"Database queries slower than the slow query threshold.",
+		"pool", "operation",
+	)
+)
+
+// instrumentedQuerier times every statement run through q, recording its
+// latency and logging it when it exceeds slow (zero disables logging).
+type instrumentedQuerier struct {
+	q    querier
+	pool string
+	slow time.Duration
+}
+
+func (iq instrumentedQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := iq.q.ExecContext(ctx, query, args...)
+	iq.observe(ctx, query, len(args), time.Since(start), err)
+	return res, err
+}
+
+func (iq instrumentedQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := iq.q.QueryContext(ctx, query, args...)
+	iq.observe(ctx, query, len(args), time.Since(start), err)
+	return rows, err
+}
+
+// QueryRowContext records the time to execute the statement; errors surface
+// on Scan and are not counted here.
+func (iq instrumentedQuerier) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := iq.q.QueryRowContext(ctx, query, args...)
+	iq.observe(ctx, query, len(args), time.Since(start), nil)
+	return row
+}
+
+func (iq instrumentedQuerier) observe(ctx context.Context, query string, nargs int, elapsed time.Duration, err error) {
+	op := queryOperation(query)
+	queryDuration.WithLabelValues(iq.pool, op).Observe(elapsed.Seconds())
+	if err != nil {
+		queryErrors.WithLabelValues(iq.pool, op).Inc()
+	}
+	if iq.slow > 0 && elapsed >= iq.slow {
+		slowQueries.WithLabelValues(iq.pool, op).Inc()
+		// Bound parameters are never logged, only their count.
+		logger.FromContext(ctx).Warn("Slow database query", map[string]interface{}{
+			"pool":        iq.pool,
+			"operation":   op,
+			"duration_ms": elapsed.Milliseconds(),
+			"statement":   redactStatement(query),
+			"args":        nargs,
+		})
+	}
+}
+
+// operations caches the operation label for each distinct statement; the
+// store only issues a fixed set of statements so this stays small.
+var operations sync.Map
+
+// queryOperation derives a low-cardinality label such as "select_users"
+// from a statement's verb and primary table.
+func queryOperation(query string) string {
+	if op, ok := operations.Load(query); ok {
+		return op.(string)
+	}
+	fields := strings.Fields(strings.ToLower(query))
+	op := "other"
+	if len(fields) > 0 {
+		op = fields[0]
+		var table string
+		switch op {
+		case "select", "delete":
+			table = wordAfter(fields, "from")
+		case "insert", "replace":
+			table = wordAfter(fields, "into")
+		case "update":
+			if len(fields) > 1 {
+				table = fields[1]
+			}
+		}
+		if table = strings.Trim(table, "`\"[]();"); table != "" {
+			op += "_" + table
+		}
+	}
+	operations.Store(query, op)
+	return op
+}
+
+func wordAfter(fields []string, keyword string) string {
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == keyword {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+var (
+	stringLiteral  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numericLiteral = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+)
+
+// redactStatement collapses whitespace and replaces inline literals with ?
+// so slow query logs never carry user data.
+func redactStatement(query string) string {
+	s := strings.Join(strings.Fields(query), " ")
+	s = stringLiteral.ReplaceAllString(s, "?")
+	s = numericLiteral.ReplaceAllString(s, "?")
+	if len(s) > maxLoggedStatement {
+		s = s[:maxLoggedStatement] + "..."
+	}
+	return s
+}
+
+// registerPoolMetrics exposes db's sql.DBStats under the given pool label.
+// Re-registering a pool label (e.g. after reopening) replaces the previous one.
+func registerPoolMetrics(db *sql.DB, pool string) {
+	labels := []string{"pool"}
+	values := []string{pool}
+	stat := func(f func(sql.DBStats) float64) func() float64 {
+		return func() float64 { return f(db.Stats()) }
+	}
+
+	metrics.Default.NewGaugeFunc("sentinel_db_max_open_connections", "Maximum number of open connections to the database.", labels, values,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	metrics.Default.NewGaugeFunc("sentinel_db_open_connections", "Established connections, both in use and idle.", labels, values,
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	metrics.Default.NewGaugeFunc("sentinel_db_in_use_connections", "Connections currently in use.", labels, values,
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	metrics.Default.NewGaugeFunc("sentinel_db_idle_connections", "Idle connections.", labels, values,
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	metrics.Default.NewCounterFunc("sentinel_db_wait_count_total", "Connections waited for because the pool was exhausted.", labels, values,
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	metrics.Default.NewCounterFunc("sentinel_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", labels, values,
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	metrics.Default.NewCounterFunc("sentinel_db_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.", labels, values,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	metrics.Default.NewCounterFunc("sentinel_db_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.", labels, values,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	metrics.Default.NewCounterFunc("sentinel_db_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.", labels, values,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
+
+// replicaPool names the pool label for the i'th replica.
+func replicaPool(i int) string {
+	return "replica" + strconv.Itoa(i)
+}
@@ -1,8 +1,14 @@
 package store
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,20 +16,173 @@ import (
 )
 
 // memStore is a simple in-memory Store for development and tests.
-// Not durable; not for production use.
+// Not durable; not for production use. It enforces the same uniqueness
+// rules as SQLite, and WithTx is atomic, so code behaves the same on both.
 type memStore struct {
-	mu     sync.RWMutex
-	next   int64
+	mu   rwLocker
+	next int64
+	// users maps IDs to users; byName maps usernames, folded as by
+	// nameKey, to IDs.
 	users  map[int64]*models.User
 	byName map[string]int64
+	// audit is the audit log in ID order; nextAudit is the ID assigned to
+	// the next event, since pruning removes the oldest.
+	audit     []models.AuditEvent
+	nextAudit int64
+	// revoked maps denylisted JTIs to their revocation record.
+	revoked map[string]models.RevokedToken
+	// refresh maps issued refresh token IDs to their record.
+	refresh map[string]models.RefreshToken
+	// recovery maps user IDs to their recovery code hashes; true marks a
+	// used code.
+	recovery map[int64]map[string]bool
+	// emailChanges maps user IDs to their email change.
+	emailChanges map[int64]models.EmailChange
+	// otp maps user IDs and purposes to one-time code challenges.
+	otp map[otpKey]models.OTPChallenge
+	// magicLinks maps user IDs to their magic link.
+	magicLinks map[int64]models.MagicLink
+	// canaries holds registered canaries in ID order; nextCanary is the
+	// next canary ID.
+	canaries   []models.Canary
+	nextCanary int64
+	// webhooks holds registered webhooks in ID order; nextWebhook is the
+	// ID assigned to the next one.
+	webhooks    []models.Webhook
+	nextWebhook int64
+	// outbox holds emitted events in ID order, with the time each is
+	// claimed until; nextOutbox is the ID assigned to the next one.
+	outbox     []outboxEntry
+	nextOutbox int64
+	// deliveries is the webhook delivery log in ID order; nextDelivery is
+	// the next delivery ID.
+	deliveries   []models.WebhookDelivery
+	nextDelivery int64
+	// quotas maps subjects to their quota; nextQuota is the ID assigned to
+	// the next one.
+	quotas    map[string]models.Quota
+	nextQuota int64
+	// quotaUsage maps subjects and periods to request counters.
+	quotaUsage map[quotaUsageKey]quotaCounter
+	// rateLimits maps keys to shared rate limit buckets.
+	rateLimits map[string]rateBucket
+	// tarpit maps keys to shared tarpit failure counts.
+	tarpit map[string]tarpitEntry
+	// nonces maps used request nonces to when they expire.
+	nonces map[string]time.Time
+	// leases maps lease names to their holder and expiry.
+	leases map[string]lease
+	// flags maps feature flag names to their override; nextFlag is the ID
+	// assigned to the next one.
+	flags    map[string]models.FeatureFlag
+	nextFlag int64
+	// clients maps client IDs to registered clients; nextClient is the ID
+	// assigned to the next one.
+	clients    map[string]models.Client
+	nextClient int64
+	// preferences maps user IDs to their saved preferences.
+	preferences map[int64]models.Preferences
+	// elevations holds elevation requests in ID order; nextElevation is
+	// the ID assigned to the next one.
+	elevations    []models.Elevation
+	nextElevation int64
+	// statusChanges holds scheduled status changes in ID order;
+	// nextStatusChange is the ID assigned to the next one.
+	statusChanges    []models.StatusChange
+	nextStatusChange int64
+}
+
+type quotaUsageKey struct {
+	subject string
+	period  string
+}
+
+type quotaCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+type rateBucket struct {
+	tokens     int
+	refilledAt time.Time
+	fullAt     time.Time
+}
+
+type tarpitEntry struct {
+	failures int
+	lastAt   time.Time
+}
+
+type lease struct {
+	holder    string
+	expiresAt time.Time
+}
+
+type otpKey struct {
+	userID  int64
+	purpose string
+}
+
+// rwLocker guards a memStore's state. Stores handed to WithTx callbacks
+// use noLock, since the transaction holds the parent store's lock.
+type rwLocker interface {
+	Lock()
+	Unlock()
+	RLock()
+	RUnlock()
+}
+
+type noLock struct{}
+
+func (noLock) Lock()    {}
+func (noLock) Unlock()  {}
+func (noLock) RLock()   {}
+func (noLock) RUnlock() {}
+
+// nameKey folds username for lookups, matching the NOCASE collation of
+// the SQLite username column, which folds ASCII letters only.
+func nameKey(username string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, username)
 }
 
 // NewMemStore constructs a new in-memory store.
 func NewMemStore() Store {
 	return &memStore{
-		next:   1,
-		users:  make(map[int64]*models.User),
-		byName: make(map[string]int64),
+		mu:       new(sync.RWMutex),
+		next:     1,
+		users:    make(map[int64]*models.User),
+		byName:   make(map[string]int64),
+		revoked:  make(map[string]models.RevokedToken),
+		refresh:  make(map[string]models.RefreshToken),
+		recovery: make(map[int64]map[string]bool),
+
+		emailChanges: make(map[int64]models.EmailChange),
+		otp:          make(map[otpKey]models.OTPChallenge),
+		magicLinks:   make(map[int64]models.MagicLink),
+		nextCanary:   1,
+		nextDelivery: 1,
+		nextWebhook:  1,
+		nextOutbox:   1,
+		quotas:       make(map[string]models.Quota),
+		nextQuota:    1,
+		quotaUsage:   make(map[quotaUsageKey]quotaCounter),
+		rateLimits:   make(map[string]rateBucket),
+		tarpit:       make(map[string]tarpitEntry),
+		nonces:       make(map[string]time.Time),
+		leases:       make(map[string]lease),
+		flags:        make(map[string]models.FeatureFlag),
+		nextFlag:     1,
+		clients:      make(map[string]models.Client),
+		nextClient:   1,
+		preferences:  make(map[int64]models.Preferences),
+
+		nextElevation:    1,
+		nextStatusChange: 1,
 	}
 }
 
@@ -31,37 +190,1478 @@ func (m *memStore) Close() error { return nil }
 
 func (m *memStore) Ping(ctx context.Context) error { return nil }
 
+// WithTx runs fn on a copy of the store while holding its lock, and keeps
+// the copy's state only if fn succeeds. Other callers wait for the
+// transaction, as they would for SQLite's write lock; fn must use tx
+// rather than the store it was called on.
+func (m *memStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if _, ok := m.mu.(noLock); ok {
+		return fn(m)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx := m.clone()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	mu := m.mu
+	*m = *tx
+	m.mu = mu
+	return nil
+}
+
+// clone returns a copy of m's state, guarded by noLock. The caller must
+// hold m.mu.
+func (m *memStore) clone() *memStore {
+	c := *m
+	c.mu = noLock{}
+	c.users = make(map[int64]*models.User, len(m.users))
+	for id, u := range m.users {
+		c.users[id] = cloneUser(u)
+	}
+	c.byName = maps.Clone(m.byName)
+	c.audit = slices.Clone(m.audit)
+	c.revoked = maps.Clone(m.revoked)
+	c.refresh = maps.Clone(m.refresh)
+	c.recovery = make(map[int64]map[string]bool, len(m.recovery))
+	for id, codes := range m.recovery {
+		c.recovery[id] = maps.Clone(codes)
+	}
+	c.emailChanges = maps.Clone(m.emailChanges)
+	c.otp = maps.Clone(m.otp)
+	c.magicLinks = maps.Clone(m.magicLinks)
+	c.canaries = slices.Clone(m.canaries)
+	c.webhooks = slices.Clone(m.webhooks)
+	c.outbox = slices.Clone(m.outbox)
+	c.deliveries = slices.Clone(m.deliveries)
+	c.quotas = maps.Clone(m.quotas)
+	c.quotaUsage = maps.Clone(m.quotaUsage)
+	c.rateLimits = maps.Clone(m.rateLimits)
+	c.tarpit = maps.Clone(m.tarpit)
+	c.nonces = maps.Clone(m.nonces)
+	c.leases = maps.Clone(m.leases)
+	c.flags = maps.Clone(m.flags)
+	c.clients = maps.Clone(m.clients)
+	c.preferences = maps.Clone(m.preferences)
+	c.elevations = slices.Clone(m.elevations)
+	c.statusChanges = slices.Clone(m.statusChanges)
+	return &c
+}
+
+// emailTaken reports whether a user other than except has email, compared
+// case-insensitively. Empty emails never collide. The caller must hold
+// m.mu.
+func (m *memStore) emailTaken(email string, except int64) bool {
+	if email == "" {
+		return false
+	}
+	for _, u := range m.users {
+		if u.ID != except && strings.EqualFold(u.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *memStore) CreateUser(ctx context.Context, u *models.User) (int64, error) {
 	if u == nil {
-		return 0, errors.New("nil user")
+		return 0, errors.New("user cannot be nil")
+	}
+	if u.Username == "" {
+		return 0, errors.New("username is required")
+	}
+	if u.Password == "" {
+		return 0, errors.New("password hash is required")
+	}
+	if u.Role == "" {
+		u.Role = "user"
 	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if _, taken := m.byName[nameKey(u.Username)]; taken {
+		return 0, fmt.Errorf("username '%s' already exists", u.Username)
+	}
+	if m.emailTaken(u.Email, 0) {
+		return 0, fmt.Errorf("email '%s' already exists", u.Email)
+	}
 	id := m.next
 	m.next++
 	u.ID = id
+	u.Version = 1
 	if u.CreatedAt.IsZero() {
 		u.CreatedAt = time.Now().UTC()
 	}
-	m.users[id] = u
-	m.byName[u.Username] = id
+	m.users[id] = cloneUser(u)
+	m.byName[nameKey(u.Username)] = id
 	return id, nil
 }
 
 func (m *memStore) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	id, ok := m.byName[username]
+	id, ok := m.byName[nameKey(username)]
 	if !ok {
 		return nil, nil
 	}
-	u := m.users[id]
-	return u, nil
+	return cloneUser(m.users[id]), nil
+}
+
+func (m *memStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, u := range m.users {
+		if email != "" && strings.EqualFold(u.Email, email) {
+			return cloneUser(u), nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memStore) UsernameExists(ctx context.Context, username string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.byName[nameKey(username)]
+	return ok, nil
+}
+
+func (m *memStore) EmailExists(ctx context.Context, email string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, u := range m.users {
+		if email != "" && strings.EqualFold(u.Email, email) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memStore) GetUserByPhone(ctx context.Context, phone string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, u := range m.users {
+		if phone != "" && u.Phone == phone {
+			return cloneUser(u), nil
+		}
+	}
+	return nil, nil
 }
 
 func (m *memStore) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	u := m.users[id]
-	return u, nil
+	return cloneUser(m.users[id]), nil
+}
+
+func (m *memStore) UpdateUser(ctx context.Context, u *models.User) error {
+	if u == nil {
+		return errors.New("nil user")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.users[u.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if existing.Version != u.Version {
+		return ErrVersionConflict
+	}
+	if m.emailTaken(u.Email, u.ID) {
+		return fmt.Errorf("email '%s' already exists", u.Email)
+	}
+	if u.Phone != "" {
+		for _, other := range m.users {
+			if other.ID != u.ID && other.Phone == u.Phone {
+				return fmt.Errorf("phone '%s' already exists", u.Phone)
+			}
+		}
+	}
+	existing.Email = u.Email
+	existing.Role = u.Role
+	existing.AvatarURL = u.AvatarURL
+	existing.Metadata = copyMetadata(u.Metadata)
+	existing.Disabled = u.Disabled
+	existing.Phone = u.Phone
+	existing.SMSOTP = u.SMSOTP
+	existing.ExpiresAt = u.ExpiresAt
+	existing.UpdatedAt = time.Now().UTC()
+	existing.Version++
+	u.Version = existing.Version
+	return nil
+}
+
+func (m *memStore) UpgradeGuest(ctx context.Context, u *models.User) error {
+	if u == nil || u.ID <= 0 || u.Username == "" || u.Password == "" || u.Role == models.RoleGuest {
+		return errors.New("a user ID, username, password hash, and non-guest role are required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.users[u.ID]
+	if !ok || existing.Role != models.RoleGuest {
+		return ErrNotFound
+	}
+	if existing.Version != u.Version {
+		return ErrVersionConflict
+	}
+	if id, taken := m.byName[nameKey(u.Username)]; taken && id != u.ID {
+		return fmt.Errorf("username '%s' already exists", u.Username)
+	}
+	if m.emailTaken(u.Email, u.ID) {
+		return fmt.Errorf("email '%s' already exists", u.Email)
+	}
+	delete(m.byName, nameKey(existing.Username))
+	existing.Username = u.Username
+	existing.Email = u.Email
+	existing.Password = u.Password
+	existing.Role = u.Role
+	existing.UpdatedAt = time.Now().UTC()
+	existing.Version++
+	m.byName[nameKey(u.Username)] = u.ID
+	u.Version = existing.Version
+	return nil
+}
+
+func (m *memStore) PurgeGuests(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, u := range m.users {
+		if u.Role == models.RoleGuest && u.CreatedAt.Before(cutoff) {
+			delete(m.byName, nameKey(u.Username))
+			delete(m.users, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) DeleteUser(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[id]; !ok {
+		return ErrNotFound
+	}
+	m.deleteUser(id)
+	return nil
+}
+
+// deleteUser removes user id and the records that belong to it. The
+// caller must hold m.mu.
+func (m *memStore) deleteUser(id int64) {
+	delete(m.byName, nameKey(m.users[id].Username))
+	delete(m.users, id)
+	delete(m.recovery, id)
+	delete(m.emailChanges, id)
+	delete(m.magicLinks, id)
+	delete(m.preferences, id)
+	m.elevations = slices.DeleteFunc(m.elevations, func(e models.Elevation) bool { return e.UserID == id })
+	m.statusChanges = slices.DeleteFunc(m.statusChanges, func(c models.StatusChange) bool { return c.UserID == id })
+	for k := range m.otp {
+		if k.userID == id {
+			delete(m.otp, k)
+		}
+	}
+}
+
+func (m *memStore) MergeUsers(ctx context.Context, sourceID, targetID int64) (MergeCounts, error) {
+	var counts MergeCounts
+	if sourceID == targetID {
+		return counts, errors.New("two different user IDs are required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.users[sourceID] == nil || m.users[targetID] == nil {
+		return counts, ErrNotFound
+	}
+	for jti, t := range m.refresh {
+		if t.UserID == sourceID {
+			t.UserID = targetID
+			m.refresh[jti] = t
+			counts.RefreshTokens++
+		}
+	}
+	for i := range m.audit {
+		e := &m.audit[i]
+		moved := false
+		if e.ActorID == sourceID {
+			e.ActorID, moved = targetID, true
+		}
+		if e.TargetType == "user" && e.TargetID == sourceID {
+			e.TargetID, moved = targetID, true
+		}
+		if moved {
+			counts.AuditEvents++
+		}
+	}
+	m.deleteUser(sourceID)
+	return counts, nil
+}
+
+func (m *memStore) SearchUsers(ctx context.Context, q string, limit, offset int) ([]UserSearchHit, int, error) {
+	if strings.TrimSpace(q) == "" {
+		return nil, 0, ErrEmptySearch
+	}
+	m.mu.RLock()
+	candidates := make([]*models.User, 0, len(m.users))
+	for _, u := range m.users {
+		candidates = append(candidates, cloneUser(u))
+	}
+	m.mu.RUnlock()
+	hits, total := rankUsers(q, candidates, limit, offset)
+	return hits, total, nil
+}
+
+// cloneUser copies u so callers cannot mutate stored state, mirroring the
+// fresh values a database-backed store returns.
+func (m *memStore) RecordAudit(ctx context.Context, e *models.AuditEvent) error {
+	if e == nil || e.Action == "" || e.TargetType == "" {
+		return errors.New("audit event requires an action and target type")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	if e.Changes == nil {
+		e.Changes = []models.FieldChange{}
+	}
+	m.nextAudit++
+	e.ID = m.nextAudit
+	m.audit = append(m.audit, *e)
+	return nil
+}
+
+func (m *memStore) RecordAudits(ctx context.Context, events []*models.AuditEvent) error {
+	for _, e := range events {
+		if e == nil || e.Action == "" || e.TargetType == "" {
+			return errors.New("audit event requires an action and target type")
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	for _, e := range events {
+		if e.CreatedAt.IsZero() {
+			e.CreatedAt = now
+		}
+		if e.Changes == nil {
+			e.Changes = []models.FieldChange{}
+		}
+		m.nextAudit++
+		e.ID = m.nextAudit
+		m.audit = append(m.audit, *e)
+	}
+	return nil
+}
+
+func (m *memStore) ListAuditEvents(ctx context.Context, f AuditFilter) ([]models.AuditEvent, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matches []models.AuditEvent
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		if e := m.audit[i]; auditMatches(f, &e) {
+			matches = append(matches, e)
+		}
+	}
+	// Events may be recorded with an earlier CreatedAt than ones before
+	// them, so order by time as SQLite does, then by ID.
+	slices.SortStableFunc(matches, func(a, b models.AuditEvent) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	total := len(matches)
+	start := min(f.Offset, total)
+	end := total
+	if f.Limit > 0 {
+		end = min(start+f.Limit, total)
+	}
+	return append([]models.AuditEvent{}, matches[start:end]...), total, nil
+}
+
+func (m *memStore) CountAuditTargets(ctx context.Context, f AuditFilter) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	targets := make(map[int64]bool)
+	for i := range m.audit {
+		if e := &m.audit[i]; auditMatches(f, e) {
+			targets[e.TargetID] = true
+		}
+	}
+	return len(targets), nil
+}
+
+func (m *memStore) PurgeAuditEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.audit[:0]
+	for _, e := range m.audit {
+		if !e.CreatedAt.Before(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	n := int64(len(m.audit) - len(kept))
+	clear(m.audit[len(kept):])
+	m.audit = kept
+	return n, nil
+}
+
+// auditMatches reports whether f selects e.
+func auditMatches(f AuditFilter, e *models.AuditEvent) bool {
+	return (f.ActorID <= 0 || e.ActorID == f.ActorID) &&
+		(f.TargetType == "" || e.TargetType == f.TargetType) &&
+		(f.TargetID <= 0 || e.TargetID == f.TargetID) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(len(f.Actions) == 0 || slices.Contains(f.Actions, e.Action)) &&
+		(f.TokenID == "" || e.TokenID == f.TokenID) &&
+		(f.Since.IsZero() || !e.CreatedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || e.CreatedAt.Before(f.Until) || (f.BeforeID > 0 && e.CreatedAt.Equal(f.Until) && e.ID < f.BeforeID)) &&
+		(f.UserID <= 0 || e.ActorID == f.UserID || (e.TargetType == "user" && e.TargetID == f.UserID))
+}
+
+func (m *memStore) RevokeToken(ctx context.Context, t *models.RevokedToken) error {
+	if t == nil || t.JTI == "" {
+		return errors.New("token ID is required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t.RevokedAt.IsZero() {
+		t.RevokedAt = time.Now().UTC()
+	}
+	if _, ok := m.revoked[t.JTI]; !ok {
+		m.revoked[t.JTI] = *t
+	}
+	return nil
+}
+
+func (m *memStore) ListRevokedTokens(ctx context.Context, since time.Time) ([]models.RevokedToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	var tokens []models.RevokedToken
+	for _, t := range m.revoked {
+		if !t.RevokedAt.Before(since) && t.ExpiresAt.After(now) {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *memStore) PurgeRevokedTokens(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for jti, t := range m.revoked {
+		if t.ExpiresAt.Before(cutoff) {
+			delete(m.revoked, jti)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) SaveRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	if t == nil || t.JTI == "" {
+		return errors.New("token ID is required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.refresh[t.JTI]; ok {
+		return errors.New("refresh token already recorded")
+	}
+	if t.IssuedAt.IsZero() {
+		t.IssuedAt = time.Now().UTC()
+	}
+	m.refresh[t.JTI] = *t
+	return nil
+}
+
+func (m *memStore) GetRefreshToken(ctx context.Context, jti string) (*models.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.refresh[jti]
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+func (m *memStore) ListRefreshTokens(ctx context.Context, userID int64) ([]models.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	tokens := []models.RefreshToken{}
+	for _, t := range m.refresh {
+		if t.UserID == userID && t.ExpiresAt.After(now) {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].IssuedAt.Equal(tokens[j].IssuedAt) {
+			return tokens[i].IssuedAt.After(tokens[j].IssuedAt)
+		}
+		return tokens[i].JTI < tokens[j].JTI
+	})
+	return tokens, nil
+}
+
+func (m *memStore) TouchRefreshToken(ctx context.Context, jti string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.refresh[jti]; ok && at.After(t.LastUsedAt) {
+		t.LastUsedAt = at.UTC()
+		m.refresh[jti] = t
+	}
+	return nil
+}
+
+func (m *memStore) PurgeRefreshTokens(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for jti, t := range m.refresh {
+		if t.ExpiresAt.Before(cutoff) {
+			delete(m.refresh, jti)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) SetRecoveryCodes(ctx context.Context, userID int64, hashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	codes := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		codes[h] = false
+	}
+	m.recovery[userID] = codes
+	return nil
+}
+
+func (m *memStore) UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	used, ok := m.recovery[userID][hash]
+	if !ok || used {
+		return false, nil
+	}
+	m.recovery[userID][hash] = true
+	return true, nil
+}
+
+func (m *memStore) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := 0
+	for _, used := range m.recovery[userID] {
+		if !used {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) SaveEmailChange(ctx context.Context, c *models.EmailChange) error {
+	if c == nil {
+		return errors.New("nil email change")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now().UTC()
+	}
+	m.emailChanges[c.UserID] = *c
+	return nil
+}
+
+func (m *memStore) GetEmailChange(ctx context.Context, userID int64) (*models.EmailChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.emailChanges[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+func (m *memStore) GetEmailChangeByToken(ctx context.Context, hash string) (*models.EmailChange, error) {
+	if hash == "" {
+		return nil, nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, c := range m.emailChanges {
+		if c.ConfirmTokenHash == hash || c.RevertTokenHash == hash {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memStore) DeleteEmailChange(ctx context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.emailChanges, userID)
+	return nil
+}
+
+func (m *memStore) SaveOTPChallenge(ctx context.Context, c *models.OTPChallenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now().UTC()
+	}
+	m.otp[otpKey{c.UserID, c.Purpose}] = *c
+	return nil
+}
+
+func (m *memStore) GetOTPChallenge(ctx context.Context, userID int64, purpose string) (*models.OTPChallenge, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.otp[otpKey{userID, purpose}]
+	if !ok {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+func (m *memStore) DeleteOTPChallenge(ctx context.Context, userID int64, purpose string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.otp, otpKey{userID, purpose})
+	return nil
+}
+
+func (m *memStore) SaveMagicLink(ctx context.Context, l *models.MagicLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l.CreatedAt.IsZero() {
+		l.CreatedAt = time.Now().UTC()
+	}
+	m.magicLinks[l.UserID] = *l
+	return nil
+}
+
+func (m *memStore) GetMagicLink(ctx context.Context, userID int64) (*models.MagicLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	l, ok := m.magicLinks[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &l, nil
+}
+
+func (m *memStore) GetMagicLinkByToken(ctx context.Context, hash string) (*models.MagicLink, error) {
+	if hash == "" {
+		return nil, nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, l := range m.magicLinks {
+		if l.TokenHash == hash {
+			return &l, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memStore) UseMagicLink(ctx context.Context, hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, l := range m.magicLinks {
+		if hash != "" && l.TokenHash == hash {
+			delete(m.magicLinks, id)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memStore) CreateCanary(ctx context.Context, c *models.Canary) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now().UTC()
+	}
+	c.ID = m.nextCanary
+	m.nextCanary++
+	m.canaries = append(m.canaries, *c)
+	return nil
+}
+
+func (m *memStore) ListCanaries(ctx context.Context) ([]models.Canary, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.canaries), nil
+}
+
+func (m *memStore) DeleteCanary(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.canaries, func(c models.Canary) bool { return c.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.canaries = slices.Delete(m.canaries, i, i+1)
+	return nil
+}
+
+func (m *memStore) CreateWebhook(ctx context.Context, w *models.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if w.CreatedAt.IsZero() {
+		w.CreatedAt = time.Now().UTC()
+	}
+	if w.UpdatedAt.IsZero() {
+		w.UpdatedAt = w.CreatedAt
+	}
+	w.ID = m.nextWebhook
+	m.nextWebhook++
+	stored := *w
+	stored.Events = slices.Clone(w.Events)
+	m.webhooks = append(m.webhooks, stored)
+	return nil
+}
+
+func (m *memStore) GetWebhook(ctx context.Context, id int64) (*models.Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, w := range m.webhooks {
+		if w.ID == id {
+			w.Events = slices.Clone(w.Events)
+			return &w, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memStore) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	webhooks := slices.Clone(m.webhooks)
+	for i := range webhooks {
+		webhooks[i].Events = slices.Clone(webhooks[i].Events)
+	}
+	return webhooks, nil
+}
+
+func (m *memStore) UpdateWebhook(ctx context.Context, w *models.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.webhooks, func(s models.Webhook) bool { return s.ID == w.ID })
+	if i < 0 {
+		return ErrNotFound
+	}
+	w.UpdatedAt = time.Now().UTC()
+	stored := &m.webhooks[i]
+	stored.URL, stored.Events, stored.Secret, stored.Enabled = w.URL, slices.Clone(w.Events), w.Secret, w.Enabled
+	stored.UpdatedAt = w.UpdatedAt
+	return nil
+}
+
+func (m *memStore) DeleteWebhook(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.webhooks, func(w models.Webhook) bool { return w.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.webhooks = slices.Delete(m.webhooks, i, i+1)
+	return nil
+}
+
+func (m *memStore) RecordWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now().UTC()
+	}
+	d.ID = m.nextDelivery
+	m.nextDelivery++
+	m.deliveries = append(m.deliveries, *d)
+	return nil
+}
+
+func (m *memStore) GetWebhookDelivery(ctx context.Context, id int64) (*models.WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, d := range m.deliveries {
+		if d.ID == id {
+			return &d, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memStore) ListWebhookDeliveries(ctx context.Context, webhookID string, limit, offset int) ([]models.WebhookDelivery, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var matches []models.WebhookDelivery
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			matches = append(matches, m.deliveries[i])
+		}
+	}
+	total := len(matches)
+	start := min(offset, total)
+	end := total
+	if limit > 0 {
+		end = min(start+limit, total)
+	}
+	return append([]models.WebhookDelivery{}, matches[start:end]...), total, nil
+}
+
+func (m *memStore) PurgeWebhookDeliveries(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before := len(m.deliveries)
+	m.deliveries = slices.DeleteFunc(m.deliveries, func(d models.WebhookDelivery) bool {
+		return d.CreatedAt.Before(cutoff)
+	})
+	return int64(before - len(m.deliveries)), nil
+}
+
+type outboxEntry struct {
+	event        models.OutboxEvent
+	claimedUntil time.Time
+}
+
+func cloneOutboxEvent(e models.OutboxEvent) models.OutboxEvent {
+	if e.DispatchedAt != nil {
+		t := *e.DispatchedAt
+		e.DispatchedAt = &t
+	}
+	return e
+}
+
+func (m *memStore) AddOutboxEvent(ctx context.Context, e *models.OutboxEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	e.ID = m.nextOutbox
+	m.nextOutbox++
+	m.outbox = append(m.outbox, outboxEntry{event: cloneOutboxEvent(*e)})
+	return nil
+}
+
+func (m *memStore) ClaimOutboxEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []models.OutboxEvent
+	for i := range m.outbox {
+		if len(events) == limit {
+			break
+		}
+		o := &m.outbox[i]
+		if o.event.DispatchedAt != nil || o.claimedUntil.After(now) {
+			continue
+		}
+		o.claimedUntil = now.Add(lease)
+		events = append(events, cloneOutboxEvent(o.event))
+	}
+	return events, nil
+}
+
+func (m *memStore) MarkOutboxEventDispatched(ctx context.Context, id int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.outbox {
+		if m.outbox[i].event.ID == id {
+			at := at.UTC()
+			m.outbox[i].event.DispatchedAt = &at
+		}
+	}
+	return nil
+}
+
+func (m *memStore) ListOutboxEvents(ctx context.Context, from, to time.Time, limit int) ([]models.OutboxEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var events []models.OutboxEvent
+	for _, o := range m.outbox {
+		if limit > 0 && len(events) == limit {
+			break
+		}
+		if !o.event.CreatedAt.Before(from) && o.event.CreatedAt.Before(to) {
+			events = append(events, cloneOutboxEvent(o.event))
+		}
+	}
+	return events, nil
+}
+
+func (m *memStore) PurgeOutboxEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before := len(m.outbox)
+	m.outbox = slices.DeleteFunc(m.outbox, func(o outboxEntry) bool {
+		return o.event.DispatchedAt != nil && o.event.CreatedAt.Before(cutoff)
+	})
+	return int64(before - len(m.outbox)), nil
+}
+
+func (m *memStore) SetQuota(ctx context.Context, q *models.Quota) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	if prev, ok := m.quotas[q.Subject]; ok {
+		q.ID, q.CreatedAt = prev.ID, prev.CreatedAt
+	} else {
+		q.ID, q.CreatedAt = m.nextQuota, now
+		m.nextQuota++
+	}
+	q.UpdatedAt = now
+	m.quotas[q.Subject] = *q
+	return nil
+}
+
+func (m *memStore) GetQuota(ctx context.Context, subject string) (*models.Quota, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	q, ok := m.quotas[subject]
+	if !ok {
+		return nil, nil
+	}
+	return &q, nil
+}
+
+func (m *memStore) ListQuotas(ctx context.Context) ([]models.Quota, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var quotas []models.Quota
+	for _, q := range m.quotas {
+		quotas = append(quotas, q)
+	}
+	slices.SortFunc(quotas, func(a, b models.Quota) int { return strings.Compare(a.Subject, b.Subject) })
+	return quotas, nil
+}
+
+func (m *memStore) DeleteQuota(ctx context.Context, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.quotas[subject]; !ok {
+		return ErrNotFound
+	}
+	delete(m.quotas, subject)
+	return nil
+}
+
+func (m *memStore) GetPreferences(ctx context.Context, userID int64) (*models.Preferences, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.preferences[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (m *memStore) SetPreferences(ctx context.Context, userID int64, p *models.Preferences) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p.UpdatedAt = time.Now().UTC()
+	m.preferences[userID] = *p
+	return nil
+}
+
+func (m *memStore) CreateElevation(ctx context.Context, e *models.Elevation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	e.ID = m.nextElevation
+	m.nextElevation++
+	stored := *e
+	stored.Scopes = slices.Clone(e.Scopes)
+	m.elevations = append(m.elevations, stored)
+	return nil
+}
+
+func (m *memStore) GetElevation(ctx context.Context, id int64) (*models.Elevation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := slices.IndexFunc(m.elevations, func(e models.Elevation) bool { return e.ID == id })
+	if i < 0 {
+		return nil, nil
+	}
+	e := m.elevations[i]
+	e.Scopes = slices.Clone(e.Scopes)
+	return &e, nil
+}
+
+func (m *memStore) ListElevations(ctx context.Context, status string, limit int) ([]models.Elevation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var elevations []models.Elevation
+	for i := len(m.elevations) - 1; i >= 0 && len(elevations) < limit; i-- {
+		if e := m.elevations[i]; status == "" || e.Status == status {
+			e.Scopes = slices.Clone(e.Scopes)
+			elevations = append(elevations, e)
+		}
+	}
+	return elevations, nil
+}
+
+func (m *memStore) DecideElevation(ctx context.Context, e *models.Elevation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.elevations, func(s models.Elevation) bool {
+		return s.ID == e.ID && s.Status == models.ElevationPending
+	})
+	if i < 0 {
+		return ErrNotFound
+	}
+	stored := &m.elevations[i]
+	stored.Status, stored.DecidedBy, stored.DecidedAt, stored.ExpiresAt = e.Status, e.DecidedBy, e.DecidedAt, e.ExpiresAt
+	return nil
+}
+
+func (m *memStore) RedeemElevation(ctx context.Context, id int64, jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.elevations, func(e models.Elevation) bool {
+		return e.ID == id && e.Status == models.ElevationApproved && e.TokenID == ""
+	})
+	if i < 0 {
+		return false, nil
+	}
+	m.elevations[i].TokenID = jti
+	return true, nil
+}
+
+func (m *memStore) CreateStatusChange(ctx context.Context, c *models.StatusChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now().UTC()
+	}
+	c.ID = m.nextStatusChange
+	m.nextStatusChange++
+	m.statusChanges = append(m.statusChanges, *c)
+	return nil
+}
+
+func (m *memStore) GetStatusChange(ctx context.Context, id int64) (*models.StatusChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := slices.IndexFunc(m.statusChanges, func(c models.StatusChange) bool { return c.ID == id })
+	if i < 0 {
+		return nil, nil
+	}
+	c := m.statusChanges[i]
+	return &c, nil
+}
+
+func (m *memStore) ListStatusChanges(ctx context.Context, userID int64) ([]models.StatusChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var changes []models.StatusChange
+	for _, c := range m.statusChanges {
+		if c.UserID == userID {
+			changes = append(changes, c)
+		}
+	}
+	sortStatusChanges(changes)
+	return changes, nil
+}
+
+func (m *memStore) ListDueStatusChanges(ctx context.Context, now time.Time, limit int) ([]models.StatusChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var changes []models.StatusChange
+	for _, c := range m.statusChanges {
+		if c.Status == models.StatusChangePending && !c.RunAt.After(now) {
+			changes = append(changes, c)
+		}
+	}
+	sortStatusChanges(changes)
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+// sortStatusChanges orders changes as they run.
+func sortStatusChanges(changes []models.StatusChange) {
+	slices.SortFunc(changes, func(a, b models.StatusChange) int {
+		if c := a.RunAt.Compare(b.RunAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+func (m *memStore) CompleteStatusChange(ctx context.Context, id int64, status string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.statusChanges, func(c models.StatusChange) bool {
+		return c.ID == id && c.Status == models.StatusChangePending
+	})
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.statusChanges[i].Status = status
+	m.statusChanges[i].CompletedAt = &at
+	return nil
+}
+
+func (m *memStore) SetFeatureFlag(ctx context.Context, f *models.FeatureFlag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	if prev, ok := m.flags[f.Name]; ok {
+		f.ID, f.CreatedAt = prev.ID, prev.CreatedAt
+	} else {
+		f.ID, f.CreatedAt = m.nextFlag, now
+		m.nextFlag++
+	}
+	f.UpdatedAt = now
+	stored := *f
+	stored.Subjects = slices.Clone(f.Subjects)
+	m.flags[f.Name] = stored
+	return nil
+}
+
+func (m *memStore) GetFeatureFlag(ctx context.Context, name string) (*models.FeatureFlag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.flags[name]
+	if !ok {
+		return nil, nil
+	}
+	f.Subjects = slices.Clone(f.Subjects)
+	return &f, nil
+}
+
+func (m *memStore) ListFeatureFlags(ctx context.Context) ([]models.FeatureFlag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var flags []models.FeatureFlag
+	for _, f := range m.flags {
+		f.Subjects = slices.Clone(f.Subjects)
+		flags = append(flags, f)
+	}
+	slices.SortFunc(flags, func(a, b models.FeatureFlag) int { return strings.Compare(a.Name, b.Name) })
+	return flags, nil
+}
+
+func (m *memStore) DeleteFeatureFlag(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.flags[name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.flags, name)
+	return nil
+}
+
+func (m *memStore) SetClient(ctx context.Context, c *models.Client) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	if prev, ok := m.clients[c.ClientID]; ok {
+		c.ID, c.CreatedAt = prev.ID, prev.CreatedAt
+	} else {
+		c.ID, c.CreatedAt = m.nextClient, now
+		m.nextClient++
+	}
+	c.UpdatedAt = now
+	m.clients[c.ClientID] = cloneClient(*c)
+	return nil
+}
+
+// cloneClient returns a copy of c that shares no slices with it.
+func cloneClient(c models.Client) models.Client {
+	c.AllowedScopes = slices.Clone(c.AllowedScopes)
+	c.CORSOrigins = slices.Clone(c.CORSOrigins)
+	return c
+}
+
+func (m *memStore) GetClient(ctx context.Context, clientID string) (*models.Client, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.clients[clientID]
+	if !ok {
+		return nil, nil
+	}
+	c = cloneClient(c)
+	return &c, nil
+}
+
+func (m *memStore) ListClients(ctx context.Context) ([]models.Client, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var clients []models.Client
+	for _, c := range m.clients {
+		clients = append(clients, cloneClient(c))
+	}
+	slices.SortFunc(clients, func(a, b models.Client) int { return strings.Compare(a.ClientID, b.ClientID) })
+	return clients, nil
+}
+
+func (m *memStore) DeleteClient(ctx context.Context, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.clients[clientID]; !ok {
+		return ErrNotFound
+	}
+	delete(m.clients, clientID)
+	return nil
+}
+
+func (m *memStore) IncrementQuotaUsage(ctx context.Context, subject, period string, expiresAt time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := quotaUsageKey{subject, period}
+	c, ok := m.quotaUsage[key]
+	if !ok {
+		c.expiresAt = expiresAt
+	}
+	c.count++
+	m.quotaUsage[key] = c
+	return c.count, nil
+}
+
+func (m *memStore) QuotaUsage(ctx context.Context, subject, period string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.quotaUsage[quotaUsageKey{subject, period}].count, nil
+}
+
+func (m *memStore) PurgeQuotaUsage(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for key, c := range m.quotaUsage {
+		if c.expiresAt.Before(cutoff) {
+			delete(m.quotaUsage, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) TakeRateLimitToken(ctx context.Context, key string, rate time.Duration, capacity int, now time.Time) (bool, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.rateLimits[key]
+	if !ok {
+		b = rateBucket{tokens: capacity, refilledAt: now}
+	}
+	// Partial tokens carry over, as in the SQLite store
+	if refill := now.Sub(b.refilledAt) / rate; refill > 0 {
+		b.tokens = int(min(int64(b.tokens)+int64(refill), int64(capacity)))
+		b.refilledAt = b.refilledAt.Add(refill * rate)
+	}
+	if b.tokens >= capacity {
+		b.tokens, b.refilledAt = capacity, now
+	}
+	taken := b.tokens > 0
+	if taken {
+		b.tokens--
+	}
+	b.fullAt = now.Add(time.Duration(capacity) * rate)
+	m.rateLimits[key] = b
+	return taken, b.tokens, nil
+}
+
+func (m *memStore) PurgeRateLimits(ctx context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for key, b := range m.rateLimits {
+		if b.fullAt.Before(now) {
+			delete(m.rateLimits, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) RecordTarpitFailure(ctx context.Context, key string, window time.Duration, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.tarpit[key]
+	if now.Sub(e.lastAt) > window {
+		e.failures = 0
+	}
+	e.failures++
+	e.lastAt = now
+	m.tarpit[key] = e
+	return nil
+}
+
+func (m *memStore) TarpitFailures(ctx context.Context, keys []string, window time.Duration, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var worst int
+	for _, k := range keys {
+		if e, ok := m.tarpit[k]; ok && now.Sub(e.lastAt) <= window {
+			worst = max(worst, e.failures)
+		}
+	}
+	return worst, nil
+}
+
+func (m *memStore) ClearTarpitFailures(ctx context.Context, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		delete(m.tarpit, k)
+	}
+	return nil
+}
+
+func (m *memStore) PurgeTarpitFailures(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for k, e := range m.tarpit {
+		if e.lastAt.Before(cutoff) {
+			delete(m.tarpit, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) UseNonce(ctx context.Context, key string, expiresAt, now time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if exp, ok := m.nonces[key]; ok && exp.After(now) {
+		return false, nil
+	}
+	m.nonces[key] = expiresAt
+	return true, nil
+}
+
+func (m *memStore) PurgeNonces(ctx context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for key, exp := range m.nonces {
+		if !exp.After(now) {
+			delete(m.nonces, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.leases[name]; ok && l.holder != holder && l.expiresAt.After(now) {
+		return false, nil
+	}
+	m.leases[name] = lease{holder: holder, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (m *memStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.leases[name].holder == holder {
+		delete(m.leases, name)
+	}
+	return nil
+}
+
+func (m *memStore) CountUsers(ctx context.Context, filter UserFilter) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := 0
+	for _, u := range m.users {
+		if filter.matches(u) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) ListUserIDs(ctx context.Context, filter UserFilter, limit int) ([]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := []int64{}
+	for id, u := range m.users {
+		if filter.matches(u) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func (m *memStore) ListExpiringUsers(ctx context.Context, before time.Time, limit int) ([]*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	users := []*models.User{}
+	for _, u := range m.users {
+		if !u.Disabled && u.ExpiresAt != nil && u.ExpiresAt.Before(before) {
+			users = append(users, cloneUser(u))
+		}
+	}
+	slices.SortFunc(users, func(a, b *models.User) int {
+		if c := a.ExpiresAt.Compare(*b.ExpiresAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (m *memStore) Stats(ctx context.Context) (*Stats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	st := &Stats{Users: len(m.users)}
+	for _, u := range m.users {
+		if u.Disabled {
+			st.DisabledUsers++
+		}
+		if u.Role == "admin" {
+			st.Admins++
+		}
+	}
+	rotated := make(map[string]bool)
+	for _, t := range m.refresh {
+		rotated[t.ParentJTI] = true
+	}
+	now := time.Now()
+	for jti, t := range m.refresh {
+		if _, revoked := m.revoked[jti]; t.ExpiresAt.After(now) && !rotated[jti] && !revoked {
+			st.ActiveSessions++
+		}
+	}
+	return st, nil
+}
+
+func cloneUser(u *models.User) *models.User {
+	if u == nil {
+		return nil
+	}
+	c := *u
+	c.Metadata = copyMetadata(u.Metadata)
+	return &c
+}
+
+// copyMetadata shallow-copies m so callers cannot mutate stored state.
+func copyMetadata(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
 }
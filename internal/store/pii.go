@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+)
+
+// Field names bound into PII ciphertexts and blind indexes.
+const (
+	piiEmail = "users.email"
+	piiPhone = "users.phone"
+)
+
+// ErrPIIKeyRequired is returned when opening a database whose email and
+// phone columns are encrypted without a PII cipher.
+var ErrPIIKeyRequired = errors.New("database has encrypted email and phone columns; set PII_ENCRYPTION_KEY")
+
+// With a PII cipher configured, the users email and phone columns hold
+// blind indexes, so the unique indexes and lookups keep working, and the
+// email_enc and phone_enc columns hold the ciphertexts. Emails are indexed
+// lowercased, matching the case-insensitive lookups of plain columns.
+//
+// Only the users table and its search index are covered: pending email
+// changes and SMS challenges, which are short-lived, still hold plain
+// values, and pages freed by the encryption keep the old values until the
+// database is vacuumed. Audit diffs mask emails and phones instead (see
+// audit.DiffUser). Admin search matches encrypted emails exactly rather
+// than by substring.
+
+// emailKey returns the value the email column holds for email.
+func (s *sqliteStore) emailKey(email string) string {
+	if s.pii == nil {
+		return email
+	}
+	return s.pii.Index(piiEmail, strings.ToLower(email))
+}
+
+// phoneKey returns the value the phone column holds for phone.
+func (s *sqliteStore) phoneKey(phone string) string {
+	if s.pii == nil || phone == "" {
+		return phone
+	}
+	return s.pii.Index(piiPhone, phone)
+}
+
+// sealEmail returns the email and email_enc column values for email.
+func (s *sqliteStore) sealEmail(email string) (interface{}, string, error) {
+	if s.pii == nil || email == "" {
+		return nullIfEmpty(email), "", nil
+	}
+	enc, err := s.pii.Encrypt(piiEmail, email)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encrypt email: %w", err)
+	}
+	return s.emailKey(email), enc, nil
+}
+
+// sealPhone returns the phone and phone_enc column values for phone.
+func (s *sqliteStore) sealPhone(phone string) (string, string, error) {
+	if s.pii == nil || phone == "" {
+		return phone, "", nil
+	}
+	enc, err := s.pii.Encrypt(piiPhone, phone)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt phone: %w", err)
+	}
+	return s.phoneKey(phone), enc, nil
+}
+
+// openPII replaces u's email and phone blind indexes with the decrypted
+// values when the row holds ciphertexts.
+func (s *sqliteStore) openPII(u *models.User, emailEnc, phoneEnc string) error {
+	if emailEnc == "" && phoneEnc == "" {
+		return nil
+	}
+	if s.pii == nil {
+		return ErrPIIKeyRequired
+	}
+	var err error
+	if emailEnc != "" {
+		if u.Email, err = s.pii.Decrypt(piiEmail, emailEnc); err != nil {
+			return fmt.Errorf("failed to decrypt email: %w", err)
+		}
+	}
+	if phoneEnc != "" {
+		if u.Phone, err = s.pii.Decrypt(piiPhone, phoneEnc); err != nil {
+			return fmt.Errorf("failed to decrypt phone: %w", err)
+		}
+	}
+	return nil
+}
+
+// initPII reconciles the users table with the configured PII cipher. It
+// refuses to open a database with encrypted rows without a cipher, or with
+// one that cannot decrypt them, so that new rows are never written in the
+// clear next to encrypted ones. With a cipher it encrypts the rows written
+// before encryption was turned on.
+func (s *sqliteStore) initPII(ctx context.Context) error {
+	var emailEnc, phoneEnc string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT email_enc, phone_enc FROM users WHERE email_enc != '' OR phone_enc != '' LIMIT 1`,
+	).Scan(&emailEnc, &phoneEnc)
+	if err == nil {
+		if err := s.openPII(&models.User{}, emailEnc, phoneEnc); err != nil {
+			return fmt.Errorf("PII encryption key does not match the database: %w", err)
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to check PII encryption: %w", err)
+	}
+	if s.pii == nil {
+		return nil
+	}
+	return s.encryptPlainPII(ctx)
+}
+
+// encryptPlainPII encrypts the emails and phones stored in the clear, then
+// rebuilds the search index, whose segments would otherwise keep the
+// plain emails' trigrams after the rows are updated.
+func (s *sqliteStore) encryptPlainPII(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, COALESCE(email, ''), phone, email_enc, phone_enc FROM users
+		 WHERE (email IS NOT NULL AND email_enc = '') OR (phone != '' AND phone_enc = '')`)
+	if err != nil {
+		return fmt.Errorf("failed to find unencrypted PII: %w", err)
+	}
+	type plainRow struct {
+		id                               int64
+		email, phone, emailEnc, phoneEnc string
+	}
+	var plain []plainRow
+	for rows.Next() {
+		var r plainRow
+		if err := rows.Scan(&r.id, &r.email, &r.phone, &r.emailEnc, &r.phoneEnc); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to find unencrypted PII: %w", err)
+		}
+		plain = append(plain, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to find unencrypted PII: %w", err)
+	}
+	if len(plain) == 0 {
+		return nil
+	}
+
+	return s.WithTx(ctx, func(tx Store) error {
+		q := tx.(*sqliteStore).q
+		for _, r := range plain {
+			if r.emailEnc == "" && r.email != "" {
+				email, enc, err := s.sealEmail(r.email)
+				if err != nil {
+					return err
+				}
+				if _, err := q.ExecContext(ctx, `UPDATE users SET email = ?, email_enc = ? WHERE id = ?`, email, enc, r.id); err != nil {
+					return fmt.Errorf("failed to encrypt email: %w", err)
+				}
+			}
+			if r.phoneEnc == "" && r.phone != "" {
+				phone, enc, err := s.sealPhone(r.phone)
+				if err != nil {
+					return err
+				}
+				if _, err := q.ExecContext(ctx, `UPDATE users SET phone = ?, phone_enc = ? WHERE id = ?`, phone, enc, r.id); err != nil {
+					return fmt.Errorf("failed to encrypt phone: %w", err)
+				}
+			}
+		}
+		if _, err := q.ExecContext(ctx, `INSERT INTO users_fts(users_fts) VALUES ('rebuild')`); err != nil {
+			return fmt.Errorf("failed to rebuild the search index: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/pii"
+)
+
+// Replica routing defaults.
+const (
+	DefaultMaxReplicaLag        = 5 * time.Second
+	DefaultReplicaCheckInterval = 2 * time.Second
+)
+
+// SQLiteOptions configures optional SQLite store features.
+type SQLiteOptions struct {
+	// ReplicaURLs lists read-only replicas (e.g. LiteFS or Litestream
+	// restores) that read queries are routed to.
+	ReplicaURLs []string
+	// MaxReplicaLag is the heartbeat age beyond which a replica is skipped.
+	MaxReplicaLag time.Duration
+	// ReplicaCheckInterval controls how often the primary writes its
+	// heartbeat and replicas are re-evaluated.
+	ReplicaCheckInterval time.Duration
+	// SlowQueryThreshold logs statements that take longer than this;
+	// zero disables slow query logging.
+	SlowQueryThreshold time.Duration
+	// PII, when set, encrypts users' email and phone columns; see pii.go.
+	PII *pii.Cipher
+}
+
+// replica is a read-only connection pool with its latest health verdict.
+type replica struct {
+	url     string
+	pool    string // metrics label
+	db      *sql.DB
+	healthy atomic.Bool
+	lag     atomic.Int64 // nanoseconds
+}
+
+// replicaSet routes reads across healthy replicas and keeps their health
+// current by comparing each replica's copy of the primary heartbeat.
+type replicaSet struct {
+	primary  *sql.DB
+	replicas []*replica
+	maxLag   time.Duration
+	interval time.Duration
+	next     atomic.Uint64
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// openReplica opens dsn in query-only mode with a small pool.
+func openReplica(dsn string) (*sql.DB, error) {
+	path := strings.TrimPrefix(dsn, "sqlite://")
+	db, err := sql.Open("sqlite", path+"?_pragma=query_only(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replica %s: %w", dsn, err)
+	}
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(10)
+	db.SetConnMaxLifetime(10 * time.Minute)
+	return db, nil
+}
+
+func newReplicaSet(primary *sql.DB, opts SQLiteOptions) (*replicaSet, error) {
+	rs := &replicaSet{
+		primary:  primary,
+		maxLag:   opts.MaxReplicaLag,
+		interval: opts.ReplicaCheckInterval,
+		stop:     make(chan struct{}),
+	}
+	if rs.maxLag <= 0 {
+		rs.maxLag = DefaultMaxReplicaLag
+	}
+	if rs.interval <= 0 {
+		rs.interval = DefaultReplicaCheckInterval
+	}
+
+	for i, url := range opts.ReplicaURLs {
+		db, err := openReplica(url)
+		if err != nil {
+			rs.close()
+			return nil, err
+		}
+		r := &replica{url: url, pool: replicaPool(i), db: db}
+		registerPoolMetrics(db, r.pool)
+		rs.replicas = append(rs.replicas, r)
+	}
+
+	// Establish health synchronously so the first reads route correctly.
+	rs.beat()
+	rs.check()
+
+	rs.wg.Add(1)
+	go rs.loop()
+	return rs, nil
+}
+
+func (rs *replicaSet) loop() {
+	defer rs.wg.Done()
+	ticker := time.NewTicker(rs.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rs.beat()
+			rs.check()
+		case <-rs.stop:
+			return
+		}
+	}
+}
+
+// beat records the current time on the primary for replicas to replicate.
+func (rs *replicaSet) beat() {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+	defer cancel()
+	_, err := rs.primary.ExecContext(ctx,
+		`INSERT INTO replication_heartbeat (id, beat_at) VALUES (1, ?)
+		 ON CONFLICT(id) DO UPDATE SET beat_at = excluded.beat_at`,
+		time.Now().UnixMilli())
+	if err != nil {
+		logger.Warn("Failed to write replication heartbeat", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// check re-evaluates each replica's lag from its copy of the heartbeat.
+func (rs *replicaSet) check() {
+	for _, r := range rs.replicas {
+		lag, err := rs.measureLag(r)
+		healthy := err == nil && lag <= rs.maxLag
+		r.lag.Store(int64(lag))
+
+		if was := r.healthy.Swap(healthy); was != healthy {
+			fields := map[string]interface{}{
+				"replica": r.url,
+				"lag_ms":  lag.Milliseconds(),
+			}
+			if err != nil {
+				fields["error"] = err.Error()
+			}
+			if healthy {
+				logger.Info("Read replica healthy; routing reads to it", fields)
+			} else {
+				logger.Warn("Read replica unhealthy or lagging; falling back to primary", fields)
+			}
+		}
+	}
+}
+
+func (rs *replicaSet) measureLag(r *replica) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+	defer cancel()
+	var beatAt int64
+	if err := r.db.QueryRowContext(ctx, `SELECT beat_at FROM replication_heartbeat WHERE id = 1`).Scan(&beatAt); err != nil {
+		return 0, err
+	}
+	lag := time.Since(time.UnixMilli(beatAt))
+	if lag < 0 {
+		lag = 0
+	}
+	return lag, nil
+}
+
+// reader returns a healthy replica chosen round-robin, or nil when every
+// replica is unhealthy and reads should go to the primary.
+func (rs *replicaSet) reader() *replica {
+	n := len(rs.replicas)
+	if n == 0 {
+		return nil
+	}
+	start := rs.next.Add(1)
+	for i := 0; i < n; i++ {
+		r := rs.replicas[(start+uint64(i))%uint64(n)]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (rs *replicaSet) close() error {
+	select {
+	case <-rs.stop:
+	default:
+		close(rs.stop)
+	}
+	rs.wg.Wait()
+	var firstErr error
+	for _, r := range rs.replicas {
+		if err := r.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
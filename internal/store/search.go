@@ -0,0 +1,175 @@
+package store
+
+import (
+	"errors"
+	"html"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+)
+
+// MaxSearchCandidates bounds how many rows a search considers before
+// ranking; queries matching more users should be refined.
+const MaxSearchCandidates = 1000
+
+// ErrEmptySearch is returned by SearchUsers for blank queries.
+var ErrEmptySearch = errors.New("search query cannot be empty")
+
+// UserSearchHit is a single search result. Highlights maps a matched field
+// ("username" or "email") to its HTML-escaped value with matching runs
+// wrapped in <mark> tags.
+type UserSearchHit struct {
+	User       *models.User
+	Score      float64
+	Highlights map[string]string
+}
+
+// Relative weights of the match kinds; fuzzy matches score below any
+// substring match so exact hits always rank first.
+const (
+	scoreExact     = 1.0
+	scorePrefix    = 0.9
+	scoreSubstring = 0.7
+	scoreFuzzyMax  = 0.5
+)
+
+// rankUsers scores candidates against query, drops non-matches, and returns
+// the requested page ordered by score then username, with the total count.
+func rankUsers(query string, candidates []*models.User, limit, offset int) ([]UserSearchHit, int) {
+	q := strings.ToLower(strings.TrimSpace(query))
+	var hits []UserSearchHit
+	for _, u := range candidates {
+		hit := UserSearchHit{User: u, Highlights: map[string]string{}}
+		for _, field := range []struct{ name, value string }{{"username", u.Username}, {"email", u.Email}} {
+			score, marked := matchField(q, field.value)
+			if score == 0 {
+				continue
+			}
+			hit.Highlights[field.name] = marked
+			hit.Score = max(hit.Score, score)
+		}
+		if hit.Score > 0 {
+			hits = append(hits, hit)
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return strings.ToLower(hits[i].User.Username) < strings.ToLower(hits[j].User.Username)
+	})
+
+	total := len(hits)
+	if offset >= total {
+		return []UserSearchHit{}, total
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	return hits[offset:end], total
+}
+
+// matchField scores how well value matches the lowercased query q and
+// returns value highlighted accordingly; a zero score means no match.
+func matchField(q, value string) (float64, string) {
+	v := strings.ToLower(value)
+	if q == "" || v == "" {
+		return 0, ""
+	}
+	if i := strings.Index(v, q); i >= 0 && len(v) == len(value) {
+		score := scoreSubstring
+		switch {
+		case v == q:
+			score = scoreExact
+		case i == 0:
+			score = scorePrefix
+		}
+		return score, markRange(value, i, i+len(q))
+	}
+
+	// Fuzzy: compare against the value's prefix of the same length so
+	// "alcie" finds "alice@example.com", allowing ~1 edit per 4 characters.
+	qr, vr := []rune(q), []rune(v)
+	if len(qr) < 3 {
+		return 0, ""
+	}
+	n := min(len(vr), len(qr))
+	dist := editDistance(qr, vr[:n]) + (len(qr) - n)
+	allowed := max(1, len(qr)/4)
+	if dist > allowed {
+		return 0, ""
+	}
+	score := scoreFuzzyMax * (1 - float64(dist)/float64(len(qr)+1))
+	orig := []rune(value)
+	end := len(string(orig[:min(n, len(orig))]))
+	return score, markRange(value, 0, end)
+}
+
+// markRange HTML-escapes value and wraps the byte range [start, end) in <mark>.
+func markRange(value string, start, end int) string {
+	if start < 0 || end > len(value) || start >= end || !utf8.ValidString(value[:start]) {
+		return html.EscapeString(value)
+	}
+	return html.EscapeString(value[:start]) + "<mark>" + html.EscapeString(value[start:end]) + "</mark>" + html.EscapeString(value[end:])
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions, and adjacent transpositions.
+func editDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// searchTrigrams returns an FTS5 trigram MATCH expression that selects rows
+// sharing any 3-character window with q, or "" if q is too short.
+func searchTrigrams(q string) string {
+	r := []rune(q)
+	if len(r) < 3 {
+		return ""
+	}
+	seen := map[string]bool{}
+	var terms []string
+	for i := 0; i+3 <= len(r) && len(terms) < 32; i++ {
+		t := string(r[i : i+3])
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		terms = append(terms, `"`+strings.ReplaceAll(t, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// likePrefix returns a LIKE pattern matching values that start with the
+// first two characters of q (typos are rarest at the start of a name).
+func likePrefix(q string) string {
+	r := []rune(q)
+	if len(r) > 2 {
+		r = r[:2]
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(string(r))
+	return escaped + "%"
+}
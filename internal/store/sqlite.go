@@ -2,14 +2,19 @@
 package store
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/pii"
 	_ "modernc.org/sqlite" // Pure Go SQLite driver
 )
 
@@ -22,6 +27,352 @@ const (
 
 type sqliteStore struct {
 	db *sql.DB
+	// q runs queries: the pool itself, or the open transaction for stores
+	// handed to WithTx callbacks.
+	q  querier
+	tx *sql.Tx
+	// replicas serves read-only queries when read replicas are configured.
+	replicas *replicaSet
+	// slowQuery is the latency above which statements are logged.
+	slowQuery time.Duration
+	// pii encrypts email and phone columns when set.
+	pii *pii.Cipher
+}
+
+// reader returns the querier for read-only queries: the open transaction,
+// a healthy replica, or the primary pool.
+func (s *sqliteStore) reader() querier {
+	if s.tx != nil || s.replicas == nil {
+		return s.q
+	}
+	if r := s.replicas.reader(); r != nil {
+		return s.instrument(r.db, r.pool)
+	}
+	return s.q
+}
+
+// instrument wraps q so its statements are timed and slow ones logged.
+func (s *sqliteStore) instrument(q querier, pool string) querier {
+	return instrumentedQuerier{q: q, pool: pool, slow: s.slowQuery}
+}
+
+// querier is the subset of *sql.DB and *sql.Tx used by store methods.
+//
+// Statements are passed as SQL rather than prepared once and cached:
+// modernc.org/sqlite compiles a statement each time it runs, even one
+// prepared through database/sql, so a *sql.Stmt saves no parsing and only
+// adds per-connection bookkeeping. BenchmarkGetUserByID and friends
+// measure the hot path.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// userColumns is the column list shared by every users SELECT; keep it in
+// sync with scanUser.
+const userColumns = `id, username, COALESCE(email, ''), password_hash, role, avatar_url, version, disabled, phone, sms_otp, metadata, created_at, updated_at, email_enc, phone_enc, expires_at`
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans a row selected with userColumns into a User, decrypting
+// its email and phone if they are encrypted.
+func (s *sqliteStore) scanUser(row rowScanner) (*models.User, error) {
+	u := &models.User{}
+	var metadata, emailEnc, phoneEnc string
+	var expiresAt sql.NullTime
+	err := row.Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.Role, &u.AvatarURL, &u.Version, &u.Disabled, &u.Phone, &u.SMSOTP, &metadata, &u.CreatedAt, &u.UpdatedAt, &emailEnc, &phoneEnc, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		u.ExpiresAt = &expiresAt.Time
+	}
+	if err := s.openPII(u, emailEnc, phoneEnc); err != nil {
+		return nil, err
+	}
+	if metadata != "" && metadata != "{}" {
+		if err := json.Unmarshal([]byte(metadata), &u.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode user metadata: %w", err)
+		}
+	}
+	return u, nil
+}
+
+// encodeMetadata serializes user metadata for the metadata column.
+func encodeMetadata(m map[string]interface{}) (string, error) {
+	if len(m) == 0 {
+		return "{}", nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode user metadata: %w", err)
+	}
+	return string(b), nil
+}
+
+// migrations are applied in order after the base schema. The index of the
+// last applied migration plus one is tracked in PRAGMA user_version, so
+// entries must only ever be appended.
+var migrations = []string{
+	`ALTER TABLE users ADD COLUMN avatar_url TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN metadata TEXT NOT NULL DEFAULT '{}'`,
+	`CREATE TABLE IF NOT EXISTS replication_heartbeat (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		beat_at INTEGER NOT NULL
+	)`,
+	`ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0`,
+	// Trigram full-text index over username and email for admin search,
+	// kept in sync with users by triggers.
+	`CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+		username, email, content='users', content_rowid='id', tokenize='trigram'
+	);
+	CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users BEGIN
+		INSERT INTO users_fts(rowid, username, email) VALUES (new.id, new.username, new.email);
+	END;
+	CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users BEGIN
+		INSERT INTO users_fts(users_fts, rowid, username, email) VALUES ('delete', old.id, old.username, old.email);
+	END;
+	CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF username, email ON users BEGIN
+		INSERT INTO users_fts(users_fts, rowid, username, email) VALUES ('delete', old.id, old.username, old.email);
+		INSERT INTO users_fts(rowid, username, email) VALUES (new.id, new.username, new.email);
+	END;
+	INSERT INTO users_fts(users_fts) VALUES ('rebuild')`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		actor_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		target_type TEXT NOT NULL,
+		target_id INTEGER NOT NULL DEFAULT 0,
+		changes TEXT NOT NULL DEFAULT '[]',
+		request_id TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at)`,
+	`CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_revoked_tokens_revoked_at ON revoked_tokens(revoked_at);
+	CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at)`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		jti TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		parent_jti TEXT NOT NULL DEFAULT '',
+		issued_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens(user_id, issued_at);
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+	ALTER TABLE audit_log ADD COLUMN jti TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE audit_log ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+	ALTER TABLE audit_log ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+	ALTER TABLE audit_log ADD COLUMN country TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS recovery_codes (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		used_at DATETIME,
+		PRIMARY KEY (user_id, code_hash)
+	)`,
+	`CREATE TABLE IF NOT EXISTS email_changes (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		old_email TEXT NOT NULL,
+		new_email TEXT NOT NULL,
+		confirm_token_hash TEXT NOT NULL DEFAULT '',
+		confirm_expires_at DATETIME NOT NULL,
+		confirmed_at DATETIME,
+		revert_token_hash TEXT NOT NULL DEFAULT '',
+		revert_expires_at DATETIME,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_email_changes_confirm ON email_changes(confirm_token_hash) WHERE confirm_token_hash != '';
+	CREATE INDEX IF NOT EXISTS idx_email_changes_revert ON email_changes(revert_token_hash) WHERE revert_token_hash != ''`,
+	// Finds the token that replaced a rotated one when counting sessions.
+	`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_parent ON refresh_tokens(parent_jti) WHERE parent_jti != ''`,
+	`ALTER TABLE users ADD COLUMN phone TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN sms_otp INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE IF NOT EXISTS otp_challenges (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		purpose TEXT NOT NULL,
+		phone TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (user_id, purpose)
+	)`,
+	// A verified phone number identifies one account.
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_phone ON users(phone) WHERE phone != ''`,
+	`CREATE TABLE IF NOT EXISTS magic_links (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		token_hash TEXT NOT NULL UNIQUE,
+		device_hash TEXT NOT NULL DEFAULT '',
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS canaries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		user_id INTEGER NOT NULL DEFAULT 0,
+		jti TEXT NOT NULL DEFAULT '',
+		note TEXT NOT NULL DEFAULT '',
+		created_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id TEXT NOT NULL,
+		event_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		url TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		redelivery_of INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_created_at ON webhook_deliveries(created_at)`,
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		events TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		created_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS outbox_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		dispatched_at DATETIME,
+		claimed_until DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON outbox_events(dispatched_at, claimed_until);
+	CREATE INDEX IF NOT EXISTS idx_outbox_events_created_at ON outbox_events(created_at)`,
+	`CREATE TABLE IF NOT EXISTS quotas (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		subject TEXT NOT NULL UNIQUE,
+		daily INTEGER NOT NULL DEFAULT 0,
+		monthly INTEGER NOT NULL DEFAULT 0,
+		updated_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS quota_usage (
+		subject TEXT NOT NULL,
+		period TEXT NOT NULL,
+		count INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		PRIMARY KEY (subject, period)
+	);
+	CREATE INDEX IF NOT EXISTS idx_quota_usage_expires_at ON quota_usage(expires_at)`,
+	// Times are Unix nanoseconds so buckets refill exactly as in memory.
+	`CREATE TABLE IF NOT EXISTS rate_limits (
+		key TEXT PRIMARY KEY,
+		tokens INTEGER NOT NULL,
+		refilled_at INTEGER NOT NULL,
+		taken INTEGER NOT NULL,
+		full_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_rate_limits_full_at ON rate_limits(full_at)`,
+	`CREATE TABLE IF NOT EXISTS request_nonces (
+		key TEXT PRIMARY KEY,
+		expires_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_request_nonces_expires_at ON request_nonces(expires_at)`,
+	`CREATE TABLE IF NOT EXISTS leases (
+		name TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		expires_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS feature_flags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		enabled INTEGER NOT NULL DEFAULT 0,
+		percent INTEGER NOT NULL DEFAULT 0,
+		subjects TEXT NOT NULL DEFAULT '',
+		updated_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_preferences (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		login_alerts INTEGER NOT NULL DEFAULT 0,
+		session_timeout_minutes INTEGER NOT NULL DEFAULT 0,
+		locale TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS elevations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		scopes TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		duration_seconds INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		decided_by INTEGER NOT NULL DEFAULT 0,
+		decided_at DATETIME,
+		expires_at DATETIME,
+		jti TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_elevations_status ON elevations(status, id)`,
+	// With PII encryption on, email and phone hold blind indexes and these
+	// hold the ciphertexts; see pii.go.
+	`ALTER TABLE users ADD COLUMN email_enc TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN phone_enc TEXT NOT NULL DEFAULT ''`,
+	// When each session was last used, for idle timeouts.
+	`ALTER TABLE refresh_tokens ADD COLUMN last_used_at DATETIME`,
+	`CREATE TABLE IF NOT EXISTS clients (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		client_id TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL DEFAULT '',
+		access_token_ttl INTEGER NOT NULL DEFAULT 0,
+		refresh_token_ttl INTEGER NOT NULL DEFAULT 0,
+		refresh_policy TEXT NOT NULL DEFAULT '',
+		allowed_scopes TEXT NOT NULL DEFAULT '',
+		cors_origins TEXT NOT NULL DEFAULT '',
+		updated_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`,
+	// When temporary accounts expire.
+	`ALTER TABLE users ADD COLUMN expires_at DATETIME;
+	CREATE INDEX IF NOT EXISTS idx_users_expires_at ON users(expires_at) WHERE expires_at IS NOT NULL`,
+	`CREATE TABLE IF NOT EXISTS status_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		action TEXT NOT NULL,
+		run_at DATETIME NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		notify INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL,
+		created_by INTEGER NOT NULL DEFAULT 0,
+		completed_at DATETIME,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_status_changes_due ON status_changes(status, run_at);
+	CREATE INDEX IF NOT EXISTS idx_status_changes_user ON status_changes(user_id, run_at)`,
+	// Times are Unix nanoseconds, as for rate limits.
+	`CREATE TABLE IF NOT EXISTS tarpit_failures (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL,
+		last_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_tarpit_failures_last_at ON tarpit_failures(last_at)`,
 }
 
 // withTimeout creates a context with timeout if one isn't already set
@@ -33,19 +384,52 @@ func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, c
 	return context.WithTimeout(ctx, timeout)
 }
 
+// SchemaVersion is the schema version this build migrates databases to.
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// InspectSQLite opens the existing database at path read-only, checks that
+// it responds, and returns its schema version without migrating it.
+func InspectSQLite(ctx context.Context, path string) (int, error) {
+	dbPath := strings.TrimPrefix(path, "sqlite://")
+	if _, err := os.Stat(dbPath); err != nil {
+		return 0, fmt.Errorf("database file: %w", err)
+	}
+	db, err := sql.Open("sqlite", "file:"+dbPath+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return 0, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	defer db.Close()
+
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
 // NewSQLite opens (or creates) an SQLite database and applies schema.
 // It configures WAL, foreign keys, and a tuned connection pool.
 func NewSQLite(path string) (Store, error) {
+	return NewSQLiteWithOptions(path, SQLiteOptions{})
+}
+
+// NewSQLiteWithOptions is NewSQLite with optional features such as read
+// replicas enabled.
+func NewSQLiteWithOptions(path string, opts SQLiteOptions) (Store, error) {
 	// Parse database URL to extract path
 	dbPath := strings.TrimPrefix(path, "sqlite://")
 
-	// Enterprise SQLite configuration:
-	// - _foreign_keys=1: Enable foreign key constraints
-	// - _journal_mode=WAL: Write-Ahead Logging for better concurrency
-	// - _timeout=5000: 5 second busy timeout
-	// - _cache_size=-64000: 64MB cache (negative = KB)
-	// - _synchronous=NORMAL: Balance between safety and performance
-	db, err := sql.Open("sqlite", dbPath+"?_foreign_keys=1&_journal_mode=WAL&_timeout=5000&_cache_size=-64000&_synchronous=NORMAL")
+	// Enterprise SQLite configuration (modernc.org/sqlite _pragma syntax):
+	// - foreign_keys(1): Enable foreign key constraints
+	// - journal_mode(WAL): Write-Ahead Logging for better concurrency
+	// - busy_timeout(5000): 5 second busy timeout
+	// - cache_size(-64000): 64MB cache (negative = KB)
+	// - synchronous(NORMAL): Balance between safety and performance
+	// - _txlock=immediate: Take the write lock at BEGIN so read-then-write
+	//   transactions cannot deadlock on lock upgrade
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=cache_size(-64000)&_pragma=synchronous(NORMAL)&_txlock=immediate")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
 	}
@@ -60,11 +444,26 @@ func NewSQLite(path string) (Store, error) {
 	db.SetConnMaxLifetime(10 * time.Minute)
 	db.SetConnMaxIdleTime(5 * time.Minute)
 
-	s := &sqliteStore{db: db}
+	s := &sqliteStore{db: db, slowQuery: opts.SlowQueryThreshold, pii: opts.PII}
+	s.q = s.instrument(db, "primary")
+	registerPoolMetrics(db, "primary")
 	if err := s.init(); err != nil {
 		_ = db.Close()
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
+	if err := s.initPII(context.Background()); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	if len(opts.ReplicaURLs) > 0 {
+		replicas, err := newReplicaSet(db, opts)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		s.replicas = replicas
+	}
 	return s, nil
 }
 
@@ -96,11 +495,43 @@ func (s *sqliteStore) init() error {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
 
+	return s.migrate()
+}
+
+// migrate applies any migrations newer than the database's user_version.
+func (s *sqliteStore) migrate() error {
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		// PRAGMA does not accept bound parameters.
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", i+1, err)
+		}
+	}
 	return nil
 }
 
 func (s *sqliteStore) Close() error {
-	if s.db != nil {
+	// The pool is owned by the root store, not by transaction-scoped copies
+	if s.tx == nil && s.db != nil {
+		if s.replicas != nil {
+			_ = s.replicas.close()
+		}
 		return s.db.Close()
 	}
 	return nil
@@ -110,6 +541,44 @@ func (s *sqliteStore) Ping(ctx context.Context) error {
 	return s.db.PingContext(ctx)
 }
 
+// WithTx runs fn inside a database transaction, committing when fn returns
+// nil and rolling back otherwise. Calls on a store that is already inside a
+// transaction join it rather than nesting.
+func (s *sqliteStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	ctx, cancel := withTimeout(ctx, DefaultTxTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	txStore := &sqliteStore{db: s.db, tx: tx, slowQuery: s.slowQuery, pii: s.pii}
+	txStore.q = s.instrument(tx, "primary")
+	if err := fn(txStore); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 func (s *sqliteStore) CreateUser(ctx context.Context, u *models.User) (int64, error) {
 	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
 	defer cancel()
@@ -130,11 +599,21 @@ func (s *sqliteStore) CreateUser(ctx context.Context, u *models.User) (int64, er
 		u.CreatedAt = time.Now().UTC()
 	}
 
-	query := `INSERT INTO users (username, email, password_hash, role, created_at) 
-			  VALUES (?, ?, ?, ?, ?)`
+	metadata, err := encodeMetadata(u.Metadata)
+	if err != nil {
+		return 0, err
+	}
+
+	email, emailEnc, err := s.sealEmail(u.Email)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `INSERT INTO users (username, email, email_enc, password_hash, role, metadata, created_at, expires_at) 
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
-	result, err := s.db.ExecContext(ctx, query,
-		u.Username, u.Email, u.Password, u.Role, u.CreatedAt)
+	result, err := s.q.ExecContext(ctx, query,
+		u.Username, email, emailEnc, u.Password, u.Role, metadata, u.CreatedAt, utcOrNil(u.ExpiresAt))
 	if err != nil {
 		// Check for unique constraint violations
 		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.username") {
@@ -152,6 +631,7 @@ func (s *sqliteStore) CreateUser(ctx context.Context, u *models.User) (int64, er
 	}
 
 	u.ID = id
+	u.Version = 1
 	return id, nil
 }
 
@@ -163,13 +643,9 @@ func (s *sqliteStore) GetUserByUsername(ctx context.Context, username string) (*
 		return nil, errors.New("username cannot be empty")
 	}
 
-	query := `SELECT id, username, email, password_hash, role, created_at 
-			  FROM users WHERE username = ? COLLATE NOCASE`
-
-	row := s.db.QueryRowContext(ctx, query, username)
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = ? COLLATE NOCASE`
 
-	u := &models.User{}
-	err := row.Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.Role, &u.CreatedAt)
+	u, err := s.scanUser(s.reader().QueryRowContext(ctx, query, username))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil // User not found
@@ -180,6 +656,74 @@ func (s *sqliteStore) GetUserByUsername(ctx context.Context, username string) (*
 	return u, nil
 }
 
+func (s *sqliteStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if email == "" {
+		return nil, errors.New("email cannot be empty")
+	}
+
+	u, err := s.scanUser(s.reader().QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email = ? COLLATE NOCASE`, s.emailKey(email)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user by email: %w", err)
+	}
+	return u, nil
+}
+
+func (s *sqliteStore) UsernameExists(ctx context.Context, username string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if username == "" {
+		return false, errors.New("username cannot be empty")
+	}
+
+	var exists bool
+	err := s.reader().QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE username = ? COLLATE NOCASE)`, username).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check username: %w", err)
+	}
+	return exists, nil
+}
+
+func (s *sqliteStore) EmailExists(ctx context.Context, email string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if email == "" {
+		return false, errors.New("email cannot be empty")
+	}
+
+	var exists bool
+	err := s.reader().QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE email = ? COLLATE NOCASE)`, s.emailKey(email)).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check email: %w", err)
+	}
+	return exists, nil
+}
+
+func (s *sqliteStore) GetUserByPhone(ctx context.Context, phone string) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if phone == "" {
+		return nil, errors.New("phone cannot be empty")
+	}
+
+	u, err := s.scanUser(s.reader().QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE phone = ?`, s.phoneKey(phone)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user by phone: %w", err)
+	}
+	return u, nil
+}
+
 func (s *sqliteStore) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
 	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
 	defer cancel()
@@ -188,13 +732,9 @@ func (s *sqliteStore) GetUserByID(ctx context.Context, id int64) (*models.User,
 		return nil, errors.New("user ID must be positive")
 	}
 
-	query := `SELECT id, username, email, password_hash, role, created_at 
-			  FROM users WHERE id = ?`
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
 
-	row := s.db.QueryRowContext(ctx, query, id)
-
-	u := &models.User{}
-	err := row.Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.Role, &u.CreatedAt)
+	u, err := s.scanUser(s.reader().QueryRowContext(ctx, query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil // User not found
@@ -204,3 +744,2020 @@ func (s *sqliteStore) GetUserByID(ctx context.Context, id int64) (*models.User,
 
 	return u, nil
 }
+
+func (s *sqliteStore) UpdateUser(ctx context.Context, u *models.User) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if u == nil || u.ID <= 0 {
+		return errors.New("user with a positive ID is required")
+	}
+
+	metadata, err := encodeMetadata(u.Metadata)
+	if err != nil {
+		return err
+	}
+
+	email, emailEnc, err := s.sealEmail(u.Email)
+	if err != nil {
+		return err
+	}
+	phone, phoneEnc, err := s.sealPhone(u.Phone)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE users SET email = ?, email_enc = ?, role = ?, avatar_url = ?, metadata = ?, disabled = ?, phone = ?, phone_enc = ?, sms_otp = ?, expires_at = ?,
+			  version = version + 1
+			  WHERE id = ? AND version = ?`
+
+	result, err := s.q.ExecContext(ctx, query, email, emailEnc, u.Role, u.AvatarURL, metadata, u.Disabled, phone, phoneEnc, u.SMSOTP, utcOrNil(u.ExpiresAt), u.ID, u.Version)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.email") {
+			return fmt.Errorf("email '%s' already exists", u.Email)
+		}
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.phone") {
+			return fmt.Errorf("phone '%s' already exists", u.Phone)
+		}
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	if n == 0 {
+		// Distinguish a missing row from a stale version
+		var exists int
+		err := s.q.QueryRowContext(ctx, `SELECT 1 FROM users WHERE id = ?`, u.ID).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		return ErrVersionConflict
+	}
+	u.Version++
+	return nil
+}
+
+func (s *sqliteStore) UpgradeGuest(ctx context.Context, u *models.User) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if u == nil || u.ID <= 0 || u.Username == "" || u.Password == "" || u.Role == models.RoleGuest {
+		return errors.New("a user ID, username, password hash, and non-guest role are required")
+	}
+
+	email, emailEnc, err := s.sealEmail(u.Email)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.q.ExecContext(ctx,
+		`UPDATE users SET username = ?, email = ?, email_enc = ?, password_hash = ?, role = ?, version = version + 1
+		 WHERE id = ? AND version = ? AND role = ?`,
+		u.Username, email, emailEnc, u.Password, u.Role, u.ID, u.Version, models.RoleGuest)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.username") {
+			return fmt.Errorf("username '%s' already exists", u.Username)
+		}
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.email") {
+			return fmt.Errorf("email '%s' already exists", u.Email)
+		}
+		return fmt.Errorf("failed to upgrade guest: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to upgrade guest: %w", err)
+	}
+	if n == 0 {
+		var role string
+		err := s.q.QueryRowContext(ctx, `SELECT role FROM users WHERE id = ?`, u.ID).Scan(&role)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && role != models.RoleGuest) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to upgrade guest: %w", err)
+		}
+		return ErrVersionConflict
+	}
+	u.Version++
+	return nil
+}
+
+func (s *sqliteStore) PurgeGuests(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM users WHERE role = ? AND created_at < ?`, models.RoleGuest, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge guests: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqliteStore) DeleteUser(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if id <= 0 {
+		return errors.New("user ID must be positive")
+	}
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) MergeUsers(ctx context.Context, sourceID, targetID int64) (MergeCounts, error) {
+	var counts MergeCounts
+	if sourceID <= 0 || targetID <= 0 || sourceID == targetID {
+		return counts, errors.New("two different positive user IDs are required")
+	}
+
+	err := s.WithTx(ctx, func(tx Store) error {
+		q := tx.(*sqliteStore).q
+		var n int
+		if err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE id IN (?, ?)`, sourceID, targetID).Scan(&n); err != nil {
+			return fmt.Errorf("failed to merge users: %w", err)
+		}
+		if n != 2 {
+			return ErrNotFound
+		}
+
+		result, err := q.ExecContext(ctx, `UPDATE refresh_tokens SET user_id = ? WHERE user_id = ?`, targetID, sourceID)
+		if err != nil {
+			return fmt.Errorf("failed to move refresh tokens: %w", err)
+		}
+		if counts.RefreshTokens, err = result.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to move refresh tokens: %w", err)
+		}
+
+		// One statement, so an event the user both performed and was the
+		// target of is counted once
+		result, err = q.ExecContext(ctx,
+			`UPDATE audit_log SET
+				actor_id = CASE WHEN actor_id = ? THEN ? ELSE actor_id END,
+				target_id = CASE WHEN target_type = 'user' AND target_id = ? THEN ? ELSE target_id END
+			 WHERE actor_id = ? OR (target_type = 'user' AND target_id = ?)`,
+			sourceID, targetID, sourceID, targetID, sourceID, sourceID)
+		if err != nil {
+			return fmt.Errorf("failed to move audit events: %w", err)
+		}
+		if counts.AuditEvents, err = result.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to move audit events: %w", err)
+		}
+
+		if _, err := q.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, sourceID); err != nil {
+			return fmt.Errorf("failed to delete merged user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return MergeCounts{}, err
+	}
+	return counts, nil
+}
+
+// SearchUsers finds users whose username or email contains q, or nearly
+// matches it. Candidates come from the users_fts trigram index plus a
+// two-character prefix scan (to catch typos) and are ranked in Go.
+func (s *sqliteStore) SearchUsers(ctx context.Context, q string, limit, offset int) ([]UserSearchHit, int, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	norm := strings.ToLower(strings.TrimSpace(q))
+	if norm == "" {
+		return nil, 0, ErrEmptySearch
+	}
+
+	prefix := likePrefix(norm)
+	query := `SELECT ` + userColumns + ` FROM users
+			  WHERE username LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\'`
+	args := []interface{}{prefix, prefix}
+	if s.pii != nil {
+		// Encrypted emails can only be matched exactly
+		query += ` OR email = ?`
+		args = append(args, s.emailKey(norm))
+	}
+	if match := searchTrigrams(norm); match != "" {
+		query += ` OR id IN (SELECT rowid FROM users_fts WHERE users_fts MATCH ?)`
+		args = append(args, match)
+	}
+	query += ` LIMIT ?`
+	args = append(args, MaxSearchCandidates)
+
+	rows, err := s.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	var candidates []*models.User
+	for rows.Next() {
+		u, err := s.scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to search users: %w", err)
+		}
+		candidates = append(candidates, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to search users: %w", err)
+	}
+
+	hits, total := rankUsers(norm, candidates, limit, offset)
+	return hits, total, nil
+}
+
+func (s *sqliteStore) RecordAudit(ctx context.Context, e *models.AuditEvent) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if e == nil || e.Action == "" || e.TargetType == "" {
+		return errors.New("audit event requires an action and target type")
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	if e.Changes == nil {
+		e.Changes = []models.FieldChange{}
+	}
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit changes: %w", err)
+	}
+
+	result, err := s.q.ExecContext(ctx,
+		`INSERT INTO audit_log (actor_id, action, target_type, target_id, changes, request_id, jti, ip, user_agent, country, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ActorID, e.Action, e.TargetType, e.TargetID, string(changes), e.RequestID, e.TokenID,
+		e.IP, e.UserAgent, e.Country, e.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get audit event ID: %w", err)
+	}
+	e.ID = id
+	return nil
+}
+
+func (s *sqliteStore) RecordAudits(ctx context.Context, events []*models.AuditEvent) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		for _, e := range events {
+			if err := tx.RecordAudit(ctx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) ListAuditEvents(ctx context.Context, f AuditFilter) ([]models.AuditEvent, int, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	clause, args := auditFilterClause(f)
+
+	var total int
+	if err := s.reader().QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log`+clause, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit events: %w", err)
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = -1 // SQLite: no limit
+	}
+	rows, err := s.reader().QueryContext(ctx,
+		`SELECT id, actor_id, action, target_type, target_id, changes, request_id, jti, ip, user_agent, country, created_at FROM audit_log`+
+			clause+` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var e models.AuditEvent
+		var changes string
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID, &changes, &e.RequestID, &e.TokenID,
+			&e.IP, &e.UserAgent, &e.Country, &e.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode audit changes: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	return events, total, nil
+}
+
+func (s *sqliteStore) CountAuditTargets(ctx context.Context, f AuditFilter) (int, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	clause, args := auditFilterClause(f)
+	var n int
+	if err := s.reader().QueryRowContext(ctx, `SELECT COUNT(DISTINCT target_id) FROM audit_log`+clause, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count audit targets: %w", err)
+	}
+	return n, nil
+}
+
+func (s *sqliteStore) PurgeAuditEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM audit_log WHERE created_at < ?`, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge audit events: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// auditFilterClause returns the WHERE clause, including the keyword, that
+// selects the audit events f matches, with its arguments.
+func auditFilterClause(f AuditFilter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if f.ActorID > 0 {
+		where, args = append(where, "actor_id = ?"), append(args, f.ActorID)
+	}
+	if f.TargetType != "" {
+		where, args = append(where, "target_type = ?"), append(args, f.TargetType)
+	}
+	if f.TargetID > 0 {
+		where, args = append(where, "target_id = ?"), append(args, f.TargetID)
+	}
+	if f.Action != "" {
+		where, args = append(where, "action = ?"), append(args, f.Action)
+	}
+	if len(f.Actions) > 0 {
+		where = append(where, "action IN (?"+strings.Repeat(", ?", len(f.Actions)-1)+")")
+		for _, a := range f.Actions {
+			args = append(args, a)
+		}
+	}
+	if f.TokenID != "" {
+		where, args = append(where, "jti = ?"), append(args, f.TokenID)
+	}
+	if !f.Since.IsZero() {
+		where, args = append(where, "created_at >= ?"), append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() && f.BeforeID > 0 {
+		where = append(where, "(created_at < ? OR (created_at = ? AND id < ?))")
+		args = append(args, f.Until.UTC(), f.Until.UTC(), f.BeforeID)
+	} else if !f.Until.IsZero() {
+		where, args = append(where, "created_at < ?"), append(args, f.Until.UTC())
+	}
+	if f.UserID > 0 {
+		where = append(where, "(actor_id = ? OR (target_type = 'user' AND target_id = ?))")
+		args = append(args, f.UserID, f.UserID)
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+func (s *sqliteStore) RevokeToken(ctx context.Context, t *models.RevokedToken) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if t == nil || t.JTI == "" {
+		return errors.New("token ID is required")
+	}
+	if t.RevokedAt.IsZero() {
+		t.RevokedAt = time.Now().UTC()
+	}
+	_, err := s.q.ExecContext(ctx,
+		`INSERT INTO revoked_tokens (jti, expires_at, revoked_at) VALUES (?, ?, ?)
+		 ON CONFLICT(jti) DO NOTHING`,
+		t.JTI, t.ExpiresAt.UTC(), t.RevokedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) ListRevokedTokens(ctx context.Context, since time.Time) ([]models.RevokedToken, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary: a lagging replica would delay revocations.
+	rows, err := s.q.QueryContext(ctx,
+		`SELECT jti, expires_at, revoked_at FROM revoked_tokens
+		 WHERE revoked_at >= ? AND expires_at > ? ORDER BY revoked_at`,
+		since.UTC(), time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revoked tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []models.RevokedToken
+	for rows.Next() {
+		var t models.RevokedToken
+		if err := rows.Scan(&t.JTI, &t.ExpiresAt, &t.RevokedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan revoked token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list revoked tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *sqliteStore) PurgeRevokedTokens(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < ?`, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge revoked tokens: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqliteStore) SaveRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if t == nil || t.JTI == "" {
+		return errors.New("token ID is required")
+	}
+	if t.IssuedAt.IsZero() {
+		t.IssuedAt = time.Now().UTC()
+	}
+	_, err := s.q.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (jti, user_id, parent_jti, issued_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		t.JTI, t.UserID, t.ParentJTI, t.IssuedAt.UTC(), t.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to save refresh token: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) GetRefreshToken(ctx context.Context, jti string) (*models.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary: the token may have been issued moments ago.
+	var t models.RefreshToken
+	var lastUsed sql.NullTime
+	err := s.q.QueryRowContext(ctx,
+		`SELECT jti, user_id, parent_jti, issued_at, expires_at, last_used_at FROM refresh_tokens WHERE jti = ?`, jti,
+	).Scan(&t.JTI, &t.UserID, &t.ParentJTI, &t.IssuedAt, &t.ExpiresAt, &lastUsed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	t.LastUsedAt = lastUsed.Time
+	return &t, nil
+}
+
+func (s *sqliteStore) ListRefreshTokens(ctx context.Context, userID int64) ([]models.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	rows, err := s.reader().QueryContext(ctx,
+		`SELECT jti, user_id, parent_jti, issued_at, expires_at, last_used_at FROM refresh_tokens
+		 WHERE user_id = ? AND expires_at > ? ORDER BY issued_at DESC, jti`,
+		userID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refresh tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []models.RefreshToken{}
+	for rows.Next() {
+		var t models.RefreshToken
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&t.JTI, &t.UserID, &t.ParentJTI, &t.IssuedAt, &t.ExpiresAt, &lastUsed); err != nil {
+			return nil, fmt.Errorf("failed to scan refresh token: %w", err)
+		}
+		t.LastUsedAt = lastUsed.Time
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list refresh tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *sqliteStore) TouchRefreshToken(ctx context.Context, jti string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	_, err := s.q.ExecContext(ctx,
+		`UPDATE refresh_tokens SET last_used_at = ? WHERE jti = ? AND (last_used_at IS NULL OR last_used_at < ?)`,
+		at.UTC(), jti, at.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record refresh token use: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) PurgeRefreshTokens(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expires_at < ?`, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge refresh tokens: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqliteStore) SetRecoveryCodes(ctx context.Context, userID int64, hashes []string) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		q := tx.(*sqliteStore).q
+		if _, err := q.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("failed to clear recovery codes: %w", err)
+		}
+		now := time.Now().UTC()
+		for _, h := range hashes {
+			if _, err := q.ExecContext(ctx,
+				`INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)`,
+				userID, h, now); err != nil {
+				return fmt.Errorf("failed to save recovery code: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// The used_at condition makes concurrent attempts with the same code
+	// race for a single row update.
+	result, err := s.q.ExecContext(ctx,
+		`UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now().UTC(), userID, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (s *sqliteStore) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary so a code used moments ago is not counted.
+	var n int
+	err := s.q.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return n, nil
+}
+
+const emailChangeColumns = `user_id, old_email, new_email, confirm_token_hash, confirm_expires_at,
+	confirmed_at, revert_token_hash, revert_expires_at, created_at`
+
+func (s *sqliteStore) SaveEmailChange(ctx context.Context, c *models.EmailChange) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if c == nil {
+		return errors.New("nil email change")
+	}
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.q.ExecContext(ctx,
+		`INSERT OR REPLACE INTO email_changes (`+emailChangeColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.UserID, c.OldEmail, c.NewEmail, c.ConfirmTokenHash, c.ConfirmExpiresAt.UTC(),
+		utcOrNil(c.ConfirmedAt), c.RevertTokenHash, utcOrNil(c.RevertExpiresAt), c.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to save email change: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) GetEmailChange(ctx context.Context, userID int64) (*models.EmailChange, error) {
+	return s.getEmailChange(ctx, `user_id = ?`, userID)
+}
+
+func (s *sqliteStore) GetEmailChangeByToken(ctx context.Context, hash string) (*models.EmailChange, error) {
+	if hash == "" {
+		return nil, nil
+	}
+	return s.getEmailChange(ctx, `confirm_token_hash = ? OR revert_token_hash = ?`, hash, hash)
+}
+
+func (s *sqliteStore) getEmailChange(ctx context.Context, where string, args ...interface{}) (*models.EmailChange, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary: the change may have been requested moments ago.
+	var c models.EmailChange
+	var confirmedAt, revertExpiresAt sql.NullTime
+	err := s.q.QueryRowContext(ctx,
+		`SELECT `+emailChangeColumns+` FROM email_changes WHERE `+where, args...,
+	).Scan(&c.UserID, &c.OldEmail, &c.NewEmail, &c.ConfirmTokenHash, &c.ConfirmExpiresAt,
+		&confirmedAt, &c.RevertTokenHash, &revertExpiresAt, &c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email change: %w", err)
+	}
+	if confirmedAt.Valid {
+		c.ConfirmedAt = &confirmedAt.Time
+	}
+	if revertExpiresAt.Valid {
+		c.RevertExpiresAt = &revertExpiresAt.Time
+	}
+	return &c, nil
+}
+
+func (s *sqliteStore) DeleteEmailChange(ctx context.Context, userID int64) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if _, err := s.q.ExecContext(ctx, `DELETE FROM email_changes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete email change: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) SaveOTPChallenge(ctx context.Context, c *models.OTPChallenge) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.q.ExecContext(ctx,
+		`INSERT OR REPLACE INTO otp_challenges (user_id, purpose, phone, code_hash, attempts, expires_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		c.UserID, c.Purpose, c.Phone, c.CodeHash, c.Attempts, c.ExpiresAt.UTC(), c.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to save otp challenge: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) GetOTPChallenge(ctx context.Context, userID int64, purpose string) (*models.OTPChallenge, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary: the challenge may have been sent moments ago.
+	var c models.OTPChallenge
+	err := s.q.QueryRowContext(ctx,
+		`SELECT user_id, purpose, phone, code_hash, attempts, expires_at, created_at
+		 FROM otp_challenges WHERE user_id = ? AND purpose = ?`, userID, purpose,
+	).Scan(&c.UserID, &c.Purpose, &c.Phone, &c.CodeHash, &c.Attempts, &c.ExpiresAt, &c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get otp challenge: %w", err)
+	}
+	return &c, nil
+}
+
+func (s *sqliteStore) DeleteOTPChallenge(ctx context.Context, userID int64, purpose string) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if _, err := s.q.ExecContext(ctx, `DELETE FROM otp_challenges WHERE user_id = ? AND purpose = ?`, userID, purpose); err != nil {
+		return fmt.Errorf("failed to delete otp challenge: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) SaveMagicLink(ctx context.Context, l *models.MagicLink) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if l.CreatedAt.IsZero() {
+		l.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.q.ExecContext(ctx,
+		`INSERT OR REPLACE INTO magic_links (user_id, token_hash, device_hash, expires_at, created_at)
+		 VALUES (?, ?, ?, ?, ?)`,
+		l.UserID, l.TokenHash, l.DeviceHash, l.ExpiresAt.UTC(), l.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to save magic link: %w", err)
+	}
+	return nil
+}
+
+// magicLinkColumns is the column list shared by magic link SELECTs.
+const magicLinkColumns = `user_id, token_hash, device_hash, expires_at, created_at`
+
+func (s *sqliteStore) getMagicLink(ctx context.Context, where string, arg interface{}) (*models.MagicLink, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary: the link may have been sent moments ago.
+	var l models.MagicLink
+	err := s.q.QueryRowContext(ctx, `SELECT `+magicLinkColumns+` FROM magic_links WHERE `+where+` = ?`, arg).
+		Scan(&l.UserID, &l.TokenHash, &l.DeviceHash, &l.ExpiresAt, &l.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get magic link: %w", err)
+	}
+	return &l, nil
+}
+
+func (s *sqliteStore) GetMagicLink(ctx context.Context, userID int64) (*models.MagicLink, error) {
+	return s.getMagicLink(ctx, "user_id", userID)
+}
+
+func (s *sqliteStore) GetMagicLinkByToken(ctx context.Context, hash string) (*models.MagicLink, error) {
+	if hash == "" {
+		return nil, nil
+	}
+	return s.getMagicLink(ctx, "token_hash", hash)
+}
+
+func (s *sqliteStore) UseMagicLink(ctx context.Context, hash string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM magic_links WHERE token_hash = ?`, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use magic link: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use magic link: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (s *sqliteStore) CreateCanary(ctx context.Context, c *models.Canary) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now().UTC()
+	}
+	result, err := s.q.ExecContext(ctx,
+		`INSERT INTO canaries (kind, user_id, jti, note, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		c.Kind, c.UserID, c.TokenID, c.Note, c.CreatedBy, c.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create canary: %w", err)
+	}
+	if c.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to create canary: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) ListCanaries(ctx context.Context) ([]models.Canary, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary: canaries must be recognized as soon as they
+	// are registered.
+	rows, err := s.q.QueryContext(ctx,
+		`SELECT id, kind, user_id, jti, note, created_by, created_at FROM canaries ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list canaries: %w", err)
+	}
+	defer rows.Close()
+
+	var canaries []models.Canary
+	for rows.Next() {
+		var c models.Canary
+		if err := rows.Scan(&c.ID, &c.Kind, &c.UserID, &c.TokenID, &c.Note, &c.CreatedBy, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan canary: %w", err)
+		}
+		canaries = append(canaries, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list canaries: %w", err)
+	}
+	return canaries, nil
+}
+
+func (s *sqliteStore) DeleteCanary(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM canaries WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete canary: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete canary: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) CreateWebhook(ctx context.Context, w *models.Webhook) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if w.CreatedAt.IsZero() {
+		w.CreatedAt = time.Now().UTC()
+	}
+	if w.UpdatedAt.IsZero() {
+		w.UpdatedAt = w.CreatedAt
+	}
+	result, err := s.q.ExecContext(ctx,
+		`INSERT INTO webhooks (url, events, secret, enabled, created_by, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		w.URL, strings.Join(w.Events, ","), w.Secret, w.Enabled, w.CreatedBy, w.CreatedAt.UTC(), w.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	if w.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+// webhookColumns is the column list shared by webhook SELECTs.
+const webhookColumns = `id, url, events, secret, enabled, created_by, created_at, updated_at`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (models.Webhook, error) {
+	var w models.Webhook
+	var events string
+	err := row.Scan(&w.ID, &w.URL, &events, &w.Secret, &w.Enabled, &w.CreatedBy, &w.CreatedAt, &w.UpdatedAt)
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return w, err
+}
+
+func (s *sqliteStore) GetWebhook(ctx context.Context, id int64) (*models.Webhook, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary, like ListWebhooks, so a webhook can be used as
+	// soon as it is registered.
+	w, err := scanWebhook(s.q.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	return &w, nil
+}
+
+func (s *sqliteStore) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	rows, err := s.q.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+func (s *sqliteStore) UpdateWebhook(ctx context.Context, w *models.Webhook) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	w.UpdatedAt = time.Now().UTC()
+	result, err := s.q.ExecContext(ctx,
+		`UPDATE webhooks SET url = ?, events = ?, secret = ?, enabled = ?, updated_at = ? WHERE id = ?`,
+		w.URL, strings.Join(w.Events, ","), w.Secret, w.Enabled, w.UpdatedAt, w.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) DeleteWebhook(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) RecordWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now().UTC()
+	}
+	result, err := s.q.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, event_id, event, url, payload, status_code, error, duration_ms, redelivery_of, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.WebhookID, d.EventID, d.Event, d.URL, string(d.Payload), d.StatusCode, d.Error, d.DurationMS, d.RedeliveryOf, d.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+	if d.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// webhookDeliveryColumns is the column list shared by webhook delivery
+// SELECTs.
+const webhookDeliveryColumns = `id, webhook_id, event_id, event, url, payload, status_code, error, duration_ms, redelivery_of, created_at`
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var payload string
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.URL, &payload, &d.StatusCode, &d.Error, &d.DurationMS, &d.RedeliveryOf, &d.CreatedAt)
+	d.Payload = []byte(payload)
+	return d, err
+}
+
+func (s *sqliteStore) GetWebhookDelivery(ctx context.Context, id int64) (*models.WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	d, err := scanWebhookDelivery(s.reader().QueryRowContext(ctx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+	return &d, nil
+}
+
+func (s *sqliteStore) ListWebhookDeliveries(ctx context.Context, webhookID string, limit, offset int) ([]models.WebhookDelivery, int, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	var total int
+	err := s.reader().QueryRowContext(ctx, `SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ?`, webhookID).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count webhook deliveries: %w", err)
+	}
+
+	if limit <= 0 {
+		limit = -1 // SQLite: no limit
+	}
+	rows, err := s.reader().QueryContext(ctx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE webhook_id = ?
+		 ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`, webhookID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return deliveries, total, nil
+}
+
+func (s *sqliteStore) PurgeWebhookDeliveries(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE created_at < ?`, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge webhook deliveries: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqliteStore) AddOutboxEvent(ctx context.Context, e *models.OutboxEvent) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	result, err := s.q.ExecContext(ctx,
+		`INSERT INTO outbox_events (event_id, type, payload, created_at, dispatched_at, claimed_until) VALUES (?, ?, ?, ?, ?, ?)`,
+		e.EventID, e.Type, string(e.Payload), e.CreatedAt.UTC(), utcOrNil(e.DispatchedAt), time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to add outbox event: %w", err)
+	}
+	if e.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to add outbox event: %w", err)
+	}
+	return nil
+}
+
+// outboxEventColumns is the column list shared by outbox SELECTs.
+const outboxEventColumns = `id, event_id, type, payload, created_at, dispatched_at`
+
+func queryOutboxEvents(ctx context.Context, q querier, query string, args ...interface{}) ([]models.OutboxEvent, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.OutboxEvent
+	for rows.Next() {
+		var e models.OutboxEvent
+		var payload string
+		var dispatchedAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.EventID, &e.Type, &payload, &e.CreatedAt, &dispatchedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		e.Payload = []byte(payload)
+		if dispatchedAt.Valid {
+			e.DispatchedAt = &dispatchedAt.Time
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list outbox events: %w", err)
+	}
+	return events, nil
+}
+
+func (s *sqliteStore) ClaimOutboxEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.OutboxEvent, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// A single statement, so concurrent claims never return the same event
+	events, err := queryOutboxEvents(ctx, s.q,
+		`UPDATE outbox_events SET claimed_until = ?
+		 WHERE id IN (SELECT id FROM outbox_events WHERE dispatched_at IS NULL AND claimed_until <= ? ORDER BY id LIMIT ?)
+		 RETURNING `+outboxEventColumns,
+		now.Add(lease).UTC(), now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(events, func(a, b models.OutboxEvent) int { return cmp.Compare(a.ID, b.ID) })
+	return events, nil
+}
+
+func (s *sqliteStore) MarkOutboxEventDispatched(ctx context.Context, id int64, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if _, err := s.q.ExecContext(ctx, `UPDATE outbox_events SET dispatched_at = ? WHERE id = ?`, at.UTC(), id); err != nil {
+		return fmt.Errorf("failed to mark outbox event dispatched: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) ListOutboxEvents(ctx context.Context, from, to time.Time, limit int) ([]models.OutboxEvent, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = -1 // SQLite: no limit
+	}
+	return queryOutboxEvents(ctx, s.reader(),
+		`SELECT `+outboxEventColumns+` FROM outbox_events WHERE created_at >= ? AND created_at < ? ORDER BY created_at, id LIMIT ?`,
+		from.UTC(), to.UTC(), limit)
+}
+
+func (s *sqliteStore) PurgeOutboxEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx,
+		`DELETE FROM outbox_events WHERE dispatched_at IS NOT NULL AND created_at < ?`, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge outbox events: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqliteStore) SetQuota(ctx context.Context, q *models.Quota) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	err := s.q.QueryRowContext(ctx,
+		`INSERT INTO quotas (subject, daily, monthly, updated_by, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(subject) DO UPDATE SET daily = excluded.daily, monthly = excluded.monthly,
+		   updated_by = excluded.updated_by, updated_at = excluded.updated_at
+		 RETURNING id, created_at, updated_at`,
+		q.Subject, q.Daily, q.Monthly, q.UpdatedBy, now, now,
+	).Scan(&q.ID, &q.CreatedAt, &q.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set quota: %w", err)
+	}
+	return nil
+}
+
+// quotaColumns is the column list shared by quota SELECTs.
+const quotaColumns = `id, subject, daily, monthly, updated_by, created_at, updated_at`
+
+func scanQuota(row interface{ Scan(...interface{}) error }) (models.Quota, error) {
+	var q models.Quota
+	err := row.Scan(&q.ID, &q.Subject, &q.Daily, &q.Monthly, &q.UpdatedBy, &q.CreatedAt, &q.UpdatedAt)
+	return q, err
+}
+
+func (s *sqliteStore) GetQuota(ctx context.Context, subject string) (*models.Quota, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary so an adjusted quota is shown at once.
+	q, err := scanQuota(s.q.QueryRowContext(ctx, `SELECT `+quotaColumns+` FROM quotas WHERE subject = ?`, subject))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota: %w", err)
+	}
+	return &q, nil
+}
+
+func (s *sqliteStore) ListQuotas(ctx context.Context) ([]models.Quota, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	rows, err := s.q.QueryContext(ctx, `SELECT `+quotaColumns+` FROM quotas ORDER BY subject`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quotas: %w", err)
+	}
+	defer rows.Close()
+
+	var quotas []models.Quota
+	for rows.Next() {
+		q, err := scanQuota(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan quota: %w", err)
+		}
+		quotas = append(quotas, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list quotas: %w", err)
+	}
+	return quotas, nil
+}
+
+func (s *sqliteStore) DeleteQuota(ctx context.Context, subject string) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM quotas WHERE subject = ?`, subject)
+	if err != nil {
+		return fmt.Errorf("failed to delete quota: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete quota: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) GetPreferences(ctx context.Context, userID int64) (*models.Preferences, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	var p models.Preferences
+	err := s.q.QueryRowContext(ctx,
+		`SELECT login_alerts, session_timeout_minutes, locale, updated_at FROM user_preferences WHERE user_id = ?`, userID,
+	).Scan(&p.LoginAlerts, &p.SessionTimeoutMinutes, &p.Locale, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
+	}
+	return &p, nil
+}
+
+func (s *sqliteStore) SetPreferences(ctx context.Context, userID int64, p *models.Preferences) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	p.UpdatedAt = time.Now().UTC()
+	_, err := s.q.ExecContext(ctx,
+		`INSERT INTO user_preferences (user_id, login_alerts, session_timeout_minutes, locale, updated_at) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET login_alerts = excluded.login_alerts,
+		   session_timeout_minutes = excluded.session_timeout_minutes, locale = excluded.locale, updated_at = excluded.updated_at`,
+		userID, p.LoginAlerts, p.SessionTimeoutMinutes, p.Locale, p.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set preferences: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) CreateElevation(ctx context.Context, e *models.Elevation) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	result, err := s.q.ExecContext(ctx,
+		`INSERT INTO elevations (user_id, scopes, reason, duration_seconds, status, decided_by, decided_at, expires_at, jti, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.UserID, strings.Join(e.Scopes, ","), e.Reason, e.DurationSeconds, e.Status, e.DecidedBy,
+		utcOrNil(e.DecidedAt), utcOrNil(e.ExpiresAt), e.TokenID, e.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create elevation: %w", err)
+	}
+	if e.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to create elevation: %w", err)
+	}
+	return nil
+}
+
+// elevationColumns is the column list shared by elevation SELECTs.
+const elevationColumns = `id, user_id, scopes, reason, duration_seconds, status, decided_by, decided_at, expires_at, jti, created_at`
+
+func scanElevation(row interface{ Scan(...interface{}) error }) (models.Elevation, error) {
+	var e models.Elevation
+	var scopes string
+	var decidedAt, expiresAt sql.NullTime
+	err := row.Scan(&e.ID, &e.UserID, &scopes, &e.Reason, &e.DurationSeconds, &e.Status, &e.DecidedBy,
+		&decidedAt, &expiresAt, &e.TokenID, &e.CreatedAt)
+	if scopes != "" {
+		e.Scopes = strings.Split(scopes, ",")
+	}
+	if decidedAt.Valid {
+		e.DecidedAt = &decidedAt.Time
+	}
+	if expiresAt.Valid {
+		e.ExpiresAt = &expiresAt.Time
+	}
+	return e, err
+}
+
+func (s *sqliteStore) GetElevation(ctx context.Context, id int64) (*models.Elevation, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary: the request may have been decided moments ago.
+	e, err := scanElevation(s.q.QueryRowContext(ctx, `SELECT `+elevationColumns+` FROM elevations WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get elevation: %w", err)
+	}
+	return &e, nil
+}
+
+func (s *sqliteStore) ListElevations(ctx context.Context, status string, limit int) ([]models.Elevation, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	rows, err := s.q.QueryContext(ctx,
+		`SELECT `+elevationColumns+` FROM elevations WHERE ? = '' OR status = ? ORDER BY id DESC LIMIT ?`,
+		status, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list elevations: %w", err)
+	}
+	defer rows.Close()
+
+	var elevations []models.Elevation
+	for rows.Next() {
+		e, err := scanElevation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan elevation: %w", err)
+		}
+		elevations = append(elevations, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list elevations: %w", err)
+	}
+	return elevations, nil
+}
+
+func (s *sqliteStore) DecideElevation(ctx context.Context, e *models.Elevation) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx,
+		`UPDATE elevations SET status = ?, decided_by = ?, decided_at = ?, expires_at = ? WHERE id = ? AND status = ?`,
+		e.Status, e.DecidedBy, utcOrNil(e.DecidedAt), utcOrNil(e.ExpiresAt), e.ID, models.ElevationPending)
+	if err != nil {
+		return fmt.Errorf("failed to decide elevation: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to decide elevation: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) CreateStatusChange(ctx context.Context, c *models.StatusChange) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now().UTC()
+	}
+	result, err := s.q.ExecContext(ctx,
+		`INSERT INTO status_changes (user_id, action, run_at, reason, notify, status, created_by, completed_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.UserID, c.Action, c.RunAt.UTC(), c.Reason, c.Notify, c.Status, c.CreatedBy, utcOrNil(c.CompletedAt), c.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create status change: %w", err)
+	}
+	if c.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to create status change: %w", err)
+	}
+	return nil
+}
+
+// statusChangeColumns is the column list shared by status change SELECTs.
+const statusChangeColumns = `id, user_id, action, run_at, reason, notify, status, created_by, completed_at, created_at`
+
+func scanStatusChange(row interface{ Scan(...interface{}) error }) (models.StatusChange, error) {
+	var c models.StatusChange
+	var completedAt sql.NullTime
+	err := row.Scan(&c.ID, &c.UserID, &c.Action, &c.RunAt, &c.Reason, &c.Notify, &c.Status, &c.CreatedBy, &completedAt, &c.CreatedAt)
+	if completedAt.Valid {
+		c.CompletedAt = &completedAt.Time
+	}
+	return c, err
+}
+
+func (s *sqliteStore) GetStatusChange(ctx context.Context, id int64) (*models.StatusChange, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary: the change may have been applied moments ago.
+	c, err := scanStatusChange(s.q.QueryRowContext(ctx, `SELECT `+statusChangeColumns+` FROM status_changes WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status change: %w", err)
+	}
+	return &c, nil
+}
+
+func (s *sqliteStore) ListStatusChanges(ctx context.Context, userID int64) ([]models.StatusChange, error) {
+	return s.listStatusChanges(ctx, `WHERE user_id = ? ORDER BY run_at, id`, userID)
+}
+
+func (s *sqliteStore) ListDueStatusChanges(ctx context.Context, now time.Time, limit int) ([]models.StatusChange, error) {
+	return s.listStatusChanges(ctx, `WHERE status = ? AND run_at <= ? ORDER BY run_at, id LIMIT ?`,
+		models.StatusChangePending, now.UTC(), limit)
+}
+
+// listStatusChanges returns the status changes selected by where, a WHERE
+// clause with its ORDER BY.
+func (s *sqliteStore) listStatusChanges(ctx context.Context, where string, args ...interface{}) ([]models.StatusChange, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	rows, err := s.q.QueryContext(ctx, `SELECT `+statusChangeColumns+` FROM status_changes `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list status changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []models.StatusChange
+	for rows.Next() {
+		c, err := scanStatusChange(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan status change: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list status changes: %w", err)
+	}
+	return changes, nil
+}
+
+func (s *sqliteStore) CompleteStatusChange(ctx context.Context, id int64, status string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx,
+		`UPDATE status_changes SET status = ?, completed_at = ? WHERE id = ? AND status = ?`,
+		status, at.UTC(), id, models.StatusChangePending)
+	if err != nil {
+		return fmt.Errorf("failed to complete status change: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to complete status change: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) RedeemElevation(ctx context.Context, id int64, jti string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx,
+		`UPDATE elevations SET jti = ? WHERE id = ? AND status = ? AND jti = ''`,
+		jti, id, models.ElevationApproved)
+	if err != nil {
+		return false, fmt.Errorf("failed to redeem elevation: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to redeem elevation: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (s *sqliteStore) SetFeatureFlag(ctx context.Context, f *models.FeatureFlag) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	err := s.q.QueryRowContext(ctx,
+		`INSERT INTO feature_flags (name, enabled, percent, subjects, updated_by, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(name) DO UPDATE SET enabled = excluded.enabled, percent = excluded.percent,
+		   subjects = excluded.subjects, updated_by = excluded.updated_by, updated_at = excluded.updated_at
+		 RETURNING id, created_at, updated_at`,
+		f.Name, f.Enabled, f.Percent, strings.Join(f.Subjects, ","), f.UpdatedBy, now, now,
+	).Scan(&f.ID, &f.CreatedAt, &f.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set feature flag: %w", err)
+	}
+	return nil
+}
+
+// featureFlagColumns is the column list shared by feature flag SELECTs.
+const featureFlagColumns = `id, name, enabled, percent, subjects, updated_by, created_at, updated_at`
+
+func scanFeatureFlag(row interface{ Scan(...interface{}) error }) (models.FeatureFlag, error) {
+	var f models.FeatureFlag
+	var subjects string
+	err := row.Scan(&f.ID, &f.Name, &f.Enabled, &f.Percent, &subjects, &f.UpdatedBy, &f.CreatedAt, &f.UpdatedAt)
+	if subjects != "" {
+		f.Subjects = strings.Split(subjects, ",")
+	}
+	return f, err
+}
+
+func (s *sqliteStore) GetFeatureFlag(ctx context.Context, name string) (*models.FeatureFlag, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary so a changed override is shown at once.
+	f, err := scanFeatureFlag(s.q.QueryRowContext(ctx, `SELECT `+featureFlagColumns+` FROM feature_flags WHERE name = ?`, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feature flag: %w", err)
+	}
+	return &f, nil
+}
+
+func (s *sqliteStore) ListFeatureFlags(ctx context.Context) ([]models.FeatureFlag, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	rows, err := s.q.QueryContext(ctx, `SELECT `+featureFlagColumns+` FROM feature_flags ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list feature flags: %w", err)
+	}
+	defer rows.Close()
+
+	var flags []models.FeatureFlag
+	for rows.Next() {
+		f, err := scanFeatureFlag(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan feature flag: %w", err)
+		}
+		flags = append(flags, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list feature flags: %w", err)
+	}
+	return flags, nil
+}
+
+func (s *sqliteStore) DeleteFeatureFlag(ctx context.Context, name string) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM feature_flags WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete feature flag: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete feature flag: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) SetClient(ctx context.Context, c *models.Client) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	err := s.q.QueryRowContext(ctx,
+		`INSERT INTO clients (client_id, name, access_token_ttl, refresh_token_ttl, refresh_policy, allowed_scopes, cors_origins, updated_by, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(client_id) DO UPDATE SET name = excluded.name, access_token_ttl = excluded.access_token_ttl,
+		   refresh_token_ttl = excluded.refresh_token_ttl, refresh_policy = excluded.refresh_policy,
+		   allowed_scopes = excluded.allowed_scopes, cors_origins = excluded.cors_origins,
+		   updated_by = excluded.updated_by, updated_at = excluded.updated_at
+		 RETURNING id, created_at, updated_at`,
+		c.ClientID, c.Name, c.AccessTokenTTL, c.RefreshTokenTTL, c.RefreshPolicy,
+		strings.Join(c.AllowedScopes, ","), strings.Join(c.CORSOrigins, ","), c.UpdatedBy, now, now,
+	).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set client: %w", err)
+	}
+	return nil
+}
+
+// clientColumns is the column list shared by client SELECTs.
+const clientColumns = `id, client_id, name, access_token_ttl, refresh_token_ttl, refresh_policy, allowed_scopes, cors_origins, updated_by, created_at, updated_at`
+
+func scanClient(row interface{ Scan(...interface{}) error }) (models.Client, error) {
+	var c models.Client
+	var scopes, origins string
+	err := row.Scan(&c.ID, &c.ClientID, &c.Name, &c.AccessTokenTTL, &c.RefreshTokenTTL, &c.RefreshPolicy,
+		&scopes, &origins, &c.UpdatedBy, &c.CreatedAt, &c.UpdatedAt)
+	if scopes != "" {
+		c.AllowedScopes = strings.Split(scopes, ",")
+	}
+	if origins != "" {
+		c.CORSOrigins = strings.Split(origins, ",")
+	}
+	return c, err
+}
+
+func (s *sqliteStore) GetClient(ctx context.Context, clientID string) (*models.Client, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// Read from the primary so a changed client is shown at once.
+	c, err := scanClient(s.q.QueryRowContext(ctx, `SELECT `+clientColumns+` FROM clients WHERE client_id = ?`, clientID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+	return &c, nil
+}
+
+func (s *sqliteStore) ListClients(ctx context.Context) ([]models.Client, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	rows, err := s.q.QueryContext(ctx, `SELECT `+clientColumns+` FROM clients ORDER BY client_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clients: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []models.Client
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan client: %w", err)
+		}
+		clients = append(clients, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list clients: %w", err)
+	}
+	return clients, nil
+}
+
+func (s *sqliteStore) DeleteClient(ctx context.Context, clientID string) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM clients WHERE client_id = ?`, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) IncrementQuotaUsage(ctx context.Context, subject, period string, expiresAt time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	var count int64
+	err := s.q.QueryRowContext(ctx,
+		`INSERT INTO quota_usage (subject, period, count, expires_at) VALUES (?, ?, 1, ?)
+		 ON CONFLICT(subject, period) DO UPDATE SET count = count + 1
+		 RETURNING count`,
+		subject, period, expiresAt.UTC(),
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count quota usage: %w", err)
+	}
+	return count, nil
+}
+
+func (s *sqliteStore) QuotaUsage(ctx context.Context, subject, period string) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	var count int64
+	err := s.q.QueryRowContext(ctx,
+		`SELECT count FROM quota_usage WHERE subject = ? AND period = ?`, subject, period).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get quota usage: %w", err)
+	}
+	return count, nil
+}
+
+func (s *sqliteStore) PurgeQuotaUsage(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM quota_usage WHERE expires_at < ?`, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge quota usage: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqliteStore) TakeRateLimitToken(ctx context.Context, key string, rate time.Duration, capacity int, now time.Time) (bool, int, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// All SET expressions see the row as it was before the update. A clock
+	// behind the last refill adds nothing rather than removing tokens.
+	// refilled_at advances by whole tokens only, so partial tokens carry
+	// over, except that a full bucket accrues nothing and starts over at
+	// now.
+	var taken bool
+	var tokens int
+	err := s.q.QueryRowContext(ctx,
+		`INSERT INTO rate_limits (key, tokens, refilled_at, taken, full_at) VALUES (?1, ?4 - 1, ?2, 1, ?5)
+		 ON CONFLICT(key) DO UPDATE SET
+			tokens = MAX(MIN(tokens + MAX((?2 - refilled_at) / ?3, 0), ?4) - 1, 0),
+			taken = MIN(tokens + MAX((?2 - refilled_at) / ?3, 0), ?4) > 0,
+			refilled_at = CASE
+				WHEN tokens + MAX((?2 - refilled_at) / ?3, 0) >= ?4 THEN ?2
+				ELSE refilled_at + MAX((?2 - refilled_at) / ?3, 0) * ?3
+			END,
+			full_at = ?5
+		 RETURNING taken, tokens`,
+		key, now.UnixNano(), int64(rate), capacity, now.Add(time.Duration(capacity)*rate).UnixNano(),
+	).Scan(&taken, &tokens)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+	return taken, tokens, nil
+}
+
+func (s *sqliteStore) PurgeRateLimits(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM rate_limits WHERE full_at < ?`, now.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge rate limits: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqliteStore) RecordTarpitFailure(ctx context.Context, key string, window time.Duration, now time.Time) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	_, err := s.q.ExecContext(ctx,
+		`INSERT INTO tarpit_failures (key, failures, last_at) VALUES (?1, 1, ?2)
+		 ON CONFLICT(key) DO UPDATE SET
+			failures = CASE WHEN ?2 - last_at > ?3 THEN 1 ELSE failures + 1 END,
+			last_at = ?2`,
+		key, now.UnixNano(), int64(window))
+	if err != nil {
+		return fmt.Errorf("failed to record tarpit failure: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) TarpitFailures(ctx context.Context, keys []string, window time.Duration, now time.Time) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	args := []any{now.UnixNano() - int64(window)}
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	var worst int
+	err := s.q.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(failures), 0) FROM tarpit_failures
+		 WHERE last_at >= ? AND key IN (?`+strings.Repeat(", ?", len(keys)-1)+`)`,
+		args...).Scan(&worst)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get tarpit failures: %w", err)
+	}
+	return worst, nil
+}
+
+func (s *sqliteStore) ClearTarpitFailures(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	args := make([]any, len(keys))
+	for i, k := range keys {
+		args[i] = k
+	}
+	_, err := s.q.ExecContext(ctx,
+		`DELETE FROM tarpit_failures WHERE key IN (?`+strings.Repeat(", ?", len(keys)-1)+`)`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to clear tarpit failures: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) PurgeTarpitFailures(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM tarpit_failures WHERE last_at < ?`, cutoff.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge tarpit failures: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqliteStore) UseNonce(ctx context.Context, key string, expiresAt, now time.Time) (bool, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// An expired nonce that has not been purged yet may be used again
+	result, err := s.q.ExecContext(ctx,
+		`INSERT INTO request_nonces (key, expires_at) VALUES (?, ?)
+		 ON CONFLICT(key) DO UPDATE SET expires_at = excluded.expires_at WHERE request_nonces.expires_at <= ?`,
+		key, expiresAt.UnixNano(), now.UnixNano())
+	if err != nil {
+		return false, fmt.Errorf("failed to use nonce: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use nonce: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (s *sqliteStore) PurgeNonces(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, `DELETE FROM request_nonces WHERE expires_at <= ?`, now.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge nonces: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqliteStore) AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	// The update is skipped, and no row returned, while another holder's
+	// lease is live.
+	var got string
+	err := s.q.QueryRowContext(ctx,
+		`INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		 ON CONFLICT(name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		 WHERE leases.holder = excluded.holder OR leases.expires_at <= ?
+		 RETURNING holder`,
+		name, holder, now.Add(ttl).UTC(), now.UTC(),
+	).Scan(&got)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	return true, nil
+}
+
+func (s *sqliteStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	if _, err := s.q.ExecContext(ctx, `DELETE FROM leases WHERE name = ? AND holder = ?`, name, holder); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) CountUsers(ctx context.Context, filter UserFilter) (int, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	where, args := userFilterClause(filter)
+	var n int
+	if err := s.reader().QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE `+where, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return n, nil
+}
+
+func (s *sqliteStore) ListUserIDs(ctx context.Context, filter UserFilter, limit int) ([]int64, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	where, args := userFilterClause(filter)
+	rows, err := s.reader().QueryContext(ctx, `SELECT id FROM users WHERE `+where+` ORDER BY id LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan user ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	return ids, nil
+}
+
+func (s *sqliteStore) ListExpiringUsers(ctx context.Context, before time.Time, limit int) ([]*models.User, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	rows, err := s.reader().QueryContext(ctx, `SELECT `+userColumns+` FROM users
+		WHERE disabled = 0 AND expires_at IS NOT NULL AND expires_at < ?
+		ORDER BY expires_at, id LIMIT ?`, before.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expiring users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		u, err := s.scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list expiring users: %w", err)
+	}
+	return users, nil
+}
+
+// userFilterClause returns the WHERE condition selecting the users f
+// matches, with its arguments.
+func userFilterClause(f UserFilter) (string, []interface{}) {
+	where := `1 = 1`
+	var args []interface{}
+	if f.Role != "" {
+		where += ` AND role = ?`
+		args = append(args, f.Role)
+	}
+	if f.Disabled != nil {
+		where += ` AND disabled = ?`
+		args = append(args, *f.Disabled)
+	}
+	if !f.CreatedAfter.IsZero() {
+		where += ` AND created_at >= ?`
+		args = append(args, f.CreatedAfter.UTC())
+	}
+	if !f.CreatedBefore.IsZero() {
+		where += ` AND created_at < ?`
+		args = append(args, f.CreatedBefore.UTC())
+	}
+	if !f.ExpiresBefore.IsZero() {
+		where += ` AND expires_at IS NOT NULL AND expires_at < ?`
+		args = append(args, f.ExpiresBefore.UTC())
+	}
+	return where, args
+}
+
+func (s *sqliteStore) Stats(ctx context.Context) (*Stats, error) {
+	ctx, cancel := withTimeout(ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	var st Stats
+	err := s.reader().QueryRowContext(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(disabled != 0), 0), COALESCE(SUM(role = 'admin'), 0) FROM users`,
+	).Scan(&st.Users, &st.DisabledUsers, &st.Admins)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	// A session is the newest token of a rotation chain.
+	err = s.reader().QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM refresh_tokens t
+		 WHERE t.expires_at > ?
+		   AND NOT EXISTS (SELECT 1 FROM refresh_tokens c WHERE c.parent_jti = t.jti)
+		   AND NOT EXISTS (SELECT 1 FROM revoked_tokens r WHERE r.jti = t.jti)`,
+		time.Now().UTC()).Scan(&st.ActiveSessions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sessions: %w", err)
+	}
+	return &st, nil
+}
+
+// nullIfEmpty maps an empty string to NULL for storage, so that accounts
+// without an email address (guests) do not collide on the unique index.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// utcOrNil converts an optional time for storage, mapping nil to NULL.
+func utcOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
@@ -0,0 +1,1783 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/pii"
+)
+
+func newTestSQLite(t testing.TB) Store {
+	t.Helper()
+	s, err := NewSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLite error: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestWithTx(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+
+		// A failing callback rolls back every write made through tx
+		boom := errors.New("boom")
+		err := s.WithTx(ctx, func(tx Store) error {
+			if _, err := tx.CreateUser(ctx, &models.User{Username: "rolledback", Email: "r@example.com", Password: "h"}); err != nil {
+				return err
+			}
+			return boom
+		})
+		if !errors.Is(err, boom) {
+			t.Fatalf("%s: expected callback error, got %v", name, err)
+		}
+		if u, _ := s.GetUserByUsername(ctx, "rolledback"); u != nil {
+			t.Fatalf("%s: expected rollback to discard user, found %+v", name, u)
+		}
+
+		// A successful callback commits, and nested WithTx joins the outer transaction
+		err = s.WithTx(ctx, func(tx Store) error {
+			if _, err := tx.CreateUser(ctx, &models.User{Username: "committed", Email: "c@example.com", Password: "h"}); err != nil {
+				return err
+			}
+			return tx.WithTx(ctx, func(inner Store) error {
+				u, err := inner.GetUserByUsername(ctx, "committed")
+				if err != nil || u == nil {
+					return errors.New("nested transaction did not see outer write")
+				}
+				u.Metadata = map[string]interface{}{"locale": "en"}
+				return inner.UpdateUser(ctx, u)
+			})
+		})
+		if err != nil {
+			t.Fatalf("%s: WithTx error: %v", name, err)
+		}
+		u, err := s.GetUserByUsername(ctx, "committed")
+		if err != nil || u == nil {
+			t.Fatalf("%s: expected committed user, got %v, %v", name, u, err)
+		}
+		if u.Metadata["locale"] != "en" {
+			t.Fatalf("%s: expected metadata written in nested tx, got %v", name, u.Metadata)
+		}
+	}
+}
+
+func TestUserUniqueness(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		id, err := s.CreateUser(ctx, &models.User{Username: "alice", Email: "alice@example.com", Password: "h"})
+		if err != nil {
+			t.Fatalf("%s: CreateUser: %v", name, err)
+		}
+		for _, u := range []*models.User{
+			{Username: "ALICE", Email: "other@example.com", Password: "h"},
+			{Username: "alice2", Email: "Alice@Example.com", Password: "h"},
+			{Username: "nopassword", Email: "np@example.com"},
+		} {
+			if _, err := s.CreateUser(ctx, u); err == nil {
+				t.Errorf("%s: expected CreateUser(%q, %q) to fail", name, u.Username, u.Email)
+			}
+		}
+		if u, _ := s.GetUserByUsername(ctx, "Alice"); u == nil || u.ID != id {
+			t.Errorf("%s: usernames should match case-insensitively, got %+v", name, u)
+		}
+
+		// Guests have no email and do not collide with each other.
+		var guests []int64
+		for _, username := range []string{"guest-1", "guest-2"} {
+			gid, err := s.CreateUser(ctx, &models.User{Username: username, Password: "h", Role: models.RoleGuest})
+			if err != nil {
+				t.Fatalf("%s: CreateUser(%s): %v", name, username, err)
+			}
+			guests = append(guests, gid)
+		}
+
+		bob, _ := s.GetUserByID(ctx, guests[0])
+		bob.Username, bob.Email, bob.Role = "bob", "ALICE@example.com", "user"
+		if err := s.UpgradeGuest(ctx, bob); err == nil {
+			t.Errorf("%s: expected upgrading a guest to a taken email to fail", name)
+		}
+		bob.Email = "bob@example.com"
+		if err := s.UpgradeGuest(ctx, bob); err != nil {
+			t.Fatalf("%s: UpgradeGuest: %v", name, err)
+		}
+		bob.Email = "alice@EXAMPLE.com"
+		if err := s.UpdateUser(ctx, bob); err == nil {
+			t.Errorf("%s: expected changing to a taken email to fail", name)
+		}
+		bob.Email = "bob@example.com"
+		if err := s.UpdateUser(ctx, bob); err != nil {
+			t.Errorf("%s: keeping one's own email: %v", name, err)
+		}
+
+		// Concurrent sign-ups for one username: exactly one wins.
+		var wg sync.WaitGroup
+		var created atomic.Int32
+		for i := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				u := &models.User{Username: "racer", Email: fmt.Sprintf("racer%d@example.com", i), Password: "h"}
+				if _, err := s.CreateUser(ctx, u); err == nil {
+					created.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := created.Load(); n != 1 {
+			t.Errorf("%s: %d concurrent sign-ups succeeded, want 1", name, n)
+		}
+	}
+}
+
+func TestSQLiteMigrationsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migrate.db")
+	for i := 0; i < 2; i++ {
+		s, err := NewSQLite(path)
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		s.Close()
+	}
+}
+
+func TestSQLiteReplicaRouting(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	// Prepare a "replica" holding a user the primary does not have
+	replicaPath := filepath.Join(dir, "replica.db")
+	seed, err := NewSQLite(replicaPath)
+	if err != nil {
+		t.Fatalf("seed replica: %v", err)
+	}
+	seed.CreateUser(ctx, &models.User{Username: "onreplica", Email: "r@example.com", Password: "h"})
+	setBeat := func(at time.Time) {
+		_, err := seed.(*sqliteStore).db.Exec(`INSERT INTO replication_heartbeat (id, beat_at) VALUES (1, ?)
+			ON CONFLICT(id) DO UPDATE SET beat_at = excluded.beat_at`, at.UnixMilli())
+		if err != nil {
+			t.Fatalf("set heartbeat: %v", err)
+		}
+	}
+	setBeat(time.Now())
+	defer seed.Close()
+
+	s, err := NewSQLiteWithOptions(filepath.Join(dir, "primary.db"), SQLiteOptions{
+		ReplicaURLs:          []string{replicaPath},
+		MaxReplicaLag:        time.Minute,
+		ReplicaCheckInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewSQLiteWithOptions: %v", err)
+	}
+	defer s.Close()
+
+	// A fresh replica serves reads
+	if u, err := s.GetUserByUsername(ctx, "onreplica"); err != nil || u == nil {
+		t.Fatalf("expected read routed to replica, got %v, %v", u, err)
+	}
+
+	// Reads inside a transaction always use the primary
+	s.WithTx(ctx, func(tx Store) error {
+		if u, _ := tx.GetUserByUsername(ctx, "onreplica"); u != nil {
+			t.Fatalf("expected transactional read to use primary")
+		}
+		return nil
+	})
+
+	// A lagging replica is skipped in favour of the primary
+	setBeat(time.Now().Add(-time.Hour))
+	s.(*sqliteStore).replicas.check()
+	if u, _ := s.GetUserByUsername(ctx, "onreplica"); u != nil {
+		t.Fatalf("expected lagging replica to be bypassed")
+	}
+}
+
+func TestSQLiteBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	s := newTestSQLite(t)
+	s.CreateUser(ctx, &models.User{Username: "before", Email: "b@example.com", Password: "h"})
+
+	backupPath := filepath.Join(dir, "snapshot.db")
+	if err := s.(Backupper).Backup(ctx, backupPath); err != nil {
+		t.Fatalf("Backup error: %v", err)
+	}
+	if err := s.(Backupper).Backup(ctx, backupPath); err == nil {
+		t.Fatalf("expected backup to refuse overwriting an existing file")
+	}
+
+	s.CreateUser(ctx, &models.User{Username: "after", Email: "a@example.com", Password: "h"})
+	if err := s.(Backupper).Restore(ctx, backupPath); err != nil {
+		t.Fatalf("Restore error: %v", err)
+	}
+	if u, _ := s.GetUserByUsername(ctx, "before"); u == nil {
+		t.Fatalf("expected snapshot user after restore")
+	}
+	if u, _ := s.GetUserByUsername(ctx, "after"); u != nil {
+		t.Fatalf("expected post-snapshot user to be gone after restore")
+	}
+}
+
+func TestQueryInstrumentation(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+
+	if _, err := s.CreateUser(ctx, &models.User{Username: "metrics", Email: "m@example.com", Password: "h"}); err != nil {
+		t.Fatalf("CreateUser error: %v", err)
+	}
+
+	var out strings.Builder
+	if _, err := metrics.Default.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	for _, want := range []string{
+		`sentinel_db_query_duration_seconds_count{pool="primary",operation="insert_users"}`,
+		`sentinel_db_open_connections{pool="primary"}`,
+		`# TYPE sentinel_db_wait_count_total counter`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+
+	cases := map[string]string{
+		"SELECT " + userColumns + " FROM users WHERE id = ?": "select_users",
+		"UPDATE users SET role = ? WHERE id = ?":             "update_users",
+		"INSERT INTO replication_heartbeat (id) VALUES (1)":  "insert_replication_heartbeat",
+		"PRAGMA user_version":                                "pragma",
+	}
+	for query, want := range cases {
+		if got := queryOperation(query); got != want {
+			t.Errorf("queryOperation(%q) = %q, want %q", query, got, want)
+		}
+	}
+
+	got := redactStatement("SELECT *\n\t FROM users WHERE email = 'alice@example.com' AND id = 42 AND role = ?")
+	if want := "SELECT * FROM users WHERE email = ? AND id = ? AND role = ?"; got != want {
+		t.Errorf("redactStatement = %q, want %q", got, want)
+	}
+}
+
+func TestSQLiteUpdateUserVersion(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+
+	u := &models.User{Username: "versioned", Email: "v@example.com", Password: "h"}
+	if _, err := s.CreateUser(ctx, u); err != nil {
+		t.Fatalf("CreateUser error: %v", err)
+	}
+	if u.Version != 1 {
+		t.Fatalf("expected version 1 after create, got %d", u.Version)
+	}
+
+	first, _ := s.GetUserByID(ctx, u.ID)
+	second, _ := s.GetUserByID(ctx, u.ID)
+
+	first.Role = "admin"
+	if err := s.UpdateUser(ctx, first); err != nil {
+		t.Fatalf("UpdateUser error: %v", err)
+	}
+	if first.Version != 2 {
+		t.Fatalf("expected version 2 after update, got %d", first.Version)
+	}
+
+	second.Email = "other@example.com"
+	if err := s.UpdateUser(ctx, second); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict for stale update, got %v", err)
+	}
+
+	missing := &models.User{ID: 9999, Version: 1}
+	if err := s.UpdateUser(ctx, missing); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for missing user, got %v", err)
+	}
+}
+
+func TestSQLiteSearchUsers(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+
+	for _, u := range []*models.User{
+		{Username: "alice", Email: "alice@example.com", Password: "h"},
+		{Username: "alicia", Email: "alicia@corp.example", Password: "h"},
+		{Username: "bob", Email: "bob@corp.example", Password: "h"},
+		{Username: "malice", Email: "m@example.com", Password: "h"},
+	} {
+		if _, err := s.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser error: %v", err)
+		}
+	}
+
+	search := func(q string, limit, offset int) ([]UserSearchHit, int) {
+		t.Helper()
+		hits, total, err := s.SearchUsers(ctx, q, limit, offset)
+		if err != nil {
+			t.Fatalf("SearchUsers(%q) error: %v", q, err)
+		}
+		return hits, total
+	}
+
+	// Exact match ranks first, then substring, then near misses
+	hits, total := search("alice", 10, 0)
+	if total != 3 || hits[0].User.Username != "alice" || hits[1].User.Username != "malice" || hits[2].User.Username != "alicia" {
+		t.Fatalf("unexpected results for alice: total=%d %+v", total, hits)
+	}
+	if got := hits[1].Highlights["username"]; got != "m<mark>alice</mark>" {
+		t.Errorf("unexpected highlight %q", got)
+	}
+
+	// Typos still find the user
+	hits, _ = search("alcie", 10, 0)
+	if len(hits) == 0 || hits[0].User.Username != "alice" {
+		t.Fatalf("expected fuzzy match for alcie, got %+v", hits)
+	}
+
+	// Email substrings match through the trigram index
+	hits, total = search("corp.example", 1, 0)
+	if total != 2 || len(hits) != 1 {
+		t.Fatalf("expected 2 total with page size 1, got total=%d len=%d", total, len(hits))
+	}
+	hits, _ = search("corp.example", 1, 1)
+	if len(hits) != 1 {
+		t.Fatalf("expected second page, got %+v", hits)
+	}
+
+	// The index follows updates and deletes
+	bob, _ := s.GetUserByUsername(ctx, "bob")
+	bob.Email = "robert@home.example"
+	if err := s.UpdateUser(ctx, bob); err != nil {
+		t.Fatalf("UpdateUser error: %v", err)
+	}
+	if _, total = search("home.example", 10, 0); total != 1 {
+		t.Fatalf("expected updated email to be searchable, got %d", total)
+	}
+	if err := s.DeleteUser(ctx, bob.ID); err != nil {
+		t.Fatalf("DeleteUser error: %v", err)
+	}
+	if _, total = search("home.example", 10, 0); total != 0 {
+		t.Fatalf("expected deleted user to disappear from search, got %d", total)
+	}
+
+	if _, _, err := s.SearchUsers(ctx, "  ", 10, 0); !errors.Is(err, ErrEmptySearch) {
+		t.Fatalf("expected ErrEmptySearch, got %v", err)
+	}
+}
+
+func TestSQLiteAuditLog(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	events := []*models.AuditEvent{
+		{ActorID: 1, Action: "user.disable", TargetType: "user", TargetID: 7, CreatedAt: base,
+			Changes: []models.FieldChange{{Field: "disabled", Before: false, After: true}}},
+		{ActorID: 2, Action: "user.role.assign", TargetType: "user", TargetID: 7, CreatedAt: base.Add(time.Hour)},
+		{ActorID: 1, Action: "user.delete", TargetType: "user", TargetID: 8, CreatedAt: base.Add(2 * time.Hour), TokenID: "jti-1"},
+	}
+	for _, e := range events {
+		if err := s.RecordAudit(ctx, e); err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+	}
+
+	got, total, err := s.ListAuditEvents(ctx, AuditFilter{TargetID: 7})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if total != 2 || len(got) != 2 || got[0].Action != "user.role.assign" {
+		t.Fatalf("expected 2 events for target 7, newest first, got %d: %+v", total, got)
+	}
+	if c := got[1].Changes; len(c) != 1 || c[0].Field != "disabled" || c[0].After != true {
+		t.Errorf("changes not round-tripped: %+v", c)
+	}
+
+	got, total, err = s.ListAuditEvents(ctx, AuditFilter{ActorID: 1, Since: base.Add(time.Minute), Limit: 10})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if total != 1 || got[0].Action != "user.delete" {
+		t.Fatalf("expected only the later event by actor 1, got %d: %+v", total, got)
+	}
+
+	got, total, _ = s.ListAuditEvents(ctx, AuditFilter{Limit: 1, Offset: 1})
+	if total != 3 || len(got) != 1 || got[0].Action != "user.role.assign" {
+		t.Fatalf("unexpected page: total %d, %+v", total, got)
+	}
+
+	got, total, _ = s.ListAuditEvents(ctx, AuditFilter{TokenID: "jti-1"})
+	if total != 1 || got[0].Action != "user.delete" || got[0].TokenID != "jti-1" {
+		t.Fatalf("expected the event made with jti-1, got %d: %+v", total, got)
+	}
+}
+
+func TestRecordAudits(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		// An invalid event rolls back the whole batch
+		err := s.RecordAudits(ctx, []*models.AuditEvent{
+			{ActorID: 1, Action: "user.login", TargetType: "user", TargetID: 1},
+			{ActorID: 1, Action: "", TargetType: "user", TargetID: 1},
+		})
+		if err == nil {
+			t.Fatalf("%s: expected an error for an event without an action", name)
+		}
+		if _, total, _ := s.ListAuditEvents(ctx, AuditFilter{}); total != 0 {
+			t.Fatalf("%s: expected no events after a failed batch, got %d", name, total)
+		}
+
+		events := []*models.AuditEvent{
+			{ActorID: 1, Action: "user.login", TargetType: "user", TargetID: 1},
+			{ActorID: 2, Action: "user.login.failed", TargetType: "user", TargetID: 2},
+		}
+		if err := s.RecordAudits(ctx, events); err != nil {
+			t.Fatalf("%s: RecordAudits: %v", name, err)
+		}
+		if events[0].ID == 0 || events[1].ID == 0 || events[0].CreatedAt.IsZero() {
+			t.Errorf("%s: IDs and times not assigned: %+v", name, events)
+		}
+		if _, total, _ := s.ListAuditEvents(ctx, AuditFilter{}); total != 2 {
+			t.Errorf("%s: expected 2 events, got %d", name, total)
+		}
+	}
+}
+
+func TestCountAuditTargets(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+		for _, e := range []*models.AuditEvent{
+			{ActorID: 1, Action: "user.login", TargetType: "user", TargetID: 1, CreatedAt: day.Add(time.Hour)},
+			{ActorID: 1, Action: "user.login", TargetType: "user", TargetID: 1, CreatedAt: day.Add(2 * time.Hour)},
+			{ActorID: 2, Action: "user.login", TargetType: "user", TargetID: 2, CreatedAt: day.Add(3 * time.Hour)},
+			{ActorID: 3, Action: "user.login", TargetType: "user", TargetID: 3, CreatedAt: day.Add(25 * time.Hour)},
+			{ActorID: 4, Action: "user.logout", TargetType: "user", TargetID: 4, CreatedAt: day.Add(time.Hour)},
+		} {
+			if err := s.RecordAudit(ctx, e); err != nil {
+				t.Fatalf("%s: RecordAudit: %v", name, err)
+			}
+		}
+		n, err := s.CountAuditTargets(ctx, AuditFilter{Action: "user.login", Since: day, Until: day.Add(24 * time.Hour), Limit: 1})
+		if err != nil || n != 2 {
+			t.Errorf("%s: CountAuditTargets = %d, %v; want 2", name, n, err)
+		}
+	}
+}
+
+func TestPurgeAuditEvents(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+		for _, at := range []time.Time{day.Add(-time.Second), day, day.Add(time.Hour)} {
+			if err := s.RecordAudit(ctx, &models.AuditEvent{Action: "user.login", TargetType: "user", CreatedAt: at}); err != nil {
+				t.Fatalf("%s: RecordAudit: %v", name, err)
+			}
+		}
+		n, err := s.PurgeAuditEvents(ctx, day)
+		if err != nil || n != 1 {
+			t.Errorf("%s: PurgeAuditEvents = %d, %v; want 1", name, n, err)
+		}
+		e := &models.AuditEvent{Action: "user.logout", TargetType: "user"}
+		if err := s.RecordAudit(ctx, e); err != nil || e.ID != 4 {
+			t.Errorf("%s: event recorded after a purge got ID %d, %v; want 4", name, e.ID, err)
+		}
+		if _, total, _ := s.ListAuditEvents(ctx, AuditFilter{}); total != 3 {
+			t.Errorf("%s: %d events left, want 3", name, total)
+		}
+	}
+}
+
+func TestListAuditEventsForUser(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+		for _, e := range []*models.AuditEvent{
+			{ActorID: 7, Action: "user.login", TargetType: "user", TargetID: 7, CreatedAt: at},
+			{ActorID: 1, Action: "user.disable", TargetType: "user", TargetID: 7, CreatedAt: at},
+			{ActorID: 7, Action: "webhook.create", TargetType: "webhook", TargetID: 3, CreatedAt: at.Add(time.Hour)},
+			{ActorID: 1, Action: "user.disable", TargetType: "user", TargetID: 8, CreatedAt: at},
+			// Recorded late, as the async audit writer does.
+			{ActorID: 7, Action: "user.login", TargetType: "user", TargetID: 7, CreatedAt: at.Add(-time.Hour)},
+		} {
+			if err := s.RecordAudit(ctx, e); err != nil {
+				t.Fatalf("%s: RecordAudit: %v", name, err)
+			}
+		}
+
+		events, total, err := s.ListAuditEvents(ctx, AuditFilter{UserID: 7})
+		if err != nil || total != 4 {
+			t.Fatalf("%s: ListAuditEvents = %d events, %v; want 4", name, total, err)
+		}
+		var ids []int64
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		if !slices.Equal(ids, []int64{3, 2, 1, 5}) {
+			t.Errorf("%s: events %v, want [3 2 1 5] newest first", name, ids)
+		}
+
+		// Continuing after event 2 picks up its same-time predecessor.
+		events, _, err = s.ListAuditEvents(ctx, AuditFilter{UserID: 7, Until: events[1].CreatedAt, BeforeID: 2})
+		if err != nil || len(events) != 2 || events[0].ID != 1 || events[1].ID != 5 {
+			t.Errorf("%s: events after 2 = %+v, %v; want 1 and 5", name, events, err)
+		}
+	}
+}
+
+func TestSQLiteRevokedTokens(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	tokens := []*models.RevokedToken{
+		{JTI: "old", RevokedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{JTI: "new", RevokedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{JTI: "expired", RevokedAt: now.Add(-time.Minute), ExpiresAt: now.Add(-time.Second)},
+	}
+	for _, tok := range tokens {
+		if err := s.RevokeToken(ctx, tok); err != nil {
+			t.Fatalf("RevokeToken: %v", err)
+		}
+	}
+	// Revoking again is a no-op
+	if err := s.RevokeToken(ctx, &models.RevokedToken{JTI: "old", ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("RevokeToken twice: %v", err)
+	}
+
+	all, err := s.ListRevokedTokens(ctx, time.Time{})
+	if err != nil {
+		t.Fatalf("ListRevokedTokens: %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected 2 unexpired revocations, got %+v", all)
+	}
+	recent, err := s.ListRevokedTokens(ctx, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("ListRevokedTokens: %v", err)
+	}
+	if len(recent) != 1 || recent[0].JTI != "new" {
+		t.Fatalf("expected only the recent revocation, got %+v", recent)
+	}
+
+	n, err := s.PurgeRevokedTokens(ctx, now)
+	if err != nil || n != 1 {
+		t.Fatalf("PurgeRevokedTokens = %d, %v; want 1", n, err)
+	}
+}
+
+func TestSQLiteRefreshTokens(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	tokens := []*models.RefreshToken{
+		{JTI: "first", UserID: 1, IssuedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{JTI: "second", UserID: 1, ParentJTI: "first", IssuedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{JTI: "stale", UserID: 1, IssuedAt: now.Add(-3 * time.Hour), ExpiresAt: now.Add(-time.Second)},
+		{JTI: "other", UserID: 2, ExpiresAt: now.Add(time.Hour)},
+	}
+	for _, tok := range tokens {
+		if err := s.SaveRefreshToken(ctx, tok); err != nil {
+			t.Fatalf("SaveRefreshToken: %v", err)
+		}
+	}
+	if err := s.SaveRefreshToken(ctx, &models.RefreshToken{JTI: "first", UserID: 1, ExpiresAt: now}); err == nil {
+		t.Error("expected duplicate jti to be rejected")
+	}
+
+	got, err := s.GetRefreshToken(ctx, "second")
+	if err != nil || got == nil || got.ParentJTI != "first" || got.UserID != 1 {
+		t.Fatalf("GetRefreshToken = %+v, %v", got, err)
+	}
+	if missing, err := s.GetRefreshToken(ctx, "nope"); missing != nil || err != nil {
+		t.Fatalf("expected nil for unknown jti, got %+v, %v", missing, err)
+	}
+
+	list, err := s.ListRefreshTokens(ctx, 1)
+	if err != nil {
+		t.Fatalf("ListRefreshTokens: %v", err)
+	}
+	if len(list) != 2 || list[0].JTI != "second" || list[1].JTI != "first" {
+		t.Fatalf("expected unexpired tokens newest first, got %+v", list)
+	}
+
+	n, err := s.PurgeRefreshTokens(ctx, now)
+	if err != nil || n != 1 {
+		t.Fatalf("PurgeRefreshTokens = %d, %v; want 1", n, err)
+	}
+}
+
+func TestTouchRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.SaveRefreshToken(ctx, &models.RefreshToken{JTI: "session", UserID: 1, IssuedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}); err != nil {
+				t.Fatalf("SaveRefreshToken: %v", err)
+			}
+			got, _ := s.GetRefreshToken(ctx, "session")
+			if !got.LastUsedAt.IsZero() || !got.LastActivity().Equal(now.Add(-time.Hour)) {
+				t.Fatalf("new token: last used %v, last activity %v", got.LastUsedAt, got.LastActivity())
+			}
+
+			// Uses only move forward, and unknown tokens are ignored.
+			for _, at := range []time.Time{now.Add(-time.Minute), now.Add(-10 * time.Minute)} {
+				if err := s.TouchRefreshToken(ctx, "session", at); err != nil {
+					t.Fatalf("TouchRefreshToken: %v", err)
+				}
+			}
+			if err := s.TouchRefreshToken(ctx, "unknown", now); err != nil {
+				t.Fatalf("TouchRefreshToken of an unknown token: %v", err)
+			}
+			got, _ = s.GetRefreshToken(ctx, "session")
+			if !got.LastUsedAt.Equal(now.Add(-time.Minute)) || !got.LastActivity().Equal(got.LastUsedAt) {
+				t.Errorf("last used %v, want %v", got.LastUsedAt, now.Add(-time.Minute))
+			}
+			list, _ := s.ListRefreshTokens(ctx, 1)
+			if len(list) != 1 || !list[0].LastUsedAt.Equal(now.Add(-time.Minute)) {
+				t.Errorf("ListRefreshTokens = %+v", list)
+			}
+		})
+	}
+}
+
+func TestSQLiteRecoveryCodes(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	id, err := s.CreateUser(ctx, &models.User{Username: "rescue", Email: "r@example.com", Password: "hash", Role: "user"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if err := s.SetRecoveryCodes(ctx, id, []string{"a", "b", "c"}); err != nil {
+		t.Fatalf("SetRecoveryCodes: %v", err)
+	}
+	if ok, err := s.UseRecoveryCode(ctx, id, "b"); !ok || err != nil {
+		t.Fatalf("UseRecoveryCode = %v, %v; want true", ok, err)
+	}
+	if ok, _ := s.UseRecoveryCode(ctx, id, "b"); ok {
+		t.Error("a recovery code must only work once")
+	}
+	if ok, _ := s.UseRecoveryCode(ctx, id+1, "a"); ok {
+		t.Error("another user's code must not work")
+	}
+	if n, err := s.CountRecoveryCodes(ctx, id); n != 2 || err != nil {
+		t.Fatalf("CountRecoveryCodes = %d, %v; want 2", n, err)
+	}
+
+	// Regenerating replaces every code, used or not
+	if err := s.SetRecoveryCodes(ctx, id, []string{"d"}); err != nil {
+		t.Fatalf("SetRecoveryCodes: %v", err)
+	}
+	if ok, _ := s.UseRecoveryCode(ctx, id, "a"); ok {
+		t.Error("old codes must stop working after regeneration")
+	}
+
+	if err := s.DeleteUser(ctx, id); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if n, _ := s.CountRecoveryCodes(ctx, id); n != 0 {
+		t.Errorf("expected codes to be deleted with the user, %d remain", n)
+	}
+}
+
+func TestSQLiteEmailChanges(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	id, err := s.CreateUser(ctx, &models.User{Username: "mover", Email: "old@example.com", Password: "hash", Role: "user"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if c, err := s.GetEmailChange(ctx, id); c != nil || err != nil {
+		t.Fatalf("GetEmailChange = %v, %v; want nil", c, err)
+	}
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	change := &models.EmailChange{UserID: id, OldEmail: "old@example.com", NewEmail: "new@example.com",
+		ConfirmTokenHash: "confirm", ConfirmExpiresAt: expires}
+	if err := s.SaveEmailChange(ctx, change); err != nil {
+		t.Fatalf("SaveEmailChange: %v", err)
+	}
+	got, err := s.GetEmailChangeByToken(ctx, "confirm")
+	if err != nil || got == nil || got.NewEmail != "new@example.com" || !got.ConfirmExpiresAt.Equal(expires) || got.ConfirmedAt != nil {
+		t.Fatalf("GetEmailChangeByToken = %+v, %v", got, err)
+	}
+
+	// Confirming swaps the confirm token for a revert token
+	now := time.Now().UTC()
+	change.ConfirmedAt, change.RevertExpiresAt = &now, &expires
+	change.ConfirmTokenHash, change.RevertTokenHash = "", "revert"
+	if err := s.SaveEmailChange(ctx, change); err != nil {
+		t.Fatalf("SaveEmailChange: %v", err)
+	}
+	if c, _ := s.GetEmailChangeByToken(ctx, "confirm"); c != nil {
+		t.Error("the confirm token must stop matching once replaced")
+	}
+	if c, _ := s.GetEmailChangeByToken(ctx, ""); c != nil {
+		t.Error("an empty token must never match")
+	}
+	got, _ = s.GetEmailChangeByToken(ctx, "revert")
+	if got == nil || got.ConfirmedAt == nil || got.RevertExpiresAt == nil || !got.RevertExpiresAt.Equal(expires) {
+		t.Fatalf("unexpected confirmed change: %+v", got)
+	}
+
+	if err := s.DeleteEmailChange(ctx, id); err != nil {
+		t.Fatalf("DeleteEmailChange: %v", err)
+	}
+	if c, _ := s.GetEmailChange(ctx, id); c != nil {
+		t.Errorf("expected change to be deleted, got %+v", c)
+	}
+}
+
+func TestOTPChallenges(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		u := &models.User{Username: "texter", Email: "t@example.com", Password: "h", Role: "user"}
+		id, err := s.CreateUser(ctx, u)
+		if err != nil {
+			t.Fatalf("%s: CreateUser: %v", name, err)
+		}
+		u.Phone = "+14155550100"
+		u.SMSOTP = true
+		if err := s.UpdateUser(ctx, u); err != nil {
+			t.Fatalf("%s: UpdateUser: %v", name, err)
+		}
+		if got, _ := s.GetUserByID(ctx, id); got.Phone != u.Phone || !got.SMSOTP {
+			t.Errorf("%s: expected phone settings to persist, got %+v", name, got)
+		}
+
+		expires := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+		c := &models.OTPChallenge{UserID: id, Purpose: models.OTPPurposeLogin, Phone: u.Phone, CodeHash: "hash", ExpiresAt: expires}
+		if err := s.SaveOTPChallenge(ctx, c); err != nil {
+			t.Fatalf("%s: SaveOTPChallenge: %v", name, err)
+		}
+		c.Attempts = 2
+		if err := s.SaveOTPChallenge(ctx, c); err != nil {
+			t.Fatalf("%s: SaveOTPChallenge (update): %v", name, err)
+		}
+		got, err := s.GetOTPChallenge(ctx, id, models.OTPPurposeLogin)
+		if err != nil || got == nil || got.CodeHash != "hash" || got.Attempts != 2 || !got.ExpiresAt.Equal(expires) {
+			t.Fatalf("%s: unexpected challenge %+v (%v)", name, got, err)
+		}
+		if other, _ := s.GetOTPChallenge(ctx, id, models.OTPPurposePhoneVerify); other != nil {
+			t.Errorf("%s: challenges should be kept per purpose", name)
+		}
+		if err := s.DeleteOTPChallenge(ctx, id, models.OTPPurposeLogin); err != nil {
+			t.Fatalf("%s: DeleteOTPChallenge: %v", name, err)
+		}
+		if got, _ := s.GetOTPChallenge(ctx, id, models.OTPPurposeLogin); got != nil {
+			t.Errorf("%s: expected the challenge to be deleted", name)
+		}
+	}
+}
+
+func TestUserByPhone(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		a := &models.User{Username: "ana", Email: "ana@example.com", Password: "h", Role: "user"}
+		b := &models.User{Username: "ben", Email: "ben@example.com", Password: "h", Role: "user"}
+		for _, u := range []*models.User{a, b} {
+			if _, err := s.CreateUser(ctx, u); err != nil {
+				t.Fatalf("%s: CreateUser: %v", name, err)
+			}
+		}
+		if got, err := s.GetUserByPhone(ctx, "+14155550100"); err != nil || got != nil {
+			t.Fatalf("%s: expected no user, got %+v (%v)", name, got, err)
+		}
+		a.Phone = "+14155550100"
+		if err := s.UpdateUser(ctx, a); err != nil {
+			t.Fatalf("%s: UpdateUser: %v", name, err)
+		}
+		if got, err := s.GetUserByPhone(ctx, "+14155550100"); err != nil || got == nil || got.ID != a.ID {
+			t.Fatalf("%s: expected ana, got %+v (%v)", name, got, err)
+		}
+
+		b.Phone = a.Phone
+		if err := s.UpdateUser(ctx, b); err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("%s: expected a duplicate phone to be rejected, got %v", name, err)
+		}
+		// Accounts without a phone do not collide.
+		b.Phone = ""
+		b.Email = "ben2@example.com"
+		if err := s.UpdateUser(ctx, b); err != nil {
+			t.Errorf("%s: UpdateUser without phone: %v", name, err)
+		}
+	}
+}
+
+func TestMagicLinks(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		id, err := s.CreateUser(ctx, &models.User{Username: "linker", Email: "Linker@Example.com", Password: "h", Role: "user"})
+		if err != nil {
+			t.Fatalf("%s: CreateUser: %v", name, err)
+		}
+		if u, err := s.GetUserByEmail(ctx, "linker@example.com"); err != nil || u == nil || u.ID != id {
+			t.Fatalf("%s: expected a case-insensitive email match, got %+v (%v)", name, u, err)
+		}
+		if u, _ := s.GetUserByEmail(ctx, "nobody@example.com"); u != nil {
+			t.Errorf("%s: expected no user for an unknown email", name)
+		}
+
+		expires := time.Now().Add(15 * time.Minute).UTC().Truncate(time.Second)
+		for _, hash := range []string{"first", "second"} {
+			if err := s.SaveMagicLink(ctx, &models.MagicLink{UserID: id, TokenHash: hash, DeviceHash: "device", ExpiresAt: expires}); err != nil {
+				t.Fatalf("%s: SaveMagicLink: %v", name, err)
+			}
+		}
+		if l, _ := s.GetMagicLinkByToken(ctx, "first"); l != nil {
+			t.Errorf("%s: expected a new link to replace the earlier one", name)
+		}
+		l, err := s.GetMagicLinkByToken(ctx, "second")
+		if err != nil || l == nil || l.UserID != id || l.DeviceHash != "device" || !l.ExpiresAt.Equal(expires) {
+			t.Fatalf("%s: unexpected link %+v (%v)", name, l, err)
+		}
+		if l, _ := s.GetMagicLink(ctx, id); l == nil || l.TokenHash != "second" {
+			t.Errorf("%s: expected the link by user, got %+v", name, l)
+		}
+		if ok, err := s.UseMagicLink(ctx, "second"); err != nil || !ok {
+			t.Fatalf("%s: UseMagicLink: %v, %v", name, ok, err)
+		}
+		if ok, _ := s.UseMagicLink(ctx, "second"); ok {
+			t.Errorf("%s: expected a link to be usable once", name)
+		}
+	}
+}
+
+func TestGuests(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		var ids []int64
+		for _, username := range []string{"guest:a", "guest:b"} {
+			id, err := s.CreateUser(ctx, &models.User{Username: username, Password: "!", Role: models.RoleGuest})
+			if err != nil {
+				t.Fatalf("%s: expected guests without an email to coexist: %v", name, err)
+			}
+			ids = append(ids, id)
+		}
+
+		u, err := s.GetUserByID(ctx, ids[0])
+		if err != nil || u == nil || u.Email != "" {
+			t.Fatalf("%s: unexpected guest %+v (%v)", name, u, err)
+		}
+		stale := u.Version
+		u.Username, u.Email, u.Password, u.Role = "upgraded", "up@example.com", "hash", "user"
+		if err := s.UpgradeGuest(ctx, u); err != nil {
+			t.Fatalf("%s: UpgradeGuest: %v", name, err)
+		}
+		if got, _ := s.GetUserByUsername(ctx, "upgraded"); got == nil || got.ID != ids[0] || got.Role != "user" || got.Email != "up@example.com" {
+			t.Errorf("%s: expected the guest to be upgraded in place, got %+v", name, got)
+		}
+		u.Version = stale
+		if err := s.UpgradeGuest(ctx, u); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound upgrading a full account, got %v", name, err)
+		}
+
+		if n, err := s.PurgeGuests(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+			t.Errorf("%s: expected one guest purged, got %d (%v)", name, n, err)
+		}
+		if got, _ := s.GetUserByID(ctx, ids[1]); got != nil {
+			t.Errorf("%s: expected the remaining guest to be purged", name)
+		}
+		if got, _ := s.GetUserByID(ctx, ids[0]); got == nil {
+			t.Errorf("%s: expected the upgraded account to survive the purge", name)
+		}
+	}
+}
+
+func TestMergeUsers(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		target, _ := s.CreateUser(ctx, &models.User{Username: "original", Email: "o@example.com", Password: "h", Role: "user"})
+		source, _ := s.CreateUser(ctx, &models.User{Username: "duplicate", Email: "d@example.com", Password: "h", Role: "user"})
+		if err := s.SaveRefreshToken(ctx, &models.RefreshToken{JTI: "dup-session", UserID: source, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatalf("%s: SaveRefreshToken: %v", name, err)
+		}
+		for _, e := range []*models.AuditEvent{
+			{ActorID: source, Action: "user.update", TargetType: "user", TargetID: source},
+			{ActorID: 99, Action: "user.disable", TargetType: "user", TargetID: source},
+			{ActorID: 99, Action: "webhook.create", TargetType: "webhook", TargetID: source},
+		} {
+			if err := s.RecordAudit(ctx, e); err != nil {
+				t.Fatalf("%s: RecordAudit: %v", name, err)
+			}
+		}
+
+		if _, err := s.MergeUsers(ctx, source, 12345); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound merging into a missing user, got %v", name, err)
+		}
+		counts, err := s.MergeUsers(ctx, source, target)
+		if err != nil || counts != (MergeCounts{RefreshTokens: 1, AuditEvents: 2}) {
+			t.Fatalf("%s: MergeUsers: %+v (%v)", name, counts, err)
+		}
+		if u, _ := s.GetUserByID(ctx, source); u != nil {
+			t.Errorf("%s: expected the source to be deleted", name)
+		}
+		if rt, _ := s.GetRefreshToken(ctx, "dup-session"); rt == nil || rt.UserID != target {
+			t.Errorf("%s: expected the session to move to the target, got %+v", name, rt)
+		}
+		if _, n, _ := s.ListAuditEvents(ctx, AuditFilter{TargetType: "user", TargetID: target}); n != 2 {
+			t.Errorf("%s: expected both user events to move to the target, got %d", name, n)
+		}
+		if _, n, _ := s.ListAuditEvents(ctx, AuditFilter{TargetType: "webhook", TargetID: source}); n != 1 {
+			t.Errorf("%s: expected events about other records to be unchanged, got %d", name, n)
+		}
+		if _, err := s.CreateUser(ctx, &models.User{Username: "duplicate", Email: "d@example.com", Password: "h"}); err != nil {
+			t.Errorf("%s: expected the source's username and email to be free: %v", name, err)
+		}
+	}
+}
+
+func TestCanaries(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		account := &models.Canary{Kind: models.CanaryAccount, UserID: 7, Note: "leaked dump bait", CreatedBy: 1}
+		token := &models.Canary{Kind: models.CanaryToken, TokenID: "abc123", CreatedBy: 1}
+		for _, c := range []*models.Canary{account, token} {
+			if err := s.CreateCanary(ctx, c); err != nil || c.ID == 0 || c.CreatedAt.IsZero() {
+				t.Fatalf("%s: CreateCanary: %+v (%v)", name, c, err)
+			}
+		}
+
+		list, err := s.ListCanaries(ctx)
+		if err != nil || len(list) != 2 || list[0].UserID != 7 || list[0].Note != "leaked dump bait" || list[1].TokenID != "abc123" {
+			t.Fatalf("%s: unexpected canaries %+v (%v)", name, list, err)
+		}
+
+		if err := s.DeleteCanary(ctx, account.ID); err != nil {
+			t.Fatalf("%s: DeleteCanary: %v", name, err)
+		}
+		if err := s.DeleteCanary(ctx, account.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound deleting twice, got %v", name, err)
+		}
+		if list, _ := s.ListCanaries(ctx); len(list) != 1 || list[0].ID != token.ID {
+			t.Errorf("%s: expected only the token canary to remain, got %+v", name, list)
+		}
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		w := &models.Webhook{URL: "https://hooks.example.com", Events: []string{"user.login", "user.delete"}, Secret: "s3cret", Enabled: true, CreatedBy: 1}
+		if err := s.CreateWebhook(ctx, w); err != nil || w.ID != 1 || w.UpdatedAt.IsZero() {
+			t.Fatalf("%s: CreateWebhook: %+v (%v)", name, w, err)
+		}
+		if err := s.CreateWebhook(ctx, &models.Webhook{URL: "https://other.example.com", Secret: "x"}); err != nil {
+			t.Fatalf("%s: CreateWebhook: %v", name, err)
+		}
+
+		w.Events = []string{models.WebhookAllEvents}
+		w.Enabled = false
+		if err := s.UpdateWebhook(ctx, w); err != nil {
+			t.Fatalf("%s: UpdateWebhook: %v", name, err)
+		}
+		got, err := s.GetWebhook(ctx, w.ID)
+		if err != nil || got == nil || got.Enabled || !slices.Equal(got.Events, w.Events) || got.Secret != "s3cret" {
+			t.Fatalf("%s: unexpected webhook %+v (%v)", name, got, err)
+		}
+		if err := s.UpdateWebhook(ctx, &models.Webhook{ID: 99}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound updating a missing webhook, got %v", name, err)
+		}
+
+		if err := s.DeleteWebhook(ctx, w.ID); err != nil {
+			t.Fatalf("%s: DeleteWebhook: %v", name, err)
+		}
+		if err := s.DeleteWebhook(ctx, w.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound deleting twice, got %v", name, err)
+		}
+		list, err := s.ListWebhooks(ctx)
+		if err != nil || len(list) != 1 || list[0].URL != "https://other.example.com" || list[0].Events != nil {
+			t.Errorf("%s: unexpected webhooks %+v (%v)", name, list, err)
+		}
+	}
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		old := time.Now().Add(-48 * time.Hour).UTC()
+		for i, d := range []*models.WebhookDelivery{
+			{WebhookID: "alerts", EventID: "e1", Event: "alert", URL: "https://hooks.example.com", Payload: []byte(`{"rule":"a"}`), StatusCode: 500, Error: "unexpected status 500", CreatedAt: old},
+			{WebhookID: "alerts", EventID: "e1", Event: "alert", URL: "https://hooks.example.com", Payload: []byte(`{"rule":"a"}`), StatusCode: 200, RedeliveryOf: 1},
+			{WebhookID: "other", EventID: "e2", Event: "alert", URL: "https://other.example.com", Payload: []byte(`{}`)},
+		} {
+			if err := s.RecordWebhookDelivery(ctx, d); err != nil || d.ID != int64(i+1) {
+				t.Fatalf("%s: RecordWebhookDelivery: id %d (%v)", name, d.ID, err)
+			}
+		}
+
+		list, total, err := s.ListWebhookDeliveries(ctx, "alerts", 1, 0)
+		if err != nil || total != 2 || len(list) != 1 || list[0].ID != 2 || list[0].RedeliveryOf != 1 || !list[0].Succeeded() {
+			t.Fatalf("%s: unexpected deliveries %+v, total %d (%v)", name, list, total, err)
+		}
+		d, err := s.GetWebhookDelivery(ctx, 1)
+		if err != nil || d == nil || string(d.Payload) != `{"rule":"a"}` || d.StatusCode != 500 || d.Error == "" {
+			t.Fatalf("%s: unexpected delivery %+v (%v)", name, d, err)
+		}
+		if d, err := s.GetWebhookDelivery(ctx, 99); d != nil || err != nil {
+			t.Errorf("%s: expected no delivery, got %+v (%v)", name, d, err)
+		}
+
+		if n, err := s.PurgeWebhookDeliveries(ctx, time.Now().Add(-24*time.Hour)); err != nil || n != 1 {
+			t.Errorf("%s: expected one delivery purged, got %d (%v)", name, n, err)
+		}
+	}
+}
+
+func TestOutboxEvents(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		old := now.Add(-48 * time.Hour)
+		for i, e := range []*models.OutboxEvent{
+			{EventID: "e1", Type: "user.login", Payload: []byte(`{"id":"e1"}`), CreatedAt: old},
+			{EventID: "e2", Type: "user.delete", Payload: []byte(`{"id":"e2"}`), CreatedAt: now.Add(-time.Minute)},
+			{EventID: "e3", Type: "user.login", Payload: []byte(`{"id":"e3"}`), CreatedAt: now},
+		} {
+			if err := s.AddOutboxEvent(ctx, e); err != nil || e.ID != int64(i+1) {
+				t.Fatalf("%s: AddOutboxEvent: id %d (%v)", name, e.ID, err)
+			}
+		}
+
+		claimed, err := s.ClaimOutboxEvents(ctx, now, time.Minute, 2)
+		if err != nil || len(claimed) != 2 || claimed[0].EventID != "e1" || claimed[1].EventID != "e2" || string(claimed[0].Payload) != `{"id":"e1"}` {
+			t.Fatalf("%s: unexpected claim %+v (%v)", name, claimed, err)
+		}
+		if again, _ := s.ClaimOutboxEvents(ctx, now, time.Minute, 10); len(again) != 1 || again[0].EventID != "e3" {
+			t.Fatalf("%s: expected only the unclaimed event, got %+v", name, again)
+		}
+		if err := s.MarkOutboxEventDispatched(ctx, 1, now); err != nil {
+			t.Fatalf("%s: MarkOutboxEventDispatched: %v", name, err)
+		}
+		// Claims that lapse, such as those of a crashed instance, are
+		// claimed again
+		expired, _ := s.ClaimOutboxEvents(ctx, now.Add(2*time.Minute), time.Minute, 10)
+		if len(expired) != 2 || expired[0].EventID != "e2" {
+			t.Errorf("%s: expected the undispatched events to be reclaimed, got %+v", name, expired)
+		}
+
+		list, err := s.ListOutboxEvents(ctx, old, now, 0)
+		if err != nil || len(list) != 2 || list[0].DispatchedAt == nil || list[1].DispatchedAt != nil {
+			t.Fatalf("%s: unexpected events %+v (%v)", name, list, err)
+		}
+		if n, err := s.PurgeOutboxEvents(ctx, now.Add(-24*time.Hour)); err != nil || n != 1 {
+			t.Errorf("%s: expected one event purged, got %d (%v)", name, n, err)
+		}
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		q := &models.Quota{Subject: "service:billing", Daily: 100, UpdatedBy: 1}
+		if err := s.SetQuota(ctx, q); err != nil || q.ID == 0 || q.CreatedAt.IsZero() {
+			t.Fatalf("%s: SetQuota: %+v (%v)", name, q, err)
+		}
+		if err := s.SetQuota(ctx, &models.Quota{Subject: "user:7", Monthly: 5}); err != nil {
+			t.Fatalf("%s: SetQuota: %v", name, err)
+		}
+		updated := &models.Quota{Subject: "service:billing", Daily: 50, Monthly: 1000, UpdatedBy: 2}
+		if err := s.SetQuota(ctx, updated); err != nil || updated.ID != q.ID {
+			t.Fatalf("%s: expected the quota to be replaced in place, got %+v (%v)", name, updated, err)
+		}
+		got, err := s.GetQuota(ctx, "service:billing")
+		if err != nil || got == nil || got.Daily != 50 || got.Monthly != 1000 || got.UpdatedBy != 2 {
+			t.Fatalf("%s: unexpected quota %+v (%v)", name, got, err)
+		}
+		if got, _ := s.GetQuota(ctx, "service:missing"); got != nil {
+			t.Errorf("%s: expected no quota, got %+v", name, got)
+		}
+		if list, _ := s.ListQuotas(ctx); len(list) != 2 || list[0].Subject != "service:billing" {
+			t.Errorf("%s: unexpected quotas %+v", name, list)
+		}
+		if err := s.DeleteQuota(ctx, "user:7"); err != nil {
+			t.Fatalf("%s: DeleteQuota: %v", name, err)
+		}
+		if err := s.DeleteQuota(ctx, "user:7"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", name, err)
+		}
+
+		now := time.Now().UTC()
+		for want := int64(1); want <= 3; want++ {
+			if n, err := s.IncrementQuotaUsage(ctx, "service:billing", "day:2024-05-01", now.Add(-time.Hour)); err != nil || n != want {
+				t.Fatalf("%s: IncrementQuotaUsage = %d, %v; want %d", name, n, err, want)
+			}
+		}
+		s.IncrementQuotaUsage(ctx, "service:billing", "month:2024-05", now.Add(time.Hour))
+		if n, err := s.QuotaUsage(ctx, "service:billing", "day:2024-05-01"); err != nil || n != 3 {
+			t.Errorf("%s: QuotaUsage = %d, %v", name, n, err)
+		}
+		if n, _ := s.QuotaUsage(ctx, "service:billing", "day:2024-05-02"); n != 0 {
+			t.Errorf("%s: expected no usage, got %d", name, n)
+		}
+		if n, err := s.PurgeQuotaUsage(ctx, now); err != nil || n != 1 {
+			t.Errorf("%s: expected one counter purged, got %d (%v)", name, n, err)
+		}
+		if n, _ := s.QuotaUsage(ctx, "service:billing", "month:2024-05"); n != 1 {
+			t.Errorf("%s: expected the unexpired counter to be kept, got %d", name, n)
+		}
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		f := &models.FeatureFlag{Name: "password-context-check", Enabled: true, Percent: 10, Subjects: []string{"user:1", "service:qa"}, UpdatedBy: 1}
+		if err := s.SetFeatureFlag(ctx, f); err != nil || f.ID == 0 || f.CreatedAt.IsZero() {
+			t.Fatalf("%s: SetFeatureFlag: %+v (%v)", name, f, err)
+		}
+		updated := &models.FeatureFlag{Name: "password-context-check", Percent: 50, UpdatedBy: 2}
+		if err := s.SetFeatureFlag(ctx, updated); err != nil || updated.ID != f.ID {
+			t.Fatalf("%s: expected the override to be replaced in place, got %+v (%v)", name, updated, err)
+		}
+		got, err := s.GetFeatureFlag(ctx, "password-context-check")
+		if err != nil || got == nil || got.Enabled || got.Percent != 50 || len(got.Subjects) != 0 || got.UpdatedBy != 2 {
+			t.Fatalf("%s: unexpected override %+v (%v)", name, got, err)
+		}
+		if got, _ := s.GetFeatureFlag(ctx, "missing"); got != nil {
+			t.Errorf("%s: expected no override, got %+v", name, got)
+		}
+		s.SetFeatureFlag(ctx, &models.FeatureFlag{Name: "another", Enabled: true, Subjects: []string{"user:2"}})
+		list, err := s.ListFeatureFlags(ctx)
+		if err != nil || len(list) != 2 || list[0].Name != "another" || len(list[0].Subjects) != 1 || list[0].Subjects[0] != "user:2" {
+			t.Errorf("%s: unexpected overrides %+v (%v)", name, list, err)
+		}
+		if err := s.DeleteFeatureFlag(ctx, "another"); err != nil {
+			t.Fatalf("%s: DeleteFeatureFlag: %v", name, err)
+		}
+		if err := s.DeleteFeatureFlag(ctx, "another"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", name, err)
+		}
+	}
+}
+
+func TestClients(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		c := &models.Client{
+			ClientID:       "mobile",
+			Name:           "Mobile app",
+			AccessTokenTTL: 900,
+			RefreshPolicy:  models.RefreshSliding,
+			AllowedScopes:  []string{"profile", "offline"},
+			CORSOrigins:    []string{"https://app.example.com"},
+			UpdatedBy:      1,
+		}
+		if err := s.SetClient(ctx, c); err != nil || c.ID == 0 || c.CreatedAt.IsZero() {
+			t.Fatalf("%s: SetClient: %+v (%v)", name, c, err)
+		}
+		updated := &models.Client{ClientID: "mobile", RefreshTokenTTL: 3600, AllowedScopes: []string{"profile"}, UpdatedBy: 2}
+		if err := s.SetClient(ctx, updated); err != nil || updated.ID != c.ID {
+			t.Fatalf("%s: expected the client to be replaced in place, got %+v (%v)", name, updated, err)
+		}
+		got, err := s.GetClient(ctx, "mobile")
+		if err != nil || got == nil || got.Name != "" || got.AccessTokenTTL != 0 || got.RefreshTokenTTL != 3600 ||
+			got.RefreshPolicy != "" || len(got.AllowedScopes) != 1 || len(got.CORSOrigins) != 0 || got.UpdatedBy != 2 {
+			t.Fatalf("%s: unexpected client %+v (%v)", name, got, err)
+		}
+		if got, _ := s.GetClient(ctx, "missing"); got != nil {
+			t.Errorf("%s: expected no client, got %+v", name, got)
+		}
+		s.SetClient(ctx, &models.Client{ClientID: "daemon", CORSOrigins: []string{"https://a.example.com", "https://b.example.com"}})
+		list, err := s.ListClients(ctx)
+		if err != nil || len(list) != 2 || list[0].ClientID != "daemon" || len(list[0].CORSOrigins) != 2 {
+			t.Errorf("%s: unexpected clients %+v (%v)", name, list, err)
+		}
+		if err := s.DeleteClient(ctx, "daemon"); err != nil {
+			t.Fatalf("%s: DeleteClient: %v", name, err)
+		}
+		if err := s.DeleteClient(ctx, "daemon"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", name, err)
+		}
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		id, _ := s.CreateUser(ctx, &models.User{Username: "prefs", Email: "prefs@example.com", Password: "h"})
+		if got, err := s.GetPreferences(ctx, id); got != nil || err != nil {
+			t.Fatalf("%s: expected no preferences, got %+v (%v)", name, got, err)
+		}
+		p := &models.Preferences{LoginAlerts: true, SessionTimeoutMinutes: 30, Locale: "de"}
+		if err := s.SetPreferences(ctx, id, p); err != nil || p.UpdatedAt.IsZero() {
+			t.Fatalf("%s: SetPreferences: %+v (%v)", name, p, err)
+		}
+		if err := s.SetPreferences(ctx, id, &models.Preferences{Locale: "pt-BR"}); err != nil {
+			t.Fatalf("%s: SetPreferences: %v", name, err)
+		}
+		got, err := s.GetPreferences(ctx, id)
+		if err != nil || got == nil || got.LoginAlerts || got.SessionTimeoutMinutes != 0 || got.Locale != "pt-BR" {
+			t.Fatalf("%s: expected the preferences to be replaced, got %+v (%v)", name, got, err)
+		}
+		if err := s.DeleteUser(ctx, id); err != nil {
+			t.Fatalf("%s: DeleteUser: %v", name, err)
+		}
+		if got, _ := s.GetPreferences(ctx, id); got != nil {
+			t.Errorf("%s: expected preferences to be deleted with the user, got %+v", name, got)
+		}
+	}
+}
+
+func TestElevations(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		id, _ := s.CreateUser(ctx, &models.User{Username: "elevated", Email: "elevated@example.com", Password: "h"})
+		e := &models.Elevation{UserID: id, Scopes: []string{"user.write", "token.revoke"}, Reason: "ticket 42", DurationSeconds: 900, Status: models.ElevationPending}
+		if err := s.CreateElevation(ctx, e); err != nil || e.ID == 0 {
+			t.Fatalf("%s: CreateElevation: %+v (%v)", name, e, err)
+		}
+		if ok, err := s.RedeemElevation(ctx, e.ID, "jti-1"); ok || err != nil {
+			t.Fatalf("%s: expected a pending elevation not to be redeemed, got %v (%v)", name, ok, err)
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		expires := now.Add(e.Duration())
+		e.Status, e.DecidedBy, e.DecidedAt, e.ExpiresAt = models.ElevationApproved, 7, &now, &expires
+		if err := s.DecideElevation(ctx, e); err != nil {
+			t.Fatalf("%s: DecideElevation: %v", name, err)
+		}
+		if err := s.DecideElevation(ctx, e); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected deciding twice to fail with ErrNotFound, got %v", name, err)
+		}
+		if ok, err := s.RedeemElevation(ctx, e.ID, "jti-1"); !ok || err != nil {
+			t.Fatalf("%s: RedeemElevation: %v (%v)", name, ok, err)
+		}
+		if ok, _ := s.RedeemElevation(ctx, e.ID, "jti-2"); ok {
+			t.Errorf("%s: expected an elevation to be redeemed once", name)
+		}
+
+		got, err := s.GetElevation(ctx, e.ID)
+		if err != nil || got == nil || got.Status != models.ElevationApproved || got.TokenID != "jti-1" ||
+			len(got.Scopes) != 2 || got.ExpiresAt == nil || !got.ExpiresAt.Equal(expires) {
+			t.Fatalf("%s: unexpected elevation %+v (%v)", name, got, err)
+		}
+		if err := s.CreateElevation(ctx, &models.Elevation{UserID: id, Scopes: []string{"admin"}, Reason: "r", DurationSeconds: 60, Status: models.ElevationPending}); err != nil {
+			t.Fatalf("%s: CreateElevation: %v", name, err)
+		}
+		if list, _ := s.ListElevations(ctx, models.ElevationPending, 10); len(list) != 1 || list[0].Scopes[0] != "admin" {
+			t.Errorf("%s: expected one pending elevation, got %+v", name, list)
+		}
+		if list, _ := s.ListElevations(ctx, "", 10); len(list) != 2 || list[0].ID < list[1].ID {
+			t.Errorf("%s: expected both elevations newest first, got %+v", name, list)
+		}
+
+		if err := s.DeleteUser(ctx, id); err != nil {
+			t.Fatalf("%s: DeleteUser: %v", name, err)
+		}
+		if got, _ := s.GetElevation(ctx, e.ID); got != nil {
+			t.Errorf("%s: expected elevations to be deleted with the user, got %+v", name, got)
+		}
+	}
+}
+
+func TestRateLimitTokens(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		now := time.Unix(1700000000, 0)
+		take := func(at time.Time, wantTaken bool, wantLeft int) {
+			t.Helper()
+			taken, left, err := s.TakeRateLimitToken(ctx, "auth:10.0.0.1", time.Second, 3, at)
+			if err != nil || taken != wantTaken || left != wantLeft {
+				t.Fatalf("%s: TakeRateLimitToken at %v = %v, %d, %v; want %v, %d", name, at.Sub(now), taken, left, err, wantTaken, wantLeft)
+			}
+		}
+		take(now, true, 2)
+		take(now, true, 1)
+		take(now, true, 0)
+		take(now, false, 0)
+		// Half a second refills nothing; a further second refills one
+		take(now.Add(500*time.Millisecond), false, 0)
+		take(now.Add(1500*time.Millisecond), true, 0)
+		// A clock behind the last refill must not remove tokens
+		take(now.Add(-time.Minute), false, 0)
+		take(now.Add(time.Hour), true, 2)
+
+		if taken, left, _ := s.TakeRateLimitToken(ctx, "auth:10.0.0.2", time.Second, 3, now); !taken || left != 2 {
+			t.Errorf("%s: expected a separate full bucket per key, got %v, %d", name, taken, left)
+		}
+		if n, err := s.PurgeRateLimits(ctx, now.Add(time.Minute)); err != nil || n != 1 {
+			t.Errorf("%s: expected one full bucket purged, got %d (%v)", name, n, err)
+		}
+		take(now.Add(time.Hour), true, 1)
+
+		// Time short of a whole token carries over: drained at 0, the
+		// bucket has a token at 1s, 2s, and 3s however the requests fall
+		key := "auth:10.0.0.3"
+		for range 3 {
+			s.TakeRateLimitToken(ctx, key, time.Second, 3, now)
+		}
+		var got int
+		for i := 1; i <= 4; i++ {
+			if taken, _, _ := s.TakeRateLimitToken(ctx, key, time.Second, 3, now.Add(time.Duration(i)*750*time.Millisecond)); taken {
+				got++
+			}
+		}
+		if got != 3 {
+			t.Errorf("%s: expected 3 of 4 requests 0.75s apart to be allowed, got %d", name, got)
+		}
+	}
+}
+
+func TestTarpitFailures(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		now := time.Unix(1700000000, 0)
+		worst := func(at time.Time, want int, keys ...string) {
+			t.Helper()
+			if got, err := s.TarpitFailures(ctx, keys, time.Minute, at); err != nil || got != want {
+				t.Errorf("%s: TarpitFailures(%v) at %v = %d, %v; want %d", name, keys, at.Sub(now), got, err, want)
+			}
+		}
+		for range 3 {
+			s.RecordTarpitFailure(ctx, "ip:10.0.0.1", time.Minute, now)
+		}
+		s.RecordTarpitFailure(ctx, "user:1", time.Minute, now)
+		worst(now, 3, "ip:10.0.0.1", "user:1")
+		worst(now, 1, "user:1", "user:2")
+		worst(now, 0)
+		worst(now.Add(time.Minute), 3, "ip:10.0.0.1")
+		worst(now.Add(time.Minute+time.Second), 0, "ip:10.0.0.1")
+
+		// A failure after the window starts the count over
+		s.RecordTarpitFailure(ctx, "ip:10.0.0.1", time.Minute, now.Add(2*time.Minute))
+		worst(now.Add(2*time.Minute), 1, "ip:10.0.0.1")
+
+		if err := s.ClearTarpitFailures(ctx, []string{"ip:10.0.0.1"}); err != nil {
+			t.Fatalf("%s: ClearTarpitFailures: %v", name, err)
+		}
+		worst(now.Add(2*time.Minute), 0, "ip:10.0.0.1")
+
+		if n, err := s.PurgeTarpitFailures(ctx, now.Add(time.Second)); err != nil || n != 1 {
+			t.Errorf("%s: expected one stale key purged, got %d (%v)", name, n, err)
+		}
+	}
+}
+
+func TestNonces(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		use := func(key string, at time.Time, want bool) {
+			t.Helper()
+			if got, err := s.UseNonce(ctx, key, at.Add(time.Minute), at); err != nil || got != want {
+				t.Errorf("%s: UseNonce(%s) at %v = %v, %v; want %v", name, key, at.Sub(now), got, err, want)
+			}
+		}
+		use("jti-1:abc", now, true)
+		use("jti-1:abc", now.Add(30*time.Second), false)
+		use("jti-2:abc", now, true)
+		// An expired nonce may be used again, purged or not
+		use("jti-1:abc", now.Add(time.Minute), true)
+		if n, err := s.PurgeNonces(ctx, now.Add(time.Minute)); err != nil || n != 1 {
+			t.Errorf("%s: expected one expired nonce purged, got %d (%v)", name, n, err)
+		}
+		use("jti-2:abc", now.Add(time.Minute), true)
+	}
+}
+
+func TestLeases(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		acquire := func(holder string, at time.Time, want bool) {
+			t.Helper()
+			if got, err := s.AcquireLease(ctx, "guest-purge", holder, at, time.Minute); err != nil || got != want {
+				t.Fatalf("%s: AcquireLease(%s) = %v, %v; want %v", name, holder, got, err, want)
+			}
+		}
+		acquire("a", now, true)
+		acquire("b", now.Add(30*time.Second), false)
+		// Renewing extends a's lease past the point it would have expired
+		acquire("a", now.Add(30*time.Second), true)
+		acquire("b", now.Add(80*time.Second), false)
+		acquire("b", now.Add(2*time.Minute), true)
+		acquire("a", now.Add(2*time.Minute), false)
+
+		if ok, _ := s.AcquireLease(ctx, "other-job", "a", now, time.Minute); !ok {
+			t.Errorf("%s: expected leases to be independent", name)
+		}
+		// Releasing someone else's lease is a no-op
+		if err := s.ReleaseLease(ctx, "guest-purge", "a"); err != nil {
+			t.Fatalf("%s: ReleaseLease: %v", name, err)
+		}
+		acquire("a", now.Add(2*time.Minute), false)
+		if err := s.ReleaseLease(ctx, "guest-purge", "b"); err != nil {
+			t.Fatalf("%s: ReleaseLease: %v", name, err)
+		}
+		acquire("a", now.Add(2*time.Minute), true)
+	}
+}
+
+func TestStats(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		users := []*models.User{
+			{Username: "alice", Email: "a@example.com", Password: "h", Role: "admin"},
+			{Username: "bob", Email: "b@example.com", Password: "h", Role: "user"},
+			{Username: "carol", Email: "c@example.com", Password: "h", Role: "user"},
+		}
+		for _, u := range users {
+			if _, err := s.CreateUser(ctx, u); err != nil {
+				t.Fatalf("%s: CreateUser: %v", name, err)
+			}
+		}
+		users[2].Disabled = true
+		if err := s.UpdateUser(ctx, users[2]); err != nil {
+			t.Fatalf("%s: UpdateUser: %v", name, err)
+		}
+		for _, tok := range []*models.RefreshToken{
+			{JTI: "rotated", UserID: 1, ExpiresAt: now.Add(time.Hour)},
+			{JTI: "current", UserID: 1, ParentJTI: "rotated", ExpiresAt: now.Add(time.Hour)},
+			{JTI: "other", UserID: 2, ExpiresAt: now.Add(time.Hour)},
+			{JTI: "revoked", UserID: 2, ExpiresAt: now.Add(time.Hour)},
+			{JTI: "expired", UserID: 2, ExpiresAt: now.Add(-time.Second)},
+		} {
+			if err := s.SaveRefreshToken(ctx, tok); err != nil {
+				t.Fatalf("%s: SaveRefreshToken: %v", name, err)
+			}
+		}
+		s.RevokeToken(ctx, &models.RevokedToken{JTI: "revoked", ExpiresAt: now.Add(time.Hour)})
+
+		st, err := s.Stats(ctx)
+		if err != nil {
+			t.Fatalf("%s: Stats: %v", name, err)
+		}
+		want := Stats{Users: 3, DisabledUsers: 1, Admins: 1, ActiveSessions: 2}
+		if *st != want {
+			t.Errorf("%s: Stats = %+v, want %+v", name, *st, want)
+		}
+	}
+}
+
+func TestUserExistsAndCount(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		users := []*models.User{
+			{Username: "alice", Email: "Alice@Example.com", Password: "h", Role: "admin", CreatedAt: now.Add(-48 * time.Hour)},
+			{Username: "bob", Email: "b@example.com", Password: "h", Role: "user", CreatedAt: now.Add(-time.Hour)},
+			{Username: "carol", Password: "h", Role: "user", CreatedAt: now},
+		}
+		for _, u := range users {
+			if _, err := s.CreateUser(ctx, u); err != nil {
+				t.Fatalf("%s: CreateUser: %v", name, err)
+			}
+		}
+		users[2].Disabled = true
+		if err := s.UpdateUser(ctx, users[2]); err != nil {
+			t.Fatalf("%s: UpdateUser: %v", name, err)
+		}
+
+		if ok, err := s.UsernameExists(ctx, "bob"); err != nil || !ok {
+			t.Errorf("%s: UsernameExists(bob) = %v, %v", name, ok, err)
+		}
+		if ok, err := s.UsernameExists(ctx, "dave"); err != nil || ok {
+			t.Errorf("%s: UsernameExists(dave) = %v, %v", name, ok, err)
+		}
+		if ok, err := s.EmailExists(ctx, "alice@example.com"); err != nil || !ok {
+			t.Errorf("%s: EmailExists ignoring case = %v, %v", name, ok, err)
+		}
+		if ok, err := s.EmailExists(ctx, "c@example.com"); err != nil || ok {
+			t.Errorf("%s: EmailExists(c@example.com) = %v, %v", name, ok, err)
+		}
+
+		disabled, enabled := true, false
+		for _, tc := range []struct {
+			filter UserFilter
+			want   int
+		}{
+			{UserFilter{}, 3},
+			{UserFilter{Role: "user"}, 2},
+			{UserFilter{Disabled: &disabled}, 1},
+			{UserFilter{Role: "user", Disabled: &enabled}, 1},
+			{UserFilter{CreatedAfter: now.Add(-2 * time.Hour)}, 2},
+			{UserFilter{CreatedBefore: now}, 2},
+			{UserFilter{CreatedAfter: now.Add(-2 * time.Hour), CreatedBefore: now}, 1},
+		} {
+			n, err := s.CountUsers(ctx, tc.filter)
+			if err != nil || n != tc.want {
+				t.Errorf("%s: CountUsers(%+v) = %d, %v; want %d", name, tc.filter, n, err, tc.want)
+			}
+		}
+
+		if ids, err := s.ListUserIDs(ctx, UserFilter{Role: "user"}, 10); err != nil || !slices.Equal(ids, []int64{users[1].ID, users[2].ID}) {
+			t.Errorf("%s: ListUserIDs(user) = %v, %v", name, ids, err)
+		}
+		if ids, err := s.ListUserIDs(ctx, UserFilter{}, 1); err != nil || !slices.Equal(ids, []int64{users[0].ID}) {
+			t.Errorf("%s: ListUserIDs with limit 1 = %v, %v", name, ids, err)
+		}
+	}
+}
+
+func TestUserExpiry(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Second)
+		soon, later := now.Add(time.Hour), now.Add(48*time.Hour)
+		users := []*models.User{
+			{Username: "contractor", Password: "h", ExpiresAt: &later},
+			{Username: "intern", Password: "h", ExpiresAt: &soon},
+			{Username: "staff", Password: "h"},
+			{Username: "leaver", Password: "h"},
+		}
+		for _, u := range users {
+			if _, err := s.CreateUser(ctx, u); err != nil {
+				t.Fatalf("%s: CreateUser: %v", name, err)
+			}
+		}
+		users[3].ExpiresAt, users[3].Disabled = &soon, true
+		if err := s.UpdateUser(ctx, users[3]); err != nil {
+			t.Fatalf("%s: UpdateUser: %v", name, err)
+		}
+
+		got, err := s.GetUserByID(ctx, users[0].ID)
+		if err != nil || got.ExpiresAt == nil || !got.ExpiresAt.Equal(later) {
+			t.Errorf("%s: expected the expiry to round-trip, got %+v (%v)", name, got, err)
+		}
+		if got, _ := s.GetUserByID(ctx, users[2].ID); got.ExpiresAt != nil {
+			t.Errorf("%s: expected no expiry, got %v", name, got.ExpiresAt)
+		}
+		if n, err := s.CountUsers(ctx, UserFilter{ExpiresBefore: now.Add(2 * time.Hour)}); err != nil || n != 2 {
+			t.Errorf("%s: CountUsers(ExpiresBefore) = %d, %v; want 2", name, n, err)
+		}
+
+		expiring, err := s.ListExpiringUsers(ctx, now.Add(72*time.Hour), 10)
+		if err != nil || len(expiring) != 2 || expiring[0].ID != users[1].ID || expiring[1].ID != users[0].ID {
+			t.Errorf("%s: expected the enabled expiring users soonest first, got %+v (%v)", name, expiring, err)
+		}
+		if expiring, _ := s.ListExpiringUsers(ctx, now.Add(72*time.Hour), 1); len(expiring) != 1 {
+			t.Errorf("%s: expected the limit to apply, got %d users", name, len(expiring))
+		}
+
+		users[0].ExpiresAt = nil
+		if err := s.UpdateUser(ctx, users[0]); err != nil {
+			t.Fatalf("%s: UpdateUser: %v", name, err)
+		}
+		if got, _ := s.GetUserByID(ctx, users[0].ID); got.ExpiresAt != nil {
+			t.Errorf("%s: expected the expiry to be removed, got %v", name, got.ExpiresAt)
+		}
+	}
+}
+
+func TestStatusChanges(t *testing.T) {
+	for name, s := range map[string]Store{"sqlite": newTestSQLite(t), "memory": NewMemStore()} {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Second)
+		user := &models.User{Username: "contractor", Password: "h"}
+		if _, err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("%s: CreateUser: %v", name, err)
+		}
+		reactivate := &models.StatusChange{UserID: user.ID, Action: models.StatusChangeReactivate, RunAt: now.Add(48 * time.Hour), Status: models.StatusChangePending, CreatedBy: 1}
+		suspend := &models.StatusChange{UserID: user.ID, Action: models.StatusChangeSuspend, RunAt: now.Add(time.Hour), Reason: "leave", Notify: true, Status: models.StatusChangePending, CreatedBy: 1}
+		for _, c := range []*models.StatusChange{reactivate, suspend} {
+			if err := s.CreateStatusChange(ctx, c); err != nil || c.ID == 0 {
+				t.Fatalf("%s: CreateStatusChange: %v", name, err)
+			}
+		}
+
+		got, err := s.GetStatusChange(ctx, suspend.ID)
+		if err != nil || got == nil || got.Reason != "leave" || !got.Notify || !got.RunAt.Equal(suspend.RunAt) || got.CompletedAt != nil {
+			t.Errorf("%s: GetStatusChange = %+v, %v", name, got, err)
+		}
+		if got, err := s.GetStatusChange(ctx, 999); got != nil || err != nil {
+			t.Errorf("%s: expected nil for an unknown change, got %+v, %v", name, got, err)
+		}
+		if list, err := s.ListStatusChanges(ctx, user.ID); err != nil || len(list) != 2 || list[0].ID != suspend.ID {
+			t.Errorf("%s: expected the changes in the order they run, got %+v (%v)", name, list, err)
+		}
+
+		if due, err := s.ListDueStatusChanges(ctx, now, 10); err != nil || len(due) != 0 {
+			t.Errorf("%s: expected nothing due yet, got %+v (%v)", name, due, err)
+		}
+		due, err := s.ListDueStatusChanges(ctx, now.Add(72*time.Hour), 1)
+		if err != nil || len(due) != 1 || due[0].ID != suspend.ID {
+			t.Errorf("%s: expected the first due change, got %+v (%v)", name, due, err)
+		}
+
+		if err := s.CompleteStatusChange(ctx, suspend.ID, models.StatusChangeDone, now); err != nil {
+			t.Fatalf("%s: CompleteStatusChange: %v", name, err)
+		}
+		if err := s.CompleteStatusChange(ctx, suspend.ID, models.StatusChangeCanceled, now); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound completing a change twice, got %v", name, err)
+		}
+		if got, _ := s.GetStatusChange(ctx, suspend.ID); got.Status != models.StatusChangeDone || got.CompletedAt == nil {
+			t.Errorf("%s: expected the change to be done, got %+v", name, got)
+		}
+		if due, _ := s.ListDueStatusChanges(ctx, now.Add(72*time.Hour), 10); len(due) != 1 || due[0].ID != reactivate.ID {
+			t.Errorf("%s: expected only pending changes to be due, got %+v", name, due)
+		}
+
+		if err := s.DeleteUser(ctx, user.ID); err != nil {
+			t.Fatalf("%s: DeleteUser: %v", name, err)
+		}
+		if list, _ := s.ListStatusChanges(ctx, user.ID); len(list) != 0 {
+			t.Errorf("%s: expected a deleted user's changes to be deleted, got %+v", name, list)
+		}
+	}
+}
+
+func TestInspectSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inspect.db")
+	if _, err := InspectSQLite(context.Background(), "sqlite://"+path); err == nil {
+		t.Fatal("expected a missing database to be reported")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("inspecting must not create the database")
+	}
+
+	s, err := NewSQLite(path)
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	s.Close()
+	version, err := InspectSQLite(context.Background(), "sqlite://"+path)
+	if err != nil || version != SchemaVersion() {
+		t.Fatalf("InspectSQLite = %d, %v; want %d", version, err, SchemaVersion())
+	}
+}
+
+func TestSQLitePIIEncryption(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "pii.db")
+	key := strings.Repeat("k", pii.KeySize)
+	cipher, err := pii.New([]byte(key))
+	if err != nil {
+		t.Fatalf("pii.New: %v", err)
+	}
+
+	// Rows written before encryption is turned on are encrypted on open
+	plain, err := NewSQLite(path)
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	alice := &models.User{Username: "alice", Email: "Alice@Example.com", Password: "h"}
+	if _, err := plain.CreateUser(ctx, alice); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	alice.Phone = "+15550100"
+	if err := plain.UpdateUser(ctx, alice); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	plain.Close()
+
+	s, err := NewSQLiteWithOptions(path, SQLiteOptions{PII: cipher})
+	if err != nil {
+		t.Fatalf("NewSQLiteWithOptions: %v", err)
+	}
+	bob := &models.User{Username: "bob", Email: "bob@example.com", Password: "h"}
+	if _, err := s.CreateUser(ctx, bob); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	var email, phone, emailEnc string
+	err = s.(*sqliteStore).db.QueryRow(`SELECT email, phone, email_enc FROM users WHERE id = ?`, alice.ID).Scan(&email, &phone, &emailEnc)
+	if err != nil {
+		t.Fatalf("raw select: %v", err)
+	}
+	if strings.Contains(email+phone+emailEnc, "xample") || strings.Contains(phone, "555") || emailEnc == "" {
+		t.Fatalf("PII stored in the clear: %q %q %q", email, phone, emailEnc)
+	}
+	// The search index no longer holds the plain email's trigrams
+	rows, err := s.(*sqliteStore).db.Query(`SELECT block FROM users_fts_data`)
+	if err != nil {
+		t.Fatalf("raw select: %v", err)
+	}
+	for rows.Next() {
+		var block []byte
+		rows.Scan(&block)
+		if bytes.Contains(block, []byte("@ex")) {
+			t.Errorf("search index holds a plain email: %q", block)
+		}
+	}
+	rows.Close()
+
+	if u, err := s.GetUserByEmail(ctx, "alice@example.COM"); err != nil || u == nil || u.Email != "Alice@Example.com" || u.Phone != "+15550100" {
+		t.Fatalf("GetUserByEmail = %+v, %v", u, err)
+	}
+	if u, err := s.GetUserByPhone(ctx, "+15550100"); err != nil || u == nil || u.ID != alice.ID {
+		t.Fatalf("GetUserByPhone = %+v, %v", u, err)
+	}
+	if ok, err := s.EmailExists(ctx, "BOB@example.com"); err != nil || !ok {
+		t.Fatalf("EmailExists = %v, %v", ok, err)
+	}
+	if _, err := s.CreateUser(ctx, &models.User{Username: "bob2", Email: "Bob@Example.com", Password: "h"}); err == nil {
+		t.Fatal("expected a duplicate encrypted email to be rejected")
+	}
+	if hits, _, err := s.SearchUsers(ctx, "bob@example.com", 10, 0); err != nil || len(hits) != 1 || hits[0].User.ID != bob.ID {
+		t.Fatalf("SearchUsers = %+v, %v", hits, err)
+	}
+	s.Close()
+
+	if _, err := NewSQLite(path); !errors.Is(err, ErrPIIKeyRequired) {
+		t.Fatalf("opening without the key: got %v, want ErrPIIKeyRequired", err)
+	}
+	other, _ := pii.New([]byte(strings.Repeat("x", pii.KeySize)))
+	if _, err := NewSQLiteWithOptions(path, SQLiteOptions{PII: other}); err == nil {
+		t.Fatal("expected the wrong key to be rejected")
+	}
+}
+
+// benchmarkStore returns a SQLite store seeded with 1000 users.
+func benchmarkStore(b *testing.B) Store {
+	s := newTestSQLite(b)
+	ctx := context.Background()
+	for i := range 1000 {
+		u := &models.User{Username: "user" + strconv.Itoa(i), Email: "user" + strconv.Itoa(i) + "@example.com", Password: "h"}
+		if _, err := s.CreateUser(ctx, u); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s
+}
+
+func BenchmarkGetUserByUsername(b *testing.B) {
+	s := benchmarkStore(b)
+	ctx := context.Background()
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := s.GetUserByUsername(ctx, "user"+strconv.Itoa(int(n.Add(1)%1000))); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetUserByID(b *testing.B) {
+	s := benchmarkStore(b)
+	ctx := context.Background()
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := s.GetUserByID(ctx, n.Add(1)%1000+1); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func BenchmarkCreateUser(b *testing.B) {
+	s := benchmarkStore(b)
+	ctx := context.Background()
+	for i := 0; b.Loop(); i++ {
+		if _, err := s.CreateUser(ctx, &models.User{Username: "new" + strconv.Itoa(i), Password: "h"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
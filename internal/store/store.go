@@ -3,22 +3,484 @@ package store
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/mayvqt/Sentinel/internal/models"
 )
 
+// ErrNotFound is returned by mutating operations when the target record does not exist.
+var ErrNotFound = errors.New("record not found")
+
+// ErrVersionConflict is returned by UpdateUser when the record was modified
+// since the caller read it (its version no longer matches).
+var ErrVersionConflict = errors.New("record was modified concurrently")
+
 // Store is the persistence interface used by application services.
 // It includes user-focused methods used by the handlers.
 type Store interface {
 	Close() error
 	Ping(ctx context.Context) error
 
+	// WithTx runs fn atomically: all writes made through tx are committed if
+	// fn returns nil and discarded otherwise. Implementations without
+	// transactional storage (memstore) simply invoke fn with themselves.
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+
 	// CreateUser persists a new user and returns the assigned ID on success.
 	CreateUser(ctx context.Context, u *models.User) (int64, error)
 
 	// GetUserByUsername returns a user by username or nil when not found.
 	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
 
+	// GetUserByEmail returns a user by email address, ignoring case, or nil
+	// when not found.
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+
+	// UsernameExists reports whether GetUserByUsername would find a user,
+	// without reading the user.
+	UsernameExists(ctx context.Context, username string) (bool, error)
+
+	// EmailExists reports whether GetUserByEmail would find a user,
+	// without reading the user.
+	EmailExists(ctx context.Context, email string) (bool, error)
+
+	// GetUserByPhone returns the user with the verified phone number, or
+	// nil when there is none.
+	GetUserByPhone(ctx context.Context, phone string) (*models.User, error)
+
 	// GetUserByID returns a user by ID.
 	GetUserByID(ctx context.Context, id int64) (*models.User, error)
+
+	// UpdateUser persists changes to an existing user's mutable fields
+	// (email, role, avatar URL, metadata, disabled, phone, SMS OTP) if u.Version matches the stored
+	// version, then increments u.Version. Returns ErrNotFound if the user
+	// does not exist and ErrVersionConflict if it was updated concurrently.
+	UpdateUser(ctx context.Context, u *models.User) error
+
+	// UpgradeGuest turns the guest account u.ID into a full account with
+	// u's username, email, password hash, and role, if it is still a guest
+	// at u.Version, then increments u.Version. Returns ErrNotFound if there
+	// is no such guest and ErrVersionConflict if it was updated
+	// concurrently.
+	UpgradeGuest(ctx context.Context, u *models.User) error
+
+	// PurgeGuests deletes guest accounts created before cutoff and returns
+	// how many were removed.
+	PurgeGuests(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// DeleteUser permanently removes a user. Returns ErrNotFound if the user does not exist.
+	DeleteUser(ctx context.Context, id int64) error
+
+	// MergeUsers moves sourceID's refresh tokens and audit log references
+	// (as actor or as target) to targetID, then deletes sourceID along with
+	// its remaining records. Returns ErrNotFound if either user does not
+	// exist.
+	MergeUsers(ctx context.Context, sourceID, targetID int64) (MergeCounts, error)
+
+	// SearchUsers returns a page of users whose username or email matches q
+	// by prefix, substring, or approximate spelling, best matches first,
+	// along with the total number of matches.
+	SearchUsers(ctx context.Context, q string, limit, offset int) ([]UserSearchHit, int, error)
+
+	// RecordAudit appends e to the audit log, assigning its ID and, when
+	// unset, its CreatedAt.
+	RecordAudit(ctx context.Context, e *models.AuditEvent) error
+
+	// RecordAudits appends events to the audit log in one transaction, as
+	// RecordAudit would each of them. Either all are recorded or none.
+	RecordAudits(ctx context.Context, events []*models.AuditEvent) error
+
+	// ListAuditEvents returns a page of audit events matching f, newest
+	// first, along with the total number of matches.
+	ListAuditEvents(ctx context.Context, f AuditFilter) ([]models.AuditEvent, int, error)
+
+	// CountAuditTargets returns the number of distinct target IDs among
+	// the audit events matching f, e.g. the users who logged in on a day.
+	// Limit and Offset are ignored.
+	CountAuditTargets(ctx context.Context, f AuditFilter) (int, error)
+
+	// PurgeAuditEvents deletes audit events created before cutoff and
+	// returns how many were removed.
+	PurgeAuditEvents(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// RevokeToken adds t.JTI to the token denylist until t.ExpiresAt,
+	// setting t.RevokedAt when unset. Revoking a JTI twice is not an error.
+	RevokeToken(ctx context.Context, t *models.RevokedToken) error
+
+	// ListRevokedTokens returns unexpired revocations made at or after since.
+	ListRevokedTokens(ctx context.Context, since time.Time) ([]models.RevokedToken, error)
+
+	// PurgeRevokedTokens deletes revocations that expired before cutoff and
+	// returns how many were removed.
+	PurgeRevokedTokens(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// SaveRefreshToken records an issued refresh token, setting t.IssuedAt
+	// when unset.
+	SaveRefreshToken(ctx context.Context, t *models.RefreshToken) error
+
+	// GetRefreshToken returns the refresh token record for jti, or nil if
+	// there is none.
+	GetRefreshToken(ctx context.Context, jti string) (*models.RefreshToken, error)
+
+	// ListRefreshTokens returns a user's unexpired refresh tokens, newest
+	// first.
+	ListRefreshTokens(ctx context.Context, userID int64) ([]models.RefreshToken, error)
+
+	// TouchRefreshToken records that the session of refresh token jti was
+	// used at at, unless a later use is already recorded. Unknown IDs are
+	// ignored.
+	TouchRefreshToken(ctx context.Context, jti string, at time.Time) error
+
+	// PurgeRefreshTokens deletes refresh token records that expired before
+	// cutoff and returns how many were removed.
+	PurgeRefreshTokens(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// SetRecoveryCodes replaces a user's account recovery codes with the
+	// given hashes.
+	SetRecoveryCodes(ctx context.Context, userID int64, hashes []string) error
+
+	// UseRecoveryCode consumes the user's unused recovery code with the
+	// given hash, reporting whether one was found. Each code works once.
+	UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error)
+
+	// CountRecoveryCodes returns how many unused recovery codes a user has.
+	CountRecoveryCodes(ctx context.Context, userID int64) (int, error)
+
+	// SaveEmailChange records c as the user's email change, replacing any
+	// earlier one, and sets c.CreatedAt when unset.
+	SaveEmailChange(ctx context.Context, c *models.EmailChange) error
+
+	// GetEmailChange returns the user's email change, or nil if there is none.
+	GetEmailChange(ctx context.Context, userID int64) (*models.EmailChange, error)
+
+	// GetEmailChangeByToken returns the email change whose confirm or revert
+	// token hash is hash, or nil if there is none.
+	GetEmailChangeByToken(ctx context.Context, hash string) (*models.EmailChange, error)
+
+	// DeleteEmailChange removes the user's email change. Deleting a missing
+	// change is not an error.
+	DeleteEmailChange(ctx context.Context, userID int64) error
+
+	// SaveOTPChallenge records c as the user's challenge for c.Purpose,
+	// replacing any earlier one, and sets c.CreatedAt when unset.
+	SaveOTPChallenge(ctx context.Context, c *models.OTPChallenge) error
+
+	// GetOTPChallenge returns the user's challenge for purpose, or nil if
+	// there is none.
+	GetOTPChallenge(ctx context.Context, userID int64, purpose string) (*models.OTPChallenge, error)
+
+	// DeleteOTPChallenge removes the user's challenge for purpose.
+	// Deleting a missing challenge is not an error.
+	DeleteOTPChallenge(ctx context.Context, userID int64, purpose string) error
+
+	// SaveMagicLink records l as the user's magic link, replacing any
+	// earlier one, and sets l.CreatedAt when unset.
+	SaveMagicLink(ctx context.Context, l *models.MagicLink) error
+
+	// GetMagicLink returns the user's magic link, or nil if there is none.
+	GetMagicLink(ctx context.Context, userID int64) (*models.MagicLink, error)
+
+	// GetMagicLinkByToken returns the magic link whose token hash is hash,
+	// or nil if there is none.
+	GetMagicLinkByToken(ctx context.Context, hash string) (*models.MagicLink, error)
+
+	// UseMagicLink deletes the magic link whose token hash is hash,
+	// reporting whether it existed, so that only one caller can use it.
+	UseMagicLink(ctx context.Context, hash string) (bool, error)
+
+	// CreateCanary registers c, assigning its ID and, when unset, its
+	// CreatedAt.
+	CreateCanary(ctx context.Context, c *models.Canary) error
+
+	// ListCanaries returns every registered canary, oldest first.
+	ListCanaries(ctx context.Context) ([]models.Canary, error)
+
+	// DeleteCanary removes a canary. Returns ErrNotFound if it does not
+	// exist.
+	DeleteCanary(ctx context.Context, id int64) error
+
+	// CreateWebhook registers w, assigning its ID and, when unset, its
+	// CreatedAt and UpdatedAt.
+	CreateWebhook(ctx context.Context, w *models.Webhook) error
+
+	// GetWebhook returns a webhook by ID, or nil if there is none.
+	GetWebhook(ctx context.Context, id int64) (*models.Webhook, error)
+
+	// ListWebhooks returns every registered webhook, oldest first.
+	ListWebhooks(ctx context.Context) ([]models.Webhook, error)
+
+	// UpdateWebhook saves w's URL, events, secret, and enabled flag,
+	// setting UpdatedAt. Returns ErrNotFound if it does not exist.
+	UpdateWebhook(ctx context.Context, w *models.Webhook) error
+
+	// DeleteWebhook removes a webhook; its delivery log is kept until it
+	// is purged. Returns ErrNotFound if it does not exist.
+	DeleteWebhook(ctx context.Context, id int64) error
+
+	// RecordWebhookDelivery appends d to the webhook delivery log,
+	// assigning its ID and, when unset, its CreatedAt.
+	RecordWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error
+
+	// GetWebhookDelivery returns a delivery by ID, or nil if there is none.
+	GetWebhookDelivery(ctx context.Context, id int64) (*models.WebhookDelivery, error)
+
+	// ListWebhookDeliveries returns a page of a webhook's deliveries,
+	// newest first, along with the total number of them.
+	ListWebhookDeliveries(ctx context.Context, webhookID string, limit, offset int) ([]models.WebhookDelivery, int, error)
+
+	// PurgeWebhookDeliveries deletes deliveries made before cutoff and
+	// returns how many were removed.
+	PurgeWebhookDeliveries(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// AddOutboxEvent appends e to the event outbox, assigning its ID and,
+	// when unset, its CreatedAt.
+	AddOutboxEvent(ctx context.Context, e *models.OutboxEvent) error
+
+	// ClaimOutboxEvents returns up to limit undispatched events, oldest
+	// first, that are not claimed past now, and claims them until now plus
+	// lease so that no other instance dispatches them meanwhile.
+	ClaimOutboxEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.OutboxEvent, error)
+
+	// MarkOutboxEventDispatched records that an event was dispatched at
+	// the given time.
+	MarkOutboxEventDispatched(ctx context.Context, id int64, at time.Time) error
+
+	// ListOutboxEvents returns up to limit events created in [from, to),
+	// oldest first.
+	ListOutboxEvents(ctx context.Context, from, to time.Time, limit int) ([]models.OutboxEvent, error)
+
+	// PurgeOutboxEvents deletes dispatched events created before cutoff and
+	// returns how many were removed.
+	PurgeOutboxEvents(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// SetQuota creates or replaces the quota for q.Subject, setting q's
+	// ID and timestamps.
+	SetQuota(ctx context.Context, q *models.Quota) error
+
+	// GetQuota returns the quota for subject, or nil if there is none.
+	GetQuota(ctx context.Context, subject string) (*models.Quota, error)
+
+	// ListQuotas returns every quota, ordered by subject.
+	ListQuotas(ctx context.Context) ([]models.Quota, error)
+
+	// DeleteQuota removes the quota for subject. Returns ErrNotFound if
+	// there is none.
+	DeleteQuota(ctx context.Context, subject string) error
+
+	// GetPreferences returns userID's preferences, or nil if they never
+	// saved any.
+	GetPreferences(ctx context.Context, userID int64) (*models.Preferences, error)
+
+	// SetPreferences creates or replaces userID's preferences, setting
+	// p.UpdatedAt.
+	SetPreferences(ctx context.Context, userID int64, p *models.Preferences) error
+
+	// CreateElevation records an elevation request, setting e's ID and,
+	// when unset, its CreatedAt.
+	CreateElevation(ctx context.Context, e *models.Elevation) error
+
+	// GetElevation returns an elevation request, or nil if it does not
+	// exist.
+	GetElevation(ctx context.Context, id int64) (*models.Elevation, error)
+
+	// ListElevations returns up to limit elevation requests with status,
+	// or with any status when it is empty, newest first.
+	ListElevations(ctx context.Context, status string, limit int) ([]models.Elevation, error)
+
+	// DecideElevation records the approval or denial of a pending request:
+	// e's Status, DecidedBy, DecidedAt, and ExpiresAt. Returns ErrNotFound
+	// if no pending request has e's ID, such as when another admin decided
+	// it first.
+	DecideElevation(ctx context.Context, e *models.Elevation) error
+
+	// RedeemElevation records jti as the token issued for an approved
+	// request. It reports false, changing nothing, if the request is not
+	// approved or a token was already issued for it.
+	RedeemElevation(ctx context.Context, id int64, jti string) (bool, error)
+
+	// CreateStatusChange schedules c, setting its ID and, when unset, its
+	// CreatedAt.
+	CreateStatusChange(ctx context.Context, c *models.StatusChange) error
+
+	// GetStatusChange returns a scheduled status change, or nil if it does
+	// not exist.
+	GetStatusChange(ctx context.Context, id int64) (*models.StatusChange, error)
+
+	// ListStatusChanges returns userID's scheduled status changes in the
+	// order they run.
+	ListStatusChanges(ctx context.Context, userID int64) ([]models.StatusChange, error)
+
+	// ListDueStatusChanges returns up to limit pending status changes due
+	// by now, in the order they run.
+	ListDueStatusChanges(ctx context.Context, now time.Time, limit int) ([]models.StatusChange, error)
+
+	// CompleteStatusChange moves the pending change id to status, done or
+	// canceled, at at. Returns ErrNotFound if no pending change has that
+	// ID, such as when it was already applied or canceled.
+	CompleteStatusChange(ctx context.Context, id int64, status string, at time.Time) error
+
+	// SetFeatureFlag creates or replaces the override for f.Name, setting
+	// f's ID and timestamps.
+	SetFeatureFlag(ctx context.Context, f *models.FeatureFlag) error
+
+	// GetFeatureFlag returns the override for name, or nil if there is none.
+	GetFeatureFlag(ctx context.Context, name string) (*models.FeatureFlag, error)
+
+	// ListFeatureFlags returns every override, ordered by name.
+	ListFeatureFlags(ctx context.Context) ([]models.FeatureFlag, error)
+
+	// DeleteFeatureFlag removes the override for name. Returns ErrNotFound
+	// if there is none.
+	DeleteFeatureFlag(ctx context.Context, name string) error
+
+	// SetClient creates or replaces the client registered as c.ClientID,
+	// setting c's ID and timestamps.
+	SetClient(ctx context.Context, c *models.Client) error
+
+	// GetClient returns the client registered as clientID, or nil if there
+	// is none.
+	GetClient(ctx context.Context, clientID string) (*models.Client, error)
+
+	// ListClients returns every registered client, ordered by client ID.
+	ListClients(ctx context.Context) ([]models.Client, error)
+
+	// DeleteClient unregisters clientID. Returns ErrNotFound if it is not
+	// registered.
+	DeleteClient(ctx context.Context, clientID string) error
+
+	// IncrementQuotaUsage counts one request by subject in period (e.g.
+	// "day:2024-05-01") and returns the period's new count. The counter
+	// may be purged once expiresAt has passed.
+	IncrementQuotaUsage(ctx context.Context, subject, period string, expiresAt time.Time) (int64, error)
+
+	// QuotaUsage returns the requests counted for subject in period.
+	QuotaUsage(ctx context.Context, subject, period string) (int64, error)
+
+	// PurgeQuotaUsage deletes counters that expired before cutoff and
+	// returns how many were removed.
+	PurgeQuotaUsage(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// TakeRateLimitToken refills the token bucket for key with one token
+	// per rate since its last refill, up to capacity, then takes a token if
+	// one is left. A new bucket starts full. It returns whether a token was
+	// taken and how many remain.
+	TakeRateLimitToken(ctx context.Context, key string, rate time.Duration, capacity int, now time.Time) (bool, int, error)
+
+	// PurgeRateLimits deletes buckets that had refilled completely by now,
+	// which behave like absent ones, and returns how many were removed.
+	PurgeRateLimits(ctx context.Context, now time.Time) (int64, error)
+
+	// RecordTarpitFailure counts a failed login against key, starting over
+	// at one when its last failure is more than window before now.
+	RecordTarpitFailure(ctx context.Context, key string, window time.Duration, now time.Time) error
+
+	// TarpitFailures returns the highest failure count among keys whose
+	// last failure is within window of now, or zero if none is.
+	TarpitFailures(ctx context.Context, keys []string, window time.Duration, now time.Time) (int, error)
+
+	// ClearTarpitFailures forgets the failures counted against keys.
+	ClearTarpitFailures(ctx context.Context, keys []string) error
+
+	// PurgeTarpitFailures deletes keys whose last failure was before
+	// cutoff and returns how many were removed.
+	PurgeTarpitFailures(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// UseNonce records key as used until expiresAt. It reports false,
+	// without error, when key was already used and has not expired, so
+	// that a signed request cannot be replayed.
+	UseNonce(ctx context.Context, key string, expiresAt, now time.Time) (bool, error)
+
+	// PurgeNonces deletes nonces that expired before now and returns how
+	// many were removed.
+	PurgeNonces(ctx context.Context, now time.Time) (int64, error)
+
+	// AcquireLease takes or renews the lease called name for holder until
+	// now plus ttl. It reports false, without error, while another holder's
+	// lease has not expired.
+	AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error)
+
+	// ReleaseLease gives up holder's lease called name, if it holds it.
+	ReleaseLease(ctx context.Context, name, holder string) error
+
+	// CountUsers returns how many users match filter.
+	CountUsers(ctx context.Context, filter UserFilter) (int, error)
+
+	// ListUserIDs returns the IDs of up to limit users matching filter, in
+	// ascending order.
+	ListUserIDs(ctx context.Context, filter UserFilter, limit int) ([]int64, error)
+
+	// ListExpiringUsers returns up to limit enabled users whose accounts
+	// expire before before, soonest first.
+	ListExpiringUsers(ctx context.Context, before time.Time, limit int) ([]*models.User, error)
+
+	// Stats returns aggregate counts for the admin dashboard.
+	Stats(ctx context.Context) (*Stats, error)
+}
+
+// UserFilter selects users for CountUsers and ListUserIDs. Zero fields match every user.
+type UserFilter struct {
+	Role string
+	// Disabled, when set, matches only disabled (true) or enabled (false)
+	// accounts.
+	Disabled *bool
+	// CreatedAfter and CreatedBefore bound the creation time, inclusive
+	// and exclusive respectively.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// ExpiresBefore, when set, matches only accounts with an expiry
+	// before it.
+	ExpiresBefore time.Time
+}
+
+// matches reports whether u is selected by f, for stores that filter in Go.
+func (f UserFilter) matches(u *models.User) bool {
+	switch {
+	case f.Role != "" && u.Role != f.Role:
+		return false
+	case f.Disabled != nil && u.Disabled != *f.Disabled:
+		return false
+	case !f.CreatedAfter.IsZero() && u.CreatedAt.Before(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !u.CreatedAt.Before(f.CreatedBefore):
+		return false
+	case !f.ExpiresBefore.IsZero() && (u.ExpiresAt == nil || !u.ExpiresAt.Before(f.ExpiresBefore)):
+		return false
+	}
+	return true
+}
+
+// Stats summarizes the user base. ActiveSessions counts unexpired refresh
+// tokens that have been neither rotated nor revoked.
+type Stats struct {
+	Users          int `json:"users"`
+	DisabledUsers  int `json:"disabled_users"`
+	Admins         int `json:"admins"`
+	ActiveSessions int `json:"active_sessions"`
+}
+
+// MergeCounts reports how many records MergeUsers moved.
+type MergeCounts struct {
+	RefreshTokens int64 `json:"refresh_tokens"`
+	AuditEvents   int64 `json:"audit_events"`
+}
+
+// AuditFilter selects audit events. Zero fields match everything.
+type AuditFilter struct {
+	ActorID    int64
+	TargetType string
+	TargetID   int64
+	Action     string
+	Actions    []string  // matches any of these actions
+	TokenID    string    // jti of the actor's token
+	Since      time.Time // inclusive
+	Until      time.Time // exclusive
+	Limit      int
+	Offset     int
+
+	// UserID matches events the user performed or was the subject of.
+	UserID int64
+	// BeforeID, with Until, also matches events created exactly at Until
+	// whose ID is lower, which continues a listing after that event.
+	BeforeID int64
 }
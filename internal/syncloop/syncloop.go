@@ -0,0 +1,35 @@
+// Package syncloop runs the periodic syncs that keep each instance's
+// in-memory copy of shared state, such as registered clients or feature
+// flags, current with the store.
+package syncloop
+
+import (
+	"context"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+)
+
+// DefaultInterval is used by Run when given a non-positive interval.
+const DefaultInterval = 30 * time.Second
+
+// Run calls sync every interval (DefaultInterval when zero) until ctx is
+// canceled. Failures are logged as "<name> sync failed" and retried at the
+// next tick.
+func Run(ctx context.Context, interval time.Duration, name string, sync func(context.Context) error) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := sync(ctx); err != nil && ctx.Err() == nil {
+				logger.Warn(name+" sync failed", map[string]interface{}{"error": err.Error()})
+			}
+		}
+	}
+}
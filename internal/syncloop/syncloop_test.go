@@ -0,0 +1,33 @@
+package syncloop
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(ctx, time.Millisecond, "Test", func(context.Context) error {
+			calls++
+			if calls == 3 {
+				cancel()
+			}
+			// A failure must not stop the loop
+			return errors.New("unavailable")
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Run to return once ctx is canceled")
+	}
+	if calls < 3 {
+		t.Errorf("expected syncing to go on after failures, got %d syncs", calls)
+	}
+}
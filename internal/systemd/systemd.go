@@ -0,0 +1,89 @@
+// Package systemd implements the parts of the sd_notify protocol used when
+// Sentinel runs as a systemd service with Type=notify: readiness, watchdog
+// keepalives, and the stop notification. Outside systemd, where
+// NOTIFY_SOCKET is unset, every call is a no-op.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+)
+
+// States sent with Notify.
+const (
+	// Ready tells systemd that startup is complete.
+	Ready = "READY=1"
+	// Stopping tells systemd that shutdown has begun.
+	Stopping = "STOPPING=1"
+	// Watchdog is the keepalive that resets the watchdog timer.
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends state to the socket in NOTIFY_SOCKET. It reports whether a
+// notification was sent, which is false outside systemd.
+func Notify(state string) (bool, error) {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return false, nil
+	}
+	// Names starting with @ are in the abstract namespace.
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("notify systemd: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("notify systemd: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout systemd expects keepalives
+// within, from WATCHDOG_USEC, or zero when the watchdog is off or meant for
+// another process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog sends a keepalive every half timeout while healthy returns
+// nil, until ctx is canceled. Missed keepalives let systemd restart a
+// service that is hung or has lost its store.
+func RunWatchdog(ctx context.Context, timeout time.Duration, healthy func(context.Context) error) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout/2)
+		err := healthy(checkCtx)
+		cancel()
+		if err != nil {
+			logger.Warn("Withholding watchdog keepalive", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else if _, err := Notify(Watchdog); err != nil {
+			logger.Warn("Watchdog keepalive failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listen returns a notify socket and sets NOTIFY_SOCKET to it.
+func listen(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func read(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no notification: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Fatalf("expected a no-op outside systemd, got %v, %v", sent, err)
+	}
+
+	conn := listen(t)
+	if sent, err := Notify(Ready); !sent || err != nil {
+		t.Fatalf("Notify: %v, %v", sent, err)
+	}
+	if got := read(t, conn); got != Ready {
+		t.Errorf("expected %q, got %q", Ready, got)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	if d := WatchdogInterval(); d != 0 {
+		t.Errorf("expected no watchdog, got %v", d)
+	}
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if d := WatchdogInterval(); d != 0 {
+		t.Errorf("expected another process's watchdog to be ignored, got %v", d)
+	}
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if d := WatchdogInterval(); d != 20*time.Millisecond {
+		t.Errorf("expected 20ms, got %v", d)
+	}
+
+	conn := listen(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	healthy := make(chan error, 1)
+	healthy <- errors.New("store down")
+	done := make(chan struct{})
+	go func() {
+		RunWatchdog(ctx, 20*time.Millisecond, func(context.Context) error {
+			select {
+			case err := <-healthy:
+				return err
+			default:
+				return nil
+			}
+		})
+		close(done)
+	}()
+	if got := read(t, conn); got != Watchdog {
+		t.Errorf("expected %q, got %q", Watchdog, got)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,286 @@
+// Package tarpit slows down brute-force attempts. Each failed login counts
+// against the client IP and the targeted account; once either passes a
+// threshold, further attempts are delayed, doubling with every failure up
+// to a cap. Legitimate users who mistype a password a couple of times
+// never notice, while guessing at scale becomes slow.
+package tarpit
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+)
+
+// Defaults applied to zero Config fields.
+const (
+	DefaultThreshold = 3
+	DefaultBaseDelay = 500 * time.Millisecond
+	DefaultMaxDelay  = 10 * time.Second
+	DefaultWindow    = 15 * time.Minute
+)
+
+// MaxWaiting bounds how many requests may be held at once, so the tarpit
+// cannot be turned into a way to exhaust the server's connections.
+const MaxWaiting = 1024
+
+var (
+	delaySeconds = metrics.NewHistogramVec(
+		"sentinel_tarpit_delay_seconds",
+		"Delays imposed on authentication attempts by the tarpit.",
+		[]float64{.25, .5, 1, 2, 4, 8, 16, 32},
+	)
+	decisions = metrics.NewCounterVec(
+		"sentinel_tarpit_decisions_total",
+		"Authentication attempts slowed (delayed) or turned away (rejected) by the tarpit.",
+		"decision",
+	)
+	failures = metrics.NewCounterVec(
+		"sentinel_tarpit_failures_total",
+		"Failures recorded by the tarpit, by key scope (ip or user).",
+		"scope",
+	)
+	trackedKeys = metrics.NewGaugeVec(
+		"sentinel_tarpit_tracked_keys",
+		"Client IPs and accounts with recent failures.",
+	)
+	backendErrors = metrics.NewCounterVec(
+		"sentinel_tarpit_backend_errors_total",
+		"Failed lookups and updates in the shared tarpit backend; the attempts were not delayed.",
+	)
+)
+
+// Backend holds failure counts shared by every instance, so that
+// spreading guesses across instances earns no extra attempts.
+// store.Store implements it.
+type Backend interface {
+	// RecordTarpitFailure counts a failure against key, starting over at
+	// one when its last failure is more than window before now.
+	RecordTarpitFailure(ctx context.Context, key string, window time.Duration, now time.Time) error
+	// TarpitFailures returns the highest count among keys whose last
+	// failure is within window of now.
+	TarpitFailures(ctx context.Context, keys []string, window time.Duration, now time.Time) (int, error)
+	// ClearTarpitFailures forgets the failures counted against keys.
+	ClearTarpitFailures(ctx context.Context, keys []string) error
+}
+
+// Config tunes the tarpit.
+type Config struct {
+	// Threshold is the number of failures after which attempts are
+	// delayed.
+	Threshold int
+	// BaseDelay is the first delay; each further failure doubles it, up
+	// to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is how long a key's failures are remembered after its last
+	// failure.
+	Window time.Duration
+	// RejectAtMax answers attempts whose delay has reached MaxDelay with
+	// an immediate rejection instead of holding them.
+	RejectAtMax bool
+}
+
+// Tarpit tracks failures by key. Keys are "scope:value", such as
+// "ip:203.0.113.7" or "user:alice". It is safe for concurrent use.
+type Tarpit struct {
+	cfg     Config
+	now     func() time.Time
+	waiting atomic.Int64
+	backend Backend // Shared failure counts; nil keeps them in memory
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	failures int
+	last     time.Time
+}
+
+// New returns a tarpit with cfg's zero fields set to the defaults.
+func New(cfg Config) *Tarpit {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = DefaultThreshold
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = DefaultBaseDelay
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = DefaultMaxDelay
+	}
+	cfg.MaxDelay = max(cfg.MaxDelay, cfg.BaseDelay)
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultWindow
+	}
+	return &Tarpit{cfg: cfg, now: time.Now, entries: make(map[string]*entry)}
+}
+
+// Share keeps failure counts in b instead of in memory. It must be called
+// before the tarpit is used.
+func (t *Tarpit) Share(b Backend) {
+	t.backend = b
+}
+
+// Delay returns the delay owed by the worst of keys.
+func (t *Tarpit) Delay(ctx context.Context, keys ...string) time.Duration {
+	if t.backend != nil {
+		// Fail open: an outage of the store must not lock everyone out
+		worst, err := t.backend.TarpitFailures(ctx, keys, t.cfg.Window, t.now())
+		if err != nil {
+			t.backendFailed(ctx, err)
+			return 0
+		}
+		return t.delayFor(worst)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	var worst int
+	for _, k := range keys {
+		if e := t.liveLocked(k, now); e != nil {
+			worst = max(worst, e.failures)
+		}
+	}
+	return t.delayFor(worst)
+}
+
+// delayFor returns the delay after n failures.
+func (t *Tarpit) delayFor(n int) time.Duration {
+	over := n - t.cfg.Threshold
+	if over < 0 {
+		return 0
+	}
+	d := t.cfg.BaseDelay
+	for range over {
+		d *= 2
+		if d >= t.cfg.MaxDelay {
+			return t.cfg.MaxDelay
+		}
+	}
+	return d
+}
+
+// Fail records a failure against each of keys.
+func (t *Tarpit) Fail(ctx context.Context, keys ...string) {
+	if t.backend != nil {
+		now := t.now()
+		for _, k := range keys {
+			if k == "" {
+				continue
+			}
+			if err := t.backend.RecordTarpitFailure(ctx, k, t.cfg.Window, now); err != nil {
+				t.backendFailed(ctx, err)
+				continue
+			}
+			scope, _, _ := strings.Cut(k, ":")
+			failures.WithLabelValues(scope).Inc()
+		}
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+		e := t.liveLocked(k, now)
+		if e == nil {
+			e = &entry{}
+			t.entries[k] = e
+		}
+		e.failures++
+		e.last = now
+		scope, _, _ := strings.Cut(k, ":")
+		failures.WithLabelValues(scope).Inc()
+	}
+	t.sweepLocked(now)
+}
+
+// Reset forgets the failures recorded against keys, e.g. an account's
+// after a successful login.
+func (t *Tarpit) Reset(ctx context.Context, keys ...string) {
+	if t.backend != nil {
+		if err := t.backend.ClearTarpitFailures(ctx, keys); err != nil {
+			t.backendFailed(ctx, err)
+		}
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range keys {
+		delete(t.entries, k)
+	}
+	trackedKeys.WithLabelValues().Set(float64(len(t.entries)))
+}
+
+// Wait holds the caller for the delay owed by keys and returns it. It
+// returns false without waiting when the attempt should be rejected
+// instead: the delay has reached the cap and RejectAtMax is set, or
+// MaxWaiting attempts are already being held. A canceled ctx ends the wait
+// early.
+func (t *Tarpit) Wait(ctx context.Context, keys ...string) (time.Duration, bool) {
+	d := t.Delay(ctx, keys...)
+	if d == 0 {
+		return 0, true
+	}
+	if t.cfg.RejectAtMax && d >= t.cfg.MaxDelay {
+		decisions.WithLabelValues("rejected").Inc()
+		return d, false
+	}
+	if t.waiting.Add(1) > MaxWaiting {
+		t.waiting.Add(-1)
+		decisions.WithLabelValues("rejected").Inc()
+		return d, false
+	}
+	defer t.waiting.Add(-1)
+
+	decisions.WithLabelValues("delayed").Inc()
+	delaySeconds.WithLabelValues().Observe(d.Seconds())
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	return d, true
+}
+
+// backendFailed counts and logs a failed call to the shared backend.
+func (t *Tarpit) backendFailed(ctx context.Context, err error) {
+	backendErrors.WithLabelValues().Inc()
+	logger.FromContext(ctx).Error("Shared tarpit lookup failed", map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
+// liveLocked returns k's entry, dropping it if its window has passed.
+func (t *Tarpit) liveLocked(k string, now time.Time) *entry {
+	e, ok := t.entries[k]
+	if !ok {
+		return nil
+	}
+	if now.Sub(e.last) > t.cfg.Window {
+		delete(t.entries, k)
+		return nil
+	}
+	return e
+}
+
+// sweepLocked drops expired entries at most once a minute, keeping memory
+// bounded by the number of keys that failed within the window.
+func (t *Tarpit) sweepLocked(now time.Time) {
+	if now.Sub(t.lastSweep) >= time.Minute {
+		t.lastSweep = now
+		for k := range t.entries {
+			t.liveLocked(k, now)
+		}
+	}
+	trackedKeys.WithLabelValues().Set(float64(len(t.entries)))
+}
@@ -0,0 +1,73 @@
+package tarpit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/store"
+)
+
+func TestProgressiveDelay(t *testing.T) {
+	shared := New(Config{Threshold: 2, BaseDelay: time.Second, MaxDelay: 5 * time.Second, Window: time.Minute})
+	shared.Share(store.NewMemStore())
+	for name, tp := range map[string]*Tarpit{
+		"memory": New(Config{Threshold: 2, BaseDelay: time.Second, MaxDelay: 5 * time.Second, Window: time.Minute}),
+		"shared": shared,
+	} {
+		ctx := context.Background()
+		now := time.Unix(1700000000, 0)
+		tp.now = func() time.Time { return now }
+
+		want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+		for i, d := range want {
+			if got := tp.Delay(ctx, "ip:203.0.113.7", "user:alice"); got != d {
+				t.Errorf("%s: after %d failures: expected %v, got %v", name, i, d, got)
+			}
+			tp.Fail(ctx, "ip:203.0.113.7")
+		}
+		if got := tp.Delay(ctx, "ip:198.51.100.1", "user:alice"); got != 0 {
+			t.Errorf("%s: expected other keys to be unaffected, got %v", name, got)
+		}
+
+		tp.Fail(ctx, "user:bob", "user:bob", "user:bob")
+		tp.Reset(ctx, "user:bob")
+		if got := tp.Delay(ctx, "user:bob"); got != 0 {
+			t.Errorf("%s: expected Reset to clear failures, got %v", name, got)
+		}
+
+		now = now.Add(2 * time.Minute)
+		if got := tp.Delay(ctx, "ip:203.0.113.7"); got != 0 {
+			t.Errorf("%s: expected failures to expire after the window, got %v", name, got)
+		}
+		tp.Fail(ctx, "ip:203.0.113.7")
+		if got := tp.Delay(ctx, "ip:203.0.113.7"); got != 0 {
+			t.Errorf("%s: expected an expired key to start over, got %v", name, got)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	tp := New(Config{Threshold: 1, BaseDelay: 20 * time.Millisecond, MaxDelay: 40 * time.Millisecond})
+	if d, ok := tp.Wait(context.Background(), "ip:a"); d != 0 || !ok {
+		t.Errorf("expected no wait before failures, got %v %v", d, ok)
+	}
+	tp.Fail(context.Background(), "ip:a")
+	start := time.Now()
+	if d, ok := tp.Wait(context.Background(), "ip:a"); d != 20*time.Millisecond || !ok || time.Since(start) < d {
+		t.Errorf("expected a 20ms wait, got %v %v after %v", d, ok, time.Since(start))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tp.Fail(context.Background(), "ip:a")
+	start = time.Now()
+	if _, ok := tp.Wait(ctx, "ip:a"); !ok || time.Since(start) > 20*time.Millisecond {
+		t.Errorf("expected a canceled request to stop waiting, got %v after %v", ok, time.Since(start))
+	}
+
+	tp.cfg.RejectAtMax = true
+	if d, ok := tp.Wait(context.Background(), "ip:a"); ok || d != 40*time.Millisecond {
+		t.Errorf("expected a rejection at the cap, got %v %v", d, ok)
+	}
+}
@@ -0,0 +1,196 @@
+// Package tracing implements W3C Trace Context (traceparent/tracestate)
+// parsing and propagation, and carries the trace and request IDs of the
+// current request through a context so logs and outbound calls can use them.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Propagation headers.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+	RequestIDHeader   = "X-Request-ID"
+)
+
+// maxTracestateLen caps the tracestate value propagated downstream; the
+// spec allows dropping it entirely when it is unreasonably long.
+const maxTracestateLen = 512
+
+// FlagSampled is the trace-flags bit recording an upstream sampling decision.
+const FlagSampled byte = 0x01
+
+// SpanContext identifies Sentinel's span for one request within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	// ParentID is the caller's span, zero when Sentinel started the trace.
+	ParentID   [8]byte
+	Flags      byte
+	TraceState string
+}
+
+// TraceIDString returns the trace ID as 32 lowercase hex characters.
+func (sc SpanContext) TraceIDString() string { return hex.EncodeToString(sc.TraceID[:]) }
+
+// SpanIDString returns the span ID as 16 lowercase hex characters.
+func (sc SpanContext) SpanIDString() string { return hex.EncodeToString(sc.SpanID[:]) }
+
+// HasParent reports whether the span continues a trace from a caller.
+func (sc SpanContext) HasParent() bool { return sc.ParentID != [8]byte{} }
+
+// ParentIDString returns the caller's span ID, or "" when there is none.
+func (sc SpanContext) ParentIDString() string {
+	if !sc.HasParent() {
+		return ""
+	}
+	return hex.EncodeToString(sc.ParentID[:])
+}
+
+// Traceparent formats the span as a version-00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceIDString() + "-" + sc.SpanIDString() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ParseTraceparent parses a traceparent header value. Unknown future
+// versions are accepted when their version-00 prefix is well formed, as the
+// spec requires. The returned SpanID is the caller's span.
+func ParseTraceparent(v string) (traceID [16]byte, spanID [8]byte, flags byte, ok bool) {
+	v = strings.TrimSpace(v)
+	if len(v) < 55 {
+		return traceID, spanID, 0, false
+	}
+	version := v[0:2]
+	if !isLowerHex(version) || version == "ff" {
+		return traceID, spanID, 0, false
+	}
+	if version == "00" && len(v) != 55 {
+		return traceID, spanID, 0, false
+	}
+	if len(v) > 55 && v[55] != '-' {
+		return traceID, spanID, 0, false
+	}
+	if v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return traceID, spanID, 0, false
+	}
+	if !isLowerHex(v[3:35]) || !isLowerHex(v[36:52]) || !isLowerHex(v[53:55]) {
+		return traceID, spanID, 0, false
+	}
+	hex.Decode(traceID[:], []byte(v[3:35]))
+	hex.Decode(spanID[:], []byte(v[36:52]))
+	var f [1]byte
+	hex.Decode(f[:], []byte(v[53:55]))
+	if traceID == [16]byte{} || spanID == [8]byte{} {
+		return traceID, spanID, 0, false
+	}
+	return traceID, spanID, f[0], true
+}
+
+// FromRequest starts Sentinel's span for r, continuing the caller's trace
+// when r carries a valid traceparent and starting a new sampled trace
+// otherwise. tracestate is only honored alongside a valid traceparent.
+func FromRequest(r *http.Request) SpanContext {
+	var sc SpanContext
+	if traceID, parentID, flags, ok := ParseTraceparent(r.Header.Get(TraceparentHeader)); ok {
+		sc.TraceID, sc.ParentID, sc.Flags = traceID, parentID, flags
+		if ts := strings.Join(r.Header.Values(TracestateHeader), ","); len(ts) <= maxTracestateLen {
+			sc.TraceState = ts
+		}
+	} else {
+		randomID(sc.TraceID[:])
+		sc.Flags = FlagSampled
+	}
+	randomID(sc.SpanID[:])
+	return sc
+}
+
+// randomID fills b with random bytes, never all zero.
+func randomID(b []byte) {
+	for {
+		if _, err := rand.Read(b); err != nil {
+			// crypto/rand does not fail on supported platforms.
+			b[len(b)-1] = 1
+			return
+		}
+		for _, c := range b {
+			if c != 0 {
+				return
+			}
+		}
+	}
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	requestIDKey
+)
+
+// ContextWithSpan returns a copy of ctx carrying sc.
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey, sc)
+}
+
+// SpanFromContext returns the span stored in ctx, if any.
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanKey).(SpanContext)
+	return sc, ok
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Inject adds the trace and request ID headers for an outbound call made on
+// behalf of the request in ctx. Sentinel's span becomes the parent of the
+// downstream span. It is a no-op for contexts without a request.
+func Inject(ctx context.Context, h http.Header) {
+	if sc, ok := SpanFromContext(ctx); ok {
+		h.Set(TraceparentHeader, sc.Traceparent())
+		if sc.TraceState != "" {
+			h.Set(TracestateHeader, sc.TraceState)
+		}
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		h.Set(RequestIDHeader, id)
+	}
+}
+
+// LogFields returns the request and trace identifiers in ctx as log fields.
+func LogFields(ctx context.Context) map[string]interface{} {
+	fields := make(map[string]interface{}, 4)
+	if id := RequestIDFromContext(ctx); id != "" {
+		fields["request_id"] = id
+	}
+	if sc, ok := SpanFromContext(ctx); ok {
+		fields["trace_id"] = sc.TraceIDString()
+		fields["span_id"] = sc.SpanIDString()
+		if sc.HasParent() {
+			fields["parent_span_id"] = sc.ParentIDString()
+		}
+	}
+	return fields
+}
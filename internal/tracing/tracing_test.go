@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"future version with extra fields", "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"version 00 with extra fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"forbidden version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"uppercase hex", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"truncated", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, ok := ParseTraceparent(tt.value); ok != tt.valid {
+				t.Errorf("ParseTraceparent(%q) ok = %v, want %v", tt.value, ok, tt.valid)
+			}
+		})
+	}
+}
+
+func TestFromRequestAndInject(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	r.Header.Set(TracestateHeader, "vendor=abc")
+
+	sc := FromRequest(r)
+	if sc.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.ParentIDString() != "00f067aa0ba902b7" {
+		t.Fatalf("trace not continued: %+v", sc)
+	}
+	if sc.SpanIDString() == "00f067aa0ba902b7" || sc.Flags != 0 {
+		t.Fatalf("expected a new span with the caller's flags, got %+v", sc)
+	}
+
+	ctx := ContextWithRequestID(ContextWithSpan(context.Background(), sc), "req-1")
+	h := http.Header{}
+	Inject(ctx, h)
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + sc.SpanIDString() + "-00"
+	if h.Get(TraceparentHeader) != want || h.Get(TracestateHeader) != "vendor=abc" || h.Get(RequestIDHeader) != "req-1" {
+		t.Errorf("unexpected outbound headers: %v", h)
+	}
+
+	// Without a valid traceparent a new sampled trace is started and any
+	// tracestate is ignored.
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(TraceparentHeader, "garbage")
+	r.Header.Set(TracestateHeader, "vendor=abc")
+	sc = FromRequest(r)
+	if sc.HasParent() || sc.Flags != FlagSampled || sc.TraceState != "" || sc.TraceID == [16]byte{} {
+		t.Errorf("expected a new root span, got %+v", sc)
+	}
+}
@@ -0,0 +1,497 @@
+package validation
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// MaxPasswordScore is the best PasswordStrength score.
+const MaxPasswordScore = 4
+
+// PasswordStrength estimates how hard a password is to guess, in the manner
+// of zxcvbn: the password is split into the cheapest sequence of patterns
+// an attacker would try (common passwords, the user's own details,
+// sequences, keyboard rows, repeats, dates) and random characters, and the
+// guesses for each are multiplied.
+type PasswordStrength struct {
+	// Score is 0 (under 10^3 guesses) to MaxPasswordScore (10^10 or more),
+	// rising a step for each hundredfold increase in guesses.
+	Score int `json:"score"`
+	// EntropyBits is the base-2 logarithm of the estimated guesses.
+	EntropyBits float64 `json:"entropy_bits"`
+	// Feedback suggests how to make the password harder to guess. It is
+	// empty for passwords scoring 3 or more.
+	Feedback []string `json:"feedback"`
+}
+
+// scoreBits are the guesses, as bits, needed for scores 1 to 4: 10^3,
+// 10^6, 10^8, and 10^10.
+var scoreBits = []float64{3 / math.Log10(2), 6 / math.Log10(2), 8 / math.Log10(2), 10 / math.Log10(2)}
+
+// commonWords are tried before anything else, most common first. The
+// position of a word is its guess rank.
+var commonWords = []string{
+	"password", "123456", "123456789", "12345678", "12345", "qwerty",
+	"1234567", "111111", "1234567890", "123123", "abc123", "iloveyou",
+	"admin", "welcome", "monkey", "login", "letmein", "dragon", "football",
+	"baseball", "master", "sunshine", "princess", "shadow", "superman",
+	"trustno1", "starwars", "passw0rd", "hello", "freedom", "whatever",
+	"qazwsx", "michael", "charlie", "jessica", "ashley", "mustang",
+	"secret", "summer", "winter", "spring", "autumn", "flower", "love",
+	"computer", "internet", "pokemon", "batman", "soccer", "hockey",
+	"killer", "pepper", "ginger", "cookie", "chocolate", "banana",
+	"orange", "purple", "silver", "golden", "tiger", "cheese", "hunter",
+	"ranger", "buster", "thomas", "robert", "jordan", "daniel", "andrew",
+	"joshua", "matthew", "jennifer", "nicole", "access", "root", "toor",
+	"default", "guest", "changeme", "sentinel", "test", "user", "money",
+	"happy", "lucky", "angel", "family", "friend", "forever", "blessed",
+}
+
+var commonRanks = func() map[string]int {
+	ranks := make(map[string]int, len(commonWords))
+	for i, w := range commonWords {
+		ranks[w] = i + 1
+	}
+	return ranks
+}()
+
+// leetSubs maps the substitutions people make for letters back to them.
+var leetSubs = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i',
+	'!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't',
+	'2': 'z',
+}
+
+// keyboardRows are the rows of a US keyboard, for spotting runs of
+// neighbouring keys.
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+
+// Pattern kinds, which decide the feedback given for a password.
+const (
+	patternCommon = iota
+	patternUserInput
+	patternSequence
+	patternKeyboard
+	patternRepeat
+	patternDate
+)
+
+// pattern is a guessable run of a password, runes[start:end].
+type pattern struct {
+	kind       int
+	start, end int
+	bits       float64
+	// For word patterns: whether only the first letter was capitalized,
+	// letters were substituted, or the word was reversed.
+	capitalized, leet, reversed bool
+}
+
+// EstimatePasswordStrength estimates how hard password is to guess.
+// userInputs are details an attacker may know, such as the username and
+// email address, which are treated as the most common words of all.
+func EstimatePasswordStrength(password string, userInputs ...string) PasswordStrength {
+	runes := []rune(password)
+	var inputs []string
+	for _, in := range userInputs {
+		in = strings.ToLower(in)
+		local, _, _ := strings.Cut(in, "@")
+		for _, word := range []string{in, local} {
+			if len([]rune(word)) >= 3 && !slices.Contains(inputs, word) {
+				inputs = append(inputs, word)
+			}
+		}
+	}
+
+	bits, used := guessBits(runes, inputs)
+	s := PasswordStrength{EntropyBits: math.Round(bits*10) / 10, Feedback: []string{}}
+	for _, b := range scoreBits {
+		if bits >= b {
+			s.Score++
+		}
+	}
+	if s.Score < 3 {
+		s.Feedback = feedback(runes, used)
+	}
+	return s
+}
+
+// ValidatePasswordStrength returns a ValidationError when password scores
+// below minScore. A minScore of zero accepts every password.
+func ValidatePasswordStrength(password string, minScore int, userInputs ...string) error {
+	if minScore <= 0 {
+		return nil
+	}
+	if EstimatePasswordStrength(password, userInputs...).Score < minScore {
+		return ValidationError{Field: "password", Message: "password is too easy to guess"}
+	}
+	return nil
+}
+
+// guessBits returns the bits needed to guess runes and the patterns that
+// make up the cheapest way to do it. Characters not covered by a pattern
+// cost the bits of a random character drawn from the classes the password
+// uses.
+func guessBits(runes []rune, inputs []string) (float64, []pattern) {
+	n := len(runes)
+	if n == 0 {
+		return 0, nil
+	}
+	charBits := math.Log2(float64(cardinality(runes)))
+	ending := make([][]pattern, n+1)
+	for _, p := range findPatterns(runes, inputs) {
+		// Even the likeliest pattern costs a guess of its own
+		p.bits = max(p.bits, 1)
+		ending[p.end] = append(ending[p.end], p)
+	}
+
+	// best[i] is the fewest bits to guess runes[:i], reached through
+	// via[i], or one random character when via[i] is nil
+	best := make([]float64, n+1)
+	via := make([]*pattern, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = best[i-1] + charBits
+		for j := range ending[i] {
+			p := &ending[i][j]
+			if b := best[p.start] + p.bits; b < best[i] {
+				best[i], via[i] = b, p
+			}
+		}
+	}
+
+	var used []pattern
+	for i := n; i > 0; {
+		if p := via[i]; p != nil {
+			used = append(used, *p)
+			i = p.start
+		} else {
+			i--
+		}
+	}
+	slices.Reverse(used)
+	return best[n], used
+}
+
+// cardinality returns the size of the alphabet the password draws from,
+// counting each class of character it uses.
+func cardinality(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	c := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			c += class.size
+		}
+	}
+	return c
+}
+
+func findPatterns(runes []rune, inputs []string) []pattern {
+	var ps []pattern
+	ps = append(ps, wordPatterns(runes, inputs)...)
+	ps = append(ps, sequencePatterns(runes)...)
+	ps = append(ps, keyboardPatterns(runes)...)
+	ps = append(ps, repeatPatterns(runes, inputs)...)
+	ps = append(ps, datePatterns(runes)...)
+	return ps
+}
+
+// wordPatterns finds common passwords and user inputs, including
+// capitalized, reversed, and leet-substituted forms.
+func wordPatterns(runes []rune, inputs []string) []pattern {
+	lower := make([]rune, len(runes))
+	unleet := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+		unleet[i] = lower[i]
+		if sub, ok := leetSubs[lower[i]]; ok {
+			unleet[i] = sub
+		}
+	}
+	rank := func(word string) (int, int) {
+		if slices.Contains(inputs, word) {
+			return 1, patternUserInput
+		}
+		if r, ok := commonRanks[word]; ok {
+			return r + len(inputs), patternCommon
+		}
+		return 0, 0
+	}
+
+	var ps []pattern
+	for i := range runes {
+		for j := i + 3; j <= len(runes); j++ {
+			for _, variant := range []struct {
+				text     []rune
+				leet     bool
+				reversed bool
+			}{
+				{lower[i:j], false, false},
+				{unleet[i:j], true, false},
+				{reversedRunes(lower[i:j]), false, true},
+			} {
+				if variant.leet && slices.Equal(variant.text, lower[i:j]) {
+					continue
+				}
+				r, kind := rank(string(variant.text))
+				if r == 0 {
+					continue
+				}
+				p := pattern{kind: kind, start: i, end: j, bits: math.Log2(float64(r)), leet: variant.leet, reversed: variant.reversed}
+				p.bits += caseBits(runes[i:j], &p)
+				if variant.leet {
+					subs := 0
+					for k := i; k < j; k++ {
+						if unleet[k] != lower[k] {
+							subs++
+						}
+					}
+					p.bits += float64(subs)
+				}
+				if variant.reversed {
+					p.bits++
+				}
+				ps = append(ps, p)
+			}
+		}
+	}
+	return ps
+}
+
+// caseBits returns the extra bits for the capitalization of a word: one for
+// the usual first-letter or all-caps forms, and one per letter in the
+// minority case otherwise.
+func caseBits(word []rune, p *pattern) float64 {
+	var upper, lower int
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 0
+	case unicode.IsUpper(word[0]) && upper == 1:
+		p.capitalized = true
+		return 1
+	case lower == 0:
+		return 1
+	}
+	return float64(min(upper, lower))
+}
+
+func reversedRunes(rs []rune) []rune {
+	out := slices.Clone(rs)
+	slices.Reverse(out)
+	return out
+}
+
+// sequencePatterns finds runs of three or more letters or digits that step
+// up or down by one, like "abc" or "9876".
+func sequencePatterns(runes []rune) []pattern {
+	class := func(r rune) int {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 1
+		case r >= 'A' && r <= 'Z':
+			return 2
+		case r >= '0' && r <= '9':
+			return 3
+		}
+		return 0
+	}
+	var ps []pattern
+	for i := 0; i < len(runes)-2; {
+		delta := runes[i+1] - runes[i]
+		j := i + 1
+		for j < len(runes) && (delta == 1 || delta == -1) && class(runes[j]) != 0 &&
+			class(runes[j]) == class(runes[i]) && runes[j]-runes[j-1] == delta {
+			j++
+		}
+		if j-i >= 3 {
+			base := 26.0
+			switch {
+			case strings.ContainsRune("aAzZ019", runes[i]):
+				base = 4
+			case class(runes[i]) == 3:
+				base = 10
+			}
+			bits := math.Log2(base) + math.Log2(float64(j-i))
+			if delta < 0 {
+				bits++
+			}
+			ps = append(ps, pattern{kind: patternSequence, start: i, end: j, bits: bits})
+			i = j - 1
+		} else {
+			i++
+		}
+	}
+	return ps
+}
+
+// keyboardPatterns finds runs of four or more neighbouring keys along a
+// keyboard row, like "asdf" or "poiuy".
+func keyboardPatterns(runes []rune) []pattern {
+	position := func(r rune) (int, int) {
+		r = unicode.ToLower(r)
+		for row, keys := range keyboardRows {
+			if col := strings.IndexRune(keys, r); col >= 0 {
+				return row, col
+			}
+		}
+		return -1, -1
+	}
+	var ps []pattern
+	for i := 0; i < len(runes)-3; {
+		row, col := position(runes[i])
+		nextRow, nextCol := position(runes[i+1])
+		step := nextCol - col
+		if nextRow != row {
+			step = 0
+		}
+		j := i + 1
+		for row >= 0 && (step == 1 || step == -1) && j < len(runes) {
+			r, c := position(runes[j])
+			if r != row || c != col+step*(j-i) {
+				break
+			}
+			j++
+		}
+		if j-i >= 4 {
+			bits := math.Log2(47) + math.Log2(float64(j-i))
+			if step < 0 {
+				bits++
+			}
+			ps = append(ps, pattern{kind: patternKeyboard, start: i, end: j, bits: bits})
+			i = j - 1
+		} else {
+			i++
+		}
+	}
+	return ps
+}
+
+// repeatPatterns finds a unit repeated back to back, like "aaa" or
+// "abcabc", which costs the guesses for the unit and its count.
+func repeatPatterns(runes []rune, inputs []string) []pattern {
+	var ps []pattern
+	for i := range runes {
+		for unit := 1; i+2*unit <= len(runes); unit++ {
+			count := 1
+			for i+(count+1)*unit <= len(runes) && slices.Equal(runes[i+count*unit:i+(count+1)*unit], runes[i:i+unit]) {
+				count++
+			}
+			if count < 2 || count*unit < 3 {
+				continue
+			}
+			unitBits, _ := guessBits(runes[i:i+unit], inputs)
+			ps = append(ps, pattern{kind: patternRepeat, start: i, end: i + count*unit, bits: unitBits + math.Log2(float64(count))})
+		}
+	}
+	return ps
+}
+
+// datePatterns finds years from 1900 to 2039 and all-digit dates in
+// day-month-year, month-day-year, or year-month-day order.
+func datePatterns(runes []rune) []pattern {
+	number := func(rs []rune) int {
+		n := 0
+		for _, r := range rs {
+			if r < '0' || r > '9' {
+				return -1
+			}
+			n = n*10 + int(r-'0')
+		}
+		return n
+	}
+	validDate := func(day, month int) bool {
+		return day >= 1 && day <= 31 && month >= 1 && month <= 12
+	}
+	yearBits := math.Log2(140)
+
+	var ps []pattern
+	for i := range runes {
+		if i+4 <= len(runes) {
+			if y := number(runes[i : i+4]); y >= 1900 && y <= 2039 {
+				ps = append(ps, pattern{kind: patternDate, start: i, end: i + 4, bits: yearBits})
+			}
+		}
+		for _, length := range []int{6, 8} {
+			if i+length > len(runes) {
+				continue
+			}
+			d := runes[i : i+length]
+			if number(d) < 0 {
+				continue
+			}
+			yearLen := length - 4
+			first, second := number(d[:2]), number(d[2:4])
+			lastTwo, middle := number(d[length-2:]), number(d[yearLen:yearLen+2])
+			if validDate(first, second) || validDate(second, first) || validDate(lastTwo, middle) {
+				ps = append(ps, pattern{kind: patternDate, start: i, end: i + length, bits: yearBits + math.Log2(366)})
+			}
+		}
+	}
+	return ps
+}
+
+// feedback suggests improvements based on the patterns that made the
+// password guessable.
+func feedback(runes []rune, used []pattern) []string {
+	var out []string
+	add := func(s string) {
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	for _, p := range used {
+		switch p.kind {
+		case patternCommon:
+			if p.start == 0 && p.end == len(runes) {
+				add("This is a commonly used password")
+			} else {
+				add("Avoid common words and passwords")
+			}
+		case patternUserInput:
+			add("Avoid your username and email address")
+		case patternSequence:
+			add("Avoid sequences like abc or 6543")
+		case patternKeyboard:
+			add("Avoid straight rows of keys like asdf")
+		case patternRepeat:
+			add("Avoid repeated characters and words")
+		case patternDate:
+			add("Avoid years and dates, especially ones associated with you")
+		}
+		if p.capitalized {
+			add("Capitalizing the first letter doesn't help much")
+		}
+		if p.leet {
+			add("Predictable substitutions like '@' for 'a' don't help much")
+		}
+		if p.reversed {
+			add("Reversed words aren't much harder to guess")
+		}
+	}
+	add("Add another word or two; uncommon words are better")
+	return out
+}
@@ -0,0 +1,198 @@
+package validation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Character classes a UsernamePolicy can allow.
+const (
+	CharsLetters    = "letters"    // ASCII letters
+	CharsDigits     = "digits"     // ASCII digits
+	CharsUnderscore = "underscore" // _
+	CharsHyphen     = "hyphen"     // -
+	CharsDot        = "dot"        // .
+	CharsUnicode    = "unicode"    // any Unicode letter or digit
+)
+
+// Default username rules.
+const (
+	DefaultUsernameMinLength = 3
+	DefaultUsernameMaxLength = 32
+)
+
+// DefaultUsernameCharsets are the character classes allowed by default.
+var DefaultUsernameCharsets = []string{CharsLetters, CharsDigits, CharsUnderscore, CharsHyphen}
+
+// DefaultReservedUsernames cannot be registered unless a reserved list is
+// configured.
+var DefaultReservedUsernames = []string{"admin", "root", "user", "api", "www", "mail", "system", "support", "null", "undefined"}
+
+// charsetDescriptions name each class in validation messages, in the order
+// they are listed.
+var charsetDescriptions = []struct{ class, desc string }{
+	{CharsUnicode, "letters and numbers in any script"},
+	{CharsLetters, "letters"},
+	{CharsDigits, "numbers"},
+	{CharsUnderscore, "underscores"},
+	{CharsHyphen, "hyphens"},
+	{CharsDot, "dots"},
+}
+
+// UsernamePolicy decides which usernames may be registered: their length
+// in characters, the character classes they may use, and names reserved
+// for the service. Reserved names are matched case-insensitively.
+type UsernamePolicy struct {
+	minLength int
+	maxLength int
+	classes   map[string]bool
+	reserved  map[string]bool
+	// charsMessage explains the allowed characters.
+	charsMessage string
+}
+
+// NewUsernamePolicy returns a policy allowing usernames of minLength to
+// maxLength characters drawn from classes (see the Chars constants), other
+// than the reserved names.
+func NewUsernamePolicy(minLength, maxLength int, classes, reserved []string) (*UsernamePolicy, error) {
+	if minLength < 1 || maxLength < minLength {
+		return nil, fmt.Errorf("invalid username length range %d-%d", minLength, maxLength)
+	}
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("at least one username character class is required")
+	}
+	p := &UsernamePolicy{
+		minLength: minLength,
+		maxLength: maxLength,
+		classes:   make(map[string]bool),
+		reserved:  make(map[string]bool),
+	}
+	for _, c := range classes {
+		c = strings.ToLower(strings.TrimSpace(c))
+		known := false
+		for _, d := range charsetDescriptions {
+			known = known || d.class == c
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown username character class %q", c)
+		}
+		p.classes[c] = true
+	}
+	for _, name := range reserved {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			p.reserved[name] = true
+		}
+	}
+
+	var descs []string
+	for _, d := range charsetDescriptions {
+		if !p.classes[d.class] {
+			continue
+		}
+		if p.classes[CharsUnicode] && (d.class == CharsLetters || d.class == CharsDigits) {
+			continue // covered by CharsUnicode
+		}
+		descs = append(descs, d.desc)
+	}
+	p.charsMessage = "username can only contain " + joinList(descs)
+	return p, nil
+}
+
+// defaultUsernamePolicy backs ValidateUsername.
+var defaultUsernamePolicy, _ = NewUsernamePolicy(DefaultUsernameMinLength, DefaultUsernameMaxLength,
+	DefaultUsernameCharsets, DefaultReservedUsernames)
+
+// DefaultUsernamePolicy returns the built-in username rules.
+func DefaultUsernamePolicy() *UsernamePolicy {
+	return defaultUsernamePolicy
+}
+
+// LoadReservedUsernames reads reserved names from path, one per line.
+// Blank lines and lines starting with # are ignored.
+func LoadReservedUsernames(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reserved usernames: %w", err)
+	}
+	defer f.Close()
+
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read reserved usernames: %w", err)
+	}
+	return names, nil
+}
+
+// Validate checks username against the policy.
+func (p *UsernamePolicy) Validate(username string) error {
+	if username == "" {
+		return ValidationError{Field: "username", Message: "username is required"}
+	}
+
+	n := utf8.RuneCountInString(username)
+	if n < p.minLength {
+		return ValidationError{Field: "username", Message: fmt.Sprintf("username must be at least %d characters", p.minLength)}
+	}
+	if n > p.maxLength {
+		return ValidationError{Field: "username", Message: fmt.Sprintf("username must be less than %d characters", p.maxLength+1)}
+	}
+
+	for _, r := range username {
+		if !p.allows(r) {
+			return ValidationError{Field: "username", Message: p.charsMessage}
+		}
+	}
+
+	if p.IsReserved(username) {
+		return ValidationError{Field: "username", Message: "username is reserved"}
+	}
+	return nil
+}
+
+// IsReserved reports whether username is one of the policy's reserved names.
+func (p *UsernamePolicy) IsReserved(username string) bool {
+	return p.reserved[strings.ToLower(username)]
+}
+
+func (p *UsernamePolicy) allows(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		return p.classes[CharsLetters] || p.classes[CharsUnicode]
+	case '0' <= r && r <= '9':
+		return p.classes[CharsDigits] || p.classes[CharsUnicode]
+	case r == '_':
+		return p.classes[CharsUnderscore]
+	case r == '-':
+		return p.classes[CharsHyphen]
+	case r == '.':
+		return p.classes[CharsDot]
+	case unicode.IsLetter(r) || unicode.IsDigit(r):
+		return p.classes[CharsUnicode]
+	}
+	return false
+}
+
+// joinList joins items as "a, b, and c".
+func joinList(items []string) string {
+	switch len(items) {
+	case 0:
+		return ""
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " and " + items[1]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + ", and " + items[len(items)-1]
+}
@@ -12,8 +12,8 @@ var (
 	// Email validation regex - RFC 5322 compliant
 	emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 
-	// Username validation regex - alphanumeric, underscore, hyphen, 3-32 chars
-	usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{3,32}$`)
+	// E.164 phone number: "+", a country code, and at most 15 digits
+	phoneRegex = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
 )
 
 // ValidationError represents a validation error with a user-friendly message.
@@ -61,36 +61,23 @@ func ValidateEmail(email string) error {
 	return nil
 }
 
-// ValidateUsername validates username format, length, and content.
-func ValidateUsername(username string) error {
-	if username == "" {
-		return ValidationError{Field: "username", Message: "username is required"}
-	}
-
-	if len(username) < 3 {
-		return ValidationError{Field: "username", Message: "username must be at least 3 characters"}
-	}
-
-	if len(username) > 32 {
-		return ValidationError{Field: "username", Message: "username must be less than 33 characters"}
+// ValidatePhone validates that phone is in E.164 format, e.g. +14155550100.
+func ValidatePhone(phone string) error {
+	if phone == "" {
+		return ValidationError{Field: "phone", Message: "phone is required"}
 	}
-
-	if !usernameRegex.MatchString(username) {
-		return ValidationError{Field: "username", Message: "username can only contain letters, numbers, underscores, and hyphens"}
+	if !phoneRegex.MatchString(phone) {
+		return ValidationError{Field: "phone", Message: "phone must be in E.164 format, e.g. +14155550100"}
 	}
-
-	// Prevent reserved usernames
-	reserved := []string{"admin", "root", "user", "api", "www", "mail", "system", "support", "null", "undefined"}
-	lowerUsername := strings.ToLower(username)
-	for _, r := range reserved {
-		if lowerUsername == r {
-			return ValidationError{Field: "username", Message: "username is reserved"}
-		}
-	}
-
 	return nil
 }
 
+// ValidateUsername validates username format, length, and content against
+// the default policy.
+func ValidateUsername(username string) error {
+	return DefaultUsernamePolicy().Validate(username)
+}
+
 // ValidatePassword validates password strength using comprehensive criteria.
 func ValidatePassword(password string) error {
 	if password == "" {
@@ -154,6 +141,21 @@ func ValidatePassword(password string) error {
 	return nil
 }
 
+// ValidatePasswordContext rejects passwords that contain the username or
+// the local part of the email address, ignoring case. Parts shorter than
+// four characters are not checked, as they match too many passwords by
+// chance.
+func ValidatePasswordContext(password, username, email string) error {
+	lower := strings.ToLower(password)
+	local, _, _ := strings.Cut(email, "@")
+	for _, part := range []string{username, local} {
+		if len(part) >= 4 && strings.Contains(lower, strings.ToLower(part)) {
+			return ValidationError{Field: "password", Message: "password must not contain your username or email address"}
+		}
+	}
+	return nil
+}
+
 // ValidateRole validates user role.
 func ValidateRole(role string) error {
 	if role == "" {
@@ -190,9 +192,15 @@ func isCommonPassword(password string) bool {
 
 // ValidateRegisterRequest validates a complete registration request.
 func ValidateRegisterRequest(username, email, password string) error {
+	return ValidateRegistration(DefaultUsernamePolicy(), username, email, password)
+}
+
+// ValidateRegistration validates a complete registration request, checking
+// the username against policy.
+func ValidateRegistration(policy *UsernamePolicy, username, email, password string) error {
 	var errs ValidationErrors
 
-	if err := ValidateUsername(username); err != nil {
+	if err := policy.Validate(username); err != nil {
 		if ve, ok := err.(ValidationError); ok {
 			errs = append(errs, ve)
 		} else {
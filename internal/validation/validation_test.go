@@ -1,6 +1,10 @@
 package validation
 
 import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -31,6 +35,26 @@ func TestValidateEmail(t *testing.T) {
 	}
 }
 
+func TestValidatePhone(t *testing.T) {
+	for phone, valid := range map[string]bool{
+		"+14155550100":      true,
+		"+4915112345678":    true,
+		"+8610123456789012": false, // 16 digits
+		"14155550100":       false,
+		"+04155550100":      false,
+		"+1 415 555 0100":   false,
+		"+1415555":          true,
+		"+141555":           false,
+		"":                  false,
+		"+1415555010a":      false,
+		"+1415555010\n":     false,
+	} {
+		if err := ValidatePhone(phone); (err == nil) != valid {
+			t.Errorf("ValidatePhone(%q) = %v, want valid=%v", phone, err, valid)
+		}
+	}
+}
+
 func TestValidateUsername(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -60,6 +84,61 @@ func TestValidateUsername(t *testing.T) {
 	}
 }
 
+func TestUsernamePolicy(t *testing.T) {
+	p, err := NewUsernamePolicy(2, 5, []string{CharsUnicode, CharsDot}, []string{" Staff "})
+	if err != nil {
+		t.Fatalf("NewUsernamePolicy: %v", err)
+	}
+	for name, wantErr := range map[string]bool{
+		"jo":     false,
+		"zoë.k":  false,
+		"用户名":    false,
+		"j":      true,
+		"jo_k":   true,
+		"abcdef": true,
+		"STAFF":  true,
+	} {
+		if err := p.Validate(name); (err != nil) != wantErr {
+			t.Errorf("Validate(%q) = %v, wantErr %v", name, err, wantErr)
+		}
+	}
+	if err := p.Validate("a b"); err == nil || err.Error() != "username: username can only contain letters and numbers in any script and dots" {
+		t.Errorf("unexpected charset message: %v", err)
+	}
+	if err := ValidateUsername("a.b.c"); err == nil || err.Error() != "username: username can only contain letters, numbers, underscores, and hyphens" {
+		t.Errorf("unexpected default charset message: %v", err)
+	}
+
+	for _, bad := range []struct {
+		min, max int
+		classes  []string
+	}{
+		{0, 5, []string{CharsLetters}},
+		{5, 4, []string{CharsLetters}},
+		{3, 8, nil},
+		{3, 8, []string{"emoji"}},
+	} {
+		if _, err := NewUsernamePolicy(bad.min, bad.max, bad.classes, nil); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestLoadReservedUsernames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reserved.txt")
+	os.WriteFile(path, []byte("# brand names\nsentinel\n\n  billing  \n"), 0o600)
+	names, err := LoadReservedUsernames(path)
+	if err != nil {
+		t.Fatalf("LoadReservedUsernames: %v", err)
+	}
+	if len(names) != 2 || names[0] != "sentinel" || names[1] != "billing" {
+		t.Errorf("unexpected names %q", names)
+	}
+	if _, err := LoadReservedUsernames(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
 func TestValidatePassword(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -89,6 +168,31 @@ func TestValidatePassword(t *testing.T) {
 	}
 }
 
+func TestValidatePasswordContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		username string
+		email    string
+		wantErr  bool
+	}{
+		{"unrelated password", "Blue-Horse-42!", "alice", "alice@example.com", false},
+		{"contains username", "xALICE2024!", "alice", "a@example.com", true},
+		{"contains email local part", "Jsmith-99!", "johnny", "jsmith@example.com", true},
+		{"short username ignored", "Bob#Secure9", "bob", "robert@example.com", false},
+		{"domain ignored", "Example.com1!", "carol", "carol@example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePasswordContext(tt.password, tt.username, tt.email)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePasswordContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestValidateRole(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -205,3 +309,61 @@ func BenchmarkValidateEmail(b *testing.B) {
 		ValidateEmail(email)
 	}
 }
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	tests := []struct {
+		password     string
+		maxScore     int
+		minScore     int
+		wantFeedback string
+	}{
+		{"password", 0, 0, "This is a commonly used password"},
+		{"P@ssw0rd", 0, 0, "Predictable substitutions like '@' for 'a' don't help much"},
+		{"drowssap", 0, 0, "Reversed words aren't much harder to guess"},
+		{"abcdefgh", 0, 0, "Avoid sequences like abc or 6543"},
+		{"asdfghjkl", 0, 0, "Avoid straight rows of keys like asdf"},
+		{"zzzzzzzzzz", 0, 0, "Avoid repeated characters and words"},
+		{"alice1990", 0, 0, "Avoid your username and email address"},
+		{"19901231", 1, 0, "Avoid years and dates, especially ones associated with you"},
+		{"x7$Kq!9zLm", 4, 4, ""},
+		{"correcthorsebatterystaple", 4, 4, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			s := EstimatePasswordStrength(tt.password, "alice", "alice@example.com")
+			if s.Score < tt.minScore || s.Score > tt.maxScore {
+				t.Errorf("score %d (%.1f bits), want %d to %d", s.Score, s.EntropyBits, tt.minScore, tt.maxScore)
+			}
+			if tt.wantFeedback == "" && len(s.Feedback) != 0 {
+				t.Errorf("unexpected feedback %v", s.Feedback)
+			}
+			if tt.wantFeedback != "" && !slices.Contains(s.Feedback, tt.wantFeedback) {
+				t.Errorf("feedback %v, want %q", s.Feedback, tt.wantFeedback)
+			}
+		})
+	}
+
+	// Patterns only ever lower the estimate from random characters
+	if s := EstimatePasswordStrength("Tr0ub4dour&3"); s.EntropyBits > 12*math.Log2(95) {
+		t.Errorf("estimate %.1f bits exceeds brute force", s.EntropyBits)
+	}
+	if s := EstimatePasswordStrength(""); s.Score != 0 || s.EntropyBits != 0 {
+		t.Errorf("unexpected estimate %+v for an empty password", s)
+	}
+}
+
+func TestValidatePasswordStrength(t *testing.T) {
+	if err := ValidatePasswordStrength("Password1!", 0); err != nil {
+		t.Errorf("expected no minimum score to accept anything, got %v", err)
+	}
+	if err := ValidatePasswordStrength("Password1!", 3); err == nil {
+		t.Error("expected a guessable password to be rejected")
+	}
+	if err := ValidatePasswordStrength("x7$Kq!9zLm", 3); err != nil {
+		t.Errorf("expected a random password to be accepted, got %v", err)
+	}
+	if err := ValidatePasswordStrength("Alice-2024!", 3, "alice"); err == nil {
+		t.Error("expected the username to count against the password")
+	}
+}
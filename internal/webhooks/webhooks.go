@@ -0,0 +1,485 @@
+// Package webhooks delivers events to webhook endpoints: those configured
+// at startup, such as the alert webhook, and subscriptions that
+// administrators manage at runtime. Every request is signed (see
+// pkg/webhook, which consumers use to verify them) and every attempt is
+// recorded in the delivery log, from which administrators can redeliver.
+//
+// Events for subscriptions go through an outbox in the store: Publish
+// persists them and Run delivers them, so an event survives a crash
+// between the two, and stays available for replay afterwards.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/httpclient"
+	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/metrics"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/pkg/webhook"
+)
+
+// SendTimeout bounds one delivery, including retries.
+const SendTimeout = 30 * time.Second
+
+// MinSecretLength is the shortest signing secret accepted.
+const MinSecretLength = 32
+
+// DefaultDispatchInterval is how often Run checks the outbox when given a
+// non-positive interval.
+const DefaultDispatchInterval = 5 * time.Second
+
+// Outbox events are claimed dispatchBatch at a time, for dispatchLease: long
+// enough to deliver them all to a few slow subscriptions, after which a
+// crashed instance's events are claimed again.
+const (
+	dispatchBatch = 10
+	dispatchLease = 5 * time.Minute
+)
+
+// maxErrorBody bounds how much of a failed response is kept in the
+// delivery log.
+const maxErrorBody = 512
+
+var deliveries = metrics.NewCounterVec(
+	"sentinel_webhook_deliveries_total",
+	"Webhook deliveries by webhook and result.",
+	"webhook", "result",
+)
+
+// Deliveries are retried: the event ID header lets endpoints recognize a
+// repeated one.
+var httpClient = sync.OnceValue(func() *http.Client {
+	return httpclient.New("webhook", httpclient.Options{
+		Timeout:            SendTimeout,
+		MaxRetries:         3,
+		RetryNonIdempotent: true,
+	})
+})
+
+// Event types delivered to webhook subscriptions.
+const (
+	EventUserRegister    = "user.register"
+	EventUserLogin       = "user.login"
+	EventUserLoginFailed = "user.login.failed"
+	EventUserDisable     = "user.disable"
+	EventUserEnable      = "user.enable"
+	EventUserDelete      = "user.delete"
+	EventUserMerge       = "user.merge"
+	// EventRateLimitWarning is sent when a client nears a rate limit.
+	EventRateLimitWarning = "ratelimit.warning"
+	// EventTest is sent by the test-delivery endpoint, whatever events
+	// the subscription names.
+	EventTest = "webhook.test"
+)
+
+// Events lists the event types a subscription may name.
+var Events = []string{
+	EventUserRegister,
+	EventUserLogin,
+	EventUserLoginFailed,
+	EventUserDisable,
+	EventUserEnable,
+	EventUserDelete,
+	EventUserMerge,
+	EventRateLimitWarning,
+}
+
+// ValidEvent reports whether a subscription may name event.
+func ValidEvent(event string) bool {
+	return event == models.WebhookAllEvents || slices.Contains(Events, event)
+}
+
+// Event is the request body of a published event.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Endpoint is a webhook endpoint that receives events.
+type Endpoint struct {
+	ID  string
+	URL string
+	// Secrets sign requests to the endpoint; without any they are unsigned.
+	Secrets []string
+}
+
+// Store holds webhook subscriptions and the delivery log. store.Store
+// satisfies it.
+type Store interface {
+	GetWebhook(ctx context.Context, id int64) (*models.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]models.Webhook, error)
+	RecordWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error
+	AddOutboxEvent(ctx context.Context, e *models.OutboxEvent) error
+	ClaimOutboxEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.OutboxEvent, error)
+	MarkOutboxEventDispatched(ctx context.Context, id int64, at time.Time) error
+}
+
+// ParseSecrets parses a comma-separated list of signing secrets. Every
+// secret signs each request, so a new secret can be added before
+// consumers switch to it and the old one removed after.
+func ParseSecrets(spec string) ([]string, error) {
+	var secrets []string
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if len(s) < MinSecretLength {
+			return nil, fmt.Errorf("webhook signing secrets must be at least %d characters", MinSecretLength)
+		}
+		secrets = append(secrets, s)
+	}
+	return secrets, nil
+}
+
+// Validate checks a subscription's URL, events, and secret, removing
+// duplicate events.
+func Validate(w *models.Webhook) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.Fragment != "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if len(w.Events) == 0 {
+		return errors.New("events must name at least one event type")
+	}
+	for _, e := range w.Events {
+		if !ValidEvent(e) {
+			return fmt.Errorf("unknown event type %q", e)
+		}
+	}
+	slices.Sort(w.Events)
+	w.Events = slices.Compact(w.Events)
+	if len(w.Secret) < MinSecretLength {
+		return fmt.Errorf("secret must be at least %d characters", MinSecretLength)
+	}
+	return nil
+}
+
+// NewSecret returns a random signing secret for a subscription.
+func NewSecret() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// Dispatcher delivers events to the configured endpoints and to the
+// subscriptions in its store.
+type Dispatcher struct {
+	store     Store
+	endpoints map[string]Endpoint
+	now       func() time.Time
+	wake      chan struct{}
+	// lastErr holds the error of Run's most recent dispatch, or nil.
+	lastErr atomic.Pointer[error]
+}
+
+// New returns a Dispatcher for the subscriptions in s and the configured
+// endpoints, which are signed with secrets (unsigned when empty). Their IDs
+// must not be numeric, as those name subscriptions.
+func New(s Store, secrets []string, endpoints ...Endpoint) *Dispatcher {
+	d := &Dispatcher{store: s, endpoints: make(map[string]Endpoint), now: time.Now, wake: make(chan struct{}, 1)}
+	for _, ep := range endpoints {
+		ep.Secrets = secrets
+		d.endpoints[ep.ID] = ep
+	}
+	return d
+}
+
+// Endpoint returns the endpoint with the given ID, a configured one or a
+// subscription, or nil if there is none.
+func (d *Dispatcher) Endpoint(ctx context.Context, id string) (*Endpoint, error) {
+	if ep, ok := d.endpoints[id]; ok {
+		return &ep, nil
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	w, err := d.store.GetWebhook(ctx, n)
+	if err != nil || w == nil {
+		return nil, err
+	}
+	return subscriptionEndpoint(w), nil
+}
+
+func subscriptionEndpoint(w *models.Webhook) *Endpoint {
+	return &Endpoint{ID: strconv.FormatInt(w.ID, 10), URL: w.URL, Secrets: []string{w.Secret}}
+}
+
+// Deliver sends payload, encoded as JSON, to the endpoint webhookID as a
+// new event of the given type. The delivery is recorded whether or not it
+// succeeds; a failed one is returned along with the error.
+func (d *Dispatcher) Deliver(ctx context.Context, webhookID, event string, payload interface{}) (*models.WebhookDelivery, error) {
+	ep, err := d.Endpoint(ctx, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	if ep == nil {
+		return nil, fmt.Errorf("unknown webhook %q", webhookID)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	eventID, err := newEventID()
+	if err != nil {
+		return nil, err
+	}
+	return d.send(ctx, ep, &models.WebhookDelivery{EventID: eventID, Event: event, Payload: body})
+}
+
+// Publish adds e to the outbox, filling in its ID and CreatedAt when unset,
+// and wakes Run to deliver it to every enabled subscription to its type.
+// Once Publish returns, the event is delivered even if this instance
+// crashes first: another instance, or this one after a restart, picks it
+// up.
+func (d *Dispatcher) Publish(ctx context.Context, e Event) error {
+	body, err := d.encode(&e)
+	if err != nil {
+		return err
+	}
+	if err := d.store.AddOutboxEvent(ctx, &models.OutboxEvent{
+		EventID:   e.ID,
+		Type:      e.Type,
+		Payload:   body,
+		CreatedAt: e.CreatedAt,
+	}); err != nil {
+		return err
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run dispatches outbox events as they are published, and every interval
+// (DefaultDispatchInterval when non-positive) to pick up events published
+// on other instances or left by a crash, until ctx is canceled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultDispatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := d.Dispatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Warn("Webhook dispatch failed", map[string]interface{}{"error": err.Error()})
+		}
+		if ctx.Err() == nil {
+			d.lastErr.Store(&err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// Ping reports the error of Run's most recent pass over the outbox, so that
+// a dispatcher that cannot reach its store shows up in health checks.
+// Failed deliveries to subscribers are not errors here.
+func (d *Dispatcher) Ping(ctx context.Context) error {
+	if err := d.lastErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Dispatch delivers pending outbox events to their subscriptions until
+// none are left, returning how many it dispatched. Each event is claimed
+// first, so concurrent dispatchers never deliver the same one. Failed
+// deliveries are logged and left in the delivery log for redelivery; the
+// event still counts as dispatched.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		events, err := d.store.ClaimOutboxEvents(ctx, d.now(), dispatchLease, dispatchBatch)
+		if err != nil || len(events) == 0 {
+			return n, err
+		}
+		subs, err := d.store.ListWebhooks(ctx)
+		if err != nil {
+			return n, err
+		}
+		for _, e := range events {
+			for i := range subs {
+				if subs[i].Subscribed(e.Type) {
+					d.sendEvent(ctx, subscriptionEndpoint(&subs[i]), e)
+				}
+			}
+			if err := d.store.MarkOutboxEventDispatched(ctx, e.ID, d.now()); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+}
+
+// Replay delivers events from the outbox to the subscription w
+// again, in order, with their original event IDs. Events of types it does
+// not subscribe to are skipped; it returns how many were delivered.
+func (d *Dispatcher) Replay(ctx context.Context, w *models.Webhook, events []models.OutboxEvent) int {
+	ep := subscriptionEndpoint(w)
+	n := 0
+	for _, e := range events {
+		if ctx.Err() != nil {
+			break
+		}
+		if w.Receives(e.Type) {
+			d.sendEvent(ctx, ep, e)
+			n++
+		}
+	}
+	return n
+}
+
+// sendEvent delivers an outbox event to ep, logging a failure.
+func (d *Dispatcher) sendEvent(ctx context.Context, ep *Endpoint, e models.OutboxEvent) {
+	if _, err := d.send(ctx, ep, &models.WebhookDelivery{EventID: e.EventID, Event: e.Type, Payload: e.Payload}); err != nil {
+		logger.Warn("Webhook delivery failed", map[string]interface{}{
+			"webhook": ep.ID,
+			"event":   e.Type,
+			"error":   err.Error(),
+		})
+	}
+}
+
+// Test sends an EventTest event to the endpoint webhookID, whether or not
+// it is enabled, so an administrator can check that it is reachable and
+// verifies signatures.
+func (d *Dispatcher) Test(ctx context.Context, webhookID string) (*models.WebhookDelivery, error) {
+	ep, err := d.Endpoint(ctx, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	if ep == nil {
+		return nil, fmt.Errorf("unknown webhook %q", webhookID)
+	}
+	e := Event{Type: EventTest, Data: map[string]string{"webhook_id": webhookID}}
+	body, err := d.encode(&e)
+	if err != nil {
+		return nil, err
+	}
+	return d.send(ctx, ep, &models.WebhookDelivery{EventID: e.ID, Event: e.Type, Payload: body})
+}
+
+// encode fills in e's ID and CreatedAt when unset and returns it as JSON.
+func (d *Dispatcher) encode(e *Event) ([]byte, error) {
+	if e.ID == "" {
+		id, err := newEventID()
+		if err != nil {
+			return nil, err
+		}
+		e.ID = id
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = d.now().UTC()
+	}
+	return json.Marshal(e)
+}
+
+// Redeliver sends the event of prev again to its endpoint's current URL,
+// with a fresh signature, and records it as a new delivery.
+func (d *Dispatcher) Redeliver(ctx context.Context, prev *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	ep, err := d.Endpoint(ctx, prev.WebhookID)
+	if err != nil {
+		return nil, err
+	}
+	if ep == nil {
+		return nil, fmt.Errorf("unknown webhook %q", prev.WebhookID)
+	}
+	return d.send(ctx, ep, &models.WebhookDelivery{
+		EventID:      prev.EventID,
+		Event:        prev.Event,
+		Payload:      prev.Payload,
+		RedeliveryOf: prev.ID,
+	})
+}
+
+// send posts del's payload to ep, fills in the outcome, and records it.
+func (d *Dispatcher) send(ctx context.Context, ep *Endpoint, del *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	del.WebhookID, del.URL = ep.ID, ep.URL
+	start := d.now()
+	del.CreatedAt = start.UTC()
+	err := d.post(ctx, ep, del)
+	del.DurationMS = d.now().Sub(start).Milliseconds()
+	result := "sent"
+	if err != nil {
+		result = "error"
+		del.Error = err.Error()
+	}
+	deliveries.WithLabelValues(ep.ID, result).Inc()
+
+	// Record even when the caller has given up waiting
+	rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if rerr := d.store.RecordWebhookDelivery(rctx, del); rerr != nil {
+		logger.Error("Failed to record webhook delivery", map[string]interface{}{
+			"webhook": ep.ID,
+			"error":   rerr.Error(),
+		})
+	}
+	return del, err
+}
+
+func (d *Dispatcher) post(ctx context.Context, ep *Endpoint, del *models.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, del.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.EventIDHeader, del.EventID)
+	req.Header.Set(webhook.EventHeader, del.Event)
+	if len(ep.Secrets) > 0 {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(ep.Secrets, d.now(), del.Payload))
+	}
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	del.StatusCode = resp.StatusCode
+	if del.Succeeded() {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, msg)
+	}
+	return fmt.Errorf("unexpected status %d", resp.StatusCode)
+}
+
+// newEventID returns 128 random bits, hex encoded.
+func newEventID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,164 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/pkg/webhook"
+)
+
+func TestParseSecrets(t *testing.T) {
+	long := "0123456789abcdef0123456789abcdef"
+	secrets, err := ParseSecrets(" " + long + ", ," + long + "x")
+	if err != nil || len(secrets) != 2 || secrets[1] != long+"x" {
+		t.Fatalf("ParseSecrets = %v, %v", secrets, err)
+	}
+	if _, err := ParseSecrets("short"); err == nil {
+		t.Error("expected a short secret to be rejected")
+	}
+	if secrets, err := ParseSecrets(""); err != nil || secrets != nil {
+		t.Errorf("expected no secrets, got %v, %v", secrets, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := "0123456789abcdef0123456789abcdef"
+	w := &models.Webhook{URL: "https://hooks.example.com/sentinel", Events: []string{EventUserLogin, EventUserDelete, EventUserLogin}, Secret: secret}
+	if err := Validate(w); err != nil || len(w.Events) != 2 {
+		t.Fatalf("Validate = %v, events %v", err, w.Events)
+	}
+	for _, bad := range []models.Webhook{
+		{URL: "ftp://hooks.example.com", Events: []string{"*"}, Secret: secret},
+		{URL: "/relative", Events: []string{"*"}, Secret: secret},
+		{URL: "https://hooks.example.com", Secret: secret},
+		{URL: "https://hooks.example.com", Events: []string{"user.explode"}, Secret: secret},
+		{URL: "https://hooks.example.com", Events: []string{"*"}, Secret: "short"},
+	} {
+		if err := Validate(&bad); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestDeliverAndRedeliver(t *testing.T) {
+	secrets := []string{"0123456789abcdef0123456789abcdef", "fedcba9876543210fedcba9876543210"}
+	status := http.StatusInternalServerError
+	var got []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// Consumers holding only the older secret still verify
+		if err := webhook.Verify(r.Header.Get(webhook.SignatureHeader), body, secrets[1:], webhook.DefaultTolerance, time.Now()); err != nil {
+			t.Errorf("signature did not verify: %v", err)
+		}
+		got = append(got, r)
+		w.WriteHeader(status)
+		io.WriteString(w, "down for maintenance")
+	}))
+	defer srv.Close()
+
+	s := store.NewMemStore()
+	d := New(s, secrets, Endpoint{ID: "alerts", URL: srv.URL})
+	if _, err := d.Deliver(context.Background(), "missing", "alert", nil); err == nil {
+		t.Error("expected an unknown webhook to be rejected")
+	}
+
+	first, err := d.Deliver(context.Background(), "alerts", "alert", map[string]string{"rule": "failed_logins"})
+	if err == nil {
+		t.Fatal("expected a failed delivery to return an error")
+	}
+	if first.StatusCode != status || first.Error == "" || first.Succeeded() || first.EventID == "" {
+		t.Errorf("unexpected failed delivery %+v", first)
+	}
+
+	status = http.StatusNoContent
+	second, err := d.Redeliver(context.Background(), first)
+	if err != nil {
+		t.Fatalf("Redeliver: %v", err)
+	}
+	if !second.Succeeded() || second.EventID != first.EventID || second.RedeliveryOf != first.ID || string(second.Payload) != `{"rule":"failed_logins"}` {
+		t.Errorf("unexpected redelivery %+v", second)
+	}
+
+	if _, total, _ := s.ListWebhookDeliveries(context.Background(), "alerts", 0, 0); total != 2 {
+		t.Fatalf("expected both attempts to be recorded, got %d", total)
+	}
+	// The HTTP client retries 5xx responses, so the first delivery may
+	// have reached the server more than once; all share the event ID
+	for _, r := range got {
+		if r.Header.Get(webhook.EventIDHeader) != first.EventID || r.Header.Get(webhook.EventHeader) != "alert" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+	}
+}
+
+func TestPublishDispatchAndReplay(t *testing.T) {
+	secret := "0123456789abcdef0123456789abcdef"
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify(r.Header.Get(webhook.SignatureHeader), body, []string{secret}, webhook.DefaultTolerance, time.Unix(1700000000, 0)); err != nil {
+			t.Errorf("signature did not verify: %v", err)
+		}
+		got = append(got, r.URL.Path+" "+string(body))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	s := store.NewMemStore()
+	subs := []*models.Webhook{
+		{URL: srv.URL + "/logins", Events: []string{EventUserLogin}, Secret: secret, Enabled: true},
+		{URL: srv.URL + "/all", Events: []string{models.WebhookAllEvents}, Secret: secret, Enabled: true},
+		{URL: srv.URL + "/disabled", Events: []string{models.WebhookAllEvents}, Secret: secret},
+	}
+	for _, w := range subs {
+		if err := s.CreateWebhook(ctx, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := New(s, nil)
+	d.now = func() time.Time { return time.Unix(1700000000, 0) }
+	if err := d.Publish(ctx, Event{ID: "e1", Type: EventUserDelete, Data: map[string]int64{"user_id": 7}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatal("expected Publish only to add the event to the outbox")
+	}
+
+	if n, err := d.Dispatch(ctx); err != nil || n != 1 {
+		t.Fatalf("Dispatch = %d, %v", n, err)
+	}
+	want := `/all {"id":"e1","type":"user.delete","created_at":"2023-11-14T22:13:20Z","data":{"user_id":7}}`
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("expected only the subscription to all events to receive it, got %q", got)
+	}
+	if n, _ := d.Dispatch(ctx); n != 0 {
+		t.Errorf("expected a dispatched event not to be dispatched again, got %d", n)
+	}
+
+	// Replays go to one subscription, even a disabled one
+	events, _ := s.ListOutboxEvents(ctx, time.Unix(0, 0), time.Unix(1800000000, 0), 0)
+	if n := d.Replay(ctx, subs[0], events); n != 0 {
+		t.Errorf("expected no replay to a subscription to other events, got %d", n)
+	}
+	if n := d.Replay(ctx, subs[2], events); n != 1 || len(got) != 2 || got[1] != "/disabled"+want[4:] {
+		t.Errorf("expected the event to be replayed, got %d: %q", n, got)
+	}
+	id := strconv.FormatInt(subs[2].ID, 10)
+	if list, _, _ := s.ListWebhookDeliveries(ctx, id, 0, 0); len(list) != 1 || list[0].EventID != "e1" {
+		t.Errorf("unexpected replay deliveries %+v", list)
+	}
+
+	if ep, err := d.Endpoint(ctx, "1"); err != nil || ep == nil || ep.URL != srv.URL+"/logins" {
+		t.Errorf("expected subscription 1 to be found, got %+v (%v)", ep, err)
+	}
+	if ep, _ := d.Endpoint(ctx, "9"); ep != nil {
+		t.Errorf("expected no endpoint, got %+v", ep)
+	}
+}
@@ -0,0 +1,188 @@
+// Package wellknown serves the standard discovery documents under
+// /.well-known/: security.txt (RFC 9116), the change-password redirect
+// (W3C "A Well-Known URL for Changing Passwords"), and OpenID Connect style
+// discovery metadata with its JWKS, so clients and security researchers
+// can find Sentinel's endpoints without out-of-band configuration.
+package wellknown
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayvqt/Sentinel/internal/httpjson"
+)
+
+// Paths served by Handler.
+const (
+	SecurityTxtPath    = "/.well-known/security.txt"
+	ChangePasswordPath = "/.well-known/change-password"
+	OpenIDConfigPath   = "/.well-known/openid-configuration"
+	OAuthServerPath    = "/.well-known/oauth-authorization-server"
+	JWKSPath           = "/.well-known/jwks.json"
+)
+
+// DefaultSecurityTxtLifetime is how far ahead security.txt's Expires field
+// is set when no fixed expiry is configured. RFC 9116 recommends less than
+// a year.
+const DefaultSecurityTxtLifetime = 180 * 24 * time.Hour
+
+// Config describes the documents Handler serves.
+type Config struct {
+	// PublicURL is the externally visible base URL of the service. It is
+	// the issuer in discovery metadata and the base of every advertised
+	// endpoint.
+	PublicURL string
+
+	// SecurityContacts are security.txt Contact values, such as
+	// "mailto:security@example.com" or an https:// URL. security.txt is
+	// not served without at least one.
+	SecurityContacts []string
+	// SecurityPolicy is the URL of the vulnerability disclosure policy.
+	SecurityPolicy string
+	// SecurityExpires is the fixed security.txt expiry; zero means
+	// DefaultSecurityTxtLifetime from each request.
+	SecurityExpires time.Time
+	// PreferredLanguages lists language tags for security reports.
+	PreferredLanguages []string
+
+	// ChangePasswordURL is where password managers send users to change
+	// their password. The redirect is not served when empty.
+	ChangePasswordURL string
+
+	// SigningAlgorithm is the JWS algorithm of issued tokens (e.g. HS256).
+	SigningAlgorithm string
+	// DPoPAlgorithms are the DPoP proof algorithms accepted; empty when
+	// DPoP is off.
+	DPoPAlgorithms []string
+
+	// CacheMaxAge is how long shared caches and clients may keep the
+	// documents. Zero, and every response other than a document, leave
+	// caching to the surrounding handler.
+	CacheMaxAge time.Duration
+}
+
+// Handler returns a handler for the /.well-known/ paths described by cfg.
+// Unconfigured documents answer 404. Documents may be cached for
+// cfg.CacheMaxAge.
+func Handler(cfg Config) http.Handler {
+	cfg.PublicURL = strings.TrimRight(cfg.PublicURL, "/")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+SecurityTxtPath, cfg.securityTxt)
+	mux.HandleFunc("GET "+ChangePasswordPath, cfg.changePassword)
+	mux.HandleFunc("GET "+OpenIDConfigPath, cfg.discovery)
+	mux.HandleFunc("GET "+OAuthServerPath, cfg.discovery)
+	mux.HandleFunc("GET "+JWKSPath, cfg.jwks)
+	return mux
+}
+
+// cacheable marks the document about to be written as cacheable for
+// cfg.CacheMaxAge. Errors are not, so that a cache does not keep serving
+// a 404 after the document is configured.
+func (cfg Config) cacheable(w http.ResponseWriter) {
+	if cfg.CacheMaxAge > 0 {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(cfg.CacheMaxAge.Seconds())))
+	}
+}
+
+// SecurityTxt renders the security.txt document for cfg at now.
+func (cfg Config) SecurityTxt(now time.Time) string {
+	var b strings.Builder
+	for _, c := range cfg.SecurityContacts {
+		b.WriteString("Contact: " + c + "\n")
+	}
+	expires := cfg.SecurityExpires
+	if expires.IsZero() {
+		expires = now.Add(DefaultSecurityTxtLifetime)
+	}
+	b.WriteString("Expires: " + expires.UTC().Truncate(time.Second).Format(time.RFC3339) + "\n")
+	if cfg.SecurityPolicy != "" {
+		b.WriteString("Policy: " + cfg.SecurityPolicy + "\n")
+	}
+	if len(cfg.PreferredLanguages) > 0 {
+		b.WriteString("Preferred-Languages: " + strings.Join(cfg.PreferredLanguages, ", ") + "\n")
+	}
+	if cfg.PublicURL != "" {
+		b.WriteString("Canonical: " + cfg.PublicURL + SecurityTxtPath + "\n")
+	}
+	return b.String()
+}
+
+func (cfg Config) securityTxt(w http.ResponseWriter, r *http.Request) {
+	if len(cfg.SecurityContacts) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	cfg.cacheable(w)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(cfg.SecurityTxt(time.Now())))
+}
+
+func (cfg Config) changePassword(w http.ResponseWriter, r *http.Request) {
+	if cfg.ChangePasswordURL == "" {
+		http.NotFound(w, r)
+		return
+	}
+	// The specification asks for 302, 303, or 307.
+	http.Redirect(w, r, cfg.ChangePasswordURL, http.StatusFound)
+}
+
+// Metadata is the discovery document served at OpenIDConfigPath and
+// OAuthServerPath. Sentinel is not a full OpenID provider: there is no
+// authorization endpoint, and tokens are obtained from the login endpoint
+// with a username and password.
+type Metadata struct {
+	Issuer                            string   `json:"issuer"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	SigningAlgValuesSupported         []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	DPoPSigningAlgValuesSupported     []string `json:"dpop_signing_alg_values_supported,omitempty"`
+}
+
+// Metadata returns the discovery document for cfg.
+func (cfg Config) Metadata() Metadata {
+	alg := cfg.SigningAlgorithm
+	if alg == "" {
+		alg = "HS256"
+	}
+	return Metadata{
+		Issuer:                            cfg.PublicURL,
+		TokenEndpoint:                     cfg.PublicURL + "/api/auth/login",
+		UserinfoEndpoint:                  cfg.PublicURL + "/api/auth/profile",
+		RevocationEndpoint:                cfg.PublicURL + "/api/auth/logout",
+		JWKSURI:                           cfg.PublicURL + JWKSPath,
+		GrantTypesSupported:               []string{"password", "refresh_token"},
+		ResponseTypesSupported:            []string{"token"},
+		SubjectTypesSupported:             []string{"public"},
+		TokenEndpointAuthMethodsSupported: []string{"none"},
+		SigningAlgValuesSupported:         []string{alg},
+		ClaimsSupported:                   []string{"uid", "role", "token_type", "auth_time", "jti", "iat", "nbf", "exp"},
+		DPoPSigningAlgValuesSupported:     cfg.DPoPAlgorithms,
+	}
+}
+
+func (cfg Config) discovery(w http.ResponseWriter, r *http.Request) {
+	// Discovery documents are public and read by browser-based clients on
+	// other origins.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	cfg.cacheable(w)
+	httpjson.Write(w, http.StatusOK, cfg.Metadata())
+}
+
+// jwks serves the public signing keys. Tokens are signed with a shared
+// HMAC secret, which must never be published, so the set is empty and
+// resource servers validate tokens with the secret or through Sentinel.
+func (cfg Config) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	cfg.cacheable(w)
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	_, _ = w.Write([]byte(`{"keys":[]}` + "\n"))
+}
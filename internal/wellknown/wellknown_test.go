@@ -0,0 +1,117 @@
+package wellknown
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestSecurityTxt(t *testing.T) {
+	cfg := Config{
+		PublicURL:          "https://auth.example.com/",
+		SecurityContacts:   []string{"mailto:security@example.com", "https://example.com/report"},
+		SecurityPolicy:     "https://example.com/disclosure",
+		PreferredLanguages: []string{"en", "de"},
+	}
+	w := get(Handler(cfg), SecurityTxtPath)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"Contact: mailto:security@example.com\nContact: https://example.com/report\n",
+		"Policy: https://example.com/disclosure\n",
+		"Preferred-Languages: en, de\n",
+		"Canonical: https://auth.example.com/.well-known/security.txt\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	if got := cfg.SecurityTxt(now); !strings.Contains(got, "Expires: 2025-08-28T12:00:00Z\n") {
+		t.Errorf("expected a rolling expiry, got:\n%s", got)
+	}
+	cfg.SecurityExpires = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := cfg.SecurityTxt(now); !strings.Contains(got, "Expires: 2026-01-01T00:00:00Z\n") {
+		t.Errorf("expected the fixed expiry, got:\n%s", got)
+	}
+
+	if w := get(Handler(Config{}), SecurityTxtPath); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without contacts, got %d", w.Code)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	w := get(Handler(Config{ChangePasswordURL: "https://app.example.com/settings/password"}), ChangePasswordPath)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://app.example.com/settings/password" {
+		t.Fatalf("unexpected redirect %d %v", w.Code, w.Header())
+	}
+	if w := get(Handler(Config{}), ChangePasswordPath); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without a URL, got %d", w.Code)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	h := Handler(Config{PublicURL: "https://auth.example.com"})
+	for _, path := range []string{OpenIDConfigPath, OAuthServerPath} {
+		w := get(h, path)
+		var m Metadata
+		if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if m.Issuer != "https://auth.example.com" || m.JWKSURI != "https://auth.example.com/.well-known/jwks.json" ||
+			m.TokenEndpoint != "https://auth.example.com/api/auth/login" || m.SigningAlgValuesSupported[0] != "HS256" {
+			t.Errorf("%s: unexpected metadata %+v", path, m)
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("%s: expected open CORS", path)
+		}
+	}
+
+	w := get(h, JWKSPath)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"keys":[]}` {
+		t.Errorf("unexpected JWKS %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, OpenIDConfigPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for POST, got %d", w.Code)
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	cfg := Config{PublicURL: "https://auth.example.com", CacheMaxAge: time.Hour}
+	for _, path := range []string{OpenIDConfigPath, JWKSPath} {
+		if got := get(Handler(cfg), path).Header().Get("Cache-Control"); got != "public, max-age=3600" {
+			t.Errorf("%s: Cache-Control %q", path, got)
+		}
+	}
+	// Errors are left to the surrounding handler, as is the redirect
+	for _, path := range []string{"/.well-known/unknown", SecurityTxtPath, ChangePasswordPath} {
+		if got := get(Handler(cfg), path).Header().Get("Cache-Control"); got != "" {
+			t.Errorf("%s: expected no Cache-Control, got %q", path, got)
+		}
+	}
+	w := httptest.NewRecorder()
+	Handler(cfg).ServeHTTP(w, httptest.NewRequest(http.MethodPost, JWKSPath, nil))
+	if got := w.Header().Get("Cache-Control"); w.Code != http.StatusMethodNotAllowed || got != "" {
+		t.Errorf("expected an uncached 405, got %d with Cache-Control %q", w.Code, got)
+	}
+
+	cfg.CacheMaxAge = 0
+	if got := get(Handler(cfg), JWKSPath).Header().Get("Cache-Control"); got != "" {
+		t.Errorf("expected no Cache-Control without a max age, got %q", got)
+	}
+}
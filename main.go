@@ -4,24 +4,58 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"unicode/utf8"
 
+	"github.com/mayvqt/Sentinel/internal/alerting"
+	"github.com/mayvqt/Sentinel/internal/analytics"
+	"github.com/mayvqt/Sentinel/internal/audit"
 	"github.com/mayvqt/Sentinel/internal/auth"
+	"github.com/mayvqt/Sentinel/internal/backchannel"
+	"github.com/mayvqt/Sentinel/internal/canary"
+	"github.com/mayvqt/Sentinel/internal/clients"
+	"github.com/mayvqt/Sentinel/internal/clockdrift"
 	"github.com/mayvqt/Sentinel/internal/config"
+	"github.com/mayvqt/Sentinel/internal/denylist"
+	"github.com/mayvqt/Sentinel/internal/dpop"
+	"github.com/mayvqt/Sentinel/internal/flags"
 	"github.com/mayvqt/Sentinel/internal/handlers"
+	"github.com/mayvqt/Sentinel/internal/health"
+	"github.com/mayvqt/Sentinel/internal/httpclient"
+	"github.com/mayvqt/Sentinel/internal/leader"
+	"github.com/mayvqt/Sentinel/internal/lifecycle"
 	"github.com/mayvqt/Sentinel/internal/logger"
+	"github.com/mayvqt/Sentinel/internal/mail"
+	"github.com/mayvqt/Sentinel/internal/metadata"
+	"github.com/mayvqt/Sentinel/internal/middleware"
+	"github.com/mayvqt/Sentinel/internal/models"
+	"github.com/mayvqt/Sentinel/internal/mtls"
+	"github.com/mayvqt/Sentinel/internal/pii"
+	"github.com/mayvqt/Sentinel/internal/quota"
+	"github.com/mayvqt/Sentinel/internal/rbac"
 	"github.com/mayvqt/Sentinel/internal/server"
+	"github.com/mayvqt/Sentinel/internal/sms"
+	"github.com/mayvqt/Sentinel/internal/storage"
 	"github.com/mayvqt/Sentinel/internal/store"
+	"github.com/mayvqt/Sentinel/internal/systemd"
+	"github.com/mayvqt/Sentinel/internal/tarpit"
+	"github.com/mayvqt/Sentinel/internal/validation"
+	"github.com/mayvqt/Sentinel/internal/webhooks"
+	"github.com/mayvqt/Sentinel/internal/wellknown"
 )
 
 // Application metadata constants.
@@ -39,12 +73,16 @@ const (
 	ExitCodeStoreError      = 2
 	ExitCodeServerError     = 3
 	ExitCodeShutdownTimeout = 4
+	ExitCodeCheckFailed     = 5
 )
 
 // Operational timeouts.
 const (
 	DatabasePingTimeout     = 5 * time.Second
 	GracefulShutdownTimeout = 30 * time.Second
+	BackgroundDrainTimeout  = 30 * time.Second
+	WebhookFlushTimeout     = 10 * time.Second
+	AuditFlushTimeout       = 10 * time.Second
 	DefaultPort             = "8080"
 )
 
@@ -58,12 +96,41 @@ func run() int {
 	// Initialize structured logging subsystem.
 	logger.SetLevel(logger.LevelInfo)
 
+	// Dispatch CLI subcommands (backup, ...) before starting the server.
+	if code, handled := runCommand(os.Args[1:]); handled {
+		return code
+	}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	dev := fs.Bool("dev", false, "run a local development server with seeded demo accounts")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return ExitCodeConfigError
+	}
+	if *dev {
+		logger.SetFormat(logger.FormatConsole)
+	}
+
 	// Load configuration from environment and .env file.
 	cfg, err := config.Load()
 	if err != nil {
 		log.Printf("Configuration load failed: %v", err)
 		return ExitCodeConfigError
 	}
+	logFormat, err := logger.ParseFormat(cfg.LogFormat)
+	if err != nil {
+		log.Printf("Configuration load failed: LOG_FORMAT: %v", err)
+		return ExitCodeConfigError
+	}
+	if cfg.LogFormat == "" && *dev {
+		logFormat = logger.FormatConsole
+	}
+	logger.SetFormat(logFormat)
+	if *dev {
+		if err := applyDevMode(cfg); err != nil {
+			log.Printf("Configuration load failed: %v", err)
+			return ExitCodeConfigError
+		}
+	}
 
 	// Validate required configuration parameters.
 	if err := validateConfiguration(cfg); err != nil {
@@ -71,6 +138,33 @@ func run() int {
 		return ExitCodeConfigError
 	}
 
+	// Subsystems register how they shut down as they start. Shutdown runs
+	// once, so this only has an effect when startup fails part way.
+	lc := lifecycle.New()
+	defer lc.Shutdown(context.Background())
+
+	// Configure access log format and destination.
+	closeAccessLog, err := configureAccessLog(cfg)
+	if err != nil {
+		log.Printf("Access log setup failed: %v", err)
+		return ExitCodeConfigError
+	}
+	lc.OnShutdown(lifecycle.Close, "access-log", 0, func(context.Context) error {
+		closeAccessLog()
+		return nil
+	})
+
+	// Configure defaults for outbound HTTP clients.
+	if err := configureHTTPClients(cfg); err != nil {
+		log.Printf("HTTP client setup failed: %v", err)
+		return ExitCodeConfigError
+	}
+
+	if err := checkDeployment(cfg); err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+
 	// Determine server port with fallback to default.
 	port := resolvePort(cfg.Port)
 
@@ -80,13 +174,9 @@ func run() int {
 		log.Printf("Store initialization failed: %v", err)
 		return ExitCodeStoreError
 	}
-	defer func() {
-		if closeErr := dataStore.Close(); closeErr != nil {
-			logger.Error("Store cleanup failed", map[string]interface{}{
-				"error": closeErr.Error(),
-			})
-		}
-	}()
+	lc.OnShutdown(lifecycle.Close, "store", 0, func(context.Context) error {
+		return dataStore.Close()
+	})
 
 	// Verify database connectivity before proceeding.
 	ctx, cancel := context.WithTimeout(context.Background(), DatabasePingTimeout)
@@ -97,33 +187,451 @@ func run() int {
 		return ExitCodeStoreError
 	}
 
+	var devUsers []models.User
+	if *dev {
+		if devUsers, err = seedDevData(ctx, dataStore); err != nil {
+			log.Printf("Store initialization failed: %v", err)
+			return ExitCodeStoreError
+		}
+	}
+
 	// Initialize authentication service.
-	authService := auth.New(cfg)
+	authService, err := newAuth(cfg)
+	if err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	if cfg.TokenEncryptionKey != "" {
+		logger.Info("Token encryption enabled")
+	}
 
 	// Initialize HTTP handlers.
 	handlerService := handlers.New(dataStore, authService)
 
+	// Initialize user metadata access policies.
+	policySpec := cfg.MetadataPolicies
+	if policySpec == "" {
+		policySpec = metadata.DefaultPolicySpec
+	}
+	metadataPolicies, err := metadata.ParsePolicies(policySpec, metadata.Access(cfg.MetadataDefaultPolicy), cfg.MetadataMaxBytes)
+	if err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	handlerService.Metadata = metadataPolicies
+
+	// Initialize the delegated admin roles.
+	adminRoles, err := buildAdminRoles(cfg)
+	if err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	handlerService.AdminRoles = adminRoles
+	handlerService.ElevationApproval = cfg.ElevationApproval
+	handlerService.ElevationMaxDuration = cfg.ElevationMaxDuration
+
+	// Initialize the username policy.
+	usernamePolicy, err := buildUsernamePolicy(cfg)
+	if err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	handlerService.UsernamePolicy = usernamePolicy
+	handlerService.PasswordMinScore = cfg.PasswordMinScore
+	handlerService.BackupDir = cfg.BackupDir
+	if handlerService.BackupBucket, err = buildBackupBucket(cfg); err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	handlerService.DiagnosticsEnabled = cfg.DiagnosticsEnabled
+	handlerService.DiagnosticsDir = cfg.DiagnosticsDir
+	handlerService.MetricsEnabled = cfg.MetricsEnabled
+	handlerService.MetricsToken = cfg.MetricsToken
+	handlerService.RefreshTokenTTL = cfg.RefreshTokenTTL
+	handlerService.RefreshSliding = cfg.RefreshSliding
+	handlerService.RefreshMaxLifetime = cfg.RefreshMaxLifetime
+	handlerService.RefreshReuseGrace = cfg.RefreshReuseGrace
+	handlerService.SessionIdleTimeout = cfg.SessionIdleTimeout
+	handlerService.GeoCountryHeader = cfg.GeoCountryHeader
+	handlerService.Settings = cfg.Settings
+	handlerService.PublicURL = cfg.PublicURL
+	if handlerService.PublicURL == "" {
+		handlerService.PublicURL = "http://localhost:" + port
+	}
+
+	// Initialize outgoing email for security notifications (optional).
+	if cfg.SMTPHost != "" {
+		mailer, err := mail.NewSMTP(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+		if err != nil {
+			log.Printf("Configuration load failed: %v", err)
+			return ExitCodeConfigError
+		}
+		handlerService.Mailer = mailer
+		// Each check dials the SMTP server, so results are reused
+		handlerService.Dependencies.RegisterOptional("mail", health.Cached(mailer, mailHealthInterval))
+	}
+	if cfg.MagicLinkEnabled && handlerService.Mailer == nil {
+		log.Printf("Configuration load failed: MAGIC_LINK_ENABLED requires SMTP_HOST")
+		return ExitCodeConfigError
+	}
+	handlerService.MagicLinkEnabled = cfg.MagicLinkEnabled
+	handlerService.MagicLinkTTL = cfg.MagicLinkTTL
+	handlerService.MagicLinkDeviceBinding = cfg.MagicLinkDeviceBinding
+	if cfg.EnumerationProtection && handlerService.Mailer == nil {
+		log.Printf("Configuration load failed: ENUMERATION_PROTECTION requires SMTP_HOST")
+		return ExitCodeConfigError
+	}
+	handlerService.EnumerationProtection = cfg.EnumerationProtection
+	handlerService.GuestEnabled = cfg.GuestEnabled
+	handlerService.GuestTokenTTL = cfg.GuestTokenTTL
+	handlerService.GuestMaxAge = cfg.GuestMaxAge
+	if err := checkSSOCookieDomain(cfg); err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	handlerService.SSOCookieDomain = cfg.SSOCookieDomain
+	handlerService.SSOCookieName = cfg.SSOCookieName
+	handlerService.SSOCookieTTL = cfg.SSOCookieTTL
+	if cfg.TarpitEnabled {
+		handlerService.Tarpit = tarpit.New(tarpit.Config{
+			Threshold:   cfg.TarpitThreshold,
+			BaseDelay:   cfg.TarpitBaseDelay,
+			MaxDelay:    cfg.TarpitMaxDelay,
+			Window:      cfg.TarpitWindow,
+			RejectAtMax: cfg.TarpitRejectAtMax,
+		})
+	}
+	logoutClients, err := backchannel.ParseClients(cfg.BackchannelLogoutClients)
+	if err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	if len(logoutClients) > 0 {
+		handlerService.Backchannel = backchannel.New(handlerService.PublicURL, logoutClients)
+	}
+	webhookSecrets, err := webhooks.ParseSecrets(cfg.WebhookSigningSecrets)
+	if err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	var webhookEndpoints []webhooks.Endpoint
+	if cfg.AlertWebhookURL != "" {
+		if len(webhookSecrets) == 0 {
+			logger.Warn("WEBHOOK_SIGNING_SECRETS is not set - alert webhook deliveries are unsigned")
+		}
+		webhookEndpoints = append(webhookEndpoints, webhooks.Endpoint{ID: alertWebhookID, URL: cfg.AlertWebhookURL})
+	}
+	handlerService.Webhooks = webhooks.New(dataStore, webhookSecrets, webhookEndpoints...)
+	handlerService.Dependencies.RegisterOptional("webhooks", handlerService.Webhooks)
+	templates, err := loadMailTemplates(cfg)
+	if err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	handlerService.Templates = templates
+
+	// Initialize text messaging for phone verification (optional).
+	if cfg.SMSProvider != "" {
+		sender, err := sms.New(smsConfig(cfg))
+		if err != nil {
+			log.Printf("Configuration load failed: %v", err)
+			return ExitCodeConfigError
+		}
+		handlerService.SMS = sender
+	}
+	if cfg.PhoneLoginEnabled && handlerService.SMS == nil {
+		log.Printf("Configuration load failed: PHONE_LOGIN_ENABLED requires SMS_PROVIDER")
+		return ExitCodeConfigError
+	}
+	handlerService.PhoneLogin = cfg.PhoneLoginEnabled
+
+	// Initialize media storage for avatar uploads (optional).
+	if media, err := storage.New(cfg); err != nil {
+		logger.Warn("Media storage unavailable - avatar uploads disabled", map[string]interface{}{
+			"backend": cfg.StorageBackend,
+			"error":   err.Error(),
+		})
+	} else {
+		handlerService.Media = media
+		handlerService.AvatarMaxBytes = cfg.AvatarMaxBytes
+		handlerService.AvatarDimension = cfg.AvatarDimension
+	}
+
+	// Elect one instance of a multi-instance deployment to run each
+	// background job that acts on the shared database. Without an elector
+	// every job runs here.
+	var jobs *leader.Elector
+	if cfg.DeploymentProfile == "multi" {
+		jobs = leader.New(dataStore, cfg.InstanceID, cfg.LeaderLeaseTTL)
+		logger.Info("Leader election enabled for background jobs", map[string]interface{}{
+			"instance": jobs.ID(),
+		})
+	}
+
+	// Load revoked token IDs and keep them in sync with the store.
+	revoked := denylist.New()
+	revoked.SetLeader(jobs)
+	if err := revoked.Sync(ctx, dataStore); err != nil {
+		log.Printf("Token denylist load failed: %v", err)
+		return ExitCodeStoreError
+	}
+
+	// Background jobs run until jobCtx is canceled on shutdown, which then
+	// waits for them to return. Leases are released after that, so that a
+	// job still renewing cannot take one back.
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+	lc.OnShutdown(lifecycle.StopJobs, "background-jobs", 0, func(ctx context.Context) error {
+		stopJobs()
+		return waitGroup(ctx, &background)
+	})
+	if jobs != nil {
+		background.Go(func() { jobs.Run(jobCtx) })
+		lc.OnShutdown(lifecycle.StopJobs, "leader-leases", 0, func(ctx context.Context) error {
+			jobs.Release(ctx)
+			return nil
+		})
+	}
+	background.Go(func() { revoked.Run(jobCtx, dataStore, cfg.DenylistSyncInterval) })
+	authService.SetDenylist(revoked)
+	handlerService.Denylist = revoked
+
+	// Compare this instance's clock with external sources. Each instance
+	// checks its own clock, so this is not leader-elected.
+	if len(cfg.ClockDriftSources) > 0 {
+		monitor := &clockdrift.Monitor{Sources: cfg.ClockDriftSources, Threshold: cfg.ClockDriftThreshold}
+		if cfg.ClockDriftAutoSkew {
+			baseSkew := authService.ClockSkew()
+			monitor.Adjust = func(offset time.Duration) {
+				authService.SetClockSkew(clockdrift.WidenedSkew(baseSkew, offset, cfg.ClockDriftThreshold, cfg.ClockDriftMaxSkew))
+			}
+		}
+		background.Go(func() { monitor.Run(jobCtx, cfg.ClockDriftInterval) })
+	}
+
+	// Delete guest accounts that were never registered.
+	if cfg.GuestEnabled {
+		background.Go(func() { runGuestPurge(jobCtx, dataStore, jobs, cfg.GuestMaxAge) })
+	}
+
+	// Disable accounts whose expiry has passed, and apply the suspensions
+	// and reactivations admins scheduled.
+	if cfg.DisableExpiredAccounts {
+		background.Go(func() { runAccountExpiry(jobCtx, handlerService, jobs) })
+	}
+	background.Go(func() { runStatusChanges(jobCtx, handlerService, jobs) })
+
+	// Deliver webhook events from the outbox, and trim the outbox and the
+	// delivery log. Events still in the outbox at shutdown are delivered
+	// before the dispatcher stops.
+	background.Go(func() { handlerService.Webhooks.Run(jobCtx, 0) })
+	background.Go(func() { runWebhookDeliveryPurge(jobCtx, dataStore, jobs, cfg.WebhookDeliveryRetention) })
+	// Write login attempts to the audit log in the background unless every
+	// entry must be written before responding.
+	if cfg.AuditMode == "async" {
+		auditLog := audit.NewWriter(dataStore, cfg.AuditQueueSize, cfg.AuditBatchSize)
+		background.Go(func() { auditLog.Run(jobCtx) })
+		lc.OnShutdown(lifecycle.Flush, "audit-log", AuditFlushTimeout, auditLog.Flush)
+		handlerService.AuditLog = auditLog
+	}
+	lc.OnShutdown(lifecycle.Flush, "webhooks", WebhookFlushTimeout, func(ctx context.Context) error {
+		_, err := handlerService.Webhooks.Dispatch(ctx)
+		return err
+	})
+
+	// Prune, and optionally archive, old audit events when a retention
+	// period is configured.
+	if handlerService.AuditRetention, err = buildAuditRetention(cfg, dataStore); err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	if retention := handlerService.AuditRetention; retention != nil {
+		background.Go(func() { runAuditRetention(jobCtx, jobs, retention) })
+	}
+
+	// Export daily anonymized analytics when a sink is configured.
+	analyticsSink, err := buildAnalyticsSink(cfg)
+	if err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	if analyticsSink != nil {
+		background.Go(func() { runAnalyticsExport(jobCtx, dataStore, jobs, analyticsSink) })
+	}
+
+	// Start anomaly alerting when a notification target is configured.
+	alerts := startAlerting(jobCtx, cfg, handlerService.Webhooks)
+
+	// Load canary credentials, whose use raises an alert immediately.
+	canaries := canary.New(dataStore, alerts)
+	if err := canaries.Sync(ctx, dataStore); err != nil {
+		log.Printf("Canary load failed: %v", err)
+		return ExitCodeStoreError
+	}
+	background.Go(func() { canaries.Run(jobCtx, dataStore, 0) })
+	authService.SetCanaries(canaries)
+	handlerService.Canaries = canaries
+
+	// Load per-client request quotas.
+	quotas := quota.New(dataStore)
+	quotas.SetLeader(jobs)
+	if err := quotas.Sync(ctx); err != nil {
+		log.Printf("Quota load failed: %v", err)
+		return ExitCodeStoreError
+	}
+	background.Go(func() { quotas.Run(jobCtx, 0) })
+	handlerService.Quotas = quotas
+
+	// Load the registered clients and their token policies.
+	clientRegistry := clients.New(dataStore)
+	if err := clientRegistry.Sync(ctx); err != nil {
+		log.Printf("Client registry load failed: %v", err)
+		return ExitCodeStoreError
+	}
+	background.Go(func() { clientRegistry.Run(jobCtx, 0) })
+	handlerService.Clients = clientRegistry
+
+	// Load feature flag rollouts and administrators' overrides.
+	flagSet, err := loadFeatureFlags(cfg, dataStore)
+	if err != nil {
+		log.Printf("Configuration load failed: %v", err)
+		return ExitCodeConfigError
+	}
+	if err := flagSet.Sync(ctx); err != nil {
+		log.Printf("Feature flag load failed: %v", err)
+		return ExitCodeStoreError
+	}
+	background.Go(func() { flagSet.Run(jobCtx, 0) })
+	handlerService.Flags = flagSet
+
 	// Create HTTP server instance with TLS support if configured.
+	var dpopAlgorithms []string
+	if cfg.DPoPEnabled {
+		dpopAlgorithms = dpop.Algorithms()
+	}
+	serverOpts := []server.Option{server.WithWellKnown(wellknown.Config{
+		PublicURL:          handlerService.PublicURL,
+		SecurityContacts:   cfg.SecurityContacts,
+		SecurityPolicy:     cfg.SecurityPolicyURL,
+		SecurityExpires:    cfg.SecurityTxtExpires,
+		PreferredLanguages: cfg.SecurityPreferredLanguages,
+		ChangePasswordURL:  cfg.ChangePasswordURL,
+		CacheMaxAge:        cfg.WellKnownCacheMaxAge,
+		DPoPAlgorithms:     dpopAlgorithms,
+	})}
+	if cfg.AdminAddr != "" {
+		serverOpts = append(serverOpts, server.WithAdminListener(cfg.AdminAddr))
+	}
+	if cfg.RateLimitWarnPercent < 0 || cfg.RateLimitWarnPercent >= 100 {
+		log.Printf("Configuration load failed: RATE_LIMIT_WARN_PERCENT must be between 0 and 99")
+		return ExitCodeConfigError
+	}
+	if cfg.RateLimitWarnPercent > 0 {
+		serverOpts = append(serverOpts, server.WithRateLimitWarnings(cfg.RateLimitWarnPercent, publishRateLimitWarning(handlerService.Webhooks)))
+	}
+	if cfg.RateLimitMaxEntries < 1 {
+		log.Printf("Configuration load failed: RATE_LIMIT_MAX_ENTRIES must be positive")
+		return ExitCodeConfigError
+	}
+	serverOpts = append(serverOpts, server.WithRateLimitMaxEntries(cfg.RateLimitMaxEntries))
+	if cfg.RateLimitBackend == "store" {
+		serverOpts = append(serverOpts, server.WithSharedRateLimits(dataStore))
+		background.Go(func() { runRateLimitPurge(jobCtx, dataStore, jobs) })
+		if handlerService.Tarpit != nil {
+			handlerService.Tarpit.Share(dataStore)
+			background.Go(func() { runTarpitPurge(jobCtx, dataStore, jobs, cfg.TarpitWindow) })
+		}
+	}
+	if *dev {
+		serverOpts = append(serverOpts, server.WithRelaxedRateLimits(devRateLimitFactor))
+	}
+	if cfg.AdminUIEnabled {
+		serverOpts = append(serverOpts, server.WithAdminUI())
+	}
+	serverOpts = append(serverOpts, server.WithSecurityHeaders(securityHeaders(cfg)))
+
+	// Under systemd with Type=notify, report readiness once the listeners
+	// are bound, and send watchdog keepalives while the store answers.
+	serverOpts = append(serverOpts, server.WithOnReady(func() {
+		if _, err := systemd.Notify(systemd.Ready); err != nil {
+			logger.Warn("Readiness notification failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}))
+	if timeout := systemd.WatchdogInterval(); timeout > 0 {
+		background.Go(func() { systemd.RunWatchdog(jobCtx, timeout, dataStore.Ping) })
+	}
+	if cfg.CompressionEnabled {
+		serverOpts = append(serverOpts, server.WithCompression(cfg.CompressionMinBytes))
+	}
+	if cfg.ErrorFormat == "problem" {
+		serverOpts = append(serverOpts, server.WithProblemDetails())
+	}
+	if chaos := chaosConfig(cfg); chaos.Enabled() {
+		serverOpts = append(serverOpts, server.WithChaos(chaos))
+		logger.Warn("Chaos testing is injecting faults into requests", map[string]interface{}{
+			"latency_percent": chaos.LatencyPercent,
+			"latency":         chaos.Latency.String(),
+			"error_percent":   chaos.ErrorPercent,
+			"error_status":    chaos.ErrorStatus,
+			"drop_percent":    chaos.DropPercent,
+			"paths":           strings.Join(chaos.Paths, ","),
+		})
+	}
+	if cfg.RequestSigning != "off" {
+		handlerService.RequestSigning = true
+		serverOpts = append(serverOpts, server.WithRequestSigning(cfg.RequestSigningWindow, cfg.RequestSigning == "required"))
+	}
+	if cfg.DPoPEnabled {
+		proofs := &dpop.Verifier{Nonces: dataStore, Window: cfg.DPoPProofWindow, PublicURL: cfg.PublicURL}
+		handlerService.DPoP = proofs
+		authService.SetProofVerifier(proofs)
+	}
+	// Used resource tokens and DPoP proofs are recorded as nonces too, so
+	// the purge runs whether or not requests are signed.
+	background.Go(func() { runNoncePurge(jobCtx, dataStore, jobs) })
 	var srv *server.Server
 	if cfg.TLSEnabled && cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
-		srv = server.NewWithTLS(":"+port, dataStore, handlerService, cfg.CORSAllowedOrigins, cfg.TLSCertFile, cfg.TLSKeyFile)
+		clientTLS, err := configureClientCerts(cfg, handlerService)
+		if err != nil {
+			log.Printf("Configuration load failed: %v", err)
+			return ExitCodeConfigError
+		}
+		srv = server.NewWithTLS(":"+port, dataStore, handlerService, cfg.CORSAllowedOrigins, cfg.TLSCertFile, cfg.TLSKeyFile, serverOpts...)
+		if clientTLS != nil {
+			srv.SetClientTLS(clientTLS)
+		}
 		logger.Info("TLS/HTTPS enabled", map[string]interface{}{
 			"cert_file": cfg.TLSCertFile,
 		})
 	} else {
-		srv = server.New(":"+port, dataStore, handlerService, cfg.CORSAllowedOrigins)
+		if cfg.TLSClientCAFile != "" {
+			log.Printf("Configuration load failed: TLS_CLIENT_CA_FILE requires TLS to be enabled")
+			return ExitCodeConfigError
+		}
+		srv = server.New(":"+port, dataStore, handlerService, cfg.CORSAllowedOrigins, serverOpts...)
 		if cfg.TLSEnabled {
 			logger.Warn("TLS enabled but certificate files not configured - falling back to HTTP")
 		}
 	}
 
+	lc.OnShutdown(lifecycle.StopAccepting, "http-server", GracefulShutdownTimeout, srv.Shutdown)
+	lc.OnShutdown(lifecycle.Drain, "background-requests", BackgroundDrainTimeout, handlerService.Drain)
+
 	// Display startup information.
 	tlsStatus := cfg.TLSEnabled && cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
 	printStartupBanner(port, storeInfo, true, tlsStatus)
+	if *dev {
+		printDevExamples(port, devUsers)
+	}
 
 	// Run server with graceful shutdown handling.
-	if err := runServerWithGracefulShutdown(srv); err != nil {
+	if err := runServerWithGracefulShutdown(srv, lc); err != nil {
 		log.Printf("Server execution failed: %v", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ExitCodeShutdownTimeout
+		}
 		return ExitCodeServerError
 	}
 
@@ -140,6 +648,32 @@ func validateConfiguration(cfg *config.Config) error {
 	if cfg.JWTSecret == "" {
 		return errors.New("JWT_SECRET is required")
 	}
+	if cfg.Environment != "development" && cfg.Environment != "production" {
+		return fmt.Errorf("ENVIRONMENT must be development or production, got %q", cfg.Environment)
+	}
+
+	// A guessable secret lets anyone forge tokens; production refuses it
+	if bits := auth.SecretEntropyBits(cfg.JWTSecret); bits < float64(cfg.JWTSecretMinBits) {
+		if cfg.Environment == "production" {
+			return fmt.Errorf("JWT_SECRET has about %.0f bits of entropy; at least %d are required in production", bits, cfg.JWTSecretMinBits)
+		}
+		logger.Warn("JWT_SECRET is weak and will be refused with ENVIRONMENT=production", map[string]interface{}{
+			"entropy_bits":  int(bits),
+			"required_bits": cfg.JWTSecretMinBits,
+		})
+	}
+
+	// Tokens signed with the previous secret are accepted too, so it is
+	// held to the same standard
+	if cfg.JWTSecretPrevious != "" {
+		if cfg.JWTSecretPrevious == cfg.JWTSecret {
+			return errors.New("JWT_SECRET_PREVIOUS must differ from JWT_SECRET")
+		}
+		if bits := auth.SecretEntropyBits(cfg.JWTSecretPrevious); bits < float64(cfg.JWTSecretMinBits) && cfg.Environment == "production" {
+			return fmt.Errorf("JWT_SECRET_PREVIOUS has about %.0f bits of entropy; at least %d are required in production", bits, cfg.JWTSecretMinBits)
+		}
+		logger.Info("Accepting tokens signed with JWT_SECRET_PREVIOUS until they expire", nil)
+	}
 
 	// Validate JWT secret strength (minimum length recommendation)
 	if len(cfg.JWTSecret) < 32 {
@@ -148,9 +682,248 @@ func validateConfiguration(cfg *config.Config) error {
 		})
 	}
 
+	switch cfg.ErrorFormat {
+	case "json", "problem":
+	default:
+		return fmt.Errorf("ERROR_FORMAT must be json or problem, got %q", cfg.ErrorFormat)
+	}
+
+	if cfg.RefreshReuseGrace < 0 || cfg.RefreshReuseGrace > handlers.MaxRefreshReuseGrace {
+		return fmt.Errorf("REFRESH_REUSE_GRACE must be between 0 and %s", handlers.MaxRefreshReuseGrace)
+	}
+	// Activity is recorded once a minute, so shorter timeouts would end
+	// sessions in use.
+	if cfg.SessionIdleTimeout != 0 && cfg.SessionIdleTimeout < handlers.MinSessionIdleTimeout {
+		return fmt.Errorf("SESSION_IDLE_TIMEOUT must be 0 or at least %s", handlers.MinSessionIdleTimeout)
+	}
+
+	if len(cfg.ClockDriftSources) > 0 {
+		if cfg.ClockDriftInterval < time.Minute {
+			return fmt.Errorf("CLOCK_DRIFT_INTERVAL must be at least 1m")
+		}
+		if cfg.ClockDriftThreshold <= 0 {
+			return fmt.Errorf("CLOCK_DRIFT_THRESHOLD must be positive")
+		}
+		if cfg.ClockDriftAutoSkew && cfg.ClockDriftMaxSkew < cfg.TokenClockSkew {
+			return fmt.Errorf("CLOCK_DRIFT_MAX_SKEW must be at least TOKEN_CLOCK_SKEW")
+		}
+	}
+
+	switch strings.ToUpper(cfg.FrameOptions) {
+	case "DENY", "SAMEORIGIN", "OFF":
+	default:
+		return fmt.Errorf("SECURITY_FRAME_OPTIONS must be DENY, SAMEORIGIN, or off")
+	}
+	if cfg.HSTSMaxAge < 0 {
+		return fmt.Errorf("SECURITY_HSTS_MAX_AGE must not be negative")
+	}
+	// Browsers only admit sites to the HSTS preload list that cover their
+	// subdomains for at least a year.
+	if cfg.HSTSPreload && (!cfg.HSTSIncludeSubdomains || cfg.HSTSMaxAge < 365*24*time.Hour) {
+		return fmt.Errorf("SECURITY_HSTS_PRELOAD requires SECURITY_HSTS_INCLUDE_SUBDOMAINS and a SECURITY_HSTS_MAX_AGE of at least 8760h")
+	}
+
+	if cfg.WellKnownCacheMaxAge < 0 {
+		return fmt.Errorf("WELL_KNOWN_CACHE_MAX_AGE must not be negative")
+	}
+
+	if cfg.TokenMaxBytes < 0 {
+		return fmt.Errorf("TOKEN_MAX_BYTES must not be negative")
+	}
+
+	switch cfg.RequestSigning {
+	case "off", "optional", "required":
+	default:
+		return fmt.Errorf("REQUEST_SIGNING must be off, optional, or required, got %q", cfg.RequestSigning)
+	}
+	if cfg.RequestSigningWindow <= 0 {
+		return fmt.Errorf("REQUEST_SIGNING_WINDOW must be positive")
+	}
+	if cfg.DPoPProofWindow <= 0 {
+		return fmt.Errorf("DPOP_PROOF_WINDOW must be positive")
+	}
+
+	switch cfg.AuditMode {
+	case "async", "sync":
+	default:
+		return fmt.Errorf("AUDIT_MODE must be async or sync, got %q", cfg.AuditMode)
+	}
+	if cfg.AuditQueueSize < 1 || cfg.AuditBatchSize < 1 {
+		return errors.New("AUDIT_QUEUE_SIZE and AUDIT_BATCH_SIZE must be positive")
+	}
+	if cfg.AuditRetentionDays < 0 {
+		return fmt.Errorf("AUDIT_RETENTION_DAYS must not be negative, got %d", cfg.AuditRetentionDays)
+	}
+	if cfg.AuditArchiveURL != "" && cfg.AuditRetentionDays == 0 {
+		return errors.New("AUDIT_ARCHIVE_URL requires AUDIT_RETENTION_DAYS")
+	}
+
+	for name, percent := range map[string]int{
+		"CHAOS_LATENCY_PERCENT": cfg.ChaosLatencyPercent,
+		"CHAOS_ERROR_PERCENT":   cfg.ChaosErrorPercent,
+		"CHAOS_DROP_PERCENT":    cfg.ChaosDropPercent,
+	} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("%s must be between 0 and 100, got %d", name, percent)
+		}
+	}
+	if cfg.ChaosLatency < 0 {
+		return errors.New("CHAOS_LATENCY must not be negative")
+	}
+	if cfg.ChaosErrorStatus < 400 || cfg.ChaosErrorStatus > 599 {
+		return fmt.Errorf("CHAOS_ERROR_STATUS must be an HTTP error status, got %d", cfg.ChaosErrorStatus)
+	}
+	if chaosConfig(cfg).Enabled() && cfg.Environment == "production" {
+		return errors.New("chaos testing (CHAOS_*_PERCENT) cannot be enabled with ENVIRONMENT=production")
+	}
+
+	if cfg.PasswordMinScore < 0 || cfg.PasswordMinScore > validation.MaxPasswordScore {
+		return fmt.Errorf("PASSWORD_MIN_SCORE must be between 0 and %d, got %d", validation.MaxPasswordScore, cfg.PasswordMinScore)
+	}
+
+	switch cfg.ElevationApproval {
+	case handlers.ElevationApprovalAdmin, handlers.ElevationApprovalSelf:
+	default:
+		return fmt.Errorf("ELEVATION_APPROVAL must be admin or self, got %q", cfg.ElevationApproval)
+	}
+	if cfg.ElevationMaxDuration < time.Minute || cfg.ElevationMaxDuration > 24*time.Hour {
+		return fmt.Errorf("ELEVATION_MAX_DURATION must be between 1m and 24h, got %s", cfg.ElevationMaxDuration)
+	}
+
 	return nil
 }
 
+// newAuth returns the token service for cfg, encrypting tokens when
+// TOKEN_ENCRYPTION_KEY is set.
+func newAuth(cfg *config.Config) (*auth.Auth, error) {
+	a := auth.New(cfg)
+	if cfg.TokenEncryptionKey == "" {
+		return a, nil
+	}
+	key, err := auth.ParseEncryptionKey(cfg.TokenEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.SetEncryptionKey(key); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// newPIICipher returns the cipher for the database's email and phone
+// columns, or nil when PII_ENCRYPTION_KEY is not set.
+func newPIICipher(cfg *config.Config) (*pii.Cipher, error) {
+	if cfg.PIIEncryptionKey == "" {
+		return nil, nil
+	}
+	key, err := pii.ParseKey(cfg.PIIEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return pii.New(key)
+}
+
+// loadFeatureFlags builds the feature flag set from FEATURE_FLAGS_FILE and
+// FEATURE_FLAGS, with overrides held in s.
+func loadFeatureFlags(cfg *config.Config, s flags.Store) (*flags.Set, error) {
+	var file map[string]flags.Rollout
+	if cfg.FeatureFlagsFile != "" {
+		var err error
+		if file, err = flags.LoadFile(cfg.FeatureFlagsFile); err != nil {
+			return nil, err
+		}
+	}
+	env, err := flags.ParseEnv(cfg.FeatureFlags)
+	if err != nil {
+		return nil, err
+	}
+	return flags.New(s, file, env), nil
+}
+
+// checkDeployment validates the deployment profile, the rate limit
+// backend, and the leader lease. Instances of a multi-instance deployment
+// share state through the database, so the profile requires one.
+func checkDeployment(cfg *config.Config) error {
+	switch cfg.DeploymentProfile {
+	case "single":
+	case "multi":
+		if cfg.DatabaseURL == "" {
+			return errors.New("DEPLOYMENT_PROFILE=multi requires DATABASE_URL")
+		}
+		if cfg.LeaderLeaseTTL < time.Second {
+			return errors.New("LEADER_LEASE_TTL must be at least 1s")
+		}
+	default:
+		return fmt.Errorf("DEPLOYMENT_PROFILE must be single or multi, got %q", cfg.DeploymentProfile)
+	}
+	switch cfg.RateLimitBackend {
+	case "memory", "store":
+	default:
+		return fmt.Errorf("RATE_LIMIT_BACKEND must be memory or store, got %q", cfg.RateLimitBackend)
+	}
+	return nil
+}
+
+// checkSSOCookieDomain verifies that browsers will accept the SSO cookie
+// from PUBLIC_URL: its host must be the cookie domain or a subdomain of it.
+func checkSSOCookieDomain(cfg *config.Config) error {
+	if cfg.SSOCookieDomain == "" || cfg.PublicURL == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.PublicURL)
+	if err != nil {
+		return fmt.Errorf("invalid PUBLIC_URL: %w", err)
+	}
+	host := strings.ToLower(u.Hostname())
+	domain := strings.ToLower(strings.TrimPrefix(cfg.SSOCookieDomain, "."))
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return fmt.Errorf("SSO_COOKIE_DOMAIN %s does not cover PUBLIC_URL host %s", cfg.SSOCookieDomain, host)
+	}
+	return nil
+}
+
+// smsConfig returns the SMS provider settings from cfg.
+func smsConfig(cfg *config.Config) sms.Config {
+	return sms.Config{
+		Provider:  cfg.SMSProvider,
+		From:      cfg.SMSFrom,
+		AccountID: cfg.SMSAccountID,
+		Secret:    cfg.SMSSecret,
+		URL:       cfg.SMSWebhookURL,
+	}
+}
+
+// buildAdminRoles parses the configured delegated admin roles, or the
+// built-in ones when none are configured.
+func buildAdminRoles(cfg *config.Config) (*rbac.Roles, error) {
+	spec := cfg.AdminRoles
+	if spec == "" {
+		spec = rbac.DefaultRoleSpec
+	}
+	return rbac.ParseRoles(spec)
+}
+
+// buildUsernamePolicy applies the configured username rules. Reserved names
+// from the environment and file replace the defaults when either is set.
+func buildUsernamePolicy(cfg *config.Config) (*validation.UsernamePolicy, error) {
+	charsets := cfg.UsernameCharsets
+	if len(charsets) == 0 {
+		charsets = validation.DefaultUsernameCharsets
+	}
+	reserved := cfg.UsernameReserved
+	if cfg.UsernameReservedFile != "" {
+		names, err := validation.LoadReservedUsernames(cfg.UsernameReservedFile)
+		if err != nil {
+			return nil, err
+		}
+		reserved = append(reserved, names...)
+	}
+	if len(cfg.UsernameReserved) == 0 && cfg.UsernameReservedFile == "" {
+		reserved = validation.DefaultReservedUsernames
+	}
+	return validation.NewUsernamePolicy(cfg.UsernameMinLength, cfg.UsernameMaxLength, charsets, reserved)
+}
+
 // resolvePort determines the HTTP server port with fallback to default.
 // Validates port is numeric and within valid range.
 func resolvePort(configuredPort string) string {
@@ -168,15 +941,484 @@ func resolvePort(configuredPort string) string {
 	return port
 }
 
+// configureClientCerts sets up mutual TLS when a client CA is configured:
+// it loads the service account mappings into h and returns the TLS settings
+// for the listener, or nil when client certificates are not used.
+func configureClientCerts(cfg *config.Config, h *handlers.Handlers) (*tls.Config, error) {
+	if cfg.TLSClientCAFile == "" {
+		return nil, nil
+	}
+	tlsConfig, err := mtls.ServerConfig(cfg.TLSClientCAFile, cfg.TLSClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := mtls.ParseAccounts(cfg.ServiceAccounts)
+	if err != nil {
+		return nil, err
+	}
+	h.ServiceAccounts = accounts
+	logger.Info("Client certificate authentication enabled", map[string]interface{}{
+		"mode":             cfg.TLSClientAuth,
+		"service_accounts": accounts.Len(),
+	})
+	return tlsConfig, nil
+}
+
+// alertWebhookID identifies the ALERT_WEBHOOK_URL endpoint in the webhook
+// delivery log.
+const alertWebhookID = "alerts"
+
+// startAlerting returns the alert manager if any notification target is
+// configured, running its threshold rules in the background. It returns nil
+// otherwise. The alert webhook is sent through dispatcher.
+func startAlerting(ctx context.Context, cfg *config.Config, dispatcher *webhooks.Dispatcher) *alerting.Manager {
+	var notifiers []alerting.Notifier
+	if cfg.AlertWebhookURL != "" {
+		notifiers = append(notifiers, &alerting.Webhook{URL: cfg.AlertWebhookURL, ID: alertWebhookID, Dispatcher: dispatcher})
+	}
+	if cfg.AlertSlackWebhookURL != "" {
+		notifiers = append(notifiers, &alerting.Slack{WebhookURL: cfg.AlertSlackWebhookURL})
+	}
+	if cfg.AlertPagerDutyRoutingKey != "" {
+		notifiers = append(notifiers, &alerting.PagerDuty{RoutingKey: cfg.AlertPagerDutyRoutingKey})
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+
+	rules := alerting.StandardRules(
+		float64(cfg.AlertFailedLoginsPerMinute),
+		float64(cfg.AlertServerErrorsPerMinute),
+		float64(cfg.AlertRegistrationsPerMinute),
+	)
+	manager := alerting.NewManager(nil, rules, notifiers, cfg.AlertEvaluationInterval, cfg.AlertCooldown)
+	if len(rules) > 0 {
+		go manager.Run(ctx)
+	}
+
+	names := make([]string, 0, len(notifiers))
+	for _, n := range notifiers {
+		names = append(names, n.Name())
+	}
+	logger.Info("Alerting enabled", map[string]interface{}{
+		"rules":     len(rules),
+		"notifiers": strings.Join(names, ","),
+	})
+	return manager
+}
+
+// publishRateLimitWarning returns a function publishing rate limit warnings
+// as ratelimit.warning webhook events.
+func publishRateLimitWarning(d *webhooks.Dispatcher) middleware.RateLimitWarnFunc {
+	return func(r *http.Request, w middleware.RateLimitWarning) {
+		ctx := context.WithoutCancel(r.Context())
+		if err := d.Publish(ctx, webhooks.Event{Type: webhooks.EventRateLimitWarning, Data: w}); err != nil {
+			logger.FromContext(ctx).Error("Failed to publish rate limit warning", map[string]interface{}{
+				"key":   w.Key,
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+// mailHealthInterval is how often the health endpoint checks the SMTP
+// server.
+const mailHealthInterval = time.Minute
+
+// guestPurgeInterval is how often expired guest accounts are deleted.
+const guestPurgeInterval = time.Hour
+
+// Background job names for leader election.
+const (
+	jobGuestPurge     = "guest-purge"
+	jobWebhookPurge   = "webhook-delivery-purge"
+	jobRateLimitPurge = "rate-limit-purge"
+	jobTarpitPurge    = "tarpit-purge"
+	jobNoncePurge     = "nonce-purge"
+	jobAnalytics      = "analytics-export"
+	jobAuditRetention = "audit-retention"
+	jobAccountExpiry  = "account-expiry"
+	jobStatusChanges  = "status-changes"
+)
+
+// runGuestPurge deletes guest accounts created more than maxAge ago, at
+// startup and then every guestPurgeInterval while this instance leads the
+// job, until ctx is canceled.
+func runGuestPurge(ctx context.Context, s store.Store, jobs *leader.Elector, maxAge time.Duration) {
+	ticker := time.NewTicker(guestPurgeInterval)
+	defer ticker.Stop()
+	for {
+		if jobs.Leads(ctx, jobGuestPurge) {
+			n, err := s.PurgeGuests(ctx, time.Now().Add(-maxAge))
+			if err != nil && ctx.Err() == nil {
+				logger.Warn("Guest purge failed", map[string]interface{}{"error": err.Error()})
+			} else if n > 0 {
+				logger.Info("Purged expired guest accounts", map[string]interface{}{"count": n})
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// accountExpiryInterval is how often expired accounts are disabled.
+const accountExpiryInterval = time.Hour
+
+// runAccountExpiry disables accounts whose expiry has passed, at startup
+// and then every accountExpiryInterval while this instance leads the job,
+// until ctx is canceled.
+func runAccountExpiry(ctx context.Context, h *handlers.Handlers, jobs *leader.Elector) {
+	ticker := time.NewTicker(accountExpiryInterval)
+	defer ticker.Stop()
+	for {
+		if jobs.Leads(ctx, jobAccountExpiry) {
+			n, err := h.DisableExpiredAccounts(ctx, time.Now())
+			if err != nil && ctx.Err() == nil {
+				logger.Warn("Account expiry failed", map[string]interface{}{"error": err.Error()})
+			}
+			if n > 0 {
+				logger.Info("Disabled expired accounts", map[string]interface{}{"count": n})
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// statusChangeInterval is how often due status changes are applied, and
+// so how late after its run_at one may be.
+const statusChangeInterval = time.Minute
+
+// runStatusChanges applies the scheduled status changes that are due, at
+// startup and then every statusChangeInterval while this instance leads
+// the job, until ctx is canceled.
+func runStatusChanges(ctx context.Context, h *handlers.Handlers, jobs *leader.Elector) {
+	ticker := time.NewTicker(statusChangeInterval)
+	defer ticker.Stop()
+	for {
+		if jobs.Leads(ctx, jobStatusChanges) {
+			n, err := h.RunStatusChanges(ctx, time.Now())
+			if err != nil && ctx.Err() == nil {
+				logger.Warn("Scheduled status changes failed", map[string]interface{}{"error": err.Error()})
+			}
+			if n > 0 {
+				logger.Info("Applied scheduled status changes", map[string]interface{}{"count": n})
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// webhookPurgeInterval is how often old webhook deliveries are deleted.
+const webhookPurgeInterval = time.Hour
+
+// runWebhookDeliveryPurge deletes webhook deliveries and dispatched outbox
+// events older than retention, at startup and then every
+// webhookPurgeInterval while this instance leads the job, until ctx is
+// canceled.
+func runWebhookDeliveryPurge(ctx context.Context, s store.Store, jobs *leader.Elector, retention time.Duration) {
+	ticker := time.NewTicker(webhookPurgeInterval)
+	defer ticker.Stop()
+	for {
+		if jobs.Leads(ctx, jobWebhookPurge) {
+			n, err := s.PurgeWebhookDeliveries(ctx, time.Now().Add(-retention))
+			if err != nil && ctx.Err() == nil {
+				logger.Warn("Webhook delivery purge failed", map[string]interface{}{"error": err.Error()})
+			} else if n > 0 {
+				logger.Info("Purged old webhook deliveries", map[string]interface{}{"count": n})
+			}
+			n, err = s.PurgeOutboxEvents(ctx, time.Now().Add(-retention))
+			if err != nil && ctx.Err() == nil {
+				logger.Warn("Webhook outbox purge failed", map[string]interface{}{"error": err.Error()})
+			} else if n > 0 {
+				logger.Info("Purged old webhook outbox events", map[string]interface{}{"count": n})
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rateLimitPurgeInterval is how often full shared rate limit buckets are
+// deleted.
+const rateLimitPurgeInterval = 5 * time.Minute
+
+// runRateLimitPurge deletes shared rate limit buckets that have refilled
+// completely, every rateLimitPurgeInterval while this instance leads the
+// job, until ctx is canceled.
+func runRateLimitPurge(ctx context.Context, s store.Store, jobs *leader.Elector) {
+	ticker := time.NewTicker(rateLimitPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !jobs.Leads(ctx, jobRateLimitPurge) {
+			continue
+		}
+		if _, err := s.PurgeRateLimits(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			logger.Warn("Shared rate limit purge failed", map[string]interface{}{"error": err.Error()})
+		}
+	}
+}
+
+// runTarpitPurge deletes shared tarpit failures older than window, every
+// rateLimitPurgeInterval while this instance leads the job, until ctx is
+// canceled.
+func runTarpitPurge(ctx context.Context, s store.Store, jobs *leader.Elector, window time.Duration) {
+	if window <= 0 {
+		window = tarpit.DefaultWindow
+	}
+	ticker := time.NewTicker(rateLimitPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !jobs.Leads(ctx, jobTarpitPurge) {
+			continue
+		}
+		if _, err := s.PurgeTarpitFailures(ctx, time.Now().Add(-window)); err != nil && ctx.Err() == nil {
+			logger.Warn("Shared tarpit purge failed", map[string]interface{}{"error": err.Error()})
+		}
+	}
+}
+
+// noncePurgeInterval is how often expired nonces are deleted.
+const noncePurgeInterval = 5 * time.Minute
+
+// runNoncePurge deletes expired request signing and resource token nonces
+// every noncePurgeInterval while this instance leads the job, until ctx is
+// canceled.
+func runNoncePurge(ctx context.Context, s store.Store, jobs *leader.Elector) {
+	ticker := time.NewTicker(noncePurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !jobs.Leads(ctx, jobNoncePurge) {
+			continue
+		}
+		if _, err := s.PurgeNonces(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			logger.Warn("Request nonce purge failed", map[string]interface{}{"error": err.Error()})
+		}
+	}
+}
+
+// analyticsExportInterval is how often the analytics job checks whether
+// the previous day has been exported.
+const analyticsExportInterval = time.Hour
+
+// buildAnalyticsSink returns the sink ANALYTICS_EXPORT_URL names, or nil
+// when it is not set. S3 sinks use the S3_* settings for everything but
+// the bucket.
+func buildAnalyticsSink(cfg *config.Config) (analytics.Sink, error) {
+	if cfg.AnalyticsExportURL == "" {
+		return nil, nil
+	}
+	return analytics.ParseSink(cfg.AnalyticsExportURL, cfg.AnalyticsExportToken, s3Options(cfg))
+}
+
+// s3Options returns the S3_* settings for buckets named by URL, which
+// supplies the bucket.
+func s3Options(cfg *config.Config) storage.S3Options {
+	return storage.S3Options{
+		Endpoint:             cfg.S3Endpoint,
+		Region:               cfg.S3Region,
+		AccessKeyID:          cfg.S3AccessKeyID,
+		SecretAccessKey:      cfg.S3SecretKey,
+		UsePathStyle:         cfg.S3UsePathStyle,
+		ServerSideEncryption: cfg.S3ServerSideEncryption,
+		SSEKMSKeyID:          cfg.S3SSEKMSKeyID,
+	}
+}
+
+// buildBackupBucket returns the bucket BACKUP_UPLOAD_URL names, or nil
+// when it is not set.
+func buildBackupBucket(cfg *config.Config) (*storage.Bucket, error) {
+	if cfg.BackupUploadURL == "" {
+		return nil, nil
+	}
+	bucket, err := storage.OpenBucket(cfg.BackupUploadURL, s3Options(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("BACKUP_UPLOAD_URL: %w", err)
+	}
+	return bucket, nil
+}
+
+// auditRetentionInterval is how often audit events past the retention
+// period are archived and deleted.
+const auditRetentionInterval = time.Hour
+
+// buildAuditRetention returns the retention policy AUDIT_RETENTION_DAYS and
+// AUDIT_ARCHIVE_URL describe, or nil when events are kept forever.
+func buildAuditRetention(cfg *config.Config, s store.Store) (*audit.Retention, error) {
+	if cfg.AuditRetentionDays <= 0 {
+		return nil, nil
+	}
+	retention := &audit.Retention{Store: s, MaxAge: time.Duration(cfg.AuditRetentionDays) * 24 * time.Hour}
+	if cfg.AuditArchiveURL != "" {
+		bucket, err := storage.OpenBucket(cfg.AuditArchiveURL, s3Options(cfg))
+		if err != nil {
+			return nil, fmt.Errorf("AUDIT_ARCHIVE_URL: %w", err)
+		}
+		retention.Archive = bucket
+	}
+	return retention, nil
+}
+
+// runAuditRetention applies retention at startup and then every
+// auditRetentionInterval while this instance leads the job, until ctx is
+// canceled. A failed run is retried at the next interval.
+func runAuditRetention(ctx context.Context, jobs *leader.Elector, retention *audit.Retention) {
+	ticker := time.NewTicker(auditRetentionInterval)
+	defer ticker.Stop()
+	for {
+		if jobs.Leads(ctx, jobAuditRetention) {
+			run, err := retention.Run(ctx, time.Now())
+			if err != nil && ctx.Err() == nil {
+				logger.Warn("Audit retention failed", map[string]interface{}{
+					"archived": run.Archived,
+					"pruned":   run.Pruned,
+					"error":    err.Error(),
+				})
+			} else if err == nil && run.Pruned > 0 {
+				logger.Info("Applied audit retention", map[string]interface{}{
+					"archived": run.Archived,
+					"pruned":   run.Pruned,
+					"objects":  run.Objects,
+				})
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runAnalyticsExport writes the previous UTC day's analytics report to
+// sink, checking at startup and then every analyticsExportInterval while
+// this instance leads the job, until ctx is canceled. A failed export is
+// retried at the next check.
+func runAnalyticsExport(ctx context.Context, s store.Store, jobs *leader.Elector, sink analytics.Sink) {
+	ticker := time.NewTicker(analyticsExportInterval)
+	defer ticker.Stop()
+	var exported time.Time
+	for {
+		day := analytics.Day(time.Now()).AddDate(0, 0, -1)
+		if !day.Equal(exported) && jobs.Leads(ctx, jobAnalytics) {
+			r, err := analytics.Export(ctx, s, sink, day)
+			if err != nil && ctx.Err() == nil {
+				logger.Warn("Analytics export failed", map[string]interface{}{
+					"date":  day.Format(analytics.DateFormat),
+					"error": err.Error(),
+				})
+			} else if err == nil {
+				exported = day
+				logger.Info("Exported analytics", map[string]interface{}{"date": r.Date, "sink": sink.Name()})
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// configureAccessLog applies the access-log format and opens its output
+// destination. The returned function closes any file it opened.
+func configureAccessLog(cfg *config.Config) (func(), error) {
+	format, err := middleware.ParseAccessLogFormat(cfg.AccessLogFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	var out io.Writer
+	closeFn := func() {}
+	switch cfg.AccessLogOutput {
+	case "":
+		if format != middleware.AccessLogJSON {
+			out = os.Stdout
+		}
+	case "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg.AccessLogOutput, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("open access log: %w", err)
+		}
+		out = f
+		closeFn = func() { _ = f.Close() }
+	}
+
+	middleware.SetAccessLog(format, out)
+	return closeFn, nil
+}
+
+// configureHTTPClients applies outbound HTTP client settings. A zero retry
+// count disables retries.
+func configureHTTPClients(cfg *config.Config) error {
+	retries := cfg.HTTPClientMaxRetries
+	if retries <= 0 {
+		retries = -1
+	}
+	return httpclient.Configure(httpclient.Options{
+		Timeout:    cfg.HTTPClientTimeout,
+		MaxRetries: retries,
+		Proxy:      cfg.HTTPClientProxy,
+	})
+}
+
 // initializeStore creates and configures the data store based on configuration.
 func initializeStore(cfg *config.Config) (store.Store, string, error) {
+	piiCipher, err := newPIICipher(cfg)
+	if err != nil {
+		return nil, "", err
+	}
 	if cfg.DatabaseURL != "" {
 		// Production mode: use SQLite persistent store.
-		sqlStore, err := store.NewSQLite(cfg.DatabaseURL)
+		sqlStore, err := store.NewSQLiteWithOptions(cfg.DatabaseURL, store.SQLiteOptions{
+			ReplicaURLs:          cfg.DatabaseReplicaURLs,
+			MaxReplicaLag:        cfg.DatabaseReplicaMaxLag,
+			ReplicaCheckInterval: cfg.DatabaseReplicaCheckInterval,
+			SlowQueryThreshold:   cfg.DatabaseSlowQueryThreshold,
+			PII:                  piiCipher,
+		})
 		if err != nil {
 			return nil, "", fmt.Errorf("SQLite initialization: %w", err)
 		}
 		storeDesc := fmt.Sprintf("SQLite (%s)", cfg.DatabaseURL)
+		if n := len(cfg.DatabaseReplicaURLs); n > 0 {
+			storeDesc = fmt.Sprintf("SQLite (%s, %d read replicas)", cfg.DatabaseURL, n)
+		}
+		if piiCipher != nil {
+			logger.Info("PII column encryption enabled")
+		}
 		return sqlStore, storeDesc, nil
 	}
 
@@ -186,8 +1428,45 @@ func initializeStore(cfg *config.Config) (store.Store, string, error) {
 	return memStore, "in-memory (development)", nil
 }
 
-// runServerWithGracefulShutdown starts the HTTP server and handles shutdown signals.
-func runServerWithGracefulShutdown(srv *server.Server) error {
+// securityHeaders returns the security headers configured in cfg.
+func securityHeaders(cfg *config.Config) middleware.SecurityHeaders {
+	h := middleware.DefaultSecurityHeaders()
+	switch {
+	case strings.EqualFold(cfg.ContentSecurityPolicy, "off"):
+		h.ContentSecurityPolicy = ""
+	case cfg.ContentSecurityPolicy != "":
+		h.ContentSecurityPolicy = cfg.ContentSecurityPolicy
+	}
+	h.CSPReportOnly = cfg.CSPReportOnly
+	h.FrameOptions = strings.ToUpper(cfg.FrameOptions)
+	if h.FrameOptions == "OFF" {
+		h.FrameOptions = ""
+	}
+	h.HSTSMaxAge = cfg.HSTSMaxAge
+	h.HSTSIncludeSubdomains = cfg.HSTSIncludeSubdomains
+	h.HSTSPreload = cfg.HSTSPreload
+	return h
+}
+
+// chaosConfig returns the faults cfg asks to inject.
+func chaosConfig(cfg *config.Config) middleware.Chaos {
+	c := middleware.Chaos{
+		LatencyPercent: cfg.ChaosLatencyPercent,
+		Latency:        cfg.ChaosLatency,
+		ErrorPercent:   cfg.ChaosErrorPercent,
+		ErrorStatus:    cfg.ChaosErrorStatus,
+		DropPercent:    cfg.ChaosDropPercent,
+		Paths:          cfg.ChaosPaths,
+	}
+	if len(c.Paths) == 0 {
+		c.Paths = []string{"/api/"}
+	}
+	return c
+}
+
+// runServerWithGracefulShutdown starts the HTTP server and, on a shutdown
+// signal, runs lc's shutdown hooks.
+func runServerWithGracefulShutdown(srv *server.Server, lc *lifecycle.Manager) error {
 	// Create context that cancels on interrupt or termination signal.
 	ctx, stop := signal.NotifyContext(
 		context.Background(),
@@ -215,15 +1494,14 @@ func runServerWithGracefulShutdown(srv *server.Server) error {
 	case <-ctx.Done():
 		logger.Info("Shutdown signal received")
 	}
+	if _, err := systemd.Notify(systemd.Stopping); err != nil {
+		logger.Warn("Stop notification failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
 
-	// Initiate graceful shutdown with timeout.
-	shutdownCtx, shutdownCancel := context.WithTimeout(
-		context.Background(),
-		GracefulShutdownTimeout,
-	)
-	defer shutdownCancel()
-
-	if err := srv.Shutdown(shutdownCtx); err != nil {
+	// Each hook is bounded by its own timeout.
+	if err := lc.Shutdown(context.Background()); err != nil {
 		return fmt.Errorf("graceful shutdown: %w", err)
 	}
 
@@ -231,6 +1509,21 @@ func runServerWithGracefulShutdown(srv *server.Server) error {
 	return nil
 }
 
+// waitGroup waits for wg, or until ctx is done.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // printConfigurationHelp displays setup instructions when configuration is invalid.
 func printConfigurationHelp(validationErr error) {
 	fmt.Fprintln(os.Stderr)
@@ -238,6 +1531,7 @@ func printConfigurationHelp(validationErr error) {
 	fmt.Fprintln(os.Stderr)
 	fmt.Fprintln(os.Stderr, "Required Configuration:")
 	fmt.Fprintln(os.Stderr, "  JWT_SECRET - Secret key for JWT token signing")
+	fmt.Fprintln(os.Stderr, "               (or JWT_SECRET_FILE - path to a file containing it)")
 	fmt.Fprintln(os.Stderr)
 	fmt.Fprintln(os.Stderr, "Optional Configuration:")
 	fmt.Fprintln(os.Stderr, "  PORT         - HTTP server port (default: 8080)")
@@ -360,6 +1654,9 @@ func printStartupBanner(port, storeInfo string, dbHealthy, tlsEnabled bool) {
 		"POST /api/auth/login    - User authentication",
 		"POST /api/auth/refresh  - Token refresh",
 		"GET  /api/auth/profile  - User profile (JWT required)",
+		"PUT  /api/auth/profile/avatar - Upload avatar (JWT required)",
+		"PATCH /api/auth/profile/metadata - Update metadata (JWT required)",
+		"GET  /api/admin/users/{id} - User details (admin)",
 		"GET  /health            - Health check",
 	}
 
@@ -0,0 +1,128 @@
+// Package sso lets applications on subdomains of a Sentinel deployment's
+// SSO cookie domain share its sign-ins. Client checks the visitor's SSO
+// cookie against Sentinel's /api/auth/sso/check endpoint, and
+// Client.Middleware puts the signed-in user in the request context.
+//
+// Apps never see Sentinel's signing key: every check is answered by
+// Sentinel, so revocations and disabled accounts take effect immediately.
+package sso
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultCookieName is the cookie Sentinel sets unless SSO_COOKIE_NAME
+// says otherwise.
+const DefaultCookieName = "sentinel_sso"
+
+// CheckPath is the Sentinel endpoint that validates SSO cookies.
+const CheckPath = "/api/auth/sso/check"
+
+// ErrNoSession is returned by Check when the request has no SSO cookie or
+// Sentinel does not accept it.
+var ErrNoSession = errors.New("sso: no valid session")
+
+// User is the signed-in user reported by Sentinel.
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// ExpiresAt is when the SSO cookie expires.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Client checks SSO cookies with a Sentinel instance.
+type Client struct {
+	// BaseURL is Sentinel's base URL, e.g. https://auth.example.com.
+	BaseURL string
+	// CookieName is the SSO cookie name; empty uses DefaultCookieName.
+	CookieName string
+	// HTTPClient sends checks; nil uses a client with a 5 second timeout.
+	HTTPClient *http.Client
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// New returns a Client for the Sentinel instance at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: baseURL}
+}
+
+func (c *Client) cookieName() string {
+	if c.CookieName == "" {
+		return DefaultCookieName
+	}
+	return c.CookieName
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return defaultHTTPClient
+	}
+	return c.HTTPClient
+}
+
+// Check returns the user signed in to the browser that sent r. Only the
+// SSO cookie is forwarded to Sentinel. It returns ErrNoSession when there
+// is no valid session and another error when Sentinel cannot be reached.
+func (c *Client) Check(ctx context.Context, r *http.Request) (*User, error) {
+	cookie, err := r.Cookie(c.cookieName())
+	if err != nil || cookie.Value == "" {
+		return nil, ErrNoSession
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(c.BaseURL, "/")+CheckPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sso: check failed: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, ErrNoSession
+	default:
+		return nil, fmt.Errorf("sso: check failed: unexpected status %d", resp.StatusCode)
+	}
+	var u User
+	if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+		return nil, fmt.Errorf("sso: invalid check response: %w", err)
+	}
+	return &u, nil
+}
+
+type contextKey struct{}
+
+// FromContext returns the user Middleware stored in ctx.
+func FromContext(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(contextKey{}).(*User)
+	return u, ok
+}
+
+// Middleware rejects requests without a valid SSO session with 401 (or
+// 502 when Sentinel cannot be reached) and otherwise passes them on with
+// the user available through FromContext.
+func (c *Client) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, err := c.Check(r.Context(), r)
+		if errors.Is(err, ErrNoSession) {
+			http.Error(w, "Sign-in required", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Sign-in service unavailable", http.StatusBadGateway)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, u)))
+	})
+}
@@ -0,0 +1,50 @@
+package sso
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	sentinel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(DefaultCookieName)
+		if r.URL.Path != CheckPath || err != nil || c.Value != "good" || len(r.Cookies()) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(User{ID: 7, Username: "ana", Role: "user"})
+	}))
+	defer sentinel.Close()
+
+	var got *User
+	h := New(sentinel.URL + "/").Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	}))
+	serve := func(cookies ...*http.Cookie) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := serve(); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a cookie, got %d", code)
+	}
+	if code := serve(&http.Cookie{Name: DefaultCookieName, Value: "bad"}); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a rejected cookie, got %d", code)
+	}
+	code := serve(&http.Cookie{Name: DefaultCookieName, Value: "good"}, &http.Cookie{Name: "app_session", Value: "private"})
+	if code != http.StatusOK || got == nil || got.ID != 7 || got.Username != "ana" {
+		t.Errorf("expected the signed-in user, got %d %+v", code, got)
+	}
+
+	sentinel.Close()
+	if code := serve(&http.Cookie{Name: DefaultCookieName, Value: "good"}); code != http.StatusBadGateway {
+		t.Errorf("expected 502 when Sentinel is unreachable, got %d", code)
+	}
+}
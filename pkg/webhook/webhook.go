@@ -0,0 +1,121 @@
+// Package webhook signs and verifies the webhook requests Sentinel sends.
+//
+// Each request carries SignatureHeader in the form
+//
+//	t=1700000000,v1=5257a8…,v1=9f86d0…
+//
+// where t is the Unix time of signing and each v1 is the hex HMAC-SHA256,
+// under one of the active signing secrets, of the timestamp, a period, and
+// the raw request body. Sentinel signs with every active secret, so a
+// consumer rotating secrets accepts a request that matches any secret it
+// knows. Requests whose timestamp is outside the tolerance are rejected,
+// so a captured request cannot be replayed later.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers set on webhook requests.
+const (
+	SignatureHeader = "X-Sentinel-Webhook-Signature"
+	// EventIDHeader identifies the event; it is the same on every
+	// redelivery, so consumers can discard duplicates.
+	EventIDHeader = "X-Sentinel-Webhook-Id"
+	// EventHeader names the event type, e.g. "alert".
+	EventHeader = "X-Sentinel-Webhook-Event"
+)
+
+// DefaultTolerance is how far a request's timestamp may be from the
+// receiver's clock, in either direction.
+const DefaultTolerance = 5 * time.Minute
+
+// MaxBodyBytes bounds the request bodies VerifyRequest reads.
+const MaxBodyBytes = 1 << 20
+
+var (
+	// ErrNoSignature is returned when the signature header is missing or
+	// malformed.
+	ErrNoSignature = errors.New("webhook: missing or malformed signature")
+	// ErrTimestamp is returned when the request was signed outside the
+	// tolerance, such as a replayed request.
+	ErrTimestamp = errors.New("webhook: timestamp outside the tolerance")
+	// ErrSignature is returned when no signature matches a known secret.
+	ErrSignature = errors.New("webhook: signature mismatch")
+)
+
+// Sign returns the SignatureHeader value for body signed at t with each of
+// secrets.
+func Sign(secrets []string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	parts := []string{"t=" + ts}
+	for _, s := range secrets {
+		parts = append(parts, "v1="+hex.EncodeToString(mac(s, ts, body)))
+	}
+	return strings.Join(parts, ",")
+}
+
+func mac(secret, ts string, body []byte) []byte {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(ts))
+	m.Write([]byte{'.'})
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+// Verify checks header, a SignatureHeader value, against body. It succeeds
+// when the timestamp is within tolerance of now and a signature matches
+// any of secrets.
+func Verify(header string, body []byte, secrets []string, tolerance time.Duration, now time.Time) error {
+	var ts string
+	var sigs [][]byte
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			if sig, err := hex.DecodeString(v); err == nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return ErrNoSignature
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return fmt.Errorf("%w: signed %s ago", ErrTimestamp, d.Round(time.Second))
+	}
+	for _, s := range secrets {
+		want := mac(s, ts, body)
+		for _, sig := range sigs {
+			if hmac.Equal(sig, want) {
+				return nil
+			}
+		}
+	}
+	return ErrSignature
+}
+
+// VerifyRequest reads r's body and verifies it with DefaultTolerance,
+// returning the body when it is authentic.
+func VerifyRequest(r *http.Request, secrets ...string) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	if err := Verify(r.Header.Get(SignatureHeader), body, secrets, DefaultTolerance, time.Now()); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"rule":"canary_tripped"}`)
+	now := time.Unix(1700000000, 0)
+	header := Sign([]string{"new-secret", "old-secret"}, now, body)
+	if !strings.HasPrefix(header, "t=1700000000,v1=") || strings.Count(header, "v1=") != 2 {
+		t.Fatalf("unexpected header %q", header)
+	}
+
+	// A consumer that knows either secret accepts the request
+	for _, secrets := range [][]string{{"old-secret"}, {"new-secret"}, {"other", "new-secret"}} {
+		if err := Verify(header, body, secrets, DefaultTolerance, now.Add(time.Minute)); err != nil {
+			t.Errorf("Verify with %v: %v", secrets, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		at     time.Time
+		want   error
+	}{
+		{"missing", "", string(body), now, ErrNoSignature},
+		{"no signatures", "t=1700000000", string(body), now, ErrNoSignature},
+		{"replayed", header, string(body), now.Add(DefaultTolerance + time.Second), ErrTimestamp},
+		{"from the future", header, string(body), now.Add(-DefaultTolerance - time.Second), ErrTimestamp},
+		{"tampered", header, `{"rule":"other"}`, now, ErrSignature},
+		{"retimed", strings.Replace(header, "t=1700000000", "t=1700000100", 1), string(body), now, ErrSignature},
+	}
+	for _, tt := range tests {
+		if err := Verify(tt.header, []byte(tt.body), []string{"new-secret"}, DefaultTolerance, tt.at); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	body := `{"rule":"failed_logins"}`
+	r := httptest.NewRequest(http.MethodPost, "/hooks/sentinel", strings.NewReader(body))
+	r.Header.Set(SignatureHeader, Sign([]string{"s3cret"}, time.Now(), []byte(body)))
+	got, err := VerifyRequest(r, "s3cret")
+	if err != nil || string(got) != body {
+		t.Fatalf("VerifyRequest = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mayvqt/Sentinel/internal/config"
+	"github.com/mayvqt/Sentinel/internal/mail"
+)
+
+// loadMailTemplates loads the email templates with cfg's overrides and
+// validates them, so a broken override fails at startup rather than when
+// the first notification is sent.
+func loadMailTemplates(cfg *config.Config) (*mail.Templates, error) {
+	templates, err := mail.LoadTemplates(cfg.MailTemplatesDir, cfg.MailAppName)
+	if err != nil {
+		return nil, err
+	}
+	failures := templates.Validate()
+	if len(failures) == 0 {
+		return templates, nil
+	}
+	names := make([]string, 0, len(failures))
+	for name := range failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("mail template %s: %v", name, failures[name])
+	}
+	return nil, fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
+
+// runTemplatesCommand implements "sentinel templates [dir]": it lists the
+// email templates with their variables and validates the overrides in dir
+// (MAIL_TEMPLATES_DIR by default), exiting with ExitCodeCheckFailed when
+// any template fails to render.
+func runTemplatesCommand(args []string) int {
+	if len(args) > 1 {
+		printUsage()
+		return ExitCodeConfigError
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration load failed: %v\n", err)
+		return ExitCodeConfigError
+	}
+	if len(args) == 1 {
+		cfg.MailTemplatesDir = args[0]
+	}
+
+	templates, err := mail.LoadTemplates(cfg.MailTemplatesDir, cfg.MailAppName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCodeCheckFailed
+	}
+	failures := templates.Validate()
+	for _, spec := range mail.Specs {
+		status := "OK"
+		if err := failures[spec.Name]; err != nil {
+			status = "ERROR: " + err.Error()
+		}
+		fmt.Printf("%s — %s\n", spec.Name, spec.Description)
+		source := "built-in"
+		if overrides := templates.Overrides(spec.Name); len(overrides) > 0 {
+			source = "overridden by " + strings.Join(overrides, ", ")
+		}
+		fmt.Printf("  source: %s\n", source)
+		fmt.Println("  variables:")
+		for _, v := range spec.AllVars() {
+			fmt.Printf("    %-15s %s\n", "{{."+v.Name+"}}", v.Description)
+		}
+		fmt.Printf("  %s\n\n", status)
+	}
+	if len(failures) > 0 {
+		fmt.Printf("%d of %d templates failed validation\n", len(failures), len(mail.Specs))
+		return ExitCodeCheckFailed
+	}
+	fmt.Printf("All %d templates are valid\n", len(mail.Specs))
+	return ExitCodeSuccess
+}